@@ -5,6 +5,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -17,22 +18,70 @@ import (
 	"sync"
 	"time"
 
+	apiversions "prokzee/internal/apiversions"
+	asof "prokzee/internal/asof"
+	backupprobe "prokzee/internal/backupprobe"
+	cacheaudit "prokzee/internal/cacheaudit"
+	captureguide "prokzee/internal/captureguide"
+	certificate "prokzee/internal/certificate"
+	changedetect "prokzee/internal/changedetect"
+	chaos "prokzee/internal/chaos"
+	clientcert "prokzee/internal/clientcert"
+	cookieanalyzer "prokzee/internal/cookieanalyzer"
+	copyformat "prokzee/internal/copyformat"
+	deserialize "prokzee/internal/deserialize"
+	diagnostics "prokzee/internal/diagnostics"
+	dnsserver "prokzee/internal/dnsserver"
+	entropy "prokzee/internal/entropy"
+	eventguard "prokzee/internal/eventguard"
+	exposure "prokzee/internal/exposure"
+	favicon "prokzee/internal/favicon"
+	favorites "prokzee/internal/favorites"
+	findings "prokzee/internal/findings"
 	fuzzer "prokzee/internal/fuzzer"
+	headeraudit "prokzee/internal/headeraudit"
 	history "prokzee/internal/history"
+	hostheader "prokzee/internal/hostheader"
+	hostinfo "prokzee/internal/hostinfo"
+	instancelock "prokzee/internal/instancelock"
+	intercept "prokzee/internal/intercept"
 	listener "prokzee/internal/listener"
 	llm "prokzee/internal/llm"
+	loadtest "prokzee/internal/loadtest"
 	logger "prokzee/internal/logger"
+	maplocal "prokzee/internal/maplocal"
 	matchreplace "prokzee/internal/matchreplace"
+	mediameta "prokzee/internal/mediameta"
+	metrics "prokzee/internal/metrics"
+	mimesniff "prokzee/internal/mimesniff"
 	models "prokzee/internal/models"
+	paramtest "prokzee/internal/paramtest"
+	pdfinspect "prokzee/internal/pdfinspect"
 	plugins "prokzee/internal/plugins"
 	projects "prokzee/internal/projects"
 	proxy "prokzee/internal/proxy"
+	racetest "prokzee/internal/racetest"
+	rawexport "prokzee/internal/rawexport"
+	replayscript "prokzee/internal/replayscript"
 	resender "prokzee/internal/resender"
 	rules "prokzee/internal/rules"
 	scope "prokzee/internal/scope"
+	secretscan "prokzee/internal/secretscan"
 	settings "prokzee/internal/settings"
+	setup "prokzee/internal/setup"
 	sitemap "prokzee/internal/sitemap"
+	snippet "prokzee/internal/snippet"
+	ssti "prokzee/internal/ssti"
 	storage "prokzee/internal/storage"
+	tagging "prokzee/internal/tagging"
+	techdetect "prokzee/internal/techdetect"
+	traversal "prokzee/internal/traversal"
+	turbo "prokzee/internal/turbo"
+	unicodebypass "prokzee/internal/unicodebypass"
+	upstream "prokzee/internal/upstream"
+	verbtamper "prokzee/internal/verbtamper"
+	wafdetect "prokzee/internal/wafdetect"
+	xxe "prokzee/internal/xxe"
 
 	"github.com/elazarl/goproxy"
 	_ "github.com/mattn/go-sqlite3"
@@ -41,38 +90,68 @@ import (
 
 // App struct. TODO: refactor this to use dependency injection
 type App struct {
-	ctx                context.Context
-	proxy              *proxy.Proxy
-	db                 *sql.DB
-	dbMutex            sync.RWMutex // Add mutex for database operations
-	rulesClient        *rules.Client
-	matchReplaceClient *matchreplace.Client
-	scopeClient        *scope.Client
-	listener           *listener.Client
-	fuzzer             *fuzzer.Fuzzer
-	resender           *resender.Resender
-	llmClient          *llm.Client
-	sitemapClient      *sitemap.Client
-	pluginsClient      *plugins.Client
-	historyClient      *history.Client
-	settingsClient     *settings.Client
-	projectsClient     *projects.Client
-	version            string
-	logger             *logger.Logger
-	requestStorage     *storage.RequestStorage
-	dbClosing          chan struct{} // Channel to signal database shutdown
-}
-
-// HandleProxyRequest handles storing of proxy requests
-func (a *App) HandleProxyRequest(req *http.Request) {
-	log.Printf("DEBUG: HandleProxyRequest called for URL: %s", req.URL.String())
+	ctx                  context.Context
+	proxy                *proxy.Proxy
+	db                   *sql.DB
+	dbMutex              sync.RWMutex // Add mutex for database operations
+	setupMutex           sync.Mutex   // serializes the first-run wizard steps
+	rulesClient          *rules.Client
+	interceptEditsClient *intercept.Client
+	matchReplaceClient   *matchreplace.Client
+	mapLocalClient       *maplocal.Client
+	chaosClient          *chaos.Client
+	tagClient            *tagging.Client
+	scopeClient          *scope.Client
+	listener             *listener.Client
+	fuzzer               *fuzzer.Fuzzer
+	resender             *resender.Resender
+	llmClient            *llm.Client
+	sitemapClient        *sitemap.Client
+	pluginsClient        *plugins.Client
+	historyClient        *history.Client
+	favoritesClient      *favorites.Client
+	findingsClient       *findings.Client
+	changeDetector       *changedetect.Client
+	hostInfoClient       *hostinfo.Client
+	clientCertsClient    *clientcert.Client
+	settingsClient       *settings.Client
+	projectsClient       *projects.Client
+	version              string
+	logger               *logger.Logger
+	requestStorage       *storage.RequestStorage
+	projectPath          string // database file of the current project
+	dnsServer            *dnsserver.Server
+	entropyCancel        context.CancelFunc
+	entropyMutex         sync.Mutex
+	turboCancel          context.CancelFunc
+	turboMutex           sync.Mutex
+	dbClosing            chan struct{} // Channel to signal database shutdown
+	metrics              *metrics.Collector
+	metricsServer        *metrics.Server
+	loadTestMutex        sync.Mutex
+	cleanupOnce          sync.Once
+	projectMu            sync.RWMutex // Held while switching the project-bound clients
+
+	// Hold of this instance on the current project, nil while another
+	// instance holds it and nothing is captured
+	projectLock *instancelock.Lock
+
+	// Checks the events of the frontend, see privilegedEvents
+	eventGuard *eventguard.Guard
+}
+
+// handleProxyRequest handles storing of proxy requests
+func (a *App) handleProxyRequest(req *http.Request) {
+	log.Printf("DEBUG: handleProxyRequest called for URL: %s", req.URL.String())
+	start := time.Now()
+	defer func() { a.metrics.ObserveHandler("request", time.Since(start)) }()
 
 	// If the request body exists, we should ensure it has a GetBody function
 	if req.Body != nil {
 		// Read the request body
 		reqBody, err := io.ReadAll(req.Body)
 		if err != nil {
-			log.Printf("ERROR: Failed to read request body in HandleProxyRequest: %v", err)
+			log.Printf("ERROR: Failed to read request body in handleProxyRequest: %v", err)
 			return
 		}
 
@@ -90,9 +169,50 @@ func (a *App) HandleProxyRequest(req *http.Request) {
 	// Do nothing else here - we'll store the request only when we get a response
 }
 
-// HandleProxyResponse handles storing of proxy responses
-func (a *App) HandleProxyResponse(req *http.Request, resp *http.Response) {
-	log.Printf("DEBUG: HandleProxyResponse called for URL: %s", req.URL.String())
+// handleProxyResponse handles storing of proxy responses. The response body is
+// not buffered: it streams to the client through a storage.BodyCapture and the
+// exchange is stored once the client has read it.
+func (a *App) handleProxyResponse(req *http.Request, resp *http.Response) {
+	log.Printf("DEBUG: handleProxyResponse called for URL: %s", req.URL.String())
+	start := time.Now()
+	defer func() { a.metrics.ObserveHandler("response", time.Since(start)) }()
+
+	// Store into the project that is current when the response arrives
+	a.projectMu.RLock()
+	scopeClient, requestStorage, changeDetector, findingsClient := a.scopeClient, a.requestStorage, a.changeDetector, a.findingsClient
+	a.projectMu.RUnlock()
+
+	// Never store capture self-test requests
+	if req.Header.Get(models.SelfTestHeader) != "" {
+		return
+	}
+
+	// Skip storage entirely while recording is paused
+	if !a.proxy.GetRecordingState() {
+		log.Printf("DEBUG: Recording paused, not storing request: %s", req.URL.String())
+		return
+	}
+
+	// Apply the per-scope sampling policy, errors are always stored
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	}
+	if !scopeClient.ShouldStore(req.Host, statusCode) {
+		log.Printf("DEBUG: Request skipped by sampling policy: %s", req.URL.String())
+		return
+	}
+
+	// Only store if we have both request and response
+	if resp == nil {
+		return
+	}
+
+	// Skip storing requests to prokzee hostname
+	if strings.HasPrefix(strings.ToLower(req.Host), "prokzee") || strings.HasPrefix(strings.ToLower(req.Host), "wails.localhost") {
+		log.Printf("DEBUG: Skipping storage of prokzee and wails.localhost request: %s", req.URL.String())
+		return
+	}
 
 	// Clone the request body if it exists
 	var reqBody []byte
@@ -106,30 +226,6 @@ func (a *App) HandleProxyResponse(req *http.Request, resp *http.Response) {
 		req.Body = io.NopCloser(bytes.NewBuffer(reqBody))
 	}
 
-	// Clone and read the response body if it exists, handling all transfer encodings
-	var respBody []byte
-	if resp != nil && resp.Body != nil {
-		var err error
-		// Read the entire response body regardless of transfer encoding
-		respBody, err = io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("ERROR: Failed to read response body: %v", err)
-		} else {
-			// Close the original body
-			resp.Body.Close()
-
-			// Create a new body for downstream handlers
-			resp.Body = io.NopCloser(bytes.NewBuffer(respBody))
-
-			// Update Content-Length if it was chunked or unknown
-			if resp.ContentLength == -1 {
-				resp.ContentLength = int64(len(respBody))
-				// Add or update Content-Length header
-				resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(respBody)))
-			}
-		}
-	}
-
 	// Create cloned request and response objects for storage
 	reqClone := *req
 	if reqBody != nil {
@@ -145,62 +241,206 @@ func (a *App) HandleProxyResponse(req *http.Request, resp *http.Response) {
 		reqClone.Header.Set("Host", req.Host)
 	}
 
-	var respClone *http.Response
-	if resp != nil {
-		respClone = new(http.Response)
-		*respClone = *resp
-		if respBody != nil {
-			respClone.Body = io.NopCloser(bytes.NewBuffer(respBody))
-			// Ensure ContentLength is set correctly in the clone
-			respClone.ContentLength = int64(len(respBody))
-		}
+	respClone := new(http.Response)
+	*respClone = *resp
+	respClone.Body = http.NoBody
 
-		// Clone headers to avoid concurrent map access
-		respClone.Header = make(http.Header)
-		for k, v := range resp.Header {
-			respClone.Header[k] = v
-		}
+	// Clone headers to avoid concurrent map access
+	respClone.Header = make(http.Header)
+	for k, v := range resp.Header {
+		respClone.Header[k] = v
 	}
 
-	// Only store if we have both request and response
-	if respClone != nil {
-		// Skip storing requests to prokzee hostname
-		if strings.HasPrefix(strings.ToLower(reqClone.Host), "prokzee") || strings.HasPrefix(strings.ToLower(reqClone.Host), "wails.localhost") {
-			log.Printf("DEBUG: Skipping storage of prokzee and wails.localhost request: %s", req.URL.String())
-			return
-		}
+	if resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+
+	// Streams may never end: store the exchange as soon as they start and
+	// record their events as they arrive
+	if storage.IsStreaming(resp.Header) {
+		a.recordStream(requestStorage, &reqClone, respClone, resp)
+		return
+	}
 
-		go func() {
-			if _, _, err := a.requestStorage.StoreRequest(&reqClone, respClone); err != nil {
+	// Record the body while the client reads it and store the exchange after
+	resp.Body = requestStorage.CaptureBody(&reqClone, resp.Body, func(capture *storage.BodyCapture) {
+		if !capture.Complete() {
+			log.Printf("DEBUG: Response closed after %d bytes, storing what was read: %s", capture.Size(), req.URL.String())
+		}
+		// The trailers are only known once the body was read
+		respClone.Trailer = resp.Trailer
+
+		stored := requestStorage.Async(func() {
+			writeStart := time.Now()
+			_, requestID, err := requestStorage.StoreCapturedRequest(&reqClone, respClone, capture)
+			a.metrics.ObserveDBWrite(time.Since(writeStart), err)
+			if err != nil {
 				if strings.Contains(err.Error(), "database is closed") {
 					log.Printf("WARN: Database is closed, skipping response storage")
 					return
 				}
 				log.Printf("ERROR: Failed to store response: %v", err)
-			} else {
-				log.Printf("DEBUG: Successfully stored response for URL: %s", req.URL.String())
+				return
 			}
-		}()
+			log.Printf("DEBUG: Successfully stored response for URL: %s", req.URL.String())
+
+			// Nothing is read from the bodies of do-not-log hosts
+			if requestStorage.IsDoNotLog(reqClone.URL.Hostname(), reqClone.URL.Path) {
+				return
+			}
+
+			// Bodies over the size limit are only inspected up to it, decoded
+			// like the stored body
+			contentEncoding := respClone.Header.Get("Content-Encoding")
+			respBody := capture.Content(contentEncoding)
+			sniffEncoding := contentEncoding
+			if capture.Decoded() {
+				sniffEncoding = ""
+			}
+
+			// Flag bodies whose content contradicts their Content-Type
+			if sniffed := mimesniff.Check(respClone.Header.Get("Content-Type"), sniffEncoding, respBody); sniffed.Mismatch {
+				err := findingsClient.AddFinding(findings.Finding{
+					Source:    "mimesniff",
+					Host:      reqClone.URL.Hostname(),
+					URL:       reqClone.URL.String(),
+					RequestID: requestID,
+					Title:     fmt.Sprintf("Content-Type mismatch: %s served as %s", sniffed.Sniffed, sniffed.Declared),
+					Severity:  findings.SeverityInfo,
+					Detail:    sniffed.Detail(),
+					Key:       reqClone.URL.Hostname() + reqClone.URL.Path + "|" + sniffed.Declared + "|" + sniffed.Sniffed,
+				})
+				if err != nil {
+					log.Printf("ERROR: Failed to store MIME mismatch finding: %v", err)
+				}
+			}
+
+			// Flag images that give away where and with what they were taken
+			if media := mediameta.Extract(respBody); media.Sensitive() {
+				err := findingsClient.AddFinding(findings.Finding{
+					Source:    "mediameta",
+					Host:      reqClone.URL.Hostname(),
+					URL:       reqClone.URL.String(),
+					RequestID: requestID,
+					Title:     "Image metadata reveals location or device details",
+					Severity:  findings.SeverityLow,
+					Detail:    media.Summary(),
+					Key:       reqClone.URL.String(),
+				})
+				if err != nil {
+					log.Printf("ERROR: Failed to store image metadata finding: %v", err)
+				}
+			}
+
+			// Flag downloaded archives and documents carrying macros or other
+			// active content
+			if archive := requestStorage.InspectArchive(contentEncoding, respBody, capture.BlobName()); archive.Risky() {
+				severity := findings.SeverityLow
+				if archive.Macros {
+					severity = findings.SeverityMedium
+				}
+				err := findingsClient.AddFinding(findings.Finding{
+					Source:    "docinspect",
+					Host:      reqClone.URL.Hostname(),
+					URL:       reqClone.URL.String(),
+					RequestID: requestID,
+					Title:     fmt.Sprintf("Downloaded %s contains active content", archive.Format),
+					Severity:  severity,
+					Detail:    archive.Summary(),
+					Key:       reqClone.URL.String(),
+				})
+				if err != nil {
+					log.Printf("ERROR: Failed to store archive inspection finding: %v", err)
+				}
+			}
+
+			// Record who produced PDF documents and whether they run scripts
+			if pdf := pdfinspect.Inspect(respBody); pdf.Active() || pdf.HasMetadata() {
+				title := "PDF document metadata"
+				if pdf.Active() {
+					title = "PDF document contains JavaScript or launch actions"
+				}
+				err := findingsClient.AddFinding(findings.Finding{
+					Source:    "pdfinspect",
+					Host:      reqClone.URL.Hostname(),
+					URL:       reqClone.URL.String(),
+					RequestID: requestID,
+					Title:     title,
+					Severity:  findings.SeverityInfo,
+					Detail:    pdf.Summary(),
+					Key:       reqClone.URL.String(),
+				})
+				if err != nil {
+					log.Printf("ERROR: Failed to store PDF inspection finding: %v", err)
+				}
+			}
+
+			// Flag stable endpoints whose content suddenly changed
+			change, err := changeDetector.Observe(requestID, reqClone.Method, reqClone.URL.Hostname(), reqClone.URL.Path, statusCode, respClone.Header.Get("Content-Type"), respBody)
+			if err != nil {
+				log.Printf("ERROR: Failed to track endpoint content: %v", err)
+				return
+			}
+			if change != nil {
+				a.logger.LogMessage("warning", fmt.Sprintf("Content of %s changed after %d identical responses", change.Endpoint, change.StableCount), "ChangeDetection")
+				wailsRuntime.EventsEmit(a.ctx, "backend:contentChanged", change)
+			}
+		})
+		if !stored {
+			log.Printf("WARN: Storage is shutting down, not storing request: %s", req.URL.String())
+		}
+	})
+}
+
+// recordStream stores a streamed response when it starts, records its events
+// while the client reads them and stores the body once the stream ends
+func (a *App) recordStream(requestStorage *storage.RequestStorage, reqClone *http.Request, respClone, resp *http.Response) {
+	_, requestID, err := requestStorage.StoreRequest(reqClone, respClone)
+	if err != nil {
+		log.Printf("ERROR: Failed to store streamed response: %v", err)
+		return
 	}
+	redacted := requestStorage.IsDoNotLog(reqClone.URL.Hostname(), reqClone.URL.Path)
+
+	recorder := storage.NewStreamRecorder(resp.Body, resp.Header.Get("Content-Type"), func(event storage.StreamEvent) {
+		event.RequestID = requestID
+		if redacted {
+			event.Data = ""
+		}
+		requestStorage.Async(func() {
+			if err := requestStorage.StoreStreamEvent(event); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		})
+		wailsRuntime.EventsEmit(a.ctx, "backend:streamEvent", event)
+	})
+	resp.Body = requestStorage.CaptureBody(reqClone, recorder, func(capture *storage.BodyCapture) {
+		respClone.Trailer = resp.Trailer
+		respClone.Request = reqClone
+		requestStorage.Async(func() {
+			if err := requestStorage.UpdateStreamBody(requestID, respClone, capture); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		})
+	})
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	// Get the appropriate config directory for the current OS
-	configDir, err := os.UserConfigDir()
-	if err != nil {
-		log.Printf("Error getting user config directory: %v, falling back to home directory", err)
-		homeDir, homeDirErr := os.UserHomeDir()
-		if homeDirErr != nil {
-			log.Printf("Error getting user home directory: %v, using current directory", homeDirErr)
-			configDir = "."
-		} else {
-			configDir = homeDir
+	// Seed new projects with the defaults of the config file and environment
+	defaults, err := setup.LoadDefaults()
+	if err != nil {
+		log.Printf("Ignoring config file and environment defaults: %v", err)
+	} else {
+		if defaults.Source != "" {
+			log.Printf("Using defaults from config file: %s", defaults.Source)
 		}
+		settings.SetSeed(defaults.Seed())
 	}
-	// Create a dedicated app data directory
-	appDataDir := filepath.Join(configDir, "ProKZee")
-	projectsDir := filepath.Join(appDataDir, "projects")
+
+	// Use the data directory chosen in the first-run wizard, or the default one
+	dataDir := setup.DataDir()
+	projectsDir := setup.ProjectsDir(dataDir)
 
 	// Create the necessary directories with proper permissions
 	if err := os.MkdirAll(projectsDir, 0755); err != nil {
@@ -213,7 +453,7 @@ func NewApp() *App {
 	}
 
 	// Use a database in the app data directory
-	dbPath := filepath.Join(projectsDir, "default_project.db")
+	dbPath := filepath.Join(projectsDir, setup.DefaultProject)
 	log.Printf("Using database path: %s", dbPath)
 
 	// Initialize SQLite database
@@ -231,16 +471,34 @@ func NewApp() *App {
 		proxy:     proxy.NewProxy(),
 		db:        db,
 		version:   "0.0.1",
+		dnsServer: dnsserver.NewServer(),
 		dbClosing: make(chan struct{}),
+		metrics:   metrics.NewCollector(),
+	}
+	app.metricsServer = metrics.NewServer(app.metrics)
+	app.eventGuard = eventguard.New(privilegedEvents)
+	app.proxy.CertManager.SetDir(setup.CertsDir(dataDir))
+	app.projectPath = dbPath
+
+	// Another instance on the same project is reported once the frontend is up
+	if lock, err := instancelock.Acquire(dbPath, false); err != nil {
+		log.Printf("Warning: %v", err)
+	} else {
+		app.projectLock = lock
 	}
 
 	app.requestStorage = storage.NewRequestStorage(db, &app.dbMutex)
+	if err := app.requestStorage.EnsureTableExists(); err != nil {
+		log.Fatalf("Failed to initialize requests table: %v", err)
+	}
+	app.requestStorage.SetBlobDir(storage.BlobDirFor(dbPath))
 
 	// Initialize history client
 	historyClient, err := history.NewClient(db)
 	if err != nil {
 		log.Fatalf("Failed to initialize history client: %v", err)
 	}
+	historyClient.SetBlobDir(storage.BlobDirFor(dbPath))
 	app.historyClient = historyClient
 
 	// Initialize plugins client
@@ -257,6 +515,14 @@ func NewApp() *App {
 	}
 	app.rulesClient = rulesClient
 
+	// Initialize intercept edit history client
+	interceptEditsClient, err := intercept.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize intercept edits client: %v", err)
+	}
+	interceptEditsClient.SetDoNotLog(app.requestStorage.IsDoNotLog)
+	app.interceptEditsClient = interceptEditsClient
+
 	// Initialize match replace client
 	matchReplaceClient, err := matchreplace.NewClient(db)
 	if err != nil {
@@ -264,6 +530,28 @@ func NewApp() *App {
 	}
 	app.matchReplaceClient = matchReplaceClient
 
+	// Initialize map local client
+	mapLocalClient, err := maplocal.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize map local client: %v", err)
+	}
+	app.mapLocalClient = mapLocalClient
+
+	// Initialize chaos client
+	chaosClient, err := chaos.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize chaos client: %v", err)
+	}
+	app.chaosClient = chaosClient
+
+	// Initialize tagging client
+	tagClient, err := tagging.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize tagging client: %v", err)
+	}
+	app.tagClient = tagClient
+	app.requestStorage.SetTagger(tagClient)
+
 	// Initialize scope client
 	scopeClient, err := scope.NewClient(db)
 	if err != nil {
@@ -285,6 +573,41 @@ func NewApp() *App {
 	}
 	app.settingsClient = settingsClient
 
+	// Initialize favorites client
+	favoritesClient, err := favorites.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize favorites client: %v", err)
+	}
+	app.favoritesClient = favoritesClient
+
+	// Initialize findings client
+	findingsClient, err := findings.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize findings client: %v", err)
+	}
+	app.findingsClient = findingsClient
+
+	// Initialize change detection client
+	changeDetector, err := changedetect.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize change detection client: %v", err)
+	}
+	app.changeDetector = changeDetector
+
+	// Initialize host info client
+	hostInfoClient, err := hostinfo.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize host info client: %v", err)
+	}
+	app.hostInfoClient = hostInfoClient
+
+	// Initialize client certificates client
+	clientCertsClient, err := clientcert.NewClient(db)
+	if err != nil {
+		log.Fatalf("Failed to initialize client certificates client: %v", err)
+	}
+	app.clientCertsClient = clientCertsClient
+
 	// Initialize projects client with context.TODO() as a placeholder
 	app.projectsClient = projects.NewClient(context.TODO(), db, &app.dbMutex)
 
@@ -294,20 +617,94 @@ func NewApp() *App {
 // EventHandler represents a function that handles frontend events
 type EventHandler func(data ...interface{})
 
+// privilegedEvents reach outside the project or change what the proxy does
+// with traffic: they read or write files, change certificates, settings,
+// plugins or the rules applied to traffic, act on held requests, run servers
+// or switch projects. They only run when sent by the main frame of the
+// window, never by a plugin or a page rendered in a frame of the webview.
+var privilegedEvents = []string{
+	"frontend:exportReplayScript",
+	"frontend:exportRawEntry",
+	"frontend:copyToClipboard",
+	"frontend:addMapLocalRule",
+	"frontend:updateMapLocalRule",
+	"frontend:importCollection",
+	"frontend:exportCollection",
+	"frontend:savePlugin",
+	"frontend:updatePlugin",
+	"frontend:confirmPlugin",
+	"frontend:deletePlugin",
+	"frontend:updateSettings",
+	"frontend:addTLSPassthroughHost",
+	"frontend:addClientCertificate",
+	"frontend:deleteClientCertificate",
+	"frontend:regenerateCA",
+	"frontend:exportCA",
+	"frontend:installCertificate",
+	"frontend:mintCertificate",
+	"frontend:startDNSServer",
+	"frontend:stopDNSServer",
+	"frontend:switchProject",
+	"frontend:createNewProject",
+	"frontend:takeOverProject",
+	"frontend:exportDiagnostics",
+	"frontend:setMetricsServer",
+	"frontend:addRule",
+	"frontend:deleteRule",
+	"frontend:autoForward",
+	"frontend:deleteAutoForwardRule",
+	"frontend:addMatchReplaceRule",
+	"frontend:updateMatchReplaceRule",
+	"frontend:deleteMatchReplaceRule",
+	"frontend:deleteMapLocalRule",
+	"frontend:mapLocalFromHistory",
+	"frontend:addChaosRule",
+	"frontend:updateChaosRule",
+	"frontend:deleteChaosRule",
+	"frontend:addTagRule",
+	"frontend:updateTagRule",
+	"frontend:deleteTagRule",
+	"frontend:updateInScopeList",
+	"frontend:updateOutOfScopeList",
+	"frontend:addToInScope",
+	"frontend:addToOutOfScope",
+	"frontend:setSamplingPolicy",
+	"frontend:toggleInterception",
+	"frontend:setInterceptFilters",
+	"frontend:forwardHeld",
+	"frontend:dropHeld",
+	"frontend:forwardAll",
+	"frontend:dropAll",
+	"frontend:forwardAllPending",
+	"frontend:dropAllPending",
+	"frontend:addDoNotLog",
+	"frontend:deleteDoNotLog",
+	"frontend:toggleRecording",
+}
+
 // registerEventHandlers sets up all frontend event handlers
 func (a *App) registerEventHandlers() {
 	// Map of event names to their handlers
 	handlers := map[string]EventHandler{
 		// Request related handlers
-		"frontend:getAllRequests":        a.GetAllRequests,
+		"frontend:getAllRequests":        a.getAllRequests,
 		"frontend:getRequestByID":        a.getRequestByID,
+		"frontend:getStreamEvents":       a.getStreamEvents,
+		"frontend:getBodyHex":            a.getBodyHex,
 		"frontend:getRequestsByEndpoint": a.getRequestsByEndpoint,
 		"frontend:getRequestsByDomain":   a.getRequestsByDomain,
+		"frontend:exportReplayScript":    a.exportReplayScript,
+		"frontend:exportRawEntry":        a.exportRawEntry,
+		"frontend:exportRequestAs":       a.exportRequestAs,
+		"frontend:copyToClipboard":       a.copyToClipboard,
 
 		// Rules handlers
-		"frontend:getAllRules": a.getAllRules,
-		"frontend:addRule":     a.addRule,
-		"frontend:deleteRule":  a.deleteRule,
+		"frontend:getAllRules":           a.getAllRules,
+		"frontend:addRule":               a.addRule,
+		"frontend:deleteRule":            a.deleteRule,
+		"frontend:autoForward":           a.autoForward,
+		"frontend:getAutoForwardRules":   a.getAutoForwardRules,
+		"frontend:deleteAutoForwardRule": a.deleteAutoForwardRule,
 		//"frontend:updateRule":  a.updateRule,
 
 		// Match/Replace rules handlers
@@ -315,16 +712,47 @@ func (a *App) registerEventHandlers() {
 		"frontend:addMatchReplaceRule":     a.addMatchReplaceRule,
 		"frontend:deleteMatchReplaceRule":  a.deleteMatchReplaceRule,
 		"frontend:updateMatchReplaceRule":  a.updateMatchReplaceRule,
+		"frontend:getAllMapLocalRules":     a.getAllMapLocalRules,
+		"frontend:addMapLocalRule":         a.addMapLocalRule,
+		"frontend:updateMapLocalRule":      a.updateMapLocalRule,
+		"frontend:deleteMapLocalRule":      a.deleteMapLocalRule,
+		"frontend:mapLocalFromHistory":     a.mapLocalFromHistory,
+		"frontend:getAllChaosRules":        a.getAllChaosRules,
+		"frontend:addChaosRule":            a.addChaosRule,
+		"frontend:updateChaosRule":         a.updateChaosRule,
+		"frontend:deleteChaosRule":         a.deleteChaosRule,
+		"frontend:getAllTagRules":          a.getAllTagRules,
+		"frontend:addTagRule":              a.addTagRule,
+		"frontend:updateTagRule":           a.updateTagRule,
+		"frontend:deleteTagRule":           a.deleteTagRule,
+		"frontend:getHistoryTags":          a.getHistoryTags,
 
 		// Resender handlers
-		"frontend:createNewResenderTab":  a.handleCreateNewResenderTab,
-		"frontend:sendToResender":        a.handleSendToResender,
-		"frontend:getResenderTabs":       a.handleGetResenderTabs,
-		"frontend:updateResenderTabName": a.handleUpdateResenderTabName,
-		"frontend:sendResenderRequest":   a.handleSendResenderRequest,
-		"frontend:cancelResenderRequest": a.handleCancelResenderRequest,
-		"frontend:getResenderRequest":    a.handleGetResenderRequest,
-		"frontend:deleteResenderTab":     a.handleDeleteResenderTab,
+		"frontend:createNewResenderTab":       a.handleCreateNewResenderTab,
+		"frontend:sendToResender":             a.handleSendToResender,
+		"frontend:getResenderTabs":            a.handleGetResenderTabs,
+		"frontend:updateResenderTabName":      a.handleUpdateResenderTabName,
+		"frontend:sendResenderRequest":        a.handleSendResenderRequest,
+		"frontend:cancelResenderRequest":      a.handleCancelResenderRequest,
+		"frontend:getDeserializationPayloads": a.handleGetDeserializationPayloads,
+		"frontend:runDeserializationProbe":    a.handleRunDeserializationProbe,
+		"frontend:getResenderRequest":         a.handleGetResenderRequest,
+		"frontend:deleteResenderTab":          a.handleDeleteResenderTab,
+		"frontend:setResenderTabGroup":        a.handleSetResenderTabGroup,
+		"frontend:setResenderTabRedirects":    a.handleSetResenderTabRedirects,
+		"frontend:getResenderTimings":         a.handleGetResenderTimings,
+		"frontend:diffResenderResponses":      a.handleDiffResenderResponses,
+		"frontend:getResenderVariables":       a.handleGetResenderVariables,
+		"frontend:setResenderVariable":        a.handleSetResenderVariable,
+		"frontend:deleteResenderVariable":     a.handleDeleteResenderVariable,
+		"frontend:getResenderGroups":          a.handleGetResenderGroups,
+		"frontend:saveResenderGroup":          a.handleSaveResenderGroup,
+		"frontend:deleteResenderGroup":        a.handleDeleteResenderGroup,
+		"frontend:sendResenderGroup":          a.handleSendResenderGroup,
+		"frontend:cancelResenderGroup":        a.handleCancelResenderGroup,
+		"frontend:importCollection":           a.importCollection,
+		"frontend:importCurl":                 a.importCurl,
+		"frontend:exportCollection":           a.exportCollection,
 
 		// Scope handlers
 		"frontend:updateInScopeList":    a.updateInScopeList,
@@ -332,16 +760,30 @@ func (a *App) registerEventHandlers() {
 		"frontend:addToOutOfScope":      a.addToOutOfScope,
 		"frontend:addToInScope":         a.addToInScope,
 		"frontend:getScopeLists":        a.getScopeLists,
+		"frontend:getSamplingPolicies":  a.getSamplingPolicies,
+		"frontend:setSamplingPolicy":    a.setSamplingPolicy,
 
 		// Fuzzer handlers
-		"frontend:startFuzzer":         a.startFuzzer,
-		"frontend:stopFuzzer":          a.stopFuzzer,
-		"frontend:sendToFuzzer":        a.handleSendToFuzzer,
-		"frontend:addFuzzerTab":        a.addFuzzerTab,
-		"frontend:removeFuzzerTab":     a.removeFuzzerTab,
-		"frontend:updateFuzzerTab":     a.updateFuzzerTab,
-		"frontend:getFuzzerTabs":       a.getFuzzerTabs,
-		"frontend:updateFuzzerTabName": a.updateFuzzerTabName,
+		"frontend:startFuzzer":                a.startFuzzer,
+		"frontend:stopFuzzer":                 a.stopFuzzer,
+		"frontend:pauseFuzzer":                a.pauseFuzzer,
+		"frontend:getFuzzerRuns":              a.getFuzzerRuns,
+		"frontend:estimateFuzzerPayloads":     a.estimateFuzzerPayloads,
+		"frontend:transformUnicode":           a.transformUnicode,
+		"frontend:getFuzzerResultDetail":      a.getFuzzerResultDetail,
+		"frontend:sendFuzzerResultToResender": a.sendFuzzerResultToResender,
+		"frontend:resumeFuzzer":               a.resumeFuzzer,
+		"frontend:getFuzzerCheckpoint":        a.getFuzzerCheckpoint,
+		"frontend:sendToFuzzer":               a.handleSendToFuzzer,
+		"frontend:addFuzzerTab":               a.addFuzzerTab,
+		"frontend:removeFuzzerTab":            a.removeFuzzerTab,
+		"frontend:updateFuzzerTab":            a.updateFuzzerTab,
+		"frontend:getFuzzerTabs":              a.getFuzzerTabs,
+		"frontend:updateFuzzerTabName":        a.updateFuzzerTabName,
+
+		// Turbo handlers
+		"frontend:startTurbo": a.startTurbo,
+		"frontend:stopTurbo":  a.stopTurbo,
 
 		// Chat handlers
 		"frontend:createChatContext":   a.createChatContext,
@@ -351,48 +793,134 @@ func (a *App) registerEventHandlers() {
 		"frontend:editChatContextName": a.editChatContextName,
 
 		// Plugin handlers
-		"frontend:loadPlugins":  a.loadPluginsFromDB,
-		"frontend:savePlugin":   a.savePlugin,
-		"frontend:updatePlugin": a.updatePlugin,
-		"frontend:deletePlugin": a.deletePlugin,
+		"frontend:loadPlugins":   a.loadPluginsFromDB,
+		"frontend:savePlugin":    a.savePlugin,
+		"frontend:updatePlugin":  a.updatePlugin,
+		"frontend:confirmPlugin": a.confirmPlugin,
+		"frontend:deletePlugin":  a.deletePlugin,
 
 		// Settings and system handlers
-		"frontend:fetchSettings":  a.FetchSettings,
-		"frontend:updateSettings": a.UpdateSettings,
-		//"frontend:getStats":             a.GetStats,
-		"frontend:getLogs":              a.GetRecentLogs,
-		"frontend:toggleInterception":   a.toggleInterception,
-		"frontend:getInterceptionState": a.getInterceptionState,
-		"frontend:getInteractshHost":    a.listener.GetInteractshHost,
-		"frontend:getCurrentVersion":    a.GetCurrentVersion,
-		"frontend:checkForUpdates":      a.CheckForUpdates,
+		"frontend:fetchSettings":           a.fetchSettings,
+		"frontend:updateSettings":          a.updateSettings,
+		"frontend:addTLSPassthroughHost":   a.addTLSPassthroughHost,
+		"frontend:getClientCertificates":   a.getClientCertificates,
+		"frontend:addClientCertificate":    a.addClientCertificate,
+		"frontend:deleteClientCertificate": a.deleteClientCertificate,
+		"frontend:getTLSProfiles":          a.getTLSProfiles,
+		"frontend:getCAInfo":               a.getCAInfo,
+		"frontend:regenerateCA":            a.regenerateCA,
+		"frontend:exportCA":                a.exportCA,
+		"frontend:installCertificate":      a.installCertificate,
+		"frontend:mintCertificate":         a.mintCertificate,
+		// Proxy activity, also sent every few seconds as backend:proxyStats
+		"frontend:getStats":              a.getStats,
+		"frontend:getLogs":               a.getRecentLogs,
+		"frontend:toggleInterception":    a.toggleInterception,
+		"frontend:getInterceptionState":  a.getInterceptionState,
+		"frontend:getInterceptFilters":   a.getInterceptFilters,
+		"frontend:setInterceptFilters":   a.setInterceptFilters,
+		"frontend:getInterceptQueue":     a.getInterceptQueue,
+		"frontend:forwardHeld":           a.forwardHeld,
+		"frontend:dropHeld":              a.dropHeld,
+		"frontend:forwardAll":            a.forwardAll,
+		"frontend:dropAll":               a.dropAll,
+		"frontend:forwardAllPending":     a.forwardAll,
+		"frontend:dropAllPending":        a.dropAll,
+		"frontend:reorderInterceptQueue": a.reorderInterceptQueue,
+		"frontend:getInterceptEdits":     a.getInterceptEdits,
+		"frontend:deleteInterceptEdit":   a.deleteInterceptEdit,
+		"frontend:getDoNotLog":           a.getDoNotLog,
+		"frontend:addDoNotLog":           a.addDoNotLog,
+		"frontend:deleteDoNotLog":        a.deleteDoNotLog,
+		"frontend:toggleRecording":       a.toggleRecording,
+		"frontend:getRecordingState":     a.getRecordingState,
+		"frontend:toggleHTTP3":           a.toggleHTTP3,
+		"frontend:getHTTP3State":         a.getHTTP3State,
+		"frontend:startDNSServer":        a.startDNSServer,
+		"frontend:stopDNSServer":         a.stopDNSServer,
+		"frontend:getDNSServerState":     a.getDNSServerState,
+		"frontend:getInteractshHost":     a.listener.GetInteractshHost,
+		"frontend:getCurrentVersion":     a.getCurrentVersion,
+		"frontend:checkForUpdates":       a.checkForUpdates,
+
+		// Favorites handlers
+		"frontend:getFavorites":        a.getFavorites,
+		"frontend:addFavorite":         a.addFavorite,
+		"frontend:updateFavoriteLabel": a.updateFavoriteLabel,
+		"frontend:removeFavorite":      a.removeFavorite,
+		"frontend:reorderFavorites":    a.reorderFavorites,
+
+		// Findings handlers
+		"frontend:getFindings":           a.getFindings,
+		"frontend:deleteFinding":         a.deleteFinding,
+		"frontend:runCookieAnalysis":     a.runCookieAnalysis,
+		"frontend:startEntropyAnalysis":  a.startEntropyAnalysis,
+		"frontend:stopEntropyAnalysis":   a.stopEntropyAnalysis,
+		"frontend:runCacheAudit":         a.runCacheAudit,
+		"frontend:runCacheProbe":         a.runCacheProbe,
+		"frontend:runHostHeaderProbe":    a.runHostHeaderProbe,
+		"frontend:runParamPollution":     a.runParamPollution,
+		"frontend:runVerbTampering":      a.runVerbTampering,
+		"frontend:runRaceTest":           a.runRaceTest,
+		"frontend:runAPIVersionExplorer": a.runAPIVersionExplorer,
+		"frontend:runPathTraversal":      a.runPathTraversal,
+		"frontend:runSSTICheck":          a.runSSTICheck,
+		"frontend:getXXETemplates":       a.getXXETemplates,
+		"frontend:runXXEProbe":           a.runXXEProbe,
+
+		// Host info handlers
+		"frontend:getHostInfo":      a.getHostInfo,
+		"frontend:runWAFDetection":  a.runWAFDetection,
+		"frontend:runTechDetection": a.runTechDetection,
+		"frontend:runFaviconHashes": a.runFaviconHashes,
 
 		// Project handlers
 		"frontend:listProjects":     a.listProjects,
-		"frontend:switchProject":    a.SwitchProject,
-		"frontend:createNewProject": a.CreateNewProject,
+		"frontend:switchProject":    a.switchProject,
+		"frontend:createNewProject": a.createNewProject,
+		"frontend:getProjectLock":   a.getProjectLock,
+		"frontend:takeOverProject":  a.takeOverProject,
 
 		// Misc handlers
-		"frontend:startListening":    a.startListening,
-		"frontend:stopListening":     a.stopListening,
-		"frontend:generateNewDomain": a.generateNewDomain,
-		"frontend:getDomains":        a.getDomains,
-		"frontend:getSiteMap":        a.getSiteMap,
-		"frontend:getTrafficData":    a.GetTrafficData,
-	}
-
-	// Register all handlers
+		"frontend:runCaptureDiagnostics": a.runCaptureDiagnostics,
+		"frontend:exportDiagnostics":     a.exportDiagnostics,
+		"frontend:setMetricsServer":      a.setMetricsServer,
+		"frontend:getMetricsServer":      a.getMetricsServer,
+		"frontend:runLoadTest":           a.runLoadTest,
+		"frontend:runHeaderAudit":        a.runHeaderAudit,
+		"frontend:startListening":        a.startListening,
+		"frontend:stopListening":         a.stopListening,
+		"frontend:generateNewDomain":     a.generateNewDomain,
+		"frontend:getDomains":            a.getDomains,
+		"frontend:getSiteMap":            a.getSiteMap,
+		"frontend:runBackupProbe":        a.runBackupProbe,
+		"frontend:getTrafficData":        a.getTrafficData,
+	}
+
+	// Register all handlers, checked by the guard
+	a.eventGuard.OnDenied(func(event string) {
+		a.logger.LogMessage("warning", fmt.Sprintf("Refused %s, it was not sent by the ProKZee window", event), "Security")
+		wailsRuntime.EventsEmit(a.ctx, "backend:eventDenied", map[string]interface{}{
+			"event": event,
+		})
+	})
 	for event, handler := range handlers {
-		wailsRuntime.EventsOn(a.ctx, event, handler)
+		wailsRuntime.EventsOn(a.ctx, event, a.eventGuard.Wrap(event, handler))
 	}
 }
 
+// domReady hands the main frame the token of the privileged events, each
+// time the page loads
+func (a *App) domReady(ctx context.Context) {
+	wailsRuntime.WindowExecJS(ctx, a.eventGuard.Script())
+}
+
 // startup is called when the app starts. The context is saved so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 	// Add cleanup handler
 	wailsRuntime.EventsOnce(ctx, "shutdown", func(optionalData ...interface{}) {
-		a.cleanup()
+		a.shutdown(ctx)
 	})
 
 	// Get the current working directory
@@ -564,6 +1092,9 @@ func (a *App) startup(ctx context.Context) {
 
 	// Initialize resender
 	a.resender = resender.NewResender(ctx, a.db, a.requestStorage)
+	if err := a.resender.EnsureSchema(); err != nil {
+		log.Printf("Failed to migrate resender tables: %v", err)
+	}
 
 	// Load settings from the database
 	settings, err := a.settingsClient.LoadSettings()
@@ -576,6 +1107,32 @@ func (a *App) startup(ctx context.Context) {
 	interactshHost := settings.InteractshHost
 	interactshPort := settings.InteractshPort
 
+	if err := a.applyUpstreamProxy(settings); err != nil {
+		log.Printf("Ignoring the upstream proxy setting: %v", err)
+	}
+	if err := a.applyResolver(settings); err != nil {
+		log.Printf("Ignoring the host overrides and DNS server settings: %v", err)
+	}
+	if err := a.applyTLSProfile(settings); err != nil {
+		log.Printf("Ignoring the TLS profile setting: %v", err)
+	}
+	if err := a.proxy.SetCorrelationHeader(settings.CorrelationHeader); err != nil {
+		log.Printf("Ignoring the correlation header setting: %v", err)
+	}
+	if err := a.proxy.SetTLSPassthrough(settings.TLSPassthrough); err != nil {
+		log.Printf("Ignoring the TLS passthrough setting: %v", err)
+	}
+	if err := a.applyClientCertificates(); err != nil {
+		log.Printf("Ignoring the client certificates: %v", err)
+	}
+	a.requestStorage.SetMaxBodySize(settings.MaxStoredBodySize)
+	removeOrphanBlobs(a.requestStorage)
+	a.proxy.SetForceConnectionClose(settings.ForceConnectionClose)
+	a.proxy.SetBodyLimits(proxy.BodyLimits{Request: settings.MaxRequestBodySize, Response: settings.MaxResponseBodySize})
+	if err := a.applyProjectCA(settings); err != nil {
+		log.Printf("Ignoring the project CA setting: %v", err)
+	}
+
 	// Initialize the client with interactshHost and interactshPort
 	a.listener = listener.NewClient(ctx, interactshHost, interactshPort)
 	a.listener.GenerateKeys()
@@ -592,12 +1149,20 @@ func (a *App) startup(ctx context.Context) {
 	a.proxy.SetupHandlers()
 
 	// Set up request and response handlers with direct method calls
-	a.proxy.HandleRequest(a.ctx, a.scopeClient, a.matchReplaceClient, a.rulesClient, a.logger, a.HandleProxyRequest)
-	a.proxy.HandleResponse(a.ctx, a.matchReplaceClient, a.logger, a.HandleProxyResponse)
-
-	// Start the proxy server
-	if err := a.proxy.StartServer(proxyPort); err != nil {
-		log.Fatalf("Failed to start proxy server: %v", err)
+	a.proxy.SetComponents(a.proxyComponents())
+	a.proxy.HandleRequest(a.ctx, a.handleProxyRequest)
+	a.proxy.HandleResponse(a.ctx, a.handleProxyResponse)
+
+	// Start the proxy server. The app stays usable when the address is taken,
+	// so another port or address can be picked in the settings. Nothing is
+	// captured into a project another instance holds until it is taken over.
+	if a.projectLock == nil {
+		a.emitProjectLocked()
+	} else {
+		a.watchProjectLock(a.projectLock)
+		if err := a.proxy.StartServer(settings.BindAddress, proxyPort); err != nil {
+			a.emitProxyListenError(err)
+		}
 	}
 
 	// Register event handlers
@@ -606,6 +1171,17 @@ func (a *App) startup(ctx context.Context) {
 	// Add this function to periodically clean up stale channels
 	a.startChannelCleanupRoutine()
 
+	// Watch held requests so they don't silently hit the approval timeout
+	a.startInterceptMonitor()
+	a.startStatsEmitter()
+
+	// Let the frontend start the first-run wizard
+	if config, err := setup.Load(); err == nil && !config.Completed {
+		wailsRuntime.EventsEmit(ctx, "backend:setupRequired", map[string]interface{}{
+			"dataDir": setup.DataDir(),
+		})
+	}
+
 }
 
 // CustomRoundTripper wraps http.Transport and implements goproxy.RoundTripper
@@ -756,6 +1332,45 @@ func (a *App) addToInScope(data ...interface{}) {
 	})
 }
 
+// getSamplingPolicies handles the event to fetch the per-scope sampling policies
+func (a *App) getSamplingPolicies(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:samplingPolicies", map[string]interface{}{
+		"policies": a.scopeClient.GetSamplingPolicies(),
+	})
+}
+
+// setSamplingPolicy handles the event to set the sample rate for an in-scope pattern
+func (a *App) setSamplingPolicy(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing sampling policy data")
+		return
+	}
+	policyData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid sampling policy data format")
+		return
+	}
+	pattern, ok := policyData["pattern"].(string)
+	if !ok || pattern == "" {
+		log.Println("Invalid or missing sampling pattern")
+		return
+	}
+	rate, ok := policyData["sampleRate"].(float64)
+	if !ok {
+		log.Println("Invalid or missing sample rate")
+		return
+	}
+
+	if err := a.scopeClient.SetSamplingPolicy(pattern, int(rate)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:samplingPolicies", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	a.getSamplingPolicies()
+}
+
 // ApproveRequest is called by the frontend to approve or reject a request.
 func (a *App) ApproveRequest(data map[string]interface{}) {
 	requestID, ok := data["requestID"].(string)
@@ -858,12 +1473,6 @@ func (a *App) ApproveRequest(data map[string]interface{}) {
 	}
 }
 
-// ToggleInterception toggles the interception state.
-func (a *App) ToggleInterception() {
-	newState := a.proxy.ToggleInterception()
-	wailsRuntime.EventsEmit(a.ctx, "backend:interceptionToggled", newState)
-}
-
 // getRequestByID handles the event to fetch a specific request by ID
 func (a *App) getRequestByID(data ...interface{}) {
 	if len(data) < 1 {
@@ -882,7 +1491,79 @@ func (a *App) getRequestByID(data ...interface{}) {
 		return
 	}
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:requestDetails", details)
+	annotated := []history.Request{*details}
+	history.AnnotateDomains(annotated, a.scopeClient.InScopeHosts())
+	wailsRuntime.EventsEmit(a.ctx, "backend:requestDetails", annotated[0])
+}
+
+// getStreamEvents returns the recorded events of a streamed response
+func (a *App) getStreamEvents(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:streamEvents", map[string]interface{}{
+			"error": "No request ID provided",
+		})
+		return
+	}
+	requestID, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:streamEvents", map[string]interface{}{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+
+	events, err := a.requestStorage.GetStreamEvents(int(requestID))
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:streamEvents", map[string]interface{}{
+			"error": "Failed to fetch stream events: " + err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:streamEvents", map[string]interface{}{
+		"requestId": int(requestID),
+		"events":    events,
+	})
+}
+
+// getBodyHex handles the event to fetch a page of a stored body as a hex dump
+func (a *App) getBodyHex(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:bodyHex", map[string]interface{}{
+			"error": "Missing hex view data",
+		})
+		return
+	}
+	hexData, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:bodyHex", map[string]interface{}{
+			"error": "Invalid hex view data format",
+		})
+		return
+	}
+
+	id, ok := hexData["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:bodyHex", map[string]interface{}{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+	part, _ := hexData["part"].(string)
+	if part == "" {
+		part = "response"
+	}
+	offset, _ := hexData["offset"].(float64)
+	length, _ := hexData["length"].(float64)
+
+	page, err := a.historyClient.GetBodyHexPage(int(id), part, int(offset), int(length))
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:bodyHex", map[string]interface{}{
+			"error": "Failed to fetch body: " + err.Error(),
+		})
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:bodyHex", page)
 }
 
 // getAllRules handles the event to fetch all rules
@@ -960,6 +1641,98 @@ func (a *App) deleteRule(data ...interface{}) {
 	})
 }
 
+// autoForward creates an auto-forward rule from a held request in one action
+// and releases it, along with every other held request the rule covers. The
+// rule matches the host of the request, and its path and method when
+// matchPath and matchMethod are set.
+func (a *App) autoForward(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForward", map[string]interface{}{
+			"error": "Missing auto-forward data",
+		})
+		return
+	}
+	params, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForward", map[string]interface{}{
+			"error": "Invalid auto-forward data format",
+		})
+		return
+	}
+	requestID, _ := params["requestID"].(string)
+	matchPath, _ := params["matchPath"].(bool)
+	matchMethod, _ := params["matchMethod"].(bool)
+	persistent, _ := params["persistent"].(bool)
+
+	a.proxy.PendingRequestsM.Lock()
+	req, exists := a.proxy.PendingRequests[requestID]
+	a.proxy.PendingRequestsM.Unlock()
+	if !exists {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForward", map[string]interface{}{
+			"error": "Request is no longer held: " + requestID,
+		})
+		return
+	}
+
+	rule := rules.AutoForwardRule{Host: req.URL.Hostname(), Persistent: persistent}
+	if rule.Host == "" {
+		rule.Host = strings.Split(req.Host, ":")[0]
+	}
+	if matchPath {
+		rule.Path = req.URL.Path
+	}
+	if matchMethod {
+		rule.Method = req.Method
+	}
+
+	rule, err := a.rulesClient.AddAutoForwardRule(rule)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForward", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	released := a.proxy.ReleaseHeld(rule.Matches)
+	wailsRuntime.EventsEmit(a.ctx, "backend:autoForward", map[string]interface{}{
+		"rule":     rule,
+		"released": released,
+	})
+	a.emitInterceptQueue(a.proxy.HeldRequests())
+}
+
+// getAutoForwardRules handles the event to fetch the auto-forward rules
+func (a *App) getAutoForwardRules(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:autoForwardRules", map[string]interface{}{
+		"rules": a.rulesClient.GetAutoForwardRules(),
+	})
+}
+
+// deleteAutoForwardRule handles the event to delete an auto-forward rule
+func (a *App) deleteAutoForwardRule(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForwardRules", map[string]interface{}{
+			"error": "Missing auto-forward rule ID",
+		})
+		return
+	}
+	ruleID, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForwardRules", map[string]interface{}{
+			"error": "Invalid auto-forward rule ID",
+		})
+		return
+	}
+
+	if err := a.rulesClient.DeleteAutoForwardRule(int(ruleID)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:autoForwardRules", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.getAutoForwardRules()
+}
+
 // getAllMatchReplaceRules handles the event to fetch all match and replace rules
 func (a *App) getAllMatchReplaceRules(data ...interface{}) {
 	rules, err := a.matchReplaceClient.GetAllRules()
@@ -1070,1070 +1843,5143 @@ func (a *App) addMatchReplaceRule(data ...interface{}) {
 	})
 }
 
-func (a *App) startFuzzer(data ...interface{}) {
-	if len(data) < 1 {
-		log.Println("Missing Fuzzer data")
+// getAllMapLocalRules handles the event to fetch all map local rules
+func (a *App) getAllMapLocalRules(data ...interface{}) {
+	rules, err := a.mapLocalClient.GetAllRules()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-	fuzzerData, ok := data[0].(map[string]interface{})
+	wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+		"rules": rules,
+	})
+}
+
+// parseMapLocalRule reads a map local rule sent by the frontend
+func parseMapLocalRule(data []interface{}) (maplocal.Rule, error) {
+	if len(data) < 1 {
+		return maplocal.Rule{}, fmt.Errorf("Missing rule data")
+	}
+	ruleData, ok := data[0].(map[string]interface{})
 	if !ok {
-		log.Println("Invalid Fuzzer data format")
-		return
+		return maplocal.Rule{}, fmt.Errorf("Invalid rule data format")
 	}
-	a.fuzzer.StartFuzzer(fuzzerData)
-}
 
-func (a *App) stopFuzzer(data ...interface{}) {
-	a.fuzzer.StopFuzzer()
+	var rule maplocal.Rule
+	if id, ok := ruleData["id"].(float64); ok {
+		rule.ID = int(id)
+	}
+	rule.RuleName, _ = ruleData["rule_name"].(string)
+	rule.Method, _ = ruleData["method"].(string)
+	rule.URLPattern, _ = ruleData["url_pattern"].(string)
+	if status, ok := ruleData["status_code"].(float64); ok {
+		rule.StatusCode = int(status)
+	}
+	rule.Headers, _ = ruleData["headers"].(string)
+	rule.Body, _ = ruleData["body"].(string)
+	rule.FilePath, _ = ruleData["file_path"].(string)
+	rule.Enabled, _ = ruleData["enabled"].(bool)
+	return rule, nil
 }
 
-func (a *App) getFuzzerTabs(data ...interface{}) {
-	tabs := a.fuzzer.GetFuzzerTabs()
-	wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerTabs", tabs)
+// addMapLocalRule handles the event to add a new map local rule
+func (a *App) addMapLocalRule(data ...interface{}) {
+	rule, err := parseMapLocalRule(data)
+	if err == nil {
+		_, err = a.mapLocalClient.AddRule(rule)
+	}
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.getAllMapLocalRules()
 }
 
-func (a *App) addFuzzerTab(data ...interface{}) {
+// mapLocalFromHistory handles the event to add a map local rule answering
+// the method and URL of a history entry with its recorded response
+func (a *App) mapLocalFromHistory(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing tab data")
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": "Missing request ID",
+		})
 		return
 	}
-	tabData, ok := data[0].(map[string]interface{})
+	requestID, ok := data[0].(float64)
 	if !ok {
-		log.Println("Invalid tab data format")
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": "Invalid request ID format",
+		})
 		return
 	}
-	a.fuzzer.AddFuzzerTab(tabData)
-}
 
-func (a *App) updateFuzzerTab(data ...interface{}) {
-	if len(data) < 1 {
-		log.Println("Missing tab data")
+	rule, err := a.mapLocalClient.RuleFromHistory(int(requestID))
+	if err == nil {
+		_, err = a.mapLocalClient.AddRule(rule)
+	}
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-	tabData, ok := data[0].(map[string]interface{})
-	if !ok {
-		log.Println("Invalid tab data format")
+	a.getAllMapLocalRules()
+}
+
+// updateMapLocalRule handles the event to update a map local rule
+func (a *App) updateMapLocalRule(data ...interface{}) {
+	rule, err := parseMapLocalRule(data)
+	if err == nil {
+		err = a.mapLocalClient.UpdateRule(rule)
+	}
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-	a.fuzzer.UpdateFuzzerTab(tabData)
+	a.getAllMapLocalRules()
 }
 
-func (a *App) updateFuzzerTabName(data ...interface{}) {
+// deleteMapLocalRule handles the event to delete a map local rule
+func (a *App) deleteMapLocalRule(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing tab data")
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": "Missing rule ID",
+		})
 		return
 	}
-	tabData, ok := data[0].(map[string]interface{})
+	ruleID, ok := data[0].(float64)
 	if !ok {
-		log.Println("Invalid tab data format")
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": "Invalid rule ID",
+		})
 		return
 	}
-
-	tabId, ok := tabData["tabId"].(float64)
-	if !ok {
-		log.Println("Invalid or missing tabId")
+	if err := a.mapLocalClient.DeleteRule(int(ruleID)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:mapLocalRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
+	a.getAllMapLocalRules()
+}
 
-	newName, ok := tabData["newName"].(string)
-	if !ok {
-		log.Println("Invalid or missing newName")
+// getAllChaosRules handles the event to fetch all chaos rules
+func (a *App) getAllChaosRules(data ...interface{}) {
+	rules, err := a.chaosClient.GetAllRules()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-
-	a.fuzzer.UpdateFuzzerTabName(tabId, newName)
+	wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+		"rules": rules,
+	})
 }
 
-func (a *App) removeFuzzerTab(data ...interface{}) {
+// parseChaosRule reads a chaos rule sent by the frontend
+func parseChaosRule(data []interface{}) (chaos.Rule, error) {
 	if len(data) < 1 {
-		log.Println("Missing tab ID")
-		return
+		return chaos.Rule{}, fmt.Errorf("Missing rule data")
 	}
-	tabID, ok := data[0].(float64)
+	ruleData, ok := data[0].(map[string]interface{})
 	if !ok {
-		log.Println("Invalid tab ID format")
-		return
+		return chaos.Rule{}, fmt.Errorf("Invalid rule data format")
 	}
-	a.fuzzer.RemoveFuzzerTab(int(tabID))
-}
-
-func (a *App) startListening(optionalData ...interface{}) {
-	a.logger.LogMessage("info", "Starting Interactsh listener", "Interactsh")
-	a.listener.StartListening()
-}
 
-func (a *App) stopListening(optionalData ...interface{}) {
-	a.logger.LogMessage("info", "Stopping Interactsh listener", "Interactsh")
-	a.listener.StopListening()
+	var rule chaos.Rule
+	if id, ok := ruleData["id"].(float64); ok {
+		rule.ID = int(id)
+	}
+	rule.RuleName, _ = ruleData["rule_name"].(string)
+	rule.Method, _ = ruleData["method"].(string)
+	rule.URLPattern, _ = ruleData["url_pattern"].(string)
+	rule.Action, _ = ruleData["action"].(string)
+	if delay, ok := ruleData["delay_ms"].(float64); ok {
+		rule.DelayMs = int(delay)
+	}
+	if jitter, ok := ruleData["jitter_ms"].(float64); ok {
+		rule.JitterMs = int(jitter)
+	}
+	if length, ok := ruleData["truncate_bytes"].(float64); ok {
+		rule.TruncateBytes = int(length)
+	}
+	if status, ok := ruleData["status_code"].(float64); ok {
+		rule.StatusCode = int(status)
+	}
+	// Every matching response unless a share is given
+	rule.Probability = 100
+	if probability, ok := ruleData["probability"].(float64); ok {
+		rule.Probability = int(probability)
+	}
+	rule.Enabled, _ = ruleData["enabled"].(bool)
+	return rule, nil
 }
 
-func (a *App) generateNewDomain(optionalData ...interface{}) {
-	if a.listener != nil {
-		a.logger.LogMessage("info", "Generating new Interactsh domain", "Interactsh")
-		a.listener.GenerateNewDomain()
+// addChaosRule handles the event to add a new chaos rule
+func (a *App) addChaosRule(data ...interface{}) {
+	rule, err := parseChaosRule(data)
+	if err == nil {
+		_, err = a.chaosClient.AddRule(rule)
+	}
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
 	}
+	a.getAllChaosRules()
 }
 
-func (a *App) getDomains(data ...interface{}) {
-	domains, err := a.sitemapClient.GetDomains()
+// updateChaosRule handles the event to update a chaos rule
+func (a *App) updateChaosRule(data ...interface{}) {
+	rule, err := parseChaosRule(data)
+	if err == nil {
+		err = a.chaosClient.UpdateRule(rule)
+	}
 	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:domains", map[string]interface{}{
-			"error": "Failed to fetch domains: " + err.Error(),
+		wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+			"error": err.Error(),
 		})
 		return
 	}
-
-	wailsRuntime.EventsEmit(a.ctx, "backend:domains", map[string]interface{}{
-		"domains": domains,
-	})
+	a.getAllChaosRules()
 }
 
-func (a *App) getSiteMap(data ...interface{}) {
+// deleteChaosRule handles the event to delete a chaos rule
+func (a *App) deleteChaosRule(data ...interface{}) {
 	if len(data) < 1 {
-		wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
-			"error": "Missing domain",
+		wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+			"error": "Missing rule ID",
 		})
 		return
 	}
-
-	domain := data[0].(string)
-	root, err := a.sitemapClient.GetSiteMap(domain)
-	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
-			"error": "Failed to fetch sitemap: " + err.Error(),
+	ruleID, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+			"error": "Invalid rule ID",
 		})
 		return
 	}
-
-	wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
-		"Sitemap": root,
-	})
-}
-
-func (a *App) getRequestsByEndpoint(data ...interface{}) {
-	if len(data) < 2 {
-		log.Println("Missing domain or path")
-		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
-			"error": "Missing domain or path",
+	if err := a.chaosClient.DeleteRule(int(ruleID)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:chaosRules", map[string]interface{}{
+			"error": err.Error(),
 		})
 		return
 	}
+	a.getAllChaosRules()
+}
 
-	domain := data[0].(string)
-	path := data[1].(string)
-
-	requests, err := a.sitemapClient.GetRequestsByEndpoint(domain, path)
+// getAllTagRules handles the event to fetch all tag rules
+func (a *App) getAllTagRules(data ...interface{}) {
+	rules, err := a.tagClient.GetAllRules()
 	if err != nil {
-		log.Printf("Error fetching requests: %v", err)
-		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
-			"error": fmt.Sprintf("Failed to fetch requests: %v", err),
+		wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+			"error": err.Error(),
 		})
 		return
 	}
-
-	wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
-		"requests": requests,
+	wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+		"rules": rules,
 	})
 }
 
-func (a *App) createChatContext(data ...interface{}) {
-	var requestString string
-	if len(data) > 0 {
-		if rs, ok := data[0].(string); ok {
-			requestString = rs
-		}
+// parseTagRule reads a tag rule sent by the frontend
+func parseTagRule(data []interface{}) (tagging.Rule, error) {
+	if len(data) < 1 {
+		return tagging.Rule{}, fmt.Errorf("Missing rule data")
 	}
-
-	id, err := a.llmClient.CreateChatContext(requestString)
-	if err != nil {
-		log.Printf("Failed to create chat context: %v", err)
-		return
+	ruleData, ok := data[0].(map[string]interface{})
+	if !ok {
+		return tagging.Rule{}, fmt.Errorf("Invalid rule data format")
 	}
 
-	if requestString != "" {
-		// Get settings for the initial message
-		settings, err := a.loadSettingsFromDB()
-		if err != nil {
-			log.Printf("Failed to load settings: %v", err)
-			return
-		}
-
-		settingsMap := map[string]interface{}{
-			"OpenAIAPIURL": settings.OpenAIAPIURL,
-			"OpenAIAPIKey": settings.OpenAIAPIKey,
-		}
-
-		message := fmt.Sprintf("Analyze the following HTTP:\n\n%s", requestString)
-		err = a.llmClient.SendMessage(map[string]interface{}{
-			"chatContextId": float64(id),
-			"messages": []interface{}{
-				map[string]interface{}{
-					"role":    "user",
-					"content": message,
-				},
-			},
-		}, settingsMap)
-		if err != nil {
-			log.Printf("Failed to send initial message: %v", err)
-		}
+	var rule tagging.Rule
+	if id, ok := ruleData["id"].(float64); ok {
+		rule.ID = int(id)
 	}
+	rule.RuleName, _ = ruleData["rule_name"].(string)
+	rule.Tag, _ = ruleData["tag"].(string)
+	rule.Part, _ = ruleData["part"].(string)
+	rule.Pattern, _ = ruleData["pattern"].(string)
+	rule.Enabled, _ = ruleData["enabled"].(bool)
+	return rule, nil
 }
 
-func (a *App) deleteChatContext(data ...interface{}) {
-	if len(data) < 1 {
-		log.Println("Missing chat context ID")
-		return
+// addTagRule handles the event to add a new tag rule
+func (a *App) addTagRule(data ...interface{}) {
+	rule, err := parseTagRule(data)
+	if err == nil {
+		_, err = a.tagClient.AddRule(rule)
 	}
-	chatContextId, ok := data[0].(float64)
-	if !ok {
-		log.Println("Invalid chat context ID")
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
+	a.getAllTagRules()
+}
 
-	err := a.llmClient.DeleteChatContext(int(chatContextId))
+// updateTagRule handles the event to update a tag rule
+func (a *App) updateTagRule(data ...interface{}) {
+	rule, err := parseTagRule(data)
+	if err == nil {
+		err = a.tagClient.UpdateRule(rule)
+	}
 	if err != nil {
-		log.Printf("Failed to delete chat context: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
 	}
+	a.getAllTagRules()
 }
 
-func (a *App) editChatContextName(data ...interface{}) {
-	if len(data) < 2 {
-		log.Println("Missing chat context ID or new name")
+// deleteTagRule handles the event to delete a tag rule
+func (a *App) deleteTagRule(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+			"error": "Missing rule ID",
+		})
 		return
 	}
-	chatContextId, ok := data[0].(float64)
+	ruleID, ok := data[0].(float64)
 	if !ok {
-		log.Println("Invalid chat context ID")
+		wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+			"error": "Invalid rule ID",
+		})
 		return
 	}
-	newName, ok := data[1].(string)
-	if !ok {
-		log.Println("Invalid new name")
+	if err := a.tagClient.DeleteRule(int(ruleID)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:tagRules", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
+	a.getAllTagRules()
+}
 
-	err := a.llmClient.EditChatContextName(int(chatContextId), newName)
+// getHistoryTags lists the tags carried by stored requests, for filtering
+func (a *App) getHistoryTags(data ...interface{}) {
+	tags, err := a.historyClient.GetTags()
 	if err != nil {
-		log.Printf("Failed to edit chat context name: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:historyTags", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
 	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:historyTags", map[string]interface{}{
+		"tags": tags,
+	})
 }
 
-func (a *App) getChatContexts(data ...interface{}) {
-	contexts, err := a.llmClient.GetChatContexts()
+func (a *App) getFavorites(data ...interface{}) {
+	favoritesList, err := a.favoritesClient.GetFavorites()
 	if err != nil {
-		log.Printf("Failed to get chat contexts: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Failed to fetch favorites: " + err.Error(),
+		})
 		return
 	}
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:chatContexts", contexts)
+	wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+		"favorites": favoritesList,
+	})
 }
 
-func (a *App) getChatMessages(data ...interface{}) {
+func (a *App) addFavorite(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing chat context ID")
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Missing favorite data",
+		})
 		return
 	}
-	chatContextId, ok := data[0].(float64)
+	favoriteData, ok := data[0].(map[string]interface{})
 	if !ok {
-		log.Println("Invalid chat context ID")
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Invalid favorite data format",
+		})
 		return
 	}
-
-	messages, err := a.llmClient.GetChatMessages(int(chatContextId))
-	if err != nil {
-		log.Printf("Failed to get chat messages: %v", err)
+	requestID, ok := favoriteData["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Invalid request ID",
+		})
 		return
 	}
+	label, _ := favoriteData["label"].(string)
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:chatMessages", map[string]interface{}{
-		"chatContextId": int(chatContextId),
-		"messages":      messages,
-	})
+	if err := a.favoritesClient.AddFavorite(int(requestID), label); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.getFavorites()
 }
 
-// GetTrafficData sends traffic data to the frontend
-func (a *App) GetTrafficData(optionalData ...interface{}) {
-	// Example traffic data
-	trafficData := models.TrafficData{
-		ID:              "1",
-		URL:             "http://example.com",
-		Method:          "GET",
-		RequestHeaders:  "{}",
-		RequestBody:     "",
-		ResponseHeaders: "{}",
-		ResponseBody:    "Hello, world!",
-		Status:          "200 OK",
+func (a *App) updateFavoriteLabel(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Missing favorite data",
+		})
+		return
 	}
-
-	wailsRuntime.EventsEmit(a.ctx, "backend:trafficData", trafficData)
-}
-
-// func (a *App) test(data ...interface{}) {
-// 	fmt.Print(data...)
-// }
-
-func (a *App) loadPluginsFromDB(optionalData ...interface{}) {
-	plugins, err := a.pluginsClient.LoadPlugins()
-	if err != nil {
-		log.Printf("Failed to load plugins: %v", err)
+	favoriteData, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Invalid favorite data format",
+		})
 		return
 	}
-
-	// Convert plugins to JSON and emit event
-	pluginsJSON, err := json.Marshal(plugins)
-	if err != nil {
-		log.Printf("Failed to marshal plugins: %v", err)
+	id, ok := favoriteData["id"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Invalid favorite ID",
+		})
 		return
 	}
-	wailsRuntime.EventsEmit(a.ctx, "pluginsLoaded", string(pluginsJSON))
-}
+	label, _ := favoriteData["label"].(string)
 
-func (a *App) savePlugin(optionalData ...interface{}) {
-	if len(optionalData) < 1 {
-		log.Println("Missing plugin data")
+	if err := a.favoritesClient.UpdateLabel(int(id), label); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
+	a.getFavorites()
+}
 
-	pluginData, ok := optionalData[0].(string)
-	if !ok {
-		log.Println("Invalid plugin data format")
+func (a *App) removeFavorite(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Missing favorite ID",
+		})
 		return
 	}
-
-	plugin, err := a.pluginsClient.SavePlugin(pluginData)
-	if err != nil {
-		log.Printf("Failed to save plugin: %v", err)
+	id, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Invalid favorite ID",
+		})
 		return
 	}
 
-	// Convert plugin to JSON and emit event
-	pluginJSON, err := json.Marshal(plugin)
-	if err != nil {
-		log.Printf("Failed to marshal plugin: %v", err)
+	if err := a.favoritesClient.RemoveFavorite(int(id)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-	wailsRuntime.EventsEmit(a.ctx, "pluginSaved", string(pluginJSON))
+	a.getFavorites()
 }
 
-func (a *App) updatePlugin(optionalData ...interface{}) {
-	if len(optionalData) < 1 {
-		log.Println("Missing plugin data")
+func (a *App) reorderFavorites(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Missing favorites order",
+		})
 		return
 	}
-
-	pluginData, ok := optionalData[0].(string)
+	idList, ok := data[0].([]interface{})
 	if !ok {
-		log.Println("Invalid plugin data format")
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": "Invalid favorites order format",
+		})
 		return
 	}
 
-	plugin, err := a.pluginsClient.UpdatePlugin(pluginData)
-	if err != nil {
-		log.Printf("Failed to update plugin: %v", err)
-		return
+	var ids []int
+	for _, item := range idList {
+		if id, ok := item.(float64); ok {
+			ids = append(ids, int(id))
+		}
 	}
 
-	// Convert plugin to JSON and emit event
-	pluginJSON, err := json.Marshal(plugin)
-	if err != nil {
-		log.Printf("Failed to marshal plugin: %v", err)
+	if err := a.favoritesClient.Reorder(ids); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:favorites", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-	wailsRuntime.EventsEmit(a.ctx, "pluginUpdated", string(pluginJSON))
+	a.getFavorites()
 }
 
-func (a *App) deletePlugin(optionalData ...interface{}) {
-	if len(optionalData) < 1 {
-		log.Println("Missing plugin ID")
-		return
-	}
-
-	pluginID, ok := optionalData[0].(float64)
-	if !ok {
-		log.Println("Invalid plugin ID format")
-		return
-	}
-
-	err := a.pluginsClient.DeletePlugin(int(pluginID))
+func (a *App) getFindings(data ...interface{}) {
+	asOf, err := parseAsOf(data, 0)
 	if err != nil {
-		log.Printf("Failed to delete plugin: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:findings", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-
-	wailsRuntime.EventsEmit(a.ctx, "pluginDeleted", int(pluginID))
-}
-
-// FetchSettings fetches the settings from the database
-func (a *App) FetchSettings(data ...interface{}) {
-	settings, err := a.settingsClient.LoadSettings()
+	findingsList, err := a.findingsClient.GetFindings(asOf)
 	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:fetchSettings", map[string]interface{}{
-			"error": "Failed to fetch settings: " + err.Error(),
+		wailsRuntime.EventsEmit(a.ctx, "backend:findings", map[string]interface{}{
+			"error": "Failed to fetch findings: " + err.Error(),
 		})
 		return
 	}
-	wailsRuntime.EventsEmit(a.ctx, "backend:fetchSettings", settings)
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:findings", map[string]interface{}{
+		"findings": findingsList,
+	})
 }
 
-// UpdateSettings updates the settings in the database
-func (a *App) UpdateSettings(data ...interface{}) {
+func (a *App) deleteFinding(data ...interface{}) {
 	if len(data) < 1 {
-		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
-			"error": "Missing settings data",
+		wailsRuntime.EventsEmit(a.ctx, "backend:findings", map[string]interface{}{
+			"error": "Missing finding ID",
 		})
 		return
 	}
-	settingsData, ok := data[0].(map[string]interface{})
+	id, ok := data[0].(float64)
 	if !ok {
-		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
-			"error": "Invalid settings data format",
+		wailsRuntime.EventsEmit(a.ctx, "backend:findings", map[string]interface{}{
+			"error": "Invalid finding ID",
 		})
 		return
 	}
 
-	settings := &settings.Settings{
-		ID:             int(settingsData["id"].(float64)),
-		ProjectName:    settingsData["project_name"].(string),
-		OpenAIAPIURL:   settingsData["openai_api_url"].(string),
-		OpenAIAPIKey:   settingsData["openai_api_key"].(string),
-		ProxyPort:      settingsData["proxy_port"].(string),
-		InteractshHost: settingsData["interactsh_host"].(string),
-		InteractshPort: int(settingsData["interactsh_port"].(float64)),
-		CreatedAt:      settingsData["created_at"].(string),
-	}
-
-	if err := a.settingsClient.UpdateSettings(settings); err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
-			"error": "Failed to update settings: " + err.Error(),
+	if err := a.findingsClient.DeleteFinding(int(id)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:findings", map[string]interface{}{
+			"error": err.Error(),
 		})
 		return
 	}
+	a.getFindings()
+}
 
-	// Update the client with the new host and port
-	a.listener.UpdateHostAndPort(settings.InteractshHost, settings.InteractshPort)
+// runCookieAnalysis analyzes the cookies of in-scope hosts and records the
+// worst offenders as findings
+func (a *App) runCookieAnalysis(data ...interface{}) {
+	go func() {
+		report, err := cookieanalyzer.NewAnalyzer(a.db).Run(a.scopeClient.IsInScope)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:cookieAnalysis", map[string]interface{}{
+				"error": "Failed to analyze cookies: " + err.Error(),
+			})
+			return
+		}
 
-	// Restart the proxy server with the new port
-	a.stopProxyServer()
-	a.startProxyServer(settings.ProxyPort)
+		created, err := report.CreateFindings(a.findingsClient)
+		if err != nil {
+			log.Printf("Failed to record cookie findings: %v", err)
+		}
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
-		"success": true,
-	})
+		wailsRuntime.EventsEmit(a.ctx, "backend:cookieAnalysis", map[string]interface{}{
+			"report":   report,
+			"findings": created,
+		})
+	}()
 }
 
-func (a *App) loadSettingsFromDB() (*settings.Settings, error) {
-	return a.settingsClient.LoadSettings()
+// runSecretScan looks for high-entropy strings and known credential formats in
+// the stored responses of in-scope hosts and records them as findings
+func (a *App) runSecretScan(data ...interface{}) {
+	go func() {
+		secrets, err := secretscan.NewScanner(a.db, a.findingsClient).Run(a.scopeClient.IsInScope)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:secretScan", map[string]interface{}{
+				"error": "Secret scan failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:secretScan", map[string]interface{}{
+			"secrets": secrets,
+		})
+		a.getFindings()
+	}()
 }
 
-func (a *App) startProxyServer(port string) {
-	if err := a.proxy.StartServer(port); err != nil {
-		log.Printf("Failed to start proxy server: %v", err)
-	}
+// runExposureCheck probes in-scope hosts for exposed .git, .svn, .env and
+// backup files and records them as findings
+func (a *App) runExposureCheck(data ...interface{}) {
+	a.logger.LogMessage("info", "Starting exposure check of in-scope hosts", "Exposure")
+
+	go func() {
+		exposures, err := exposure.NewChecker(a.db, a.findingsClient).Run(a.scopeClient.IsInScope, func(done, total int) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:exposureProgress", map[string]interface{}{
+				"done":  done,
+				"total": total,
+			})
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:exposureCheck", map[string]interface{}{
+				"error": "Exposure check failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:exposureCheck", map[string]interface{}{
+			"exposures": exposures,
+		})
+		a.getFindings()
+	}()
 }
 
-func (a *App) stopProxyServer() {
-	if err := a.proxy.StopServer(); err != nil {
-		log.Printf("Failed to stop proxy server: %v", err)
-	}
+// runCacheAudit evaluates the Cache-Control, Vary and ETag headers of the
+// stored responses of in-scope hosts per endpoint and records cacheable
+// personalized responses and cache deception or poisoning candidates as findings
+func (a *App) runCacheAudit(data ...interface{}) {
+	go func() {
+		endpoints, err := cacheaudit.NewAuditor(a.db, a.findingsClient).Run(a.scopeClient.IsInScope)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:cacheAudit", map[string]interface{}{
+				"error": "Cache audit failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:cacheAudit", map[string]interface{}{
+			"endpoints": endpoints,
+		})
+		a.getFindings()
+	}()
 }
 
-// listProjects handles the event to list all projects
-func (a *App) listProjects(data ...interface{}) {
-	projects, err := a.projectsClient.ListProjects()
-	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:listProjects", map[string]interface{}{
-			"error": err.Error(),
+// runCacheProbe replays a stored request to test its endpoint for web cache
+// deception and unkeyed header poisoning
+func (a *App) runCacheProbe(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:cacheProbe", map[string]interface{}{
+			"error": "Missing request ID",
+		})
+		return
+	}
+	requestID, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:cacheProbe", map[string]interface{}{
+			"error": "Invalid request ID",
 		})
 		return
 	}
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:listProjects", map[string]interface{}{
-		"projects": projects,
-	})
+	go func() {
+		result, err := cacheaudit.NewAuditor(a.db, a.findingsClient).Probe(int(requestID))
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:cacheProbe", map[string]interface{}{
+				"error": "Cache probe failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:cacheProbe", map[string]interface{}{
+			"requestId": int(requestID),
+			"result":    result,
+		})
+		a.getFindings()
+	}()
 }
 
-// SwitchProject switches to the selected database
-func (a *App) SwitchProject(data ...interface{}) {
+// runHostHeaderProbe replays the selected requests with attacker-controlled
+// Host, X-Forwarded-Host and similar headers and records the ones whose
+// redirects, links or bodies reflect the injected host
+func (a *App) runHostHeaderProbe(data ...interface{}) {
 	if len(data) < 1 {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Missing database name",
+		wailsRuntime.EventsEmit(a.ctx, "backend:hostHeaderProbe", map[string]interface{}{
+			"error": "Missing request IDs",
 		})
 		return
 	}
-	dbName, ok := data[0].(string)
+	idList, ok := data[0].([]interface{})
 	if !ok {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Invalid database name",
+		wailsRuntime.EventsEmit(a.ctx, "backend:hostHeaderProbe", map[string]interface{}{
+			"error": "Invalid request IDs format",
 		})
 		return
 	}
 
-	// First emit an event to tell frontend to clear its state
-	wailsRuntime.EventsEmit(a.ctx, "backend:clearState", nil)
+	var ids []int
+	for _, item := range idList {
+		if id, ok := item.(float64); ok {
+			ids = append(ids, int(id))
+		}
+	}
 
-	// First stop the proxy server to prevent new requests
-	a.stopProxyServer()
+	go func() {
+		results, err := hostheader.NewProber(a.db, a.findingsClient).Run(a.ctx, ids, func(done, total int) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:hostHeaderProgress", map[string]interface{}{
+				"done":  done,
+				"total": total,
+			})
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:hostHeaderProbe", map[string]interface{}{
+				"error": "Host header probe failed: " + err.Error(),
+			})
+			return
+		}
 
-	// Wait for any in-flight requests to complete
-	time.Sleep(500 * time.Millisecond)
+		wailsRuntime.EventsEmit(a.ctx, "backend:hostHeaderProbe", map[string]interface{}{
+			"results": results,
+		})
+		a.getFindings()
+	}()
+}
 
-	// Close old database connection
-	if a.db != nil {
-		a.db.Close()
+// runParamPollution duplicates the query and form body parameters of a stored
+// request with differing values and records the parameters the server parses
+// inconsistently
+func (a *App) runParamPollution(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:paramPollution", map[string]interface{}{
+			"error": "Missing parameter pollution data",
+		})
+		return
 	}
-
-	// Create new database connection
-	newDB, err := a.projectsClient.SwitchProject(dbName)
-	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": err.Error(),
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:paramPollution", map[string]interface{}{
+			"error": "Invalid parameter pollution data format",
 		})
 		return
 	}
+	requestID, ok := options["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:paramPollution", map[string]interface{}{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+	var names []string
+	if nameList, ok := options["parameters"].([]interface{}); ok {
+		for _, item := range nameList {
+			if name, ok := item.(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
 
-	// Set connection pool settings for new database
-	newDB.SetMaxOpenConns(25)
-	newDB.SetMaxIdleConns(5)
-	newDB.SetConnMaxLifetime(time.Hour)
-
-	// Update the app's database connection
-	a.db = newDB
-
-	// Reset mutex and channels
-	a.dbMutex = sync.RWMutex{}
-	a.dbClosing = make(chan struct{})
-
-	// Reinitialize all database-dependent components
-	var initErr error
+	go func() {
+		result, err := paramtest.NewTester(a.db, a.findingsClient).Run(a.ctx, int(requestID), names)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:paramPollution", map[string]interface{}{
+				"error": "Parameter pollution test failed: " + err.Error(),
+			})
+			return
+		}
 
-	// Create new request storage
-	a.requestStorage = storage.NewRequestStorage(newDB, &a.dbMutex)
+		wailsRuntime.EventsEmit(a.ctx, "backend:paramPollution", map[string]interface{}{
+			"result": result,
+		})
+		a.getFindings()
+	}()
+}
 
-	// Initialize history client
-	a.historyClient, initErr = history.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize history client: " + initErr.Error(),
+// runPathTraversal replaces the file name parameters of a stored request
+// with traversal sequences and records the ones that read system files
+func (a *App) runPathTraversal(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:pathTraversal", map[string]interface{}{
+			"error": "Missing path traversal data",
 		})
 		return
 	}
-
-	// Initialize plugins client
-	a.pluginsClient, initErr = plugins.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize plugins client: " + initErr.Error(),
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:pathTraversal", map[string]interface{}{
+			"error": "Invalid path traversal data format",
 		})
 		return
 	}
-
-	// Initialize rules client
-	a.rulesClient, initErr = rules.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize rules client: " + initErr.Error(),
+	requestID, ok := options["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:pathTraversal", map[string]interface{}{
+			"error": "Invalid request ID",
 		})
 		return
 	}
+	var names []string
+	if nameList, ok := options["parameters"].([]interface{}); ok {
+		for _, item := range nameList {
+			if name, ok := item.(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
 
-	// Initialize match replace client
-	a.matchReplaceClient, initErr = matchreplace.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize match replace client: " + initErr.Error(),
+	go func() {
+		result, err := traversal.NewChecker(a.db, a.findingsClient).Run(a.ctx, int(requestID), names)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:pathTraversal", map[string]interface{}{
+				"error": "Path traversal check failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:pathTraversal", map[string]interface{}{
+			"result": result,
 		})
-		return
-	}
+		a.getFindings()
+	}()
+}
 
-	// Initialize scope client
-	a.scopeClient, initErr = scope.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize scope client: " + initErr.Error(),
+// runSSTICheck sends template expressions in the parameters of a stored
+// request, identifies the engine of the ones that get evaluated and records
+// them as findings
+func (a *App) runSSTICheck(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:sstiCheck", map[string]interface{}{
+			"error": "Missing template injection data",
 		})
 		return
 	}
-
-	// Initialize sitemap client
-	a.sitemapClient, initErr = sitemap.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize sitemap client: " + initErr.Error(),
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:sstiCheck", map[string]interface{}{
+			"error": "Invalid template injection data format",
 		})
 		return
 	}
-
-	// Initialize settings client
-	a.settingsClient, initErr = settings.NewClient(newDB)
-	if initErr != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to initialize settings client: " + initErr.Error(),
+	requestID, ok := options["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:sstiCheck", map[string]interface{}{
+			"error": "Invalid request ID",
 		})
 		return
 	}
+	names := stringItems(options["parameters"])
 
-	// Initialize projects client with current context
-	a.projectsClient = projects.NewClient(a.ctx, newDB, &a.dbMutex)
+	go func() {
+		result, err := ssti.NewChecker(a.db, a.findingsClient).Run(a.ctx, int(requestID), names)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:sstiCheck", map[string]interface{}{
+				"error": "Template injection check failed: " + err.Error(),
+			})
+			return
+		}
 
-	// Initialize other components with current context
-	a.fuzzer = fuzzer.NewFuzzer(a.ctx, newDB)
-	a.resender = resender.NewResender(a.ctx, newDB, a.requestStorage)
-	a.llmClient = llm.NewClient(a.ctx, newDB)
+		wailsRuntime.EventsEmit(a.ctx, "backend:sstiCheck", map[string]interface{}{
+			"result": result,
+		})
+		a.getFindings()
+	}()
+}
 
-	// Update logger with new database connection
-	if a.logger != nil {
-		a.logger.RefreshConnection(newDB)
-	} else {
-		a.logger = logger.NewLogger(newDB, a.ctx, nil)
-	}
+// getXXETemplates returns the XXE probes that can be sent for XML requests
+func (a *App) getXXETemplates(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:xxeTemplates", xxe.Templates)
+}
 
-	if err := a.logger.EnsureLogsTableExists(); err != nil {
-		log.Printf("Warning: Failed to create logs table: %v", err)
+// runXXEProbe sends XXE probes built from the XML body of a stored request.
+// Out-of-band probes point at the listener domain and need it running.
+func (a *App) runXXEProbe(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:xxeProbe", map[string]interface{}{
+			"error": "Missing XXE probe data",
+		})
+		return
 	}
-
-	// Load settings from the new database
-	settings, err := a.settingsClient.LoadSettings()
-	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to load settings: " + err.Error(),
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:xxeProbe", map[string]interface{}{
+			"error": "Invalid XXE probe data format",
 		})
 		return
 	}
-
-	// Reinitialize proxy with new settings
-	a.proxy = proxy.NewProxy()
-	if err := a.proxy.SetupCertificates(); err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-			"error": "Failed to setup certificates: " + err.Error(),
+	requestID, ok := options["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:xxeProbe", map[string]interface{}{
+			"error": "Invalid request ID",
 		})
 		return
 	}
-	a.proxy.SetupHandlers()
+	names := stringItems(options["templates"])
 
-	// Update proxy handlers with new components
-	a.proxy.HandleRequest(a.ctx, a.scopeClient, a.matchReplaceClient, a.rulesClient, a.logger, a.HandleProxyRequest)
-	a.proxy.HandleResponse(a.ctx, a.matchReplaceClient, a.logger, a.HandleProxyResponse)
+	oobDomain := ""
+	if a.listener != nil && a.listener.IsListening() {
+		oobDomain = a.listener.GetInteractDomain()
+	}
 
-	// Start the proxy server with new settings
-	a.startProxyServer(settings.ProxyPort)
+	go func() {
+		result, err := xxe.NewProber(a.db, a.findingsClient).Run(a.ctx, int(requestID), names, oobDomain)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:xxeProbe", map[string]interface{}{
+				"error": "XXE probe failed: " + err.Error(),
+			})
+			return
+		}
 
-	// Reinitialize listener with new settings
-	a.listener = listener.NewClient(a.ctx, settings.InteractshHost, settings.InteractshPort)
-	a.listener.GenerateKeys()
+		wailsRuntime.EventsEmit(a.ctx, "backend:xxeProbe", map[string]interface{}{
+			"result": result,
+		})
+		a.getFindings()
+	}()
+}
 
-	// Emit success event with the new project name
-	wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
-		"success":     true,
-		"projectName": dbName,
-	})
+// runVerbTampering sends a stored request with every standard and override
+// method and returns the status matrix of the endpoint
+func (a *App) runVerbTampering(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:verbTampering", map[string]interface{}{
+			"error": "Missing verb tampering data",
+		})
+		return
+	}
+	requestID, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:verbTampering", map[string]interface{}{
+			"error": "Invalid request ID",
+		})
+		return
+	}
 
-	// Emit events to refresh all data
-	a.GetAllRequests()             // Refresh requests
-	a.getAllRules(nil)             // Refresh rules
-	a.getAllMatchReplaceRules(nil) // Refresh match/replace rules
-	a.getScopeLists(nil)           // Refresh scope lists
-	a.getFuzzerTabs(nil)           // Refresh fuzzer tabs
-	a.getChatContexts(nil)         // Refresh chat contexts
-	a.loadPluginsFromDB(nil)       // Refresh plugins
-	a.FetchSettings(nil)           // Refresh settings
-	a.getDomains(nil)              // Refresh domains
-	a.GetRecentLogs(nil)           // Refresh logs
+	go func() {
+		result, err := verbtamper.NewTester(a.db, a.findingsClient).Run(a.ctx, int(requestID))
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:verbTampering", map[string]interface{}{
+				"error": "Verb tampering test failed: " + err.Error(),
+			})
+			return
+		}
 
-	// Refresh resender tabs
-	if tabs, err := a.resender.GetTabs(); err == nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:resenderTabs", tabs)
-	} else {
-		log.Printf("Warning: Failed to refresh resender tabs: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:verbTampering", map[string]interface{}{
+			"result": result,
+		})
+		a.getFindings()
+	}()
+}
+
+// runRaceTest sends synchronized copies of a stored request and groups the
+// identical answers
+func (a *App) runRaceTest(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:raceTest", map[string]interface{}{
+			"error": "Missing race test data",
+		})
+		return
+	}
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:raceTest", map[string]interface{}{
+			"error": "Invalid race test data format",
+		})
+		return
+	}
+	var config racetest.Config
+	if requestID, ok := options["requestId"].(float64); ok {
+		config.RequestID = int(requestID)
+	}
+	if count, ok := options["count"].(float64); ok {
+		config.Count = int(count)
 	}
+	config.Mode, _ = options["mode"].(string)
+
+	go func() {
+		result, err := racetest.NewTester(a.db).Run(a.ctx, config)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:raceTest", map[string]interface{}{
+				"error": "Race test failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:raceTest", map[string]interface{}{
+			"result": result,
+		})
+	}()
 }
 
-// CreateNewProject creates a new SQLite database in the projects_data folder and initializes it with default data
-func (a *App) CreateNewProject(data ...interface{}) {
+// startTurbo sends a stored request at a high rate over kept alive
+// connections, reporting the rate and errors every second
+func (a *App) startTurbo(data ...interface{}) {
 	if len(data) < 1 {
-		wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
-			"error": "Missing project name",
+		wailsRuntime.EventsEmit(a.ctx, "backend:turboResult", map[string]interface{}{
+			"error": "Missing turbo data",
 		})
 		return
 	}
-	projectName, ok := data[0].(string)
+	options, ok := data[0].(map[string]interface{})
 	if !ok {
-		wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
-			"error": "Invalid project name",
+		wailsRuntime.EventsEmit(a.ctx, "backend:turboResult", map[string]interface{}{
+			"error": "Invalid turbo data format",
 		})
 		return
 	}
+	var config turbo.Config
+	if requestID, ok := options["requestId"].(float64); ok {
+		config.RequestID = int(requestID)
+	}
+	if connections, ok := options["connections"].(float64); ok {
+		config.Connections = int(connections)
+	}
+	if requests, ok := options["requests"].(float64); ok {
+		config.Requests = int(requests)
+	}
+	if pipeline, ok := options["pipeline"].(float64); ok {
+		config.Pipeline = int(pipeline)
+	}
 
-	err := a.projectsClient.CreateNewProject(projectName)
-	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
-			"error": err.Error(),
+	a.turboMutex.Lock()
+	if a.turboCancel != nil {
+		a.turboMutex.Unlock()
+		wailsRuntime.EventsEmit(a.ctx, "backend:turboResult", map[string]interface{}{
+			"error": "A turbo run is already in progress",
 		})
 		return
 	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.turboCancel = cancel
+	a.turboMutex.Unlock()
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
-		"success": true,
-	})
+	go func() {
+		defer func() {
+			a.turboMutex.Lock()
+			a.turboCancel = nil
+			a.turboMutex.Unlock()
+			cancel()
+		}()
+
+		result, err := turbo.NewEngine(a.db).Run(ctx, config, func(snapshot turbo.Snapshot) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:turboProgress", snapshot)
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:turboResult", map[string]interface{}{
+				"error": "Turbo run failed: " + err.Error(),
+			})
+			return
+		}
+
+		a.logger.LogMessage("info", fmt.Sprintf("Turbo run against %s finished: %d answers, %d errors, %.0f requests/s",
+			result.URL, result.Completed, result.Errors, result.AverageRPS), "Turbo")
+		wailsRuntime.EventsEmit(a.ctx, "backend:turboResult", map[string]interface{}{
+			"result": result,
+		})
+	}()
 }
 
-func (a *App) getRequestsByDomain(data ...interface{}) {
+func (a *App) stopTurbo(data ...interface{}) {
+	a.turboMutex.Lock()
+	defer a.turboMutex.Unlock()
+
+	if a.turboCancel != nil {
+		a.turboCancel()
+	}
+}
+
+// runAPIVersionExplorer probes the sibling versions and debug paths of the
+// API path of a stored request and compares their authentication
+func (a *App) runAPIVersionExplorer(data ...interface{}) {
 	if len(data) < 1 {
-		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByDomain", map[string]interface{}{
-			"error": "Missing domain",
+		wailsRuntime.EventsEmit(a.ctx, "backend:apiVersions", map[string]interface{}{
+			"error": "Missing API version explorer data",
 		})
 		return
 	}
-
-	domain := data[0].(string)
-
-	requests, err := a.sitemapClient.GetRequestsByDomain(domain)
-	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByDomain", map[string]interface{}{
-			"error": "Failed to fetch requests by domain: " + err.Error(),
+	requestID, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:apiVersions", map[string]interface{}{
+			"error": "Invalid request ID",
 		})
 		return
 	}
 
-	wailsRuntime.EventsEmit(a.ctx, "backend:requestsByDomain", map[string]interface{}{
-		"requests": requests,
-	})
+	go func() {
+		result, err := apiversions.NewExplorer(a.db, a.findingsClient).Run(a.ctx, int(requestID), func(done, total int) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:apiVersionsProgress", map[string]interface{}{
+				"done":  done,
+				"total": total,
+			})
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:apiVersions", map[string]interface{}{
+				"error": "API version exploration failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:apiVersions", map[string]interface{}{
+			"result": result,
+		})
+		a.getFindings()
+	}()
 }
 
-// Add this new method to handle log retrieval
-func (a *App) GetRecentLogs(data ...interface{}) {
-	var params map[string]interface{}
-	if len(data) > 0 {
-		if p, ok := data[0].(map[string]interface{}); ok {
-			params = p
-		}
+// startEntropyAnalysis replays a stored request to collect session tokens and
+// analyzes how predictable they are
+func (a *App) startEntropyAnalysis(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:entropyResult", map[string]interface{}{
+			"error": "Missing entropy analysis data",
+		})
+		return
+	}
+	analysisData, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:entropyResult", map[string]interface{}{
+			"error": "Invalid entropy analysis data format",
+		})
+		return
 	}
 
-	result := a.logger.GetRecentLogs(params)
-	wailsRuntime.EventsEmit(a.ctx, "backend:logs", result)
-}
+	requestID, ok := analysisData["requestId"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:entropyResult", map[string]interface{}{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+	target := entropy.Target{}
+	target.Source, _ = analysisData["source"].(string)
+	target.Name, _ = analysisData["name"].(string)
+	count := 200
+	if value, ok := analysisData["count"].(float64); ok {
+		count = int(value)
+	}
+
+	a.entropyMutex.Lock()
+	if a.entropyCancel != nil {
+		a.entropyMutex.Unlock()
+		wailsRuntime.EventsEmit(a.ctx, "backend:entropyResult", map[string]interface{}{
+			"error": "An entropy analysis is already running",
+		})
+		return
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.entropyCancel = cancel
+	a.entropyMutex.Unlock()
 
-// Add this function after the startup function
-func (a *App) startChannelCleanupRoutine() {
 	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
+		defer func() {
+			a.entropyMutex.Lock()
+			a.entropyCancel = nil
+			a.entropyMutex.Unlock()
+			cancel()
+		}()
 
-		for {
-			select {
-			case <-ticker.C:
-				a.cleanupStaleChannels()
-			case <-a.ctx.Done():
-				return
+		samples, failed, err := entropy.NewCollector(a.db).Collect(ctx, int(requestID), target, count, func(done, total int) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:entropyProgress", map[string]interface{}{
+				"done":  done,
+				"total": total,
+			})
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:entropyResult", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		result := entropy.Analyze(samples)
+		result.FailedExtraction = failed
+
+		if result.Predictable && len(samples) > 0 {
+			var host, rawURL string
+			if err := a.db.QueryRow("SELECT COALESCE(domain, ''), COALESCE(url, '') FROM requests WHERE id = ?", int(requestID)).Scan(&host, &rawURL); err != nil {
+				log.Printf("Failed to look up request for entropy finding: %v", err)
+			}
+			err := a.findingsClient.AddFinding(findings.Finding{
+				Source:    "entropy-analysis",
+				Host:      host,
+				URL:       rawURL,
+				RequestID: int(requestID),
+				Title:     fmt.Sprintf("Predictable token %s", target.Name),
+				Severity:  findings.SeverityHigh,
+				Detail:    strings.Join(result.Reasons, ", "),
+				Key:       host + "|" + target.Source + "|" + target.Name,
+			})
+			if err != nil {
+				log.Printf("Failed to record entropy finding: %v", err)
 			}
 		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:entropyResult", map[string]interface{}{
+			"result": result,
+		})
 	}()
 }
 
-// Add this function to clean up stale channels
-func (a *App) cleanupStaleChannels() {
-	log.Println("Running cleanup of stale approval channels")
+func (a *App) stopEntropyAnalysis(data ...interface{}) {
+	a.entropyMutex.Lock()
+	defer a.entropyMutex.Unlock()
 
-	// Get the current time
-	now := time.Now()
+	if a.entropyCancel != nil {
+		a.entropyCancel()
+	}
+}
 
-	// Track how many channels were cleaned up
-	cleanedCount := 0
+func (a *App) getHostInfo(data ...interface{}) {
+	host := ""
+	if len(data) > 0 {
+		host, _ = data[0].(string)
+	}
 
-	// Lock both maps to ensure consistency
-	a.proxy.ApprovalChsM.Lock()
-	a.proxy.PendingRequestsM.Lock()
+	entries, err := a.hostInfoClient.GetEntries(host)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:hostInfo", map[string]interface{}{
+			"error": "Failed to fetch host info: " + err.Error(),
+		})
+		return
+	}
 
-	// Find stale requests (those older than 2 minutes)
-	staleRequestIDs := []string{}
-	for requestID, req := range a.proxy.PendingRequests {
-		// If the request has been pending for more than 2 minutes, consider it stale
-		if req.Context().Value(models.CreationTimeKey) != nil {
-			creationTime, ok := req.Context().Value(models.CreationTimeKey).(time.Time)
-			if ok && now.Sub(creationTime) > 2*time.Minute {
-				staleRequestIDs = append(staleRequestIDs, requestID)
+	wailsRuntime.EventsEmit(a.ctx, "backend:hostInfo", map[string]interface{}{
+		"host":    host,
+		"entries": entries,
+	})
+}
+
+// runWAFDetection fingerprints WAFs and CDNs. Without a host it matches the
+// stored responses of in-scope hosts; with a host and active set it also
+// probes the host directly.
+func (a *App) runWAFDetection(data ...interface{}) {
+	host, scheme, active := "", "https", false
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			host, _ = options["host"].(string)
+			if value, ok := options["scheme"].(string); ok && value != "" {
+				scheme = value
 			}
+			active, _ = options["active"].(bool)
 		}
 	}
 
-	// Clean up stale requests and their channels
-	for _, requestID := range staleRequestIDs {
-		delete(a.proxy.PendingRequests, requestID)
-		if ch, exists := a.proxy.ApprovalChs[requestID]; exists {
-			delete(a.proxy.ApprovalChs, requestID)
-			cleanedCount++
+	go func() {
+		detector := wafdetect.NewDetector(a.db, a.hostInfoClient)
 
-			// Try to close the channel by sending a timeout response
-			select {
-			case ch <- proxy.ApprovalResponse{Approved: false}:
-				// Successfully sent a response
-			default:
-				// Channel is already closed or full, nothing to do
+		var detections []wafdetect.Detection
+		var err error
+		if active && host != "" {
+			detections, err = detector.RunActive(scheme, host)
+		} else {
+			filter := a.scopeClient.IsInScope
+			if host != "" {
+				filter = func(h string) bool { return h == host }
 			}
+			detections, err = detector.RunPassive(filter)
+		}
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:wafDetection", map[string]interface{}{
+				"error": "WAF detection failed: " + err.Error(),
+			})
+			return
 		}
-	}
-
-	a.proxy.PendingRequestsM.Unlock()
-	a.proxy.ApprovalChsM.Unlock()
 
-	if cleanedCount > 0 {
-		log.Printf("Cleaned up %d stale approval channels", cleanedCount)
-	}
+		wailsRuntime.EventsEmit(a.ctx, "backend:wafDetection", map[string]interface{}{
+			"detections": detections,
+		})
+	}()
 }
 
-// setupCertificates checks if certificate files exist, and if not, generates new ones
-func (a *App) setupCertificates() {
-	if err := a.proxy.SetupCertificates(); err != nil {
-		log.Fatalf("Failed to setup certificates: %v", err)
-	}
+// runTechDetection fingerprints the technologies of in-scope hosts from their
+// stored responses
+func (a *App) runTechDetection(data ...interface{}) {
+	go func() {
+		detections, err := techdetect.NewDetector(a.db, a.hostInfoClient, a.findingsClient).Run(a.scopeClient.IsInScope)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:techDetection", map[string]interface{}{
+				"error": "Technology detection failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:techDetection", map[string]interface{}{
+			"detections": detections,
+		})
+	}()
 }
 
-func (a *App) GetAllRequests(data ...interface{}) {
-	var page int = 1
+// runFaviconHashes computes the Shodan favicon hashes of in-scope hosts. With
+// fetch set, hosts without a favicon in the history are asked for one.
+func (a *App) runFaviconHashes(data ...interface{}) {
+	fetch := false
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			fetch, _ = options["fetch"].(bool)
+		}
+	}
+
+	go func() {
+		results, err := favicon.NewCollector(a.db, a.hostInfoClient).Run(a.scopeClient.IsInScope, fetch)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:faviconHashes", map[string]interface{}{
+				"error": "Failed to compute favicon hashes: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:faviconHashes", map[string]interface{}{
+			"results": results,
+		})
+	}()
+}
+
+func (a *App) startFuzzer(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing Fuzzer data")
+		return
+	}
+	fuzzerData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid Fuzzer data format")
+		return
+	}
+	a.fuzzer.StartFuzzer(fuzzerData)
+}
+
+// stopFuzzer stops the run of the given tab, or all runs without a tab ID
+func (a *App) stopFuzzer(data ...interface{}) {
+	if len(data) > 0 {
+		if tabID, ok := data[0].(float64); ok {
+			a.fuzzer.StopFuzzer(int(tabID))
+			return
+		}
+	}
+	for _, tabID := range a.fuzzer.RunningTabs() {
+		a.fuzzer.StopFuzzer(tabID)
+	}
+}
+
+// pauseFuzzer pauses the run of the given tab, or all runs without a tab ID
+func (a *App) pauseFuzzer(data ...interface{}) {
+	if len(data) > 0 {
+		if tabID, ok := data[0].(float64); ok {
+			a.fuzzer.PauseFuzzer(int(tabID))
+			return
+		}
+	}
+	a.fuzzer.PauseAll()
+}
+
+func (a *App) getFuzzerRuns(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerRuns", map[string]interface{}{
+		"tabIds": a.fuzzer.RunningTabs(),
+		"budget": fuzzer.DefaultConcurrencyBudget,
+	})
+}
+
+func (a *App) resumeFuzzer(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab ID")
+		return
+	}
+	tabID, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid tab ID format")
+		return
+	}
+	a.fuzzer.ResumeFuzzer(int(tabID))
+}
+
+func (a *App) getFuzzerCheckpoint(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab ID")
+		return
+	}
+	tabID, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid tab ID format")
+		return
+	}
+
+	checkpoint, err := a.fuzzer.GetCheckpoint(int(tabID))
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerCheckpoint", map[string]interface{}{
+			"tabId": int(tabID),
+			"error": err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerCheckpoint", map[string]interface{}{
+		"tabId":      int(tabID),
+		"checkpoint": checkpoint,
+	})
+}
+
+// estimateFuzzerPayloads counts the requests of a payload configuration and,
+// when the tab was run before, estimates how long they take
+func (a *App) estimateFuzzerPayloads(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing payload data")
+		return
+	}
+	payloadData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid payload data format")
+		return
+	}
+	payloads, ok := payloadData["payloads"].([]interface{})
+	if !ok {
+		log.Println("Invalid or missing payloads")
+		return
+	}
+
+	var avg time.Duration
+	if tabID, ok := payloadData["tabId"].(float64); ok {
+		if checkpoint, err := a.fuzzer.GetCheckpoint(int(tabID)); err == nil && checkpoint != nil {
+			avg = time.Duration(checkpoint.AvgMs) * time.Millisecond
+		}
+	}
+
+	total, duration := fuzzer.EstimatePayloads(payloads, avg)
+	wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerEstimate", map[string]interface{}{
+		"total":      total,
+		"durationMs": duration.Milliseconds(),
+	})
+}
+
+// transformUnicode returns the case mapping, normalization and homoglyph
+// variants of a value, given as {value, techniques}, for filter bypass tests
+func (a *App) transformUnicode(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:unicodeVariants", map[string]interface{}{
+			"error": "Missing value to transform",
+		})
+		return
+	}
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:unicodeVariants", map[string]interface{}{
+			"error": "Invalid transform data format",
+		})
+		return
+	}
+	value, _ := options["value"].(string)
+	wailsRuntime.EventsEmit(a.ctx, "backend:unicodeVariants", map[string]interface{}{
+		"techniques": unicodebypass.Techniques,
+		"variants":   unicodebypass.Variants(value, stringItems(options["techniques"])),
+	})
+}
+
+// parseAsOf reads the optional time a view is reconstructed at from event
+// data, the zero time when it is missing
+func parseAsOf(data []interface{}, index int) (time.Time, error) {
+	if len(data) <= index {
+		return time.Time{}, nil
+	}
+	value, _ := data[index].(string)
+	return asof.Parse(value)
+}
+
+// parseFuzzerResultRef reads the {tabId, index} of a fuzzer result from event data
+func parseFuzzerResultRef(data []interface{}) (int, int, bool) {
+	if len(data) < 1 {
+		return 0, 0, false
+	}
+	ref, ok := data[0].(map[string]interface{})
+	if !ok {
+		return 0, 0, false
+	}
+	tabID, ok := ref["tabId"].(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	index, ok := ref["index"].(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(tabID), int(index), true
+}
+
+func (a *App) getFuzzerResultDetail(data ...interface{}) {
+	tabID, index, ok := parseFuzzerResultRef(data)
+	if !ok {
+		log.Println("Invalid or missing fuzzer result reference")
+		return
+	}
+
+	detail, err := a.fuzzer.GetResultDetail(tabID, index)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerResultDetail", map[string]interface{}{
+			"tabId": tabID,
+			"index": index,
+			"error": err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerResultDetail", map[string]interface{}{
+		"tabId":  tabID,
+		"index":  index,
+		"detail": detail,
+	})
+}
+
+func (a *App) sendFuzzerResultToResender(data ...interface{}) {
+	tabID, index, ok := parseFuzzerResultRef(data)
+	if !ok {
+		log.Println("Invalid or missing fuzzer result reference")
+		return
+	}
+
+	detail, err := a.fuzzer.GetResultDetail(tabID, index)
+	if err != nil {
+		log.Printf("Error loading fuzzer result: %v", err)
+		return
+	}
+	if err := a.resender.SendToResender(detail.ResenderData()); err != nil {
+		log.Printf("Error sending to resender: %v", err)
+	}
+}
+
+func (a *App) getFuzzerTabs(data ...interface{}) {
+	tabs := a.fuzzer.GetFuzzerTabs()
+	wailsRuntime.EventsEmit(a.ctx, "backend:FuzzerTabs", tabs)
+}
+
+func (a *App) addFuzzerTab(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab data")
+		return
+	}
+	tabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid tab data format")
+		return
+	}
+	a.fuzzer.AddFuzzerTab(tabData)
+}
+
+func (a *App) updateFuzzerTab(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab data")
+		return
+	}
+	tabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid tab data format")
+		return
+	}
+	a.fuzzer.UpdateFuzzerTab(tabData)
+}
+
+func (a *App) updateFuzzerTabName(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab data")
+		return
+	}
+	tabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid tab data format")
+		return
+	}
+
+	tabId, ok := tabData["tabId"].(float64)
+	if !ok {
+		log.Println("Invalid or missing tabId")
+		return
+	}
+
+	newName, ok := tabData["newName"].(string)
+	if !ok {
+		log.Println("Invalid or missing newName")
+		return
+	}
+
+	a.fuzzer.UpdateFuzzerTabName(tabId, newName)
+}
+
+func (a *App) removeFuzzerTab(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab ID")
+		return
+	}
+	tabID, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid tab ID format")
+		return
+	}
+	a.fuzzer.RemoveFuzzerTab(int(tabID))
+}
+
+func (a *App) startListening(optionalData ...interface{}) {
+	a.logger.LogMessage("info", "Starting Interactsh listener", "Interactsh")
+	a.listener.StartListening()
+}
+
+func (a *App) stopListening(optionalData ...interface{}) {
+	a.logger.LogMessage("info", "Stopping Interactsh listener", "Interactsh")
+	a.listener.StopListening()
+}
+
+func (a *App) generateNewDomain(optionalData ...interface{}) {
+	if a.listener != nil {
+		a.logger.LogMessage("info", "Generating new Interactsh domain", "Interactsh")
+		a.listener.GenerateNewDomain()
+	}
+}
+
+func (a *App) getDomains(data ...interface{}) {
+	asOf, err := parseAsOf(data, 0)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:domains", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	domains, err := a.sitemapClient.GetDomains(asOf)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:domains", map[string]interface{}{
+			"error": "Failed to fetch domains: " + err.Error(),
+		})
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:domains", map[string]interface{}{
+		"domains": domains,
+	})
+}
+
+func (a *App) getSiteMap(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
+			"error": "Missing domain",
+		})
+		return
+	}
+
+	domain := data[0].(string)
+	asOf, err := parseAsOf(data, 1)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	root, err := a.sitemapClient.GetSiteMap(domain, asOf)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
+			"error": "Failed to fetch sitemap: " + err.Error(),
+		})
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:Sitemap", map[string]interface{}{
+		"Sitemap": root,
+	})
+}
+
+func (a *App) getRequestsByEndpoint(data ...interface{}) {
+	if len(data) < 2 {
+		log.Println("Missing domain or path")
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
+			"error": "Missing domain or path",
+		})
+		return
+	}
+
+	domain := data[0].(string)
+	path := data[1].(string)
+	asOf, err := parseAsOf(data, 2)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	requests, err := a.sitemapClient.GetRequestsByEndpoint(domain, path, asOf)
+	if err != nil {
+		log.Printf("Error fetching requests: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
+			"error": fmt.Sprintf("Failed to fetch requests: %v", err),
+		})
+		return
+	}
+
+	// The statistics only add to the list, it is sent without them on failure
+	stats, err := a.sitemapClient.GetEndpointStats(domain, path, asOf)
+	if err != nil {
+		log.Printf("Error computing endpoint statistics: %v", err)
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:requestsByEndpoint", map[string]interface{}{
+		"requests": requests,
+		"stats":    stats,
+	})
+}
+
+// runBackupProbe requests backup copies of the sitemap paths of a domain
+// under a path, such as index.php.bak or an archive of their directory
+func (a *App) runBackupProbe(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:backupProbe", map[string]interface{}{
+			"error": "Missing domain",
+		})
+		return
+	}
+	domain, ok := data[0].(string)
+	if !ok || domain == "" {
+		wailsRuntime.EventsEmit(a.ctx, "backend:backupProbe", map[string]interface{}{
+			"error": "Invalid domain",
+		})
+		return
+	}
+	path := "/"
+	if len(data) > 1 {
+		if p, ok := data[1].(string); ok && p != "" {
+			path = p
+		}
+	}
+
+	go func() {
+		hits, err := backupprobe.NewProber(a.db, a.findingsClient).Run(a.ctx, domain, path, func(done, total int) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:backupProbeProgress", map[string]interface{}{
+				"done":  done,
+				"total": total,
+			})
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:backupProbe", map[string]interface{}{
+				"error": "Backup probe failed: " + err.Error(),
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:backupProbe", map[string]interface{}{
+			"domain": domain,
+			"hits":   hits,
+		})
+		a.getFindings()
+	}()
+}
+
+func (a *App) createChatContext(data ...interface{}) {
+	var requestString string
+	if len(data) > 0 {
+		if rs, ok := data[0].(string); ok {
+			requestString = rs
+		}
+	}
+
+	id, err := a.llmClient.CreateChatContext(requestString)
+	if err != nil {
+		log.Printf("Failed to create chat context: %v", err)
+		return
+	}
+
+	if requestString != "" {
+		// Get settings for the initial message
+		settings, err := a.loadSettingsFromDB()
+		if err != nil {
+			log.Printf("Failed to load settings: %v", err)
+			return
+		}
+
+		settingsMap := map[string]interface{}{
+			"OpenAIAPIURL": settings.OpenAIAPIURL,
+			"OpenAIAPIKey": settings.OpenAIAPIKey,
+		}
+
+		message := fmt.Sprintf("Analyze the following HTTP:\n\n%s", requestString)
+		err = a.llmClient.SendMessage(map[string]interface{}{
+			"chatContextId": float64(id),
+			"messages": []interface{}{
+				map[string]interface{}{
+					"role":    "user",
+					"content": message,
+				},
+			},
+		}, settingsMap)
+		if err != nil {
+			log.Printf("Failed to send initial message: %v", err)
+		}
+	}
+}
+
+func (a *App) deleteChatContext(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing chat context ID")
+		return
+	}
+	chatContextId, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid chat context ID")
+		return
+	}
+
+	err := a.llmClient.DeleteChatContext(int(chatContextId))
+	if err != nil {
+		log.Printf("Failed to delete chat context: %v", err)
+	}
+}
+
+func (a *App) editChatContextName(data ...interface{}) {
+	if len(data) < 2 {
+		log.Println("Missing chat context ID or new name")
+		return
+	}
+	chatContextId, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid chat context ID")
+		return
+	}
+	newName, ok := data[1].(string)
+	if !ok {
+		log.Println("Invalid new name")
+		return
+	}
+
+	err := a.llmClient.EditChatContextName(int(chatContextId), newName)
+	if err != nil {
+		log.Printf("Failed to edit chat context name: %v", err)
+	}
+}
+
+func (a *App) getChatContexts(data ...interface{}) {
+	contexts, err := a.llmClient.GetChatContexts()
+	if err != nil {
+		log.Printf("Failed to get chat contexts: %v", err)
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:chatContexts", contexts)
+}
+
+func (a *App) getChatMessages(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing chat context ID")
+		return
+	}
+	chatContextId, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid chat context ID")
+		return
+	}
+
+	messages, err := a.llmClient.GetChatMessages(int(chatContextId))
+	if err != nil {
+		log.Printf("Failed to get chat messages: %v", err)
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:chatMessages", map[string]interface{}{
+		"chatContextId": int(chatContextId),
+		"messages":      messages,
+	})
+}
+
+// runCaptureDiagnostics checks whether devices on the LAN can use the proxy
+func (a *App) runCaptureDiagnostics(data ...interface{}) {
+	settings, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:captureDiagnostics", map[string]interface{}{
+			"error": "Failed to load settings: " + err.Error(),
+		})
+		return
+	}
+
+	// The checks make network calls, so keep them off the event loop
+	go func() {
+		report := captureguide.Run(settings.BindAddress, settings.ProxyPort)
+		wailsRuntime.EventsEmit(a.ctx, "backend:captureDiagnostics", report)
+	}()
+}
+
+// setMetricsServer starts or stops the localhost-only server exposing
+// Prometheus metrics at /metrics and pprof profiles at /debug/pprof/
+func (a *App) setMetricsServer(data ...interface{}) {
+	enabled, port := false, ""
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			enabled, _ = options["enabled"].(bool)
+			port, _ = options["port"].(string)
+		}
+	}
+
+	if !enabled {
+		if err := a.metricsServer.Stop(); err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:metricsServer", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		a.getMetricsServer()
+		return
+	}
+
+	address, err := a.metricsServer.Start(port)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:metricsServer", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.logger.LogMessage("info", fmt.Sprintf("Metrics server listening on http://%s/metrics", address), "Metrics")
+	a.getMetricsServer()
+}
+
+func (a *App) getMetricsServer(data ...interface{}) {
+	address := a.metricsServer.Address()
+	wailsRuntime.EventsEmit(a.ctx, "backend:metricsServer", map[string]interface{}{
+		"enabled": address != "",
+		"address": address,
+	})
+}
+
+// runLoadTest pushes synthetic traffic through the running proxy and storage
+// and reports the highest sustainable request rate and the slowest stage. The
+// synthetic requests are removed from the project afterwards.
+func (a *App) runLoadTest(data ...interface{}) {
+	config := loadtest.Config{}
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			if seconds, ok := options["stepSeconds"].(float64); ok {
+				config.StepDuration = time.Duration(seconds * float64(time.Second))
+			}
+			if concurrency, ok := options["maxConcurrency"].(float64); ok {
+				config.MaxConcurrency = int(concurrency)
+			}
+		}
+	}
+
+	settings, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:loadTestReport", map[string]interface{}{
+			"error": "Failed to load settings: " + err.Error(),
+		})
+		return
+	}
+	config.ProxyHost, config.ProxyPort = proxy.LocalHost(settings.BindAddress), settings.ProxyPort
+
+	if !a.loadTestMutex.TryLock() {
+		wailsRuntime.EventsEmit(a.ctx, "backend:loadTestReport", map[string]interface{}{
+			"error": "A load test is already running",
+		})
+		return
+	}
+	defer a.loadTestMutex.Unlock()
+
+	a.logger.LogMessage("info", "Load test started", "LoadTest")
+	report, err := loadtest.Run(a.ctx, config, a.metrics, func(step loadtest.Step) {
+		wailsRuntime.EventsEmit(a.ctx, "backend:loadTestProgress", step)
+	})
+
+	if report != nil {
+		// Storage is asynchronous, wait for the last writes before cleaning up
+		a.waitForStorageIdle(10 * time.Second)
+		if deleted, err := a.requestStorage.DeleteByURLPrefix(report.TargetURL); err != nil {
+			log.Printf("Failed to remove load test requests: %v", err)
+		} else {
+			log.Printf("Removed %d load test requests", deleted)
+		}
+	}
+
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:loadTestReport", map[string]interface{}{
+			"error":  err.Error(),
+			"report": report,
+		})
+		return
+	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("Load test finished: %.0f requests/s at %d clients, bottleneck: %s", report.MaxRPS, report.MaxConcurrency, report.Bottleneck), "LoadTest")
+	wailsRuntime.EventsEmit(a.ctx, "backend:loadTestReport", map[string]interface{}{
+		"report":    report,
+		"recording": a.proxy.GetRecordingState(),
+	})
+}
+
+// waitForStorageIdle waits until no request has been stored for half a second
+func (a *App) waitForStorageIdle(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	last := a.metrics.Snapshot()[metrics.StageDBWrite].Count
+	for time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+		current := a.metrics.Snapshot()[metrics.StageDBWrite].Count
+		if current == last {
+			return
+		}
+		last = current
+	}
+}
+
+// exportDiagnostics writes a zip with the logs, redacted settings, version,
+// OS details and database schema for attaching to bug reports. The file is
+// chosen with a save dialog unless a path is given.
+func (a *App) exportDiagnostics(data ...interface{}) {
+	path := ""
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			path, _ = options["path"].(string)
+		}
+	}
+	if path == "" {
+		var err error
+		path, err = wailsRuntime.SaveFileDialog(a.ctx, wailsRuntime.SaveDialogOptions{
+			Title:           "Export diagnostic bundle",
+			DefaultFilename: fmt.Sprintf("prokzee-diagnostics-%s.zip", time.Now().Format("20060102-150405")),
+			Filters:         []wailsRuntime.FileFilter{{DisplayName: "Zip archives", Pattern: "*.zip"}},
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:diagnosticsExported", map[string]interface{}{
+				"error": "Failed to choose a file: " + err.Error(),
+			})
+			return
+		}
+		if path == "" {
+			return // cancelled
+		}
+	}
+
+	info := diagnostics.Info{Version: a.version}
+	if settings, err := a.settingsClient.LoadSettings(); err == nil {
+		info.ProjectName = settings.ProjectName
+		info.Settings = settings
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:diagnosticsExported", map[string]interface{}{
+			"error": "Failed to create bundle: " + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	a.dbMutex.RLock()
+	err = diagnostics.WriteBundle(file, info, a.db)
+	a.dbMutex.RUnlock()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:diagnosticsExported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("Diagnostic bundle exported to %s", path), "Diagnostics")
+	wailsRuntime.EventsEmit(a.ctx, "backend:diagnosticsExported", map[string]interface{}{
+		"path": path,
+	})
+}
+
+// exportReplayScript writes a filtered slice of history as a Go test, Python
+// or k6 script that replays it in order, extracting the values issued by
+// responses at runtime. The file is chosen with a save dialog unless a path is
+// given.
+func (a *App) exportReplayScript(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+			"error": "Missing export options",
+		})
+		return
+	}
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+			"error": "Invalid export options format",
+		})
+		return
+	}
+
+	format, _ := options["format"].(string)
+	if format == "" {
+		format = replayscript.FormatPython
+	}
+	var filter replayscript.Filter
+	filter.Search, _ = options["search"].(string)
+	filter.Domain, _ = options["domain"].(string)
+	if idList, ok := options["ids"].([]interface{}); ok {
+		for _, item := range idList {
+			if id, ok := item.(float64); ok {
+				filter.IDs = append(filter.IDs, int(id))
+			}
+		}
+	}
+
+	a.dbMutex.RLock()
+	steps, err := replayscript.Load(a.db, filter)
+	a.dbMutex.RUnlock()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	script := replayscript.New(steps)
+	content, err := script.Render(format)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	path, _ := options["path"].(string)
+	if path == "" {
+		path, err = wailsRuntime.SaveFileDialog(a.ctx, wailsRuntime.SaveDialogOptions{
+			Title:           "Export replay script",
+			DefaultFilename: "replay" + replayscript.Extension(format),
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+				"error": "Failed to choose a file: " + err.Error(),
+			})
+			return
+		}
+		if path == "" {
+			return // cancelled
+		}
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+			"error": "Failed to write script: " + err.Error(),
+		})
+		return
+	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("Replay script with %d requests exported to %s", len(steps), path), "History")
+	wailsRuntime.EventsEmit(a.ctx, "backend:replayScriptExported", map[string]interface{}{
+		"path":      path,
+		"format":    format,
+		"requests":  len(steps),
+		"variables": script.Variables,
+	})
+}
+
+// exportRawEntry writes the raw bytes of a history entry, for evidence or
+// other raw HTTP tools: the request and response to .req and .res files, or
+// both to one .http file
+func (a *App) exportRawEntry(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+			"error": "Missing export options",
+		})
+		return
+	}
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+			"error": "Invalid export options format",
+		})
+		return
+	}
+	id, ok := options["id"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+			"error": "Invalid or missing request ID",
+		})
+		return
+	}
+	format, _ := options["format"].(string)
+	if format == "" {
+		format = rawexport.FormatSplit
+	}
+	if format != rawexport.FormatSplit && format != rawexport.FormatCombined {
+		wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+			"error": "Unknown export format: " + format,
+		})
+		return
+	}
+
+	a.dbMutex.RLock()
+	entry, err := rawexport.Load(a.db, a.requestStorage.BlobPath, int(id))
+	a.dbMutex.RUnlock()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	extension := rawexport.RequestExtension
+	if format == rawexport.FormatCombined {
+		extension = rawexport.CombinedExtension
+	}
+	path, _ := options["path"].(string)
+	if path == "" {
+		path, err = wailsRuntime.SaveFileDialog(a.ctx, wailsRuntime.SaveDialogOptions{
+			Title:           "Export raw request and response",
+			DefaultFilename: fmt.Sprintf("request-%d%s", entry.ID, extension),
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+				"error": "Failed to choose a file: " + err.Error(),
+			})
+			return
+		}
+		if path == "" {
+			return // cancelled
+		}
+	}
+
+	// The response of a split export goes next to the request
+	paths, contents := []string{path}, [][]byte{entry.Combined()}
+	if format == rawexport.FormatSplit {
+		contents[0] = entry.Request
+		if entry.Response != nil {
+			paths = append(paths, strings.TrimSuffix(path, filepath.Ext(path))+rawexport.ResponseExtension)
+			contents = append(contents, entry.Response)
+		}
+	}
+	for i, file := range paths {
+		if err := os.WriteFile(file, contents[i], 0644); err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+				"error": "Failed to write file: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("Raw bytes of request %d exported to %s", entry.ID, strings.Join(paths, ", ")), "History")
+	wailsRuntime.EventsEmit(a.ctx, "backend:rawEntryExported", map[string]interface{}{
+		"id":         entry.ID,
+		"format":     format,
+		"paths":      paths,
+		"incomplete": entry.Incomplete,
+	})
+}
+
+// exportRequestAs writes a request of the history or of the resender as a
+// curl command, a Python requests script or a Go program
+func (a *App) exportRequestAs(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestExportedAs", map[string]interface{}{
+			"error": "Missing export options",
+		})
+		return
+	}
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestExportedAs", map[string]interface{}{
+			"error": "Invalid export options format",
+		})
+		return
+	}
+	id, ok := options["id"].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestExportedAs", map[string]interface{}{
+			"error": "Invalid or missing request ID",
+		})
+		return
+	}
+	source, _ := options["source"].(string)
+	if source == "" {
+		source = snippet.SourceHistory
+	}
+	format, _ := options["format"].(string)
+	if format == "" {
+		format = snippet.FormatCurl
+	}
+
+	var req *snippet.Request
+	var err error
+	a.dbMutex.RLock()
+	switch source {
+	case snippet.SourceHistory:
+		req, err = snippet.LoadHistory(a.db, a.requestStorage.BlobPath, int(id))
+	case snippet.SourceResender:
+		req, err = snippet.LoadResender(a.db, int(id))
+	default:
+		err = fmt.Errorf("unknown request source: %s", source)
+	}
+	a.dbMutex.RUnlock()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestExportedAs", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	code, err := snippet.Render(req, format)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestExportedAs", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:requestExportedAs", map[string]interface{}{
+		"id":      int(id),
+		"source":  source,
+		"format":  format,
+		"snippet": code,
+	})
+}
+
+// copyToClipboard copies requests of the history, the site map or the
+// resender to the clipboard as raw HTTP, curl commands, a URL list or a
+// Markdown table. The site map copies the requests of a domain, or of one
+// path of it.
+func (a *App) copyToClipboard(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:copiedToClipboard", map[string]interface{}{
+			"error": "Missing copy options",
+		})
+		return
+	}
+	options, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:copiedToClipboard", map[string]interface{}{
+			"error": "Invalid copy options format",
+		})
+		return
+	}
+	source, _ := options["source"].(string)
+	if source == "" {
+		source = snippet.SourceHistory
+	}
+	format, _ := options["format"].(string)
+	if format == "" {
+		format = copyformat.FormatRaw
+	}
+	var ids []int
+	if idList, ok := options["ids"].([]interface{}); ok {
+		for _, item := range idList {
+			if id, ok := item.(float64); ok {
+				ids = append(ids, int(id))
+			}
+		}
+	}
+
+	// Site map entries are requests of the history
+	if source == "sitemap" {
+		domain, _ := options["domain"].(string)
+		path, _ := options["path"].(string)
+		var requests []sitemap.RequestInfo
+		var err error
+		if path != "" {
+			requests, err = a.sitemapClient.GetRequestsByEndpoint(domain, path, time.Time{})
+		} else {
+			requests, err = a.sitemapClient.GetRequestsByDomain(domain)
+		}
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:copiedToClipboard", map[string]interface{}{
+				"error": "Failed to fetch requests: " + err.Error(),
+			})
+			return
+		}
+		for _, request := range requests {
+			ids = append(ids, request.ID)
+		}
+		source = snippet.SourceHistory
+	}
+
+	a.dbMutex.RLock()
+	text, err := copyformat.Build(a.db, a.requestStorage.BlobPath, source, ids, format)
+	a.dbMutex.RUnlock()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:copiedToClipboard", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := wailsRuntime.ClipboardSetText(a.ctx, text); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:copiedToClipboard", map[string]interface{}{
+			"error": "Failed to copy to the clipboard: " + err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:copiedToClipboard", map[string]interface{}{
+		"source": source,
+		"format": format,
+		"count":  len(ids),
+	})
+}
+
+// runHeaderAudit builds a security header compliance matrix for the captured hosts.
+// Only in-scope hosts are audited unless inScopeOnly is false.
+func (a *App) runHeaderAudit(data ...interface{}) {
+	inScopeOnly := true
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			if value, ok := options["inScopeOnly"].(bool); ok {
+				inScopeOnly = value
+			}
+		}
+	}
+
+	var filter func(host string) bool
+	if inScopeOnly {
+		filter = a.scopeClient.IsInScope
+	}
+
+	go func() {
+		report, err := headeraudit.NewAuditor(a.db).Run(filter)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:headerAudit", map[string]interface{}{
+				"error": "Failed to run header audit: " + err.Error(),
+			})
+			return
+		}
+
+		csvReport, err := report.CSV()
+		if err != nil {
+			log.Printf("Failed to render header audit as CSV: %v", err)
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:headerAudit", map[string]interface{}{
+			"report":   report,
+			"csv":      csvReport,
+			"markdown": report.Markdown(),
+		})
+	}()
+}
+
+// getTrafficData sends traffic data to the frontend
+func (a *App) getTrafficData(optionalData ...interface{}) {
+	// Example traffic data
+	trafficData := models.TrafficData{
+		ID:              "1",
+		URL:             "http://example.com",
+		Method:          "GET",
+		RequestHeaders:  "{}",
+		RequestBody:     "",
+		ResponseHeaders: "{}",
+		ResponseBody:    "Hello, world!",
+		Status:          "200 OK",
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:trafficData", trafficData)
+}
+
+// func (a *App) test(data ...interface{}) {
+// 	fmt.Print(data...)
+// }
+
+func (a *App) loadPluginsFromDB(optionalData ...interface{}) {
+	plugins, err := a.pluginsClient.LoadPlugins()
+	if err != nil {
+		log.Printf("Failed to load plugins: %v", err)
+		return
+	}
+
+	// Convert plugins to JSON and emit event
+	pluginsJSON, err := json.Marshal(plugins)
+	if err != nil {
+		log.Printf("Failed to marshal plugins: %v", err)
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "pluginsLoaded", string(pluginsJSON))
+}
+
+func (a *App) savePlugin(optionalData ...interface{}) {
+	if len(optionalData) < 1 {
+		log.Println("Missing plugin data")
+		return
+	}
+
+	pluginData, ok := optionalData[0].(string)
+	if !ok {
+		log.Println("Invalid plugin data format")
+		return
+	}
+
+	plugin, err := a.pluginsClient.SavePlugin(pluginData)
+	if err != nil {
+		log.Printf("Failed to save plugin: %v", err)
+		return
+	}
+
+	// Convert plugin to JSON and emit event
+	pluginJSON, err := json.Marshal(plugin)
+	if err != nil {
+		log.Printf("Failed to marshal plugin: %v", err)
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "pluginSaved", string(pluginJSON))
+}
+
+func (a *App) updatePlugin(optionalData ...interface{}) {
+	if len(optionalData) < 1 {
+		log.Println("Missing plugin data")
+		return
+	}
+
+	pluginData, ok := optionalData[0].(string)
+	if !ok {
+		log.Println("Invalid plugin data format")
+		return
+	}
+
+	plugin, err := a.pluginsClient.UpdatePlugin(pluginData)
+	if err == plugins.ErrConfirmationRequired {
+		// Show the scan so the user can confirm activation with frontend:confirmPlugin
+		var requested plugins.Plugin
+		if err := json.Unmarshal([]byte(pluginData), &requested); err != nil {
+			log.Printf("Failed to parse plugin data: %v", err)
+			return
+		}
+		wailsRuntime.EventsEmit(a.ctx, "pluginConfirmationRequired", map[string]interface{}{
+			"id":   requested.ID,
+			"scan": plugins.ScanCode(requested.Code),
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update plugin: %v", err)
+		return
+	}
+
+	// Convert plugin to JSON and emit event
+	pluginJSON, err := json.Marshal(plugin)
+	if err != nil {
+		log.Printf("Failed to marshal plugin: %v", err)
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "pluginUpdated", string(pluginJSON))
+}
+
+func (a *App) confirmPlugin(optionalData ...interface{}) {
+	if len(optionalData) < 1 {
+		log.Println("Missing plugin ID")
+		return
+	}
+
+	pluginID, ok := optionalData[0].(float64)
+	if !ok {
+		log.Println("Invalid plugin ID format")
+		return
+	}
+
+	plugin, err := a.pluginsClient.ConfirmPlugin(int(pluginID))
+	if err != nil {
+		log.Printf("Failed to confirm plugin: %v", err)
+		return
+	}
+
+	pluginJSON, err := json.Marshal(plugin)
+	if err != nil {
+		log.Printf("Failed to marshal plugin: %v", err)
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "pluginUpdated", string(pluginJSON))
+}
+
+func (a *App) deletePlugin(optionalData ...interface{}) {
+	if len(optionalData) < 1 {
+		log.Println("Missing plugin ID")
+		return
+	}
+
+	pluginID, ok := optionalData[0].(float64)
+	if !ok {
+		log.Println("Invalid plugin ID format")
+		return
+	}
+
+	err := a.pluginsClient.DeletePlugin(int(pluginID))
+	if err != nil {
+		log.Printf("Failed to delete plugin: %v", err)
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "pluginDeleted", int(pluginID))
+}
+
+// fetchSettings fetches the settings from the database
+func (a *App) fetchSettings(data ...interface{}) {
+	settings, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:fetchSettings", map[string]interface{}{
+			"error": "Failed to fetch settings: " + err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:fetchSettings", settings)
+}
+
+// updateSettings updates the settings in the database
+func (a *App) updateSettings(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Missing settings data",
+		})
+		return
+	}
+	settingsData, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Invalid settings data format",
+		})
+		return
+	}
+
+	// Fields left out of the update keep their current value
+	settings, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Failed to load settings: " + err.Error(),
+		})
+		return
+	}
+	if id, ok := settingsData["id"].(float64); ok {
+		settings.ID = int(id)
+	}
+	if projectName, ok := settingsData["project_name"].(string); ok {
+		settings.ProjectName = projectName
+	}
+	if openAIAPIURL, ok := settingsData["openai_api_url"].(string); ok {
+		settings.OpenAIAPIURL = openAIAPIURL
+	}
+	if openAIAPIKey, ok := settingsData["openai_api_key"].(string); ok {
+		settings.OpenAIAPIKey = openAIAPIKey
+	}
+	if proxyPort, ok := settingsData["proxy_port"].(string); ok {
+		settings.ProxyPort = proxyPort
+	}
+	if interactshHost, ok := settingsData["interactsh_host"].(string); ok {
+		settings.InteractshHost = interactshHost
+	}
+	if interactshPort, ok := settingsData["interactsh_port"].(float64); ok {
+		settings.InteractshPort = int(interactshPort)
+	}
+	if createdAt, ok := settingsData["created_at"].(string); ok {
+		settings.CreatedAt = createdAt
+	}
+	if upstreamProxy, ok := settingsData["upstream_proxy"].(string); ok {
+		settings.UpstreamProxy = upstreamProxy
+	}
+	if upstreamProxyBypass, ok := settingsData["upstream_proxy_bypass"].(string); ok {
+		settings.UpstreamProxyBypass = upstreamProxyBypass
+	}
+	// The passthrough list is also edited on its own, keep it unless given
+	if passthrough, ok := settingsData["tls_passthrough"].(string); ok {
+		settings.TLSPassthrough = passthrough
+	}
+	if hostOverrides, ok := settingsData["host_overrides"].(string); ok {
+		settings.HostOverrides = hostOverrides
+	}
+	if dnsServer, ok := settingsData["dns_server"].(string); ok {
+		settings.DNSServer = dnsServer
+	}
+	if maxBodySize, ok := settingsData["max_stored_body_size"].(float64); ok {
+		settings.MaxStoredBodySize = int64(maxBodySize)
+	}
+	if forceClose, ok := settingsData["force_connection_close"].(bool); ok {
+		settings.ForceConnectionClose = forceClose
+	}
+	if projectCA, ok := settingsData["project_ca"].(bool); ok {
+		settings.ProjectCA = projectCA
+	}
+	if tlsProfile, ok := settingsData["tls_profile"].(string); ok {
+		settings.TLSProfile = tlsProfile
+	}
+	if correlationHeader, ok := settingsData["correlation_header"].(string); ok {
+		settings.CorrelationHeader = correlationHeader
+	}
+	if bindAddress, ok := settingsData["bind_address"].(string); ok {
+		settings.BindAddress = bindAddress
+	}
+	if maxRequestBody, ok := settingsData["max_request_body_size"].(float64); ok {
+		settings.MaxRequestBodySize = int64(maxRequestBody)
+	}
+	if maxResponseBody, ok := settingsData["max_response_body_size"].(float64); ok {
+		settings.MaxResponseBodySize = int64(maxResponseBody)
+	}
+	if settings.MaxStoredBodySize < 0 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Maximum stored body size cannot be negative",
+		})
+		return
+	}
+	if settings.MaxRequestBodySize < 0 || settings.MaxResponseBodySize < 0 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Maximum request and response body sizes cannot be negative",
+		})
+		return
+	}
+
+	if _, err := upstream.ParseChain(settings.UpstreamProxy, settings.UpstreamProxyBypass); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if _, err := upstream.ParseResolver(settings.HostOverrides, settings.DNSServer); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if _, err := upstream.ParseTLSProfile(settings.TLSProfile); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if _, err := proxy.ParseTLSPassthrough(settings.TLSPassthrough); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if _, err := proxy.ParseCorrelationHeader(settings.CorrelationHeader); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if _, err := proxy.ParseBindAddress(settings.BindAddress); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := a.settingsClient.UpdateSettings(settings); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Failed to update settings: " + err.Error(),
+		})
+		return
+	}
+
+	// Update the client with the new host and port
+	a.listener.UpdateHostAndPort(settings.InteractshHost, settings.InteractshPort)
+	a.applyUpstreamProxy(settings)
+	a.applyResolver(settings)
+	a.applyTLSProfile(settings)
+	a.proxy.SetTLSPassthrough(settings.TLSPassthrough)
+	a.proxy.SetCorrelationHeader(settings.CorrelationHeader)
+	a.requestStorage.SetMaxBodySize(settings.MaxStoredBodySize)
+	a.proxy.SetForceConnectionClose(settings.ForceConnectionClose)
+	a.proxy.SetBodyLimits(proxy.BodyLimits{Request: settings.MaxRequestBodySize, Response: settings.MaxResponseBodySize})
+	if err := a.applyProjectCA(settings); err != nil {
+		a.logger.LogMessage("error", "Failed to switch the CA: "+err.Error(), "Settings")
+	}
+
+	// The other settings apply to the running proxy, only a new address or
+	// port moves it
+	if err := a.proxy.Rebind(settings.BindAddress, settings.ProxyPort); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+			"error": "Settings saved but the proxy keeps listening where it was: " + err.Error(),
+		})
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:updateSettings", map[string]interface{}{
+		"success": true,
+	})
+}
+
+// emitProxyListenError tells the frontend the proxy could not listen where
+// the settings ask, with what to change
+func (a *App) emitProxyListenError(err error) {
+	a.logger.LogMessage("error", "Proxy not listening: "+err.Error(), "Proxy")
+	wailsRuntime.EventsEmit(a.ctx, "backend:proxyListenError", map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func (a *App) loadSettingsFromDB() (*settings.Settings, error) {
+	return a.settingsClient.LoadSettings()
+}
+
+// applyUpstreamProxy routes the outbound traffic of the proxy, Resender and
+// Fuzzer through the upstream proxy of the settings
+func (a *App) applyUpstreamProxy(settings *settings.Settings) error {
+	chain, err := upstream.ParseChain(settings.UpstreamProxy, settings.UpstreamProxyBypass)
+	if err != nil {
+		upstream.SetChain(nil)
+		return err
+	}
+	upstream.SetChain(chain)
+	// Pooled connections go through the previous chain
+	a.proxy.CloseIdleConnections()
+	if chain != nil {
+		log.Printf("Routing outbound traffic through upstream proxy %s", chain)
+	}
+	return nil
+}
+
+// applyResolver resolves the hosts the proxy, Resender and Fuzzer connect to
+// with the host overrides and DNS server of the settings
+func (a *App) applyResolver(settings *settings.Settings) error {
+	resolver, err := upstream.ParseResolver(settings.HostOverrides, settings.DNSServer)
+	if err != nil {
+		upstream.SetResolver(nil)
+		return err
+	}
+	upstream.SetResolver(resolver)
+	// Pooled connections go to the previously resolved addresses
+	a.proxy.CloseIdleConnections()
+	if resolver != nil {
+		log.Printf("Resolving hosts with %d overrides and DNS server %q", len(resolver.Overrides()), settings.DNSServer)
+	}
+	return nil
+}
+
+// applyTLSProfile shapes the ClientHello the proxy, Resender and Fuzzer send
+// upstream after the TLS profile of the settings
+func (a *App) applyTLSProfile(settings *settings.Settings) error {
+	profile, err := upstream.ParseTLSProfile(settings.TLSProfile)
+	if err != nil {
+		upstream.SetTLSProfile(nil)
+		return err
+	}
+	upstream.SetTLSProfile(profile)
+	// Pooled connections were made with the previous profile
+	a.proxy.CloseIdleConnections()
+	if profile != nil {
+		log.Printf("Using TLS profile %q for upstream connections", profile.Name)
+	}
+	return nil
+}
+
+// getTLSProfiles returns the preset TLS profiles and the JA3 and JA4
+// fingerprints of a profile, the one given as a preset name or JSON or the
+// one in use
+func (a *App) getTLSProfiles(data ...interface{}) {
+	profile := upstream.CurrentTLSProfile()
+	if len(data) > 0 {
+		if raw, ok := data[0].(string); ok {
+			parsed, err := upstream.ParseTLSProfile(raw)
+			if err != nil {
+				wailsRuntime.EventsEmit(a.ctx, "backend:tlsProfiles", map[string]interface{}{
+					"error":   err.Error(),
+					"presets": upstream.TLSPresets,
+				})
+				return
+			}
+			profile = parsed
+		}
+	}
+
+	result := map[string]interface{}{
+		"presets": upstream.TLSPresets,
+		"profile": profile,
+	}
+	if fingerprint, err := upstream.Fingerprint(profile); err != nil {
+		result["error"] = "Failed to compute the fingerprint: " + err.Error()
+	} else {
+		result["fingerprint"] = fingerprint
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:tlsProfiles", result)
+}
+
+// caDir returns where the CA the settings ask for is stored: next to the
+// project for a project CA, in the data directory otherwise
+func (a *App) caDir(settings *settings.Settings) string {
+	if settings.ProjectCA {
+		a.projectMu.RLock()
+		defer a.projectMu.RUnlock()
+		return certificate.ProjectDirFor(a.projectPath)
+	}
+	return setup.CertsDir(setup.DataDir())
+}
+
+// applyProjectCA signs intercepted hosts with the project CA or the shared
+// one, as the settings ask, creating the CA on first use
+func (a *App) applyProjectCA(settings *settings.Settings) error {
+	dir := a.caDir(settings)
+	if dir == a.proxy.CertManager.CurrentDir() && a.proxy.CertManager.GetCertificate() != nil {
+		return nil
+	}
+	a.proxy.CertManager.SetDir(dir)
+	if err := a.proxy.SetupCertificates(); err != nil {
+		return err
+	}
+	log.Printf("Signing intercepted hosts with the CA in %s", dir)
+	return nil
+}
+
+// addTLSPassthroughHost adds a host to the TLS passthrough list of the
+// project, so its connections are tunnelled without interception. The host is
+// given directly or taken from the message of a failed handshake log entry.
+func (a *App) addTLSPassthroughHost(data ...interface{}) {
+	options := map[string]interface{}{}
+	if len(data) > 0 {
+		if value, ok := data[0].(map[string]interface{}); ok {
+			options = value
+		}
+	}
+	host, _ := options["host"].(string)
+	if message, _ := options["message"].(string); host == "" && message != "" {
+		host = proxy.HandshakeFailureHost(message)
+	}
+	if strings.TrimSpace(host) == "" {
+		wailsRuntime.EventsEmit(a.ctx, "backend:tlsPassthroughUpdated", map[string]interface{}{
+			"error": "No host given",
+		})
+		return
+	}
+
+	settings, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:tlsPassthroughUpdated", map[string]interface{}{
+			"error": "Failed to load settings: " + err.Error(),
+		})
+		return
+	}
+	pattern := proxy.PassthroughPattern(host)
+	exists := false
+	for _, line := range strings.Split(settings.TLSPassthrough, "\n") {
+		if strings.TrimSpace(line) == pattern {
+			exists = true
+		}
+	}
+	if !exists {
+		settings.TLSPassthrough = strings.TrimRight(settings.TLSPassthrough, "\n")
+		if settings.TLSPassthrough != "" {
+			settings.TLSPassthrough += "\n"
+		}
+		settings.TLSPassthrough += pattern
+		if err := a.settingsClient.UpdateSettings(settings); err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:tlsPassthroughUpdated", map[string]interface{}{
+				"error": "Failed to update settings: " + err.Error(),
+			})
+			return
+		}
+		if err := a.proxy.SetTLSPassthrough(settings.TLSPassthrough); err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:tlsPassthroughUpdated", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		a.logger.LogMessage("info", fmt.Sprintf("TLS connections to %s are now tunnelled without interception", host), "TLS")
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:tlsPassthroughUpdated", map[string]interface{}{
+		"pattern":         pattern,
+		"tls_passthrough": settings.TLSPassthrough,
+	})
+}
+
+// removeOrphanBlobs deletes the body blobs left behind by deleted requests
+func removeOrphanBlobs(requestStorage *storage.RequestStorage) {
+	removed, err := requestStorage.RemoveOrphanBlobs()
+	if err != nil {
+		log.Printf("Failed to remove orphaned body blobs: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Removed %d orphaned body blobs", removed)
+	}
+}
+
+// applyClientCertificates makes the proxy, Resender and Fuzzer present the
+// client certificates of the project to their hosts
+func (a *App) applyClientCertificates() error {
+	certificates, err := a.clientCertsClient.Upstream()
+	if err != nil {
+		upstream.SetClientCertificates(nil)
+		return err
+	}
+	upstream.SetClientCertificates(certificates)
+	// Pooled connections were made with the previous certificates
+	a.proxy.CloseIdleConnections()
+	return nil
+}
+
+// getClientCertificates sends the client certificates of the project
+func (a *App) getClientCertificates(data ...interface{}) {
+	certificates, err := a.clientCertsClient.GetCertificates()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+			"error": "Failed to fetch client certificates: " + err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+		"certificates": certificates,
+	})
+}
+
+// addClientCertificate maps a client certificate to a host. The certificate is
+// given as a PEM certificate and key, or as a base64 encoded PKCS#12 file with
+// its password.
+func (a *App) addClientCertificate(data ...interface{}) {
+	options := map[string]interface{}{}
+	if len(data) > 0 {
+		if value, ok := data[0].(map[string]interface{}); ok {
+			options = value
+		}
+	}
+	host, _ := options["host"].(string)
+	certPEM, _ := options["certPem"].(string)
+	keyPEM, _ := options["keyPem"].(string)
+	if pkcs12Data, _ := options["pkcs12"].(string); pkcs12Data != "" {
+		password, _ := options["password"].(string)
+		var err error
+		certPEM, keyPEM, err = clientcert.ParsePKCS12(pkcs12Data, password)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := a.clientCertsClient.AddCertificate(host, certPEM, keyPEM); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := a.applyClientCertificates(); err != nil {
+		log.Printf("Failed to apply client certificates: %v", err)
+	}
+	a.getClientCertificates()
+}
+
+// deleteClientCertificate removes a client certificate
+func (a *App) deleteClientCertificate(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+			"error": "Missing certificate ID",
+		})
+		return
+	}
+	id, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+			"error": "Invalid certificate ID",
+		})
+		return
+	}
+
+	if err := a.clientCertsClient.DeleteCertificate(int(id)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:clientCertificates", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := a.applyClientCertificates(); err != nil {
+		log.Printf("Failed to apply client certificates: %v", err)
+	}
+	a.getClientCertificates()
+}
+
+// proxyComponents returns the project-bound clients used by the proxy handlers
+func (a *App) proxyComponents() proxy.Components {
+	return proxy.Components{
+		Scope:        a.scopeClient,
+		MatchReplace: a.matchReplaceClient,
+		MapLocal:     a.mapLocalClient,
+		Chaos:        a.chaosClient,
+		Rules:        a.rulesClient,
+		Edits:        a.interceptEditsClient,
+		Logger:       a.logger,
+	}
+}
+
+// listProjects handles the event to list all projects
+func (a *App) listProjects(data ...interface{}) {
+	projects, err := a.projectsClient.ListProjects()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:listProjects", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Projects other instances have open, so another one can be picked
+	openElsewhere := map[string]*instancelock.Owner{}
+	for _, project := range projects {
+		if holder := instancelock.HeldBy(filepath.Join(a.projectsClient.ProjectsDir(), project)); holder != nil {
+			openElsewhere[project] = holder
+		}
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:listProjects", map[string]interface{}{
+		"projects":      projects,
+		"openElsewhere": openElsewhere,
+	})
+}
+
+// projectLockState describes whether this instance holds the current project
+func (a *App) projectLockState() map[string]interface{} {
+	a.projectMu.RLock()
+	lock, path := a.projectLock, a.projectPath
+	a.projectMu.RUnlock()
+
+	state := map[string]interface{}{
+		"project": filepath.Base(path),
+		"held":    lock != nil,
+	}
+	if lock != nil {
+		state["owner"] = lock.Owner()
+	} else if holder := instancelock.HeldBy(path); holder != nil {
+		state["heldBy"] = holder
+	}
+	return state
+}
+
+// emitProjectLocked warns that another instance holds the current project, so
+// it can be taken over or another project opened
+func (a *App) emitProjectLocked() {
+	state := a.projectLockState()
+	a.logger.LogMessage("warning", fmt.Sprintf("Project %s is open in another instance, nothing is captured until it is taken over", state["project"]), "Projects")
+	wailsRuntime.EventsEmit(a.ctx, "backend:projectLocked", state)
+}
+
+// watchProjectLock stops capturing into the project once another instance
+// takes it over
+func (a *App) watchProjectLock(lock *instancelock.Lock) {
+	lock.OnLost(func(taker instancelock.Owner) {
+		a.projectMu.Lock()
+		current := a.projectLock == lock
+		if current {
+			a.projectLock = nil
+		}
+		a.projectMu.Unlock()
+		if !current {
+			return
+		}
+		if err := a.proxy.StopServer(); err != nil {
+			log.Printf("Warning: error stopping the proxy after losing the project: %v", err)
+		}
+		a.logger.LogMessage("warning", fmt.Sprintf("Project taken over by process %d on %s, the proxy was stopped", taker.PID, taker.Host), "Projects")
+		wailsRuntime.EventsEmit(a.ctx, "backend:projectLockLost", map[string]interface{}{
+			"project": filepath.Base(a.projectPath),
+			"heldBy":  taker,
+		})
+	})
+}
+
+// getProjectLock reports whether this instance holds the current project
+func (a *App) getProjectLock(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:projectLock", a.projectLockState())
+}
+
+// takeOverProject takes the current project from the instance holding it and
+// starts capturing. That instance stops its proxy on its next heartbeat.
+func (a *App) takeOverProject(data ...interface{}) {
+	a.projectMu.Lock()
+	if a.projectLock != nil {
+		a.projectMu.Unlock()
+		a.getProjectLock()
+		return
+	}
+	lock, err := instancelock.Acquire(a.projectPath, true)
+	if err != nil {
+		a.projectMu.Unlock()
+		wailsRuntime.EventsEmit(a.ctx, "backend:projectLock", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.projectLock = lock
+	a.projectMu.Unlock()
+	a.watchProjectLock(lock)
+
+	settings, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		log.Printf("Warning: Failed to load settings after taking over the project: %v", err)
+	} else if err := a.proxy.StartServer(settings.BindAddress, settings.ProxyPort); err != nil {
+		a.emitProxyListenError(err)
+	}
+	a.getProjectLock()
+}
+
+// switchProject switches to the selected database. The new project is opened
+// and initialized while the proxy keeps serving the current one, so a failure
+// leaves the current project in place. The proxy listener and the held
+// intercepts survive the switch, and the old database is closed once its
+// pending writes are done.
+func (a *App) switchProject(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
+			"error": "Missing database name",
+		})
+		return
+	}
+	dbName, ok := data[0].(string)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
+			"error": "Invalid database name",
+		})
+		return
+	}
+
+	// Lock the project first, a project open in another instance is only
+	// switched to when taking it over
+	takeover := len(data) > 1 && data[1] == true
+	newLock, err := instancelock.Acquire(filepath.Join(a.projectsClient.ProjectsDir(), dbName), takeover)
+	if err != nil {
+		result := map[string]interface{}{
+			"error":       err.Error(),
+			"projectName": dbName,
+		}
+		var held *instancelock.HeldError
+		if errors.As(err, &held) {
+			result["locked"] = true
+			result["heldBy"] = held.Owner
+		}
+		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", result)
+		return
+	}
+
+	// Create new database connection
+	newDB, err := a.projectsClient.OpenProject(dbName)
+	if err != nil {
+		newLock.Release()
+		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// abort reports an initialization failure and stays on the current project
+	abort := func(message string, err error) {
+		newDB.Close()
+		newLock.Release()
+		wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
+			"error": message + err.Error(),
+		})
+	}
+
+	// Initialize all database-dependent components of the new project
+	blobDir := storage.BlobDirFor(filepath.Join(a.projectsClient.ProjectsDir(), dbName))
+	requestStorage := storage.NewRequestStorage(newDB, &a.dbMutex)
+	if err := requestStorage.EnsureTableExists(); err != nil {
+		abort("Failed to initialize requests table: ", err)
+		return
+	}
+	requestStorage.SetBlobDir(blobDir)
+	historyClient, err := history.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize history client: ", err)
+		return
+	}
+	historyClient.SetBlobDir(blobDir)
+	pluginsClient, err := plugins.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize plugins client: ", err)
+		return
+	}
+	rulesClient, err := rules.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize rules client: ", err)
+		return
+	}
+	interceptEditsClient, err := intercept.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize intercept edits client: ", err)
+		return
+	}
+	interceptEditsClient.SetDoNotLog(requestStorage.IsDoNotLog)
+	matchReplaceClient, err := matchreplace.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize match replace client: ", err)
+		return
+	}
+	mapLocalClient, err := maplocal.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize map local client: ", err)
+		return
+	}
+	chaosClient, err := chaos.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize chaos client: ", err)
+		return
+	}
+	tagClient, err := tagging.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize tagging client: ", err)
+		return
+	}
+	requestStorage.SetTagger(tagClient)
+	scopeClient, err := scope.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize scope client: ", err)
+		return
+	}
+	sitemapClient, err := sitemap.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize sitemap client: ", err)
+		return
+	}
+	settingsClient, err := settings.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize settings client: ", err)
+		return
+	}
+	favoritesClient, err := favorites.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize favorites client: ", err)
+		return
+	}
+	findingsClient, err := findings.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize findings client: ", err)
+		return
+	}
+	changeDetector, err := changedetect.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize change detection client: ", err)
+		return
+	}
+	hostInfoClient, err := hostinfo.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize host info client: ", err)
+		return
+	}
+	clientCertsClient, err := clientcert.NewClient(newDB)
+	if err != nil {
+		abort("Failed to initialize client certificates client: ", err)
+		return
+	}
+
+	// Load settings from the new database
+	settings, err := settingsClient.LoadSettings()
+	if err != nil {
+		abort("Failed to load settings: ", err)
+		return
+	}
+	requestStorage.SetMaxBodySize(settings.MaxStoredBodySize)
+	removeOrphanBlobs(requestStorage)
+
+	// Tell the frontend to clear its state
+	wailsRuntime.EventsEmit(a.ctx, "backend:clearState", nil)
+
+	// Pause running fuzzers so their checkpoints are written to the old project
+	if a.fuzzer != nil {
+		a.fuzzer.PauseAll()
+	}
+
+	// Hand over to the new project. Requests already in the proxy finish with
+	// the components they started with.
+	a.projectMu.Lock()
+	oldDB, oldStorage := a.db, a.requestStorage
+	a.db = newDB
+	a.requestStorage = requestStorage
+	a.historyClient = historyClient
+	a.pluginsClient = pluginsClient
+	a.rulesClient = rulesClient
+	a.interceptEditsClient = interceptEditsClient
+	a.matchReplaceClient = matchReplaceClient
+	a.mapLocalClient = mapLocalClient
+	a.chaosClient = chaosClient
+	a.tagClient = tagClient
+	a.scopeClient = scopeClient
+	a.sitemapClient = sitemapClient
+	a.settingsClient = settingsClient
+	a.favoritesClient = favoritesClient
+	a.findingsClient = findingsClient
+	a.changeDetector = changeDetector
+	a.hostInfoClient = hostInfoClient
+	a.clientCertsClient = clientCertsClient
+	a.projectsClient = projects.NewClient(a.ctx, newDB, &a.dbMutex)
+	a.fuzzer = fuzzer.NewFuzzer(a.ctx, newDB)
+	a.resender = resender.NewResender(a.ctx, newDB, requestStorage)
+	a.llmClient = llm.NewClient(a.ctx, newDB)
+	a.logger.RefreshConnection(newDB)
+	a.projectPath = filepath.Join(a.projectsClient.ProjectsDir(), dbName)
+	oldLock := a.projectLock
+	a.projectLock = newLock
+	a.projectMu.Unlock()
+
+	a.watchProjectLock(newLock)
+	if oldLock != nil {
+		if err := oldLock.Release(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if err := a.resender.EnsureSchema(); err != nil {
+		log.Printf("Warning: Failed to migrate resender tables: %v", err)
+	}
+	if err := a.logger.EnsureLogsTableExists(); err != nil {
+		log.Printf("Warning: Failed to create logs table: %v", err)
+	}
+	a.proxy.SetComponents(a.proxyComponents())
+	if err := a.applyUpstreamProxy(settings); err != nil {
+		log.Printf("Warning: Ignoring the upstream proxy setting: %v", err)
+	}
+	if err := a.applyResolver(settings); err != nil {
+		log.Printf("Warning: Ignoring the host overrides and DNS server settings: %v", err)
+	}
+	if err := a.applyTLSProfile(settings); err != nil {
+		log.Printf("Warning: Ignoring the TLS profile setting: %v", err)
+	}
+	if err := a.proxy.SetCorrelationHeader(settings.CorrelationHeader); err != nil {
+		log.Printf("Warning: Ignoring the correlation header setting: %v", err)
+	}
+	if err := a.proxy.SetTLSPassthrough(settings.TLSPassthrough); err != nil {
+		log.Printf("Warning: Ignoring the TLS passthrough setting: %v", err)
+	}
+	if err := a.applyClientCertificates(); err != nil {
+		log.Printf("Warning: Ignoring the client certificates: %v", err)
+	}
+	a.proxy.SetForceConnectionClose(settings.ForceConnectionClose)
+	a.proxy.SetBodyLimits(proxy.BodyLimits{Request: settings.MaxRequestBodySize, Response: settings.MaxResponseBodySize})
+	if err := a.applyProjectCA(settings); err != nil {
+		log.Printf("Warning: Ignoring the project CA setting: %v", err)
+	}
+
+	// Keep the listener unless the new project uses another address or port
+	if err := a.proxy.Rebind(settings.BindAddress, settings.ProxyPort); err != nil {
+		log.Printf("Warning: Keeping the proxy on port %s: %v", a.proxy.Port(), err)
+		a.emitProxyListenError(err)
+	}
+
+	// Let the pending writes of the old project finish before closing it
+	go func() {
+		if pending := oldStorage.Drain(storageDrainTimeout); pending > 0 {
+			log.Printf("Warning: closing previous project with %d captured requests not stored", pending)
+		}
+		if err := oldDB.Close(); err != nil {
+			log.Printf("Warning: error closing previous project database: %v", err)
+		}
+	}()
+
+	// Reinitialize listener with new settings
+	a.listener = listener.NewClient(a.ctx, settings.InteractshHost, settings.InteractshPort)
+	a.listener.GenerateKeys()
+
+	// Emit success event with the new project name
+	wailsRuntime.EventsEmit(a.ctx, "backend:switchProject", map[string]interface{}{
+		"success":     true,
+		"projectName": dbName,
+	})
+
+	// Emit events to refresh all data
+	a.getAllRequests()             // Refresh requests
+	a.getAllRules(nil)             // Refresh rules
+	a.getAllMatchReplaceRules(nil) // Refresh match/replace rules
+	a.getScopeLists(nil)           // Refresh scope lists
+	a.getFuzzerTabs(nil)           // Refresh fuzzer tabs
+	a.getChatContexts(nil)         // Refresh chat contexts
+	a.loadPluginsFromDB(nil)       // Refresh plugins
+	a.fetchSettings(nil)           // Refresh settings
+	a.getDomains(nil)              // Refresh domains
+	a.getRecentLogs(nil)           // Refresh logs
+	a.proxy.ReemitHeld(a.ctx)      // Show the intercepts still waiting for approval
+
+	// Refresh resender tabs
+	if tabs, err := a.resender.GetTabs(); err == nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderTabs", tabs)
+	} else {
+		log.Printf("Warning: Failed to refresh resender tabs: %v", err)
+	}
+}
+
+// createNewProject creates a new SQLite database in the projects_data folder and initializes it with default data
+func (a *App) createNewProject(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
+			"error": "Missing project name",
+		})
+		return
+	}
+	projectName, ok := data[0].(string)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
+			"error": "Invalid project name",
+		})
+		return
+	}
+
+	err := a.projectsClient.CreateNewProject(projectName)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:createNewProject", map[string]interface{}{
+		"success": true,
+	})
+}
+
+func (a *App) getRequestsByDomain(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByDomain", map[string]interface{}{
+			"error": "Missing domain",
+		})
+		return
+	}
+
+	domain := data[0].(string)
+
+	requests, err := a.sitemapClient.GetRequestsByDomain(domain)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:requestsByDomain", map[string]interface{}{
+			"error": "Failed to fetch requests by domain: " + err.Error(),
+		})
+		return
+	}
+
+	wailsRuntime.EventsEmit(a.ctx, "backend:requestsByDomain", map[string]interface{}{
+		"requests": requests,
+	})
+}
+
+// Add this new method to handle log retrieval
+func (a *App) getRecentLogs(data ...interface{}) {
+	var params map[string]interface{}
+	if len(data) > 0 {
+		if p, ok := data[0].(map[string]interface{}); ok {
+			params = p
+		}
+	}
+
+	result := a.logger.GetRecentLogs(params)
+	wailsRuntime.EventsEmit(a.ctx, "backend:logs", result)
+}
+
+// Add this function after the startup function
+func (a *App) startChannelCleanupRoutine() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.cleanupStaleChannels()
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// interceptAlarmThreshold is how long before the approval timeout a held
+// request raises an alarm
+const interceptAlarmThreshold = time.Minute
+
+// startInterceptMonitor periodically reports the intercept queue to the
+// frontend and raises an alarm for every held request about to time out
+func (a *App) startInterceptMonitor() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		alarmed := make(map[string]bool)
+		lastHeld := 0
+		for {
+			select {
+			case <-ticker.C:
+				held := a.proxy.HeldRequests()
+				if len(held) > 0 || lastHeld > 0 {
+					a.emitInterceptQueue(held)
+				}
+				lastHeld = len(held)
+
+				stillHeld := make(map[string]bool, len(held))
+				for _, req := range held {
+					stillHeld[req.RequestID] = true
+					if alarmed[req.RequestID] || req.RemainingSeconds > int(interceptAlarmThreshold.Seconds()) {
+						continue
+					}
+					alarmed[req.RequestID] = true
+					a.logger.LogMessage("warning", fmt.Sprintf("Intercepted %s %s will time out in %ds unless it is forwarded or dropped",
+						req.Method, req.URL, req.RemainingSeconds), "Interceptor")
+					wailsRuntime.EventsEmit(a.ctx, "backend:interceptStuckRequest", req)
+				}
+				for requestID := range alarmed {
+					if !stillHeld[requestID] {
+						delete(alarmed, requestID)
+					}
+				}
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// statsInterval is how often the proxy stats are sent to the frontend
+const statsInterval = 2 * time.Second
+
+// startStatsEmitter periodically sends the proxy stats to the frontend
+func (a *App) startStatsEmitter() {
+	go func() {
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				wailsRuntime.EventsEmit(a.ctx, "backend:proxyStats", a.proxy.Stats())
+			case <-a.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// getStats sends the current proxy stats to the frontend
+func (a *App) getStats(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:proxyStats", a.proxy.Stats())
+}
+
+// emitInterceptQueue sends the number and ages of the held requests to the frontend
+func (a *App) emitInterceptQueue(held []proxy.HeldRequest) {
+	oldest := 0
+	if len(held) > 0 {
+		oldest = held[0].AgeSeconds
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptQueue", map[string]interface{}{
+		"held":             len(held),
+		"oldestAgeSeconds": oldest,
+		"timeoutSeconds":   int(proxy.ApprovalTimeout.Seconds()),
+		"requests":         held,
+	})
+}
+
+func (a *App) getInterceptQueue(data ...interface{}) {
+	a.emitInterceptQueue(a.proxy.HeldRequests())
+}
+
+// heldRequestIDs reads a request ID or a list of them from event data
+func heldRequestIDs(data []interface{}) []string {
+	if len(data) < 1 {
+		return nil
+	}
+	switch value := data[0].(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case []interface{}:
+		var ids []string
+		for _, item := range value {
+			if id, ok := item.(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	return nil
+}
+
+// emitInterceptReleased tells the frontend which held requests left the
+// queue, then sends the queue that remains
+func (a *App) emitInterceptReleased(forwarded, dropped []string) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptReleased", map[string]interface{}{
+		"forwarded": forwarded,
+		"dropped":   dropped,
+	})
+	a.emitInterceptQueue(a.proxy.HeldRequests())
+}
+
+// forwardHeld forwards the given held requests unchanged, in queue order
+func (a *App) forwardHeld(data ...interface{}) {
+	ids := heldRequestIDs(data)
+	if len(ids) == 0 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:interceptReleased", map[string]interface{}{
+			"error": "No held request IDs provided",
+		})
+		return
+	}
+	a.emitInterceptReleased(a.proxy.ForwardHeld(ids), []string{})
+}
+
+// dropHeld drops the given held requests
+func (a *App) dropHeld(data ...interface{}) {
+	ids := heldRequestIDs(data)
+	if len(ids) == 0 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:interceptReleased", map[string]interface{}{
+			"error": "No held request IDs provided",
+		})
+		return
+	}
+	a.emitInterceptReleased([]string{}, a.proxy.DropHeldRequests(ids))
+}
+
+// forwardAll forwards every held request unchanged, in queue order
+func (a *App) forwardAll(data ...interface{}) {
+	forwarded := a.proxy.ForwardHeld(nil)
+	if len(forwarded) > 0 {
+		a.logger.LogMessage("info", fmt.Sprintf("Forwarded %d held requests", len(forwarded)), "Interceptor")
+	}
+	a.emitInterceptReleased(forwarded, []string{})
+}
+
+// dropAll drops every held request
+func (a *App) dropAll(data ...interface{}) {
+	dropped := a.proxy.DropHeldRequests(nil)
+	if len(dropped) > 0 {
+		a.logger.LogMessage("info", fmt.Sprintf("Dropped %d held requests", len(dropped)), "Interceptor")
+	}
+	a.emitInterceptReleased([]string{}, dropped)
+}
+
+// reorderInterceptQueue moves the given held requests to the front of the
+// queue in the given order
+func (a *App) reorderInterceptQueue(data ...interface{}) {
+	a.proxy.ReorderHeld(heldRequestIDs(data))
+	a.emitInterceptQueue(a.proxy.HeldRequests())
+}
+
+// getInterceptEdits returns the intercepted requests that were modified
+// before being forwarded, with the original, edited version and their diff
+func (a *App) getInterceptEdits(data ...interface{}) {
+	limit := 0
+	if len(data) > 0 {
+		if value, ok := data[0].(float64); ok {
+			limit = int(value)
+		}
+	}
+
+	edits, err := a.interceptEditsClient.GetEdits(limit)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:interceptEdits", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptEdits", map[string]interface{}{
+		"edits": edits,
+	})
+}
+
+// getDoNotLog returns the hosts and paths whose bodies are never persisted
+func (a *App) getDoNotLog(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+		"rules": a.requestStorage.GetDoNotLog(),
+	})
+}
+
+// addDoNotLog marks a host, optionally limited to a path prefix, as do-not-log.
+// Its requests are then stored with headers only.
+func (a *App) addDoNotLog(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+			"error": "Missing do-not-log data",
+		})
+		return
+	}
+	params, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+			"error": "Invalid do-not-log data format",
+		})
+		return
+	}
+	host, _ := params["host"].(string)
+	path, _ := params["path"].(string)
+
+	if _, err := a.requestStorage.AddDoNotLog(host, path); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.getDoNotLog()
+}
+
+func (a *App) deleteDoNotLog(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+			"error": "Missing do-not-log rule ID",
+		})
+		return
+	}
+	id, ok := data[0].(float64)
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+			"error": "Invalid do-not-log rule ID",
+		})
+		return
+	}
+
+	if err := a.requestStorage.DeleteDoNotLog(int(id)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:doNotLog", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.getDoNotLog()
+}
+
+func (a *App) deleteInterceptEdit(data ...interface{}) {
+	if len(data) == 0 {
+		log.Println("Error: No intercept edit ID provided")
+		return
+	}
+	id, ok := data[0].(float64)
+	if !ok {
+		log.Println("Error: Invalid intercept edit ID")
+		return
+	}
+
+	if err := a.interceptEditsClient.DeleteEdit(int(id)); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:interceptEdits", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.getInterceptEdits()
+}
+
+// Add this function to clean up stale channels
+func (a *App) cleanupStaleChannels() {
+	log.Println("Running cleanup of stale approval channels")
+
+	// Get the current time
+	now := time.Now()
+
+	// Track how many channels were cleaned up
+	cleanedCount := 0
+
+	// Lock both maps to ensure consistency
+	a.proxy.ApprovalChsM.Lock()
+	a.proxy.PendingRequestsM.Lock()
+
+	// Find stale requests (those held past the approval timeout)
+	staleRequestIDs := []string{}
+	for requestID, req := range a.proxy.PendingRequests {
+		// If the request outlived the approval timeout, its handler is gone
+		if req.Context().Value(models.CreationTimeKey) != nil {
+			creationTime, ok := req.Context().Value(models.CreationTimeKey).(time.Time)
+			if ok && now.Sub(creationTime) > proxy.ApprovalTimeout+time.Minute {
+				staleRequestIDs = append(staleRequestIDs, requestID)
+			}
+		}
+	}
+
+	// Clean up stale requests and their channels
+	for _, requestID := range staleRequestIDs {
+		delete(a.proxy.PendingRequests, requestID)
+		if ch, exists := a.proxy.ApprovalChs[requestID]; exists {
+			delete(a.proxy.ApprovalChs, requestID)
+			cleanedCount++
+
+			// Try to close the channel by sending a timeout response
+			select {
+			case ch <- proxy.ApprovalResponse{Approved: false}:
+				// Successfully sent a response
+			default:
+				// Channel is already closed or full, nothing to do
+			}
+		}
+	}
+
+	a.proxy.PendingRequestsM.Unlock()
+	a.proxy.ApprovalChsM.Unlock()
+
+	if cleanedCount > 0 {
+		log.Printf("Cleaned up %d stale approval channels", cleanedCount)
+	}
+}
+
+// setupCertificates checks if certificate files exist, and if not, generates new ones
+func (a *App) setupCertificates() {
+	if err := a.proxy.SetupCertificates(); err != nil {
+		log.Fatalf("Failed to setup certificates: %v", err)
+	}
+}
+
+// The first-run wizard is a sequence of bound methods: GetSetupState, then
+// SetDataDirectory, SetProxyPort, GenerateCA or ImportCA, the optional
+// ConfigureBrowser and finally CompleteSetup. Every step can be repeated until
+// CompleteSetup.
+
+// errSetupComplete is returned by the steps of the wizard once it is done.
+// Bound methods can be called from any frame of the webview, unlike the
+// privileged events the settings make the same changes through.
+var errSetupComplete = errors.New("setup is already complete, change this in the settings")
+
+// checkWizardOpen fails once the first-run wizard is completed
+func checkWizardOpen() error {
+	config, err := setup.Load()
+	if err != nil {
+		return err
+	}
+	if config.Completed {
+		return errSetupComplete
+	}
+	return nil
+}
+
+// GetSetupState returns what the first-run wizard needs to render its steps
+func (a *App) GetSetupState() (setup.State, error) {
+	config, err := setup.Load()
+	state := setup.State{
+		Completed:      config.Completed,
+		DataDir:        setup.DataDir(),
+		DefaultDataDir: setup.DefaultDataDir(),
+		ProxyPort:      a.proxy.Port(),
+		CA:             a.proxy.CertManager.Info(),
+		Browsers:       setup.DetectBrowsers(),
+	}
+	return state, err
+}
+
+// ChooseDataDirectory asks for a directory to hold the projects and the CA.
+// It returns "" when the dialog is cancelled.
+func (a *App) ChooseDataDirectory() (string, error) {
+	return wailsRuntime.OpenDirectoryDialog(a.ctx, wailsRuntime.OpenDialogOptions{
+		Title:                "Choose the ProKZee data directory",
+		DefaultDirectory:     setup.DataDir(),
+		CanCreateDirectories: true,
+	})
+}
+
+// SetDataDirectory moves new projects and the CA to dir and switches to its
+// default project. Existing projects are not moved.
+func (a *App) SetDataDirectory(dir string) (setup.State, error) {
+	a.setupMutex.Lock()
+	defer a.setupMutex.Unlock()
+	if err := checkWizardOpen(); err != nil {
+		return a.setupResult(err)
+	}
+
+	dir, err := setup.ValidateDataDir(dir)
+	if err != nil {
+		return a.setupResult(err)
+	}
+	if dir == setup.DataDir() {
+		return a.setupResult(nil)
+	}
+
+	config, err := setup.Load()
+	if err != nil {
+		return a.setupResult(err)
+	}
+	config.DataDir = dir
+	if err := setup.Save(config); err != nil {
+		return a.setupResult(err)
+	}
+
+	if err := a.projectsClient.SetProjectsDir(setup.ProjectsDir(dir)); err != nil {
+		return a.setupResult(err)
+	}
+	// Use the CA of the new directory, or create one there
+	a.proxy.CertManager.SetDir(setup.CertsDir(dir))
+	if err := a.proxy.SetupCertificates(); err != nil {
+		return a.setupResult(err)
+	}
+	a.logger.LogMessage("info", fmt.Sprintf("Data directory changed to %s", dir), "Setup")
+
+	// Reported to the frontend through backend:switchProject
+	a.switchProject(setup.DefaultProject)
+	return a.setupResult(nil)
+}
+
+// setupResult returns the wizard state along with the outcome of a step
+func (a *App) setupResult(err error) (setup.State, error) {
+	state, stateErr := a.GetSetupState()
+	if err == nil {
+		err = stateErr
+	}
+	return state, err
+}
+
+// CheckProxyPort reports whether the proxy could listen on port
+func (a *App) CheckProxyPort(port string) setup.PortCheck {
+	return setup.CheckPort(a.proxy.BindAddress(), strings.TrimSpace(port), a.proxy.Port())
+}
+
+// SetProxyPort saves the proxy port of the current project and restarts the
+// listener on it
+func (a *App) SetProxyPort(port string) (setup.State, error) {
+	a.setupMutex.Lock()
+	defer a.setupMutex.Unlock()
+	if err := checkWizardOpen(); err != nil {
+		return a.setupResult(err)
+	}
+
+	port = strings.TrimSpace(port)
+	current, err := a.settingsClient.LoadSettings()
+	if err != nil {
+		return a.setupResult(err)
+	}
+	if check := setup.CheckPort(current.BindAddress, port, a.proxy.Port()); !check.Available {
+		return a.setupResult(fmt.Errorf("port %s is not available: %s", port, check.Error))
+	}
+
+	current.ProxyPort = port
+	if err := a.settingsClient.UpdateSettings(current); err != nil {
+		return a.setupResult(err)
+	}
+
+	return a.setupResult(a.proxy.Rebind(current.BindAddress, port))
+}
+
+// getCAInfo sends the CA in use to the frontend
+func (a *App) getCAInfo(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:caInfo", map[string]interface{}{
+		"ca": a.proxy.CertManager.Info(),
+	})
+}
+
+// regenerateCA replaces the CA in use, the project CA when the project has
+// one, with a new one
+func (a *App) regenerateCA(data ...interface{}) {
+	a.setupMutex.Lock()
+	err := a.proxy.CertManager.GenerateCA()
+	a.setupMutex.Unlock()
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:caInfo", map[string]interface{}{
+			"error": "Failed to regenerate the CA: " + err.Error(),
+		})
+		return
+	}
+	a.logger.LogMessage("info", "Regenerated the CA certificate, devices must trust the new one", "Certificates")
+	a.getCAInfo()
+}
+
+// exportCA writes the CA certificate in PEM, DER or PKCS#12 format to a file
+// chosen by the user
+func (a *App) exportCA(data ...interface{}) {
+	format, password := certificate.FormatPEM, ""
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			if f, ok := options["format"].(string); ok && f != "" {
+				format = strings.ToLower(f)
+			}
+			password, _ = options["password"].(string)
+		}
+	}
+
+	content, err := a.proxy.CertManager.Export(format, password)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:caExported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	path, err := wailsRuntime.SaveFileDialog(a.ctx, wailsRuntime.SaveDialogOptions{
+		Title:           "Export CA certificate",
+		DefaultFilename: "prokzee-ca." + format,
+	})
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:caExported", map[string]interface{}{
+			"error": "Failed to choose a file: " + err.Error(),
+		})
+		return
+	}
+	if path == "" {
+		return // cancelled
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:caExported", map[string]interface{}{
+			"error": "Failed to write certificate: " + err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:caExported", map[string]interface{}{
+		"path":   path,
+		"format": format,
+	})
+}
+
+// installCertificate adds the CA to the trust store of the operating system,
+// which may ask the user for an administrator password
+func (a *App) installCertificate(data ...interface{}) {
+	store, err := a.proxy.CertManager.InstallSystemTrust()
+	if err != nil {
+		a.logger.LogMessage("error", fmt.Sprintf("Failed to install the CA certificate: %v", err), "Certificates")
+		wailsRuntime.EventsEmit(a.ctx, "backend:certificateInstalled", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	a.logger.LogMessage("info", fmt.Sprintf("Installed the CA certificate in %s", store), "Certificates")
+	wailsRuntime.EventsEmit(a.ctx, "backend:certificateInstalled", map[string]interface{}{
+		"store": store,
+	})
+}
+
+// mintCertificate signs a server certificate for the given hosts with the CA
+// and writes it, followed by the CA, to a PEM file chosen by the user, with
+// its key in a .key file next to it
+func (a *App) mintCertificate(data ...interface{}) {
+	var request certificate.LeafRequest
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			request.Hosts = stringItems(options["hosts"])
+			if days, ok := options["days"].(float64); ok {
+				request.Days = int(days)
+			}
+			request.KeyType, _ = options["keyType"].(string)
+		}
+	}
+
+	leaf, err := a.proxy.CertManager.MintLeaf(request)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:certificateMinted", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	path, err := wailsRuntime.SaveFileDialog(a.ctx, wailsRuntime.SaveDialogOptions{
+		Title:           "Export certificate",
+		DefaultFilename: strings.ReplaceAll(leaf.Subject, "*", "wildcard") + ".pem",
+	})
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:certificateMinted", map[string]interface{}{
+			"error": "Failed to choose a file: " + err.Error(),
+		})
+		return
+	}
+	if path == "" {
+		return // cancelled
+	}
+	keyPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".key"
+
+	if err := os.WriteFile(path, []byte(leaf.ChainPEM), 0644); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:certificateMinted", map[string]interface{}{
+			"error": "Failed to write certificate: " + err.Error(),
+		})
+		return
+	}
+	if err := os.WriteFile(keyPath, []byte(leaf.KeyPEM), 0600); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:certificateMinted", map[string]interface{}{
+			"error": "Failed to write key: " + err.Error(),
+		})
+		return
+	}
+	a.logger.LogMessage("info", fmt.Sprintf("Minted a certificate for %s", strings.Join(append(leaf.DNSNames, leaf.IPAddresses...), ", ")), "Certificates")
+	wailsRuntime.EventsEmit(a.ctx, "backend:certificateMinted", map[string]interface{}{
+		"certificate": leaf,
+		"path":        path,
+		"keyPath":     keyPath,
+	})
+}
+
+// GenerateCA replaces the interception CA with a new one
+func (a *App) GenerateCA() (setup.State, error) {
+	a.setupMutex.Lock()
+	defer a.setupMutex.Unlock()
+	if err := checkWizardOpen(); err != nil {
+		return a.setupResult(err)
+	}
+
+	if err := a.proxy.CertManager.GenerateCA(); err != nil {
+		return a.setupResult(err)
+	}
+	a.logger.LogMessage("info", "Generated a new CA certificate", "Setup")
+	return a.setupResult(nil)
+}
+
+// ImportCA replaces the interception CA with a PEM certificate and key.
+// Empty paths are asked for with a file dialog.
+func (a *App) ImportCA(certPath, keyPath string) (setup.State, error) {
+	a.setupMutex.Lock()
+	defer a.setupMutex.Unlock()
+	if err := checkWizardOpen(); err != nil {
+		return a.setupResult(err)
+	}
+
+	pemFilter := []wailsRuntime.FileFilter{{DisplayName: "PEM files (*.pem, *.crt, *.key)", Pattern: "*.pem;*.crt;*.key"}}
+	var err error
+	if certPath == "" {
+		certPath, err = wailsRuntime.OpenFileDialog(a.ctx, wailsRuntime.OpenDialogOptions{Title: "Select the CA certificate", Filters: pemFilter})
+		if err != nil || certPath == "" {
+			return a.setupResult(err)
+		}
+	}
+	if keyPath == "" {
+		keyPath, err = wailsRuntime.OpenFileDialog(a.ctx, wailsRuntime.OpenDialogOptions{Title: "Select the CA private key", Filters: pemFilter})
+		if err != nil || keyPath == "" {
+			return a.setupResult(err)
+		}
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return a.setupResult(fmt.Errorf("failed to read CA certificate: %v", err))
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return a.setupResult(fmt.Errorf("failed to read CA key: %v", err))
+	}
+	if err := a.proxy.CertManager.ImportCA(certPEM, keyPEM); err != nil {
+		return a.setupResult(err)
+	}
+	a.logger.LogMessage("info", fmt.Sprintf("Imported CA certificate from %s", certPath), "Setup")
+	return a.setupResult(nil)
+}
+
+// ConfigureBrowser launches a detected browser, identified by its path, with
+// a dedicated profile that uses the proxy
+func (a *App) ConfigureBrowser(browserPath string) error {
+	if err := checkWizardOpen(); err != nil {
+		return err
+	}
+	port := a.proxy.Port()
+	if port == "" {
+		return fmt.Errorf("the proxy is not running")
+	}
+
+	for _, browser := range setup.DetectBrowsers() {
+		if browser.Path != browserPath {
+			continue
+		}
+		profile := strings.ToLower(strings.ReplaceAll(browser.Name, " ", "-"))
+		profileDir := filepath.Join(setup.DataDir(), "browsers", profile)
+		spkiHash := ""
+		if info := a.proxy.CertManager.Info(); info != nil {
+			spkiHash = info.SPKIHash
+		}
+		return setup.LaunchBrowser(browser, profileDir, proxy.LocalHost(a.proxy.BindAddress()), port, spkiHash)
+	}
+	return fmt.Errorf("browser not found: %s", browserPath)
+}
+
+// CompleteSetup records that the first-run wizard was finished
+func (a *App) CompleteSetup() (setup.State, error) {
+	a.setupMutex.Lock()
+	defer a.setupMutex.Unlock()
+
+	config, err := setup.Load()
+	if err != nil {
+		return a.setupResult(err)
+	}
+	config.Complete()
+	if err := setup.Save(config); err != nil {
+		return a.setupResult(err)
+	}
+	return a.setupResult(nil)
+}
+
+func (a *App) getAllRequests(data ...interface{}) {
+	var page int = 1
 	var limit int = 50
 	var sortKey string = "timestamp"
 	var sortDirection string = "descending"
 	var searchQuery string = ""
+	var tags []string
+	var asOf time.Time
+
+	if len(data) > 0 {
+		if params, ok := data[0].(map[string]interface{}); ok {
+			if p, ok := params["page"].(float64); ok {
+				page = int(p)
+			}
+			if l, ok := params["limit"].(float64); ok {
+				limit = int(l)
+			}
+			if sk, ok := params["sortKey"].(string); ok {
+				sortKey = sk
+			}
+			if sd, ok := params["sortDirection"].(string); ok {
+				sortDirection = sd
+			}
+			if sq, ok := params["searchQuery"].(string); ok {
+				searchQuery = sq
+			}
+			if list, ok := params["tags"].([]interface{}); ok {
+				for _, tag := range list {
+					if t, ok := tag.(string); ok && t != "" {
+						tags = append(tags, t)
+					}
+				}
+			}
+			if value, ok := params["asOf"].(string); ok {
+				parsed, err := asof.Parse(value)
+				if err != nil {
+					wailsRuntime.EventsEmit(a.ctx, "backend:allRequests", map[string]interface{}{
+						"error": err.Error(),
+					})
+					return
+				}
+				asOf = parsed
+			}
+		}
+	}
+
+	requests, pagination, err := a.historyClient.GetAllRequests(page, limit, sortKey, sortDirection, searchQuery, tags, asOf)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:allRequests", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	history.AnnotateDomains(requests, a.scopeClient.InScopeHosts())
+	wailsRuntime.EventsEmit(a.ctx, "backend:allRequests", map[string]interface{}{
+		"requests":   requests,
+		"pagination": pagination,
+	})
+}
+
+func (a *App) toggleInterception(data ...interface{}) {
+	newState := a.proxy.ToggleInterception()
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptionToggled", newState)
+}
+
+func (a *App) getInterceptionState(data ...interface{}) {
+	state := a.proxy.GetInterceptionState()
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptionState", state)
+}
+
+// getInterceptFilters returns the quick filters applied to intercepted requests
+func (a *App) getInterceptFilters(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptFilters", a.proxy.GetInterceptFilter())
+}
+
+// setInterceptFilters replaces the quick filters applied to intercepted
+// requests, given as {methods, paramsOnly, contentTypes}
+func (a *App) setInterceptFilters(data ...interface{}) {
+	filter := proxy.InterceptFilter{}
+	if len(data) > 0 {
+		if options, ok := data[0].(map[string]interface{}); ok {
+			filter.Methods = stringItems(options["methods"])
+			filter.ParamsOnly, _ = options["paramsOnly"].(bool)
+			filter.ContentTypes = stringItems(options["contentTypes"])
+		}
+	}
+	a.proxy.SetInterceptFilter(filter)
+	wailsRuntime.EventsEmit(a.ctx, "backend:interceptFilters", a.proxy.GetInterceptFilter())
+}
+
+// stringItems returns the strings of a list sent by the frontend
+func stringItems(value interface{}) []string {
+	items, _ := value.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (a *App) toggleRecording(data ...interface{}) {
+	newState := a.proxy.ToggleRecording()
+	wailsRuntime.EventsEmit(a.ctx, "backend:recordingToggled", newState)
+}
+
+func (a *App) getRecordingState(data ...interface{}) {
+	state := a.proxy.GetRecordingState()
+	wailsRuntime.EventsEmit(a.ctx, "backend:recordingState", state)
+}
+
+func (a *App) toggleHTTP3(data ...interface{}) {
+	a.proxy.ToggleHTTP3()
+	a.getHTTP3State()
+}
+
+func (a *App) getHTTP3State(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:http3State", map[string]interface{}{
+		"enabled": a.proxy.Upstream.Enabled(),
+		"hosts":   a.proxy.Upstream.Hosts(),
+	})
+}
+
+func (a *App) startDNSServer(data ...interface{}) {
+	if len(data) == 0 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:dnsServerState", map[string]interface{}{
+			"error": "No DNS server configuration provided",
+		})
+		return
+	}
+
+	configData, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:dnsServerState", map[string]interface{}{
+			"error": "Invalid DNS server configuration",
+		})
+		return
+	}
+
+	config := dnsserver.Config{}
+	config.ListenAddr, _ = configData["listenAddr"].(string)
+	config.AnswerIP, _ = configData["answerIP"].(string)
+	config.Upstream, _ = configData["upstream"].(string)
+	switch domains := configData["domains"].(type) {
+	case string:
+		for _, domain := range strings.Split(domains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				config.Domains = append(config.Domains, domain)
+			}
+		}
+	case []interface{}:
+		for _, domain := range domains {
+			if domainStr, ok := domain.(string); ok && domainStr != "" {
+				config.Domains = append(config.Domains, domainStr)
+			}
+		}
+	}
+
+	// Default to this machine's LAN address so devices reach the proxy
+	if config.AnswerIP == "" {
+		if addresses, err := captureguide.LANAddresses(); err == nil && len(addresses) > 0 {
+			config.AnswerIP = addresses[0]
+		}
+	}
+
+	if err := a.dnsServer.Start(config); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:dnsServerState", map[string]interface{}{
+			"error": "Failed to start DNS server: " + err.Error(),
+		})
+		return
+	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("DNS server started on %s", a.dnsServer.GetState().Config.ListenAddr), "DNS")
+	a.getDNSServerState()
+}
+
+func (a *App) stopDNSServer(data ...interface{}) {
+	a.dnsServer.Stop()
+	a.getDNSServerState()
+}
+
+func (a *App) getDNSServerState(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:dnsServerState", map[string]interface{}{
+		"state": a.dnsServer.GetState(),
+	})
+}
+
+func (a *App) getCurrentVersion(optionalData ...interface{}) {
+	version := "0.0.1" // Hardcoded current version
+	wailsRuntime.EventsEmit(a.ctx, "backend:currentVersion", version)
+}
+
+func (a *App) checkForUpdates(optionalData ...interface{}) {
+	currentVersion := a.version // Use the version from App struct
+
+	// Fetch latest version from GitHub
+	resp, err := http.Get("https://raw.githubusercontent.com/al-sultani/prokzee/main/version.txt")
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateCheck", map[string]interface{}{
+			"currentVersion":  currentVersion,
+			"latestVersion":   currentVersion,
+			"updateAvailable": false,
+			"error":           "Failed to check for updates: " + err.Error(),
+		})
+		return
+	}
+	defer resp.Body.Close()
 
-	if len(data) > 0 {
-		if params, ok := data[0].(map[string]interface{}); ok {
-			if p, ok := params["page"].(float64); ok {
-				page = int(p)
-			}
-			if l, ok := params["limit"].(float64); ok {
-				limit = int(l)
-			}
-			if sk, ok := params["sortKey"].(string); ok {
-				sortKey = sk
-			}
-			if sd, ok := params["sortDirection"].(string); ok {
-				sortDirection = sd
-			}
-			if sq, ok := params["searchQuery"].(string); ok {
-				searchQuery = sq
+	// Read the version from the response
+	versionBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:updateCheck", map[string]interface{}{
+			"currentVersion":  currentVersion,
+			"latestVersion":   currentVersion,
+			"updateAvailable": false,
+			"error":           "Failed to read version: " + err.Error(),
+		})
+		return
+	}
+
+	latestVersion := strings.TrimSpace(string(versionBytes))
+	fmt.Println(latestVersion)
+	// TODO: Remove this temporary workaround
+	latestVersion = "0.0.2"
+	wailsRuntime.EventsEmit(a.ctx, "backend:updateCheck", map[string]interface{}{
+		"currentVersion":  currentVersion,
+		"latestVersion":   latestVersion,
+		"updateAvailable": latestVersion != currentVersion,
+		"error":           nil,
+	})
+}
+
+// Add these new methods to the App struct
+func (a *App) handleCreateNewResenderTab(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing new tab data")
+		return
+	}
+	newTabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid new tab data format")
+		return
+	}
+	if err := a.resender.CreateNewTab(newTabData); err != nil {
+		log.Printf("Error creating new tab: %v", err)
+	}
+}
+
+func (a *App) handleSendToResender(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing request data")
+		return
+	}
+	requestData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid request data format")
+		return
+	}
+	if err := a.resender.SendToResender(requestData); err != nil {
+		log.Printf("Error sending to resender: %v", err)
+	}
+}
+
+func (a *App) handleGetResenderTabs(data ...interface{}) {
+	tabs, err := a.resender.GetTabs()
+	if err != nil {
+		log.Printf("Error getting resender tabs: %v", err)
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:resenderTabs", tabs)
+}
+
+func (a *App) handleUpdateResenderTabName(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab data")
+		return
+	}
+	tabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid tab data format")
+		return
+	}
+	tabId, ok := tabData["tabId"].(float64)
+	if !ok {
+		log.Println("Invalid or missing tabId")
+		return
+	}
+	newName, ok := tabData["newName"].(string)
+	if !ok {
+		log.Println("Invalid or missing newName")
+		return
+	}
+	if err := a.resender.UpdateTabName(int(tabId), newName); err != nil {
+		log.Printf("Error updating tab name: %v", err)
+	}
+}
+
+func (a *App) handleSendResenderRequest(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing request data")
+		return
+	}
+	requestData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid request data format")
+		return
+	}
+	tabId, ok := requestData["tabId"].(float64)
+	if !ok {
+		log.Println("Invalid tab ID")
+		return
+	}
+	requestDetails, ok := requestData["requestDetails"].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid request details")
+		return
+	}
+	if err := a.resender.SendRequest(tabId, requestDetails); err != nil {
+		log.Printf("Error sending request: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderResponse", map[string]interface{}{
+			"error": err.Error(),
+			"tabId": tabId,
+		})
+	}
+}
+
+// handleGetDeserializationPayloads returns the deserialization probe library
+func (a *App) handleGetDeserializationPayloads(data ...interface{}) {
+	wailsRuntime.EventsEmit(a.ctx, "backend:deserializationPayloads", deserialize.Payloads)
+}
+
+// handleRunDeserializationProbe runs the guided deserialization test on the
+// request of a resender tab, in the given parameters or in those holding
+// serialized values
+func (a *App) handleRunDeserializationProbe(data ...interface{}) {
+	if len(data) < 1 {
+		wailsRuntime.EventsEmit(a.ctx, "backend:deserializationProbe", map[string]interface{}{
+			"error": "Missing deserialization probe data",
+		})
+		return
+	}
+	requestData, ok := data[0].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:deserializationProbe", map[string]interface{}{
+			"error": "Invalid deserialization probe data format",
+		})
+		return
+	}
+	tabId, _ := requestData["tabId"].(float64)
+	requestDetails, ok := requestData["requestDetails"].(map[string]interface{})
+	if !ok {
+		wailsRuntime.EventsEmit(a.ctx, "backend:deserializationProbe", map[string]interface{}{
+			"error": "Invalid request details",
+			"tabId": tabId,
+		})
+		return
+	}
+
+	// Variables are expanded the way sending the tab would
+	request := deserialize.Request{Headers: make(map[string]string)}
+	if value, ok := requestDetails["url"].(string); ok {
+		request.URL = a.resender.Expand(value)
+	}
+	request.Method, _ = requestDetails["method"].(string)
+	if value, ok := requestDetails["body"].(string); ok {
+		request.Body = a.resender.Expand(value)
+	}
+	if headers, ok := requestDetails["headers"].(map[string]interface{}); ok {
+		for key, value := range headers {
+			if strValue, ok := value.(string); ok {
+				request.Headers[a.resender.Expand(key)] = a.resender.Expand(strValue)
 			}
 		}
 	}
+	names := stringItems(requestData["parameters"])
+	payloads := stringItems(requestData["payloads"])
+
+	go func() {
+		result, err := deserialize.NewProber(a.findingsClient).Run(a.ctx, request, names, payloads)
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:deserializationProbe", map[string]interface{}{
+				"error": "Deserialization probe failed: " + err.Error(),
+				"tabId": tabId,
+			})
+			return
+		}
+
+		wailsRuntime.EventsEmit(a.ctx, "backend:deserializationProbe", map[string]interface{}{
+			"result": result,
+			"tabId":  tabId,
+		})
+		a.getFindings()
+	}()
+}
+
+func (a *App) handleCancelResenderRequest(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing request data")
+		return
+	}
+	requestData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid request data format")
+		return
+	}
+	tabId, ok := requestData["tabId"].(float64)
+	if !ok {
+		log.Println("Invalid tab ID")
+		return
+	}
+	a.resender.CancelRequest(int(tabId))
+}
+
+func (a *App) handleGetResenderRequest(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing request ID")
+		return
+	}
+	log.Println("Received request ID:", data[0])
+	var requestID int
+	switch v := data[0].(type) {
+	case float64:
+		requestID = int(v)
+	case string:
+		var err error
+		requestID, err = strconv.Atoi(v)
+		if err != nil {
+			log.Println("Invalid request ID format")
+			return
+		}
+	default:
+		log.Println("Invalid request ID format")
+		return
+	}
+	if err := a.resender.GetRequest(requestID); err != nil {
+		log.Printf("Error getting request: %v", err)
+	}
+}
+
+func (a *App) handleDeleteResenderTab(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab ID")
+		return
+	}
+	tabID, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid tab ID format")
+		return
+	}
+	if err := a.resender.DeleteTab(int(tabID)); err != nil {
+		log.Printf("Error deleting tab: %v", err)
+	}
+}
+
+func (a *App) handleSetResenderTabGroup(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab data")
+		return
+	}
+	tabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid tab data format")
+		return
+	}
+	tabId, ok := tabData["tabId"].(float64)
+	if !ok {
+		log.Println("Invalid or missing tabId")
+		return
+	}
+	group, _ := tabData["group"].(string)
+	if err := a.resender.SetTabGroup(int(tabId), group); err != nil {
+		log.Printf("Error updating tab group: %v", err)
+		return
+	}
+	a.handleGetResenderTabs()
+}
+
+// handleSetResenderTabRedirects sets whether a resender tab follows redirects,
+// how many and whether each hop is kept
+func (a *App) handleSetResenderTabRedirects(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab data")
+		return
+	}
+	tabData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid tab data format")
+		return
+	}
+	tabId, ok := tabData["tabId"].(float64)
+	if !ok {
+		log.Println("Invalid or missing tabId")
+		return
+	}
+	var options resender.RedirectOptions
+	options.Follow, _ = tabData["follow"].(bool)
+	options.ShowChain, _ = tabData["showChain"].(bool)
+	if maxHops, ok := tabData["maxHops"].(float64); ok {
+		options.MaxHops = int(maxHops)
+	}
+	if err := a.resender.SetTabRedirects(int(tabId), options); err != nil {
+		log.Printf("Error updating tab redirect options: %v", err)
+		return
+	}
+	a.handleGetResenderTabs()
+}
 
-	requests, pagination, err := a.historyClient.GetAllRequests(page, limit, sortKey, sortDirection, searchQuery)
+// handleGetResenderTimings sends the timings of every send of a resender tab
+func (a *App) handleGetResenderTimings(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing tab ID")
+		return
+	}
+	tabId, ok := data[0].(float64)
+	if !ok {
+		log.Println("Invalid tab ID format")
+		return
+	}
+	timings, err := a.resender.GetTabTimings(int(tabId))
 	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:allRequests", map[string]interface{}{
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderTimings", map[string]interface{}{
+			"tabId": tabId,
 			"error": err.Error(),
 		})
 		return
 	}
-
-	wailsRuntime.EventsEmit(a.ctx, "backend:allRequests", map[string]interface{}{
-		"requests":   requests,
-		"pagination": pagination,
+	wailsRuntime.EventsEmit(a.ctx, "backend:resenderTimings", map[string]interface{}{
+		"tabId":   tabId,
+		"timings": timings,
 	})
 }
 
-func (a *App) toggleInterception(data ...interface{}) {
-	newState := a.proxy.ToggleInterception()
-	wailsRuntime.EventsEmit(a.ctx, "backend:interceptionToggled", newState)
-}
-
-func (a *App) getInterceptionState(data ...interface{}) {
-	state := a.proxy.GetInterceptionState()
-	wailsRuntime.EventsEmit(a.ctx, "backend:interceptionState", state)
-}
-
-func (a *App) GetCurrentVersion(optionalData ...interface{}) {
-	version := "0.0.1" // Hardcoded current version
-	wailsRuntime.EventsEmit(a.ctx, "backend:currentVersion", version)
-}
-
-func (a *App) CheckForUpdates(optionalData ...interface{}) {
-	currentVersion := a.version // Use the version from App struct
-
-	// Fetch latest version from GitHub
-	resp, err := http.Get("https://raw.githubusercontent.com/al-sultani/prokzee/main/version.txt")
+// handleDiffResenderResponses compares the responses of two resender
+// requests, such as consecutive sends of a tab
+func (a *App) handleDiffResenderResponses(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing diff data")
+		return
+	}
+	diffData, ok := data[0].(map[string]interface{})
+	if !ok {
+		log.Println("Invalid diff data format")
+		return
+	}
+	fromId, fromOk := diffData["fromId"].(float64)
+	toId, toOk := diffData["toId"].(float64)
+	if !fromOk || !toOk {
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderDiff", map[string]interface{}{
+			"error": "Invalid or missing request IDs",
+		})
+		return
+	}
+	diff, err := a.resender.DiffResponses(int(fromId), int(toId))
 	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:updateCheck", map[string]interface{}{
-			"currentVersion":  currentVersion,
-			"latestVersion":   currentVersion,
-			"updateAvailable": false,
-			"error":           "Failed to check for updates: " + err.Error(),
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderDiff", map[string]interface{}{
+			"fromId": fromId,
+			"toId":   toId,
+			"error":  err.Error(),
 		})
 		return
 	}
-	defer resp.Body.Close()
+	wailsRuntime.EventsEmit(a.ctx, "backend:resenderDiff", diff)
+}
 
-	// Read the version from the response
-	versionBytes, err := io.ReadAll(resp.Body)
+func (a *App) handleGetResenderVariables(data ...interface{}) {
+	variables, err := a.resender.GetVariables()
 	if err != nil {
-		wailsRuntime.EventsEmit(a.ctx, "backend:updateCheck", map[string]interface{}{
-			"currentVersion":  currentVersion,
-			"latestVersion":   currentVersion,
-			"updateAvailable": false,
-			"error":           "Failed to read version: " + err.Error(),
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderVariables", map[string]interface{}{
+			"error": err.Error(),
 		})
 		return
 	}
-
-	latestVersion := strings.TrimSpace(string(versionBytes))
-	fmt.Println(latestVersion)
-	// TODO: Remove this temporary workaround
-	latestVersion = "0.0.2"
-	wailsRuntime.EventsEmit(a.ctx, "backend:updateCheck", map[string]interface{}{
-		"currentVersion":  currentVersion,
-		"latestVersion":   latestVersion,
-		"updateAvailable": latestVersion != currentVersion,
-		"error":           nil,
-	})
+	wailsRuntime.EventsEmit(a.ctx, "backend:resenderVariables", variables)
 }
 
-// Add these new methods to the App struct
-func (a *App) handleCreateNewResenderTab(data ...interface{}) {
+func (a *App) handleSetResenderVariable(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing new tab data")
+		log.Println("Missing variable data")
 		return
 	}
-	newTabData, ok := data[0].(map[string]interface{})
+	variableData, ok := data[0].(map[string]interface{})
 	if !ok {
-		log.Println("Invalid new tab data format")
+		log.Println("Invalid variable data format")
 		return
 	}
-	if err := a.resender.CreateNewTab(newTabData); err != nil {
-		log.Printf("Error creating new tab: %v", err)
+	name, _ := variableData["name"].(string)
+	value, _ := variableData["value"].(string)
+	if err := a.resender.SetVariable(name, value, "manual"); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderVariables", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
 	}
+	a.handleGetResenderVariables()
 }
 
-func (a *App) handleSendToResender(data ...interface{}) {
+func (a *App) handleDeleteResenderVariable(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing request data")
+		log.Println("Missing variable name")
 		return
 	}
-	requestData, ok := data[0].(map[string]interface{})
+	name, ok := data[0].(string)
 	if !ok {
-		log.Println("Invalid request data format")
+		log.Println("Invalid variable name format")
 		return
 	}
-	if err := a.resender.SendToResender(requestData); err != nil {
-		log.Printf("Error sending to resender: %v", err)
+	if err := a.resender.DeleteVariable(name); err != nil {
+		log.Printf("Error deleting variable: %v", err)
+		return
 	}
+	a.handleGetResenderVariables()
 }
 
-func (a *App) handleGetResenderTabs(data ...interface{}) {
-	tabs, err := a.resender.GetTabs()
+func (a *App) handleGetResenderGroups(data ...interface{}) {
+	groups, err := a.resender.GetGroups()
 	if err != nil {
-		log.Printf("Error getting resender tabs: %v", err)
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderGroups", map[string]interface{}{
+			"error": err.Error(),
+		})
 		return
 	}
-	wailsRuntime.EventsEmit(a.ctx, "backend:resenderTabs", tabs)
+	wailsRuntime.EventsEmit(a.ctx, "backend:resenderGroups", groups)
 }
 
-func (a *App) handleUpdateResenderTabName(data ...interface{}) {
+// handleSaveResenderGroup creates a group of tabs, or updates it when an id
+// is given. Extractions set variables from the response of a tab of a
+// sequential group for the tabs sent after it.
+func (a *App) handleSaveResenderGroup(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing tab data")
+		log.Println("Missing group data")
 		return
 	}
-	tabData, ok := data[0].(map[string]interface{})
+	groupData, ok := data[0].(map[string]interface{})
 	if !ok {
-		log.Println("Invalid tab data format")
+		log.Println("Invalid group data format")
 		return
 	}
-	tabId, ok := tabData["tabId"].(float64)
-	if !ok {
-		log.Println("Invalid or missing tabId")
-		return
+	var group resender.RequestGroup
+	if id, ok := groupData["id"].(float64); ok {
+		group.ID = int(id)
 	}
-	newName, ok := tabData["newName"].(string)
-	if !ok {
-		log.Println("Invalid or missing newName")
-		return
+	group.Name, _ = groupData["name"].(string)
+	group.Mode, _ = groupData["mode"].(string)
+	if tabIDs, ok := groupData["tabIds"].([]interface{}); ok {
+		for _, item := range tabIDs {
+			if id, ok := item.(float64); ok {
+				group.TabIDs = append(group.TabIDs, int(id))
+			}
+		}
 	}
-	if err := a.resender.UpdateTabName(int(tabId), newName); err != nil {
-		log.Printf("Error updating tab name: %v", err)
+	if extractions, ok := groupData["extractions"].([]interface{}); ok {
+		for _, item := range extractions {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var extraction resender.Extraction
+			if tabID, ok := fields["tabId"].(float64); ok {
+				extraction.TabID = int(tabID)
+			}
+			extraction.Variable, _ = fields["variable"].(string)
+			extraction.Source, _ = fields["source"].(string)
+			extraction.Name, _ = fields["name"].(string)
+			group.Extractions = append(group.Extractions, extraction)
+		}
+	}
+
+	if _, err := a.resender.SaveGroup(group); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderGroups", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
 	}
+	a.handleGetResenderGroups()
 }
 
-func (a *App) handleSendResenderRequest(data ...interface{}) {
+func (a *App) handleDeleteResenderGroup(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing request data")
+		log.Println("Missing group ID")
 		return
 	}
-	requestData, ok := data[0].(map[string]interface{})
+	groupID, ok := data[0].(float64)
 	if !ok {
-		log.Println("Invalid request data format")
+		log.Println("Invalid group ID format")
 		return
 	}
-	tabId, ok := requestData["tabId"].(float64)
-	if !ok {
-		log.Println("Invalid tab ID")
+	if err := a.resender.DeleteGroup(int(groupID)); err != nil {
+		log.Printf("Error deleting group: %v", err)
 		return
 	}
-	requestDetails, ok := requestData["requestDetails"].(map[string]interface{})
+	a.handleGetResenderGroups()
+}
+
+// handleSendResenderGroup sends the tabs of a group in the background,
+// reporting each response as it arrives and the whole run at the end
+func (a *App) handleSendResenderGroup(data ...interface{}) {
+	if len(data) < 1 {
+		log.Println("Missing group ID")
+		return
+	}
+	groupID, ok := data[0].(float64)
 	if !ok {
-		log.Println("Invalid request details")
+		log.Println("Invalid group ID format")
 		return
 	}
-	if err := a.resender.SendRequest(tabId, requestDetails); err != nil {
-		log.Printf("Error sending request: %v", err)
-		wailsRuntime.EventsEmit(a.ctx, "backend:resenderResponse", map[string]interface{}{
-			"error": err.Error(),
-			"tabId": tabId,
+
+	go func() {
+		run, err := a.resender.SendGroup(int(groupID), func(step resender.GroupStep) {
+			wailsRuntime.EventsEmit(a.ctx, "backend:resenderGroupStep", map[string]interface{}{
+				"groupId": int(groupID),
+				"step":    step,
+			})
 		})
-	}
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:resenderGroupDone", map[string]interface{}{
+				"groupId": int(groupID),
+				"error":   err.Error(),
+			})
+			return
+		}
+		wailsRuntime.EventsEmit(a.ctx, "backend:resenderGroupDone", run)
+		a.handleGetResenderVariables()
+	}()
 }
 
-func (a *App) handleCancelResenderRequest(data ...interface{}) {
+func (a *App) handleCancelResenderGroup(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing request data")
-		return
-	}
-	requestData, ok := data[0].(map[string]interface{})
-	if !ok {
-		log.Println("Invalid request data format")
+		log.Println("Missing group ID")
 		return
 	}
-	tabId, ok := requestData["tabId"].(float64)
+	groupID, ok := data[0].(float64)
 	if !ok {
-		log.Println("Invalid tab ID")
+		log.Println("Invalid group ID format")
 		return
 	}
-	a.resender.CancelRequest(int(tabId))
+	a.resender.CancelGroup(int(groupID))
 }
 
-func (a *App) handleGetResenderRequest(data ...interface{}) {
-	if len(data) < 1 {
-		log.Println("Missing request ID")
+// importCollection creates resender tabs and variables from a Postman,
+// Insomnia or Hoppscotch export, chosen with the format option (Postman by
+// default). The files are chosen with open dialogs unless paths are given;
+// the separate Postman or Hoppscotch environment is only asked for with
+// withEnvironment. Insomnia exports carry their environments, the one named
+// by the environment option is used.
+func (a *App) importCollection(data ...interface{}) {
+	options := map[string]interface{}{}
+	if len(data) > 0 {
+		if value, ok := data[0].(map[string]interface{}); ok {
+			options = value
+		}
+	}
+	format, _ := options["format"].(string)
+	if format == "" {
+		format = resender.CollectionFormatPostman
+	}
+	var title string
+	filters := []wailsRuntime.FileFilter{{DisplayName: "JSON files (*.json)", Pattern: "*.json"}}
+	switch format {
+	case resender.CollectionFormatPostman:
+		title = "Postman"
+	case resender.CollectionFormatInsomnia:
+		title = "Insomnia"
+		filters = []wailsRuntime.FileFilter{{DisplayName: "Insomnia exports (*.json, *.yaml)", Pattern: "*.json;*.yaml;*.yml"}}
+	case resender.CollectionFormatHoppscotch:
+		title = "Hoppscotch"
+	default:
+		wailsRuntime.EventsEmit(a.ctx, "backend:collectionImported", map[string]interface{}{
+			"error": "Unknown collection format: " + format,
+		})
 		return
 	}
-	log.Println("Received request ID:", data[0])
-	var requestID int
-	switch v := data[0].(type) {
-	case float64:
-		requestID = int(v)
-	case string:
+
+	collectionPath, _ := options["path"].(string)
+	if collectionPath == "" {
 		var err error
-		requestID, err = strconv.Atoi(v)
+		collectionPath, err = wailsRuntime.OpenFileDialog(a.ctx, wailsRuntime.OpenDialogOptions{Title: "Select a " + title + " collection", Filters: filters})
 		if err != nil {
-			log.Println("Invalid request ID format")
+			wailsRuntime.EventsEmit(a.ctx, "backend:collectionImported", map[string]interface{}{
+				"error": "Failed to choose a file: " + err.Error(),
+			})
 			return
 		}
-	default:
-		log.Println("Invalid request ID format")
+		if collectionPath == "" {
+			return // cancelled
+		}
+	}
+	environmentPath, _ := options["environmentPath"].(string)
+	withEnvironment, _ := options["withEnvironment"].(bool)
+	if withEnvironment && environmentPath == "" && format != resender.CollectionFormatInsomnia {
+		environmentPath, _ = wailsRuntime.OpenFileDialog(a.ctx, wailsRuntime.OpenDialogOptions{Title: "Select a " + title + " environment", Filters: filters})
+	}
+
+	collectionData, err := os.ReadFile(collectionPath)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:collectionImported", map[string]interface{}{
+			"error": "Failed to read collection: " + err.Error(),
+		})
 		return
 	}
-	if err := a.resender.GetRequest(requestID); err != nil {
-		log.Printf("Error getting request: %v", err)
+	var environmentData []byte
+	if environmentPath != "" {
+		if environmentData, err = os.ReadFile(environmentPath); err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:collectionImported", map[string]interface{}{
+				"error": "Failed to read environment: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	var result *resender.CollectionImport
+	switch format {
+	case resender.CollectionFormatPostman:
+		result, err = a.resender.ImportPostman(collectionData, environmentData)
+	case resender.CollectionFormatInsomnia:
+		environment, _ := options["environment"].(string)
+		result, err = a.resender.ImportInsomnia(collectionData, environment)
+	case resender.CollectionFormatHoppscotch:
+		result, err = a.resender.ImportHoppscotch(collectionData, environmentData)
+	}
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:collectionImported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
 	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("Imported %d requests and %d variables from %s collection %s", result.Tabs, result.Variables, title, result.Collection), "Resender")
+	wailsRuntime.EventsEmit(a.ctx, "backend:collectionImported", result)
+	a.handleGetResenderTabs()
+	a.handleGetResenderVariables()
 }
 
-func (a *App) handleDeleteResenderTab(data ...interface{}) {
+// importCurl opens a pasted curl command in a new resender tab
+func (a *App) importCurl(data ...interface{}) {
 	if len(data) < 1 {
-		log.Println("Missing tab ID")
+		wailsRuntime.EventsEmit(a.ctx, "backend:curlImported", map[string]interface{}{
+			"error": "Missing curl command",
+		})
 		return
 	}
-	tabID, ok := data[0].(float64)
+	command, ok := data[0].(string)
 	if !ok {
-		log.Println("Invalid tab ID format")
+		if options, isMap := data[0].(map[string]interface{}); isMap {
+			command, ok = options["command"].(string)
+		}
+	}
+	if !ok || strings.TrimSpace(command) == "" {
+		wailsRuntime.EventsEmit(a.ctx, "backend:curlImported", map[string]interface{}{
+			"error": "Invalid or missing curl command",
+		})
 		return
 	}
-	if err := a.resender.DeleteTab(int(tabID)); err != nil {
-		log.Printf("Error deleting tab: %v", err)
+
+	result, err := a.resender.ImportCurl(command)
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:curlImported", map[string]interface{}{
+			"error": "Failed to import curl command: " + err.Error(),
+		})
+		return
+	}
+	wailsRuntime.EventsEmit(a.ctx, "backend:curlImported", result)
+	a.handleGetResenderTabs()
+}
+
+// exportCollection writes resender tabs, all of them unless tabIds are given,
+// as a Postman v2.1 collection, an Insomnia v4 export or a Hoppscotch
+// collection, chosen with the format option. The file is chosen with a save
+// dialog unless a path is given. The variables of a Hoppscotch export are
+// written next to it as an environment.
+func (a *App) exportCollection(data ...interface{}) {
+	options := map[string]interface{}{}
+	if len(data) > 0 {
+		if value, ok := data[0].(map[string]interface{}); ok {
+			options = value
+		}
+	}
+	var tabIDs []int
+	if idList, ok := options["tabIds"].([]interface{}); ok {
+		for _, item := range idList {
+			if id, ok := item.(float64); ok {
+				tabIDs = append(tabIDs, int(id))
+			}
+		}
+	}
+	name, _ := options["name"].(string)
+	if name == "" {
+		if settings, err := a.settingsClient.LoadSettings(); err == nil {
+			name = settings.ProjectName
+		}
+	}
+	format, _ := options["format"].(string)
+	if format == "" {
+		format = resender.CollectionFormatPostman
+	}
+
+	var content, environment []byte
+	var err error
+	var title, defaultFilename string
+	switch format {
+	case resender.CollectionFormatPostman:
+		title, defaultFilename = "Postman", "prokzee.postman_collection.json"
+		content, err = a.resender.ExportPostman(tabIDs, name)
+	case resender.CollectionFormatInsomnia:
+		title, defaultFilename = "Insomnia", "prokzee.insomnia.json"
+		content, err = a.resender.ExportInsomnia(tabIDs, name)
+	case resender.CollectionFormatHoppscotch:
+		title, defaultFilename = "Hoppscotch", "prokzee.hoppscotch.json"
+		content, environment, err = a.resender.ExportHoppscotch(tabIDs, name)
+	default:
+		err = fmt.Errorf("unknown collection format: %s", format)
+	}
+	if err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:collectionExported", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	path, _ := options["path"].(string)
+	if path == "" {
+		path, err = wailsRuntime.SaveFileDialog(a.ctx, wailsRuntime.SaveDialogOptions{
+			Title:           "Export " + title + " collection",
+			DefaultFilename: defaultFilename,
+			Filters:         []wailsRuntime.FileFilter{{DisplayName: "JSON files (*.json)", Pattern: "*.json"}},
+		})
+		if err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:collectionExported", map[string]interface{}{
+				"error": "Failed to choose a file: " + err.Error(),
+			})
+			return
+		}
+		if path == "" {
+			return // cancelled
+		}
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		wailsRuntime.EventsEmit(a.ctx, "backend:collectionExported", map[string]interface{}{
+			"error": "Failed to write collection: " + err.Error(),
+		})
+		return
+	}
+	result := map[string]interface{}{
+		"path": path,
+	}
+	if environment != nil {
+		environmentPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".environment.json"
+		if err := os.WriteFile(environmentPath, environment, 0644); err != nil {
+			wailsRuntime.EventsEmit(a.ctx, "backend:collectionExported", map[string]interface{}{
+				"error": "Failed to write environment: " + err.Error(),
+			})
+			return
+		}
+		result["environmentPath"] = environmentPath
 	}
+
+	a.logger.LogMessage("info", fmt.Sprintf("%s collection exported to %s", title, path), "Resender")
+	wailsRuntime.EventsEmit(a.ctx, "backend:collectionExported", result)
 }
 
 func (a *App) handleSendToFuzzer(data ...interface{}) {
@@ -2144,15 +6990,45 @@ func (a *App) handleSendToFuzzer(data ...interface{}) {
 	}
 }
 
-// Add a cleanup method
+// shutdown is called when the application is about to quit, and by the
+// frontend "shutdown" event. The cleanup runs only once.
+func (a *App) shutdown(ctx context.Context) {
+	a.cleanupOnce.Do(a.cleanup)
+}
+
+// storageDrainTimeout bounds how long shutdown waits for captured traffic to
+// be written
+const storageDrainTimeout = 10 * time.Second
+
+// cleanup shuts down in order: held intercepts are answered, the proxy stops
+// accepting and drains, background writes finish, then the database closes
 func (a *App) cleanup() {
-	// First stop the proxy server to prevent new requests
+	// Answer held intercepts so their connections can complete
+	if dropped := a.proxy.DropHeld(); dropped > 0 {
+		log.Printf("Dropped %d held requests during shutdown", dropped)
+	}
+
+	// Stop accepting connections and let in-flight requests finish
 	if err := a.proxy.StopServer(); err != nil {
 		log.Printf("Error stopping proxy server during cleanup: %v", err)
 	}
 
-	// Wait a moment for any in-flight requests to complete
-	time.Sleep(500 * time.Millisecond)
+	// Pause running fuzzers so they can be resumed on the next start
+	if a.fuzzer != nil {
+		a.fuzzer.PauseAll()
+	}
+
+	if err := a.metricsServer.Stop(); err != nil {
+		log.Printf("Error stopping metrics server during cleanup: %v", err)
+	}
+
+	// Store the traffic captured before the proxy stopped, then record how the
+	// drain went while the database is still open
+	if pending := a.requestStorage.Drain(storageDrainTimeout); pending > 0 {
+		a.logger.LogMessage("warning", fmt.Sprintf("Shutdown timed out with %d captured requests not stored", pending), "Shutdown")
+	} else {
+		a.logger.LogMessage("info", "All captured traffic stored, shutting down", "Shutdown")
+	}
 
 	// Signal all db operations to stop
 	close(a.dbClosing)
@@ -2163,4 +7039,14 @@ func (a *App) cleanup() {
 			log.Printf("Error closing database during cleanup: %v", err)
 		}
 	}
+
+	// Let other instances open the project
+	a.projectMu.RLock()
+	lock := a.projectLock
+	a.projectMu.RUnlock()
+	if lock != nil {
+		if err := lock.Release(); err != nil {
+			log.Printf("Error releasing the project lock during cleanup: %v", err)
+		}
+	}
 }
@@ -51,6 +51,65 @@ interface Plugin {
 
 type PageType = "settings" | "ui"
 
+// pluginFrameDocument builds the page a plugin runs in. The styles of the app
+// are copied in so plugin markup looks the same as before.
+const pluginFrameDocument = (plugin: Plugin, dark: boolean): string => {
+  const styles = Array.from(document.styleSheets)
+    .map((sheet) => {
+      try {
+        return Array.from(sheet.cssRules).map((rule) => rule.cssText).join("\n")
+      } catch {
+        return ""
+      }
+    })
+    .join("\n")
+  const data = JSON.stringify({
+    id: plugin.id,
+    name: plugin.name,
+    code: plugin.code,
+    template: plugin.template,
+  }).replace(/</g, "\\u003c")
+
+  return `<!DOCTYPE html>
+<html class="${dark ? "dark" : ""}">
+<head>
+<meta charset="utf-8">
+<style>${styles.replace(/<\/style/gi, "<\\/style")}</style>
+<style>html, body { margin: 0; background: transparent; }</style>
+</head>
+<body>
+<div id="plugin-ui"></div>
+<script>
+(function (plugin) {
+  function post(message) { parent.postMessage(message, "*") }
+  var pluginApi = {
+    updateUI: function (html) { document.getElementById("plugin-ui").innerHTML = html },
+    EventsEmit: function (eventName, eventData) { post({ type: "emit", event: eventName, data: eventData }) },
+    name: plugin.name,
+    id: plugin.id,
+    template: plugin.template,
+  }
+  new ResizeObserver(function () {
+    post({ type: "resize", height: document.documentElement.scrollHeight })
+  }).observe(document.body)
+  try {
+    var pluginModule = new Function("pluginApi",
+      "with (pluginApi) {\\n" + plugin.code + "\\nvar initResult = init(pluginApi);\\n" +
+      "if (!initResult) { throw new Error('Plugin init function must return an object'); }\\n" +
+      "return initResult;\\n}")(pluginApi)
+    window.pluginBridge = {}
+    window.pluginBridge[plugin.name] = pluginModule
+    window["pluginApi_" + plugin.name] = Object.assign({}, pluginModule, pluginApi)
+    post({ type: "ready" })
+  } catch (e) {
+    post({ type: "error", message: "Plugin execution failed: " + e.message })
+  }
+})(${data});
+</script>
+</body>
+</html>`
+}
+
 const ErrorFallback = ({ error }: { error: Error }) => (
   <div className="text-center py-4 text-red-500">
     <h2>Something went wrong:</h2>
@@ -76,7 +135,7 @@ const Plugins: React.FC = () => {
   const [activePage, setActivePage] = useState<PageType>("ui")
   const [editedCode, setEditedCode] = useState("")
   const [editedTemplate, setEditedTemplate] = useState("")
-  const pluginUIRef = useRef<HTMLDivElement>(null)
+  const pluginFrameRef = useRef<HTMLIFrameElement>(null)
   const [isNewPluginModalOpen, setIsNewPluginModalOpen] = useState(false)
   const [jsonFile, setJsonFile] = useState<File | null>(null)
   const [fileError, setFileError] = useState<string | null>(null)
@@ -87,121 +146,72 @@ const Plugins: React.FC = () => {
     author?: string;
   }>({})
 
-  const executePlugin = useCallback((plugin: Plugin) => {
-    console.log("Executing plugin:", plugin.name)
-
-    try {
-      // Clear existing functions for this plugin
-      window.pluginBridge[plugin.name] = {}
-
-      // Create plugin API
-      const pluginApi = {
-        updateUI: (html: string) => {
-          if (pluginUIRef.current) {
-            pluginUIRef.current.innerHTML = html
-          }
-        },
-        EventsEmit: (eventName: string, eventData: any) => {
-          console.log(`Plugin ${plugin.name} emitted event:`, eventName, eventData)
-          if (eventName === "frontend:test") {
-            EventsEmit("backend:test", eventData)
-          }
-        },
-        name: plugin.name,
-        id: plugin.id,
-        template: plugin.template,
-      }
-
-      // Validate plugin code has init function
-      if (!plugin.code.includes('function init')) {
-        throw new Error('Plugin must have an init function')
-      }
-
-      // Create a new function from the plugin code and immediately execute it
-      const pluginModule = new Function(
-        "pluginApi",
-        `
-        try {
-          with (pluginApi) {
-            ${plugin.code}
-            const initResult = init(pluginApi);
-            if (!initResult) {
-              throw new Error('Plugin init function must return an object');
-            }
-            return initResult;
-          }
-        } catch (e) {
-          throw new Error('Plugin execution failed: ' + e.message);
-        }
-        `,
-      )(pluginApi)
+  // Plugins run in a sandboxed frame without allow-same-origin: their code
+  // cannot reach the window, its runtime or the token of privileged events,
+  // and talks to the app through postMessage alone
+  const [pluginDocument, setPluginDocument] = useState<string | null>(null)
+  const [pluginNotice, setPluginNotice] = useState<{ text: string; error: boolean } | null>(null)
+
+  const disablePlugin = useCallback((plugin: Plugin, reason: string) => {
+    console.error("Error executing plugin:", reason)
+    setPluginDocument(null)
+    setPluginNotice({ text: "Failed to execute plugin. Plugin has been disabled.", error: true })
+    // Update plugin state to inactive due to execution failure
+    updatePlugin({ ...plugin, is_active: false })
+  }, [updatePlugin])
 
-      // Register plugin functions
-      window.pluginBridge[plugin.name] = pluginModule
+  useEffect(() => {
+    if (activePage !== "ui" || !activePluginId) return
 
-      // Create a global pluginApi object for this specific plugin
-      window[`pluginApi_${plugin.name}`] = {
-        ...pluginModule,
-        ...pluginApi,
-      }
+    const plugin = plugins.find((p) => p.id === activePluginId)
+    if (!plugin) return
 
-      console.log("Plugin executed successfully")
-      return true
-    } catch (error) {
-      console.error("Error executing plugin:", error)
-      // Update plugin state to inactive due to execution failure
-      const updatedPlugin = { ...plugin, is_active: false }
-      updatePlugin(updatedPlugin)
-      return false
+    setPluginDocument(null)
+    setPluginNotice(null)
+    if (!plugin.is_active) {
+      console.log("Plugin is inactive:", plugin.name)
+      setPluginNotice({ text: "Plugin is currently disabled", error: false })
+      return
     }
-  }, [updatePlugin])
+    // Validate plugin code has init function
+    if (!plugin.code.includes('function init')) {
+      disablePlugin(plugin, "Plugin must have an init function")
+      return
+    }
+    console.log("Executing active plugin:", plugin.name)
+    setPluginDocument(pluginFrameDocument(plugin, theme === "dark"))
+  }, [activePluginId, activePage, plugins, theme, disablePlugin])
 
   useEffect(() => {
-    const handlePluginUI = () => {
-      if (!activePluginId || !pluginUIRef.current) return;
-      
+    const handleFrameMessage = (event: MessageEvent) => {
+      const frame = pluginFrameRef.current
+      if (!frame || event.source !== frame.contentWindow) return
       const plugin = plugins.find((p) => p.id === activePluginId)
-      if (!plugin) return;
-
-      // Clear the UI first
-      pluginUIRef.current.innerHTML = '';
-
-      if (plugin.is_active) {
-        console.log("Executing active plugin:", plugin.name)
-        try {
-          const success = executePlugin({ ...plugin, is_active: true })
-          if (!success) {
-            pluginUIRef.current.innerHTML = '<div class="text-red-500 dark:text-red-400 text-center py-4">Failed to execute plugin. Plugin has been disabled.</div>';
+      if (!plugin) return
+
+      const message = event.data || {}
+      switch (message.type) {
+        case "emit":
+          console.log(`Plugin ${plugin.name} emitted event:`, message.event, message.data)
+          if (message.event === "frontend:test") {
+            EventsEmit("backend:test", message.data)
           }
-        } catch (error) {
-          console.error("Failed to execute plugin:", error)
-          pluginUIRef.current.innerHTML = '<div class="text-red-500 dark:text-red-400 text-center py-4">Failed to execute plugin. Plugin has been disabled.</div>';
-        }
-      } else {
-        console.log("Plugin is inactive:", plugin.name)
-        pluginUIRef.current.innerHTML = '<div class="text-gray-500 dark:text-gray-400 text-center py-4">Plugin is currently disabled</div>';
-        // Clean up plugin bridge
-        if (window.pluginBridge && plugin.name in window.pluginBridge) {
-          delete window.pluginBridge[plugin.name]
-        }
-        if (`pluginApi_${plugin.name}` in window) {
-          delete window[`pluginApi_${plugin.name}`]
-        }
+          break
+        case "resize":
+          frame.style.height = `${Math.max(200, Number(message.height) || 0)}px`
+          break
+        case "ready":
+          console.log("Plugin executed successfully")
+          break
+        case "error":
+          disablePlugin(plugin, String(message.message))
+          break
       }
     }
 
-    if (activePage === "ui") {
-      handlePluginUI()
-    }
-
-    // Cleanup function to ensure we clean resources when component unmounts
-    // or when active plugin changes
-    return () => {
-      if (activePluginId && pluginUIRef.current) {
-        pluginUIRef.current.innerHTML = '';
-      }
-    }
-  }, [activePluginId, activePage, plugins, executePlugin])
+    window.addEventListener("message", handleFrameMessage)
+    return () => window.removeEventListener("message", handleFrameMessage)
+  }, [activePluginId, plugins, disablePlugin])
 
   useEffect(() => {
     if (activePluginId !== null) {
@@ -312,7 +322,21 @@ const Plugins: React.FC = () => {
       <div className="flex-1 overflow-auto p-4 space-y-4">
         <h2 className="text-2xl font-bold mb-4 dark:text-white">{plugin.name}</h2>
         <div className="bg-white dark:bg-dark-secondary p-4 rounded-lg border border-gray-200 dark:border-gray-700">
-          <div ref={pluginUIRef} className="min-h-[200px] p-2 border rounded dark:border-gray-700 dark:text-white" />
+          <div className="min-h-[200px] p-2 border rounded dark:border-gray-700 dark:text-white">
+            {pluginDocument ? (
+              <iframe
+                ref={pluginFrameRef}
+                title={`${plugin.name} plugin`}
+                sandbox="allow-scripts"
+                srcDoc={pluginDocument}
+                className="w-full min-h-[200px] border-0"
+              />
+            ) : pluginNotice && (
+              <div className={`${pluginNotice.error ? "text-red-500 dark:text-red-400" : "text-gray-500 dark:text-gray-400"} text-center py-4`}>
+                {pluginNotice.text}
+              </div>
+            )}
+          </div>
         </div>
       </div>
     </div>
@@ -1,29 +1,23 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
-import {http} from '../models';
+import {setup} from '../models';
 
 export function ApproveRequest(arg1:{[key: string]: any}):Promise<void>;
 
-export function CheckForUpdates(arg1:Array<any>):Promise<void>;
+export function CheckProxyPort(arg1:string):Promise<setup.PortCheck>;
 
-export function CreateNewProject(arg1:Array<any>):Promise<void>;
+export function ChooseDataDirectory():Promise<string>;
 
-export function FetchSettings(arg1:Array<any>):Promise<void>;
+export function CompleteSetup():Promise<setup.State>;
 
-export function GetAllRequests(arg1:Array<any>):Promise<void>;
+export function ConfigureBrowser(arg1:string):Promise<void>;
 
-export function GetCurrentVersion(arg1:Array<any>):Promise<void>;
+export function GenerateCA():Promise<setup.State>;
 
-export function GetRecentLogs(arg1:Array<any>):Promise<void>;
+export function GetSetupState():Promise<setup.State>;
 
-export function GetTrafficData(arg1:Array<any>):Promise<void>;
+export function ImportCA(arg1:string,arg2:string):Promise<setup.State>;
 
-export function HandleProxyRequest(arg1:http.Request):Promise<void>;
+export function SetDataDirectory(arg1:string):Promise<setup.State>;
 
-export function HandleProxyResponse(arg1:http.Request,arg2:http.Response):Promise<void>;
-
-export function SwitchProject(arg1:Array<any>):Promise<void>;
-
-export function ToggleInterception():Promise<void>;
-
-export function UpdateSettings(arg1:Array<any>):Promise<void>;
+export function SetProxyPort(arg1:string):Promise<setup.State>;
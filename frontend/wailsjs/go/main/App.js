@@ -6,50 +6,38 @@ export function ApproveRequest(arg1) {
   return window['go']['main']['App']['ApproveRequest'](arg1);
 }
 
-export function CheckForUpdates(arg1) {
-  return window['go']['main']['App']['CheckForUpdates'](arg1);
+export function CheckProxyPort(arg1) {
+  return window['go']['main']['App']['CheckProxyPort'](arg1);
 }
 
-export function CreateNewProject(arg1) {
-  return window['go']['main']['App']['CreateNewProject'](arg1);
+export function ChooseDataDirectory() {
+  return window['go']['main']['App']['ChooseDataDirectory']();
 }
 
-export function FetchSettings(arg1) {
-  return window['go']['main']['App']['FetchSettings'](arg1);
+export function CompleteSetup() {
+  return window['go']['main']['App']['CompleteSetup']();
 }
 
-export function GetAllRequests(arg1) {
-  return window['go']['main']['App']['GetAllRequests'](arg1);
+export function ConfigureBrowser(arg1) {
+  return window['go']['main']['App']['ConfigureBrowser'](arg1);
 }
 
-export function GetCurrentVersion(arg1) {
-  return window['go']['main']['App']['GetCurrentVersion'](arg1);
+export function GenerateCA() {
+  return window['go']['main']['App']['GenerateCA']();
 }
 
-export function GetRecentLogs(arg1) {
-  return window['go']['main']['App']['GetRecentLogs'](arg1);
+export function GetSetupState() {
+  return window['go']['main']['App']['GetSetupState']();
 }
 
-export function GetTrafficData(arg1) {
-  return window['go']['main']['App']['GetTrafficData'](arg1);
+export function ImportCA(arg1, arg2) {
+  return window['go']['main']['App']['ImportCA'](arg1, arg2);
 }
 
-export function HandleProxyRequest(arg1) {
-  return window['go']['main']['App']['HandleProxyRequest'](arg1);
+export function SetDataDirectory(arg1) {
+  return window['go']['main']['App']['SetDataDirectory'](arg1);
 }
 
-export function HandleProxyResponse(arg1, arg2) {
-  return window['go']['main']['App']['HandleProxyResponse'](arg1, arg2);
-}
-
-export function SwitchProject(arg1) {
-  return window['go']['main']['App']['SwitchProject'](arg1);
-}
-
-export function ToggleInterception() {
-  return window['go']['main']['App']['ToggleInterception']();
-}
-
-export function UpdateSettings(arg1) {
-  return window['go']['main']['App']['UpdateSettings'](arg1);
+export function SetProxyPort(arg1) {
+  return window['go']['main']['App']['SetProxyPort'](arg1);
 }
@@ -0,0 +1,104 @@
+export namespace certificate {
+	
+	export class CAInfo {
+	    subject: string;
+	    notBefore: string;
+	    notAfter: string;
+	    fingerprint: string;
+	    spkiHash: string;
+	    path: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new CAInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.subject = source["subject"];
+	        this.notBefore = source["notBefore"];
+	        this.notAfter = source["notAfter"];
+	        this.fingerprint = source["fingerprint"];
+	        this.spkiHash = source["spkiHash"];
+	        this.path = source["path"];
+	    }
+	}
+
+}
+
+export namespace setup {
+	
+	export class Browser {
+	    name: string;
+	    kind: string;
+	    path: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Browser(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.kind = source["kind"];
+	        this.path = source["path"];
+	    }
+	}
+	export class PortCheck {
+	    port: string;
+	    available: boolean;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new PortCheck(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.port = source["port"];
+	        this.available = source["available"];
+	        this.error = source["error"];
+	    }
+	}
+	export class State {
+	    completed: boolean;
+	    dataDir: string;
+	    defaultDataDir: string;
+	    proxyPort: string;
+	    ca?: certificate.CAInfo;
+	    browsers: Browser[];
+	
+	    static createFrom(source: any = {}) {
+	        return new State(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.completed = source["completed"];
+	        this.dataDir = source["dataDir"];
+	        this.defaultDataDir = source["defaultDataDir"];
+	        this.proxyPort = source["proxyPort"];
+	        this.ca = this.convertValues(source["ca"], certificate.CAInfo);
+	        this.browsers = this.convertValues(source["browsers"], Browser);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
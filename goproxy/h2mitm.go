@@ -0,0 +1,155 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+)
+
+// H2Stream describes the HTTP/2 stream a MITM'd request arrived on. The
+// pseudo-headers are the ones sent by the client, before the proxy turned
+// the request into an absolute https URL.
+type H2Stream struct {
+	// Connection identifies the client connection the stream belongs to
+	Connection int64
+	// Sequence is the position of the stream among the streams the client
+	// opened on the connection, starting at 1
+	Sequence int64
+	// Method, Scheme, Authority and Path are the :method, :scheme,
+	// :authority and :path pseudo-headers
+	Method    string
+	Scheme    string
+	Authority string
+	Path      string
+}
+
+type h2StreamKey struct{}
+
+// H2StreamFromContext returns the HTTP/2 stream of a request served by the
+// h2 MITM, or nil when the client spoke HTTP/1.x
+func H2StreamFromContext(ctx context.Context) *H2Stream {
+	stream, _ := ctx.Value(h2StreamKey{}).(*H2Stream)
+	return stream
+}
+
+// h2HopHeaders are connection-specific headers that must not be sent in an
+// HTTP/2 response
+var h2HopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade"}
+
+// serveH2Mitm serves a MITM'd client connection that negotiated h2 through
+// ALPN. Unlike H2Transport, every stream is decoded into its own request and
+// goes through the request and response handlers.
+func (proxy *ProxyHttpServer) serveH2Mitm(conn *tls.Conn, connectReq *http.Request, connectCtx *ProxyCtx) {
+	connection := atomic.AddInt64(&proxy.sess, 1)
+	var streams int64
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		stream := &H2Stream{
+			Connection: connection,
+			Sequence:   atomic.AddInt64(&streams, 1),
+			Method:     req.Method,
+			Scheme:     "https",
+			Authority:  req.Host,
+			Path:       req.URL.RequestURI(),
+		}
+		if req.Method == http.MethodConnect {
+			// Extended CONNECT (RFC 8441) is not supported, clients fall back
+			// to WebSockets over HTTP/1.1
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		requestContext, finishRequest := context.WithCancel(context.WithValue(req.Context(), h2StreamKey{}, stream))
+		defer finishRequest()
+		req = req.WithContext(requestContext)
+		req.RemoteAddr = connectReq.RemoteAddr
+		if req.Host == "" {
+			req.Host = connectReq.Host
+		}
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+
+		ctx := &ProxyCtx{
+			Req:          req,
+			Session:      atomic.AddInt64(&proxy.sess, 1),
+			Proxy:        proxy,
+			UserData:     connectCtx.UserData,
+			RoundTripper: connectCtx.RoundTripper,
+		}
+		ctx.Logf("h2 req %v stream %d", req.Host, stream.Sequence)
+
+		req, resp := proxy.filterRequest(req, ctx)
+		if resp == nil {
+			if !proxy.KeepHeader {
+				RemoveProxyHeaders(ctx, req)
+			}
+			var err error
+			resp, err = func() (*http.Response, error) {
+				defer req.Body.Close()
+				return ctx.RoundTrip(req)
+			}()
+			if err != nil {
+				ctx.Warnf("Cannot read h2 response from mitm'd server %v", err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			ctx.Logf("resp %v", resp.Status)
+		}
+		resp = proxy.filterResponse(resp, ctx)
+		defer resp.Body.Close()
+
+		writeH2Response(ctx, w, resp)
+	})
+
+	server := &http2.Server{}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Context: context.Background(),
+		Handler: handler,
+	})
+	connectCtx.Logf("Exiting h2 connection %d", connection)
+}
+
+// writeH2Response copies a response, including its trailers, to an HTTP/2
+// stream
+func writeH2Response(ctx *ProxyCtx, w http.ResponseWriter, resp *http.Response) {
+	header := w.Header()
+	for name, values := range resp.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	for _, name := range h2HopHeaders {
+		header.Del(name)
+	}
+	// Trailers are sent with the TrailerPrefix once the body is written
+	header.Del("Trailer")
+	w.WriteHeader(resp.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				ctx.Warnf("Cannot write h2 response body to mitm'd client: %v", werr)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ctx.Warnf("Cannot read response body for mitm'd h2 client: %v", err)
+			return
+		}
+	}
+
+	for name, values := range resp.Trailer {
+		header[http.TrailerPrefix+name] = values
+	}
+}
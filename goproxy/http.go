@@ -83,8 +83,10 @@ func (proxy *ProxyHttpServer) handleHttp(w http.ResponseWriter, r *http.Request)
 	var copyWriter io.Writer = w
 	// Content-Type header may also contain charset definition, so here we need to check the prefix.
 	// Transfer-Encoding can be a list of comma separated values, so we use Contains() for it.
+	// A body of unknown length is chunked by net/http, resp.TransferEncoding
+	// is not part of the copied headers.
 	if strings.HasPrefix(w.Header().Get("content-type"), "text/event-stream") ||
-		strings.Contains(w.Header().Get("transfer-encoding"), "chunked") {
+		strings.Contains(w.Header().Get("transfer-encoding"), "chunked") || resp.ContentLength < 0 {
 		// server-side events, flush the buffered data to the client.
 		copyWriter = &flushWriter{w: w}
 	}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/elazarl/goproxy/internal/http1parser"
 	"github.com/elazarl/goproxy/internal/signer"
+	"golang.org/x/net/http2"
 )
 
 type ConnectActionLiteral int
@@ -289,6 +290,11 @@ func (proxy *ProxyHttpServer) handleHttps(w http.ResponseWriter, r *http.Request
 				return
 			}
 
+			if rawClientTls.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+				proxy.serveH2Mitm(rawClientTls, r, ctx)
+				return
+			}
+
 			clientTlsReader := http1parser.NewRequestReader(proxy.PreventCanonicalization, rawClientTls)
 			for !clientTlsReader.IsEOF() {
 				req, err := clientTlsReader.ReadRequest()
@@ -14,6 +14,7 @@ import (
 	"math/big"
 	"math/rand"
 	"net"
+	"net/netip"
 	"runtime"
 	"sort"
 	"strings"
@@ -64,8 +65,9 @@ func SignHost(ca tls.Certificate, hosts []string) (cert *tls.Certificate, err er
 		BasicConstraintsValid: true,
 	}
 	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
+		// IP literals, IPv6 ones possibly bracketed or with a zone, go in IP SANs
+		if addr, err := netip.ParseAddr(strings.Trim(h, "[]")); err == nil {
+			template.IPAddresses = append(template.IPAddresses, net.IP(addr.WithZone("").AsSlice()))
 		} else {
 			template.DNSNames = append(template.DNSNames, h)
 			template.Subject.CommonName = h
@@ -0,0 +1,327 @@
+package apiversions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the API version explorer
+const Source = "api-versions"
+
+// maxResponseBody bounds how much of a response is read
+const maxResponseBody = 256 * 1024
+
+// versionPattern recognizes the version segment of an API path
+var versionPattern = regexp.MustCompile(`(?i)^(?:v(\d+)(?:\.\d+)*|beta|alpha|internal|private|dev|test|latest|legacy|preview)$`)
+
+// namedVersions are tried next to the numbered siblings of the observed version
+var namedVersions = []string{"beta", "alpha", "internal", "private", "dev", "test", "latest", "legacy", "preview"}
+
+// DebugPaths are documentation and debug endpoints looked for under the API
+// root and the host root
+var DebugPaths = []string{
+	"swagger.json", "swagger-ui.html", "openapi.json", "api-docs", "v2/api-docs", "v3/api-docs",
+	"graphql", "graphiql", "debug", "debug/vars", "debug/pprof/",
+	"actuator", "actuator/env", "health", "status", "metrics", "_debug", "console",
+}
+
+// credentialHeaders are removed for the anonymous requests
+var credentialHeaders = []string{"Authorization", "Cookie", "X-Api-Key", "X-Auth-Token", "X-Csrf-Token"}
+
+// Probe is the answer to one sibling version or debug path
+type Probe struct {
+	Kind       string `json:"kind"` // "version" or "debug"
+	Version    string `json:"version"`
+	URL        string `json:"url"`
+	Status     int    `json:"status"`     // with the credentials of the observed request
+	AnonStatus int    `json:"anonStatus"` // without credentials
+	Length     int    `json:"length"`
+	Exists     bool   `json:"exists"`
+	AuthNeeded bool   `json:"authNeeded"`
+	Error      string `json:"error"`
+	Note       string `json:"note"`
+}
+
+// Result is what the explorer learned about an API path
+type Result struct {
+	RequestID  int     `json:"requestId"`
+	URL        string  `json:"url"`
+	Version    string  `json:"version"` // version segment of the observed path
+	AuthNeeded bool    `json:"authNeeded"`
+	Probes     []Probe `json:"probes"`
+}
+
+// response is what is kept of a response
+type response struct {
+	status int
+	length int
+}
+
+// Explorer probes the sibling versions of an observed API path
+type Explorer struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewExplorer creates a new API version explorer
+func NewExplorer(db *sql.DB, findingsClient *findings.Client) *Explorer {
+	return &Explorer{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run replays a stored request against the sibling versions of its API path
+// and the known debug paths, with and without its credentials, and records
+// versions that drop the authentication of the observed one
+func (e *Explorer) Run(ctx context.Context, requestID int, progress func(done, total int)) (*Result, error) {
+	stored, err := probe.Load(e.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := strings.Split(stored.Target.Path, "/")
+	index := -1
+	for i, segment := range segments {
+		if versionPattern.MatchString(segment) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("no version segment such as v1 found in %s", stored.Target.Path)
+	}
+	observed := segments[index]
+
+	observedAuth, err := e.send(ctx, stored, stored.Target.Path, true)
+	if err != nil {
+		return nil, fmt.Errorf("request to the observed version failed: %v", err)
+	}
+	observedAnon, err := e.send(ctx, stored, stored.Target.Path, false)
+	if err != nil {
+		return nil, fmt.Errorf("anonymous request to the observed version failed: %v", err)
+	}
+	// A version that cannot exist shows how the API answers unknown paths
+	missing, err := e.send(ctx, stored, withSegment(segments, index, "v9999"), true)
+	if err != nil {
+		return nil, fmt.Errorf("request to a missing version failed: %v", err)
+	}
+
+	result := &Result{
+		RequestID:  requestID,
+		URL:        stored.Target.String(),
+		Version:    observed,
+		AuthNeeded: authNeeded(observedAuth, observedAnon),
+		Probes:     []Probe{},
+	}
+
+	type candidate struct {
+		kind, version, path string
+		missing             *response
+	}
+	var candidates []candidate
+	for _, version := range siblings(observed) {
+		candidates = append(candidates, candidate{"version", version, withSegment(segments, index, version), missing})
+	}
+	apiRoot := strings.Join(segments[:index], "/")
+	roots := []string{apiRoot}
+	if apiRoot != "" {
+		roots = append(roots, "")
+	}
+	get := *stored
+	get.Method, get.Body = http.MethodGet, ""
+	for _, root := range roots {
+		// Single page applications answer any path under the host root
+		rootMissing, err := e.send(ctx, &get, root+"/prokzee-missing", true)
+		if err != nil {
+			continue
+		}
+		for _, path := range DebugPaths {
+			candidates = append(candidates, candidate{"debug", "", root + "/" + path, rootMissing})
+		}
+	}
+
+	for i, c := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+		probe := Probe{Kind: c.kind, Version: c.version, URL: stored.Target.Scheme + "://" + stored.Target.Host + c.path}
+		source := stored
+		if c.kind == "debug" {
+			// Debug and documentation pages are fetched, whatever the observed method
+			source = &get
+		}
+		auth, err := e.send(ctx, source, c.path, true)
+		if err != nil {
+			probe.Error = err.Error()
+		} else {
+			probe.Status, probe.Length = auth.status, auth.length
+			probe.Exists = exists(auth, c.missing)
+			if anon, err := e.send(ctx, source, c.path, false); err == nil {
+				probe.AnonStatus = anon.status
+				probe.AuthNeeded = authNeeded(auth, anon)
+			}
+			evaluate(&probe, result)
+		}
+		result.Probes = append(result.Probes, probe)
+		if progress != nil {
+			progress(i+1, len(candidates))
+		}
+	}
+
+	e.record(stored, result)
+	return result, nil
+}
+
+// evaluate notes a probe worth reporting
+func evaluate(probe *Probe, result *Result) {
+	if !probe.Exists {
+		return
+	}
+	anonSuccess := probe.AnonStatus >= 200 && probe.AnonStatus < 300
+	switch {
+	case probe.Kind == "version" && result.AuthNeeded && anonSuccess:
+		probe.Note = fmt.Sprintf("%s answers without credentials while %s requires them", probe.Version, result.Version)
+	case probe.Kind == "version" && result.AuthNeeded != probe.AuthNeeded:
+		probe.Note = fmt.Sprintf("%s and %s differ in their authentication", probe.Version, result.Version)
+	case probe.Kind == "version":
+		probe.Note = fmt.Sprintf("%s exists next to %s", probe.Version, result.Version)
+	case anonSuccess:
+		probe.Note = "Debug or documentation endpoint answers without credentials"
+	case probe.Status >= 200 && probe.Status < 300:
+		probe.Note = "Debug or documentation endpoint answers with credentials"
+	}
+}
+
+// record stores the notable probes as findings
+func (e *Explorer) record(stored *probe.Request, result *Result) {
+	for _, probe := range result.Probes {
+		if probe.Note == "" {
+			continue
+		}
+		severity := findings.SeverityInfo
+		title := "Sibling API version " + probe.Version + " exists"
+		switch {
+		case strings.Contains(probe.Note, "without credentials while"):
+			severity = findings.SeverityHigh
+			title = "API version " + probe.Version + " skips authentication"
+		case strings.Contains(probe.Note, "differ in their authentication"):
+			severity = findings.SeverityMedium
+			title = "API version " + probe.Version + " authenticates differently"
+		case probe.Kind == "debug":
+			severity = findings.SeverityLow
+			if probe.AnonStatus >= 200 && probe.AnonStatus < 300 {
+				severity = findings.SeverityMedium
+			}
+			title = "API debug endpoint exposed"
+		}
+
+		err := e.findings.AddFinding(findings.Finding{
+			Source:    Source,
+			Host:      stored.Target.Hostname(),
+			URL:       probe.URL,
+			RequestID: result.RequestID,
+			Title:     title,
+			Severity:  severity,
+			Detail:    fmt.Sprintf("%s\nStatus %d with credentials, %d without", probe.Note, probe.Status, probe.AnonStatus),
+			Key:       stored.Method + "|" + probe.URL,
+		})
+		if err != nil {
+			log.Printf("Failed to record API version finding: %v", err)
+		}
+	}
+}
+
+// siblings lists the versions to try next to an observed one
+func siblings(observed string) []string {
+	highest := 3
+	if match := versionPattern.FindStringSubmatch(observed); match[1] != "" {
+		if n, err := strconv.Atoi(match[1]); err == nil && n+2 > highest {
+			highest = n + 2
+		}
+	}
+	var versions []string
+	for n := 0; n <= highest; n++ {
+		versions = append(versions, "v"+strconv.Itoa(n))
+	}
+	versions = append(versions, namedVersions...)
+
+	var out []string
+	for _, version := range versions {
+		if !strings.EqualFold(version, observed) {
+			out = append(out, version)
+		}
+	}
+	return out
+}
+
+// withSegment returns the path with the segment at index replaced
+func withSegment(segments []string, index int, value string) string {
+	out := append([]string{}, segments...)
+	out[index] = value
+	return strings.Join(out, "/")
+}
+
+// exists tells a real endpoint from the answer to a path that cannot exist
+func exists(resp, missing *response) bool {
+	if resp.status == http.StatusNotFound || resp.status == http.StatusGone {
+		return false
+	}
+	if resp.status != missing.status {
+		return true
+	}
+	// Catch-all routes answer every path alike
+	diff := resp.length - missing.length
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff > 64 && float64(diff) > 0.1*float64(missing.length)
+}
+
+// authNeeded reports whether credentials turn a refusal into an answer
+func authNeeded(auth, anon *response) bool {
+	denied := anon.status == http.StatusUnauthorized || anon.status == http.StatusForbidden
+	return denied && auth.status != anon.status
+}
+
+// send replays the stored request on another path, with or without its
+// credentials
+func (e *Explorer) send(ctx context.Context, stored *probe.Request, path string, credentials bool) (*response, error) {
+	target := *stored.Target
+	target.Path = path
+	target.RawPath = ""
+
+	req, err := stored.NewHTTPRequest(ctx, stored.Method, &target, stored.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !credentials {
+		for _, header := range credentialHeaders {
+			req.Header.Del(header)
+		}
+	}
+	resp, body, err := probe.Do(e.client, req, maxResponseBody)
+	if err != nil {
+		return nil, err
+	}
+	return &response{status: resp.StatusCode, length: len(body)}, nil
+}
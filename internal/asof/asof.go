@@ -0,0 +1,52 @@
+package asof
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layout is how SQLite CURRENT_TIMESTAMP stores times, in UTC. Values in this
+// layout compare as strings in time order.
+const Layout = "2006-01-02 15:04:05"
+
+// inputLayouts are the forms a time is accepted in besides RFC 3339, read in
+// local time like a datetime-local input
+var inputLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Parse reads the time a view is reconstructed at. An empty value is the zero
+// time, which stands for now.
+func Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range inputLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			// A bare date covers the whole day
+			if layout == "2006-01-02" {
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or YYYY-MM-DD HH:MM", value)
+}
+
+// Condition returns the SQL condition keeping the rows of column recorded at
+// or before t, with its argument. It is empty for the zero time.
+func Condition(column string, t time.Time) (string, []interface{}) {
+	if t.IsZero() {
+		return "", nil
+	}
+	return " AND " + column + " <= ?", []interface{}{t.UTC().Format(Layout)}
+}
@@ -0,0 +1,331 @@
+package backupprobe
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the backup file prober
+const Source = "backup-probe"
+
+// maxProbeBody bounds how much of a candidate is downloaded
+const maxProbeBody = 64 * 1024
+
+// maxPaths bounds how many discovered paths are probed in one run
+const maxPaths = 500
+
+// fileSuffixes are appended to discovered files by editors and by hand
+var fileSuffixes = []string{".bak", "~", ".old", ".orig", ".save", ".copy", ".tmp", ".1"}
+
+// dirSuffixes turn a discovered directory into the archive it may have been
+// shipped or backed up as
+var dirSuffixes = []string{".zip", ".tar.gz", ".tgz", ".tar", ".rar", ".7z"}
+
+// Hit is a backup variant of a discovered path that the server serves
+type Hit struct {
+	Path        string `json:"path"` // discovered path the variant belongs to
+	URL         string `json:"url"`
+	Variant     string `json:"variant"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Length      int    `json:"length"`
+	Severity    string `json:"severity"`
+}
+
+// candidate is a variant to try
+type candidate struct {
+	path    string // discovered path
+	variant string // path of the variant
+	kind    string // "file", "swap" or "archive"
+}
+
+// Prober requests backup variants of the paths discovered on a host
+type Prober struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewProber creates a new backup file prober
+func NewProber(db *sql.DB, findingsClient *findings.Client) *Prober {
+	return &Prober{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			// A redirect usually means a login or error page, never the file
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run probes the backup variants of the sitemap paths of a domain under a
+// path prefix, "/" or empty for the whole domain, and records hits as findings
+func (p *Prober) Run(ctx context.Context, domain, prefix string, progress func(done, total int)) ([]Hit, error) {
+	origin, paths, err := p.paths(domain, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if origin == "" {
+		return nil, fmt.Errorf("no requests captured for %s under %s", domain, prefix)
+	}
+
+	candidates := variants(paths)
+	hits := []Hit{}
+	// Servers answering unknown paths with a page of their own are told apart
+	// by what they serve for a path that cannot exist
+	softNotFound, _ := p.fetch(ctx, origin+"/prokzee-missing-"+fmt.Sprint(time.Now().UnixNano())+".bak")
+
+	for i, c := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+		if hit, ok := p.check(ctx, origin, c, softNotFound); ok {
+			hits = append(hits, hit)
+		}
+		if progress != nil {
+			progress(i+1, len(candidates))
+		}
+	}
+
+	for _, hit := range hits {
+		err := p.findings.AddFinding(findings.Finding{
+			Source:   Source,
+			Host:     domain,
+			URL:      hit.URL,
+			Title:    "Exposed backup of " + hit.Path,
+			Severity: hit.Severity,
+			Detail:   fmt.Sprintf("%s is served (status %d, %s, %d bytes read)", hit.Variant, hit.Status, hit.ContentType, hit.Length),
+			Key:      hit.URL,
+		})
+		if err != nil {
+			log.Printf("Failed to record backup finding: %v", err)
+		}
+	}
+	return hits, nil
+}
+
+// probed is what is kept of a response to a candidate
+type probed struct {
+	status      int
+	contentType string
+	body        []byte
+}
+
+// check requests a candidate, with HEAD first so misses cost no body
+func (p *Prober) check(ctx context.Context, origin string, c candidate, softNotFound *probed) (Hit, bool) {
+	target := origin + escapePath(c.variant)
+	if status, ok := p.head(ctx, target); ok && status != http.StatusOK && status != http.StatusPartialContent {
+		return Hit{}, false
+	}
+	resp, err := p.fetch(ctx, target)
+	if err != nil || (resp.status != http.StatusOK && resp.status != http.StatusPartialContent) || len(resp.body) == 0 {
+		return Hit{}, false
+	}
+
+	switch c.kind {
+	case "archive":
+		if !isArchive(resp.body) {
+			return Hit{}, false
+		}
+	case "swap":
+		if !bytes.HasPrefix(resp.body, []byte("b0VIM")) {
+			return Hit{}, false
+		}
+	default:
+		if softNotFound != nil && softNotFound.status == resp.status && similar(softNotFound.body, resp.body) {
+			return Hit{}, false
+		}
+		if looksLikeHTML(resp.body) && !strings.HasSuffix(strings.ToLower(c.path), ".html") && !strings.HasSuffix(strings.ToLower(c.path), ".htm") {
+			// A rendered page rather than the source of a script
+			return Hit{}, false
+		}
+	}
+
+	severity := findings.SeverityMedium
+	if c.kind == "archive" || isSource(c.path) {
+		severity = findings.SeverityHigh
+	}
+	return Hit{
+		Path:        c.path,
+		URL:         target,
+		Variant:     c.variant,
+		Status:      resp.status,
+		ContentType: resp.contentType,
+		Length:      len(resp.body),
+		Severity:    severity,
+	}, true
+}
+
+// head sends a HEAD request. ok is false when the server does not support it,
+// in which case the GET decides.
+func (p *Prober) head(ctx context.Context, target string) (int, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return 0, false
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		return resp.StatusCode, false
+	}
+	return resp.StatusCode, true
+}
+
+// fetch GETs the start of a URL
+func (p *Prober) fetch(ctx context.Context, target string) (*probed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Ask for the start of the file only, archives can be huge
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", maxProbeBody-1))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	return &probed{status: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), body: body}, nil
+}
+
+// paths returns the origin and the distinct paths captured for a domain
+// under a prefix
+func (p *Prober) paths(domain, prefix string) (string, []string, error) {
+	prefix = "/" + strings.TrimPrefix(prefix, "/")
+	rows, err := p.db.Query(`SELECT DISTINCT url FROM requests WHERE domain = ? AND url IS NOT NULL AND url != ''`, domain)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query paths: %v", err)
+	}
+	defer rows.Close()
+
+	origin := ""
+	seen := make(map[string]bool)
+	var paths []string
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			return "", nil, fmt.Errorf("failed to scan url: %v", err)
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		if origin == "" || (parsed.Scheme == "https" && strings.HasPrefix(origin, "http://")) {
+			origin = parsed.Scheme + "://" + parsed.Host
+		}
+		if !strings.HasPrefix(parsed.Path, prefix) || seen[parsed.Path] {
+			continue
+		}
+		seen[parsed.Path] = true
+		paths = append(paths, parsed.Path)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+	sort.Strings(paths)
+	if len(paths) > maxPaths {
+		paths = paths[:maxPaths]
+	}
+	return origin, paths, nil
+}
+
+// variants lists the backup candidates of discovered paths: editor and manual
+// copies of files, and archives of their directories
+func variants(paths []string) []candidate {
+	seen := make(map[string]bool)
+	var out []candidate
+	add := func(c candidate) {
+		if !seen[c.variant] {
+			seen[c.variant] = true
+			out = append(out, c)
+		}
+	}
+
+	for _, p := range paths {
+		dir, name := path.Split(p)
+		if name != "" && strings.Contains(name, ".") {
+			for _, suffix := range fileSuffixes {
+				add(candidate{path: p, variant: p + suffix, kind: "file"})
+			}
+			// index.php becomes index.bak and index.php.swp becomes .index.php.swp
+			add(candidate{path: p, variant: dir + strings.TrimSuffix(name, path.Ext(name)) + ".bak", kind: "file"})
+			add(candidate{path: p, variant: dir + "." + name + ".swp", kind: "swap"})
+		}
+
+		// Every directory on the way, except the root
+		for dir = strings.TrimSuffix(dir, "/"); dir != ""; dir = strings.TrimSuffix(path.Dir(dir), "/") {
+			for _, suffix := range dirSuffixes {
+				add(candidate{path: dir + "/", variant: dir + suffix, kind: "archive"})
+			}
+		}
+	}
+	return out
+}
+
+// escapePath escapes a path for a URL, keeping its slashes
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// sourceExtensions are files executed on the server, whose source is never
+// meant to be read
+var sourceExtensions = map[string]bool{
+	".php": true, ".asp": true, ".aspx": true, ".jsp": true, ".py": true, ".rb": true,
+	".pl": true, ".cgi": true, ".cfm": true, ".config": true, ".conf": true, ".ini": true,
+	".env": true, ".yml": true, ".yaml": true, ".sql": true,
+}
+
+// isSource reports whether a path names a server-side script or config file
+func isSource(p string) bool {
+	return sourceExtensions[strings.ToLower(path.Ext(p))]
+}
+
+// similar reports whether two bodies are the same page give or take the
+// reflected path
+func similar(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	shorter, longer := len(a), len(b)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	return longer > 0 && float64(longer-shorter)/float64(longer) < 0.05
+}
+
+// isArchive checks for zip, gzip, tar, rar and 7z magic bytes
+func isArchive(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("PK\x03\x04")) || bytes.HasPrefix(b, []byte{0x1f, 0x8b}) ||
+		bytes.HasPrefix(b, []byte("Rar!")) || bytes.HasPrefix(b, []byte("7z\xbc\xaf\x27\x1c")) ||
+		(len(b) > 262 && bytes.HasPrefix(b[257:], []byte("ustar")))
+}
+
+// looksLikeHTML reports whether a body is an HTML page
+func looksLikeHTML(b []byte) bool {
+	start := bytes.ToLower(bytes.TrimSpace(b))
+	if len(start) > 256 {
+		start = start[:256]
+	}
+	return bytes.Contains(start, []byte("<html")) || bytes.Contains(start, []byte("<!doctype"))
+}
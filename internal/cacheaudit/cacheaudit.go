@@ -0,0 +1,540 @@
+package cacheaudit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the cache audit and cache probe
+const Source = "cache-audit"
+
+// maxProbeBody bounds how much of a probed response is downloaded
+const maxProbeBody = 256 * 1024
+
+// cacheableStatus are the status codes shared caches store without explicit
+// freshness information
+var cacheableStatus = map[int]bool{200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true, 404: true, 405: true, 410: true, 414: true, 501: true}
+
+// staticExtensions are the extensions CDNs cache by default, whatever the
+// response headers say
+var staticExtensions = map[string]bool{
+	".css": true, ".js": true, ".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".ico": true, ".woff": true, ".woff2": true, ".ttf": true, ".webp": true, ".avif": true, ".map": true,
+}
+
+// unkeyedHeaders are request headers that caches rarely key on but
+// applications often reflect
+var unkeyedHeaders = []string{"X-Forwarded-Host", "X-Host", "X-Forwarded-Server", "X-Original-URL", "X-Forwarded-Scheme"}
+
+// Endpoint is the caching behavior of a method and path of a host, over every
+// stored response for it
+type Endpoint struct {
+	Host         string   `json:"host"`
+	Method       string   `json:"method"`
+	Path         string   `json:"path"`
+	URL          string   `json:"url"`
+	RequestID    int      `json:"requestId"`
+	Responses    int      `json:"responses"`
+	CacheControl string   `json:"cacheControl"`
+	Vary         string   `json:"vary"`
+	ETag         bool     `json:"etag"`
+	Cacheable    bool     `json:"cacheable"`    // a shared cache may store the response
+	CacheHit     bool     `json:"cacheHit"`     // a cache in front of the host served the response
+	Personalized bool     `json:"personalized"` // the response depends on cookies or credentials
+	Issues       []string `json:"issues"`
+}
+
+// ProbeResult is what the active cache probe learned about an endpoint
+type ProbeResult struct {
+	URL       string   `json:"url"`
+	Cached    bool     `json:"cached"`    // a repeated request was served from a cache
+	Deception bool     `json:"deception"` // a personalized page was cached under a static-looking path
+	Poisoning bool     `json:"poisoning"` // an unkeyed header value was cached and served to others
+	Reflected []string `json:"reflected"` // unkeyed headers whose value the response reflects
+	Evidence  []string `json:"evidence"`
+}
+
+// Auditor evaluates the caching headers of stored responses and probes
+// endpoints for web cache deception and poisoning
+type Auditor struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewAuditor creates a new cache auditor
+func NewAuditor(db *sql.DB, findingsClient *findings.Client) *Auditor {
+	return &Auditor{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run evaluates every stored response of the hosts that pass the filter,
+// grouped by endpoint, and records the issues it finds as findings
+func (a *Auditor) Run(filter func(host string) bool) ([]Endpoint, error) {
+	rows, err := a.db.Query(`
+		SELECT id, COALESCE(domain, ''), method, COALESCE(url, ''), COALESCE(path, ''), status,
+			COALESCE(request_headers, ''), COALESCE(response_headers, '')
+		FROM requests
+		WHERE response_headers IS NOT NULL AND response_headers != ''
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query responses: %v", err)
+	}
+	defer rows.Close()
+
+	endpoints := make(map[string]*Endpoint)
+	var order []string
+	allowed := make(map[string]bool)
+	cachedHosts := make(map[string]bool)
+	for rows.Next() {
+		var id int
+		var host, method, rawURL, urlPath, status, rawRequest, rawResponse string
+		if err := rows.Scan(&id, &host, &method, &rawURL, &urlPath, &status, &rawRequest, &rawResponse); err != nil {
+			return nil, fmt.Errorf("failed to scan response: %v", err)
+		}
+
+		if _, seen := allowed[host]; !seen {
+			allowed[host] = filter == nil || filter(host)
+		}
+		if !allowed[host] {
+			continue
+		}
+
+		var requestHeaders, responseHeaders http.Header
+		if err := json.Unmarshal([]byte(rawResponse), &responseHeaders); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(rawRequest), &requestHeaders)
+		code, _ := strconv.Atoi(strings.Fields(status + " 0")[0])
+
+		key := host + "|" + method + "|" + urlPath
+		endpoint, ok := endpoints[key]
+		if !ok {
+			endpoint = &Endpoint{Host: host, Method: method, Path: urlPath, URL: rawURL, RequestID: id, Issues: []string{}}
+			endpoints[key] = endpoint
+			order = append(order, key)
+		}
+		endpoint.evaluate(method, code, requestHeaders, responseHeaders)
+		if endpoint.CacheHit {
+			cachedHosts[host] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read responses: %v", err)
+	}
+
+	result := []Endpoint{}
+	for _, key := range order {
+		endpoint := endpoints[key]
+		// Personalized dynamic pages behind a cache are worth a deception probe
+		if cachedHosts[endpoint.Host] && endpoint.Personalized && !endpoint.Cacheable && !isStatic(endpoint.Path) &&
+			!strings.Contains(strings.ToLower(endpoint.CacheControl), "no-store") {
+			endpoint.addIssue("Web cache deception candidate")
+		}
+		if len(endpoint.Issues) == 0 {
+			continue
+		}
+		sort.Strings(endpoint.Issues)
+		result = append(result, *endpoint)
+		a.record(*endpoint)
+	}
+	return result, nil
+}
+
+// evaluate folds a response into the caching behavior of the endpoint
+func (e *Endpoint) evaluate(method string, status int, request, response http.Header) {
+	e.Responses++
+	cacheControl := strings.ToLower(strings.Join(response.Values("Cache-Control"), ", "))
+	if cacheControl != "" {
+		e.CacheControl = cacheControl
+	}
+	if vary := strings.Join(response.Values("Vary"), ", "); vary != "" {
+		e.Vary = vary
+	}
+	if response.Get("ETag") != "" {
+		e.ETag = true
+	}
+
+	hit := cacheHit(response)
+	cacheable := hit || sharedCacheable(method, status, cacheControl, response)
+	e.Cacheable = e.Cacheable || cacheable
+	e.CacheHit = e.CacheHit || hit
+
+	credentials := request.Get("Authorization") != "" || request.Get("Cookie") != ""
+	setsCookie := len(response.Values("Set-Cookie")) > 0
+	if credentials || setsCookie {
+		e.Personalized = true
+	}
+
+	vary := strings.ToLower(strings.Join(response.Values("Vary"), ","))
+	switch {
+	case cacheable && setsCookie:
+		e.addIssue("Cacheable response sets cookies")
+	case cacheable && credentials && !strings.Contains(vary, "cookie") && !strings.Contains(vary, "authorization") && vary != "*":
+		e.addIssue("Cacheable authenticated response without Vary on credentials")
+	}
+	if hit && !strings.Contains(vary, "origin") && response.Get("Access-Control-Allow-Origin") != "" &&
+		response.Get("Access-Control-Allow-Origin") != "*" {
+		e.addIssue("Cached CORS response without Vary: Origin")
+	}
+	if credentials && response.Get("ETag") != "" && !strings.Contains(cacheControl, "private") &&
+		!strings.Contains(cacheControl, "no-store") {
+		e.addIssue("ETag on personalized response not marked private")
+	}
+	// Cached pages are worth a poisoning probe, cached assets are too many to list
+	if hit && !isStatic(e.Path) {
+		e.addIssue("Served from a shared cache, cache poisoning candidate")
+	}
+}
+
+// addIssue records an issue once
+func (e *Endpoint) addIssue(issue string) {
+	for _, existing := range e.Issues {
+		if existing == issue {
+			return
+		}
+	}
+	e.Issues = append(e.Issues, issue)
+}
+
+// severity rates the worst issue of an endpoint
+func (e Endpoint) severity() string {
+	for _, issue := range e.Issues {
+		if strings.HasPrefix(issue, "Cacheable") {
+			return findings.SeverityMedium
+		}
+	}
+	for _, issue := range e.Issues {
+		if strings.HasPrefix(issue, "Cached CORS") || strings.HasPrefix(issue, "Web cache deception") {
+			return findings.SeverityLow
+		}
+	}
+	return findings.SeverityInfo
+}
+
+// record stores the issues of an endpoint as a finding
+func (a *Auditor) record(endpoint Endpoint) {
+	err := a.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      endpoint.Host,
+		URL:       endpoint.URL,
+		RequestID: endpoint.RequestID,
+		Title:     "Caching issue on " + endpoint.Method + " " + endpoint.Path,
+		Severity:  endpoint.severity(),
+		Detail: fmt.Sprintf("%s\nCache-Control: %s\nVary: %s\nETag: %t",
+			strings.Join(endpoint.Issues, "\n"), endpoint.CacheControl, endpoint.Vary, endpoint.ETag),
+		Key: endpoint.Host + "|" + endpoint.Method + "|" + endpoint.Path,
+	})
+	if err != nil {
+		log.Printf("Failed to record cache audit finding: %v", err)
+	}
+}
+
+// sharedCacheable reports whether a shared cache may store a response
+func sharedCacheable(method string, status int, cacheControl string, response http.Header) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	directives := parseDirectives(cacheControl)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	if _, ok := directives["private"]; ok {
+		return false
+	}
+	if value, ok := directives["s-maxage"]; ok {
+		return value != "0"
+	}
+	if _, ok := directives["public"]; ok {
+		return true
+	}
+	if value, ok := directives["max-age"]; ok {
+		return value != "0" && cacheableStatus[status]
+	}
+	if expires := response.Get("Expires"); expires != "" {
+		when, err := http.ParseTime(expires)
+		return err == nil && when.After(time.Now()) && cacheableStatus[status]
+	}
+	return false
+}
+
+// parseDirectives splits a Cache-Control value into its directives
+func parseDirectives(cacheControl string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// cacheHit reports whether a response says it came from a cache
+func cacheHit(response http.Header) bool {
+	if age, err := strconv.Atoi(response.Get("Age")); err == nil && age > 0 {
+		return true
+	}
+	for _, name := range []string{"X-Cache", "Cf-Cache-Status", "X-Cache-Status", "X-Proxy-Cache", "X-Varnish-Cache", "Cdn-Cache"} {
+		if strings.Contains(strings.ToLower(response.Get(name)), "hit") {
+			return true
+		}
+	}
+	return false
+}
+
+// isStatic reports whether a path ends with an extension CDNs cache by default
+func isStatic(urlPath string) bool {
+	return staticExtensions[strings.ToLower(path.Ext(urlPath))]
+}
+
+// Probe replays a stored GET request to test its endpoint for web cache
+// deception and unkeyed header poisoning. Every probe URL carries a unique
+// cache buster so real users are never served a poisoned entry.
+func (a *Auditor) Probe(requestID int) (*ProbeResult, error) {
+	var host, rawURL, rawHeaders string
+	err := a.db.QueryRow(`
+		SELECT COALESCE(domain, ''), COALESCE(url, ''), COALESCE(request_headers, '')
+		FROM requests WHERE id = ?
+	`, requestID).Scan(&host, &rawURL, &rawHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load request %d: %v", requestID, err)
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid request URL %q", rawURL)
+	}
+	headers := http.Header{}
+	if rawHeaders != "" {
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			return nil, fmt.Errorf("failed to parse request headers: %v", err)
+		}
+	}
+	for _, name := range []string{"Content-Length", "Content-Type", "If-None-Match", "If-Modified-Since", "Range"} {
+		headers.Del(name)
+	}
+
+	result := &ProbeResult{URL: rawURL, Reflected: []string{}, Evidence: []string{}}
+
+	// Does a repeated request come from a cache at all?
+	buster := withBuster(target)
+	if _, _, err := a.fetch(buster, headers); err != nil {
+		return nil, fmt.Errorf("probe request failed: %v", err)
+	}
+	if second, _, err := a.fetch(buster, headers); err == nil && cacheHit(second.Header) {
+		result.Cached = true
+		result.Evidence = append(result.Evidence, "Repeated request was a cache hit: "+hitEvidence(second.Header))
+	}
+
+	a.probeDeception(target, headers, result)
+	a.probePoisoning(target, headers, result)
+
+	a.recordProbe(host, requestID, result)
+	return result, nil
+}
+
+// probeDeception requests the page under a static-looking path with the
+// original credentials, then again without them. The second request getting
+// the personalized page back means a cache stored it.
+func (a *Auditor) probeDeception(target *url.URL, headers http.Header, result *ProbeResult) {
+	if headers.Get("Cookie") == "" && headers.Get("Authorization") == "" {
+		return
+	}
+	original, originalBody, err := a.fetch(target.String(), headers)
+	if err != nil || original.StatusCode != http.StatusOK {
+		return
+	}
+	anonymous := headers.Clone()
+	anonymous.Del("Cookie")
+	anonymous.Del("Authorization")
+	// A page that looks the same without credentials has nothing to leak
+	if _, publicBody, err := a.fetch(target.String(), anonymous); err == nil && similar(originalBody, publicBody) {
+		return
+	}
+
+	deceptive := *target
+	deceptive.Path = strings.TrimSuffix(target.Path, "/") + "/prokzee" + probe.Token() + ".css"
+	deceptive.RawPath = ""
+	first, firstBody, err := a.fetch(deceptive.String(), headers)
+	if err != nil || first.StatusCode != http.StatusOK || !similar(originalBody, firstBody) {
+		return
+	}
+	result.Evidence = append(result.Evidence, fmt.Sprintf("%s returns the page of %s", deceptive.Path, target.Path))
+
+	second, secondBody, err := a.fetch(deceptive.String(), anonymous)
+	if err != nil {
+		return
+	}
+	if second.StatusCode == http.StatusOK && similar(firstBody, secondBody) {
+		result.Deception = true
+		result.Evidence = append(result.Evidence, "The page was served again without credentials: "+hitEvidence(second.Header))
+	}
+}
+
+// probePoisoning sends each unkeyed header with a canary value and checks
+// whether the canary is reflected, and whether a later request without the
+// header still gets it from the cache
+func (a *Auditor) probePoisoning(target *url.URL, headers http.Header, result *ProbeResult) {
+	for _, name := range unkeyedHeaders {
+		canary := "prokzee" + probe.Token() + ".example"
+		buster := withBuster(target)
+
+		poisoned := headers.Clone()
+		poisoned.Set(name, canary)
+		resp, body, err := a.fetch(buster, poisoned)
+		if err != nil || !reflects(resp, body, canary) {
+			continue
+		}
+		result.Reflected = append(result.Reflected, name)
+		result.Evidence = append(result.Evidence, fmt.Sprintf("%s value is reflected in the response", name))
+
+		clean, cleanBody, err := a.fetch(buster, headers)
+		if err == nil && reflects(clean, cleanBody, canary) {
+			result.Poisoning = true
+			result.Evidence = append(result.Evidence, fmt.Sprintf("%s value was cached and served to a request without it", name))
+		}
+	}
+}
+
+// recordProbe stores what the probe confirmed as findings
+func (a *Auditor) recordProbe(host string, requestID int, result *ProbeResult) {
+	var title, severity string
+	switch {
+	case result.Poisoning:
+		title, severity = "Web cache poisoning through unkeyed header", findings.SeverityHigh
+	case result.Deception:
+		title, severity = "Web cache deception", findings.SeverityHigh
+	case len(result.Reflected) > 0:
+		title, severity = "Unkeyed header reflected in response", findings.SeverityLow
+	default:
+		return
+	}
+	err := a.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      host,
+		URL:       result.URL,
+		RequestID: requestID,
+		Title:     title,
+		Severity:  severity,
+		Detail:    strings.Join(result.Evidence, "\n"),
+		Key:       "probe|" + result.URL,
+	})
+	if err != nil {
+		log.Printf("Failed to record cache probe finding: %v", err)
+	}
+}
+
+// fetch sends a GET with the given headers and returns the start of the body
+func (a *Auditor) fetch(target string, headers http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header = headers.Clone()
+	if host := headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+	// Ask for the body as is, so it can be compared
+	req.Header.Del("Accept-Encoding")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+// withBuster adds a unique query parameter so a probe gets its own cache entry
+func withBuster(target *url.URL) string {
+	busted := *target
+	query := busted.Query()
+	query.Set("prokzee_cb", probe.Token())
+	busted.RawQuery = query.Encode()
+	return busted.String()
+}
+
+// reflects reports whether a canary shows up in the headers or body of a response
+func reflects(resp *http.Response, body []byte, canary string) bool {
+	if bytes.Contains(body, []byte(canary)) {
+		return true
+	}
+	for _, values := range resp.Header {
+		for _, value := range values {
+			if strings.Contains(value, canary) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// similar reports whether two bodies are the same page, allowing for small
+// dynamic parts such as CSRF tokens
+func similar(a, b []byte) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	if bytes.Equal(a, b) {
+		return true
+	}
+	shorter, longer := len(a), len(b)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	if float64(shorter)/float64(longer) < 0.9 {
+		return false
+	}
+	prefix := 0
+	for prefix < shorter && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < shorter-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return float64(prefix+suffix)/float64(longer) >= 0.9
+}
+
+// hitEvidence lists the cache headers of a response
+func hitEvidence(response http.Header) string {
+	var parts []string
+	for _, name := range []string{"Age", "X-Cache", "Cf-Cache-Status", "X-Cache-Status", "X-Proxy-Cache", "X-Varnish-Cache", "Cdn-Cache", "Cache-Control"} {
+		if value := response.Get(name); value != "" {
+			parts = append(parts, name+": "+value)
+		}
+	}
+	if len(parts) == 0 {
+		return "identical body"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,253 @@
+package captureguide
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	models "prokzee/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// checkTimeout bounds every network check so the assistant never hangs
+const checkTimeout = 5 * time.Second
+
+// Check is the outcome of a single diagnostic step
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+	Advice string `json:"advice,omitempty"`
+}
+
+// Report summarizes whether a phone or IoT device on the LAN can use the proxy
+type Report struct {
+	ProxyPort    string   `json:"proxyPort"`
+	LANAddresses []string `json:"lanAddresses"`
+	ProxyAddress string   `json:"proxyAddress"`
+	CAURL        string   `json:"caUrl"`
+	Checks       []Check  `json:"checks"`
+	Ready        bool     `json:"ready"`
+}
+
+// Run runs every check against the proxy listening on the given address and
+// port, every interface when the address is empty
+func Run(bindAddress, proxyPort string) *Report {
+	report := &Report{ProxyPort: proxyPort, Checks: []Check{}}
+
+	addresses, err := LANAddresses()
+	if err != nil || len(addresses) == 0 {
+		detail := "no non-loopback IPv4 address found"
+		if err != nil {
+			detail = err.Error()
+		}
+		report.Checks = append(report.Checks, Check{
+			Name:   "LAN address",
+			Passed: false,
+			Detail: detail,
+			Advice: "Connect this machine to the same Wi-Fi or LAN as the device you want to capture.",
+		})
+		return report
+	}
+	report.LANAddresses = addresses
+	lanIP := addresses[0]
+	// A proxy bound to one address is only reachable there
+	bind := net.ParseIP(strings.Trim(bindAddress, "[]"))
+	if strings.EqualFold(bindAddress, "localhost") || bind != nil && bind.IsLoopback() {
+		report.Checks = append(report.Checks, Check{
+			Name:   "Bind address",
+			Passed: false,
+			Detail: fmt.Sprintf("the proxy only listens on %s", bindAddress),
+			Advice: "Clear the bind address in the project settings, or set it to this machine's LAN address.",
+		})
+		return report
+	}
+	if bind != nil && !bind.IsUnspecified() {
+		lanIP = bind.String()
+	}
+	report.ProxyAddress = net.JoinHostPort(lanIP, proxyPort)
+	report.CAURL = "http://prokzee/"
+	report.Checks = append(report.Checks, Check{
+		Name:   "LAN address",
+		Passed: true,
+		Detail: fmt.Sprintf("Configure the device proxy as %s", report.ProxyAddress),
+	})
+
+	report.Checks = append(report.Checks, CheckPortOpen(lanIP, proxyPort))
+	report.Checks = append(report.Checks, CheckCAEndpoint(lanIP, proxyPort))
+	report.Checks = append(report.Checks, CaptureSelfTest(lanIP, proxyPort))
+
+	report.Ready = true
+	for _, check := range report.Checks {
+		if !check.Passed {
+			report.Ready = false
+			break
+		}
+	}
+	return report
+}
+
+// LANAddresses returns the IPv4 addresses of the interfaces that are up and not loopback
+func LANAddresses() ([]string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %v", err)
+	}
+
+	var addresses []string
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Printf("Failed to read addresses of interface %s: %v", iface.Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			addresses = append(addresses, ipNet.IP.String())
+		}
+	}
+	return addresses, nil
+}
+
+// CheckPortOpen verifies the proxy accepts connections on the LAN address rather
+// than only on loopback. A local firewall that blocks inbound LAN traffic can
+// still interfere, so the advice points at it when the dial fails.
+func CheckPortOpen(lanIP, port string) Check {
+	check := Check{Name: "Proxy port reachable on LAN address"}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(lanIP, port), checkTimeout)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Advice = fmt.Sprintf("Allow inbound TCP on port %s in the OS firewall and make sure the proxy listens on all interfaces.", port)
+		return check
+	}
+	conn.Close()
+
+	check.Passed = true
+	check.Detail = fmt.Sprintf("Accepted a connection on %s", net.JoinHostPort(lanIP, port))
+	return check
+}
+
+// CheckCAEndpoint fetches the root CA through the proxy on the LAN address, the
+// same way a device does when it browses to http://prokzee/
+func CheckCAEndpoint(lanIP, port string) Check {
+	check := Check{Name: "CA certificate endpoint reachable"}
+
+	client := proxiedClient(lanIP, port)
+	resp, err := client.Get("http://prokzee/rootCA.pem")
+	if err != nil {
+		check.Detail = err.Error()
+		check.Advice = "Make sure the proxy is running and the port is reachable before installing the certificate."
+		return check
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		check.Detail = fmt.Sprintf("failed to read CA certificate: %v", err)
+		return check
+	}
+
+	block, _ := pem.Decode(body)
+	if resp.StatusCode != http.StatusOK || block == nil {
+		check.Detail = fmt.Sprintf("unexpected response %s", resp.Status)
+		check.Advice = "Restart the proxy so the certificate handler is registered."
+		return check
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		check.Detail = fmt.Sprintf("invalid CA certificate: %v", err)
+		return check
+	}
+
+	check.Passed = true
+	check.Detail = fmt.Sprintf("Served %s, valid until %s", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+	return check
+}
+
+// CaptureSelfTest sends a request through the proxy to a throwaway server on the
+// LAN address and confirms it arrived. The request carries the self-test header
+// so the proxy neither holds it for interception nor stores it.
+func CaptureSelfTest(lanIP, port string) Check {
+	check := Check{Name: "Capture self-test"}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(lanIP, "0"))
+	if err != nil {
+		check.Detail = fmt.Sprintf("failed to start test server: %v", err)
+		return check
+	}
+
+	marker := uuid.New().String()
+	received := make(chan string, 1)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case received <- r.URL.Query().Get("marker"):
+			default:
+			}
+			w.Write([]byte("ok"))
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	target := fmt.Sprintf("http://%s/prokzee-self-test?marker=%s", listener.Addr().String(), marker)
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	req.Header.Set(models.SelfTestHeader, marker)
+
+	resp, err := proxiedClient(lanIP, port).Do(req)
+	if err != nil {
+		check.Detail = err.Error()
+		check.Advice = "The proxy accepted the connection but could not forward the request; check upstream connectivity."
+		return check
+	}
+	resp.Body.Close()
+
+	select {
+	case got := <-received:
+		if got != marker {
+			check.Detail = "test server received an unexpected request"
+			return check
+		}
+	case <-time.After(checkTimeout):
+		check.Detail = "test server never received the proxied request"
+		return check
+	}
+
+	check.Passed = true
+	check.Detail = "A request sent through the LAN address reached its destination"
+	return check
+}
+
+// proxiedClient returns an HTTP client that sends everything through the proxy
+func proxiedClient(lanIP, port string) *http.Client {
+	proxyURL := &url.URL{Scheme: "http", Host: net.JoinHostPort(lanIP, port)}
+	return &http.Client{
+		Timeout: checkTimeout,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -3,15 +3,20 @@ package certificate
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"log"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +24,9 @@ import (
 type CertificateManager struct {
 	CaCert    *x509.Certificate
 	CaTLSCert tls.Certificate
+	// Dir is where the CA is stored, the ProKZee config directory when empty
+	Dir string
+	mu  sync.RWMutex
 }
 
 // NewCertificateManager creates a new CertificateManager instance
@@ -33,8 +41,15 @@ func generateCA() (*x509.Certificate, *rsa.PrivateKey, error) {
 		return nil, nil, err
 	}
 
+	// A random serial keeps browsers from rejecting a regenerated CA as a
+	// reused issuer and serial
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	caCertTemplate := &x509.Certificate{
-		SerialNumber: big.NewInt(16877104),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			Country:            []string{"UK"},
 			Province:           []string{"London"},
@@ -84,28 +99,7 @@ func saveCertAndKey(certPath string, keyPath string, caCert *x509.Certificate, c
 
 // SetupCertificates checks if certificate files exist, and if not, generates new ones
 func (cm *CertificateManager) SetupCertificates() error {
-	// Get the appropriate directory for storing certificates
-	certDir, err := os.UserConfigDir()
-	if err != nil {
-		// Fall back to home directory if config dir isn't available
-		certDir, err = os.UserHomeDir()
-		if err != nil {
-			// As a last resort, use current directory
-			certDir = "."
-		}
-	}
-
-	// Create a dedicated app data directory for certificates
-	certDir = filepath.Join(certDir, "ProKZee", "certs")
-
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll(certDir, 0755); err != nil {
-		log.Printf("Failed to create certificate directory, using current directory: %v", err)
-		certDir = "."
-	}
-
-	certPath := filepath.Join(certDir, "rootCA.pem")
-	keyPath := filepath.Join(certDir, "rootCA-key.pem")
+	certPath, keyPath := cm.paths()
 
 	log.Printf("Using certificate path: %s", certPath)
 	log.Printf("Using key path: %s", keyPath)
@@ -117,61 +111,192 @@ func (cm *CertificateManager) SetupCertificates() error {
 	if os.IsNotExist(certErr) || os.IsNotExist(keyErr) {
 		// One or both files don't exist, generate new certificates
 		log.Println("Certificate files not found. Generating new CA certificate...")
+		return cm.GenerateCA()
+	}
 
-		caCert, caKey, err := generateCA()
-		if err != nil {
-			return fmt.Errorf("failed to generate CA certificate: %v", err)
-		}
+	// Load existing certificate and key
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CA certificate: %v", err)
+	}
 
-		// Save the CA certificate and key to files
-		err = saveCertAndKey(certPath, keyPath, caCert, caKey)
-		if err != nil {
-			return fmt.Errorf("failed to save CA certificate and key: %v", err)
-		}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CA key: %v", err)
+	}
 
-		cm.CaCert = caCert
-		cm.CaTLSCert = tls.Certificate{
-			Certificate: [][]byte{caCert.Raw},
-			PrivateKey:  caKey,
-			Leaf:        caCert,
-		}
-	} else {
-		// Load existing certificate and key
-		certPEM, err := os.ReadFile(certPath)
-		if err != nil {
-			return fmt.Errorf("failed to read CA certificate: %v", err)
-		}
+	cert, caCert, err := parseCA(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	cm.set(caCert, cert)
+	return nil
+}
 
-		keyPEM, err := os.ReadFile(keyPath)
-		if err != nil {
-			return fmt.Errorf("failed to read CA key: %v", err)
-		}
+// paths returns where the CA certificate and key are stored, creating the
+// directory if needed
+func (cm *CertificateManager) paths() (string, string) {
+	cm.mu.RLock()
+	certDir := cm.Dir
+	cm.mu.RUnlock()
 
-		// Parse the certificate
-		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if certDir == "" {
+		// Get the appropriate directory for storing certificates
+		configDir, err := os.UserConfigDir()
 		if err != nil {
-			return fmt.Errorf("failed to parse X509 key pair: %v", err)
+			// Fall back to home directory if config dir isn't available
+			configDir, err = os.UserHomeDir()
+			if err != nil {
+				// As a last resort, use current directory
+				configDir = "."
+			}
 		}
 
-		// Parse the certificate for the leaf
-		cm.CaCert, err = x509.ParseCertificate(cert.Certificate[0])
-		if err != nil {
-			return fmt.Errorf("failed to parse CA certificate: %v", err)
-		}
+		// Create a dedicated app data directory for certificates
+		certDir = filepath.Join(configDir, "ProKZee", "certs")
+	}
+
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(certDir, 0755); err != nil {
+		log.Printf("Failed to create certificate directory, using current directory: %v", err)
+		certDir = "."
+	}
+
+	return filepath.Join(certDir, "rootCA.pem"), filepath.Join(certDir, "rootCA-key.pem")
+}
+
+// ProjectDirFor returns where the CA of the project stored at dbPath is kept
+func ProjectDirFor(dbPath string) string {
+	return strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ".ca"
+}
 
-		cm.CaTLSCert = cert
-		cm.CaTLSCert.Leaf = cm.CaCert
+// CurrentDir returns the directory the CA is stored in, empty for the
+// ProKZee config directory
+func (cm *CertificateManager) CurrentDir() string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.Dir
+}
+
+// SetDir changes the directory the CA is stored in. The CA in use does not
+// change until SetupCertificates, GenerateCA or ImportCA is called.
+func (cm *CertificateManager) SetDir(dir string) {
+	cm.mu.Lock()
+	cm.Dir = dir
+	cm.mu.Unlock()
+}
+
+// GenerateCA creates a new CA, replacing the stored one. Clients that trusted
+// the previous CA must trust the new one before intercepted HTTPS works again.
+func (cm *CertificateManager) GenerateCA() error {
+	caCert, caKey, err := generateCA()
+	if err != nil {
+		return fmt.Errorf("failed to generate CA certificate: %v", err)
 	}
 
+	// Save the CA certificate and key to files
+	certPath, keyPath := cm.paths()
+	if err := saveCertAndKey(certPath, keyPath, caCert, caKey); err != nil {
+		return fmt.Errorf("failed to save CA certificate and key: %v", err)
+	}
+
+	cm.set(caCert, tls.Certificate{
+		Certificate: [][]byte{caCert.Raw},
+		PrivateKey:  caKey,
+	})
 	return nil
 }
 
+// ImportCA replaces the stored CA with an existing PEM certificate and key,
+// e.g. one already trusted by the test devices
+func (cm *CertificateManager) ImportCA(certPEM, keyPEM []byte) error {
+	cert, caCert, err := parseCA(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if !caCert.IsCA {
+		return fmt.Errorf("certificate %q is not a CA certificate", caCert.Subject.CommonName)
+	}
+	if time.Now().After(caCert.NotAfter) {
+		return fmt.Errorf("certificate %q expired on %s", caCert.Subject.CommonName, caCert.NotAfter.Format("2006-01-02"))
+	}
+
+	certPath, keyPath := cm.paths()
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save root CA certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to save root CA key: %v", err)
+	}
+
+	cm.set(caCert, cert)
+	return nil
+}
+
+// parseCA parses a PEM certificate and its key
+func parseCA(certPEM, keyPEM []byte) (tls.Certificate, *x509.Certificate, error) {
+	// Parse the certificate
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return cert, nil, fmt.Errorf("failed to parse X509 key pair: %v", err)
+	}
+
+	// Parse the certificate for the leaf
+	caCert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	return cert, caCert, nil
+}
+
+// set makes a CA the one used to sign intercepted hosts
+func (cm *CertificateManager) set(caCert *x509.Certificate, cert tls.Certificate) {
+	cert.Leaf = caCert
+	cm.mu.Lock()
+	cm.CaCert = caCert
+	cm.CaTLSCert = cert
+	cm.mu.Unlock()
+}
+
+// CAInfo describes the CA in use
+type CAInfo struct {
+	Subject     string `json:"subject"`
+	NotBefore   string `json:"notBefore"`
+	NotAfter    string `json:"notAfter"`
+	Fingerprint string `json:"fingerprint"` // SHA-256 of the certificate
+	SPKIHash    string `json:"spkiHash"`    // base64 SHA-256 of the public key, as used by Chrome
+	Path        string `json:"path"`
+}
+
+// Info describes the CA in use, or returns nil before one was set up
+func (cm *CertificateManager) Info() *CAInfo {
+	caCert := cm.GetCertificate()
+	if caCert == nil {
+		return nil
+	}
+	certPath, _ := cm.paths()
+	fingerprint := sha256.Sum256(caCert.Raw)
+	spki := sha256.Sum256(caCert.RawSubjectPublicKeyInfo)
+	return &CAInfo{
+		Subject:     caCert.Subject.CommonName,
+		NotBefore:   caCert.NotBefore.Format(time.RFC3339),
+		NotAfter:    caCert.NotAfter.Format(time.RFC3339),
+		Fingerprint: strings.ToUpper(hex.EncodeToString(fingerprint[:])),
+		SPKIHash:    base64.StdEncoding.EncodeToString(spki[:]),
+		Path:        certPath,
+	}
+}
+
 // GetCertificate returns the CA certificate
 func (cm *CertificateManager) GetCertificate() *x509.Certificate {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 	return cm.CaCert
 }
 
 // GetTLSCertificate returns the TLS certificate
 func (cm *CertificateManager) GetTLSCertificate() tls.Certificate {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
 	return cm.CaTLSCert
 }
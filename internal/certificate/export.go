@@ -0,0 +1,227 @@
+package certificate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"unicode/utf16"
+)
+
+// Export formats of the CA certificate
+const (
+	FormatPEM    = "pem"
+	FormatDER    = "der"
+	FormatPKCS12 = "p12"
+)
+
+// pkcs12Iterations is the iteration count of the PKCS#12 MAC key derivation
+const pkcs12Iterations = 2048
+
+var (
+	oidData         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Cert     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidSHA1         = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+// Export returns the CA certificate, never its key, in one of the export
+// formats. The PKCS#12 file is protected by password, which may be empty.
+func (cm *CertificateManager) Export(format, password string) ([]byte, error) {
+	caCert := cm.GetCertificate()
+	if caCert == nil {
+		return nil, fmt.Errorf("no CA certificate has been set up")
+	}
+	switch format {
+	case FormatPEM:
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), nil
+	case FormatDER:
+		return append([]byte{}, caCert.Raw...), nil
+	case FormatPKCS12:
+		return encodePKCS12(caCert.Raw, caCert.Subject.CommonName, password)
+	}
+	return nil, fmt.Errorf("unknown CA export format %q", format)
+}
+
+// ContentType returns the MIME type a CA export is served with
+func ContentType(format string) string {
+	if format == FormatPKCS12 {
+		return "application/x-pkcs12"
+	}
+	return "application/x-x509-ca-cert"
+}
+
+// The [0] EXPLICIT fields below hold raw values, which encoding/asn1 writes
+// as is, so explicit wraps them itself
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []attribute `asn1:"set"`
+}
+
+type attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+// encodePKCS12 builds a PKCS#12 file holding a single certificate, the way
+// trust stores import a CA, with an HMAC-SHA1 integrity check
+func encodePKCS12(certDER []byte, name, password string) ([]byte, error) {
+	bag, err := asn1.Marshal(certBag{ID: oidX509Cert, Data: certDER})
+	if err != nil {
+		return nil, err
+	}
+	nameValue, err := asn1.Marshal(bmpString(name, false))
+	if err != nil {
+		return nil, err
+	}
+	friendlyName, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: nameValue})
+	if err != nil {
+		return nil, err
+	}
+	safeContents, err := asn1.Marshal([]safeBag{{
+		ID:         oidCertBag,
+		Value:      explicit(bag),
+		Attributes: []attribute{{ID: oidFriendlyName, Values: asn1.RawValue{FullBytes: friendlyName}}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	authenticatedSafe, err := asn1.Marshal([]contentInfo{dataContent(safeContents)})
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := pkcs12KDF(bmpString(password, true).Bytes, salt, pkcs12Iterations, 3, sha1.Size)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(authenticatedSafe)
+
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: dataContent(authenticatedSafe),
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: algorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// dataContent wraps bytes in a ContentInfo of type data
+func dataContent(content []byte) contentInfo {
+	octets, _ := asn1.Marshal(content)
+	return contentInfo{ContentType: oidData, Content: explicit(octets)}
+}
+
+// explicit wraps an encoded value in a [0] EXPLICIT tag
+func explicit(encoded []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: encoded}
+}
+
+// bmpString encodes s as a BMPString, with the two zero bytes PKCS#12
+// passwords end with when terminated is set
+func bmpString(s string, terminated bool) asn1.RawValue {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	if terminated {
+		b = append(b, 0, 0)
+	}
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: 30, Bytes: b}
+}
+
+// pkcs12KDF derives key material from a password as in RFC 7292 appendix B.2,
+// with SHA-1
+func pkcs12KDF(password, salt []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(in []byte) []byte {
+		if len(in) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(in)+v-1)/v))
+		for i := range out {
+			out[i] = in[i%len(in)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	in := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(in)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+
+		// Add B + 1 to every v-byte block of the input
+		b := new(big.Int).SetBytes(fill(a[:u]))
+		b.Add(b, big.NewInt(1))
+		for j := 0; j < len(in); j += v {
+			block := new(big.Int).SetBytes(in[j : j+v])
+			block.Add(block, b)
+			sum := block.Bytes()
+			if len(sum) > v {
+				sum = sum[len(sum)-v:]
+			}
+			copy(in[j:j+v], make([]byte, v))
+			copy(in[j+v-len(sum):j+v], sum)
+		}
+	}
+	return out[:size]
+}
@@ -0,0 +1,120 @@
+package certificate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// installTimeout bounds how long an install may wait, mostly on the user
+// answering the administrator prompt
+const installTimeout = 5 * time.Minute
+
+// linuxTrustStore is a system CA directory and the command that rebuilds
+// the trusted bundle from it
+type linuxTrustStore struct {
+	dir     string
+	refresh []string
+}
+
+// linuxTrustStores are tried in order, the first whose directory and command
+// exist is used
+var linuxTrustStores = []linuxTrustStore{
+	{"/usr/local/share/ca-certificates", []string{"update-ca-certificates"}},           // Debian, Ubuntu
+	{"/etc/pki/ca-trust/source/anchors", []string{"update-ca-trust", "extract"}},       // Fedora, RHEL
+	{"/etc/ca-certificates/trust-source/anchors", []string{"trust", "extract-compat"}}, // Arch
+	{"/usr/share/pki/trust/anchors", []string{"update-ca-certificates"}},               // openSUSE
+}
+
+// InstallSystemTrust adds the CA in use to the trust store of the operating
+// system: the current user's Root store on Windows, the System keychain on
+// macOS and the ca-certificates bundle on Linux. macOS and Linux ask for an
+// administrator password, Windows asks the user to confirm. It returns the
+// store the CA was added to. Firefox keeps its own store and is not covered.
+func (cm *CertificateManager) InstallSystemTrust() (string, error) {
+	caCert := cm.GetCertificate()
+	if caCert == nil {
+		return "", fmt.Errorf("no CA certificate has been set up")
+	}
+	fingerprint := sha256.Sum256(caCert.Raw)
+	// Named after the CA, so project CAs and regenerated ones sit side by side
+	name := "prokzee-" + hex.EncodeToString(fingerprint[:4]) + ".crt"
+
+	dir, err := os.MkdirTemp("", "prokzee-ca")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, name)
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0644); err != nil {
+		return "", fmt.Errorf("failed to write CA certificate: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), installTimeout)
+	defer cancel()
+
+	switch runtime.GOOS {
+	case "windows":
+		return "Windows certificate store (current user, Trusted Root Certification Authorities)",
+			run(ctx, "certutil", "-user", "-addstore", "Root", certPath)
+	case "darwin":
+		command := "/usr/bin/security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain " + shellQuote(certPath)
+		script := fmt.Sprintf("do shell script %s with administrator privileges", appleScriptString(command))
+		return "macOS System keychain", run(ctx, "osascript", "-e", script)
+	case "linux":
+		for _, store := range linuxTrustStores {
+			if info, err := os.Stat(store.dir); err != nil || !info.IsDir() {
+				continue
+			}
+			if _, err := exec.LookPath(store.refresh[0]); err != nil {
+				continue
+			}
+			args := []string{"sh", "-c", `cp "$1" "$2" && chmod 644 "$2" && shift 2 && "$@"`,
+				"sh", certPath, filepath.Join(store.dir, name)}
+			args = append(args, store.refresh...)
+			if os.Geteuid() != 0 {
+				if _, err := exec.LookPath("pkexec"); err != nil {
+					return "", fmt.Errorf("administrator rights are needed and pkexec is not installed, copy the CA to %s and run %s as root",
+						store.dir, strings.Join(store.refresh, " "))
+				}
+				args = append([]string{"pkexec"}, args...)
+			}
+			return store.dir, run(ctx, args[0], args[1:]...)
+		}
+		return "", fmt.Errorf("no supported system CA store was found, install the CA by hand")
+	}
+	return "", fmt.Errorf("installing the CA is not supported on %s", runtime.GOOS)
+}
+
+// run runs a command, reporting its output when it fails
+func run(ctx context.Context, name string, args ...string) error {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s timed out waiting for confirmation", name)
+	}
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s failed: %s", name, message)
+		}
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// appleScriptString quotes a string as an AppleScript literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,171 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// Key types of minted leaf certificates
+const (
+	KeyECDSA = "ecdsa"
+	KeyRSA   = "rsa"
+)
+
+// MaxLeafDays is the longest validity browsers accept for a server
+// certificate, also the default
+const MaxLeafDays = 397
+
+// dnsName matches a host name SAN, optionally a *. wildcard of one label
+var dnsName = regexp.MustCompile(`^(\*\.)?([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// LeafRequest describes a leaf certificate to mint
+type LeafRequest struct {
+	Hosts   []string `json:"hosts"`   // host names, *.example.com wildcards and IP addresses
+	Days    int      `json:"days"`    // MaxLeafDays when 0
+	KeyType string   `json:"keyType"` // KeyECDSA when empty
+}
+
+// Leaf is a minted certificate with its key and the CA that signed it, all
+// PEM encoded
+type Leaf struct {
+	Subject     string   `json:"subject"`
+	DNSNames    []string `json:"dnsNames"`
+	IPAddresses []string `json:"ipAddresses"`
+	NotBefore   string   `json:"notBefore"`
+	NotAfter    string   `json:"notAfter"`
+	Fingerprint string   `json:"fingerprint"` // SHA-256 of the certificate
+	CertPEM     string   `json:"certPem"`
+	ChainPEM    string   `json:"chainPem"` // the certificate followed by the CA
+	KeyPEM      string   `json:"-"`
+}
+
+// MintLeaf signs a server certificate for the given hosts with the CA in
+// use, for mock servers that clients trusting the CA should accept. The
+// first host becomes the common name. It never expires after the CA.
+func (cm *CertificateManager) MintLeaf(request LeafRequest) (*Leaf, error) {
+	caCert, caTLS := cm.GetCertificate(), cm.GetTLSCertificate()
+	if caCert == nil {
+		return nil, fmt.Errorf("no CA certificate has been set up")
+	}
+	caKey, ok := caTLS.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the CA key cannot sign certificates")
+	}
+
+	template := &x509.Certificate{
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	for _, host := range request.Hosts {
+		host = strings.ToLower(strings.Trim(strings.TrimSpace(host), "[]"))
+		if host == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(host); err == nil {
+			template.IPAddresses = append(template.IPAddresses, net.IP(addr.WithZone("").AsSlice()))
+			continue
+		}
+		// Internationalized names go in as punycode
+		wildcard := strings.HasPrefix(host, "*.")
+		name, err := idna.Lookup.ToASCII(strings.TrimPrefix(host, "*."))
+		if wildcard {
+			name = "*." + name
+		}
+		if err != nil || !dnsName.MatchString(name) {
+			return nil, fmt.Errorf("invalid host name %q", host)
+		}
+		template.DNSNames = append(template.DNSNames, name)
+	}
+	if len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 {
+		return nil, fmt.Errorf("no host names or IP addresses given")
+	}
+	if len(template.DNSNames) > 0 {
+		template.Subject = pkix.Name{CommonName: template.DNSNames[0]}
+	} else {
+		template.Subject = pkix.Name{CommonName: template.IPAddresses[0].String()}
+	}
+	template.Subject.Organization = []string{"ProKZee"}
+
+	days := request.Days
+	if days == 0 {
+		days = MaxLeafDays
+	}
+	if days < 1 || days > MaxLeafDays {
+		return nil, fmt.Errorf("validity must be between 1 and %d days", MaxLeafDays)
+	}
+	// Backdated a little so clocks running behind accept it
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+
+	var key crypto.Signer
+	switch request.KeyType {
+	case "", KeyECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	default:
+		return nil, fmt.Errorf("unknown key type %q", request.KeyType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}))
+	fingerprint := sha256.Sum256(der)
+	leaf := &Leaf{
+		Subject:     template.Subject.CommonName,
+		DNSNames:    template.DNSNames,
+		IPAddresses: []string{},
+		NotBefore:   template.NotBefore.Format(time.RFC3339),
+		NotAfter:    template.NotAfter.Format(time.RFC3339),
+		Fingerprint: strings.ToUpper(hex.EncodeToString(fingerprint[:])),
+		CertPEM:     certPEM,
+		ChainPEM:    certPEM + caPEM,
+		KeyPEM:      string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	}
+	if leaf.DNSNames == nil {
+		leaf.DNSNames = []string{}
+	}
+	for _, ip := range template.IPAddresses {
+		leaf.IPAddresses = append(leaf.IPAddresses, ip.String())
+	}
+	return leaf, nil
+}
@@ -0,0 +1,154 @@
+package changedetect
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	textextract "prokzee/internal/textextract"
+)
+
+// StableThreshold is how many identical responses in a row make an endpoint stable
+const StableThreshold = 3
+
+// Change describes a stable endpoint whose content changed
+type Change struct {
+	Endpoint       string `json:"endpoint"`
+	RequestID      int    `json:"requestId"`
+	PreviousHash   string `json:"previousHash"`
+	Hash           string `json:"hash"`
+	StableCount    int    `json:"stableCount"` // identical responses seen before the change
+	PreviousStatus int    `json:"previousStatus"`
+	Status         int    `json:"status"`
+}
+
+// Client tracks a hash of the latest response of every endpoint
+type Client struct {
+	db *sql.DB
+	mu sync.Mutex
+}
+
+// NewClient creates a new change detection client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure endpoint_hashes table exists: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the endpoint_hashes table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS endpoint_hashes (
+		endpoint TEXT PRIMARY KEY,
+		hash TEXT NOT NULL,
+		status INTEGER DEFAULT 0,
+		stable_count INTEGER NOT NULL DEFAULT 1,
+		last_request_id INTEGER DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := c.db.Exec(query); err != nil {
+		log.Printf("Error creating endpoint_hashes table: %v", err)
+		return fmt.Errorf("failed to create endpoint_hashes table: %v", err)
+	}
+	return nil
+}
+
+// Endpoint builds the key that identifies an endpoint, ignoring the query string
+func Endpoint(method, host, path string) string {
+	if path == "" {
+		path = "/"
+	}
+	return strings.ToUpper(method) + " " + strings.ToLower(host) + path
+}
+
+// renderedPrefix marks hashes of the rendered text of HTML responses, which
+// replaced hashes of the raw markup
+const renderedPrefix = "text:"
+
+// Observe records the response of a stored request. It returns a Change when
+// an endpoint that had been stable answers with different content, and marks
+// the stored request as changed. HTML responses are compared by their
+// rendered text so nonces and other dynamic attributes don't count.
+func (c *Client) Observe(requestID int, method, host, path string, status int, contentType string, body []byte) (*Change, error) {
+	endpoint := Endpoint(method, host, path)
+	hash := contentHash(contentType, body)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var previousHash string
+	var previousStatus, stableCount int
+	err := c.db.QueryRow("SELECT hash, status, stable_count FROM endpoint_hashes WHERE endpoint = ?", endpoint).
+		Scan(&previousHash, &previousStatus, &stableCount)
+	if err == sql.ErrNoRows {
+		_, err = c.db.Exec(`
+			INSERT INTO endpoint_hashes (endpoint, hash, status, stable_count, last_request_id)
+			VALUES (?, ?, ?, 1, ?)
+		`, endpoint, hash, status, requestID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record endpoint hash: %v", err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load endpoint hash: %v", err)
+	}
+
+	if previousHash == hash && previousStatus == status {
+		_, err = c.db.Exec(`
+			UPDATE endpoint_hashes SET stable_count = stable_count + 1, last_request_id = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE endpoint = ?
+		`, requestID, endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update endpoint hash: %v", err)
+		}
+		return nil, nil
+	}
+
+	_, err = c.db.Exec(`
+		UPDATE endpoint_hashes SET hash = ?, status = ?, stable_count = 1, last_request_id = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE endpoint = ?
+	`, hash, status, requestID, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update endpoint hash: %v", err)
+	}
+
+	// Endpoints that never settled on one response are not worth flagging, and
+	// neither are hashes recorded before HTML was compared as rendered text
+	if stableCount < StableThreshold || strings.HasPrefix(hash, renderedPrefix) != strings.HasPrefix(previousHash, renderedPrefix) {
+		return nil, nil
+	}
+
+	if _, err := c.db.Exec("UPDATE requests SET content_changed = 1 WHERE id = ?", requestID); err != nil {
+		return nil, fmt.Errorf("failed to mark request as changed: %v", err)
+	}
+
+	return &Change{
+		Endpoint:       endpoint,
+		RequestID:      requestID,
+		PreviousHash:   previousHash,
+		Hash:           hash,
+		StableCount:    stableCount,
+		PreviousStatus: previousStatus,
+		Status:         status,
+	}, nil
+}
+
+// contentHash hashes a response body, or the rendered text of an HTML response
+func contentHash(contentType string, body []byte) string {
+	if textextract.IsHTML(contentType, body) {
+		sum := sha256.Sum256([]byte(textextract.Extract(string(body))))
+		return renderedPrefix + hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
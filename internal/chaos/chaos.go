@@ -0,0 +1,375 @@
+package chaos
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions a chaos rule applies to a response
+const (
+	ActionDelay    = "delay"
+	ActionTruncate = "truncate"
+	ActionFail     = "fail"
+)
+
+// maxDelay bounds the delay of a rule, longer ones look like a hung proxy
+const maxDelay = 5 * time.Minute
+
+// Rule degrades the responses to requests whose URL matches its pattern, on
+// a share of them, to test how clients cope with slow, cut or failing servers
+type Rule struct {
+	ID            int    `json:"id"`
+	RuleName      string `json:"rule_name"`
+	Method        string `json:"method"`      // empty matches any method
+	URLPattern    string `json:"url_pattern"` // full URL, * matches any run of characters
+	Action        string `json:"action"`
+	DelayMs       int    `json:"delay_ms"`       // delay, with up to JitterMs more at random
+	JitterMs      int    `json:"jitter_ms"`      // delay only
+	TruncateBytes int    `json:"truncate_bytes"` // bytes of the body kept, truncate only
+	StatusCode    int    `json:"status_code"`    // status the response is replaced with, fail only
+	Probability   int    `json:"probability"`    // percent of matching responses affected
+	Enabled       bool   `json:"enabled"`
+}
+
+// Client represents the chaos client
+type Client struct {
+	db       *sql.DB
+	mu       sync.RWMutex
+	rules    []Rule
+	patterns map[int]*regexp.Regexp
+	random   *rand.Rand
+	randMu   sync.Mutex
+}
+
+// NewClient creates a new chaos client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{
+		db:     db,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure chaos_rules table exists: %v", err)
+	}
+
+	if err := client.loadRules(); err != nil {
+		return nil, fmt.Errorf("failed to load chaos rules: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the chaos_rules table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS chaos_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_name TEXT,
+		method TEXT DEFAULT '',
+		url_pattern TEXT NOT NULL,
+		action TEXT NOT NULL,
+		delay_ms INTEGER DEFAULT 0,
+		jitter_ms INTEGER DEFAULT 0,
+		truncate_bytes INTEGER DEFAULT 0,
+		status_code INTEGER DEFAULT 503,
+		probability INTEGER DEFAULT 100,
+		enabled BOOLEAN
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create chaos_rules table: %v", err)
+	}
+	return nil
+}
+
+// compilePattern turns a URL pattern into an anchored regular expression
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(strings.TrimSpace(pattern))
+	return regexp.Compile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+}
+
+// validate checks a rule and normalizes its fields
+func validate(rule *Rule) error {
+	rule.URLPattern = strings.TrimSpace(rule.URLPattern)
+	if rule.URLPattern == "" {
+		return fmt.Errorf("URL pattern cannot be empty")
+	}
+	if _, err := compilePattern(rule.URLPattern); err != nil {
+		return fmt.Errorf("invalid URL pattern: %v", err)
+	}
+	rule.Method = strings.ToUpper(strings.TrimSpace(rule.Method))
+	if rule.Probability < 0 || rule.Probability > 100 {
+		return fmt.Errorf("probability must be between 0 and 100 percent")
+	}
+
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	switch rule.Action {
+	case ActionDelay:
+		if rule.DelayMs < 0 || rule.JitterMs < 0 {
+			return fmt.Errorf("delay and jitter cannot be negative")
+		}
+		if time.Duration(rule.DelayMs+rule.JitterMs)*time.Millisecond > maxDelay {
+			return fmt.Errorf("delay and jitter cannot exceed %v together", maxDelay)
+		}
+		if rule.DelayMs == 0 && rule.JitterMs == 0 {
+			return fmt.Errorf("a delay rule needs a delay or a jitter")
+		}
+	case ActionTruncate:
+		if rule.TruncateBytes < 0 {
+			return fmt.Errorf("truncated length cannot be negative")
+		}
+	case ActionFail:
+		if rule.StatusCode == 0 {
+			rule.StatusCode = http.StatusServiceUnavailable
+		}
+		if rule.StatusCode < 100 || rule.StatusCode > 599 {
+			return fmt.Errorf("invalid status code %d", rule.StatusCode)
+		}
+	default:
+		return fmt.Errorf("unknown action %q, expected delay, truncate or fail", rule.Action)
+	}
+	return nil
+}
+
+// GetAllRules returns all chaos rules
+func (c *Client) GetAllRules() ([]Rule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Rule{}, c.rules...), nil
+}
+
+// AddRule adds a new chaos rule
+func (c *Client) AddRule(rule Rule) (Rule, error) {
+	if err := validate(&rule); err != nil {
+		return rule, err
+	}
+
+	result, err := c.db.Exec(`
+		INSERT INTO chaos_rules (rule_name, method, url_pattern, action, delay_ms, jitter_ms, truncate_bytes, status_code, probability, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.RuleName, rule.Method, rule.URLPattern, rule.Action, rule.DelayMs, rule.JitterMs, rule.TruncateBytes, rule.StatusCode, rule.Probability, rule.Enabled)
+	if err != nil {
+		return rule, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return rule, err
+	}
+	rule.ID = int(id)
+
+	c.mu.Lock()
+	c.rules = append(c.rules, rule)
+	c.compile(rule)
+	c.mu.Unlock()
+	return rule, nil
+}
+
+// UpdateRule updates an existing chaos rule
+func (c *Client) UpdateRule(rule Rule) error {
+	if err := validate(&rule); err != nil {
+		return err
+	}
+
+	_, err := c.db.Exec(`
+		UPDATE chaos_rules
+		SET rule_name = ?, method = ?, url_pattern = ?, action = ?, delay_ms = ?, jitter_ms = ?, truncate_bytes = ?, status_code = ?, probability = ?, enabled = ?
+		WHERE id = ?
+	`, rule.RuleName, rule.Method, rule.URLPattern, rule.Action, rule.DelayMs, rule.JitterMs, rule.TruncateBytes, rule.StatusCode, rule.Probability, rule.Enabled, rule.ID)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.rules {
+		if r.ID == rule.ID {
+			c.rules[i] = rule
+			break
+		}
+	}
+	c.compile(rule)
+	return nil
+}
+
+// DeleteRule deletes a chaos rule
+func (c *Client) DeleteRule(ruleID int) error {
+	if _, err := c.db.Exec(`DELETE FROM chaos_rules WHERE id = ?`, ruleID); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, rule := range c.rules {
+		if rule.ID == ruleID {
+			c.rules = append(c.rules[:i], c.rules[i+1:]...)
+			break
+		}
+	}
+	delete(c.patterns, ruleID)
+	return nil
+}
+
+// loadRules loads all chaos rules from the database
+func (c *Client) loadRules() error {
+	rows, err := c.db.Query(`
+		SELECT id, COALESCE(rule_name, ''), COALESCE(method, ''), url_pattern, action, COALESCE(delay_ms, 0),
+			COALESCE(jitter_ms, 0), COALESCE(truncate_bytes, 0), COALESCE(status_code, 503), COALESCE(probability, 100), enabled
+		FROM chaos_rules
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		var rule Rule
+		if err := rows.Scan(&rule.ID, &rule.RuleName, &rule.Method, &rule.URLPattern, &rule.Action, &rule.DelayMs,
+			&rule.JitterMs, &rule.TruncateBytes, &rule.StatusCode, &rule.Probability, &rule.Enabled); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = rules
+	c.patterns = make(map[int]*regexp.Regexp, len(rules))
+	for _, rule := range rules {
+		c.compile(rule)
+	}
+	return nil
+}
+
+// compile caches the pattern of a rule, the caller holds the lock
+func (c *Client) compile(rule Rule) {
+	if c.patterns == nil {
+		c.patterns = make(map[int]*regexp.Regexp)
+	}
+	pattern, err := compilePattern(rule.URLPattern)
+	if err != nil {
+		log.Printf("Invalid chaos pattern %q: %v", rule.URLPattern, err)
+		delete(c.patterns, rule.ID)
+		return
+	}
+	c.patterns[rule.ID] = pattern
+}
+
+// matching returns the enabled rules covering a request, in order
+func (c *Client) matching(req *http.Request) []Rule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	target := req.URL.String()
+	if req.URL.Host == "" {
+		// Requests inside a MITM tunnel may carry the host in Host only
+		target = "https://" + req.Host + req.URL.RequestURI()
+	}
+	var rules []Rule
+	for _, rule := range c.rules {
+		if !rule.Enabled || (rule.Method != "" && !strings.EqualFold(rule.Method, req.Method)) {
+			continue
+		}
+		if pattern := c.patterns[rule.ID]; pattern != nil && pattern.MatchString(target) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// roll reports whether a rule with the given probability applies this time
+func (c *Client) roll(probability int) bool {
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+	return c.random.Intn(100) < probability
+}
+
+// jitter returns a random delay below max milliseconds
+func (c *Client) jitter(max int) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+	return time.Duration(c.random.Intn(max+1)) * time.Millisecond
+}
+
+// Apply runs the rules covering a request against its response, each on its
+// share of responses, and returns the response the client gets with what was
+// done to it, "" when nothing was. A failure replaces the response and ends
+// the rules.
+func (c *Client) Apply(req *http.Request, resp *http.Response) (*http.Response, string) {
+	if resp == nil {
+		return resp, ""
+	}
+	var applied []string
+	for _, rule := range c.matching(req) {
+		if !c.roll(rule.Probability) {
+			continue
+		}
+		switch rule.Action {
+		case ActionDelay:
+			delay := time.Duration(rule.DelayMs)*time.Millisecond + c.jitter(rule.JitterMs)
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+			}
+			applied = append(applied, fmt.Sprintf("delayed %v by %q", delay, rule.RuleName))
+		case ActionTruncate:
+			if resp.Body == nil {
+				continue
+			}
+			resp = truncate(resp, rule.TruncateBytes)
+			applied = append(applied, fmt.Sprintf("truncated to %d bytes by %q", rule.TruncateBytes, rule.RuleName))
+		case ActionFail:
+			if resp.Body != nil {
+				resp.Body.Close()
+			}
+			resp = failure(req, rule)
+			applied = append(applied, fmt.Sprintf("failed with %d by %q", rule.StatusCode, rule.RuleName))
+			return resp, strings.Join(applied, ", ")
+		}
+	}
+	return resp, strings.Join(applied, ", ")
+}
+
+// truncate keeps the first n bytes of a body. The headers are kept, only
+// the length follows, so an encoded body is cut as received.
+func truncate(resp *http.Response, n int) *http.Response {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, int64(n)))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Del("Transfer-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return resp
+}
+
+// failure builds the response a fail rule replaces the server's with
+func failure(req *http.Request, rule Rule) *http.Response {
+	message := fmt.Sprintf("Failed by chaos rule %q", rule.RuleName)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rule.StatusCode, http.StatusText(rule.StatusCode)),
+		StatusCode:    rule.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(message)),
+		ContentLength: int64(len(message)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,189 @@
+package clientcert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"prokzee/internal/upstream"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// Certificate is a client certificate mapped to the hosts it is presented to.
+// The private key is never sent to the frontend.
+type Certificate struct {
+	ID        int    `json:"id"`
+	Host      string `json:"host"`
+	Subject   string `json:"subject"`
+	Issuer    string `json:"issuer"`
+	NotAfter  string `json:"notAfter"`
+	Expired   bool   `json:"expired"`
+	CreatedAt string `json:"createdAt"`
+	certPEM   string
+	keyPEM    string
+}
+
+// Client stores the client certificates of a project
+type Client struct {
+	db *sql.DB
+}
+
+// NewClient creates a new client certificates client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure client certificates table exists: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the client_certificates table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS client_certificates (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL,
+		cert_pem TEXT NOT NULL,
+		key_pem TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := c.db.Exec(query); err != nil {
+		log.Printf("Error creating client_certificates table: %v", err)
+		return fmt.Errorf("failed to create client_certificates table: %v", err)
+	}
+	return nil
+}
+
+// ParsePEM checks a PEM certificate chain and private key and returns them
+// normalized
+func ParsePEM(certPEM, keyPEM string) (string, string, error) {
+	if _, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM)); err != nil {
+		return "", "", fmt.Errorf("invalid certificate or key: %v", err)
+	}
+	return strings.TrimSpace(certPEM) + "\n", strings.TrimSpace(keyPEM) + "\n", nil
+}
+
+// ParsePKCS12 converts a base64 encoded PKCS#12 (.p12 or .pfx) file into a PEM
+// certificate chain and private key
+func ParsePKCS12(encoded, password string) (string, string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid PKCS#12 data: %v", err)
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode PKCS#12 file: %v", err)
+	}
+
+	var certs, key bytes.Buffer
+	for _, block := range blocks {
+		// Drop the bag attributes, which are not valid PEM headers for Go
+		block.Headers = nil
+		switch {
+		case block.Type == "CERTIFICATE":
+			pem.Encode(&certs, block)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			pem.Encode(&key, block)
+		}
+	}
+	if certs.Len() == 0 || key.Len() == 0 {
+		return "", "", fmt.Errorf("PKCS#12 file has no certificate and private key")
+	}
+	return ParsePEM(certs.String(), key.String())
+}
+
+// GetCertificates returns the client certificates in the order they are matched
+func (c *Client) GetCertificates() ([]Certificate, error) {
+	rows, err := c.db.Query(`
+		SELECT id, host, cert_pem, key_pem, COALESCE(created_at, '')
+		FROM client_certificates
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query client certificates: %v", err)
+	}
+	defer rows.Close()
+
+	certificates := []Certificate{}
+	for rows.Next() {
+		var certificate Certificate
+		if err := rows.Scan(&certificate.ID, &certificate.Host, &certificate.certPEM, &certificate.keyPEM, &certificate.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan client certificate: %v", err)
+		}
+		certificate.describe()
+		certificates = append(certificates, certificate)
+	}
+	return certificates, rows.Err()
+}
+
+// AddCertificate stores a PEM certificate and key for a host or wildcard
+func (c *Client) AddCertificate(host, certPEM, keyPEM string) error {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return fmt.Errorf("host is required")
+	}
+	certPEM, keyPEM, err := ParsePEM(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.db.Exec("INSERT INTO client_certificates (host, cert_pem, key_pem) VALUES (?, ?, ?)", host, certPEM, keyPEM); err != nil {
+		return fmt.Errorf("failed to add client certificate: %v", err)
+	}
+	return nil
+}
+
+// DeleteCertificate removes a client certificate
+func (c *Client) DeleteCertificate(id int) error {
+	if _, err := c.db.Exec("DELETE FROM client_certificates WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete client certificate: %v", err)
+	}
+	return nil
+}
+
+// Upstream returns the certificates ready for upstream.SetClientCertificates.
+// Entries that no longer parse are skipped.
+func (c *Client) Upstream() ([]upstream.ClientCertificate, error) {
+	certificates, err := c.GetCertificates()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []upstream.ClientCertificate
+	for _, certificate := range certificates {
+		pair, err := tls.X509KeyPair([]byte(certificate.certPEM), []byte(certificate.keyPEM))
+		if err != nil {
+			log.Printf("Skipping client certificate for %s: %v", certificate.Host, err)
+			continue
+		}
+		result = append(result, upstream.ClientCertificate{Host: certificate.Host, Certificate: pair})
+	}
+	return result, nil
+}
+
+// describe fills in the fields shown to the user from the leaf certificate
+func (c *Certificate) describe() {
+	block, _ := pem.Decode([]byte(c.certPEM))
+	if block == nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return
+	}
+	c.Subject = leaf.Subject.String()
+	c.Issuer = leaf.Issuer.String()
+	c.NotAfter = leaf.NotAfter.Format(time.RFC3339)
+	c.Expired = time.Now().After(leaf.NotAfter)
+}
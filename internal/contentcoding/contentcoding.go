@@ -0,0 +1,174 @@
+package contentcoding
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnsupported is returned for content codings that cannot be undone
+var ErrUnsupported = errors.New("unsupported content encoding")
+
+// Codings splits a Content-Encoding header into its codings, in the order the
+// sender applied them. identity is dropped.
+func Codings(contentEncoding string) []string {
+	var codings []string
+	for _, coding := range strings.Split(contentEncoding, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// IsIdentity reports whether a Content-Encoding header leaves the body as is
+func IsIdentity(contentEncoding string) bool {
+	return len(Codings(contentEncoding)) == 0
+}
+
+// Supported reports whether every coding of a Content-Encoding header can be
+// undone and applied again
+func Supported(contentEncoding string) bool {
+	for _, coding := range Codings(contentEncoding) {
+		switch coding {
+		case "gzip", "x-gzip", "deflate", "br", "zstd":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewReader returns a reader of r with the codings of contentEncoding undone
+func NewReader(r io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	if !Supported(contentEncoding) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, contentEncoding)
+	}
+
+	codings := Codings(contentEncoding)
+	reader := io.NopCloser(r)
+	var closers []io.Closer
+	// The last coding applied is the first one undone
+	for i := len(codings) - 1; i >= 0; i-- {
+		next, err := newDecoder(reader, codings[i])
+		if err != nil {
+			for _, closer := range closers {
+				closer.Close()
+			}
+			return nil, fmt.Errorf("failed to decode %s body: %v", codings[i], err)
+		}
+		closers = append(closers, next)
+		reader = next
+	}
+	return &multiCloser{Reader: reader, closers: closers}, nil
+}
+
+// Decode undoes the codings of contentEncoding, returning at most limit bytes
+// when limit is positive. A body cut short still returns what could be
+// decoded, along with the error.
+func Decode(body []byte, contentEncoding string, limit int64) ([]byte, error) {
+	if IsIdentity(contentEncoding) {
+		return body, nil
+	}
+	reader, err := NewReader(bytes.NewReader(body), contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var source io.Reader = reader
+	if limit > 0 {
+		source = io.LimitReader(reader, limit)
+	}
+	return io.ReadAll(source)
+}
+
+// Encode applies the codings of contentEncoding to body, in order
+func Encode(body []byte, contentEncoding string) ([]byte, error) {
+	if !Supported(contentEncoding) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, contentEncoding)
+	}
+	for _, coding := range Codings(contentEncoding) {
+		var buffer bytes.Buffer
+		var writer io.WriteCloser
+		switch coding {
+		case "gzip", "x-gzip":
+			writer = gzip.NewWriter(&buffer)
+		case "deflate":
+			writer = zlib.NewWriter(&buffer)
+		case "br":
+			writer = brotli.NewWriter(&buffer)
+		case "zstd":
+			encoder, err := zstd.NewWriter(&buffer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+			}
+			writer = encoder
+		}
+		if _, err := writer.Write(body); err != nil {
+			return nil, fmt.Errorf("failed to encode %s body: %v", coding, err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode %s body: %v", coding, err)
+		}
+		body = buffer.Bytes()
+	}
+	return body, nil
+}
+
+// newDecoder returns a reader undoing a single coding
+func newDecoder(r io.Reader, coding string) (io.ReadCloser, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// Servers send deflate both with and without the zlib wrapper
+		buffered := bufio.NewReader(r)
+		if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, coding)
+}
+
+// isZlibHeader reports whether two bytes start a zlib stream: deflate
+// compression and a header checksum that is a multiple of 31
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// multiCloser closes every decoder of a chain
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close implements io.Closer
+func (m *multiCloser) Close() error {
+	var first error
+	for _, closer := range m.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
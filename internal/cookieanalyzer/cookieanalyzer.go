@@ -0,0 +1,255 @@
+package cookieanalyzer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+)
+
+// Source identifies findings created by the cookie analyzer
+const Source = "cookie-analyzer"
+
+// longExpiration is the lifetime above which a cookie is considered long-lived
+const longExpiration = 365 * 24 * time.Hour
+
+// maxFindings caps how many of the worst cookies are turned into findings
+const maxFindings = 10
+
+// Issue weights, used to rank cookies against each other
+var issueWeights = map[string]int{
+	"missing Secure":               3,
+	"missing HttpOnly":             3,
+	"missing SameSite":             1,
+	"SameSite=None without Secure": 3,
+	"broad domain":                 2,
+	"broad path":                   1,
+	"long expiration":              1,
+}
+
+// CookieReport describes one cookie name as set by one host
+type CookieReport struct {
+	Host      string   `json:"host"`
+	Name      string   `json:"name"`
+	Domain    string   `json:"domain"`
+	Path      string   `json:"path"`
+	Issues    []string `json:"issues"`
+	Score     int      `json:"score"`
+	Seen      int      `json:"seen"`
+	RequestID int      `json:"requestId"`
+	URL       string   `json:"url"`
+}
+
+// HostSummary counts cookie issues of a host
+type HostSummary struct {
+	Host    string         `json:"host"`
+	Cookies int            `json:"cookies"`
+	Issues  map[string]int `json:"issues"`
+}
+
+// Report is the result of an analysis run
+type Report struct {
+	Cookies []CookieReport `json:"cookies"`
+	Hosts   []HostSummary  `json:"hosts"`
+}
+
+// Analyzer inspects the cookies set by stored responses
+type Analyzer struct {
+	db *sql.DB
+}
+
+// NewAnalyzer creates a new cookie analyzer
+func NewAnalyzer(db *sql.DB) *Analyzer {
+	return &Analyzer{db: db}
+}
+
+// Run analyzes every stored response whose host passes the filter. A nil
+// filter analyzes all hosts.
+func (a *Analyzer) Run(filter func(host string) bool) (*Report, error) {
+	rows, err := a.db.Query(`
+		SELECT id, COALESCE(domain, ''), COALESCE(url, ''), COALESCE(response_headers, '')
+		FROM requests
+		WHERE response_headers LIKE '%Set-Cookie%'
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query responses: %v", err)
+	}
+	defer rows.Close()
+
+	cookies := make(map[string]*CookieReport)
+	allowed := make(map[string]bool)
+
+	for rows.Next() {
+		var id int
+		var host, rawURL, rawHeaders string
+		if err := rows.Scan(&id, &host, &rawURL, &rawHeaders); err != nil {
+			return nil, fmt.Errorf("failed to scan response: %v", err)
+		}
+
+		if _, seen := allowed[host]; !seen {
+			allowed[host] = filter == nil || filter(host)
+		}
+		if !allowed[host] {
+			continue
+		}
+
+		var headers http.Header
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			continue
+		}
+
+		requestPath := "/"
+		if parsed, err := url.Parse(rawURL); err == nil && parsed.Path != "" {
+			requestPath = parsed.Path
+		}
+
+		for _, cookie := range (&http.Response{Header: headers}).Cookies() {
+			key := host + "|" + cookie.Name
+			report, ok := cookies[key]
+			if !ok {
+				report = &CookieReport{Host: host, Name: cookie.Name}
+				cookies[key] = report
+			}
+			report.Seen++
+
+			// Keep the most recent observation of the cookie
+			issues := analyze(cookie, hostname(host), requestPath)
+			report.Domain = cookie.Domain
+			report.Path = cookie.Path
+			report.Issues = issues
+			report.Score = score(issues)
+			report.RequestID = id
+			report.URL = rawURL
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read responses: %v", err)
+	}
+
+	report := &Report{Cookies: []CookieReport{}, Hosts: []HostSummary{}}
+	summaries := make(map[string]*HostSummary)
+	for _, cookie := range cookies {
+		report.Cookies = append(report.Cookies, *cookie)
+
+		summary, ok := summaries[cookie.Host]
+		if !ok {
+			summary = &HostSummary{Host: cookie.Host, Issues: make(map[string]int)}
+			summaries[cookie.Host] = summary
+		}
+		summary.Cookies++
+		for _, issue := range cookie.Issues {
+			summary.Issues[issue]++
+		}
+	}
+	for _, summary := range summaries {
+		report.Hosts = append(report.Hosts, *summary)
+	}
+
+	sort.Slice(report.Cookies, func(i, j int) bool {
+		if report.Cookies[i].Score != report.Cookies[j].Score {
+			return report.Cookies[i].Score > report.Cookies[j].Score
+		}
+		return report.Cookies[i].Host+report.Cookies[i].Name < report.Cookies[j].Host+report.Cookies[j].Name
+	})
+	sort.Slice(report.Hosts, func(i, j int) bool {
+		return report.Hosts[i].Host < report.Hosts[j].Host
+	})
+
+	return report, nil
+}
+
+// CreateFindings records the worst cookies of the report as findings and
+// returns how many were recorded
+func (r *Report) CreateFindings(client *findings.Client) (int, error) {
+	created := 0
+	for _, cookie := range r.Cookies {
+		if created >= maxFindings || cookie.Score < 3 {
+			break
+		}
+
+		severity := findings.SeverityLow
+		if cookie.Score >= 6 {
+			severity = findings.SeverityMedium
+		}
+
+		err := client.AddFinding(findings.Finding{
+			Source:    Source,
+			Host:      cookie.Host,
+			URL:       cookie.URL,
+			RequestID: cookie.RequestID,
+			Title:     fmt.Sprintf("Insecure cookie %s", cookie.Name),
+			Severity:  severity,
+			Detail:    strings.Join(cookie.Issues, ", "),
+			Key:       cookie.Host + "|" + cookie.Name,
+		})
+		if err != nil {
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// analyze returns the issues of a single Set-Cookie
+func analyze(cookie *http.Cookie, host, requestPath string) []string {
+	issues := []string{}
+
+	if !cookie.Secure {
+		issues = append(issues, "missing Secure")
+	}
+	if !cookie.HttpOnly {
+		issues = append(issues, "missing HttpOnly")
+	}
+	switch cookie.SameSite {
+	case http.SameSiteDefaultMode:
+		issues = append(issues, "missing SameSite")
+	case http.SameSiteNoneMode:
+		if !cookie.Secure {
+			issues = append(issues, "SameSite=None without Secure")
+		}
+	}
+
+	// A Domain attribute shares the cookie with every subdomain
+	domain := strings.TrimPrefix(strings.ToLower(cookie.Domain), ".")
+	if domain != "" && domain != host {
+		issues = append(issues, "broad domain")
+	}
+
+	// Scoped to the whole site although it was issued deeper in the tree
+	if cookie.Path == "/" && strings.Count(strings.TrimSuffix(requestPath, "/"), "/") > 1 {
+		issues = append(issues, "broad path")
+	}
+
+	if cookie.MaxAge > 0 && time.Duration(cookie.MaxAge)*time.Second > longExpiration {
+		issues = append(issues, "long expiration")
+	} else if cookie.MaxAge == 0 && !cookie.Expires.IsZero() && time.Until(cookie.Expires) > longExpiration {
+		issues = append(issues, "long expiration")
+	}
+
+	return issues
+}
+
+// score sums the weights of the issues
+func score(issues []string) int {
+	total := 0
+	for _, issue := range issues {
+		total += issueWeights[issue]
+	}
+	return total
+}
+
+// hostname strips the port from a stored domain
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return strings.ToLower(h)
+	}
+	return strings.ToLower(host)
+}
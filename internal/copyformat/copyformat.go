@@ -0,0 +1,141 @@
+package copyformat
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"prokzee/internal/snippet"
+)
+
+// Copy formats
+const (
+	FormatRaw      = "raw"      // the raw HTTP requests, separated by a blank line
+	FormatCurl     = "curl"     // a curl command per request
+	FormatURLs     = "urls"     // the distinct URLs, one per line
+	FormatMarkdown = "markdown" // a table of method, URL, status, length and type
+)
+
+// separator goes between the requests of a multi-request copy
+const separator = "\n\n"
+
+// Summary is what the URL list and the table show of a request
+type Summary struct {
+	ID       int
+	Method   string
+	URL      string
+	Status   string
+	Length   int
+	MimeType string
+}
+
+// Build returns the text copied for requests of a source, snippet.SourceHistory
+// or snippet.SourceResender, in the order of ids. blobPath returns the path of
+// a blob named in the requests table.
+func Build(db *sql.DB, blobPath func(name string) string, source string, ids []int, format string) (string, error) {
+	if len(ids) == 0 {
+		return "", fmt.Errorf("no requests to copy")
+	}
+	switch format {
+	case FormatRaw, FormatCurl:
+		return buildSnippets(db, blobPath, source, ids, format)
+	case FormatURLs, FormatMarkdown:
+		summaries, err := loadSummaries(db, source, ids)
+		if err != nil {
+			return "", err
+		}
+		if format == FormatURLs {
+			return urlList(summaries), nil
+		}
+		return markdownTable(summaries), nil
+	}
+	return "", fmt.Errorf("unknown copy format: %s", format)
+}
+
+// buildSnippets renders each request as a snippet
+func buildSnippets(db *sql.DB, blobPath func(name string) string, source string, ids []int, format string) (string, error) {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		var req *snippet.Request
+		var err error
+		switch source {
+		case snippet.SourceHistory:
+			req, err = snippet.LoadHistory(db, blobPath, id)
+		case snippet.SourceResender:
+			req, err = snippet.LoadResender(db, id)
+		default:
+			return "", fmt.Errorf("unknown request source: %s", source)
+		}
+		if err != nil {
+			return "", err
+		}
+		text, err := snippet.Render(req, format)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, strings.TrimRight(text, "\n"))
+	}
+	return strings.Join(parts, separator) + "\n", nil
+}
+
+// loadSummaries reads the summaries of requests, in the order of ids
+func loadSummaries(db *sql.DB, source string, ids []int) ([]Summary, error) {
+	table := ""
+	switch source {
+	case snippet.SourceHistory:
+		table = "requests"
+	case snippet.SourceResender:
+		table = "resender_requests"
+	default:
+		return nil, fmt.Errorf("unknown request source: %s", source)
+	}
+
+	summaries := make([]Summary, 0, len(ids))
+	for _, id := range ids {
+		summary := Summary{ID: id}
+		err := db.QueryRow(`
+			SELECT method, COALESCE(url, ''), COALESCE(status, ''), COALESCE(length, 0), COALESCE(mime_type, '')
+			FROM `+table+` WHERE id = ?
+		`, id).Scan(&summary.Method, &summary.URL, &summary.Status, &summary.Length, &summary.MimeType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch request %d: %v", id, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// urlList lists the distinct URLs in the order they first appear
+func urlList(summaries []Summary) string {
+	seen := make(map[string]bool, len(summaries))
+	var b strings.Builder
+	for _, summary := range summaries {
+		if seen[summary.URL] {
+			continue
+		}
+		seen[summary.URL] = true
+		b.WriteString(summary.URL + "\n")
+	}
+	return b.String()
+}
+
+// markdownTable writes a Markdown table with a row per request
+func markdownTable(summaries []Summary) string {
+	var b strings.Builder
+	b.WriteString("| # | Method | URL | Status | Length | Type |\n")
+	b.WriteString("|---|--------|-----|--------|-------:|------|\n")
+	for _, summary := range summaries {
+		fmt.Fprintf(&b, "| %d | %s | %s | %s | %d | %s |\n", summary.ID, markdownCell(summary.Method),
+			markdownCell(summary.URL), markdownCell(summary.Status), summary.Length, markdownCell(summary.MimeType))
+	}
+	return b.String()
+}
+
+// markdownCell escapes a value for a table cell: pipes end the cell and line
+// breaks the row
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "|", `\|`)
+	value = strings.ReplaceAll(value, "\r", "")
+	return strings.ReplaceAll(value, "\n", " ")
+}
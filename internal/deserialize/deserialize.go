@@ -0,0 +1,331 @@
+package deserialize
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the deserialization prober
+const Source = "deserialization"
+
+// maxResponseBody bounds how much of a response is searched for errors
+const maxResponseBody = 512 * 1024
+
+// Locations of the parameters
+const (
+	LocationQuery  = probe.LocationQuery
+	LocationBody   = probe.LocationBody // a form encoded body parameter
+	LocationCookie = "cookie"           // a cookie of the Cookie header
+	LocationRaw    = "raw"              // the whole body
+)
+
+// Request is the resender request the probes are derived from
+type Request struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    string
+}
+
+// Parameter is a parameter the probes are sent in
+type Parameter struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Platform string `json:"platform"` // detected from the value, "" when named by the user
+}
+
+// Probe is the outcome of one payload in one parameter
+type Probe struct {
+	Parameter string `json:"parameter"`
+	Location  string `json:"location"`
+	Payload   string `json:"payload"`
+	Platform  string `json:"platform"`
+	Value     string `json:"value"`
+	Status    int    `json:"status"`
+	Length    int    `json:"length"`
+	Signature string `json:"signature"`
+	Hit       bool   `json:"hit"`
+	Error     string `json:"error"`
+	Note      string `json:"note"`
+}
+
+// Result is the outcome of a guided deserialization test
+type Result struct {
+	URL        string      `json:"url"`
+	Parameters []Parameter `json:"parameters"`
+	Probes     []Probe     `json:"probes"`
+}
+
+// target is the parsed request
+type target struct {
+	method  string
+	url     *url.URL
+	headers http.Header
+	host    string
+	query   []probe.Pair
+	form    []probe.Pair // nil unless the body is form encoded
+	cookies []probe.Pair
+	body    string
+}
+
+// Prober sends deserialization probes in the parameters of a request
+type Prober struct {
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewProber creates a new deserialization prober
+func NewProber(findingsClient *findings.Client) *Prober {
+	return &Prober{
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run sends payloads in the named parameters of a request, or in those whose
+// value looks like a serialized object when names is empty. Parameters get the
+// payloads of their detected platform, or every payload when the platform is
+// unknown, unless payloadNames picks them. A deserialization error counts
+// only when the original response did not already show it.
+func (p *Prober) Run(ctx context.Context, request Request, names, payloadNames []string) (*Result, error) {
+	t, err := parse(request)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters := t.parameters(names)
+	if len(parameters) == 0 {
+		if len(names) > 0 {
+			return nil, fmt.Errorf("none of the parameters %s were found", strings.Join(names, ", "))
+		}
+		return nil, fmt.Errorf("no parameter looks like a serialized object, name the parameters to test")
+	}
+
+	baselineStatus, baseline, err := p.send(ctx, t, t.query, t.form, t.cookies, t.body)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+	known := signatures(baseline)
+
+	result := &Result{URL: t.url.String(), Parameters: parameters, Probes: []Probe{}}
+	for _, parameter := range parameters {
+		for _, payload := range payloadsFor(parameter.Platform, payloadNames) {
+			if ctx.Err() != nil {
+				return result, nil
+			}
+			probe := Probe{
+				Parameter: parameter.Name,
+				Location:  parameter.Location,
+				Payload:   payload.Name,
+				Platform:  payload.Platform,
+				Value:     payload.Value,
+			}
+			status, body, err := p.sendVariant(ctx, t, parameter, payload.Value)
+			if err != nil {
+				probe.Error = err.Error()
+				result.Probes = append(result.Probes, probe)
+				continue
+			}
+			probe.Status, probe.Length = status, len(body)
+			for platform, match := range signatures(body) {
+				if _, ok := known[platform]; !ok {
+					probe.Hit = true
+					probe.Signature = match
+					probe.Note = fmt.Sprintf("A %s deserialization error appeared", platform)
+					break
+				}
+			}
+			if !probe.Hit && status >= 500 && baselineStatus < 500 {
+				probe.Note = fmt.Sprintf("Server error %d without a known deserialization message", status)
+			}
+			if probe.Hit {
+				p.record(t, probe)
+			}
+			result.Probes = append(result.Probes, probe)
+		}
+	}
+	return result, nil
+}
+
+// record stores a probe that made a deserializer fail as a finding, with the
+// exact value sent
+func (p *Prober) record(t *target, probe Probe) {
+	err := p.findings.AddFinding(findings.Finding{
+		Source:   Source,
+		Host:     t.url.Hostname(),
+		URL:      t.url.String(),
+		Title:    fmt.Sprintf("Deserialization of %s parameter %s", probe.Location, probe.Parameter),
+		Severity: findings.SeverityMedium,
+		Detail: fmt.Sprintf("The %s payload made the server report a deserialization error (status %d)\nSignature: %s\nValue sent: %s",
+			probe.Payload, probe.Status, probe.Signature, probe.Value),
+		Key: t.method + "|" + t.url.Host + t.url.Path + "|" + probe.Location + "|" + probe.Parameter,
+	})
+	if err != nil {
+		log.Printf("Failed to record deserialization finding: %v", err)
+	}
+}
+
+// payloadsFor returns the payloads named, or those of a platform, or all of
+// them when the platform is unknown
+func payloadsFor(platform string, names []string) []Payload {
+	var out []Payload
+	for _, payload := range Payloads {
+		switch {
+		case len(names) > 0:
+			for _, name := range names {
+				if strings.EqualFold(payload.Name, name) {
+					out = append(out, payload)
+					break
+				}
+			}
+		case platform == "" || payload.Platform == platform:
+			out = append(out, payload)
+		}
+	}
+	return out
+}
+
+// parameters returns the named parameters, or those holding a serialized
+// value when names is empty
+func (t *target) parameters(names []string) []Parameter {
+	var out []Parameter
+	consider := func(location, name, raw string) {
+		platform := Detect(probe.Decode(raw))
+		if len(names) == 0 {
+			if platform != "" {
+				out = append(out, Parameter{Name: name, Location: location, Platform: platform})
+			}
+			return
+		}
+		for _, wanted := range names {
+			if strings.EqualFold(wanted, name) {
+				out = append(out, Parameter{Name: name, Location: location, Platform: platform})
+				return
+			}
+		}
+	}
+	for _, p := range t.query {
+		consider(LocationQuery, probe.Decode(p.Name), p.Value)
+	}
+	for _, p := range t.form {
+		consider(LocationBody, probe.Decode(p.Name), p.Value)
+	}
+	for _, p := range t.cookies {
+		consider(LocationCookie, p.Name, p.Value)
+	}
+	if t.form == nil && t.body != "" {
+		consider(LocationRaw, "body", t.body)
+	}
+	return out
+}
+
+// parse reads a resender request
+func parse(request Request) (*target, error) {
+	parsed, err := url.Parse(request.URL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid request URL %q", request.URL)
+	}
+	method := request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	headers := http.Header{}
+	for name, value := range request.Headers {
+		headers.Set(name, value)
+	}
+	headers.Del("Content-Length")
+	host := headers.Get("Host")
+	headers.Del("Host")
+
+	t := &target{
+		method:  method,
+		url:     parsed,
+		headers: headers,
+		host:    host,
+		query:   probe.SplitPairs(parsed.RawQuery, "&"),
+		cookies: probe.SplitPairs(headers.Get("Cookie"), ";"),
+		body:    request.Body,
+	}
+	if strings.HasPrefix(strings.ToLower(headers.Get("Content-Type")), "application/x-www-form-urlencoded") {
+		t.form = probe.SplitPairs(request.Body, "&")
+	}
+	return t, nil
+}
+
+// sendVariant sends the request with one parameter set to value
+func (p *Prober) sendVariant(ctx context.Context, t *target, parameter Parameter, value string) (int, []byte, error) {
+	query, form, cookies, body := t.query, t.form, t.cookies, t.body
+	switch parameter.Location {
+	case LocationQuery:
+		query = replaced(query, parameter.Name, url.QueryEscape(value))
+	case LocationBody:
+		form = replaced(form, parameter.Name, url.QueryEscape(value))
+	case LocationCookie:
+		// Cookie values cannot hold quotes, semicolons or spaces
+		cookies = replaced(cookies, parameter.Name, url.QueryEscape(value))
+	case LocationRaw:
+		body = value
+	}
+	return p.send(ctx, t, query, form, cookies, body)
+}
+
+// send sends the request with the given query, form body, cookies and body
+func (p *Prober) send(ctx context.Context, t *target, query, form, cookies []probe.Pair, body string) (int, []byte, error) {
+	u := *t.url
+	u.RawQuery = probe.JoinPairs(query, "&")
+	if t.form != nil {
+		body = probe.JoinPairs(form, "&")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, t.method, u.String(), strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header = t.headers.Clone()
+	if t.host != "" {
+		req.Host = t.host
+	}
+	if len(cookies) > 0 {
+		req.Header.Set("Cookie", probe.JoinPairs(cookies, "; "))
+	}
+	// Ask for the body as is, so it can be searched
+	req.Header.Del("Accept-Encoding")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	return resp.StatusCode, respBody, nil
+}
+
+// replaced returns a copy of pairs with the value of the first pair named
+// name replaced
+func replaced(pairs []probe.Pair, name, value string) []probe.Pair {
+	out := append([]probe.Pair{}, pairs...)
+	for i, p := range out {
+		if p.Name == name || probe.Decode(p.Name) == name {
+			out[i].Value = value
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package deserialize
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// Platforms of serialized objects
+const (
+	PlatformJava   = "java"
+	PlatformDotNet = "dotnet"
+	PlatformPHP    = "php"
+	PlatformPython = "python"
+)
+
+// Payload is a serialized object sent in place of a parameter value. The
+// payloads are harmless probes, malformed or of unexpected types, meant to
+// make a deserializer fail visibly and never to run code.
+type Payload struct {
+	Name        string `json:"name"`
+	Platform    string `json:"platform"`
+	Description string `json:"description"`
+	Value       string `json:"value"`
+}
+
+// Payloads is the probe library
+var Payloads = []Payload{
+	{Name: "java-string", Platform: PlatformJava, Description: "Serialized java.lang.String where an object is expected, a ClassCastException when cast", Value: "rO0ABXQAB3Byb2t6ZWU="},
+	{Name: "java-truncated", Platform: PlatformJava, Description: "Object stream cut off inside a class descriptor", Value: "rO0ABXNyAAA="},
+	{Name: "java-bad-header", Platform: PlatformJava, Description: "Stream magic with a wrong version, an invalid stream header", Value: "rO0AB3QAB3Byb2t6ZWU="},
+	{Name: "java-unknown-class", Platform: PlatformJava, Description: "Object of a class that does not exist, a ClassNotFoundException", Value: javaUnknownClass()},
+	{Name: "viewstate-tampered", Platform: PlatformDotNet, Description: "ViewState whose MAC cannot validate", Value: "/wEPDwUKMTIzNDU2Nzg5MGRkcHJva3plZQ=="},
+	{Name: "viewstate-truncated", Platform: PlatformDotNet, Description: "ViewState cut off after its header", Value: "/wEPDw=="},
+	{Name: "binaryformatter-truncated", Platform: PlatformDotNet, Description: "BinaryFormatter stream header without a body", Value: "AAEAAAD/////AQAAAAAAAAA="},
+	{Name: "php-unknown-class", Platform: PlatformPHP, Description: "Object of a class that does not exist, unserialized as __PHP_Incomplete_Class", Value: `O:19:"ProkzeeMissingClass":0:{}`},
+	{Name: "php-bad-length", Platform: PlatformPHP, Description: "String whose declared length is wrong, an error at offset", Value: `a:1:{i:0;s:50:"prokzee";}`},
+	{Name: "php-type-juggling", Platform: PlatformPHP, Description: "Boolean true where an array or object is expected", Value: "b:1;"},
+	{Name: "pickle-string", Platform: PlatformPython, Description: "Pickled str where another type is expected", Value: "gASVCwAAAAAAAACMB3Byb2t6ZWWULg=="},
+	{Name: "pickle-truncated", Platform: PlatformPython, Description: "Pickle stream cut off after its protocol header", Value: "gASV"},
+	{Name: "pickle-bad-opcode", Platform: PlatformPython, Description: "Pickle protocol header followed by an invalid opcode", Value: "gAT/"},
+}
+
+// javaUnknownClass builds an object stream of a class named ProkzeeMissing
+// with no fields
+func javaUnknownClass() string {
+	name := "ProkzeeMissing"
+	stream := "aced0005" + "73" + "72" + hex.EncodeToString([]byte{0, byte(len(name))}) + hex.EncodeToString([]byte(name)) +
+		"0000000000000001" + "02" + "0000" + "78" + "70"
+	raw, _ := hex.DecodeString(stream)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// Signature is an error message betraying a deserializer
+type Signature struct {
+	Platform string
+	Pattern  *regexp.Regexp
+}
+
+// Signatures are the deserialization errors looked for in responses
+var Signatures = []Signature{
+	{Platform: PlatformJava, Pattern: regexp.MustCompile(`java\.io\.(?:StreamCorruptedException|InvalidClassException|OptionalDataException|EOFException|ObjectInputStream)|invalid stream header|java\.lang\.ClassNotFoundException|java\.lang\.ClassCastException`)},
+	{Platform: PlatformDotNet, Pattern: regexp.MustCompile(`(?i)Validation of viewstate MAC failed|The state information is invalid for this page|System\.Runtime\.Serialization|BinaryFormatter|LosFormatter|ObjectStateFormatter|Invalid viewstate`)},
+	{Platform: PlatformPHP, Pattern: regexp.MustCompile(`unserialize\(\)|__PHP_Incomplete_Class|Error at offset \d+ of \d+ bytes`)},
+	{Platform: PlatformPython, Pattern: regexp.MustCompile(`_pickle\.UnpicklingError|pickle\.UnpicklingError|invalid load key|pickle data was truncated|unpickling stack underflow`)},
+}
+
+// Detect returns the platform a parameter value looks serialized for, or ""
+func Detect(value string) string {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, "rO0AB"), strings.HasPrefix(strings.ToLower(value), "aced0005"):
+		return PlatformJava
+	case strings.HasPrefix(value, "/wE"), strings.HasPrefix(value, "AAEAAAD/////"):
+		return PlatformDotNet
+	case phpSerialized.MatchString(value):
+		return PlatformPHP
+	case strings.HasPrefix(value, "gAJ"), strings.HasPrefix(value, "gAN"), strings.HasPrefix(value, "gASV"), strings.HasPrefix(value, "gAV"), strings.HasPrefix(value, "KGRwMA"), strings.HasPrefix(value, "KGxwMA"):
+		return PlatformPython
+	}
+	return ""
+}
+
+// phpSerialized matches serialize() output
+var phpSerialized = regexp.MustCompile(`^(?:[aO]:\d+:[{"]|s:\d+:"|[bi]:\d+;|d:[\d.E+-]+;|N;$)`)
+
+// signatures returns the deserialization errors found in a body, with the
+// platform of each
+func signatures(body []byte) map[string]string {
+	found := make(map[string]string)
+	for _, signature := range Signatures {
+		if match := signature.Pattern.Find(body); match != nil {
+			found[signature.Platform] = string(match)
+		}
+	}
+	return found
+}
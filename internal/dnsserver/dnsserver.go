@@ -0,0 +1,240 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// upstreamTimeout bounds how long a forwarded query waits for the upstream resolver
+const upstreamTimeout = 3 * time.Second
+
+// answerTTL is the TTL of the records the server answers for capture domains.
+// It is short so clients pick up a changed proxy address quickly.
+const answerTTL = 30
+
+// Config configures the DNS server
+type Config struct {
+	ListenAddr string   `json:"listenAddr"` // e.g. 0.0.0.0:53
+	Domains    []string `json:"domains"`    // e.g. *.corp.example, api.vendor.com
+	AnswerIP   string   `json:"answerIP"`   // address of the proxy host
+	Upstream   string   `json:"upstream"`   // resolver for every other name, empty to refuse
+}
+
+// State describes the server for the frontend
+type State struct {
+	Running bool   `json:"running"`
+	Config  Config `json:"config"`
+	Queries int    `json:"queries"`
+	Matched int    `json:"matched"`
+}
+
+// Server is a small UDP DNS server that resolves capture domains to the proxy
+// host and forwards everything else
+type Server struct {
+	config  Config
+	answer  [4]byte
+	conn    net.PacketConn
+	queries int
+	matched int
+	mu      sync.Mutex
+}
+
+// NewServer creates a new, stopped DNS server
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Start starts listening with the given config, replacing a running server
+func (s *Server) Start(config Config) error {
+	ip := net.ParseIP(config.AnswerIP).To4()
+	if ip == nil {
+		return fmt.Errorf("answer IP must be an IPv4 address: %q", config.AnswerIP)
+	}
+	if len(config.Domains) == 0 {
+		return fmt.Errorf("at least one domain is required")
+	}
+	if config.ListenAddr == "" {
+		config.ListenAddr = "0.0.0.0:53"
+	}
+	for i, domain := range config.Domains {
+		config.Domains[i] = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	}
+
+	s.Stop()
+
+	conn, err := net.ListenPacket("udp", config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", config.ListenAddr, err)
+	}
+
+	s.mu.Lock()
+	s.config = config
+	copy(s.answer[:], ip)
+	s.conn = conn
+	s.queries = 0
+	s.matched = 0
+	s.mu.Unlock()
+
+	log.Printf("DNS server listening on %s, resolving %v to %s", config.ListenAddr, config.Domains, config.AnswerIP)
+	go s.serve(conn)
+	return nil
+}
+
+// Stop stops the server if it is running
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		log.Printf("DNS server stopped")
+	}
+}
+
+// GetState returns the current state of the server
+func (s *Server) GetState() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return State{
+		Running: s.conn != nil,
+		Config:  s.config,
+		Queries: s.queries,
+		Matched: s.matched,
+	}
+}
+
+// serve reads queries until the connection is closed
+func (s *Server) serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go s.handle(conn, addr, query)
+	}
+}
+
+// handle answers a single query
+func (s *Server) handle(conn net.PacketConn, addr net.Addr, query []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) == 0 {
+		return
+	}
+	question := msg.Questions[0]
+
+	s.mu.Lock()
+	s.queries++
+	config := s.config
+	answer := s.answer
+	matched := s.matches(question.Name.String())
+	if matched {
+		s.matched++
+	}
+	s.mu.Unlock()
+
+	if !matched {
+		if config.Upstream == "" {
+			s.reply(conn, addr, msg, dnsmessage.RCodeRefused, nil)
+			return
+		}
+		response, err := forward(config.Upstream, query)
+		if err != nil {
+			log.Printf("Failed to forward DNS query for %s: %v", question.Name.String(), err)
+			s.reply(conn, addr, msg, dnsmessage.RCodeServerFailure, nil)
+			return
+		}
+		conn.WriteTo(response, addr)
+		return
+	}
+
+	var answers []dnsmessage.Resource
+	if question.Type == dnsmessage.TypeA || question.Type == dnsmessage.TypeALL {
+		answers = append(answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  question.Name,
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+				TTL:   answerTTL,
+			},
+			Body: &dnsmessage.AResource{A: answer},
+		})
+	}
+	// Other types (AAAA in particular) get an empty answer so clients fall back to IPv4
+	s.reply(conn, addr, msg, dnsmessage.RCodeSuccess, answers)
+}
+
+// matches reports whether a queried name belongs to a capture domain. A
+// "*.example.com" entry matches example.com and every name below it.
+func (s *Server) matches(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, domain := range s.config.Domains {
+		if strings.HasPrefix(domain, "*.") {
+			base := domain[2:]
+			if name == base || strings.HasSuffix(name, "."+base) {
+				return true
+			}
+		} else if name == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// reply sends an authoritative response to the query
+func (s *Server) reply(conn net.PacketConn, addr net.Addr, query dnsmessage.Message, rcode dnsmessage.RCode, answers []dnsmessage.Resource) {
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.ID,
+			Response:           true,
+			Authoritative:      rcode == dnsmessage.RCodeSuccess,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: query.Questions,
+		Answers:   answers,
+	}
+
+	packed, err := response.Pack()
+	if err != nil {
+		log.Printf("Failed to pack DNS response: %v", err)
+		return
+	}
+	conn.WriteTo(packed, addr)
+}
+
+// forward sends the raw query to the upstream resolver and returns its raw response
+func forward(upstream string, query []byte) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+
+	conn, err := net.DialTimeout("udp", upstream, upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
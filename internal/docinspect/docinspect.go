@@ -0,0 +1,250 @@
+package docinspect
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Limits keeping inspection cheap on hostile archives
+const (
+	maxListedFiles    = 500
+	maxPropertiesSize = 256 * 1024
+	maxValueLength    = 256
+	bombRatio         = 100
+)
+
+// Inspection describes the content of a zip archive or an Office Open XML
+// document (docx, xlsx, pptx)
+type Inspection struct {
+	Format     string            `json:"format"`
+	FileCount  int               `json:"fileCount"`
+	Files      []File            `json:"files"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Macros     bool              `json:"macros"`
+	Indicators []string          `json:"indicators,omitempty"`
+}
+
+// File is an entry of an archive
+type File struct {
+	Name           string `json:"name"`
+	Size           uint64 `json:"size"`
+	CompressedSize uint64 `json:"compressedSize"`
+	Modified       string `json:"modified,omitempty"`
+	Encrypted      bool   `json:"encrypted,omitempty"`
+}
+
+// Risky reports whether the archive holds macros or other content worth a
+// closer look
+func (i *Inspection) Risky() bool {
+	return i != nil && (i.Macros || len(i.Indicators) > 0)
+}
+
+// Summary describes the indicators for a finding
+func (i *Inspection) Summary() string {
+	parts := append([]string{}, i.Indicators...)
+	if i.Macros {
+		parts = append([]string{"VBA macros"}, parts...)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Inspect lists the files of the zip archive in r, of the given size, and
+// reads the properties and macro indicators of Office documents. It returns
+// nil when r is not a zip archive.
+func Inspect(r io.ReaderAt, size int64) *Inspection {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil
+	}
+
+	inspection := &Inspection{Format: "zip", FileCount: len(archive.File), Files: []File{}}
+	indicators := make(map[string]bool)
+	names := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		names[f.Name] = f
+		if len(inspection.Files) < maxListedFiles {
+			file := File{
+				Name:           f.Name,
+				Size:           f.UncompressedSize64,
+				CompressedSize: f.CompressedSize64,
+				Encrypted:      f.Flags&0x1 != 0,
+			}
+			if !f.Modified.IsZero() {
+				file.Modified = f.Modified.UTC().Format("2006-01-02T15:04:05Z")
+			}
+			inspection.Files = append(inspection.Files, file)
+		}
+
+		lower := strings.ToLower(f.Name)
+		switch {
+		case strings.HasSuffix(lower, "vbaproject.bin") || strings.HasSuffix(lower, "vbadata.xml"):
+			inspection.Macros = true
+		case strings.Contains(lower, "/activex/"):
+			indicators["ActiveX controls"] = true
+		case strings.Contains(lower, "/embeddings/") && strings.HasSuffix(lower, ".bin"):
+			indicators["Embedded OLE objects"] = true
+		case strings.Contains(lower, "/externallinks/"):
+			indicators["External workbook links"] = true
+		}
+		if strings.HasPrefix(f.Name, "/") || strings.Contains(f.Name, "../") || strings.Contains(f.Name, `..\`) {
+			indicators["Path traversal in file names"] = true
+		}
+		if f.Flags&0x1 != 0 {
+			indicators["Encrypted entries"] = true
+		}
+		if f.CompressedSize64 > 0 && f.UncompressedSize64/f.CompressedSize64 > bombRatio {
+			indicators["Compression ratio above 100:1"] = true
+		}
+		if isExecutable(lower) {
+			indicators["Executable or script files"] = true
+		}
+	}
+
+	if contentTypes, ok := names["[Content_Types].xml"]; ok {
+		inspection.Format = officeFormat(names)
+		if bytes.Contains(bytes.ToLower(readLimited(contentTypes)), []byte("macroenabled")) {
+			inspection.Macros = true
+		}
+		inspection.Metadata = make(map[string]string)
+		for _, name := range []string{"docProps/core.xml", "docProps/app.xml"} {
+			if f, ok := names[name]; ok {
+				readProperties(readLimited(f), inspection.Metadata)
+			}
+		}
+		for _, f := range archive.File {
+			if strings.HasSuffix(f.Name, ".rels") && hasRemoteContent(readLimited(f)) {
+				indicators["Remote templates or linked objects"] = true
+				break
+			}
+		}
+		if len(inspection.Metadata) == 0 {
+			inspection.Metadata = nil
+		}
+	}
+
+	for indicator := range indicators {
+		inspection.Indicators = append(inspection.Indicators, indicator)
+	}
+	sort.Strings(inspection.Indicators)
+	return inspection
+}
+
+// InspectBytes inspects an archive held in memory
+func InspectBytes(body []byte) *Inspection {
+	return Inspect(bytes.NewReader(body), int64(len(body)))
+}
+
+// JSON returns an inspection as JSON, or "" when there is none
+func JSON(inspection *Inspection) string {
+	if inspection == nil {
+		return ""
+	}
+	data, err := json.Marshal(inspection)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// officeFormat tells the Office Open XML documents apart by their main part
+func officeFormat(names map[string]*zip.File) string {
+	switch {
+	case names["word/document.xml"] != nil:
+		return "docx"
+	case names["xl/workbook.xml"] != nil:
+		return "xlsx"
+	case names["ppt/presentation.xml"] != nil:
+		return "pptx"
+	}
+	return "ooxml"
+}
+
+// isExecutable reports whether a file name looks like something that runs
+// when opened
+func isExecutable(name string) bool {
+	switch path.Ext(name) {
+	case ".exe", ".dll", ".scr", ".com", ".bat", ".cmd", ".ps1", ".vbs", ".js", ".jar", ".msi", ".lnk", ".hta":
+		return true
+	}
+	return false
+}
+
+// readLimited reads at most maxPropertiesSize bytes of an archive entry
+func readLimited(f *zip.File) []byte {
+	rc, err := f.Open()
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(io.LimitReader(rc, maxPropertiesSize))
+	return data
+}
+
+// readProperties collects the simple elements of docProps/core.xml and
+// docProps/app.xml, such as the creator, last editor and application
+func readProperties(data []byte, metadata map[string]string) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	current := ""
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			current = ""
+			// Properties are the direct children of the root element
+			if depth == 2 {
+				current = t.Name.Local
+			}
+		case xml.CharData:
+			if current == "" {
+				continue
+			}
+			value := strings.TrimSpace(string(t))
+			if value == "" || metadata[current] != "" {
+				continue
+			}
+			if len(value) > maxValueLength {
+				value = value[:maxValueLength]
+			}
+			metadata[current] = value
+		case xml.EndElement:
+			depth--
+			current = ""
+		}
+	}
+}
+
+// hasRemoteContent reports whether a relationships part loads a template,
+// frame or object from outside the document. External hyperlinks are common
+// and not reported.
+func hasRemoteContent(data []byte) bool {
+	var rels struct {
+		Relationships []struct {
+			Type       string `xml:"Type,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return false
+	}
+	for _, rel := range rels.Relationships {
+		if rel.TargetMode != "External" {
+			continue
+		}
+		kind := path.Base(rel.Type)
+		if kind == "attachedTemplate" || kind == "oleObject" || kind == "frame" || kind == "externalLinkPath" {
+			return true
+		}
+	}
+	return false
+}
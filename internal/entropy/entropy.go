@@ -0,0 +1,318 @@
+package entropy
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"prokzee/internal/upstream"
+)
+
+// Token sources
+const (
+	SourceCookie = "cookie" // value of a Set-Cookie with the given name
+	SourceHeader = "header" // value of the response header with the given name
+	SourceRegex  = "regex"  // first capture group of a pattern matched against headers and body
+)
+
+// MinimumBits is the effective entropy below which a token is considered predictable
+const MinimumBits = 64
+
+// maxSamples bounds how many times the issuing request is replayed
+const maxSamples = 5000
+
+// Target selects which token to collect from each response
+type Target struct {
+	Source string `json:"source"`
+	Name   string `json:"name"`
+}
+
+// Result is the outcome of an entropy analysis
+type Result struct {
+	Samples          int       `json:"samples"`
+	Unique           int       `json:"unique"`
+	MinLength        int       `json:"minLength"`
+	MaxLength        int       `json:"maxLength"`
+	Charset          string    `json:"charset"`
+	BitsPerChar      float64   `json:"bitsPerChar"`
+	PositionEntropy  []float64 `json:"positionEntropy"`
+	EffectiveBits    float64   `json:"effectiveBits"`
+	MaxMeasurable    float64   `json:"maxMeasurable"` // upper bound for the sample size
+	Sequential       bool      `json:"sequential"`
+	Predictable      bool      `json:"predictable"`
+	Reasons          []string  `json:"reasons"`
+	Warning          string    `json:"warning,omitempty"`
+	ExampleTokens    []string  `json:"exampleTokens"`
+	FailedExtraction int       `json:"failedExtraction"`
+}
+
+// Collector replays a stored request to gather token samples
+type Collector struct {
+	db     *sql.DB
+	client *http.Client
+}
+
+// NewCollector creates a new collector
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{
+		db: db,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Collect replays the stored request count times and extracts the target token
+// from each response. Extraction failures are counted, not returned as errors.
+func (c *Collector) Collect(ctx context.Context, requestID int, target Target, count int, progress func(done, total int)) ([]string, int, error) {
+	if count <= 0 || count > maxSamples {
+		return nil, 0, fmt.Errorf("sample count must be between 1 and %d", maxSamples)
+	}
+
+	extract, err := extractor(target)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var method, rawURL, rawHeaders, body string
+	err = c.db.QueryRow(`
+		SELECT method, url, COALESCE(request_headers, ''), COALESCE(request_body, '')
+		FROM requests WHERE id = ?
+	`, requestID).Scan(&method, &rawURL, &rawHeaders, &body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load request %d: %v", requestID, err)
+	}
+
+	headers := http.Header{}
+	if rawHeaders != "" {
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse request headers: %v", err)
+		}
+	}
+
+	samples := []string{}
+	failed := 0
+	for i := 0; i < count; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader([]byte(body)))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to build request: %v", err)
+		}
+		req.Header = headers.Clone()
+		req.Header.Del("Content-Length")
+		if host := headers.Get("Host"); host != "" {
+			req.Host = host
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			failed++
+		} else {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if token := extract(resp, respBody); token != "" {
+				samples = append(samples, token)
+			} else {
+				failed++
+			}
+		}
+
+		if progress != nil {
+			progress(i+1, count)
+		}
+	}
+
+	return samples, failed, nil
+}
+
+// extractor returns a function that pulls the target token out of a response
+func extractor(target Target) (func(resp *http.Response, body []byte) string, error) {
+	if target.Name == "" {
+		return nil, fmt.Errorf("token name is required")
+	}
+
+	switch target.Source {
+	case SourceCookie:
+		return func(resp *http.Response, _ []byte) string {
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == target.Name {
+					return cookie.Value
+				}
+			}
+			return ""
+		}, nil
+	case SourceHeader:
+		return func(resp *http.Response, _ []byte) string {
+			return resp.Header.Get(target.Name)
+		}, nil
+	case SourceRegex:
+		pattern, err := regexp.Compile(target.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		if pattern.NumSubexp() < 1 {
+			return nil, fmt.Errorf("pattern needs a capture group")
+		}
+		return func(resp *http.Response, body []byte) string {
+			var raw bytes.Buffer
+			resp.Header.Write(&raw)
+			raw.Write(body)
+			if match := pattern.FindSubmatch(raw.Bytes()); match != nil {
+				return string(match[1])
+			}
+			return ""
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown token source: %s", target.Source)
+}
+
+// Analyze estimates the randomness of the collected tokens
+func Analyze(samples []string) *Result {
+	result := &Result{Samples: len(samples), PositionEntropy: []float64{}, Reasons: []string{}, ExampleTokens: []string{}}
+	if len(samples) == 0 {
+		result.Predictable = true
+		result.Reasons = append(result.Reasons, "no tokens collected")
+		return result
+	}
+
+	unique := make(map[string]bool)
+	charCounts := make(map[rune]int)
+	totalChars := 0
+	result.MinLength = len(samples[0])
+	for _, sample := range samples {
+		unique[sample] = true
+		if len(sample) < result.MinLength {
+			result.MinLength = len(sample)
+		}
+		if len(sample) > result.MaxLength {
+			result.MaxLength = len(sample)
+		}
+		for _, r := range sample {
+			charCounts[r]++
+			totalChars++
+		}
+	}
+	result.Unique = len(unique)
+
+	charset := make([]string, 0, len(charCounts))
+	for r := range charCounts {
+		charset = append(charset, string(r))
+	}
+	sort.Strings(charset)
+	result.Charset = strings.Join(charset, "")
+
+	counts := make([]int, 0, len(charCounts))
+	for _, n := range charCounts {
+		counts = append(counts, n)
+	}
+	result.BitsPerChar = shannon(counts, totalChars)
+
+	// Entropy of each character position across the samples. With N samples a
+	// position can show at most log2(N) bits, so large tokens need many samples.
+	for position := 0; position < result.MaxLength; position++ {
+		positionCounts := make(map[byte]int)
+		total := 0
+		for _, sample := range samples {
+			if position < len(sample) {
+				positionCounts[sample[position]]++
+				total++
+			}
+		}
+		values := make([]int, 0, len(positionCounts))
+		for _, n := range positionCounts {
+			values = append(values, n)
+		}
+		bits := shannon(values, total)
+		result.PositionEntropy = append(result.PositionEntropy, bits)
+		result.EffectiveBits += bits
+	}
+	result.MaxMeasurable = math.Log2(float64(len(samples))) * float64(result.MaxLength)
+	result.Sequential = isSequential(samples)
+
+	if result.Unique < result.Samples {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%d duplicate tokens", result.Samples-result.Unique))
+	}
+	if result.Sequential {
+		result.Reasons = append(result.Reasons, "tokens increase by a constant step")
+	}
+	if result.MaxMeasurable < MinimumBits {
+		result.Warning = fmt.Sprintf("%d samples of this length cannot show %d bits; collect more tokens", len(samples), MinimumBits)
+	} else if result.EffectiveBits < MinimumBits {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("effective entropy %.1f bits is below %d bits", result.EffectiveBits, MinimumBits))
+	}
+	result.Predictable = len(result.Reasons) > 0
+
+	for i := 0; i < len(samples) && i < 5; i++ {
+		result.ExampleTokens = append(result.ExampleTokens, samples[i])
+	}
+
+	return result
+}
+
+// shannon returns the Shannon entropy in bits of a distribution
+func shannon(counts []int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// isSequential reports whether the tokens are numbers (decimal or hex) that
+// change by the same step every time
+func isSequential(samples []string) bool {
+	if len(samples) < 3 {
+		return false
+	}
+
+	for _, base := range []int{10, 16} {
+		values := make([]int64, 0, len(samples))
+		for _, sample := range samples {
+			value, err := strconv.ParseInt(sample, base, 64)
+			if err != nil {
+				break
+			}
+			values = append(values, value)
+		}
+		if len(values) != len(samples) {
+			continue
+		}
+
+		step := values[1] - values[0]
+		sequential := step != 0
+		for i := 2; i < len(values) && sequential; i++ {
+			if values[i]-values[i-1] != step {
+				sequential = false
+			}
+		}
+		if sequential {
+			return true
+		}
+	}
+	return false
+}
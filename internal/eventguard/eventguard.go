@@ -0,0 +1,116 @@
+package eventguard
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// originKey names the field of the envelope the main frame appends to the
+// arguments of privileged events
+const originKey = "__prokzeeOrigin"
+
+// Guard decides which frontend events run. Privileged events only run when
+// they carry the token of the session, which is handed to the main frame of
+// the window alone. Plugins run, and captured HTML must be shown, in
+// sandboxed frames without the origin of the app, so they can neither read
+// the token nor call the runtime of the main frame.
+type Guard struct {
+	token      string
+	privileged map[string]bool
+	onDenied   func(event string)
+}
+
+// New creates a guard with a new token, requiring it for the privileged events
+func New(privileged []string) *Guard {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		// crypto/rand does not fail on the supported platforms
+		panic(fmt.Sprintf("failed to generate event token: %v", err))
+	}
+	g := &Guard{token: hex.EncodeToString(secret), privileged: make(map[string]bool, len(privileged))}
+	for _, event := range privileged {
+		g.privileged[event] = true
+	}
+	return g
+}
+
+// Privileged reports whether an event requires the token
+func (g *Guard) Privileged(event string) bool {
+	return g.privileged[event]
+}
+
+// OnDenied sets what is called when an event is refused. It is set before
+// the handlers are registered.
+func (g *Guard) OnDenied(fn func(event string)) {
+	g.onDenied = fn
+}
+
+// Wrap returns the handler of an event checked by the guard. The envelope is
+// removed from the arguments before they reach handler.
+func (g *Guard) Wrap(event string, handler func(data ...interface{})) func(data ...interface{}) {
+	return func(data ...interface{}) {
+		data, trusted := g.open(data)
+		if g.privileged[event] && !trusted {
+			log.Printf("Warning: refused %s, it was not sent by the main window", event)
+			if g.onDenied != nil {
+				g.onDenied(event)
+			}
+			return
+		}
+		handler(data...)
+	}
+}
+
+// open removes the envelope ending the arguments, reporting whether it
+// carried the token
+func (g *Guard) open(data []interface{}) ([]interface{}, bool) {
+	if len(data) == 0 {
+		return data, false
+	}
+	envelope, ok := data[len(data)-1].(map[string]interface{})
+	if !ok || len(envelope) != 1 {
+		return data, false
+	}
+	token, ok := envelope[originKey].(string)
+	if !ok {
+		return data, false
+	}
+	return data[:len(data)-1], subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1
+}
+
+// Script returns the JavaScript run in the main frame to have the privileged
+// events it emits carry the token. The token stays in a closure, out of reach
+// of other frames.
+func (g *Guard) Script() string {
+	events := make([]string, 0, len(g.privileged))
+	for event := range g.privileged {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	eventsJSON, _ := json.Marshal(events)
+	tokenJSON, _ := json.Marshal(g.token)
+	keyJSON, _ := json.Marshal(originKey)
+
+	return fmt.Sprintf(`(function (token, key, privileged) {
+	var runtime = window.runtime;
+	if (window !== window.top || !runtime || !runtime.EventsEmit || runtime.__prokzeeGuarded) {
+		return;
+	}
+	var emit = runtime.EventsEmit;
+	runtime.EventsEmit = function (name) {
+		var args = Array.prototype.slice.call(arguments);
+		if (privileged.indexOf(name) !== -1) {
+			var envelope = {};
+			envelope[key] = token;
+			args.push(envelope);
+		}
+		return emit.apply(runtime, args);
+	};
+	Object.defineProperty(runtime, "__prokzeeGuarded", { value: true });
+})(%s, %s, %s);`, tokenJSON, keyJSON, eventsJSON)
+}
@@ -0,0 +1,283 @@
+package exposure
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the exposure checker
+const Source = "exposure-check"
+
+// maxProbeBody bounds how much of a probed file is downloaded
+const maxProbeBody = 64 * 1024
+
+// Probe is a file to request and a check that its content is the real thing
+// rather than a soft 404 page
+type Probe struct {
+	Path     string
+	Title    string
+	Severity string
+	Valid    func(body []byte) bool
+}
+
+var (
+	gitHeadPattern = regexp.MustCompile(`^(ref: refs/|[0-9a-f]{40}\s*$)`)
+	envLinePattern = regexp.MustCompile(`(?m)^[A-Z][A-Z0-9_]*=.*$`)
+	remotePattern  = regexp.MustCompile(`(?m)^\s*url\s*=\s*(\S+)`)
+	logLinePattern = regexp.MustCompile(`(?m)^[0-9a-f]{40} ([0-9a-f]{40}) (.+?) <[^>]*> \d+ [+-]\d{4}\t(.*)$`)
+)
+
+// Probes is the list of files requested from every host
+var Probes = []Probe{
+	{Path: "/.git/HEAD", Title: "Exposed Git repository", Severity: findings.SeverityHigh, Valid: func(b []byte) bool {
+		return gitHeadPattern.Match(bytes.TrimSpace(b))
+	}},
+	{Path: "/.git/config", Title: "Exposed Git config", Severity: findings.SeverityHigh, Valid: func(b []byte) bool {
+		return bytes.Contains(b, []byte("[core]"))
+	}},
+	{Path: "/.svn/wc.db", Title: "Exposed Subversion working copy", Severity: findings.SeverityMedium, Valid: func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("SQLite format 3"))
+	}},
+	{Path: "/.svn/entries", Title: "Exposed Subversion entries", Severity: findings.SeverityMedium, Valid: func(b []byte) bool {
+		return bytes.HasPrefix(bytes.TrimSpace(b), []byte("8")) || bytes.HasPrefix(bytes.TrimSpace(b), []byte("9")) ||
+			bytes.HasPrefix(bytes.TrimSpace(b), []byte("10")) || bytes.HasPrefix(bytes.TrimSpace(b), []byte("12"))
+	}},
+	{Path: "/.env", Title: "Exposed environment file", Severity: findings.SeverityHigh, Valid: func(b []byte) bool {
+		return !looksLikeHTML(b) && len(envLinePattern.FindAll(b, 3)) >= 2
+	}},
+	{Path: "/.DS_Store", Title: "Exposed .DS_Store file", Severity: findings.SeverityLow, Valid: func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("\x00\x00\x00\x01Bud1"))
+	}},
+	{Path: "/backup.zip", Title: "Exposed backup archive", Severity: findings.SeverityMedium, Valid: isArchive},
+	{Path: "/backup.tar.gz", Title: "Exposed backup archive", Severity: findings.SeverityMedium, Valid: isArchive},
+	{Path: "/www.zip", Title: "Exposed backup archive", Severity: findings.SeverityMedium, Valid: isArchive},
+	{Path: "/site.zip", Title: "Exposed backup archive", Severity: findings.SeverityMedium, Valid: isArchive},
+	{Path: "/index.php.bak", Title: "Exposed source backup", Severity: findings.SeverityMedium, Valid: func(b []byte) bool {
+		return bytes.Contains(b, []byte("<?php"))
+	}},
+	{Path: "/config.php.bak", Title: "Exposed source backup", Severity: findings.SeverityHigh, Valid: func(b []byte) bool {
+		return bytes.Contains(b, []byte("<?php"))
+	}},
+}
+
+// Exposure is a probe that found a real file
+type Exposure struct {
+	Host     string `json:"host"`
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+	Severity string `json:"severity"`
+	Detail   string `json:"detail"`
+}
+
+// Checker probes hosts for exposed repository metadata and backups
+type Checker struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewChecker creates a new exposure checker
+func NewChecker(db *sql.DB, findingsClient *findings.Client) *Checker {
+	return &Checker{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			// A redirect usually means a login or error page, never the file
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run probes every captured origin whose host passes the filter and records
+// what it finds as findings
+func (c *Checker) Run(filter func(host string) bool, progress func(done, total int)) ([]Exposure, error) {
+	origins, err := c.origins(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	exposures := []Exposure{}
+	for i, origin := range origins {
+		exposures = append(exposures, c.Check(origin)...)
+		if progress != nil {
+			progress(i+1, len(origins))
+		}
+	}
+
+	for _, exposure := range exposures {
+		err := c.findings.AddFinding(findings.Finding{
+			Source:   Source,
+			Host:     exposure.Host,
+			URL:      exposure.URL,
+			Title:    exposure.Title,
+			Severity: exposure.Severity,
+			Detail:   exposure.Detail,
+			Key:      exposure.URL,
+		})
+		if err != nil {
+			log.Printf("Failed to record exposure finding: %v", err)
+		}
+	}
+	return exposures, nil
+}
+
+// Check probes a single origin such as https://example.com
+func (c *Checker) Check(origin string) []Exposure {
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return nil
+	}
+
+	exposures := []Exposure{}
+	for _, probe := range Probes {
+		target := strings.TrimSuffix(origin, "/") + probe.Path
+		body, ok := c.fetch(target)
+		if !ok || !probe.Valid(body) {
+			continue
+		}
+
+		exposure := Exposure{
+			Host:     parsed.Hostname(),
+			URL:      target,
+			Title:    probe.Title,
+			Severity: probe.Severity,
+			Detail:   fmt.Sprintf("%s is publicly readable (%d bytes)", probe.Path, len(body)),
+		}
+		switch probe.Path {
+		case "/.git/HEAD":
+			if info := c.gitInfo(origin); info != "" {
+				exposure.Detail += "\n" + info
+			}
+		case "/.env":
+			exposure.Detail += "\nVariables: " + strings.Join(envKeys(body), ", ")
+		}
+		exposures = append(exposures, exposure)
+	}
+	return exposures
+}
+
+// gitInfo reconstructs what can be learned from an exposed .git directory
+// without downloading objects: the branch, remotes and recent commits
+func (c *Checker) gitInfo(origin string) string {
+	base := strings.TrimSuffix(origin, "/") + "/.git/"
+	var lines []string
+
+	if head, ok := c.fetch(base + "HEAD"); ok {
+		ref := strings.TrimSpace(string(head))
+		lines = append(lines, "HEAD: "+strings.TrimPrefix(ref, "ref: "))
+	}
+	if config, ok := c.fetch(base + "config"); ok {
+		for _, match := range remotePattern.FindAllSubmatch(config, -1) {
+			lines = append(lines, "Remote: "+string(match[1]))
+		}
+	}
+	if logs, ok := c.fetch(base + "logs/HEAD"); ok {
+		matches := logLinePattern.FindAllSubmatch(logs, -1)
+		start := len(matches) - 5
+		if start < 0 {
+			start = 0
+		}
+		for _, match := range matches[start:] {
+			lines = append(lines, fmt.Sprintf("Commit %s by %s: %s", match[1][:10], match[2], match[3]))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// fetch GETs a URL and returns the start of its body for successful responses
+func (c *Checker) fetch(target string) ([]byte, bool) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, false
+	}
+	// Ask for the start of the file only, archives can be huge
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", maxProbeBody-1))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil || len(body) == 0 {
+		return nil, false
+	}
+	return body, true
+}
+
+// origins returns the scheme://host[:port] of every captured host that passes the filter
+func (c *Checker) origins(filter func(host string) bool) ([]string, error) {
+	rows, err := c.db.Query(`SELECT DISTINCT url FROM requests WHERE url IS NOT NULL AND url != ''`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hosts: %v", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	allowed := make(map[string]bool)
+	var origins []string
+	for rows.Next() {
+		var rawURL string
+		if err := rows.Scan(&rawURL); err != nil {
+			return nil, fmt.Errorf("failed to scan url: %v", err)
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		host := parsed.Hostname()
+		if _, ok := allowed[host]; !ok {
+			allowed[host] = filter == nil || filter(host)
+		}
+		origin := parsed.Scheme + "://" + parsed.Host
+		if !allowed[host] || seen[origin] {
+			continue
+		}
+		seen[origin] = true
+		origins = append(origins, origin)
+	}
+	return origins, rows.Err()
+}
+
+// envKeys returns the variable names of an exposed .env file, never the values
+func envKeys(body []byte) []string {
+	var keys []string
+	for _, line := range envLinePattern.FindAll(body, 50) {
+		keys = append(keys, string(bytes.SplitN(line, []byte("="), 2)[0]))
+	}
+	return keys
+}
+
+// isArchive checks for zip and gzip magic bytes
+func isArchive(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("PK\x03\x04")) || bytes.HasPrefix(b, []byte{0x1f, 0x8b})
+}
+
+// looksLikeHTML reports whether a body is an HTML page
+func looksLikeHTML(b []byte) bool {
+	start := bytes.ToLower(bytes.TrimSpace(b))
+	if len(start) > 256 {
+		start = start[:256]
+	}
+	return bytes.Contains(start, []byte("<html")) || bytes.Contains(start, []byte("<!doctype"))
+}
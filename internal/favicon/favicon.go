@@ -0,0 +1,231 @@
+package favicon
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	hostinfo "prokzee/internal/hostinfo"
+	"prokzee/internal/upstream"
+)
+
+// maxFaviconSize bounds how much of a favicon is downloaded
+const maxFaviconSize = 1 << 20
+
+// Result is the favicon hash of a host
+type Result struct {
+	Host        string `json:"host"`
+	URL         string `json:"url"`
+	Hash        int32  `json:"hash"`
+	Size        int    `json:"size"`
+	Source      string `json:"source"` // "history" or "fetched"
+	ShodanQuery string `json:"shodanQuery"`
+	ShodanURL   string `json:"shodanUrl"`
+}
+
+// Hash computes the favicon hash used by Shodan: the signed MurmurHash3 of the
+// base64 encoding with a line break every 76 characters
+func Hash(data []byte) int32 {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var sb strings.Builder
+	for i := 0; i < len(encoded); i += 76 {
+		end := i + 76
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		sb.WriteString(encoded[i:end])
+		sb.WriteByte('\n')
+	}
+	return int32(murmur3([]byte(sb.String()), 0))
+}
+
+// murmur3 is the 32-bit x86 variant of MurmurHash3
+func murmur3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	blocks := len(data) / 4
+	for i := 0; i < blocks; i++ {
+		k := uint32(data[i*4]) | uint32(data[i*4+1])<<8 | uint32(data[i*4+2])<<16 | uint32(data[i*4+3])<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[blocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// Collector finds the favicons of hosts and records their hashes as host info
+type Collector struct {
+	db       *sql.DB
+	hostInfo *hostinfo.Client
+	client   *http.Client
+}
+
+// NewCollector creates a new favicon collector
+func NewCollector(db *sql.DB, hostInfo *hostinfo.Client) *Collector {
+	return &Collector{
+		db:       db,
+		hostInfo: hostInfo,
+		client: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+		},
+	}
+}
+
+// Run hashes the favicon of every host that passes the filter. Favicons already
+// in the history are used first; when fetch is set, hosts without one get
+// /favicon.ico requested directly.
+func (c *Collector) Run(filter func(host string) bool, fetch bool) ([]Result, error) {
+	rows, err := c.db.Query(`
+		SELECT DISTINCT COALESCE(domain, ''), COALESCE(port, '')
+		FROM requests
+		WHERE domain IS NOT NULL AND domain != ''
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hosts: %v", err)
+	}
+
+	type target struct{ host, port string }
+	var targets []target
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var host, port string
+		if err := rows.Scan(&host, &port); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan host: %v", err)
+		}
+		if seen[host] || (filter != nil && !filter(host)) {
+			continue
+		}
+		seen[host] = true
+		targets = append(targets, target{host, port})
+	}
+	rows.Close()
+
+	results := []Result{}
+	for _, t := range targets {
+		result, ok := c.fromHistory(t.host)
+		if !ok && fetch {
+			result, ok = c.fetch(t.host, t.port)
+		}
+		if !ok {
+			continue
+		}
+		results = append(results, result)
+
+		err := c.hostInfo.Upsert(hostinfo.Entry{
+			Host:     result.Host,
+			Category: hostinfo.CategoryFavicon,
+			Name:     fmt.Sprintf("%d", result.Hash),
+			Evidence: result.URL,
+			Source:   result.Source,
+		})
+		if err != nil {
+			log.Printf("Failed to record favicon hash for %s: %v", result.Host, err)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Host < results[j].Host
+	})
+	return results, nil
+}
+
+// fromHistory hashes the most recent favicon stored for the host
+func (c *Collector) fromHistory(host string) (Result, bool) {
+	var rawURL, body string
+	err := c.db.QueryRow(`
+		SELECT url, response_body FROM requests
+		WHERE domain = ? AND path LIKE '%favicon%' AND status LIKE '200%' AND length(response_body) > 0
+		ORDER BY id DESC LIMIT 1
+	`, host).Scan(&rawURL, &body)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to look up favicon of %s: %v", host, err)
+		}
+		return Result{}, false
+	}
+	return newResult(host, rawURL, []byte(body), "history"), true
+}
+
+// fetch requests /favicon.ico from the host
+func (c *Collector) fetch(host, port string) (Result, bool) {
+	scheme := "https"
+	if port == "80" {
+		scheme = "http"
+	}
+	target := fmt.Sprintf("%s://%s/favicon.ico", scheme, host)
+	if port != "" && port != "80" && port != "443" {
+		target = fmt.Sprintf("%s://%s:%s/favicon.ico", scheme, host, port)
+	}
+
+	resp, err := c.client.Get(target)
+	if err != nil {
+		log.Printf("Failed to fetch favicon of %s: %v", host, err)
+		return Result{}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, false
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFaviconSize))
+	if err != nil || len(data) == 0 {
+		return Result{}, false
+	}
+	return newResult(host, target, data, "fetched"), true
+}
+
+// newResult hashes a favicon and builds the Shodan links for it
+func newResult(host, rawURL string, data []byte, source string) Result {
+	hash := Hash(data)
+	query := fmt.Sprintf("http.favicon.hash:%d", hash)
+	return Result{
+		Host:        host,
+		URL:         rawURL,
+		Hash:        hash,
+		Size:        len(data),
+		Source:      source,
+		ShodanQuery: query,
+		ShodanURL:   "https://www.shodan.io/search?query=" + url.QueryEscape(query),
+	}
+}
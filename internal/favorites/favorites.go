@@ -0,0 +1,148 @@
+package favorites
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Favorite represents a pinned request together with a summary of the request
+type Favorite struct {
+	ID        int    `json:"id"`
+	RequestID int    `json:"requestId"`
+	Label     string `json:"label"`
+	Position  int    `json:"position"`
+	CreatedAt string `json:"createdAt"`
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Status    string `json:"status"`
+}
+
+// Client represents the favorites client
+type Client struct {
+	db *sql.DB
+}
+
+// NewClient creates a new favorites client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure favorites table exists: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the favorites table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	log.Printf("Ensuring favorites table exists...")
+	query := `
+	CREATE TABLE IF NOT EXISTS favorites (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		request_id INTEGER NOT NULL UNIQUE,
+		label TEXT DEFAULT '',
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := c.db.Exec(query); err != nil {
+		log.Printf("Error creating favorites table: %v", err)
+		return fmt.Errorf("failed to create favorites table: %v", err)
+	}
+	return nil
+}
+
+// GetFavorites returns all favorites in their configured order. Favorites whose
+// request was deleted are still returned with an empty summary.
+func (c *Client) GetFavorites() ([]Favorite, error) {
+	rows, err := c.db.Query(`
+		SELECT f.id, f.request_id, COALESCE(f.label, ''), f.position, COALESCE(f.created_at, ''),
+			COALESCE(r.method, ''), COALESCE(r.url, ''), COALESCE(r.status, '')
+		FROM favorites f
+		LEFT JOIN requests r ON r.id = f.request_id
+		ORDER BY f.position ASC, f.id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query favorites: %v", err)
+	}
+	defer rows.Close()
+
+	favorites := []Favorite{}
+	for rows.Next() {
+		var favorite Favorite
+		if err := rows.Scan(&favorite.ID, &favorite.RequestID, &favorite.Label, &favorite.Position,
+			&favorite.CreatedAt, &favorite.Method, &favorite.URL, &favorite.Status); err != nil {
+			return nil, fmt.Errorf("failed to scan favorite: %v", err)
+		}
+		favorites = append(favorites, favorite)
+	}
+	return favorites, rows.Err()
+}
+
+// AddFavorite pins a request at the end of the list. Pinning a request that is
+// already pinned only updates its label.
+func (c *Client) AddFavorite(requestID int, label string) error {
+	var exists int
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM requests WHERE id = ?", requestID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up request: %v", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("request %d not found", requestID)
+	}
+
+	_, err := c.db.Exec(`
+		INSERT INTO favorites (request_id, label, position)
+		VALUES (?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM favorites))
+		ON CONFLICT(request_id) DO UPDATE SET label = excluded.label
+	`, requestID, label)
+	if err != nil {
+		return fmt.Errorf("failed to add favorite: %v", err)
+	}
+	return nil
+}
+
+// UpdateLabel changes the label of a favorite
+func (c *Client) UpdateLabel(id int, label string) error {
+	result, err := c.db.Exec("UPDATE favorites SET label = ? WHERE id = ?", label, id)
+	if err != nil {
+		return fmt.Errorf("failed to update favorite label: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("favorite %d not found", id)
+	}
+	return nil
+}
+
+// RemoveFavorite unpins a favorite
+func (c *Client) RemoveFavorite(id int) error {
+	if _, err := c.db.Exec("DELETE FROM favorites WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to remove favorite: %v", err)
+	}
+	return nil
+}
+
+// Reorder sets the order of the favorites to the order of the given IDs.
+// Favorites missing from the list keep their relative order after the listed ones.
+func (c *Client) Reorder(ids []int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Move everything behind the listed favorites first
+	if _, err := tx.Exec("UPDATE favorites SET position = position + ?", len(ids)); err != nil {
+		return fmt.Errorf("failed to reorder favorites: %v", err)
+	}
+	for position, id := range ids {
+		if _, err := tx.Exec("UPDATE favorites SET position = ? WHERE id = ?", position, id); err != nil {
+			return fmt.Errorf("failed to reorder favorites: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit favorites order: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package findings
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"prokzee/internal/asof"
+)
+
+// Severity levels of a finding
+const (
+	SeverityInfo   = "info"
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Finding represents an issue discovered by one of the analyzers
+type Finding struct {
+	ID        int    `json:"id"`
+	Source    string `json:"source"` // analyzer that created the finding
+	Host      string `json:"host"`
+	URL       string `json:"url"`
+	RequestID int    `json:"requestId"`
+	Title     string `json:"title"`
+	Severity  string `json:"severity"`
+	Detail    string `json:"detail"`
+	Key       string `json:"key"` // deduplicates findings of the same source
+	CreatedAt string `json:"createdAt"`
+}
+
+// Client represents the findings client
+type Client struct {
+	db *sql.DB
+}
+
+// NewClient creates a new findings client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure findings table exists: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the findings table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	log.Printf("Ensuring findings table exists...")
+	query := `
+	CREATE TABLE IF NOT EXISTS findings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		source TEXT NOT NULL,
+		host TEXT DEFAULT '',
+		url TEXT DEFAULT '',
+		request_id INTEGER DEFAULT 0,
+		title TEXT NOT NULL,
+		severity TEXT NOT NULL DEFAULT 'info',
+		detail TEXT DEFAULT '',
+		finding_key TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(source, finding_key)
+	)`
+
+	if _, err := c.db.Exec(query); err != nil {
+		log.Printf("Error creating findings table: %v", err)
+		return fmt.Errorf("failed to create findings table: %v", err)
+	}
+	return nil
+}
+
+// AddFinding stores a finding. A finding with the same source and key replaces
+// the details of the existing one, so analyzers can be rerun without duplicates.
+func (c *Client) AddFinding(finding Finding) error {
+	if finding.Key == "" {
+		finding.Key = finding.Host + "|" + finding.Title
+	}
+	if finding.Severity == "" {
+		finding.Severity = SeverityInfo
+	}
+
+	_, err := c.db.Exec(`
+		INSERT INTO findings (source, host, url, request_id, title, severity, detail, finding_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(source, finding_key) DO UPDATE SET
+			host = excluded.host, url = excluded.url, request_id = excluded.request_id,
+			title = excluded.title, severity = excluded.severity, detail = excluded.detail
+	`, finding.Source, finding.Host, finding.URL, finding.RequestID, finding.Title, finding.Severity, finding.Detail, finding.Key)
+	if err != nil {
+		return fmt.Errorf("failed to store finding: %v", err)
+	}
+	return nil
+}
+
+// GetFindings returns all findings, most severe first. A non-zero asOf keeps
+// those found by then.
+func (c *Client) GetFindings(asOf time.Time) ([]Finding, error) {
+	asOfCond, asOfArgs := asof.Condition("created_at", asOf)
+	rows, err := c.db.Query(`
+		SELECT id, source, COALESCE(host, ''), COALESCE(url, ''), COALESCE(request_id, 0), title,
+			severity, COALESCE(detail, ''), finding_key, COALESCE(created_at, '')
+		FROM findings
+		WHERE 1=1`+asOfCond+`
+		ORDER BY CASE severity
+			WHEN 'high' THEN 0
+			WHEN 'medium' THEN 1
+			WHEN 'low' THEN 2
+			ELSE 3
+		END, id DESC
+	`, asOfArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query findings: %v", err)
+	}
+	defer rows.Close()
+
+	findings := []Finding{}
+	for rows.Next() {
+		var finding Finding
+		if err := rows.Scan(&finding.ID, &finding.Source, &finding.Host, &finding.URL, &finding.RequestID,
+			&finding.Title, &finding.Severity, &finding.Detail, &finding.Key, &finding.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan finding: %v", err)
+		}
+		findings = append(findings, finding)
+	}
+	return findings, rows.Err()
+}
+
+// DeleteFinding deletes a finding
+func (c *Client) DeleteFinding(id int) error {
+	if _, err := c.db.Exec("DELETE FROM findings WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete finding: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package fuzzer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"prokzee/internal/contentcoding"
+	textextract "prokzee/internal/textextract"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// AnomalyDiffScore is the diff score above which a result is flagged as
+// anomalous even when its status matches the baseline
+const AnomalyDiffScore = 0.2
+
+// baseline is the response to the unmodified request of a run
+type baseline struct {
+	StatusCode string
+	Length     int
+	tokens     map[string]int
+	tokenCount int
+}
+
+// Delta is how a result differs from the baseline response
+type Delta struct {
+	StatusChanged bool    `json:"statusChanged"`
+	LengthDelta   int     `json:"lengthDelta"`
+	DiffScore     float64 `json:"diffScore"` // 0 identical, 1 nothing in common
+	Anomalous     bool    `json:"anomalous"`
+}
+
+// sendBaseline sends the request with every placeholder replaced by its
+// baseline value (the empty string unless given) and keeps the response to
+// compare results against
+func (f *Fuzzer) sendBaseline(run *fuzzRun, client *http.Client, method, url, body string, headers map[string]interface{}, values []string) *baseline {
+	req, err := http.NewRequestWithContext(run.ctx, method, fillPlaceholders(url, values), bytes.NewBufferString(fillPlaceholders(body, values)))
+	if err != nil {
+		log.Printf("Error creating baseline request: %v", err)
+		return nil
+	}
+	for key, value := range headers {
+		if strValue, ok := value.(string); ok {
+			req.Header.Set(key, strValue)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Error sending baseline request: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := readBody(resp)
+	if err != nil {
+		log.Printf("Error reading baseline response: %v", err)
+		return nil
+	}
+
+	b := &baseline{StatusCode: fmt.Sprintf("%d", resp.StatusCode), Length: len(responseBody)}
+	b.tokens, b.tokenCount = tokenize(textextract.Rendered(resp.Header.Get("Content-Type"), responseBody))
+
+	runtime.EventsEmit(f.ctx, "backend:FuzzerBaseline", map[string]interface{}{
+		"tabId":          run.tabId,
+		"statusCode":     b.StatusCode,
+		"responseLength": b.Length,
+	})
+	return b
+}
+
+// compare computes the delta of a response against the baseline. The diff
+// score of HTML responses is computed on their rendered text.
+func (b *baseline) compare(statusCode, contentType string, responseBody []byte) Delta {
+	delta := Delta{
+		StatusChanged: statusCode != b.StatusCode,
+		LengthDelta:   len(responseBody) - b.Length,
+	}
+
+	tokens, count := tokenize(textextract.Rendered(contentType, responseBody))
+	if count+b.tokenCount > 0 {
+		common := 0
+		for token, n := range tokens {
+			if m := b.tokens[token]; m < n {
+				common += m
+			} else {
+				common += n
+			}
+		}
+		delta.DiffScore = 1 - float64(2*common)/float64(count+b.tokenCount)
+	}
+	delta.Anomalous = delta.StatusChanged || delta.DiffScore > AnomalyDiffScore
+	return delta
+}
+
+// baselineFor returns the baseline of the running tab, if one was recorded
+func (f *Fuzzer) baselineFor(tabId int) *baseline {
+	f.FuzzerMutex.Lock()
+	defer f.FuzzerMutex.Unlock()
+	if run, ok := f.runs[tabId]; ok {
+		return run.baseline
+	}
+	return nil
+}
+
+// grepFor returns the grep terms of the running tab
+func (f *Fuzzer) grepFor(tabId int) []string {
+	f.FuzzerMutex.Lock()
+	defer f.FuzzerMutex.Unlock()
+	if run, ok := f.runs[tabId]; ok {
+		return run.grep
+	}
+	return nil
+}
+
+// grepMatches returns the terms found in a text, ignoring case
+func grepMatches(terms []string, text string) []string {
+	text = strings.ToLower(text)
+	matches := []string{}
+	for _, term := range terms {
+		if strings.Contains(text, strings.ToLower(term)) {
+			matches = append(matches, term)
+		}
+	}
+	return matches
+}
+
+// placeholderPattern matches the injection points of a request template
+var placeholderPattern = regexp.MustCompile(`\[__Inject-Here__\[(\d+)\]\]`)
+
+// fillPlaceholders replaces the injection points of a template with values,
+// the first value going to [__Inject-Here__[1]]. Injection points without a
+// value are removed.
+func fillPlaceholders(template string, values []string) string {
+	return placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholderPattern.FindStringSubmatch(placeholder)[1])
+		if n >= 1 && n <= len(values) {
+			return values[n-1]
+		}
+		return ""
+	})
+}
+
+// tokenize counts the words of a response body
+func tokenize(body string) (map[string]int, int) {
+	tokens := make(map[string]int)
+	fields := strings.FieldsFunc(body, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, field := range fields {
+		tokens[field]++
+	}
+	return tokens, len(fields)
+}
+
+// readBody reads a response body, undoing its content encoding
+func readBody(resp *http.Response) ([]byte, error) {
+	if contentEncoding := resp.Header.Get("Content-Encoding"); !contentcoding.IsIdentity(contentEncoding) && contentcoding.Supported(contentEncoding) {
+		reader, err := contentcoding.NewReader(resp.Body, contentEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("error creating decoder: %v", err)
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
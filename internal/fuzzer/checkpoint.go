@@ -0,0 +1,273 @@
+package fuzzer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	storage "prokzee/internal/storage"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Checkpoint statuses. A checkpoint left "running" without a live run was
+// interrupted by an app restart or a project switch and is reported as
+// "interrupted".
+const (
+	CheckpointRunning     = "running"
+	CheckpointPaused      = "paused"
+	CheckpointStopped     = "stopped"
+	CheckpointFinished    = "finished"
+	CheckpointInterrupted = "interrupted"
+)
+
+// Checkpoint is the persisted progress of a fuzzing run of a tab
+type Checkpoint struct {
+	TabID     int                `json:"tabId"`
+	Status    string             `json:"status"`
+	NextIndex int                `json:"nextIndex"`
+	Total     int                `json:"total"`
+	ElapsedMs int64              `json:"elapsedMs"`
+	AvgMs     int64              `json:"avgMs"`
+	Results   []CheckpointResult `json:"results"`
+	UpdatedAt string             `json:"updatedAt"`
+}
+
+// CheckpointResult is the summary of one sent payload, GetResultDetail
+// returns the full request and response
+type CheckpointResult struct {
+	Index          int    `json:"index"`
+	Payload        string `json:"payload"`
+	StatusCode     string `json:"statusCode"`
+	ResponseLength int    `json:"responseLength"`
+	Error          string `json:"error"`
+}
+
+// ensureCheckpointTables creates the checkpoint tables if they don't exist
+func (f *Fuzzer) ensureCheckpointTables() error {
+	_, err := f.db.Exec(`
+		CREATE TABLE IF NOT EXISTS fuzzer_checkpoints (
+			tab_id INTEGER PRIMARY KEY,
+			config TEXT NOT NULL,
+			status TEXT NOT NULL,
+			next_index INTEGER NOT NULL DEFAULT 0,
+			total INTEGER NOT NULL DEFAULT 0,
+			elapsed_ms INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS fuzzer_checkpoint_results (
+			tab_id INTEGER NOT NULL,
+			payload_index INTEGER NOT NULL,
+			payload TEXT,
+			status_code TEXT,
+			response_length INTEGER DEFAULT 0,
+			error TEXT DEFAULT '',
+			PRIMARY KEY (tab_id, payload_index)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create fuzzer checkpoint tables: %v", err)
+	}
+
+	// The rendered request and full response of every iteration
+	for _, column := range []string{"request_method", "request_url", "request_headers", "request_body", "raw_request", "response_headers", "response_body"} {
+		if err := storage.EnsureColumn(f.db, "fuzzer_checkpoint_results", column, "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// beginCheckpoint stores the configuration of a run. A run starting from the
+// beginning discards the previous checkpoint of the tab; a resumed run keeps
+// its results and elapsed time, which is returned.
+func (f *Fuzzer) beginCheckpoint(tabId int, data map[string]interface{}, startIndex, total int) time.Duration {
+	config := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if key != "resumeFrom" {
+			config[key] = value
+		}
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		log.Printf("Failed to marshal fuzzer checkpoint config: %v", err)
+		return 0
+	}
+
+	var elapsedMs int64
+	if startIndex > 0 {
+		err := f.db.QueryRow("SELECT elapsed_ms FROM fuzzer_checkpoints WHERE tab_id = ?", tabId).Scan(&elapsedMs)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Failed to read fuzzer checkpoint: %v", err)
+		}
+	} else if _, err := f.db.Exec("DELETE FROM fuzzer_checkpoint_results WHERE tab_id = ?", tabId); err != nil {
+		log.Printf("Failed to clear fuzzer checkpoint results: %v", err)
+	}
+
+	_, err = f.db.Exec(`
+		INSERT INTO fuzzer_checkpoints (tab_id, config, status, next_index, total, elapsed_ms, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(tab_id) DO UPDATE SET
+			config = excluded.config,
+			status = excluded.status,
+			next_index = excluded.next_index,
+			total = excluded.total,
+			elapsed_ms = excluded.elapsed_ms,
+			updated_at = CURRENT_TIMESTAMP
+	`, tabId, string(configJSON), CheckpointRunning, startIndex, total, elapsedMs)
+	if err != nil {
+		log.Printf("Failed to store fuzzer checkpoint: %v", err)
+	}
+	return time.Duration(elapsedMs) * time.Millisecond
+}
+
+// recordCheckpoint stores the request and result of a payload and advances the
+// checkpoint past it
+func (f *Fuzzer) recordCheckpoint(tabId, index int, result map[string]interface{}, sent *sentRequest) {
+	elapsed := f.runElapsed(tabId)
+
+	payload, _ := result["payload"].(string)
+	statusCode, _ := result["statusCode"].(string)
+	length, _ := result["responseLength"].(int)
+	errMsg, _ := result["error"].(string)
+	responseBody, _ := result["responseBody"].(string)
+	responseHeaders, err := json.Marshal(result["responseHeaders"])
+	if err != nil {
+		responseHeaders = []byte("{}")
+	}
+	if sent == nil {
+		sent = &sentRequest{}
+	}
+	requestHeaders, err := json.Marshal(sent.Headers)
+	if err != nil {
+		requestHeaders = []byte("{}")
+	}
+
+	_, err = f.db.Exec(`
+		INSERT OR REPLACE INTO fuzzer_checkpoint_results (
+			tab_id, payload_index, payload, status_code, response_length, error,
+			request_method, request_url, request_headers, request_body, raw_request, response_headers, response_body
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, tabId, index, payload, statusCode, length, errMsg,
+		sent.Method, sent.URL, string(requestHeaders), sent.Body, sent.Raw, string(responseHeaders), responseBody)
+	if err != nil {
+		log.Printf("Failed to store fuzzer checkpoint result: %v", err)
+		return
+	}
+
+	_, err = f.db.Exec(`
+		UPDATE fuzzer_checkpoints
+		SET next_index = MAX(next_index, ?), elapsed_ms = MAX(elapsed_ms, ?), updated_at = CURRENT_TIMESTAMP
+		WHERE tab_id = ?
+	`, index+1, elapsed.Milliseconds(), tabId)
+	if err != nil {
+		log.Printf("Failed to update fuzzer checkpoint: %v", err)
+	}
+}
+
+// setCheckpointStatus marks the checkpoint of a tab as paused, stopped or finished
+func (f *Fuzzer) setCheckpointStatus(tabId int, status string) {
+	_, err := f.db.Exec(`
+		UPDATE fuzzer_checkpoints SET status = ?, elapsed_ms = MAX(elapsed_ms, ?), updated_at = CURRENT_TIMESTAMP
+		WHERE tab_id = ?
+	`, status, f.runElapsed(tabId).Milliseconds(), tabId)
+	if err != nil {
+		log.Printf("Failed to update fuzzer checkpoint status: %v", err)
+	}
+}
+
+// runElapsed returns the total time spent fuzzing the tab, including earlier
+// runs of the same checkpoint
+func (f *Fuzzer) runElapsed(tabId int) time.Duration {
+	f.FuzzerMutex.Lock()
+	defer f.FuzzerMutex.Unlock()
+	run, ok := f.runs[tabId]
+	if !ok || run.started.IsZero() {
+		return 0
+	}
+	return run.baseElapsed + time.Since(run.started)
+}
+
+// GetCheckpoint returns the checkpoint of a tab, or nil if it has none
+func (f *Fuzzer) GetCheckpoint(tabId int) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{TabID: tabId, Results: []CheckpointResult{}}
+	err := f.db.QueryRow(`
+		SELECT status, next_index, total, elapsed_ms, COALESCE(updated_at, '')
+		FROM fuzzer_checkpoints WHERE tab_id = ?
+	`, tabId).Scan(&checkpoint.Status, &checkpoint.NextIndex, &checkpoint.Total, &checkpoint.ElapsedMs, &checkpoint.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fuzzer checkpoint: %v", err)
+	}
+
+	if checkpoint.Status == CheckpointRunning {
+		f.FuzzerMutex.Lock()
+		_, live := f.runs[tabId]
+		f.FuzzerMutex.Unlock()
+		if !live {
+			checkpoint.Status = CheckpointInterrupted
+		}
+	}
+	if checkpoint.NextIndex > 0 {
+		checkpoint.AvgMs = checkpoint.ElapsedMs / int64(checkpoint.NextIndex)
+	}
+
+	rows, err := f.db.Query(`
+		SELECT payload_index, COALESCE(payload, ''), COALESCE(status_code, ''), response_length, COALESCE(error, '')
+		FROM fuzzer_checkpoint_results WHERE tab_id = ? ORDER BY payload_index
+	`, tabId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fuzzer checkpoint results: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result CheckpointResult
+		if err := rows.Scan(&result.Index, &result.Payload, &result.StatusCode, &result.ResponseLength, &result.Error); err != nil {
+			return nil, fmt.Errorf("failed to scan fuzzer checkpoint result: %v", err)
+		}
+		checkpoint.Results = append(checkpoint.Results, result)
+	}
+	return checkpoint, rows.Err()
+}
+
+// ResumeFuzzer continues the checkpointed run of a tab from the first payload
+// that wasn't sent
+func (f *Fuzzer) ResumeFuzzer(tabId int) {
+	checkpoint, err := f.GetCheckpoint(tabId)
+	if err != nil || checkpoint == nil {
+		log.Printf("No fuzzer checkpoint to resume for tab %d: %v", tabId, err)
+		runtime.EventsEmit(f.ctx, "backend:FuzzerCheckpoint", map[string]interface{}{
+			"tabId": tabId,
+			"error": "No checkpoint to resume",
+		})
+		return
+	}
+	if checkpoint.Status == CheckpointRunning || checkpoint.Status == CheckpointFinished {
+		log.Printf("Fuzzer checkpoint of tab %d is %s, not resuming", tabId, checkpoint.Status)
+		return
+	}
+
+	var configJSON string
+	if err := f.db.QueryRow("SELECT config FROM fuzzer_checkpoints WHERE tab_id = ?", tabId).Scan(&configJSON); err != nil {
+		log.Printf("Failed to read fuzzer checkpoint config: %v", err)
+		return
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(configJSON), &data); err != nil {
+		log.Printf("Failed to parse fuzzer checkpoint config: %v", err)
+		return
+	}
+	data["resumeFrom"] = float64(checkpoint.NextIndex)
+
+	// Let the frontend restore the results sent before the interruption
+	runtime.EventsEmit(f.ctx, "backend:FuzzerCheckpoint", map[string]interface{}{
+		"tabId":      tabId,
+		"checkpoint": checkpoint,
+	})
+
+	f.StartFuzzer(data)
+}
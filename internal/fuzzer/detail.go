@@ -0,0 +1,92 @@
+package fuzzer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+)
+
+// sentRequest is the request rendered for one payload, as it was sent
+type sentRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]interface{}
+	Body    string
+	Raw     string
+}
+
+// capture stores the wire format of the request, including the headers the
+// HTTP client adds on its own
+func (s *sentRequest) capture(req *http.Request) {
+	raw, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		log.Printf("Failed to capture fuzzer request: %v", err)
+		return
+	}
+	s.Raw = string(raw)
+}
+
+// ResultDetail is everything stored about one fuzzer iteration
+type ResultDetail struct {
+	TabID           int                    `json:"tabId"`
+	Index           int                    `json:"index"`
+	Payload         string                 `json:"payload"`
+	Method          string                 `json:"method"`
+	URL             string                 `json:"url"`
+	Headers         map[string]interface{} `json:"headers"`
+	Body            string                 `json:"body"`
+	RawRequest      string                 `json:"rawRequest"`
+	StatusCode      string                 `json:"statusCode"`
+	ResponseHeaders map[string][]string    `json:"responseHeaders"`
+	ResponseBody    string                 `json:"responseBody"`
+	ResponseLength  int                    `json:"responseLength"`
+	Error           string                 `json:"error"`
+}
+
+// GetResultDetail returns the request and response of one iteration of a tab's last run
+func (f *Fuzzer) GetResultDetail(tabId, index int) (*ResultDetail, error) {
+	detail := &ResultDetail{TabID: tabId, Index: index}
+	var headersJSON, responseHeadersJSON string
+	err := f.db.QueryRow(`
+		SELECT COALESCE(payload, ''), COALESCE(request_method, ''), COALESCE(request_url, ''),
+			COALESCE(request_headers, ''), COALESCE(request_body, ''), COALESCE(raw_request, ''),
+			COALESCE(status_code, ''), COALESCE(response_headers, ''), COALESCE(response_body, ''),
+			response_length, COALESCE(error, '')
+		FROM fuzzer_checkpoint_results WHERE tab_id = ? AND payload_index = ?
+	`, tabId, index).Scan(&detail.Payload, &detail.Method, &detail.URL, &headersJSON, &detail.Body, &detail.RawRequest,
+		&detail.StatusCode, &responseHeadersJSON, &detail.ResponseBody, &detail.ResponseLength, &detail.Error)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no result %d for fuzzer tab %d", index, tabId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fuzzer result: %v", err)
+	}
+
+	detail.Headers = map[string]interface{}{}
+	if headersJSON != "" {
+		if err := json.Unmarshal([]byte(headersJSON), &detail.Headers); err != nil {
+			log.Printf("Failed to unmarshal fuzzer request headers: %v", err)
+		}
+	}
+	detail.ResponseHeaders = map[string][]string{}
+	if responseHeadersJSON != "" {
+		if err := json.Unmarshal([]byte(responseHeadersJSON), &detail.ResponseHeaders); err != nil {
+			log.Printf("Failed to unmarshal fuzzer response headers: %v", err)
+		}
+	}
+	return detail, nil
+}
+
+// ResenderData returns the request of an iteration in the format accepted by
+// resender.SendToResender
+func (d *ResultDetail) ResenderData() map[string]interface{} {
+	return map[string]interface{}{
+		"url":     d.URL,
+		"method":  d.Method,
+		"headers": d.Headers,
+		"body":    d.Body,
+	}
+}
@@ -2,29 +2,50 @@ package fuzzer
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
-	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	textextract "prokzee/internal/textextract"
+	upstream "prokzee/internal/upstream"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+// DefaultConcurrencyBudget is how many fuzzer requests may be in flight at
+// once across all running tabs
+const DefaultConcurrencyBudget = 5
+
 type Fuzzer struct {
-	ctx             context.Context
-	db              *sql.DB
-	isFuzzerRunning bool
-	runningTabId    int
-	FuzzerMutex     sync.Mutex
-	FuzzerProgress  map[int]int
-	progressMutex   sync.Mutex
+	ctx            context.Context
+	db             *sql.DB
+	runs           map[int]*fuzzRun
+	budget         chan struct{}
+	FuzzerMutex    sync.Mutex
+	FuzzerProgress map[int]int
+	progressMutex  sync.Mutex
+}
+
+// fuzzRun is the state of the live fuzzing run of a tab
+type fuzzRun struct {
+	tabId       int
+	startIndex  int
+	total       int
+	started     time.Time
+	baseElapsed time.Duration
+	baseline    *baseline
+	grep        []string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
 }
 
 type FuzzerTab struct {
@@ -40,21 +61,31 @@ type FuzzerTab struct {
 }
 
 type Payload struct {
-	Type string   `json:"type"`
-	List []string `json:"list,omitempty"`
-	From float64  `json:"from,omitempty"`
-	To   float64  `json:"to,omitempty"`
-	Step float64  `json:"step,omitempty"`
+	Type       string   `json:"type"`
+	List       []string `json:"list,omitempty"`
+	From       float64  `json:"from,omitempty"`
+	To         float64  `json:"to,omitempty"`
+	Step       float64  `json:"step,omitempty"`
+	Seed       string   `json:"seed,omitempty"`
+	Count      int      `json:"count,omitempty"`
+	Strategies []string `json:"strategies,omitempty"`
+	Charset    string   `json:"charset,omitempty"`
+	MinLength  int      `json:"minLength,omitempty"`
+	MaxLength  int      `json:"maxLength,omitempty"`
 }
 
 func NewFuzzer(ctx context.Context, db *sql.DB) *Fuzzer {
-	return &Fuzzer{
-		ctx:             ctx,
-		db:              db,
-		isFuzzerRunning: false,
-		runningTabId:    -1,
-		FuzzerProgress:  make(map[int]int),
+	f := &Fuzzer{
+		ctx:            ctx,
+		db:             db,
+		runs:           make(map[int]*fuzzRun),
+		budget:         make(chan struct{}, DefaultConcurrencyBudget),
+		FuzzerProgress: make(map[int]int),
+	}
+	if err := f.ensureCheckpointTables(); err != nil {
+		log.Printf("Warning: %v", err)
 	}
+	return f
 }
 
 func (f *Fuzzer) StartFuzzer(data map[string]interface{}) {
@@ -111,114 +142,114 @@ func (f *Fuzzer) StartFuzzer(data map[string]interface{}) {
 	log.Printf("Received data: targetUrl=%s, method=%s, path=%s, httpVersion=%s, payloads=%v, resumeFrom=%d", targetUrl, method, path, httpVersion, payloads, startIndex)
 
 	f.FuzzerMutex.Lock()
-	if f.isFuzzerRunning {
+	if _, running := f.runs[int(tabId)]; running {
 		f.FuzzerMutex.Unlock()
-		log.Println("Fuzzer is already running")
+		log.Printf("Fuzzer is already running for tab %d", int(tabId))
 		return
 	}
-	f.isFuzzerRunning = true
-	f.runningTabId = int(tabId)
+	runCtx, cancel := context.WithCancel(context.Background())
+	run := &fuzzRun{tabId: int(tabId), ctx: runCtx, cancel: cancel, done: make(chan struct{})}
+	f.runs[int(tabId)] = run
 	f.FuzzerMutex.Unlock()
+	f.emitRuns()
 
-	// Create a custom transport based on the requested HTTP version
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
+	defer func() {
+		f.FuzzerMutex.Lock()
+		if f.runs[run.tabId] == run {
+			delete(f.runs, run.tabId)
+		}
+		f.FuzzerMutex.Unlock()
+		cancel()
+		close(run.done)
+		f.emitRuns()
+	}()
 
-	// Disable HTTP/2 if HTTP/1.1 is requested
-	if httpVersion == "HTTP/1.1" {
-		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
-	}
+	// Negotiate h2 with the server only when HTTP/2 is requested
+	transport := upstream.NewHTTPTransport(upstream.IsHTTP2(httpVersion))
 
 	client := &http.Client{
 		Transport: transport,
 	}
 
 	// Collect all payload values
-	var allPayloadValues [][]string
-	for _, payload := range payloads {
-		payloadMap, ok := payload.(map[string]interface{})
-		if !ok {
-			log.Println("Invalid payload format")
-			continue
-		}
-
-		payloadType, ok := payloadMap["type"].(string)
-		if !ok {
-			log.Println("Invalid or missing payload type")
-			continue
-		}
-
-		var payloadValues []string
-		if payloadType == "sequence" {
-			from, _ := payloadMap["from"].(float64)
-			to, _ := payloadMap["to"].(float64)
-			step, _ := payloadMap["step"].(float64)
-			for i := from; i <= to; i += step {
-				payloadValues = append(payloadValues, fmt.Sprintf("%v", i))
-			}
-		} else if payloadType == "list" {
-			list, ok := payloadMap["list"].([]interface{})
-			if !ok {
-				log.Println("Invalid list payload format")
-				continue
-			}
-			for _, item := range list {
-				if str, ok := item.(string); ok {
-					payloadValues = append(payloadValues, str)
-				}
-			}
-		}
-
-		log.Printf("Payload values for type %s: %v", payloadType, payloadValues)
-		allPayloadValues = append(allPayloadValues, payloadValues)
-	}
+	allPayloadValues := buildPayloadSources(payloads)
 
 	if len(allPayloadValues) == 0 {
 		log.Println("No payload values found")
-		f.FuzzerMutex.Lock()
-		f.isFuzzerRunning = false
-		f.FuzzerMutex.Unlock()
 		return
 	}
 
+	// Persist a checkpoint so the run can be resumed after a restart
+	total := allPayloadValues[0].Len()
+	baseElapsed := f.beginCheckpoint(int(tabId), data, startIndex, total)
+	f.FuzzerMutex.Lock()
+	run.started = time.Now()
+	run.baseElapsed = baseElapsed
+	run.startIndex = startIndex
+	run.total = total
+	f.FuzzerMutex.Unlock()
+
 	// Reset progress for this tab
 	f.progressMutex.Lock()
-	f.FuzzerProgress[int(tabId)] = 0
+	f.FuzzerProgress[int(tabId)] = startIndex
 	f.progressMutex.Unlock()
 
 	// Send progress update to frontend
 	runtime.EventsEmit(f.ctx, "backend:FuzzerProgress", map[string]interface{}{
 		"tabId":    int(tabId),
-		"progress": 0,
+		"progress": startIndex,
 	})
 
-	// Process the payloads
-	for i := startIndex; i < len(allPayloadValues[0]); i++ {
+	// Send the unmodified request first so results can be compared against it
+	var baselineValues []string
+	if values, ok := data["baseline"].([]interface{}); ok {
+		for _, value := range values {
+			str, _ := value.(string)
+			baselineValues = append(baselineValues, str)
+		}
+	}
+	if b := f.sendBaseline(run, client, method, targetUrl+path, body, headers, baselineValues); b != nil {
 		f.FuzzerMutex.Lock()
-		if !f.isFuzzerRunning {
-			f.FuzzerMutex.Unlock()
-			log.Println("Fuzzer stopped")
-			return
+		run.baseline = b
+		f.FuzzerMutex.Unlock()
+	}
+
+	// Terms searched for in the rendered text of every response
+	if terms, ok := data["grep"].([]interface{}); ok {
+		var grep []string
+		for _, term := range terms {
+			if str, ok := term.(string); ok && str != "" {
+				grep = append(grep, str)
+			}
 		}
+		f.FuzzerMutex.Lock()
+		run.grep = grep
 		f.FuzzerMutex.Unlock()
+	}
+
+	// Process the payloads
+	for i := startIndex; i < total; i++ {
+		if run.ctx.Err() != nil {
+			log.Printf("Fuzzer stopped for tab %d", run.tabId)
+			return
+		}
 
 		modifiedBody := body
 		modifiedPath := path
 		for j, payloadValues := range allPayloadValues {
 			placeholder := fmt.Sprintf("[__Inject-Here__[%d]]", j+1)
-			modifiedBody = strings.ReplaceAll(modifiedBody, placeholder, payloadValues[i])
-			modifiedPath = strings.ReplaceAll(modifiedPath, placeholder, payloadValues[i])
+			value := valueAt(payloadValues, i)
+			modifiedBody = strings.ReplaceAll(modifiedBody, placeholder, value)
+			modifiedPath = strings.ReplaceAll(modifiedPath, placeholder, value)
 		}
 
 		// Create a new HTTP request
 		url := targetUrl + modifiedPath
-		req, err := http.NewRequest(method, url, bytes.NewBufferString(modifiedBody))
+		sent := &sentRequest{Method: method, URL: url, Headers: headers, Body: modifiedBody}
+		req, err := http.NewRequestWithContext(run.ctx, method, url, bytes.NewBufferString(modifiedBody))
 		if err != nil {
 			log.Printf("Error creating request: %v", err)
-			f.sendFuzzerResult(int(tabId), i, allPayloadValues, nil, err)
+			f.sendFuzzerResult(int(tabId), i, allPayloadValues, sent, nil, err)
 			continue
 		}
 
@@ -228,53 +259,53 @@ func (f *Fuzzer) StartFuzzer(data map[string]interface{}) {
 				req.Header.Set(key, strValue)
 			}
 		}
+		sent.capture(req)
+
+		// Wait for a slot of the budget shared by all running tabs
+		select {
+		case f.budget <- struct{}{}:
+		case <-run.ctx.Done():
+			log.Printf("Fuzzer stopped for tab %d", run.tabId)
+			return
+		}
 
 		resp, err := client.Do(req)
 		if err != nil {
+			<-f.budget
+			if run.ctx.Err() != nil {
+				// Interrupted by stop or pause, the payload is sent again on resume
+				log.Printf("Fuzzer stopped for tab %d", run.tabId)
+				return
+			}
 			log.Printf("Error sending request: %v", err)
-			f.sendFuzzerResult(int(tabId), i, allPayloadValues, nil, err)
+			f.sendFuzzerResult(int(tabId), i, allPayloadValues, sent, nil, err)
 			continue
 		}
-		defer resp.Body.Close()
 
-		f.handleFuzzerResponse(int(tabId), i, allPayloadValues, resp)
+		f.handleFuzzerResponse(int(tabId), i, allPayloadValues, sent, resp)
+		resp.Body.Close()
+		<-f.budget
 	}
 
-	// Clear progress when finished
-	f.FuzzerMutex.Lock()
-	f.isFuzzerRunning = false
-	runningTabId := f.runningTabId
-	f.runningTabId = -1
-	f.FuzzerMutex.Unlock()
+	if run.ctx.Err() != nil {
+		return
+	}
+	f.setCheckpointStatus(int(tabId), CheckpointFinished)
 
 	// Notify frontend that Fuzzer has finished
 	runtime.EventsEmit(f.ctx, "backend:FuzzerFinished", map[string]interface{}{
-		"tabId": runningTabId,
+		"tabId": int(tabId),
 	})
 
 	log.Println("Fuzzer finished")
 }
 
-func (f *Fuzzer) handleFuzzerResponse(tabId, index int, allPayloadValues [][]string, resp *http.Response) {
-	var responseBody []byte
-	var err error
-
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		reader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			log.Printf("Error creating gzip reader: %v", err)
-			f.sendFuzzerResult(tabId, index, allPayloadValues, resp, err)
-			return
-		}
-		defer reader.Close()
-		responseBody, err = ioutil.ReadAll(reader)
-	} else {
-		responseBody, err = ioutil.ReadAll(resp.Body)
-	}
+func (f *Fuzzer) handleFuzzerResponse(tabId, index int, allPayloadValues []payloadSource, sent *sentRequest, resp *http.Response) {
+	responseBody, err := readBody(resp)
 	resp.Body = ioutil.NopCloser(bytes.NewBuffer(responseBody))
 	if err != nil {
 		log.Printf("Error reading response body: %v", err)
-		f.sendFuzzerResult(tabId, index, allPayloadValues, resp, err)
+		f.sendFuzzerResult(tabId, index, allPayloadValues, sent, resp, err)
 		return
 	}
 
@@ -284,16 +315,20 @@ func (f *Fuzzer) handleFuzzerResponse(tabId, index int, allPayloadValues [][]str
 	f.progressMutex.Unlock()
 
 	// Send progress update to frontend
+	total, eta := f.estimateRemaining(tabId, index+1)
 	runtime.EventsEmit(f.ctx, "backend:FuzzerProgress", map[string]interface{}{
 		"tabId":    tabId,
 		"progress": index + 1,
+		"total":    total,
+		"etaMs":    eta.Milliseconds(),
 	})
 
-	f.sendFuzzerResult(tabId, index, allPayloadValues, resp, nil)
+	f.sendFuzzerResult(tabId, index, allPayloadValues, sent, resp, nil)
 }
 
-func (f *Fuzzer) sendFuzzerResult(tabId, index int, allPayloadValues [][]string, resp *http.Response, err error) {
+func (f *Fuzzer) sendFuzzerResult(tabId, index int, allPayloadValues []payloadSource, sent *sentRequest, resp *http.Response, err error) {
 	result := map[string]interface{}{
+		"index":   index,
 		"payload": strings.Join(getPayloadValuesAtIndex(allPayloadValues, index), ","),
 	}
 
@@ -316,28 +351,85 @@ func (f *Fuzzer) sendFuzzerResult(tabId, index int, allPayloadValues [][]string,
 		result["contentType"] = resp.Header.Get("Content-Type")
 		result["rawStatusLine"] = fmt.Sprintf("%s %s", resp.Proto, resp.Status)
 		result["error"] = ""
+		if b := f.baselineFor(tabId); b != nil {
+			result["baseline"] = b.compare(result["statusCode"].(string), resp.Header.Get("Content-Type"), responseBody)
+		}
+		if grep := f.grepFor(tabId); len(grep) > 0 {
+			result["grepMatches"] = grepMatches(grep, textextract.Rendered(resp.Header.Get("Content-Type"), responseBody))
+		}
 	}
 
 	runtime.EventsEmit(f.ctx, "backend:FuzzerResult", map[string]interface{}{
 		"id":     tabId,
 		"result": result,
 	})
+
+	f.recordCheckpoint(tabId, index, result, sent)
+}
+
+// StopFuzzer stops the run of a tab
+func (f *Fuzzer) StopFuzzer(tabId int) {
+	f.haltFuzzer(tabId, CheckpointStopped)
+	log.Printf("Fuzzer stop requested for tab %d", tabId)
 }
 
-func (f *Fuzzer) StopFuzzer() {
+// PauseFuzzer stops the run of a tab and waits for it to exit, leaving a
+// checkpoint that ResumeFuzzer continues from
+func (f *Fuzzer) PauseFuzzer(tabId int) {
+	done := f.haltFuzzer(tabId, CheckpointPaused)
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Printf("Timed out waiting for the fuzzer of tab %d to pause", tabId)
+		}
+	}
+	log.Printf("Fuzzer pause requested for tab %d", tabId)
+}
+
+// PauseAll pauses every running tab
+func (f *Fuzzer) PauseAll() {
+	for _, tabId := range f.RunningTabs() {
+		f.PauseFuzzer(tabId)
+	}
+}
+
+// RunningTabs returns the IDs of the tabs with a running fuzzer
+func (f *Fuzzer) RunningTabs() []int {
 	f.FuzzerMutex.Lock()
-	wasRunning := f.isFuzzerRunning
-	runningTabId := f.runningTabId
-	f.isFuzzerRunning = false
-	f.FuzzerMutex.Unlock()
+	defer f.FuzzerMutex.Unlock()
+	tabs := make([]int, 0, len(f.runs))
+	for tabId := range f.runs {
+		tabs = append(tabs, tabId)
+	}
+	sort.Ints(tabs)
+	return tabs
+}
 
-	if wasRunning {
-		runtime.EventsEmit(f.ctx, "backend:FuzzerFinished", map[string]interface{}{
-			"tabId": runningTabId,
-		})
+// emitRuns tells the frontend which tabs are running
+func (f *Fuzzer) emitRuns() {
+	runtime.EventsEmit(f.ctx, "backend:FuzzerRuns", map[string]interface{}{
+		"tabIds": f.RunningTabs(),
+		"budget": cap(f.budget),
+	})
+}
+
+// haltFuzzer cancels the run of a tab, records why in its checkpoint and
+// returns a channel closed when the run has exited, or nil if none was running
+func (f *Fuzzer) haltFuzzer(tabId int, status string) chan struct{} {
+	f.FuzzerMutex.Lock()
+	run, running := f.runs[tabId]
+	f.FuzzerMutex.Unlock()
+	if !running {
+		return nil
 	}
 
-	log.Println("Fuzzer stop requested")
+	run.cancel()
+	f.setCheckpointStatus(tabId, status)
+	runtime.EventsEmit(f.ctx, "backend:FuzzerFinished", map[string]interface{}{
+		"tabId": tabId,
+	})
+	return run.done
 }
 
 func (f *Fuzzer) GetFuzzerTabs() []map[string]interface{} {
@@ -383,11 +475,11 @@ func (f *Fuzzer) GetFuzzerTabs() []map[string]interface{} {
 	return tabs
 }
 
-func getPayloadValuesAtIndex(allPayloadValues [][]string, index int) []string {
+func getPayloadValuesAtIndex(allPayloadValues []payloadSource, index int) []string {
 	var values []string
 	for _, payloadValues := range allPayloadValues {
-		if index < len(payloadValues) {
-			values = append(values, payloadValues[index])
+		if index < payloadValues.Len() {
+			values = append(values, payloadValues.At(index))
 		}
 	}
 	return values
@@ -0,0 +1,135 @@
+package fuzzer
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Mutation strategies of the "mutation" payload type
+const (
+	StrategyBoundary = "boundary"
+	StrategyFormat   = "format"
+	StrategyOverflow = "overflow"
+	StrategyBitFlip  = "bitflip"
+	StrategyHavoc    = "havoc"
+)
+
+// DefaultMutationCount is how many payloads a mutation payload generates
+// when no count is given
+const DefaultMutationCount = 100
+
+var boundaryValues = []string{
+	"", "0", "-1", "1", "-0", "0.0", "2147483647", "-2147483648", "2147483648", "4294967295", "4294967296",
+	"9223372036854775807", "-9223372036854775808", "18446744073709551616", "1e308", "-1e308", "1e-308",
+	"NaN", "Infinity", "-Infinity", "null", "undefined", "true", "false", "[]", "{}", "0x7fffffff",
+}
+
+var formatValues = []string{
+	"%s%s%s%s%s", "%x%x%x%x", "%n%n%n%n", "%p%p%p%p", "%d%d%d%d", "%99999999s", "{0}", "{}", "${7*7}",
+	"{{7*7}}", "#{7*7}", "%00", "%0a", "%0d%0a", "\x00", "\\", "'", "\"", "`", "../", "..\\",
+}
+
+// Mutate generates payloads by mutating the original value of a parameter.
+// The output only depends on its arguments, so a resumed run gets the same
+// payloads at the same indexes.
+func Mutate(seed string, count int, strategies []string) []string {
+	if count <= 0 {
+		count = DefaultMutationCount
+	}
+	if len(strategies) == 0 {
+		strategies = []string{StrategyBoundary, StrategyFormat, StrategyOverflow, StrategyBitFlip, StrategyHavoc}
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	var payloads []string
+	seen := map[string]bool{seed: true}
+	add := func(value string) {
+		if len(payloads) < count && !seen[value] {
+			seen[value] = true
+			payloads = append(payloads, value)
+		}
+	}
+
+	for _, strategy := range strategies {
+		switch strategy {
+		case StrategyBoundary:
+			if n, err := strconv.ParseInt(seed, 10, 64); err == nil {
+				add(strconv.FormatInt(n+1, 10))
+				add(strconv.FormatInt(n-1, 10))
+				add(strconv.FormatInt(-n, 10))
+				add(strconv.FormatInt(n*10, 10))
+				add(seed + ".5")
+			}
+			for _, value := range boundaryValues {
+				add(value)
+			}
+		case StrategyFormat:
+			for _, value := range formatValues {
+				add(seed + value)
+				add(value)
+			}
+		case StrategyOverflow:
+			for _, size := range []int{256, 1024, 4096, 65536} {
+				add(strings.Repeat("A", size))
+				if seed != "" {
+					add(strings.Repeat(seed, size/len(seed)+1)[:size])
+				}
+			}
+		case StrategyBitFlip:
+			for i := 0; i < len(seed) && i < 32; i++ {
+				b := []byte(seed)
+				b[i] ^= 1 << uint(i%8)
+				add(string(b))
+			}
+		}
+	}
+
+	// Havoc fills the remaining budget with random edits of the seed that
+	// reuse its own characters, so values stay close to the expected format
+	havoc := false
+	for _, strategy := range strategies {
+		havoc = havoc || strategy == StrategyHavoc
+	}
+	for attempts := 0; havoc && len(payloads) < count && attempts < count*10; attempts++ {
+		add(havocEdit(rng, seed))
+	}
+
+	return payloads
+}
+
+// havocEdit applies a few random insertions, deletions, replacements and
+// duplications to a value
+func havocEdit(rng *rand.Rand, seed string) string {
+	alphabet := []rune(seed + "'\"<>{}%;-_/\\0123456789")
+	value := []rune(seed)
+	for edits := 1 + rng.Intn(4); edits > 0; edits-- {
+		pos := 0
+		if len(value) > 0 {
+			pos = rng.Intn(len(value))
+		}
+		switch rng.Intn(4) {
+		case 0:
+			value = append(value[:pos], append([]rune{alphabet[rng.Intn(len(alphabet))]}, value[pos:]...)...)
+		case 1:
+			if len(value) > 0 {
+				value = append(value[:pos], value[pos+1:]...)
+			}
+		case 2:
+			if len(value) > 0 {
+				value[pos] = alphabet[rng.Intn(len(alphabet))]
+			}
+		case 3:
+			if len(value) > 0 {
+				end := pos + 1 + rng.Intn(len(value)-pos)
+				chunk := append([]rune{}, value[pos:end]...)
+				value = append(value[:end], append(chunk, value[end:]...)...)
+			}
+		}
+	}
+	return string(value)
+}
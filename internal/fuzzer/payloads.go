@@ -0,0 +1,236 @@
+package fuzzer
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"prokzee/internal/unicodebypass"
+)
+
+// MaxBruteForceCount bounds how many values a brute force payload may enumerate
+const MaxBruteForceCount = 100_000_000
+
+// Charsets are the named character sets of the "bruteforce" payload type
+var Charsets = map[string]string{
+	"digits":   "0123456789",
+	"hex":      "0123456789abcdef",
+	"HEX":      "0123456789ABCDEF",
+	"lower":    "abcdefghijklmnopqrstuvwxyz",
+	"upper":    "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alpha":    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":    "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnumLow": "0123456789abcdefghijklmnopqrstuvwxyz",
+}
+
+// payloadSource produces the values of one injection point by index, so
+// large payload sets are never held in memory
+type payloadSource interface {
+	Len() int
+	At(i int) string
+}
+
+// listSource is a payload set generated up front
+type listSource []string
+
+func (l listSource) Len() int        { return len(l) }
+func (l listSource) At(i int) string { return l[i] }
+
+// bruteForceSource enumerates every string over a charset, shortest first,
+// in charset order: 0000, 0001, ... 9999 for four digits
+type bruteForceSource struct {
+	charset []rune
+	lengths []int
+	counts  []int
+	total   int
+}
+
+func newBruteForceSource(charset string, minLength, maxLength int) (*bruteForceSource, error) {
+	runes := []rune(charset)
+	if len(runes) == 0 {
+		return nil, fmt.Errorf("empty charset")
+	}
+	if minLength < 1 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid length range %d-%d", minLength, maxLength)
+	}
+
+	source := &bruteForceSource{charset: runes}
+	for length := minLength; length <= maxLength; length++ {
+		count := 1
+		for i := 0; i < length; i++ {
+			count *= len(runes)
+			if count > MaxBruteForceCount {
+				return nil, fmt.Errorf("brute force exceeds %d values", MaxBruteForceCount)
+			}
+		}
+		source.total += count
+		if source.total > MaxBruteForceCount {
+			return nil, fmt.Errorf("brute force exceeds %d values", MaxBruteForceCount)
+		}
+		source.lengths = append(source.lengths, length)
+		source.counts = append(source.counts, count)
+	}
+	return source, nil
+}
+
+func (b *bruteForceSource) Len() int { return b.total }
+
+func (b *bruteForceSource) At(i int) string {
+	for bucket, count := range b.counts {
+		if i >= count {
+			i -= count
+			continue
+		}
+		value := make([]rune, b.lengths[bucket])
+		for pos := len(value) - 1; pos >= 0; pos-- {
+			value[pos] = b.charset[i%len(b.charset)]
+			i /= len(b.charset)
+		}
+		return string(value)
+	}
+	return ""
+}
+
+// valueAt returns the value of a source at an index, or "" past its end
+func valueAt(source payloadSource, i int) string {
+	if i < source.Len() {
+		return source.At(i)
+	}
+	return ""
+}
+
+// buildPayloadSources turns the payload definitions of a tab into sources,
+// one per injection point
+func buildPayloadSources(payloads []interface{}) []payloadSource {
+	var sources []payloadSource
+	for _, payload := range payloads {
+		payloadMap, ok := payload.(map[string]interface{})
+		if !ok {
+			log.Println("Invalid payload format")
+			continue
+		}
+
+		payloadType, ok := payloadMap["type"].(string)
+		if !ok {
+			log.Println("Invalid or missing payload type")
+			continue
+		}
+
+		var payloadValues []string
+		if payloadType == "sequence" {
+			from, _ := payloadMap["from"].(float64)
+			to, _ := payloadMap["to"].(float64)
+			step, _ := payloadMap["step"].(float64)
+			for i := from; i <= to; i += step {
+				payloadValues = append(payloadValues, fmt.Sprintf("%v", i))
+			}
+		} else if payloadType == "list" {
+			list, ok := payloadMap["list"].([]interface{})
+			if !ok {
+				log.Println("Invalid list payload format")
+				continue
+			}
+			for _, item := range list {
+				if str, ok := item.(string); ok {
+					payloadValues = append(payloadValues, str)
+				}
+			}
+		} else if payloadType == "mutation" {
+			seed, _ := payloadMap["seed"].(string)
+			count, _ := payloadMap["count"].(float64)
+			var strategies []string
+			if list, ok := payloadMap["strategies"].([]interface{}); ok {
+				for _, item := range list {
+					if str, ok := item.(string); ok {
+						strategies = append(strategies, str)
+					}
+				}
+			}
+			payloadValues = Mutate(seed, int(count), strategies)
+		} else if payloadType == "bruteforce" {
+			charset, _ := payloadMap["charset"].(string)
+			if named, ok := Charsets[charset]; ok {
+				charset = named
+			}
+			minLength, _ := payloadMap["minLength"].(float64)
+			maxLength, _ := payloadMap["maxLength"].(float64)
+			if maxLength == 0 {
+				maxLength = minLength
+			}
+			source, err := newBruteForceSource(charset, int(minLength), int(maxLength))
+			if err != nil {
+				log.Printf("Invalid brute force payload: %v", err)
+				continue
+			}
+			log.Printf("Brute force payload over %d characters: %d values", len(source.charset), source.Len())
+			sources = append(sources, source)
+			continue
+		}
+
+		if techniques, ok := unicodeProcessor(payloadMap); ok {
+			payloadValues = withUnicodeVariants(payloadValues, techniques)
+		}
+
+		log.Printf("Payload values for type %s: %v", payloadType, payloadValues)
+		sources = append(sources, listSource(payloadValues))
+	}
+	return sources
+}
+
+// unicodeProcessor returns the techniques of the "unicode" processor of a
+// payload definition, true or a list of technique names, and whether it has
+// one. An empty list stands for every technique.
+func unicodeProcessor(payloadMap map[string]interface{}) ([]string, bool) {
+	switch processor := payloadMap["unicode"].(type) {
+	case bool:
+		return nil, processor
+	case []interface{}:
+		var techniques []string
+		for _, item := range processor {
+			if str, ok := item.(string); ok {
+				techniques = append(techniques, str)
+			}
+		}
+		return techniques, true
+	}
+	return nil, false
+}
+
+// withUnicodeVariants follows every value with its Unicode case mapping,
+// normalization and homoglyph variants
+func withUnicodeVariants(values []string, techniques []string) []string {
+	var out []string
+	for _, value := range values {
+		out = append(out, value)
+		out = append(out, unicodebypass.Values(value, techniques)...)
+	}
+	return out
+}
+
+// EstimatePayloads returns how many requests the payload definitions of a tab
+// produce and how long they would take at the given average request time
+func EstimatePayloads(payloads []interface{}, avg time.Duration) (int, time.Duration) {
+	sources := buildPayloadSources(payloads)
+	if len(sources) == 0 {
+		return 0, 0
+	}
+	total := sources[0].Len()
+	return total, time.Duration(total) * avg
+}
+
+// estimateRemaining returns the number of payloads of the running tab and the
+// time left, extrapolated from the requests sent since it was started
+func (f *Fuzzer) estimateRemaining(tabId, done int) (int, time.Duration) {
+	f.FuzzerMutex.Lock()
+	defer f.FuzzerMutex.Unlock()
+	run, ok := f.runs[tabId]
+	if !ok || run.started.IsZero() {
+		return 0, 0
+	}
+	sent := done - run.startIndex
+	if sent <= 0 || done >= run.total {
+		return run.total, 0
+	}
+	perRequest := time.Since(run.started) / time.Duration(sent)
+	return run.total, perRequest * time.Duration(run.total-done)
+}
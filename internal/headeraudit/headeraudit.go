@@ -0,0 +1,231 @@
+package headeraudit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Check outcomes for a host
+const (
+	StatusPass    = "pass"
+	StatusPartial = "partial" // some responses pass, some fail
+	StatusFail    = "fail"
+	StatusNA      = "n/a" // the check does not apply to any response of the host
+)
+
+// Checks lists the audited properties in the order they appear in the matrix
+var Checks = []string{
+	"HSTS",
+	"CSP",
+	"X-Content-Type-Options",
+	"Clickjacking protection",
+	"Referrer-Policy",
+	"Cookie Secure",
+	"Cookie HttpOnly",
+	"Cookie SameSite",
+}
+
+// HostResult holds the compliance of a single host
+type HostResult struct {
+	Host      string            `json:"host"`
+	Responses int               `json:"responses"`
+	Results   map[string]string `json:"results"`
+}
+
+// Report is the per-host compliance matrix
+type Report struct {
+	Checks []string     `json:"checks"`
+	Hosts  []HostResult `json:"hosts"`
+}
+
+// counts tracks how many responses passed and failed a check
+type counts struct {
+	pass int
+	fail int
+}
+
+// Auditor evaluates the security headers of stored responses
+type Auditor struct {
+	db *sql.DB
+}
+
+// NewAuditor creates a new header auditor
+func NewAuditor(db *sql.DB) *Auditor {
+	return &Auditor{db: db}
+}
+
+// Run audits every stored response whose host passes the filter. A nil filter
+// audits all hosts.
+func (a *Auditor) Run(filter func(host string) bool) (*Report, error) {
+	rows, err := a.db.Query(`
+		SELECT COALESCE(domain, ''), COALESCE(url, ''), COALESCE(response_headers, '')
+		FROM requests
+		WHERE response_headers IS NOT NULL AND response_headers != ''
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query responses: %v", err)
+	}
+	defer rows.Close()
+
+	hostCounts := make(map[string]map[string]*counts)
+	hostResponses := make(map[string]int)
+	allowed := make(map[string]bool)
+
+	for rows.Next() {
+		var host, rawURL, rawHeaders string
+		if err := rows.Scan(&host, &rawURL, &rawHeaders); err != nil {
+			return nil, fmt.Errorf("failed to scan response: %v", err)
+		}
+
+		if _, seen := allowed[host]; !seen {
+			allowed[host] = filter == nil || filter(host)
+		}
+		if !allowed[host] {
+			continue
+		}
+
+		var headers http.Header
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			continue
+		}
+
+		if hostCounts[host] == nil {
+			hostCounts[host] = make(map[string]*counts)
+			for _, check := range Checks {
+				hostCounts[host][check] = &counts{}
+			}
+		}
+		hostResponses[host]++
+
+		for check, passed := range evaluate(strings.HasPrefix(rawURL, "https://"), headers) {
+			if passed {
+				hostCounts[host][check].pass++
+			} else {
+				hostCounts[host][check].fail++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read responses: %v", err)
+	}
+
+	report := &Report{Checks: Checks, Hosts: []HostResult{}}
+	for host, checkCounts := range hostCounts {
+		result := HostResult{Host: host, Responses: hostResponses[host], Results: make(map[string]string)}
+		for _, check := range Checks {
+			result.Results[check] = status(checkCounts[check])
+		}
+		report.Hosts = append(report.Hosts, result)
+	}
+	sort.Slice(report.Hosts, func(i, j int) bool {
+		return report.Hosts[i].Host < report.Hosts[j].Host
+	})
+
+	return report, nil
+}
+
+// evaluate returns the outcome of every check that applies to a response
+func evaluate(isHTTPS bool, headers http.Header) map[string]bool {
+	results := make(map[string]bool)
+
+	if isHTTPS {
+		hsts := strings.ToLower(headers.Get("Strict-Transport-Security"))
+		results["HSTS"] = strings.Contains(hsts, "max-age=") && !strings.Contains(hsts, "max-age=0")
+	}
+
+	csp := headers.Get("Content-Security-Policy")
+	results["CSP"] = csp != ""
+	results["X-Content-Type-Options"] = strings.EqualFold(strings.TrimSpace(headers.Get("X-Content-Type-Options")), "nosniff")
+
+	frameOptions := strings.ToLower(headers.Get("X-Frame-Options"))
+	results["Clickjacking protection"] = frameOptions == "deny" || frameOptions == "sameorigin" ||
+		strings.Contains(strings.ToLower(csp), "frame-ancestors")
+
+	results["Referrer-Policy"] = headers.Get("Referrer-Policy") != ""
+
+	cookies := headers.Values("Set-Cookie")
+	if len(cookies) > 0 {
+		secure, httpOnly, sameSite := true, true, true
+		for _, cookie := range cookies {
+			attributes := cookieAttributes(cookie)
+			secure = secure && attributes["secure"]
+			httpOnly = httpOnly && attributes["httponly"]
+			sameSite = sameSite && attributes["samesite"]
+		}
+		results["Cookie Secure"] = secure
+		results["Cookie HttpOnly"] = httpOnly
+		results["Cookie SameSite"] = sameSite
+	}
+
+	return results
+}
+
+// cookieAttributes returns the lower-cased attribute names of a Set-Cookie value
+func cookieAttributes(cookie string) map[string]bool {
+	attributes := make(map[string]bool)
+	parts := strings.Split(cookie, ";")
+	for _, part := range parts[1:] {
+		name := strings.SplitN(strings.TrimSpace(part), "=", 2)[0]
+		attributes[strings.ToLower(name)] = true
+	}
+	return attributes
+}
+
+// status collapses the per-response outcomes of a check into one host status
+func status(c *counts) string {
+	switch {
+	case c.pass == 0 && c.fail == 0:
+		return StatusNA
+	case c.fail == 0:
+		return StatusPass
+	case c.pass == 0:
+		return StatusFail
+	default:
+		return StatusPartial
+	}
+}
+
+// CSV renders the report as CSV with one row per host
+func (r *Report) CSV() (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(append([]string{"Host", "Responses"}, r.Checks...)); err != nil {
+		return "", err
+	}
+	for _, host := range r.Hosts {
+		row := []string{host.Host, fmt.Sprintf("%d", host.Responses)}
+		for _, check := range r.Checks {
+			row = append(row, host.Results[check])
+		}
+		if err := w.Write(row); err != nil {
+			return "", err
+		}
+	}
+
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// Markdown renders the report as a Markdown table with one row per host
+func (r *Report) Markdown() string {
+	var sb strings.Builder
+
+	sb.WriteString("| Host | Responses | " + strings.Join(r.Checks, " | ") + " |\n")
+	sb.WriteString("|---|---|" + strings.Repeat("---|", len(r.Checks)) + "\n")
+	for _, host := range r.Hosts {
+		sb.WriteString(fmt.Sprintf("| %s | %d |", host.Host, host.Responses))
+		for _, check := range r.Checks {
+			sb.WriteString(" " + host.Results[check] + " |")
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String()
+}
@@ -3,8 +3,17 @@ package history
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"prokzee/internal/asof"
+	"prokzee/internal/htmlpreview"
+	idn "prokzee/internal/idn"
 )
 
 // Request represents a single HTTP request/response pair
@@ -19,17 +28,38 @@ type Request struct {
 	Status          string `json:"status"`
 	Length          int64  `json:"length"`
 	MimeType        string `json:"mimeType"`
+	SniffedType     string `json:"sniffedType,omitempty"` // what the response body looks like
 	Timestamp       string `json:"timestamp"`
 	RequestHeaders  string `json:"requestHeaders,omitempty"`
 	RequestBody     string `json:"requestBody,omitempty"`
 	ResponseHeaders string `json:"responseHeaders,omitempty"`
 	ResponseBody    string `json:"responseBody,omitempty"`
 	Query           string `json:"query,omitempty"`
+	TransferInfo    string `json:"transferInfo,omitempty"`
+	ContentChanged  bool   `json:"contentChanged,omitempty"`
+	MediaMetadata   string `json:"mediaMetadata,omitempty"` // EXIF and XMP of images, as JSON
+	ArchiveInfo     string `json:"archiveInfo,omitempty"`   // files and macro indicators of archives, as JSON
+	BodyTruncated   bool   `json:"bodyTruncated,omitempty"` // a body is stored whole only in a blob
+	CorrelationID   string `json:"correlationId,omitempty"` // sent upstream in the correlation header
+
+	// Set by AnnotateDomains: the decoded punycode domain and the in-scope
+	// host it imitates, Domain keeps the original
+	DisplayDomain   string `json:"displayDomain,omitempty"`
+	Lookalike       string `json:"lookalike,omitempty"`
+	LookalikeReason string `json:"lookalikeReason,omitempty"`
+
+	// Tags computed when the exchange was stored, such as json-api
+	Tags []string `json:"tags,omitempty"`
+
+	// Views of the bodies, set by GetRequestByID
+	RequestView  *BodyView `json:"requestView,omitempty"`
+	ResponseView *BodyView `json:"responseView,omitempty"`
 }
 
 // Client handles HTTP request history operations
 type Client struct {
-	db *sql.DB
+	db      *sql.DB
+	blobDir string
 }
 
 // NewClient creates a new history client
@@ -39,8 +69,16 @@ func NewClient(db *sql.DB) (*Client, error) {
 	}, nil
 }
 
-// GetAllRequests retrieves all HTTP requests with pagination and search
-func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQuery string) ([]Request, map[string]interface{}, error) {
+// SetBlobDir sets the directory the bodies over the stored size limit are
+// read from
+func (c *Client) SetBlobDir(dir string) {
+	c.blobDir = dir
+}
+
+// GetAllRequests retrieves all HTTP requests with pagination and search,
+// keeping only the requests carrying every one of tags. A non-zero asOf
+// shows the history as it was at that time.
+func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQuery string, tags []string, asOf time.Time) ([]Request, map[string]interface{}, error) {
 	// Log search parameters for debugging
 	log.Printf("Search query: '%s', sort: %s %s, page: %d, limit: %d",
 		searchQuery, sortKey, sortDirection, page, limit)
@@ -63,13 +101,30 @@ func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQ
 			request_body,
 			response_headers,
 			response_body,
-			query
+			query,
+			COALESCE(content_changed, 0),
+			COALESCE(sniffed_type, ''),
+			COALESCE(correlation_id, ''),
+			COALESCE(tags, '')
 		FROM requests
 		WHERE 1=1
 	`
 	countQuery := "SELECT COUNT(*) FROM requests WHERE 1=1"
 	params := []interface{}{}
 
+	// Tags are stored comma separated, a whole entry has to match
+	for _, tag := range tags {
+		tagCond := " AND instr(',' || COALESCE(tags, '') || ',', ?) > 0"
+		baseQuery += tagCond
+		countQuery += tagCond
+		params = append(params, ","+strings.ToLower(strings.TrimSpace(tag))+",")
+	}
+
+	asOfCond, asOfArgs := asof.Condition("timestamp", asOf)
+	baseQuery += asOfCond
+	countQuery += asOfCond
+	params = append(params, asOfArgs...)
+
 	// Add search condition if search query exists
 	if searchQuery != "" {
 		// Trim and clean search query
@@ -131,6 +186,16 @@ func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQ
 			params = append(params, "%"+strings.ToLower(searchQuery)+"%")
 		}
 
+		// A correlation ID from a server log finds its request
+		conditions = append(conditions, "correlation_id = ?")
+		params = append(params, strings.ToLower(searchQuery))
+
+		// Domains are stored in punycode, so match a Unicode query in that form too
+		if encoded := idn.ToASCII(strings.ToLower(searchQuery)); encoded != strings.ToLower(searchQuery) {
+			conditions = append(conditions, "LOWER(domain) LIKE ?")
+			params = append(params, "%"+encoded+"%")
+		}
+
 		// Then add LIKE clauses for partial matches
 		// Don't add method/status LIKE clauses if we're doing exact matching
 		if !exactMethodMatch {
@@ -207,6 +272,7 @@ func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQ
 		var timestamp string
 		var lengthNull sql.NullInt64
 		var mimeTypeNull sql.NullString
+		var tags string
 		err := rows.Scan(
 			&req.ID,
 			&req.Method,
@@ -224,11 +290,16 @@ func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQ
 			&req.ResponseHeaders,
 			&req.ResponseBody,
 			&req.Query,
+			&req.ContentChanged,
+			&req.SniffedType,
+			&req.CorrelationID,
+			&tags,
 		)
 		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
+		req.Tags = splitTags(tags)
 		req.Status = status
 		req.Timestamp = timestamp
 		req.Length = lengthNull.Int64
@@ -251,7 +322,53 @@ func (c *Client) GetAllRequests(page, limit int, sortKey, sortDirection, searchQ
 	return requests, pagination, nil
 }
 
-// GetRequestByID retrieves a specific request by its ID
+// GetTags returns the tags the stored requests carry, sorted
+func (c *Client) GetTags() ([]string, error) {
+	rows, err := c.db.Query("SELECT DISTINCT tags FROM requests WHERE COALESCE(tags, '') != ''")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tags: %v", err)
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	tags := []string{}
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			return nil, err
+		}
+		for _, tag := range splitTags(stored) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags, rows.Err()
+}
+
+// splitTags reads the comma separated tags of a request
+func splitTags(stored string) []string {
+	if stored == "" {
+		return nil
+	}
+	return strings.Split(stored, ",")
+}
+
+// AnnotateDomains sets the display form of the punycode domains of requests
+// and flags the ones imitating a reference host, such as an in-scope one
+func AnnotateDomains(requests []Request, references []string) {
+	for i := range requests {
+		info := idn.Analyze(requests[i].Domain, references)
+		requests[i].DisplayDomain = info.Display
+		requests[i].Lookalike = info.Lookalike
+		requests[i].LookalikeReason = info.Reason
+	}
+}
+
+// GetRequestByID retrieves a specific request by its ID, with the views of
+// its bodies
 func (c *Client) GetRequestByID(id string) (*Request, error) {
 	query := `
 		SELECT 
@@ -265,12 +382,22 @@ func (c *Client) GetRequestByID(id string) (*Request, error) {
 			request_body,
 			response_headers,
 			response_body,
-			status
+			status,
+			COALESCE(transfer_info, ''),
+			COALESCE(sniffed_type, ''),
+			COALESCE(media_metadata, ''),
+			COALESCE(archive_info, ''),
+			COALESCE(request_encoding, ''),
+			COALESCE(response_encoding, ''),
+			COALESCE(body_truncated, 0),
+			COALESCE(correlation_id, ''),
+			COALESCE(tags, '')
 		FROM requests 
 		WHERE id = ?
 	`
 
 	var details Request
+	var requestEncoding, responseEncoding, tags string
 	err := c.db.QueryRow(query, id).Scan(
 		&details.Method,
 		&details.Domain,
@@ -283,11 +410,151 @@ func (c *Client) GetRequestByID(id string) (*Request, error) {
 		&details.ResponseHeaders,
 		&details.ResponseBody,
 		&details.Status,
+		&details.TransferInfo,
+		&details.SniffedType,
+		&details.MediaMetadata,
+		&details.ArchiveInfo,
+		&requestEncoding,
+		&responseEncoding,
+		&details.BodyTruncated,
+		&details.CorrelationID,
+		&tags,
 	)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch request details: %v", err)
 	}
+	details.Tags = splitTags(tags)
 
+	details.RequestView = NewBodyView(details.RequestBody, details.RequestHeaders, requestEncoding)
+	details.ResponseView = NewBodyView(details.ResponseBody, details.ResponseHeaders, responseEncoding)
+	if details.ResponseView.Format == FormatHTML {
+		details.ResponseView.Preview = htmlpreview.Sanitize(details.ResponseView.Text, details.URL)
+	}
 	return &details, nil
 }
+
+// HexLine is one row of a hex dump
+type HexLine struct {
+	Offset int    `json:"offset"`
+	Hex    string `json:"hex"`
+	ASCII  string `json:"ascii"`
+}
+
+// HexPage is a slice of a stored body rendered as a hex dump
+type HexPage struct {
+	RequestID int       `json:"requestId"`
+	Part      string    `json:"part"`
+	Offset    int       `json:"offset"`
+	Total     int       `json:"total"`
+	Lines     []HexLine `json:"lines"`
+}
+
+// hexBytesPerLine is the width of a hex dump row
+const hexBytesPerLine = 16
+
+// maxHexPageSize bounds how many bytes a single hex page can cover
+const maxHexPageSize = 64 * 1024
+
+// GetBodyHexPage returns a hex dump of part of a stored request or response
+// body. Only the requested bytes are read from the database, or from the blob
+// holding the whole body when it was too large for the database.
+func (c *Client) GetBodyHexPage(id int, part string, offset, length int) (*HexPage, error) {
+	column, blobColumn := "", ""
+	switch part {
+	case "request":
+		column, blobColumn = "request_body", "request_blob"
+	case "response":
+		column, blobColumn = "response_body", "response_blob"
+	default:
+		return nil, fmt.Errorf("unknown body part: %s", part)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if length <= 0 || length > maxHexPageSize {
+		length = maxHexPageSize
+	}
+	// Align pages on row boundaries so offsets line up across pages
+	offset -= offset % hexBytesPerLine
+
+	total, chunk, err := c.readBlobPage(id, blobColumn, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if chunk == nil {
+		// substr on a BLOB counts bytes and starts at 1
+		query := fmt.Sprintf(`
+			SELECT COALESCE(length(CAST(%[1]s AS BLOB)), 0), COALESCE(substr(CAST(%[1]s AS BLOB), ?, ?), X'')
+			FROM requests WHERE id = ?
+		`, column)
+
+		if err := c.db.QueryRow(query, offset+1, length, id).Scan(&total, &chunk); err != nil {
+			return nil, fmt.Errorf("failed to fetch body: %v", err)
+		}
+	}
+
+	page := &HexPage{RequestID: id, Part: part, Offset: offset, Total: total, Lines: []HexLine{}}
+	for start := 0; start < len(chunk); start += hexBytesPerLine {
+		end := start + hexBytesPerLine
+		if end > len(chunk) {
+			end = len(chunk)
+		}
+		row := chunk[start:end]
+
+		var hexPart, asciiPart strings.Builder
+		for i, b := range row {
+			if i > 0 {
+				hexPart.WriteByte(' ')
+			}
+			fmt.Fprintf(&hexPart, "%02x", b)
+			if b >= 0x20 && b < 0x7f {
+				asciiPart.WriteByte(b)
+			} else {
+				asciiPart.WriteByte('.')
+			}
+		}
+		page.Lines = append(page.Lines, HexLine{
+			Offset: offset + start,
+			Hex:    hexPart.String(),
+			ASCII:  asciiPart.String(),
+		})
+	}
+
+	return page, nil
+}
+
+// readBlobPage reads part of a body from its blob. It returns a nil chunk
+// when the body has no blob, or the blob is gone, so the caller falls back to
+// the part stored in the database.
+func (c *Client) readBlobPage(id int, blobColumn string, offset, length int) (int, []byte, error) {
+	if c.blobDir == "" {
+		return 0, nil, nil
+	}
+	var name string
+	query := fmt.Sprintf("SELECT COALESCE(%s, '') FROM requests WHERE id = ?", blobColumn)
+	if err := c.db.QueryRow(query, id).Scan(&name); err != nil {
+		return 0, nil, fmt.Errorf("failed to fetch body: %v", err)
+	}
+	if name == "" {
+		return 0, nil, nil
+	}
+
+	file, err := os.Open(filepath.Join(c.blobDir, filepath.Base(name)))
+	if err != nil {
+		log.Printf("Body blob %s of request %d is unavailable: %v", name, id, err)
+		return 0, nil, nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read body blob: %v", err)
+	}
+
+	chunk := make([]byte, length)
+	n, err := file.ReadAt(chunk, int64(offset))
+	if err != nil && err != io.EOF {
+		return 0, nil, fmt.Errorf("failed to read body blob: %v", err)
+	}
+	return int(info.Size()), chunk[:n], nil
+}
@@ -0,0 +1,267 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/textextract"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Body formats detected for the viewer
+const (
+	FormatJSON       = "json"
+	FormatXML        = "xml"
+	FormatHTML       = "html"
+	FormatJavaScript = "javascript"
+	FormatCSS        = "css"
+	FormatForm       = "form"
+	FormatText       = "text"
+	FormatBinary     = "binary"
+)
+
+// xmlEncodingPattern finds the encoding an XML prolog declares
+var xmlEncodingPattern = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding=["']([A-Za-z0-9._\-]+)["']`)
+
+// maxViewSize bounds the bodies that are decoded and formatted, larger ones
+// are left to the hex view
+const maxViewSize = 5 * 1024 * 1024
+
+// BodyView is a stored body in the representations the viewer switches
+// between, so the frontend does not recompute them
+type BodyView struct {
+	Size     int    `json:"size"`               // bytes as stored
+	Encoding string `json:"encoding,omitempty"` // Content-Encoding of the message
+	Decoded  bool   `json:"decoded"`            // whether the content encoding was undone
+	Charset  string `json:"charset,omitempty"`
+	Format   string `json:"format"`
+	Text     string `json:"text"`               // decompressed and decoded to UTF-8
+	Pretty   string `json:"pretty"`             // Text formatted for reading
+	Rendered string `json:"rendered,omitempty"` // the text a browser shows, for HTML
+	// Preview is the HTML of a response sanitized to render without running
+	// any of its scripts, in a sandboxed iframe
+	Preview string `json:"preview,omitempty"`
+}
+
+// NewBodyView builds the representations of a body from its stored bytes and
+// the headers of the same message, stored as JSON. storedEncoding is the
+// content encoding already undone before the body was stored, if any.
+func NewBodyView(body string, headersJSON string, storedEncoding string) *BodyView {
+	var headers http.Header
+	json.Unmarshal([]byte(headersJSON), &headers)
+	contentType := headers.Get("Content-Type")
+
+	view := &BodyView{
+		Size:     len(body),
+		Encoding: strings.ToLower(strings.TrimSpace(headers.Get("Content-Encoding"))),
+		Format:   FormatText,
+	}
+	if body == "" {
+		view.Decoded = true
+		return view
+	}
+	if len(body) > maxViewSize {
+		view.Format = FormatBinary
+		return view
+	}
+
+	raw, decoded := []byte(body), true
+	if storedEncoding != "" {
+		view.Encoding = storedEncoding
+	} else {
+		raw, decoded = decompress(raw, view.Encoding)
+	}
+	view.Decoded = decoded
+	if !decoded {
+		view.Format = FormatBinary
+		return view
+	}
+
+	view.Format = detectFormat(contentType, raw)
+	if view.Format == FormatBinary {
+		return view
+	}
+
+	text, name := decodeText(view.Format, contentType, raw)
+	view.Charset = name
+	view.Text = string(text)
+	view.Pretty = prettyPrint(view.Format, view.Text)
+	if view.Format == FormatHTML {
+		view.Rendered = textextract.Extract(view.Text)
+	}
+	return view
+}
+
+// decodeText decodes a text body to UTF-8 from its charset: the one of the
+// content type, the one an XML prolog declares, or for HTML the one a browser
+// would pick. Other bodies are UTF-8.
+func decodeText(format, contentType string, body []byte) ([]byte, string) {
+	label := ""
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		label = params["charset"]
+	}
+	if match := xmlEncodingPattern.FindSubmatch(body); label == "" && format == FormatXML && match != nil {
+		label = string(match[1])
+	}
+
+	if label != "" {
+		if encoding, name := charset.Lookup(label); encoding != nil {
+			if text, err := encoding.NewDecoder().Bytes(body); err == nil {
+				return text, name
+			}
+			return body, name
+		}
+	}
+	if format == FormatHTML {
+		encoding, name, _ := charset.DetermineEncoding(body, contentType)
+		if text, err := encoding.NewDecoder().Bytes(body); err == nil {
+			return text, name
+		}
+		return body, name
+	}
+	return body, "utf-8"
+}
+
+// decompress undoes a content encoding. It reports false for encodings it
+// cannot undo and for corrupt bodies.
+func decompress(body []byte, encoding string) ([]byte, bool) {
+	if contentcoding.IsIdentity(encoding) {
+		return body, true
+	}
+	decoded, err := contentcoding.Decode(body, encoding, maxViewSize+1)
+	if err != nil || len(decoded) > maxViewSize {
+		return body, false
+	}
+	return decoded, true
+}
+
+// detectFormat tells the format of a body from its content type or, when
+// that is missing or generic, from the body itself
+func detectFormat(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return FormatJSON
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return FormatHTML
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return FormatXML
+	case strings.Contains(mediaType, "javascript") || mediaType == "application/ecmascript":
+		return FormatJavaScript
+	case mediaType == "text/css":
+		return FormatCSS
+	case mediaType == "application/x-www-form-urlencoded":
+		return FormatForm
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case (bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("["))) && json.Valid(trimmed):
+		return FormatJSON
+	case textextract.IsHTML("", trimmed):
+		return FormatHTML
+	case bytes.HasPrefix(trimmed, []byte("<?xml")):
+		return FormatXML
+	}
+	if strings.HasPrefix(mediaType, "text/") || utf8.Valid(body) {
+		return FormatText
+	}
+	// Legacy charsets are declared, undeclared non-UTF-8 bodies are binary
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		return FormatText
+	}
+	return FormatBinary
+}
+
+// prettyPrint formats text for reading, returning it unchanged for formats
+// without a pretty form or when it does not parse
+func prettyPrint(format, text string) string {
+	switch format {
+	case FormatJSON:
+		var buffer bytes.Buffer
+		if json.Indent(&buffer, []byte(strings.TrimSpace(text)), "", "  ") == nil {
+			return buffer.String()
+		}
+	case FormatXML:
+		if pretty, err := indentXML(text); err == nil {
+			return pretty
+		}
+	case FormatForm:
+		var lines []string
+		for _, pair := range strings.Split(text, "&") {
+			key, value, _ := strings.Cut(pair, "=")
+			if unescaped, err := url.QueryUnescape(key); err == nil {
+				key = unescaped
+			}
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
+			}
+			lines = append(lines, key+" = "+value)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return text
+}
+
+// indentXML re-indents an XML document, dropping whitespace between
+// elements. Raw tokens keep the namespace prefixes as written.
+func indentXML(text string) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	decoder.Strict = false
+	// The text is already decoded, whatever the prolog declares
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	var buffer bytes.Buffer
+	encoder := xml.NewEncoder(&buffer)
+	encoder.Indent("", "  ")
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if data, ok := token.(xml.CharData); ok && len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			element.Name = prefixedName(element.Name)
+			attributes := make([]xml.Attr, len(element.Attr))
+			for i, attribute := range element.Attr {
+				attributes[i] = xml.Attr{Name: prefixedName(attribute.Name), Value: attribute.Value}
+			}
+			element.Attr = attributes
+			token = element
+		case xml.EndElement:
+			element.Name = prefixedName(element.Name)
+			token = element
+		}
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return "", err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// prefixedName folds the namespace prefix of a raw name into its local part,
+// so the encoder writes it as it was
+func prefixedName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
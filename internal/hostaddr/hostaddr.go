@@ -0,0 +1,81 @@
+package hostaddr
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Split separates a host and its port. Bracketed IPv6 literals lose their
+// brackets, and a bare IPv6 literal is taken as a host without a port.
+func Split(hostport string) (host, port string) {
+	if name, p, err := net.SplitHostPort(hostport); err == nil {
+		return name, p
+	}
+	return strings.Trim(hostport, "[]"), ""
+}
+
+// Canonical returns the form a host is stored and compared in: lower case,
+// without brackets, port or trailing dot, and IP addresses in their shortest
+// form, with IPv4-mapped IPv6 addresses written as IPv4
+func Canonical(host string) string {
+	name, _ := Split(strings.TrimSpace(host))
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if addr, err := netip.ParseAddr(name); err == nil {
+		return addr.Unmap().String()
+	}
+	return name
+}
+
+// IsIP reports whether a host, with or without port and brackets, is an IP
+// address literal
+func IsIP(host string) bool {
+	name, _ := Split(host)
+	_, err := netip.ParseAddr(name)
+	return err == nil
+}
+
+// IsIPv6 reports whether a host, with or without port and brackets, is an
+// IPv6 address literal
+func IsIPv6(host string) bool {
+	name, _ := Split(host)
+	addr, err := netip.ParseAddr(name)
+	return err == nil && addr.Is6() && !addr.Is4In6()
+}
+
+// Literal writes a host the way it appears in a URL, with IPv6 addresses in
+// brackets
+func Literal(host string) string {
+	if IsIPv6(host) {
+		name, _ := Split(host)
+		return "[" + name + "]"
+	}
+	return host
+}
+
+// Forms returns the ways a host can be written, for matching patterns
+// against it: as given, canonical, and for IPv6 addresses bracketed, with
+// and without the port
+func Forms(hostport string) []string {
+	forms := []string{hostport}
+	add := func(form string) {
+		for _, existing := range forms {
+			if existing == form {
+				return
+			}
+		}
+		forms = append(forms, form)
+	}
+
+	name, port := Split(hostport)
+	canonical := Canonical(name)
+	add(name)
+	add(canonical)
+	if IsIPv6(canonical) {
+		add("[" + canonical + "]")
+	}
+	if port != "" {
+		add(net.JoinHostPort(canonical, port))
+	}
+	return forms
+}
@@ -0,0 +1,276 @@
+package hostheader
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the Host header probe
+const Source = "host-header-probe"
+
+// maxResponseBody bounds how much of a response is searched for the canary
+const maxResponseBody = 512 * 1024
+
+// resetPattern recognizes password reset and account recovery endpoints,
+// whose emailed links are built from the Host header
+var resetPattern = regexp.MustCompile(`(?i)(?:reset|forgot|recover|lost)[-_]?(?:password|pass|pwd)|password[-_]?(?:reset|recovery)|/forgot`)
+
+// Mutation is a way of handing the application an attacker-controlled host
+type Mutation struct {
+	Name  string
+	Apply func(req *http.Request, host, canary string)
+}
+
+// Mutations is the list of Host header variations tried on every request
+var Mutations = []Mutation{
+	{Name: "Host", Apply: func(req *http.Request, host, canary string) {
+		req.Host = canary
+	}},
+	{Name: "Host with port", Apply: func(req *http.Request, host, canary string) {
+		// Parsers that split on the last colon keep the canary as the port
+		req.Host = hostname(host) + ":" + canary
+	}},
+	{Name: "X-Forwarded-Host", Apply: func(req *http.Request, host, canary string) {
+		req.Header.Set("X-Forwarded-Host", canary)
+	}},
+	{Name: "X-Host", Apply: func(req *http.Request, host, canary string) {
+		req.Header.Set("X-Host", canary)
+	}},
+	{Name: "X-Forwarded-Server", Apply: func(req *http.Request, host, canary string) {
+		req.Header.Set("X-Forwarded-Server", canary)
+	}},
+	{Name: "X-HTTP-Host-Override", Apply: func(req *http.Request, host, canary string) {
+		req.Header.Set("X-HTTP-Host-Override", canary)
+	}},
+	{Name: "Forwarded", Apply: func(req *http.Request, host, canary string) {
+		req.Header.Set("Forwarded", "host="+canary)
+	}},
+}
+
+// Reflection is a mutation whose canary host came back in the response
+type Reflection struct {
+	Mutation string   `json:"mutation"`
+	Status   int      `json:"status"`
+	Places   []string `json:"places"` // Location header, links, body or other headers
+}
+
+// Result is what the probe learned about a single request
+type Result struct {
+	RequestID   int          `json:"requestId"`
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	Reset       bool         `json:"reset"` // the request looks like a password reset
+	Reflections []Reflection `json:"reflections"`
+	Errors      []string     `json:"errors"`
+}
+
+// Prober replays stored requests with mutated Host headers
+type Prober struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewProber creates a new Host header prober
+func NewProber(db *sql.DB, findingsClient *findings.Client) *Prober {
+	return &Prober{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			// Redirects are inspected, not followed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run probes each request in turn and records reflections as findings
+func (p *Prober) Run(ctx context.Context, requestIDs []int, progress func(done, total int)) ([]Result, error) {
+	results := []Result{}
+	for i, id := range requestIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		result, err := p.Probe(ctx, id)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+		if progress != nil {
+			progress(i+1, len(requestIDs))
+		}
+	}
+	return results, nil
+}
+
+// Probe replays one stored request once per mutation
+func (p *Prober) Probe(ctx context.Context, requestID int) (*Result, error) {
+	var method, rawURL, rawHeaders, body, encoding, domain string
+	err := p.db.QueryRow(`
+		SELECT method, COALESCE(url, ''), COALESCE(request_headers, ''), COALESCE(request_body, ''),
+			COALESCE(request_encoding, ''), COALESCE(domain, '')
+		FROM requests WHERE id = ?
+	`, requestID).Scan(&method, &rawURL, &rawHeaders, &body, &encoding, &domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load request %d: %v", requestID, err)
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid request URL %q", rawURL)
+	}
+
+	headers := http.Header{}
+	if rawHeaders != "" {
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			return nil, fmt.Errorf("failed to parse request headers: %v", err)
+		}
+	}
+	headers.Del("Content-Length")
+	// The body is stored decoded
+	if encoding != "" {
+		headers.Del("Content-Encoding")
+	}
+	host := headers.Get("Host")
+	if host == "" {
+		host = target.Host
+	}
+
+	result := &Result{
+		RequestID:   requestID,
+		Method:      method,
+		URL:         rawURL,
+		Reset:       resetPattern.MatchString(target.Path) || resetPattern.MatchString(target.RawQuery) || resetPattern.MatchString(body),
+		Reflections: []Reflection{},
+		Errors:      []string{},
+	}
+
+	for _, mutation := range Mutations {
+		if ctx.Err() != nil {
+			break
+		}
+		canary := "prokzee" + probe.Token() + ".example"
+
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, strings.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to build request: %v", err)
+		}
+		req.Header = headers.Clone()
+		req.Header.Del("Host")
+		req.Host = host
+		// Ask for the body as is, so it can be searched
+		req.Header.Del("Accept-Encoding")
+		mutation.Apply(req, host, canary)
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", mutation.Name, err))
+			continue
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+		resp.Body.Close()
+
+		if places := reflections(resp.Header, respBody, canary); len(places) > 0 {
+			result.Reflections = append(result.Reflections, Reflection{
+				Mutation: mutation.Name,
+				Status:   resp.StatusCode,
+				Places:   places,
+			})
+		}
+	}
+
+	p.record(domain, result)
+	return result, nil
+}
+
+// record stores the reflections of a request as findings, one per mutation
+func (p *Prober) record(host string, result *Result) {
+	for _, reflection := range result.Reflections {
+		severity := findings.SeverityLow
+		for _, place := range reflection.Places {
+			if place == "Location header" || place == "links" {
+				severity = findings.SeverityMedium
+			}
+		}
+		if result.Reset {
+			severity = findings.SeverityHigh
+		}
+
+		detail := fmt.Sprintf("A %s value of the tester's choosing was reflected in the %s (status %d)",
+			reflection.Mutation, strings.Join(reflection.Places, ", "), reflection.Status)
+		if result.Reset {
+			detail += "\nThe request looks like a password reset; emailed reset links may point to the attacker's host"
+		}
+		err := p.findings.AddFinding(findings.Finding{
+			Source:    Source,
+			Host:      host,
+			URL:       result.URL,
+			RequestID: result.RequestID,
+			Title:     "Host header poisoning via " + reflection.Mutation,
+			Severity:  severity,
+			Detail:    detail,
+			Key:       result.Method + "|" + result.URL + "|" + reflection.Mutation,
+		})
+		if err != nil {
+			log.Printf("Failed to record Host header finding: %v", err)
+		}
+	}
+}
+
+// linkPattern finds the URLs of links, forms, scripts and meta refreshes
+var linkPattern = regexp.MustCompile(`(?i)(?:href|src|action|content|url)\s*=\s*["']?[^"'\s>]*`)
+
+// reflections lists where a canary host shows up in a response
+func reflections(headers http.Header, body []byte, canary string) []string {
+	var places []string
+	if strings.Contains(headers.Get("Location"), canary) {
+		places = append(places, "Location header")
+	}
+	for name, values := range headers {
+		if name == "Location" {
+			continue
+		}
+		if strings.Contains(strings.Join(values, "\n"), canary) {
+			places = append(places, name+" header")
+		}
+	}
+	if bytes.Contains(body, []byte(canary)) {
+		inLink := false
+		for _, link := range linkPattern.FindAll(body, -1) {
+			if bytes.Contains(link, []byte(canary)) {
+				inLink = true
+				break
+			}
+		}
+		if inLink {
+			places = append(places, "links")
+		} else {
+			places = append(places, "body")
+		}
+	}
+	return places
+}
+
+// hostname strips the port from a Host header value
+func hostname(host string) string {
+	if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+		return host[:i]
+	}
+	return host
+}
@@ -0,0 +1,111 @@
+package hostinfo
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Categories of host information
+const (
+	CategoryWAF     = "waf"
+	CategoryCDN     = "cdn"
+	CategoryTech    = "tech"
+	CategoryFavicon = "favicon"
+)
+
+// Entry is one fact learned about a host, such as a WAF or a framework
+type Entry struct {
+	Host      string `json:"host"`
+	Category  string `json:"category"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Evidence  string `json:"evidence"`
+	Source    string `json:"source"` // "passive" or "active"
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// Client represents the host info client
+type Client struct {
+	db *sql.DB
+}
+
+// NewClient creates a new host info client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure host_info table exists: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the host_info table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	log.Printf("Ensuring host_info table exists...")
+	query := `
+	CREATE TABLE IF NOT EXISTS host_info (
+		host TEXT NOT NULL,
+		category TEXT NOT NULL,
+		name TEXT NOT NULL,
+		version TEXT DEFAULT '',
+		evidence TEXT DEFAULT '',
+		source TEXT DEFAULT 'passive',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (host, category, name)
+	)`
+
+	if _, err := c.db.Exec(query); err != nil {
+		log.Printf("Error creating host_info table: %v", err)
+		return fmt.Errorf("failed to create host_info table: %v", err)
+	}
+	return nil
+}
+
+// Upsert stores an entry, replacing an earlier entry with the same host,
+// category and name. A known version is never overwritten by an empty one.
+func (c *Client) Upsert(entry Entry) error {
+	_, err := c.db.Exec(`
+		INSERT INTO host_info (host, category, name, version, evidence, source)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(host, category, name) DO UPDATE SET
+			version = CASE WHEN excluded.version != '' THEN excluded.version ELSE host_info.version END,
+			evidence = excluded.evidence,
+			source = excluded.source,
+			updated_at = CURRENT_TIMESTAMP
+	`, entry.Host, entry.Category, entry.Name, entry.Version, entry.Evidence, entry.Source)
+	if err != nil {
+		return fmt.Errorf("failed to store host info: %v", err)
+	}
+	return nil
+}
+
+// GetEntries returns the entries of a host, or of all hosts when host is empty
+func (c *Client) GetEntries(host string) ([]Entry, error) {
+	query := `
+		SELECT host, category, name, COALESCE(version, ''), COALESCE(evidence, ''), COALESCE(source, ''), COALESCE(updated_at, '')
+		FROM host_info`
+	args := []interface{}{}
+	if host != "" {
+		query += " WHERE host = ?"
+		args = append(args, host)
+	}
+	query += " ORDER BY host, category, name"
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query host info: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Scan(&entry.Host, &entry.Category, &entry.Name, &entry.Version, &entry.Evidence, &entry.Source, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan host info: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,257 @@
+package htmlpreview
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// policy is the Content-Security-Policy the preview carries on top of the
+// sanitizing, in case anything slips through: no scripts, frames or form
+// submissions, only images, styles, fonts and media
+const policy = "default-src 'none'; script-src 'none'; object-src 'none'; frame-src 'none'; " +
+	"form-action 'none'; base-uri 'none'; img-src http: https: data:; style-src 'unsafe-inline' http: https:; " +
+	"font-src http: https: data:; media-src http: https: data:"
+
+// removed are elements dropped with their content: they run code, embed other
+// documents or change how the rest of the page loads
+var removed = map[string]bool{
+	"script": true, "noscript": true, "template": true, "iframe": true, "frame": true, "frameset": true,
+	"object": true, "embed": true, "applet": true, "base": true, "portal": true, "param": true,
+	// SVG animations can set an href to a javascript: URL
+	"animate": true, "set": true, "handler": true,
+}
+
+// linkAttributes navigate when clicked, resourceAttributes load a resource
+var (
+	linkAttributes     = map[string]bool{"href": true}
+	resourceAttributes = map[string]bool{"src": true, "poster": true, "background": true, "lowsrc": true, "dynsrc": true}
+	// droppedAttributes act on their own or submit elsewhere
+	droppedAttributes = map[string]bool{
+		"srcdoc": true, "formaction": true, "formmethod": true, "formtarget": true, "action": true, "target": true,
+		"ping": true, "http-equiv": true, "codebase": true, "manifest": true, "cite": true, "longdesc": true,
+		"autofocus": true,
+	}
+	// resourceLinks are the rel values of link elements kept, other links
+	// preload, prefetch or import documents
+	resourceLinks = map[string]bool{"stylesheet": true, "icon": true}
+)
+
+// Sanitize returns a copy of an HTML document that is safe to render inside
+// the app: scripts, frames, plugins and event handlers are removed, forms
+// cannot submit, links do not navigate and keep their target in data-href,
+// and resources are loaded from absolute URLs resolved against pageURL. It
+// is meant for an iframe with the sandbox attribute set, which it does not
+// replace.
+func Sanitize(document, pageURL string) string {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		// The parser does not fail on malformed markup, only on read errors
+		return ""
+	}
+	base, _ := url.Parse(pageURL)
+	sanitizeChildren(root, base)
+	addPolicy(root)
+
+	var out bytes.Buffer
+	if err := html.Render(&out, root); err != nil {
+		return ""
+	}
+	return out.String()
+}
+
+// sanitizeChildren sanitizes the children of a node, removing the unsafe ones
+func sanitizeChildren(node *html.Node, base *url.URL) {
+	for child := node.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.CommentNode:
+			// Conditional comments of old browsers hold markup
+			node.RemoveChild(child)
+		case html.ElementNode:
+			if keep(child) {
+				sanitizeElement(child, base)
+				sanitizeChildren(child, base)
+			} else {
+				node.RemoveChild(child)
+			}
+		default:
+			sanitizeChildren(child, base)
+		}
+		child = next
+	}
+}
+
+// keep reports whether an element stays in the preview
+func keep(element *html.Node) bool {
+	name := strings.ToLower(element.Data)
+	if removed[name] {
+		return false
+	}
+	switch name {
+	case "meta":
+		// Refreshes, cookies and policies of the page
+		return attribute(element, "http-equiv") == ""
+	case "link":
+		for _, rel := range strings.Fields(strings.ToLower(attribute(element, "rel"))) {
+			if resourceLinks[rel] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// sanitizeElement rewrites the attributes of an element
+func sanitizeElement(element *html.Node, base *url.URL) {
+	name := strings.ToLower(element.Data)
+	attrs := make([]html.Attribute, 0, len(element.Attr))
+	for _, attr := range element.Attr {
+		key := strings.ToLower(attr.Key)
+		switch {
+		case strings.HasPrefix(key, "on"), droppedAttributes[key]:
+			continue
+		case key == "style":
+			if !safeStyle(attr.Val) {
+				continue
+			}
+		case key == "srcset":
+			attr.Val = rewriteSrcset(attr.Val, base)
+			if attr.Val == "" {
+				continue
+			}
+		case linkAttributes[key] && name != "link" && name != "image" && name != "use":
+			// Anchors and areas, and SVG links through xlink:href
+			if target, ok := resolve(attr.Val, base); ok {
+				attrs = append(attrs, html.Attribute{Key: "data-href", Val: target})
+				if attribute(element, "title") == "" {
+					attrs = append(attrs, html.Attribute{Key: "title", Val: target})
+				}
+			}
+			continue
+		case linkAttributes[key], resourceAttributes[key]:
+			target, ok := resolve(attr.Val, base)
+			if !ok {
+				continue
+			}
+			attr.Val = target
+		}
+		attrs = append(attrs, attr)
+	}
+	element.Attr = attrs
+
+	// Buttons of a form only look like they submit
+	switch {
+	case name == "button" && !strings.EqualFold(attribute(element, "type"), "reset"):
+		setAttribute(element, "type", "button")
+	case name == "input":
+		switch strings.ToLower(attribute(element, "type")) {
+		case "submit", "image":
+			setAttribute(element, "type", "button")
+		}
+	}
+}
+
+// resolve returns the absolute URL of a link or resource, reporting false
+// for schemes that run code or that are unknown
+func resolve(value string, base *url.URL) (string, bool) {
+	value = strings.TrimSpace(value)
+	target, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		target = base.ResolveReference(target)
+	}
+	// Relative URLs of a page without URL have no scheme and are dropped
+	switch strings.ToLower(target.Scheme) {
+	case "http", "https", "data", "mailto", "tel":
+		return target.String(), true
+	}
+	return "", false
+}
+
+// rewriteSrcset resolves the candidates of a srcset, dropping unsafe ones
+func rewriteSrcset(value string, base *url.URL) string {
+	var candidates []string
+	for _, candidate := range strings.Split(value, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		target, ok := resolve(fields[0], base)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, strings.Join(append([]string{target}, fields[1:]...), " "))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// safeStyle reports whether an inline style is free of the constructs old
+// browsers run as code
+func safeStyle(style string) bool {
+	lower := strings.ToLower(style)
+	for _, pattern := range []string{"expression(", "javascript:", "vbscript:", "behavior:", "-moz-binding"} {
+		if strings.Contains(lower, pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// addPolicy puts the Content-Security-Policy of the preview first in its head
+func addPolicy(root *html.Node) {
+	head := find(root, atom.Head)
+	if head == nil {
+		return
+	}
+	meta := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Meta,
+		Data:     "meta",
+		Attr: []html.Attribute{
+			{Key: "http-equiv", Val: "Content-Security-Policy"},
+			{Key: "content", Val: policy},
+		},
+	}
+	head.InsertBefore(meta, head.FirstChild)
+}
+
+// find returns the first element of a kind in document order
+func find(node *html.Node, kind atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == kind {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := find(child, kind); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// attribute returns the value of an attribute, "" when it is missing
+func attribute(element *html.Node, key string) string {
+	for _, attr := range element.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// setAttribute sets an attribute, replacing its value when present
+func setAttribute(element *html.Node, key, value string) {
+	for i, attr := range element.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			element.Attr[i].Val = value
+			return
+		}
+	}
+	element.Attr = append(element.Attr, html.Attribute{Key: key, Val: value})
+}
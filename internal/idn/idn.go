@@ -0,0 +1,163 @@
+package idn
+
+import (
+	"net"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// Info describes how a host name is shown and whether it imitates another
+type Info struct {
+	Display   string `json:"display,omitempty"`   // Unicode form of a punycode host, "" when the same
+	Lookalike string `json:"lookalike,omitempty"` // reference host it imitates
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Display returns the Unicode form of a host whose labels are punycode, or
+// the host itself. The port, if any, is kept.
+func Display(host string) string {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, ""
+	}
+	if !strings.Contains(strings.ToLower(name), "xn--") {
+		return host
+	}
+	decoded, err := idna.Display.ToUnicode(name)
+	if err != nil || decoded == "" {
+		return host
+	}
+	if port != "" {
+		return net.JoinHostPort(decoded, port)
+	}
+	return decoded
+}
+
+// ToASCII returns the punycode form of a Unicode host, or the host itself
+func ToASCII(host string) string {
+	encoded, err := idna.Lookup.ToASCII(host)
+	if err != nil || encoded == "" {
+		return host
+	}
+	return encoded
+}
+
+// Analyze decodes a host for display and reports whether it imitates one of
+// the reference hosts, such as the in-scope ones, or mixes scripts within a
+// label
+func Analyze(host string, references []string) Info {
+	var info Info
+	if display := Display(host); display != host {
+		info.Display = display
+	}
+	name := strings.ToLower(strings.TrimSuffix(hostname(Display(host)), "."))
+	if name == "" {
+		return info
+	}
+
+	skeleton := Skeleton(name)
+	for _, reference := range references {
+		reference = strings.ToLower(strings.TrimSuffix(hostname(Display(reference)), "."))
+		if reference == "" || name == reference || strings.HasSuffix(name, "."+reference) {
+			continue
+		}
+		target := Skeleton(reference)
+		if skeleton == target || strings.HasSuffix(skeleton, "."+target) {
+			info.Lookalike = reference
+			if isASCII(name) {
+				info.Reason = "Looks like " + reference + " with confusable ASCII characters"
+			} else {
+				info.Reason = "Homograph of " + reference
+			}
+			return info
+		}
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if mixedScripts(label) {
+			info.Reason = "Label " + label + " mixes scripts"
+			break
+		}
+	}
+	return info
+}
+
+// Skeleton folds a host name to what it looks like, mapping look-alike
+// characters of other scripts and confusable ASCII sequences to one form, so
+// two names that render alike get the same skeleton
+func Skeleton(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if folded, ok := confusables[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		// Fullwidth forms render like ASCII
+		if r >= '\uff01' && r <= '\uff5e' {
+			b.WriteRune(unicode.ToLower(r - '\uff01' + '!'))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return asciiConfusables.Replace(b.String())
+}
+
+// hostname strips the port of a host
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+// isASCII reports whether s holds only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// mixedScripts reports whether the letters of a label belong to more than one
+// of the Latin, Cyrillic and Greek scripts, the usual mix of homographs
+func mixedScripts(label string) bool {
+	scripts := make(map[string]bool)
+	for _, r := range label {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"] = true
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["cyrillic"] = true
+		case unicode.Is(unicode.Greek, r):
+			scripts["greek"] = true
+		}
+	}
+	return len(scripts) > 1
+}
+
+// asciiConfusables folds ASCII sequences that render alike in most fonts
+var asciiConfusables = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d", "0", "o", "1", "l")
+
+// confusables maps the lower case characters that look like a Latin letter
+// to it
+var confusables = map[rune]string{
+	// Cyrillic
+	'\u0430': "a", '\u0432': "b", '\u0441': "c", '\u0501': "d", '\u0435': "e", '\u04bb': "h", '\u0456': "i",
+	'\u0458': "j", '\u043a': "k", '\u04cf': "l", '\u043c': "m", '\u043d': "h", '\u043e': "o", '\u0440': "p",
+	'\u051b': "q", '\u0455': "s", '\u0442': "t", '\u0443': "y", '\u0445': "x", '\u0461': "w", '\u051d': "w",
+	'\u0457': "i", '\u0451': "e", '\u04af': "y", '\u0491': "r",
+	// Greek
+	'\u03b1': "a", '\u03b2': "b", '\u03b5': "e", '\u03b9': "i", '\u03ba': "k", '\u03bd': "v", '\u03bf': "o",
+	'\u03c1': "p", '\u03c4': "t", '\u03c5': "u", '\u03c7': "x", '\u03c9': "w",
+	// Latin, Armenian and accented look-alikes
+	'\u0131': "i", '\u0269': "i", '\u0261': "g", '\u017f': "f", '\u0251': "a", '\u0578': "n", '\u057d': "u",
+	'à': "a", 'á': "a", 'â': "a", 'ä': "a", 'å': "a", 'ç': "c", 'è': "e",
+	'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ò': "o", 'ó': "o", 'ô': "o", 'ö': "o", 'ø': "o", 'ù': "u", 'ú': "u",
+	'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y", 'ā': "a", 'ē': "e", 'ī': "i",
+	'ō': "o", 'ū': "u", 'ạ': "a", 'ọ': "o", 'ș': "s", 'ț': "t",
+}
@@ -0,0 +1,275 @@
+package instancelock
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// heartbeatInterval is how often the holder of a project refreshes its lock
+const heartbeatInterval = 10 * time.Second
+
+// staleAfter is how old a heartbeat gets before the lock counts as abandoned,
+// such as by an instance that crashed
+const staleAfter = 3 * heartbeatInterval
+
+// guardWait bounds how long an instance waits for another one to finish
+// reading and writing the lock file
+const guardWait = 5 * time.Second
+
+// guardStale is how old a guard file gets before it counts as left by an
+// instance that died while holding it
+const guardStale = 10 * time.Second
+
+// Owner describes the instance holding a project
+type Owner struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"startedAt"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// live reports whether the owner still holds its project for the instance
+// self. A lock left by the process of self is never live.
+func (o Owner) live(self Owner) bool {
+	if o.Host == self.Host && o.PID == self.PID {
+		return false
+	}
+	return time.Since(o.Heartbeat) < staleAfter
+}
+
+// HeldError is returned when another running instance holds the project
+type HeldError struct {
+	Owner Owner
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("project is open in another instance (process %d on %s, since %s)",
+		e.Owner.PID, e.Owner.Host, e.Owner.StartedAt.Local().Format(time.DateTime))
+}
+
+// Lock is the hold of this instance on a project database, kept in a file
+// next to it and refreshed until released
+type Lock struct {
+	dbPath string
+	owner  Owner
+
+	mu       sync.Mutex
+	onLost   func(Owner)
+	done     chan struct{}
+	released bool
+}
+
+// pathFor returns the lock file of a project database
+func pathFor(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+// guardFor returns the guard file of a project database
+func guardFor(dbPath string) string {
+	return pathFor(dbPath) + ".guard"
+}
+
+// guard creates the guard file of a project database, which a single
+// instance can hold at a time, so reading the lock file and writing it is
+// one step. It waits for another instance holding the guard, and removes a
+// guard left behind by one that died. The returned function releases it.
+func guard(dbPath string) (func(), error) {
+	path := guardFor(dbPath)
+	deadline := time.Now().Add(guardWait)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > guardStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("another instance is opening the project")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Acquire locks a project database for this instance. It fails with a
+// HeldError while another running instance holds it, unless takeover is set,
+// in which case that instance finds out on its next heartbeat.
+func Acquire(dbPath string, takeover bool) (*Lock, error) {
+	release, err := guard(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock project: %v", err)
+	}
+	defer release()
+
+	// Checked under the guard, so instances starting together cannot both
+	// find the project free
+	if !takeover {
+		if holder := HeldBy(dbPath); holder != nil {
+			return nil, &HeldError{Owner: *holder}
+		}
+	}
+
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	owner := Owner{ID: uuid.New().String(), PID: os.Getpid(), Host: host, StartedAt: now, Heartbeat: now}
+	if err := write(pathFor(dbPath), owner); err != nil {
+		return nil, fmt.Errorf("failed to write lock file: %v", err)
+	}
+	lock := &Lock{dbPath: dbPath, owner: owner, done: make(chan struct{})}
+	go lock.heartbeat()
+	return lock, nil
+}
+
+// Read returns the instance holding a project database, live or not. It fails
+// with an error satisfying os.IsNotExist when no instance ever locked it.
+func Read(dbPath string) (*Owner, error) {
+	data, err := os.ReadFile(pathFor(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	var owner Owner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, fmt.Errorf("invalid lock file: %v", err)
+	}
+	return &owner, nil
+}
+
+// HeldBy returns the other running instance holding a project database, nil
+// when there is none
+func HeldBy(dbPath string) *Owner {
+	current, err := Read(dbPath)
+	if err != nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	if !current.live(Owner{PID: os.Getpid(), Host: host}) {
+		return nil
+	}
+	return current
+}
+
+// write replaces the lock file in one step, so it is never read half written
+func write(path string, owner Owner) error {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Owner returns this instance as recorded in the lock
+func (l *Lock) Owner() Owner {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.owner
+}
+
+// OnLost sets what is called, once, when another instance takes the project
+// over
+func (l *Lock) OnLost(fn func(taker Owner)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onLost = fn
+}
+
+// heartbeat refreshes the lock file until the lock is released or taken over
+func (l *Lock) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		// A beat is skipped while another instance holds the guard
+		release, err := guard(l.dbPath)
+		if err != nil {
+			continue
+		}
+		l.mu.Lock()
+		if l.released {
+			l.mu.Unlock()
+			release()
+			return
+		}
+		current, err := Read(l.dbPath)
+		if err == nil && current.ID != l.owner.ID {
+			l.released = true
+			onLost := l.onLost
+			l.mu.Unlock()
+			release()
+			log.Printf("Warning: project taken over by process %d on %s", current.PID, current.Host)
+			if onLost != nil {
+				onLost(*current)
+			}
+			return
+		}
+		// A deleted or damaged lock file is written again
+		l.owner.Heartbeat = time.Now().UTC()
+		if err := write(pathFor(l.dbPath), l.owner); err != nil {
+			log.Printf("Warning: failed to refresh project lock: %v", err)
+		}
+		l.mu.Unlock()
+		release()
+	}
+}
+
+// Release gives the project up. The lock file is left alone when another
+// instance took the project over.
+func (l *Lock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return nil
+	}
+	l.released = true
+	close(l.done)
+
+	// Without the guard, the lock file of an instance taking over at this
+	// moment could be removed
+	release, err := guard(l.dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove lock file: %v", err)
+	}
+	defer release()
+	current, err := Read(l.dbPath)
+	if err != nil || current.ID != l.owner.ID {
+		return nil
+	}
+	if err := os.Remove(pathFor(l.dbPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package intercept
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// maxDiffLines bounds the size of requests diffed line by line, larger ones
+// are shown as fully replaced
+const maxDiffLines = 2000
+
+// Edit is an intercepted request the user modified before forwarding it
+type Edit struct {
+	ID          int    `json:"id"`
+	InterceptID string `json:"interceptId"`
+	URL         string `json:"url"`
+	Original    string `json:"original"`
+	Edited      string `json:"edited"`
+	Diff        string `json:"diff"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// Client stores the history of modified intercepted requests
+type Client struct {
+	db       *sql.DB
+	doNotLog func(host, path string) bool
+}
+
+// NewClient creates a new intercept edit history client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure intercept_edits table exists: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the intercept_edits table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS intercept_edits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		intercept_id TEXT NOT NULL,
+		url TEXT DEFAULT '',
+		original_request TEXT NOT NULL,
+		edited_request TEXT NOT NULL,
+		diff TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := c.db.Exec(query); err != nil {
+		log.Printf("Error creating intercept_edits table: %v", err)
+		return fmt.Errorf("failed to create intercept_edits table: %v", err)
+	}
+	return nil
+}
+
+// SetDoNotLog sets the check for requests whose bodies must not be persisted,
+// their edits are stored with headers only
+func (c *Client) SetDoNotLog(doNotLog func(host, path string) bool) {
+	c.doNotLog = doNotLog
+}
+
+// RecordEdit stores the original and edited version of an intercepted request
+// along with a line diff of the two
+func (c *Client) RecordEdit(interceptID, rawURL, original, edited string) error {
+	if u, err := url.Parse(rawURL); err == nil && c.doNotLog != nil && c.doNotLog(u.Hostname(), u.Path) {
+		original, edited = headersOnly(original), headersOnly(edited)
+	}
+
+	_, err := c.db.Exec(`
+		INSERT INTO intercept_edits (intercept_id, url, original_request, edited_request, diff)
+		VALUES (?, ?, ?, ?, ?)
+	`, interceptID, rawURL, original, edited, Diff(original, edited))
+	if err != nil {
+		return fmt.Errorf("failed to store intercept edit: %v", err)
+	}
+	return nil
+}
+
+// GetEdits returns the most recent edits, newest first
+func (c *Client) GetEdits(limit int) ([]Edit, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := c.db.Query(`
+		SELECT id, intercept_id, COALESCE(url, ''), original_request, edited_request, diff, COALESCE(created_at, '')
+		FROM intercept_edits ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query intercept edits: %v", err)
+	}
+	defer rows.Close()
+
+	edits := []Edit{}
+	for rows.Next() {
+		var edit Edit
+		if err := rows.Scan(&edit.ID, &edit.InterceptID, &edit.URL, &edit.Original, &edit.Edited, &edit.Diff, &edit.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan intercept edit: %v", err)
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
+}
+
+// DeleteEdit removes an edit from the history
+func (c *Client) DeleteEdit(id int) error {
+	if _, err := c.db.Exec("DELETE FROM intercept_edits WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete intercept edit: %v", err)
+	}
+	return nil
+}
+
+// headersOnly drops the body of a raw request
+func headersOnly(raw string) string {
+	if i := strings.Index(raw, "\n\n"); i >= 0 {
+		return raw[:i+2]
+	}
+	return raw
+}
+
+// Diff returns a line diff of two texts. Every line starts with "  " when it
+// is unchanged, "- " when it was removed and "+ " when it was added.
+func Diff(original, edited string) string {
+	a := strings.Split(original, "\n")
+	b := strings.Split(edited, "\n")
+
+	var out []string
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			out = append(out, "- "+line)
+		}
+		for _, line := range b {
+			out = append(out, "+ "+line)
+		}
+		return strings.Join(out, "\n")
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return strings.Join(out, "\n")
+}
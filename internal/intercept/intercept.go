@@ -2,12 +2,13 @@ package intercept
 
 import (
 	"context"
-	"crypto/tls"
 	"io"
 	"log"
 	"net/http"
 	"strings"
 
+	"prokzee/internal/upstream"
+
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -68,17 +69,8 @@ func (i *Interceptor) ForwardRequest(data map[string]interface{}) {
 		}
 	}
 
-	// Create a custom transport based on the requested protocol version
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-
-	// Disable HTTP/2 if HTTP/1.1 is requested
-	if protocolVersion == "HTTP/1.1" {
-		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
-	}
+	// Negotiate h2 with the server only when HTTP/2 is requested
+	transport := upstream.NewHTTPTransport(upstream.IsHTTP2(protocolVersion))
 
 	client := &http.Client{
 		Transport: transport,
@@ -0,0 +1,311 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "prokzee/internal/metrics"
+	models "prokzee/internal/models"
+)
+
+const (
+	// DefaultStepDuration is how long each concurrency level is sustained
+	DefaultStepDuration = 3 * time.Second
+	// DefaultMaxConcurrency is the highest number of parallel clients tried
+	DefaultMaxConcurrency = 64
+	// MaxErrorRate is the share of failed requests a sustainable level may have
+	MaxErrorRate = 0.01
+	// MaxP95 is the slowest 95th percentile latency a sustainable level may have
+	MaxP95 = 2 * time.Second
+	// responseSize is the body size of the synthetic target responses
+	responseSize = 4096
+)
+
+// Config describes a load test run
+type Config struct {
+	ProxyHost      string // 127.0.0.1 when empty
+	ProxyPort      string
+	StepDuration   time.Duration
+	MaxConcurrency int
+}
+
+// Step is the outcome of one concurrency level
+type Step struct {
+	Concurrency int     `json:"concurrency"`
+	Requests    int     `json:"requests"`
+	Errors      int     `json:"errors"`
+	RPS         float64 `json:"rps"`
+	P50Ms       float64 `json:"p50Ms"`
+	P95Ms       float64 `json:"p95Ms"`
+	Sustainable bool    `json:"sustainable"`
+}
+
+// StageTiming is the average time a request spent in one pipeline stage
+type StageTiming struct {
+	Name  string  `json:"name"`
+	AvgMs float64 `json:"avgMs"`
+	// Share is the fraction of the client latency for inline stages, and the
+	// utilization of the single database writer for storage
+	Share float64 `json:"share"`
+}
+
+// Report is the result of a load test
+type Report struct {
+	TargetURL      string        `json:"targetUrl"`
+	Steps          []Step        `json:"steps"`
+	MaxRPS         float64       `json:"maxRps"`
+	MaxConcurrency int           `json:"maxConcurrency"`
+	Bottleneck     string        `json:"bottleneck"`
+	Stages         []StageTiming `json:"stages"`
+}
+
+// Run starts a local target server and sends synthetic traffic to it through
+// the proxy at doubling concurrency levels until latency or errors degrade or
+// throughput stops growing. The bottleneck is derived from the proxy's own
+// stage metrics at the best level. progress is called after every level.
+func Run(ctx context.Context, config Config, collector *metrics.Collector, progress func(Step)) (*Report, error) {
+	if config.StepDuration <= 0 {
+		config.StepDuration = DefaultStepDuration
+	}
+	if config.MaxConcurrency <= 0 {
+		config.MaxConcurrency = DefaultMaxConcurrency
+	}
+
+	target, err := startTarget()
+	if err != nil {
+		return nil, err
+	}
+	defer target.close()
+
+	proxyHost := config.ProxyHost
+	if proxyHost == "" {
+		proxyHost = "127.0.0.1"
+	}
+	proxyURL := &url.URL{Scheme: "http", Host: net.JoinHostPort(proxyHost, config.ProxyPort)}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyURL(proxyURL),
+			MaxIdleConnsPerHost: config.MaxConcurrency,
+		},
+	}
+
+	report := &Report{TargetURL: target.url}
+	var best *stepResult
+	for concurrency := 1; concurrency <= config.MaxConcurrency; concurrency *= 2 {
+		if ctx.Err() != nil {
+			break
+		}
+		result := runStep(ctx, client, target, concurrency, config.StepDuration, collector)
+		report.Steps = append(report.Steps, result.step)
+		if progress != nil {
+			progress(result.step)
+		}
+
+		if !result.step.Sustainable {
+			break
+		}
+		improved := best == nil || result.step.RPS > best.step.RPS*1.05
+		if best == nil || result.step.RPS > best.step.RPS {
+			best = result
+		}
+		if !improved {
+			break
+		}
+	}
+
+	if best == nil {
+		return report, fmt.Errorf("no sustainable load level, the proxy failed at a single client")
+	}
+	report.MaxRPS = best.step.RPS
+	report.MaxConcurrency = best.step.Concurrency
+	report.Stages, report.Bottleneck = bottleneck(best)
+	return report, nil
+}
+
+// stepResult keeps what is needed to attribute time to pipeline stages
+type stepResult struct {
+	step     Step
+	latency  time.Duration // average client latency
+	upstream time.Duration // average time spent in the target server
+	stages   map[string]metrics.StageStat
+	elapsed  time.Duration
+}
+
+func runStep(ctx context.Context, client *http.Client, target *target, concurrency int, duration time.Duration, collector *metrics.Collector) *stepResult {
+	before := collector.Snapshot()
+	upstreamBefore := target.stats()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	errors := 0
+
+	stepCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for n := 0; stepCtx.Err() == nil; n++ {
+				sent := time.Now()
+				err := send(stepCtx, client, fmt.Sprintf("%s/load/%d/%d", target.url, worker, n))
+				if stepCtx.Err() != nil {
+					return // requests cut off by the end of the step are not counted
+				}
+				mu.Lock()
+				if err != nil {
+					errors++
+				} else {
+					latencies = append(latencies, time.Since(sent))
+				}
+				mu.Unlock()
+			}
+		}(worker)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := &stepResult{elapsed: elapsed, stages: map[string]metrics.StageStat{}}
+	for stage, stat := range collector.Snapshot() {
+		result.stages[stage] = stat.Sub(before[stage])
+	}
+	result.upstream = target.stats().Sub(upstreamBefore).Average()
+
+	step := Step{Concurrency: concurrency, Requests: len(latencies) + errors, Errors: errors}
+	step.RPS = float64(len(latencies)) / elapsed.Seconds()
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		var total time.Duration
+		for _, latency := range latencies {
+			total += latency
+		}
+		result.latency = total / time.Duration(len(latencies))
+		step.P50Ms = ms(latencies[len(latencies)/2])
+		step.P95Ms = ms(latencies[len(latencies)*95/100])
+	}
+	step.Sustainable = step.Requests > 0 &&
+		float64(errors)/float64(step.Requests) <= MaxErrorRate &&
+		step.P95Ms <= ms(MaxP95)
+	result.step = step
+	return result
+}
+
+func send(ctx context.Context, client *http.Client, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(models.LoadTestHeader, "1")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// bottleneck attributes the client latency to the proxy stages. Storage runs
+// after the response is returned but on a single writer, so it is the
+// bottleneck once that writer is nearly always busy.
+func bottleneck(best *stepResult) ([]StageTiming, string) {
+	latency := best.latency.Seconds()
+	share := func(d time.Duration) float64 {
+		if latency == 0 {
+			return 0
+		}
+		return d.Seconds() / latency
+	}
+
+	request := best.stages["request"].Average()
+	response := best.stages["response"].Average()
+	db := best.stages[metrics.StageDBWrite]
+	overhead := best.latency - request - response - best.upstream
+	if overhead < 0 {
+		overhead = 0
+	}
+
+	stages := []StageTiming{
+		{Name: "request handler", AvgMs: ms(request), Share: share(request)},
+		{Name: "response handler", AvgMs: ms(response), Share: share(response)},
+		{Name: "upstream", AvgMs: ms(best.upstream), Share: share(best.upstream)},
+		{Name: "proxy transport", AvgMs: ms(overhead), Share: share(overhead)},
+	}
+	storage := StageTiming{Name: "storage", AvgMs: ms(db.Average())}
+	if best.elapsed > 0 {
+		storage.Share = db.Seconds / best.elapsed.Seconds()
+	}
+
+	if storage.Share >= 0.8 {
+		return append(stages, storage), storage.Name
+	}
+	slowest := stages[0]
+	for _, stage := range stages[1:] {
+		if stage.Share > slowest.Share {
+			slowest = stage
+		}
+	}
+	return append(stages, storage), slowest.Name
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// target is the local server the synthetic traffic is sent to
+type target struct {
+	url    string
+	server *http.Server
+
+	mu      sync.Mutex
+	handled metrics.StageStat
+}
+
+func startTarget() (*target, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start load test target: %v", err)
+	}
+
+	t := &target{url: "http://" + listener.Addr().String()}
+	body := []byte(strings.Repeat("x", responseSize))
+	t.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(body)
+			t.mu.Lock()
+			t.handled.Count++
+			t.handled.Seconds += time.Since(start).Seconds()
+			t.mu.Unlock()
+		}),
+	}
+	go t.server.Serve(listener)
+	return t, nil
+}
+
+func (t *target) stats() metrics.StageStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.handled
+}
+
+func (t *target) close() {
+	t.server.Close()
+}
@@ -0,0 +1,331 @@
+package maplocal
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Rule answers the requests whose URL matches its pattern with a canned
+// response, from inline content or a local file, without contacting the server
+type Rule struct {
+	ID         int    `json:"id"`
+	RuleName   string `json:"rule_name"`
+	Method     string `json:"method"`      // empty matches any method
+	URLPattern string `json:"url_pattern"` // full URL, * matches any run of characters
+	StatusCode int    `json:"status_code"`
+	Headers    string `json:"headers"` // one "Name: value" per line
+	Body       string `json:"body"`
+	FilePath   string `json:"file_path"` // read on every match, takes precedence over Body
+	Enabled    bool   `json:"enabled"`
+}
+
+// Client represents the map local client
+type Client struct {
+	db       *sql.DB
+	mu       sync.RWMutex
+	rules    []Rule
+	patterns map[int]*regexp.Regexp
+}
+
+// NewClient creates a new map local client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{
+		db: db,
+	}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure map_local_rules table exists: %v", err)
+	}
+
+	if err := client.loadRules(); err != nil {
+		return nil, fmt.Errorf("failed to load map local rules: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the map_local_rules table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS map_local_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_name TEXT,
+		method TEXT DEFAULT '',
+		url_pattern TEXT NOT NULL,
+		status_code INTEGER DEFAULT 200,
+		headers TEXT DEFAULT '',
+		body TEXT DEFAULT '',
+		file_path TEXT DEFAULT '',
+		enabled BOOLEAN
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create map_local_rules table: %v", err)
+	}
+	return nil
+}
+
+// compilePattern turns a URL pattern into an anchored regular expression
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(strings.TrimSpace(pattern))
+	return regexp.Compile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+}
+
+// validate checks a rule and normalizes its fields
+func validate(rule *Rule) error {
+	rule.URLPattern = strings.TrimSpace(rule.URLPattern)
+	if rule.URLPattern == "" {
+		return fmt.Errorf("URL pattern cannot be empty")
+	}
+	if _, err := compilePattern(rule.URLPattern); err != nil {
+		return fmt.Errorf("invalid URL pattern: %v", err)
+	}
+	rule.Method = strings.ToUpper(strings.TrimSpace(rule.Method))
+	if rule.StatusCode == 0 {
+		rule.StatusCode = http.StatusOK
+	}
+	if rule.StatusCode < 100 || rule.StatusCode > 599 {
+		return fmt.Errorf("invalid status code %d", rule.StatusCode)
+	}
+	for _, line := range headerLines(rule.Headers) {
+		if !strings.Contains(line, ":") {
+			return fmt.Errorf("invalid header line %q, expected Name: value", line)
+		}
+	}
+	return nil
+}
+
+// GetAllRules returns all map local rules
+func (c *Client) GetAllRules() ([]Rule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Rule{}, c.rules...), nil
+}
+
+// AddRule adds a new map local rule
+func (c *Client) AddRule(rule Rule) (Rule, error) {
+	if err := validate(&rule); err != nil {
+		return rule, err
+	}
+
+	result, err := c.db.Exec(`
+		INSERT INTO map_local_rules (rule_name, method, url_pattern, status_code, headers, body, file_path, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.RuleName, rule.Method, rule.URLPattern, rule.StatusCode, rule.Headers, rule.Body, rule.FilePath, rule.Enabled)
+	if err != nil {
+		return rule, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return rule, err
+	}
+	rule.ID = int(id)
+
+	c.mu.Lock()
+	c.rules = append(c.rules, rule)
+	c.compile(rule)
+	c.mu.Unlock()
+	return rule, nil
+}
+
+// UpdateRule updates an existing map local rule
+func (c *Client) UpdateRule(rule Rule) error {
+	if err := validate(&rule); err != nil {
+		return err
+	}
+
+	_, err := c.db.Exec(`
+		UPDATE map_local_rules
+		SET rule_name = ?, method = ?, url_pattern = ?, status_code = ?, headers = ?, body = ?, file_path = ?, enabled = ?
+		WHERE id = ?
+	`, rule.RuleName, rule.Method, rule.URLPattern, rule.StatusCode, rule.Headers, rule.Body, rule.FilePath, rule.Enabled, rule.ID)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.rules {
+		if r.ID == rule.ID {
+			c.rules[i] = rule
+			break
+		}
+	}
+	c.compile(rule)
+	return nil
+}
+
+// DeleteRule deletes a map local rule
+func (c *Client) DeleteRule(ruleID int) error {
+	if _, err := c.db.Exec(`DELETE FROM map_local_rules WHERE id = ?`, ruleID); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, rule := range c.rules {
+		if rule.ID == ruleID {
+			c.rules = append(c.rules[:i], c.rules[i+1:]...)
+			break
+		}
+	}
+	delete(c.patterns, ruleID)
+	return nil
+}
+
+// loadRules loads all map local rules from the database
+func (c *Client) loadRules() error {
+	rows, err := c.db.Query(`
+		SELECT id, COALESCE(rule_name, ''), COALESCE(method, ''), url_pattern, COALESCE(status_code, 200),
+			COALESCE(headers, ''), COALESCE(body, ''), COALESCE(file_path, ''), enabled
+		FROM map_local_rules
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		var rule Rule
+		if err := rows.Scan(&rule.ID, &rule.RuleName, &rule.Method, &rule.URLPattern, &rule.StatusCode,
+			&rule.Headers, &rule.Body, &rule.FilePath, &rule.Enabled); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = rules
+	c.patterns = make(map[int]*regexp.Regexp, len(rules))
+	for _, rule := range rules {
+		c.compile(rule)
+	}
+	return nil
+}
+
+// compile caches the pattern of a rule, the caller holds the lock
+func (c *Client) compile(rule Rule) {
+	if c.patterns == nil {
+		c.patterns = make(map[int]*regexp.Regexp)
+	}
+	pattern, err := compilePattern(rule.URLPattern)
+	if err != nil {
+		log.Printf("Invalid map local pattern %q: %v", rule.URLPattern, err)
+		delete(c.patterns, rule.ID)
+		return
+	}
+	c.patterns[rule.ID] = pattern
+}
+
+// Match returns the first enabled rule covering a request
+func (c *Client) Match(req *http.Request) (Rule, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	target := req.URL.String()
+	if req.URL.Host == "" {
+		// Requests inside a MITM tunnel may carry the host in Host only
+		target = "https://" + req.Host + req.URL.RequestURI()
+	}
+	for _, rule := range c.rules {
+		if !rule.Enabled || (rule.Method != "" && !strings.EqualFold(rule.Method, req.Method)) {
+			continue
+		}
+		if pattern := c.patterns[rule.ID]; pattern != nil && pattern.MatchString(target) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Respond builds the canned response of the first rule covering a request. It
+// returns false when no rule matches, so the request goes to the server.
+func (c *Client) Respond(req *http.Request) (*http.Response, bool) {
+	rule, ok := c.Match(req)
+	if !ok {
+		return nil, false
+	}
+
+	body := []byte(rule.Body)
+	if rule.FilePath != "" {
+		data, err := os.ReadFile(rule.FilePath)
+		if err != nil {
+			log.Printf("Map local rule %q failed to read %s: %v", rule.RuleName, rule.FilePath, err)
+			return errorResponse(req, fmt.Sprintf("Map local rule %q failed to read %s: %v", rule.RuleName, rule.FilePath, err)), true
+		}
+		body = data
+	}
+
+	header := make(http.Header)
+	for _, line := range headerLines(rule.Headers) {
+		name, value, _ := strings.Cut(line, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if header.Get("Content-Type") == "" {
+		contentType := ""
+		if rule.FilePath != "" {
+			contentType = mime.TypeByExtension(filepath.Ext(rule.FilePath))
+		}
+		if contentType == "" {
+			contentType = http.DetectContentType(body)
+		}
+		header.Set("Content-Type", contentType)
+	}
+	// The body is served as is
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rule.StatusCode, http.StatusText(rule.StatusCode)),
+		StatusCode:    rule.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, true
+}
+
+// errorResponse answers a request whose rule cannot be served
+func errorResponse(req *http.Request, message string) *http.Response {
+	return &http.Response{
+		Status:        "502 Bad Gateway",
+		StatusCode:    http.StatusBadGateway,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(message)),
+		ContentLength: int64(len(message)),
+		Request:       req,
+	}
+}
+
+// headerLines splits the headers of a rule into its non-empty lines
+func headerLines(headers string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(headers, "\r\n", "\n"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
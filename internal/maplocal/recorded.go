@@ -0,0 +1,84 @@
+package maplocal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prokzee/internal/contentcoding"
+)
+
+// recordedSkipHeaders are response headers a recorded response is not
+// replayed with, they describe the original connection rather than the body
+var recordedSkipHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Content-Encoding":  true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+}
+
+// RuleFromHistory builds a rule that answers the method and URL of a history
+// entry with the response recorded for it. The rule is not saved.
+func (c *Client) RuleFromHistory(requestID int) (Rule, error) {
+	var rawURL, method, status, headersJSON, body, encoding string
+	var truncated bool
+	err := c.db.QueryRow(`
+		SELECT COALESCE(url, ''), method, COALESCE(status, ''), COALESCE(response_headers, ''),
+			COALESCE(response_body, ''), COALESCE(response_encoding, ''), COALESCE(body_truncated, 0)
+		FROM requests WHERE id = ?
+	`, requestID).Scan(&rawURL, &method, &status, &headersJSON, &body, &encoding, &truncated)
+	if err == sql.ErrNoRows {
+		return Rule{}, fmt.Errorf("request %d not found", requestID)
+	}
+	if err != nil {
+		return Rule{}, fmt.Errorf("failed to fetch request %d: %v", requestID, err)
+	}
+
+	code, err := strconv.Atoi(strings.Fields(status + " 0")[0])
+	if err != nil || code < 100 || code > 599 {
+		return Rule{}, fmt.Errorf("request %d has no recorded response", requestID)
+	}
+	if truncated {
+		return Rule{}, fmt.Errorf("the response of request %d was too large to be kept whole, save it to a file and map that instead", requestID)
+	}
+
+	var headers http.Header
+	json.Unmarshal([]byte(headersJSON), &headers)
+	// A body kept as received in an encoding that was not undone cannot be
+	// served without it
+	if contentEncoding := headers.Get("Content-Encoding"); encoding == "" && !contentcoding.IsIdentity(contentEncoding) {
+		return Rule{}, fmt.Errorf("the response of request %d is kept in the %s encoding, which cannot be replayed", requestID, contentEncoding)
+	}
+
+	var lines []string
+	for name, values := range headers {
+		if recordedSkipHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, value := range values {
+			lines = append(lines, name+": "+value)
+		}
+	}
+
+	sort.Strings(lines)
+
+	path := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		path = parsed.Path
+	}
+	return Rule{
+		RuleName:   fmt.Sprintf("Recorded %s %s", method, path),
+		Method:     method,
+		URLPattern: rawURL,
+		StatusCode: code,
+		Headers:    strings.Join(lines, "\n"),
+		Body:       body,
+		Enabled:    true,
+	}, nil
+}
@@ -1,12 +1,16 @@
 package matchreplace
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/storage"
 )
 
 // Rule represents a match and replace rule
@@ -175,7 +179,11 @@ func (c *Client) ApplyToRequest(req *http.Request) (*http.Request, error) {
 	// Close the original body
 	req.Body.Close()
 
-	originalBody := string(bodyBytes)
+	// Rules match the plaintext of encoded bodies
+	contentEncoding := req.Header.Get("Content-Encoding")
+	plainBytes, decoded := decodeBody(bodyBytes, contentEncoding)
+
+	originalBody := string(plainBytes)
 	modifiedBody := originalBody
 
 	for _, rule := range c.rules {
@@ -205,19 +213,25 @@ func (c *Client) ApplyToRequest(req *http.Request) (*http.Request, error) {
 
 	// Only update if the body was actually modified
 	if modifiedBody != originalBody {
+		// Encode the body again as it was received
+		newBody := []byte(modifiedBody)
+		if decoded {
+			newBody = encodeBody(newBody, contentEncoding, req.Header)
+		}
+
 		// Update the body
-		req.Body = io.NopCloser(strings.NewReader(modifiedBody))
+		req.Body = io.NopCloser(bytes.NewReader(newBody))
 
 		// Update Content-Length header if it exists
 		if req.Header.Get("Content-Length") != "" {
-			req.Header.Set("Content-Length", fmt.Sprintf("%d", len(modifiedBody)))
+			req.Header.Set("Content-Length", fmt.Sprintf("%d", len(newBody)))
 		}
 
 		// Update the ContentLength field
-		req.ContentLength = int64(len(modifiedBody))
+		req.ContentLength = int64(len(newBody))
 	} else {
 		// Restore the original body if no changes were made
-		req.Body = io.NopCloser(strings.NewReader(originalBody))
+		req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 	}
 
 	return req, nil
@@ -229,6 +243,13 @@ func (c *Client) ApplyToResponse(resp *http.Response) (*http.Response, error) {
 		return resp, nil
 	}
 
+	// Leave the body streaming to the client unless a rule rewrites it. Event
+	// streams may never end, so their body rules are not applied.
+	if !c.hasResponseBodyRules() || storage.IsStreaming(resp.Header) {
+		c.applyResponseHeaderRules(resp)
+		return resp, nil
+	}
+
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %v", err)
@@ -237,7 +258,11 @@ func (c *Client) ApplyToResponse(resp *http.Response) (*http.Response, error) {
 	// Close the original body
 	resp.Body.Close()
 
-	originalBody := string(bodyBytes)
+	// Rules match the plaintext of encoded bodies
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	plainBytes, decoded := decodeBody(bodyBytes, contentEncoding)
+
+	originalBody := string(plainBytes)
 	modifiedBody := originalBody
 
 	for _, rule := range c.rules {
@@ -267,20 +292,76 @@ func (c *Client) ApplyToResponse(resp *http.Response) (*http.Response, error) {
 
 	// Only update if the body was actually modified
 	if modifiedBody != originalBody {
+		// Encode the body again as it was received
+		newBody := []byte(modifiedBody)
+		if decoded {
+			newBody = encodeBody(newBody, contentEncoding, resp.Header)
+		}
+
 		// Update the body
-		resp.Body = io.NopCloser(strings.NewReader(modifiedBody))
+		resp.Body = io.NopCloser(bytes.NewReader(newBody))
 
 		// Update Content-Length header if it exists
 		if resp.Header.Get("Content-Length") != "" {
-			resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(modifiedBody)))
+			resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(newBody)))
 		}
 
 		// Update the ContentLength field
-		resp.ContentLength = int64(len(modifiedBody))
+		resp.ContentLength = int64(len(newBody))
 	} else {
 		// Restore the original body if no changes were made
-		resp.Body = io.NopCloser(strings.NewReader(originalBody))
+		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 	}
 
 	return resp, nil
 }
+
+// hasResponseBodyRules reports whether an enabled rule rewrites response bodies
+func (c *Client) hasResponseBodyRules() bool {
+	for _, rule := range c.rules {
+		if rule.Enabled && rule.Target == "response" && rule.MatchType == "body" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyResponseHeaderRules applies the enabled response header rules
+func (c *Client) applyResponseHeaderRules(resp *http.Response) {
+	for _, rule := range c.rules {
+		if !rule.Enabled || rule.Target != "response" || rule.MatchType != "header" {
+			continue
+		}
+		parts := strings.SplitN(rule.MatchContent, ":", 2)
+		if len(parts) == 2 && resp.Header.Get(strings.TrimSpace(parts[0])) == strings.TrimSpace(parts[1]) {
+			resp.Header.Set(strings.TrimSpace(parts[0]), rule.ReplaceContent)
+		}
+	}
+}
+
+// decodeBody undoes a content encoding so rules match the plaintext. It
+// reports false when the body is kept as received, because it has no
+// encoding or one that cannot be undone.
+func decodeBody(body []byte, contentEncoding string) ([]byte, bool) {
+	if contentcoding.IsIdentity(contentEncoding) || !contentcoding.Supported(contentEncoding) {
+		return body, false
+	}
+	decoded, err := contentcoding.Decode(body, contentEncoding, 0)
+	if err != nil {
+		log.Printf("Applying match replace rules to the encoded body: %v", err)
+		return body, false
+	}
+	return decoded, true
+}
+
+// encodeBody applies a content encoding again to a rewritten body. When that
+// fails the body is sent as plaintext and the Content-Encoding header dropped.
+func encodeBody(body []byte, contentEncoding string, header http.Header) []byte {
+	encoded, err := contentcoding.Encode(body, contentEncoding)
+	if err != nil {
+		log.Printf("Sending the rewritten body unencoded: %v", err)
+		header.Del("Content-Encoding")
+		return body
+	}
+	return encoded
+}
@@ -0,0 +1,383 @@
+package mediameta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxValueLength bounds the length of a single metadata value
+const maxValueLength = 256
+
+// EXIF tags worth showing, by IFD
+var (
+	imageTags = map[uint16]string{
+		0x010E: "ImageDescription",
+		0x010F: "Make",
+		0x0110: "Model",
+		0x0131: "Software",
+		0x0132: "DateTime",
+		0x013B: "Artist",
+		0x8298: "Copyright",
+	}
+	exifTags = map[uint16]string{
+		0x9003: "DateTimeOriginal",
+		0xA430: "CameraOwnerName",
+		0xA431: "BodySerialNumber",
+		0xA433: "LensMake",
+		0xA434: "LensModel",
+		0xA435: "LensSerialNumber",
+	}
+)
+
+// EXIF pointers to the sub IFDs
+const (
+	exifIFDPointer = 0x8769
+	gpsIFDPointer  = 0x8825
+)
+
+// xmpAttribute and xmpElement find the simple properties of an XMP packet
+var (
+	xmpAttribute = regexp.MustCompile(`\s([A-Za-z][\w-]*:[A-Za-z][\w-]*)="([^"]*)"`)
+	xmpElement   = regexp.MustCompile(`<([A-Za-z][\w-]*:[A-Za-z][\w-]*)>([^<]+)</`)
+)
+
+// Metadata is the EXIF and XMP metadata found in an image
+type Metadata struct {
+	Format string            `json:"format"`
+	EXIF   map[string]string `json:"exif,omitempty"`
+	XMP    map[string]string `json:"xmp,omitempty"`
+	GPS    *GPS              `json:"gps,omitempty"`
+}
+
+// GPS is the location an image was taken at
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// Sensitive reports whether the metadata reveals a location or a device serial
+// number, which images uploaded by users should not carry
+func (m *Metadata) Sensitive() bool {
+	if m == nil {
+		return false
+	}
+	if m.GPS != nil {
+		return true
+	}
+	for _, key := range []string{"BodySerialNumber", "LensSerialNumber", "CameraOwnerName"} {
+		if m.EXIF[key] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary describes the sensitive parts of the metadata for a finding
+func (m *Metadata) Summary() string {
+	var parts []string
+	if m.GPS != nil {
+		parts = append(parts, fmt.Sprintf("GPS location %.6f, %.6f", m.GPS.Latitude, m.GPS.Longitude))
+	}
+	for _, key := range []string{"Make", "Model", "BodySerialNumber", "LensSerialNumber", "CameraOwnerName", "Artist"} {
+		if value := m.EXIF[key]; value != "" {
+			parts = append(parts, key+" "+value)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Extract returns the metadata of a JPEG, PNG, WebP or TIFF image, or nil when
+// the body is not one of them or carries no metadata
+func Extract(body []byte) *Metadata {
+	var meta *Metadata
+	switch {
+	case bytes.HasPrefix(body, []byte{0xFF, 0xD8}):
+		meta = extractJPEG(body)
+	case bytes.HasPrefix(body, []byte("\x89PNG\r\n\x1a\n")):
+		meta = extractPNG(body)
+	case len(body) >= 12 && string(body[:4]) == "RIFF" && string(body[8:12]) == "WEBP":
+		meta = extractWebP(body)
+	case bytes.HasPrefix(body, []byte("II*\x00")) || bytes.HasPrefix(body, []byte("MM\x00*")):
+		meta = &Metadata{Format: "tiff"}
+		meta.parseTIFF(body)
+	default:
+		return nil
+	}
+	if len(meta.EXIF) == 0 && len(meta.XMP) == 0 && meta.GPS == nil {
+		return nil
+	}
+	return meta
+}
+
+// JSON returns the metadata of an image as JSON, or "" when there is none
+func JSON(body []byte) string {
+	meta := Extract(body)
+	if meta == nil {
+		return ""
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// extractJPEG walks the JPEG segments up to the image data looking for the
+// APP1 EXIF and XMP segments
+func extractJPEG(body []byte) *Metadata {
+	meta := &Metadata{Format: "jpeg"}
+	for i := 2; i+4 <= len(body); {
+		if body[i] != 0xFF {
+			break
+		}
+		marker := body[i+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			i += 2
+			continue
+		}
+		// Start of scan, the metadata segments come before it
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(body[i+2 : i+4]))
+		if length < 2 || i+2+length > len(body) {
+			break
+		}
+		segment := body[i+4 : i+2+length]
+		if marker == 0xE1 {
+			switch {
+			case bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+				meta.parseTIFF(segment[6:])
+			case bytes.HasPrefix(segment, []byte("http://ns.adobe.com/xap/1.0/\x00")):
+				meta.parseXMP(segment[29:])
+			}
+		}
+		i += 2 + length
+	}
+	return meta
+}
+
+// extractPNG reads the eXIf chunk and the XMP packet of an iTXt chunk
+func extractPNG(body []byte) *Metadata {
+	meta := &Metadata{Format: "png"}
+	for i := 8; i+8 <= len(body); {
+		length := int(binary.BigEndian.Uint32(body[i : i+4]))
+		chunkType := string(body[i+4 : i+8])
+		if length < 0 || i+12+length > len(body) {
+			break
+		}
+		data := body[i+8 : i+8+length]
+		switch chunkType {
+		case "eXIf":
+			meta.parseTIFF(data)
+		case "iTXt":
+			if bytes.HasPrefix(data, []byte("XML:com.adobe.xmp\x00")) {
+				meta.parseXMP(data)
+			}
+		case "IEND":
+			return meta
+		}
+		i += 12 + length
+	}
+	return meta
+}
+
+// extractWebP reads the EXIF and XMP chunks of a WebP container
+func extractWebP(body []byte) *Metadata {
+	meta := &Metadata{Format: "webp"}
+	for i := 12; i+8 <= len(body); {
+		chunkType := string(body[i : i+4])
+		length := int(binary.LittleEndian.Uint32(body[i+4 : i+8]))
+		if length < 0 || i+8+length > len(body) {
+			break
+		}
+		data := body[i+8 : i+8+length]
+		switch chunkType {
+		case "EXIF":
+			meta.parseTIFF(bytes.TrimPrefix(data, []byte("Exif\x00\x00")))
+		case "XMP ":
+			meta.parseXMP(data)
+		}
+		// Chunks are padded to an even size
+		i += 8 + length + length%2
+	}
+	return meta
+}
+
+// tiffReader reads the IFDs of a TIFF structure, the format EXIF data uses
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// parseTIFF reads the image, EXIF and GPS IFDs of TIFF data
+func (m *Metadata) parseTIFF(data []byte) {
+	if len(data) < 8 {
+		return
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return
+	}
+
+	if m.EXIF == nil {
+		m.EXIF = make(map[string]string)
+	}
+	entries := r.ifd(r.order.Uint32(data[4:8]))
+	for tag, entry := range entries {
+		if name, ok := imageTags[tag]; ok {
+			m.setEXIF(name, r.ascii(entry))
+		}
+	}
+	if entry, ok := entries[exifIFDPointer]; ok {
+		for tag, sub := range r.ifd(r.order.Uint32(entry[8:12])) {
+			if name, ok := exifTags[tag]; ok {
+				m.setEXIF(name, r.ascii(sub))
+			}
+		}
+	}
+	if entry, ok := entries[gpsIFDPointer]; ok {
+		m.GPS = r.gps(r.ifd(r.order.Uint32(entry[8:12])))
+	}
+	if len(m.EXIF) == 0 {
+		m.EXIF = nil
+	}
+}
+
+// ifd returns the 12 byte entries of the IFD at offset by tag
+func (r *tiffReader) ifd(offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if int(offset)+2 > len(r.data) {
+		return entries
+	}
+	count := int(r.order.Uint16(r.data[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(r.data) {
+			break
+		}
+		entry := r.data[start : start+12]
+		entries[r.order.Uint16(entry[:2])] = entry
+	}
+	return entries
+}
+
+// value returns the bytes of an entry, stored inline when they fit in four
+// bytes and at an offset otherwise
+func (r *tiffReader) value(entry []byte, size int) []byte {
+	count := int(r.order.Uint32(entry[4:8]))
+	total := count * size
+	if total <= 4 {
+		return entry[8 : 8+total]
+	}
+	offset := int(r.order.Uint32(entry[8:12]))
+	if total < 0 || offset+total > len(r.data) {
+		return nil
+	}
+	return r.data[offset : offset+total]
+}
+
+// ascii reads an ASCII entry
+func (r *tiffReader) ascii(entry []byte) string {
+	if r.order.Uint16(entry[2:4]) != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(r.value(entry, 1)), "\x00"))
+}
+
+// rationals reads an unsigned RATIONAL entry
+func (r *tiffReader) rationals(entry []byte) []float64 {
+	if r.order.Uint16(entry[2:4]) != 5 {
+		return nil
+	}
+	raw := r.value(entry, 8)
+	var values []float64
+	for i := 0; i+8 <= len(raw); i += 8 {
+		numerator, denominator := r.order.Uint32(raw[i:i+4]), r.order.Uint32(raw[i+4:i+8])
+		if denominator == 0 {
+			return nil
+		}
+		values = append(values, float64(numerator)/float64(denominator))
+	}
+	return values
+}
+
+// gps converts the latitude, longitude and altitude of a GPS IFD
+func (r *tiffReader) gps(entries map[uint16][]byte) *GPS {
+	coordinate := func(refTag, valueTag uint16, negative string) (float64, bool) {
+		ref, okRef := entries[refTag]
+		value, okValue := entries[valueTag]
+		if !okRef || !okValue {
+			return 0, false
+		}
+		parts := r.rationals(value)
+		if len(parts) != 3 {
+			return 0, false
+		}
+		degrees := parts[0] + parts[1]/60 + parts[2]/3600
+		if strings.HasPrefix(strings.ToUpper(string(r.value(ref, 1))), negative) {
+			degrees = -degrees
+		}
+		return degrees, true
+	}
+
+	latitude, okLat := coordinate(1, 2, "S")
+	longitude, okLon := coordinate(3, 4, "W")
+	if !okLat || !okLon || (latitude == 0 && longitude == 0) {
+		return nil
+	}
+	gps := &GPS{Latitude: latitude, Longitude: longitude}
+	if entry, ok := entries[6]; ok {
+		if altitude := r.rationals(entry); len(altitude) == 1 {
+			gps.Altitude = altitude[0]
+		}
+	}
+	return gps
+}
+
+// parseXMP collects the simple properties of an XMP packet
+func (m *Metadata) parseXMP(packet []byte) {
+	if m.XMP == nil {
+		m.XMP = make(map[string]string)
+	}
+	for _, match := range xmpAttribute.FindAllSubmatch(packet, -1) {
+		name := string(match[1])
+		if strings.HasPrefix(name, "xmlns:") || strings.HasPrefix(name, "rdf:") || strings.HasPrefix(name, "x:") {
+			continue
+		}
+		setValue(m.XMP, name, string(match[2]))
+	}
+	for _, match := range xmpElement.FindAllSubmatch(packet, -1) {
+		setValue(m.XMP, string(match[1]), string(match[2]))
+	}
+	if len(m.XMP) == 0 {
+		m.XMP = nil
+	}
+}
+
+// setEXIF stores a non-empty EXIF value
+func (m *Metadata) setEXIF(name, value string) {
+	setValue(m.EXIF, name, value)
+}
+
+// setValue stores a trimmed, non-empty value, keeping the first one seen
+func setValue(values map[string]string, name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" || values[name] != "" {
+		return
+	}
+	if len(value) > maxValueLength {
+		value = value[:maxValueLength]
+	}
+	values[name] = value
+}
@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the localhost port of the metrics server when none is given
+const DefaultPort = "6061"
+
+// latencyBuckets are the histogram upper bounds, in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram is a cumulative latency histogram in the Prometheus format
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Collector keeps the counters and latencies of the proxy pipeline
+type Collector struct {
+	mu       sync.Mutex
+	started  time.Time
+	requests map[string]uint64     // by stage
+	handlers map[string]*histogram // by stage
+	dbWrites histogram
+	dbErrors uint64
+}
+
+// NewCollector creates an empty collector
+func NewCollector() *Collector {
+	return &Collector{
+		started:  time.Now(),
+		requests: make(map[string]uint64),
+		handlers: make(map[string]*histogram),
+	}
+}
+
+// ObserveHandler counts a message that went through a stage of the proxy
+// pipeline ("request" or "response") and records how long the stage took
+func (c *Collector) ObserveHandler(stage string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[stage]++
+	h, ok := c.handlers[stage]
+	if !ok {
+		h = &histogram{}
+		c.handlers[stage] = h
+	}
+	h.observe(d)
+}
+
+// ObserveDBWrite records the latency of storing a captured request
+func (c *Collector) ObserveDBWrite(d time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dbWrites.observe(d)
+	if err != nil {
+		c.dbErrors++
+	}
+}
+
+// StageStat is how many messages a stage handled and the time spent in it
+type StageStat struct {
+	Count   uint64
+	Seconds float64
+}
+
+// Sub returns the activity between an earlier snapshot and this one
+func (s StageStat) Sub(earlier StageStat) StageStat {
+	return StageStat{Count: s.Count - earlier.Count, Seconds: s.Seconds - earlier.Seconds}
+}
+
+// Average returns the mean time spent per message
+func (s StageStat) Average() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return time.Duration(s.Seconds / float64(s.Count) * float64(time.Second))
+}
+
+// StageDBWrite is the snapshot key of the database write latencies
+const StageDBWrite = "db_write"
+
+// Snapshot returns the totals of every handler stage and of database writes
+func (c *Collector) Snapshot() map[string]StageStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := map[string]StageStat{
+		StageDBWrite: {Count: c.dbWrites.count, Seconds: c.dbWrites.sum},
+	}
+	for stage, h := range c.handlers {
+		snapshot[stage] = StageStat{Count: h.count, Seconds: h.sum}
+	}
+	return snapshot
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (c *Collector) WritePrometheus(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP prokzee_uptime_seconds Time since the collector was created.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "prokzee_uptime_seconds %g\n", time.Since(c.started).Seconds())
+
+	stages := make([]string, 0, len(c.requests))
+	for stage := range c.requests {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	fmt.Fprintf(w, "# HELP prokzee_proxy_messages_total Messages handled by each stage of the proxy pipeline.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_proxy_messages_total counter\n")
+	for _, stage := range stages {
+		fmt.Fprintf(w, "prokzee_proxy_messages_total{stage=%q} %d\n", stage, c.requests[stage])
+	}
+
+	fmt.Fprintf(w, "# HELP prokzee_handler_duration_seconds Latency of each stage of the proxy pipeline.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_handler_duration_seconds histogram\n")
+	for _, stage := range stages {
+		writeHistogram(w, "prokzee_handler_duration_seconds", fmt.Sprintf("stage=%q,", stage), c.handlers[stage])
+	}
+
+	fmt.Fprintf(w, "# HELP prokzee_db_write_duration_seconds Latency of storing a captured request.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_db_write_duration_seconds histogram\n")
+	writeHistogram(w, "prokzee_db_write_duration_seconds", "", &c.dbWrites)
+	fmt.Fprintf(w, "# HELP prokzee_db_write_errors_total Captured requests that failed to store.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_db_write_errors_total counter\n")
+	fmt.Fprintf(w, "prokzee_db_write_errors_total %d\n", c.dbErrors)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(w, "# HELP prokzee_memory_heap_bytes Bytes of allocated heap objects.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_memory_heap_bytes gauge\n")
+	fmt.Fprintf(w, "prokzee_memory_heap_bytes %d\n", mem.HeapAlloc)
+	fmt.Fprintf(w, "# HELP prokzee_memory_sys_bytes Bytes of memory obtained from the OS.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_memory_sys_bytes gauge\n")
+	fmt.Fprintf(w, "prokzee_memory_sys_bytes %d\n", mem.Sys)
+	fmt.Fprintf(w, "# HELP prokzee_gc_runs_total Completed garbage collection cycles.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_gc_runs_total counter\n")
+	fmt.Fprintf(w, "prokzee_gc_runs_total %d\n", mem.NumGC)
+	fmt.Fprintf(w, "# HELP prokzee_goroutines Number of goroutines.\n")
+	fmt.Fprintf(w, "# TYPE prokzee_goroutines gauge\n")
+	fmt.Fprintf(w, "prokzee_goroutines %d\n", runtime.NumGoroutine())
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	for i, bound := range latencyBuckets {
+		var count uint64
+		if h.counts != nil {
+			count = h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bound, count)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = trimLabels(labels)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// trimLabels turns the label prefix of the buckets into a label set
+func trimLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels[:len(labels)-1] + "}"
+}
+
+// Server exposes the metrics and the pprof profiles on a localhost port
+type Server struct {
+	mu        sync.Mutex
+	collector *Collector
+	server    *http.Server
+	address   string
+}
+
+// NewServer creates a stopped metrics server for a collector
+func NewServer(collector *Collector) *Server {
+	return &Server{collector: collector}
+}
+
+// Start listens on 127.0.0.1:port, serving /metrics and /debug/pprof/. It
+// never binds other interfaces since profiles expose process internals.
+func (s *Server) Start(port string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		return s.address, nil
+	}
+	if port == "" {
+		port = DefaultPort
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for metrics: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.collector.WritePrometheus(w)
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	s.server = &http.Server{Handler: mux}
+	s.address = listener.Addr().String()
+	server := s.server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+	return s.address, nil
+}
+
+// Stop shuts the metrics server down
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	s.server = nil
+	s.address = ""
+	return err
+}
+
+// Address returns the address the server listens on, or "" when stopped
+func (s *Server) Address() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.address
+}
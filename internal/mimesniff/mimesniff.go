@@ -0,0 +1,192 @@
+package mimesniff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"prokzee/internal/contentcoding"
+)
+
+// sniffLimit bounds how much of a body is decompressed and inspected
+const sniffLimit = 1024 * 1024
+
+// Classes of content compared between the declared and the sniffed type
+const (
+	classJSON       = "json"
+	classHTML       = "html"
+	classXML        = "xml"
+	classJavaScript = "javascript"
+	classCSS        = "css"
+	classText       = "text"
+	classImage      = "image"
+	classAudio      = "audio"
+	classVideo      = "video"
+	classFont       = "font"
+	classPDF        = "pdf"
+	classArchive    = "archive"
+	classExecutable = "executable"
+	classUnknown    = ""
+)
+
+// magic are signatures http.DetectContentType does not know, mostly
+// executables served under another type
+var magic = []struct {
+	prefix    []byte
+	mediaType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-elf"},
+	{[]byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{[]byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xca\xfe\xba\xbe"), "application/java-vm"},
+	{[]byte("dex\n"), "application/vnd.android.dex"},
+}
+
+// Result compares the type a response declares with the one its body looks like
+type Result struct {
+	Declared string `json:"declared"`
+	Sniffed  string `json:"sniffed"`
+	Mismatch bool   `json:"mismatch"`
+}
+
+// Sniff returns the media type the content of body looks like, without
+// parameters, or "" for an empty body
+func Sniff(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	for _, m := range magic {
+		if bytes.HasPrefix(body, m.prefix) {
+			return m.mediaType
+		}
+	}
+
+	trimmed := bytes.TrimLeft(body, " \t\r\n\xef\xbb\xbf")
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json"
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(body))
+	return mediaType
+}
+
+// SniffBody sniffs a body as received, undoing its content encoding. Bodies in
+// an unknown encoding are not sniffed.
+func SniffBody(body []byte, contentEncoding string) string {
+	decoded, ok := decode(body, contentEncoding)
+	if !ok {
+		return ""
+	}
+	return Sniff(decoded)
+}
+
+// Check sniffs a response body and reports whether its content contradicts the
+// Content-Type it was served with. Missing and generic declared types never
+// mismatch, and neither do sniffed types that say little about the content.
+func Check(contentType, contentEncoding string, body []byte) Result {
+	declared, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		declared = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	result := Result{Declared: declared, Sniffed: SniffBody(body, contentEncoding)}
+	if result.Declared == "" || result.Sniffed == "" {
+		return result
+	}
+
+	declaredClass, sniffedClass := classify(result.Declared), classify(result.Sniffed)
+	if declaredClass == classUnknown || sniffedClass == classUnknown || declaredClass == sniffedClass {
+		return result
+	}
+	result.Mismatch = !compatible(declaredClass, sniffedClass)
+	return result
+}
+
+// Detail describes a mismatch for a finding
+func (r Result) Detail() string {
+	return fmt.Sprintf("The response is served as %s but its body looks like %s. Browsers that sniff content, or clients trusting the declared type, may handle it differently than intended.", r.Declared, r.Sniffed)
+}
+
+// compatible reports whether content of the sniffed class is expected under
+// the declared class. Plain text and JavaScript routinely carry JSON, and a
+// text sniff of a textual declared type only means the content is text.
+func compatible(declared, sniffed string) bool {
+	switch {
+	case sniffed == classText:
+		return isTextual(declared)
+	case declared == classText:
+		return sniffed == classJSON || sniffed == classXML
+	case declared == classJavaScript:
+		return sniffed == classJSON
+	case declared == classXML:
+		return sniffed == classHTML
+	}
+	return false
+}
+
+// isTextual reports whether a class is text-based
+func isTextual(class string) bool {
+	switch class {
+	case classJSON, classHTML, classXML, classJavaScript, classCSS, classText:
+		return true
+	}
+	return false
+}
+
+// classify groups a media type into a class, classUnknown for types that say
+// nothing about the content such as application/octet-stream
+func classify(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return classJSON
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return classHTML
+	case mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return classXML
+	case strings.Contains(mediaType, "javascript") || mediaType == "application/ecmascript":
+		return classJavaScript
+	case mediaType == "text/css":
+		return classCSS
+	case mediaType == "application/pdf":
+		return classPDF
+	case mediaType == "application/zip" || mediaType == "application/x-gzip" || mediaType == "application/gzip" ||
+		mediaType == "application/x-rar-compressed" || mediaType == "application/x-7z-compressed":
+		return classArchive
+	case mediaType == "application/x-msdownload" || mediaType == "application/x-elf" || mediaType == "application/x-mach-binary" ||
+		mediaType == "application/java-vm" || mediaType == "application/vnd.android.dex" ||
+		mediaType == "application/x-msdos-program" || mediaType == "application/x-executable":
+		return classExecutable
+	case strings.HasPrefix(mediaType, "image/"):
+		return classImage
+	case strings.HasPrefix(mediaType, "audio/"):
+		return classAudio
+	case strings.HasPrefix(mediaType, "video/"):
+		return classVideo
+	case strings.HasPrefix(mediaType, "font/") || strings.Contains(mediaType, "font"):
+		return classFont
+	case strings.HasPrefix(mediaType, "text/"):
+		return classText
+	}
+	return classUnknown
+}
+
+// decode undoes a content encoding, reading at most sniffLimit bytes
+func decode(body []byte, contentEncoding string) ([]byte, bool) {
+	if contentcoding.IsIdentity(contentEncoding) {
+		if len(body) > sniffLimit {
+			body = body[:sniffLimit]
+		}
+		return body, true
+	}
+	decoded, err := contentcoding.Decode(body, contentEncoding, sniffLimit)
+	if err != nil && len(decoded) == 0 {
+		return nil, false
+	}
+	return decoded, true
+}
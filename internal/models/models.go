@@ -13,8 +13,18 @@ type contextKey string
 // Define constants for our context keys
 const (
 	CreationTimeKey contextKey = "creationTime"
+	// CorrelationIDKey holds the ID sent upstream in the correlation header
+	CorrelationIDKey contextKey = "correlationID"
 )
 
+// SelfTestHeader marks diagnostic requests that the proxy forwards without
+// intercepting or storing them
+const SelfTestHeader = "X-Prokzee-Self-Test"
+
+// LoadTestHeader marks synthetic benchmark requests, which go through the
+// whole pipeline including storage but are never held for interception
+const LoadTestHeader = "X-Prokzee-Load-Test"
+
 type UserData struct {
 	RequestID  string
 	BodyBytes  []byte
@@ -0,0 +1,307 @@
+package paramtest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the parameter pollution tester
+const Source = "parameter-pollution"
+
+// maxResponseBody bounds how much of a response is compared
+const maxResponseBody = 512 * 1024
+
+// Parameter locations
+const (
+	LocationQuery = probe.LocationQuery
+	LocationBody  = probe.LocationBody
+)
+
+// Behaviors of the server towards a polluted parameter
+const (
+	BehaviorOriginal     = "original"     // acted on the original value
+	BehaviorInjected     = "injected"     // acted on the injected value
+	BehaviorConcatenated = "concatenated" // joined both values, as ASP.NET does
+	BehaviorDifferent    = "different"    // acted like neither value alone
+	BehaviorError        = "error"        // rejected the request
+)
+
+// Test is one way of sending a parameter twice
+type Test struct {
+	Variant  string `json:"variant"`
+	Status   int    `json:"status"`
+	Length   int    `json:"length"`
+	Behavior string `json:"behavior"`
+}
+
+// ParamResult is how the server parsed a duplicated parameter
+type ParamResult struct {
+	Name         string   `json:"name"`
+	Location     string   `json:"location"`
+	Tests        []Test   `json:"tests"`
+	Inconsistent bool     `json:"inconsistent"`
+	Reasons      []string `json:"reasons"`
+}
+
+// Result is the outcome of testing a request
+type Result struct {
+	RequestID  int           `json:"requestId"`
+	URL        string        `json:"url"`
+	Parameters []ParamResult `json:"parameters"`
+}
+
+// response is what is kept of a response for comparison
+type response struct {
+	status int
+	body   []byte
+}
+
+// Tester replays a request with duplicated parameters
+type Tester struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewTester creates a new parameter pollution tester
+func NewTester(db *sql.DB, findingsClient *findings.Client) *Tester {
+	return &Tester{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run duplicates each selected query and form body parameter of a stored
+// request, or all of them when names is empty, and compares how the server
+// answers with the original, the injected and both values
+func (t *Tester) Run(ctx context.Context, requestID int, names []string) (*Result, error) {
+	stored, err := probe.LoadParams(t.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	status, body, err := stored.Send(ctx, t.client, stored.Query, stored.Form, maxResponseBody)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+	baseline := &response{status: status, body: body}
+
+	result := &Result{RequestID: requestID, URL: stored.Target.String(), Parameters: []ParamResult{}}
+	for _, location := range []string{LocationQuery, LocationBody} {
+		pairs := stored.Query
+		if location == LocationBody {
+			pairs = stored.Form
+		}
+		seen := make(map[string]bool)
+		for i, p := range pairs {
+			if ctx.Err() != nil {
+				return result, nil
+			}
+			if seen[p.Name] || (len(selected) > 0 && !selected[p.Name]) {
+				continue
+			}
+			seen[p.Name] = true
+			param, err := t.testParam(ctx, stored, location, i, baseline)
+			if err != nil {
+				log.Printf("Parameter pollution test of %s failed: %v", p.Name, err)
+				continue
+			}
+			result.Parameters = append(result.Parameters, *param)
+		}
+	}
+
+	for _, param := range result.Parameters {
+		if param.Inconsistent {
+			t.record(stored, requestID, param)
+		}
+	}
+	return result, nil
+}
+
+// testParam sends the variants of one parameter
+func (t *Tester) testParam(ctx context.Context, stored *probe.Request, location string, index int, baseline *response) (*ParamResult, error) {
+	pairs := stored.Query
+	if location == LocationBody {
+		pairs = stored.Form
+	}
+	original := pairs[index]
+	canary := "prokzee" + probe.Token()
+	injected := probe.Pair{Name: original.Name, Value: url.QueryEscape(canary)}
+
+	// What the server does with the injected value alone
+	status, body, err := stored.SendVariant(ctx, t.client, location, probe.ReplaceAt(pairs, index, injected), maxResponseBody)
+	if err != nil {
+		return nil, err
+	}
+	control := &response{status: status, body: body}
+
+	param := &ParamResult{Name: original.Name, Location: location, Tests: []Test{}, Reasons: []string{}}
+	values := []string{probe.Decode(original.Value), canary}
+	if same(baseline, control, values) {
+		// Both values get the same answer, there is nothing to tell apart
+		return param, nil
+	}
+
+	type variant struct {
+		name     string
+		location string
+		pairs    []probe.Pair
+	}
+	variants := []variant{
+		{"injected after the original in the " + location, location, insertAt(pairs, index+1, injected)},
+		{"injected before the original in the " + location, location, insertAt(pairs, index, injected)},
+	}
+	// The other location carries the injected value, the original stays put
+	other := LocationBody
+	if location == LocationBody {
+		other = LocationQuery
+	}
+	if other == LocationQuery || stored.Form != nil {
+		otherPairs := stored.Query
+		if other == LocationBody {
+			otherPairs = stored.Form
+		}
+		variants = append(variants, variant{"injected in the " + other, other, insertAt(otherPairs, len(otherPairs), injected)})
+	}
+
+	behaviors := make([]string, len(variants))
+	for i, v := range variants {
+		if ctx.Err() != nil {
+			break
+		}
+		status, body, err := stored.SendVariant(ctx, t.client, v.location, v.pairs, maxResponseBody)
+		if err != nil {
+			continue
+		}
+		resp := &response{status: status, body: body}
+		behavior := classify(resp, baseline, control, values)
+		behaviors[i] = behavior
+		param.Tests = append(param.Tests, Test{Variant: v.name, Status: resp.status, Length: len(resp.body), Behavior: behavior})
+
+		switch {
+		case behavior == BehaviorConcatenated:
+			param.addReason("Both values are joined when the parameter is " + v.name)
+		case behavior == BehaviorDifferent:
+			param.addReason("The server acts like neither value when the parameter is " + v.name)
+		case behavior == BehaviorInjected && v.location != location:
+			param.addReason("A value in the " + v.location + " overrides the " + location + " parameter")
+		}
+	}
+
+	// First-wins and last-wins parsers each pick one position; picking the
+	// injected value in both means it is read from wherever it appears
+	if behaviors[0] == BehaviorInjected && behaviors[1] == BehaviorInjected {
+		param.addReason("The injected value wins whether it comes first or last")
+	}
+	param.Inconsistent = len(param.Reasons) > 0
+	return param, nil
+}
+
+// addReason records a reason once
+func (p *ParamResult) addReason(reason string) {
+	for _, existing := range p.Reasons {
+		if existing == reason {
+			return
+		}
+	}
+	p.Reasons = append(p.Reasons, reason)
+}
+
+// record stores an inconsistently parsed parameter as a finding
+func (t *Tester) record(stored *probe.Request, requestID int, param ParamResult) {
+	severity := findings.SeverityMedium
+	if len(param.Reasons) == 1 && strings.HasPrefix(param.Reasons[0], "Both values are joined") {
+		severity = findings.SeverityLow
+	}
+	var lines []string
+	lines = append(lines, param.Reasons...)
+	for _, test := range param.Tests {
+		lines = append(lines, fmt.Sprintf("%s: %s (status %d, %d bytes)", test.Variant, test.Behavior, test.Status, test.Length))
+	}
+	err := t.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      stored.Target.Hostname(),
+		URL:       stored.Target.String(),
+		RequestID: requestID,
+		Title:     fmt.Sprintf("Inconsistent parsing of duplicated %s parameter %s", param.Location, param.Name),
+		Severity:  severity,
+		Detail:    strings.Join(lines, "\n"),
+		Key:       stored.Method + "|" + stored.Target.Host + stored.Target.Path + "|" + param.Location + "|" + param.Name,
+	})
+	if err != nil {
+		log.Printf("Failed to record parameter pollution finding: %v", err)
+	}
+}
+
+// classify tells which value a response to a polluted request acted on
+func classify(resp, baseline, control *response, values []string) string {
+	joined := values[0] + "," + values[1]
+	if bytes.Contains(resp.body, []byte(joined)) || bytes.Contains(resp.body, []byte(values[1]+","+values[0])) {
+		return BehaviorConcatenated
+	}
+	switch {
+	case same(resp, baseline, values):
+		return BehaviorOriginal
+	case same(resp, control, values):
+		return BehaviorInjected
+	case resp.status >= 400 && resp.status != baseline.status && resp.status != control.status:
+		return BehaviorError
+	}
+	return BehaviorDifferent
+}
+
+// same reports whether two responses are the same answer, ignoring where
+// they reflect the parameter values
+func same(a, b *response, values []string) bool {
+	if a.status != b.status {
+		return false
+	}
+	bodyA, bodyB := a.body, b.body
+	for _, value := range values {
+		if len(value) < 3 {
+			continue
+		}
+		bodyA = bytes.ReplaceAll(bodyA, []byte(value), nil)
+		bodyB = bytes.ReplaceAll(bodyB, []byte(value), nil)
+	}
+	if bytes.Equal(bodyA, bodyB) {
+		return true
+	}
+	shorter, longer := len(bodyA), len(bodyB)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	// Allow for small dynamic parts such as tokens and timestamps
+	return longer > 0 && float64(longer-shorter)/float64(longer) < 0.02
+}
+
+// insertAt returns a copy of pairs with p inserted at index
+func insertAt(pairs []probe.Pair, index int, p probe.Pair) []probe.Pair {
+	out := make([]probe.Pair, 0, len(pairs)+1)
+	out = append(out, pairs[:index]...)
+	out = append(out, p)
+	return append(out, pairs[index:]...)
+}
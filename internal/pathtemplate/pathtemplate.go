@@ -0,0 +1,100 @@
+package pathtemplate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Placeholders standing for the variable segments of a path
+const (
+	ID    = "{id}"    // a number
+	UUID  = "{uuid}"  // a UUID
+	Hash  = "{hash}"  // a long hexadecimal string, such as a digest or an object ID
+	Token = "{token}" // a long random-looking string
+	Param = "{param}" // a segment already written as a parameter, as in :id or {id}
+)
+
+var (
+	numberPattern = regexp.MustCompile(`^[0-9]+$`)
+	uuidPattern   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashPattern   = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	tokenPattern  = regexp.MustCompile(`^[A-Za-z0-9_\-]{20,}$`)
+)
+
+// Template replaces the variable segments of a path with placeholders, so
+// /users/123/orders/456 becomes /users/{id}/orders/{id}
+func Template(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = Segment(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Segment returns the placeholder of a variable path segment, or the segment
+// itself. An extension is kept, so 42.json becomes {id}.json.
+func Segment(part string) string {
+	if part == "" {
+		return part
+	}
+	if strings.Contains(part, ":") || (strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")) {
+		return Param
+	}
+	name, extension := part, ""
+	if dot := strings.LastIndexByte(part, '.'); dot > 0 && len(part)-dot <= 6 {
+		name, extension = part[:dot], part[dot:]
+	}
+	switch {
+	case numberPattern.MatchString(name):
+		return ID + extension
+	case uuidPattern.MatchString(name):
+		return UUID + extension
+	case hashPattern.MatchString(name) && strings.ContainsAny(name, "0123456789"):
+		return Hash + extension
+	case tokenPattern.MatchString(name) && randomLooking(name):
+		return Token + extension
+	}
+	return part
+}
+
+// IsTemplate reports whether a path holds placeholders
+func IsTemplate(path string) bool {
+	for _, part := range strings.Split(path, "/") {
+		name := part
+		if dot := strings.LastIndexByte(part, '.'); dot > 0 {
+			name = part[:dot]
+		}
+		switch name {
+		case ID, UUID, Hash, Token, Param:
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether a path falls under a template, or is the path
+// itself when it holds no placeholders
+func Matches(template, path string) bool {
+	return path == template || Template(path) == template
+}
+
+// randomLooking tells identifiers from words joined by dashes, as in the
+// slugs of articles: it needs digits mixed with letters of both cases, or
+// digits making up a quarter of the segment
+func randomLooking(segment string) bool {
+	var digits, upper, lower int
+	for _, c := range segment {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'A' && c <= 'Z':
+			upper++
+		case c >= 'a' && c <= 'z':
+			lower++
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	return (upper > 0 && lower > 0) || digits*4 >= len(segment)
+}
@@ -0,0 +1,387 @@
+package pdfinspect
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Limits keeping inspection cheap on large or hostile documents
+const (
+	maxStreams      = 2000
+	maxStreamSize   = 1024 * 1024
+	maxDecodedTotal = 16 * 1024 * 1024
+	maxValueLength  = 256
+)
+
+// infoKeys are the document information entries worth reporting
+var infoKeys = []string{"Title", "Author", "Subject", "Keywords", "Creator", "Producer", "CreationDate", "ModDate"}
+
+var (
+	versionPattern = regexp.MustCompile(`^%PDF-(\d\.\d)`)
+	// namePattern finds names, which may hide letters as #xx escapes
+	namePattern   = regexp.MustCompile(`/(?:[A-Za-z0-9_.+-]|#[0-9A-Fa-f]{2})+`)
+	streamPattern = regexp.MustCompile(`(?s)<<(.{0,1000}?)>>\s*stream\r?\n`)
+	uriPattern    = regexp.MustCompile(`/URI\s*\(`)
+)
+
+// Inspection describes the metadata and active content of a PDF document
+type Inspection struct {
+	Version    string            `json:"version,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	JavaScript bool              `json:"javascript"`
+	Launch     bool              `json:"launch"`
+	OpenAction bool              `json:"openAction"`
+	Embedded   bool              `json:"embeddedFiles"`
+	Forms      bool              `json:"forms"`
+	Encrypted  bool              `json:"encrypted"`
+	Obfuscated bool              `json:"obfuscated"` // names written with #xx escapes
+	URIs       int               `json:"uris"`
+}
+
+// Active reports whether the document runs JavaScript or launches programs
+func (i *Inspection) Active() bool {
+	return i != nil && (i.JavaScript || i.Launch)
+}
+
+// HasMetadata reports whether the document names who or what produced it
+func (i *Inspection) HasMetadata() bool {
+	return i != nil && len(i.Metadata) > 0
+}
+
+// Summary describes the inspection for a finding
+func (i *Inspection) Summary() string {
+	var parts []string
+	for _, key := range infoKeys {
+		if value := i.Metadata[key]; value != "" {
+			parts = append(parts, key+": "+value)
+		}
+	}
+	flags := []struct {
+		set  bool
+		text string
+	}{
+		{i.JavaScript, "embedded JavaScript"},
+		{i.Launch, "launch actions"},
+		{i.OpenAction, "an action run on open"},
+		{i.Embedded, "embedded files"},
+		{i.Forms, "interactive forms"},
+		{i.Encrypted, "encryption"},
+		{i.Obfuscated, "names obfuscated with #xx escapes"},
+	}
+	for _, flag := range flags {
+		if flag.set {
+			parts = append(parts, "Contains "+flag.text)
+		}
+	}
+	if i.URIs > 0 {
+		parts = append(parts, fmt.Sprintf("%d URI links", i.URIs))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Inspect reads the document information, XMP metadata and active content of
+// a PDF body, including what compressed object streams hold. It returns nil
+// when the body is not a PDF.
+func Inspect(body []byte) *Inspection {
+	start := bytes.Index(body[:min(len(body), 1024)], []byte("%PDF-"))
+	if start < 0 {
+		return nil
+	}
+	body = body[start:]
+
+	inspection := &Inspection{Metadata: make(map[string]string)}
+	if match := versionPattern.FindSubmatch(body); match != nil {
+		inspection.Version = string(match[1])
+	}
+
+	inspection.scan(body)
+	decodedTotal := 0
+	for _, match := range streamPattern.FindAllSubmatchIndex(body, maxStreams) {
+		// Images are compressed too but never hold objects
+		dict := body[match[2]:match[3]]
+		if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Contains(dict, []byte("/Image")) {
+			continue
+		}
+		data := body[match[1]:]
+		if end := bytes.Index(data, []byte("endstream")); end >= 0 {
+			data = data[:end]
+		}
+		reader, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		decoded, _ := io.ReadAll(io.LimitReader(reader, maxStreamSize))
+		reader.Close()
+		inspection.scan(decoded)
+		if decodedTotal += len(decoded); decodedTotal > maxDecodedTotal {
+			break
+		}
+	}
+
+	if len(inspection.Metadata) == 0 {
+		inspection.Metadata = nil
+	}
+	return inspection
+}
+
+// scan looks for active content names and metadata values in raw or decoded
+// PDF content
+func (i *Inspection) scan(data []byte) {
+	for _, raw := range namePattern.FindAll(data, -1) {
+		name := string(raw[1:])
+		if strings.Contains(name, "#") {
+			decoded, ok := decodeName(name)
+			if !ok {
+				continue
+			}
+			if isActiveName(decoded) {
+				i.Obfuscated = true
+			}
+			name = decoded
+		}
+		switch name {
+		case "JavaScript", "JS":
+			i.JavaScript = true
+		case "Launch":
+			i.Launch = true
+		case "OpenAction", "AA":
+			i.OpenAction = true
+		case "EmbeddedFile", "EmbeddedFiles":
+			i.Embedded = true
+		case "AcroForm", "XFA":
+			i.Forms = true
+		case "Encrypt":
+			i.Encrypted = true
+		}
+	}
+	i.URIs += len(uriPattern.FindAllIndex(data, -1))
+
+	for _, key := range infoKeys {
+		if i.Metadata[key] != "" {
+			continue
+		}
+		if value := infoValue(data, key); value != "" {
+			i.Metadata[key] = value
+		}
+	}
+	i.scanXMP(data)
+}
+
+// isActiveName reports whether a name starts or hides active content
+func isActiveName(name string) bool {
+	switch name {
+	case "JavaScript", "JS", "Launch", "OpenAction", "AA", "EmbeddedFile":
+		return true
+	}
+	return false
+}
+
+// decodeName undoes the #xx escapes of a name
+func decodeName(name string) (string, bool) {
+	var builder strings.Builder
+	for j := 0; j < len(name); j++ {
+		if name[j] == '#' && j+2 < len(name) {
+			value, err := strconv.ParseUint(name[j+1:j+3], 16, 8)
+			if err != nil {
+				return "", false
+			}
+			builder.WriteByte(byte(value))
+			j += 2
+			continue
+		}
+		builder.WriteByte(name[j])
+	}
+	return builder.String(), true
+}
+
+// infoValue reads the string value of a document information entry, written
+// as a literal (string) or a <hex> string
+func infoValue(data []byte, key string) string {
+	marker := []byte("/" + key)
+	for offset := 0; ; {
+		index := bytes.Index(data[offset:], marker)
+		if index < 0 {
+			return ""
+		}
+		rest := data[offset+index+len(marker):]
+		offset += index + len(marker)
+		// Skip longer names sharing the prefix, such as /Creator in /CreatorTool
+		if len(rest) > 0 && (rest[0] >= 'A' && rest[0] <= 'Z' || rest[0] >= 'a' && rest[0] <= 'z') {
+			continue
+		}
+		rest = bytes.TrimLeft(rest, " \t\r\n")
+		var value []byte
+		switch {
+		case bytes.HasPrefix(rest, []byte("(")):
+			value = literalString(rest[1:])
+		case bytes.HasPrefix(rest, []byte("<")) && !bytes.HasPrefix(rest, []byte("<<")):
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				continue
+			}
+			hexDigits := strings.Join(strings.Fields(string(rest[1:end])), "")
+			if len(hexDigits)%2 == 1 {
+				hexDigits += "0"
+			}
+			decoded, err := hex.DecodeString(hexDigits)
+			if err != nil {
+				continue
+			}
+			value = decoded
+		default:
+			continue
+		}
+		if text := cleanValue(textString(value)); text != "" {
+			return text
+		}
+	}
+}
+
+// literalString reads a literal string up to its closing parenthesis,
+// undoing escapes
+func literalString(data []byte) []byte {
+	var out []byte
+	depth := 0
+	for j := 0; j < len(data); j++ {
+		c := data[j]
+		switch {
+		case c == '\\' && j+1 < len(data):
+			j++
+			switch e := data[j]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					end := j + 1
+					for end < len(data) && end < j+3 && data[end] >= '0' && data[end] <= '7' {
+						end++
+					}
+					value, _ := strconv.ParseUint(string(data[j:end]), 8, 8)
+					out = append(out, byte(value))
+					j = end - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+		case c == '(':
+			depth++
+			out = append(out, c)
+		case c == ')':
+			if depth == 0 {
+				return out
+			}
+			depth--
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+		if len(out) > maxValueLength*4 {
+			return out
+		}
+	}
+	return out
+}
+
+// textString decodes a PDF text string, UTF-16BE with a byte order mark or
+// PDFDocEncoding, which matches Latin-1 for printable text
+func textString(value []byte) string {
+	if len(value) >= 2 && value[0] == 0xFE && value[1] == 0xFF {
+		units := make([]uint16, 0, len(value)/2)
+		for j := 2; j+1 < len(value); j += 2 {
+			units = append(units, uint16(value[j])<<8|uint16(value[j+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(value))
+	for j, b := range value {
+		runes[j] = rune(b)
+	}
+	return string(runes)
+}
+
+// cleanValue trims a value and drops control characters
+func cleanValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.TrimSpace(value)
+	if len(value) > maxValueLength {
+		value = value[:maxValueLength]
+	}
+	return value
+}
+
+// xmpProperties maps XMP properties to the document information keys
+var xmpProperties = map[string]string{
+	"dc:creator":      "Author",
+	"dc:title":        "Title",
+	"pdf:Producer":    "Producer",
+	"xmp:CreatorTool": "Creator",
+	"xmp:CreateDate":  "CreationDate",
+	"xmp:ModifyDate":  "ModDate",
+	"pdf:Keywords":    "Keywords",
+}
+
+var (
+	xmpElement   = regexp.MustCompile(`(?s)<([A-Za-z]+:[A-Za-z]+)>(.*?)</[A-Za-z]+:[A-Za-z]+>`)
+	xmpAttribute = regexp.MustCompile(`\s([A-Za-z]+:[A-Za-z]+)="([^"]*)"`)
+	xmlTag       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// scanXMP fills the metadata missing from the document information with the
+// XMP packet, which newer producers write instead
+func (i *Inspection) scanXMP(data []byte) {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return
+	}
+	packet := data[start:]
+	if end := bytes.Index(packet, []byte("</x:xmpmeta>")); end >= 0 {
+		packet = packet[:end]
+	}
+
+	values := make(map[string]string)
+	for _, match := range xmpAttribute.FindAllSubmatch(packet, -1) {
+		values[string(match[1])] = string(match[2])
+	}
+	for _, match := range xmpElement.FindAllSubmatch(packet, -1) {
+		// dc:creator and dc:title wrap their values in rdf lists
+		values[string(match[1])] = strings.Join(strings.Fields(xmlTag.ReplaceAllString(string(match[2]), " ")), " ")
+	}
+
+	keys := make([]string, 0, len(values))
+	for property := range values {
+		keys = append(keys, property)
+	}
+	sort.Strings(keys)
+	for _, property := range keys {
+		key, ok := xmpProperties[property]
+		if !ok || i.Metadata[key] != "" {
+			continue
+		}
+		if value := cleanValue(values[property]); value != "" {
+			i.Metadata[key] = value
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	storage "prokzee/internal/storage"
 )
 
 // Plugin represents a plugin in the system
@@ -18,6 +20,10 @@ type Plugin struct {
 	Version     string `json:"version"`
 	Author      string `json:"author"`
 	CreatedAt   string `json:"created_at"`
+	// Result of the static scan of Code, see ScanCode
+	ScanVerdict   string `json:"scan_verdict"`
+	ScanReport    string `json:"scan_report"`
+	ScanConfirmed bool   `json:"scan_confirmed"`
 }
 
 // Client handles plugin operations
@@ -36,6 +42,15 @@ func NewClient(db *sql.DB) (*Client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to ensure plugins table exists: %v", err)
 	}
+	for column, definition := range map[string]string{
+		"scan_verdict":   "TEXT DEFAULT ''",
+		"scan_report":    "TEXT DEFAULT ''",
+		"scan_confirmed": "INTEGER NOT NULL DEFAULT 0",
+	} {
+		if err := storage.EnsureColumn(db, "plugins", column, definition); err != nil {
+			return nil, err
+		}
+	}
 
 	return client, nil
 }
@@ -58,7 +73,10 @@ func (c *Client) ensurePluginsTableExists() error {
 				template TEXT,
 				version TEXT,
 				author TEXT,
-				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				scan_verdict TEXT DEFAULT '',
+				scan_report TEXT DEFAULT '',
+				scan_confirmed INTEGER NOT NULL DEFAULT 0
 			)
 		`)
 		return err
@@ -147,7 +165,10 @@ func (c *Client) ensurePluginsTableExists() error {
 
 // LoadPlugins loads all plugins from the database
 func (c *Client) LoadPlugins() ([]Plugin, error) {
-	rows, err := c.db.Query("SELECT id, name, description, is_active, code, template, version, author, created_at FROM plugins")
+	rows, err := c.db.Query(`
+		SELECT id, name, description, is_active, code, template, version, author, created_at,
+			COALESCE(scan_verdict, ''), COALESCE(scan_report, ''), scan_confirmed
+		FROM plugins`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query plugins: %v", err)
 	}
@@ -158,7 +179,8 @@ func (c *Client) LoadPlugins() ([]Plugin, error) {
 		var p Plugin
 		var createdAt sql.NullString
 		var isActive sql.NullInt64 // Use NullInt64 to handle potential NULL values
-		err := rows.Scan(&p.ID, &p.Name, &p.Description, &isActive, &p.Code, &p.Template, &p.Version, &p.Author, &createdAt)
+		err := rows.Scan(&p.ID, &p.Name, &p.Description, &isActive, &p.Code, &p.Template, &p.Version, &p.Author, &createdAt,
+			&p.ScanVerdict, &p.ScanReport, &p.ScanConfirmed)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan plugin: %v", err)
 		}
@@ -187,10 +209,18 @@ func (c *Client) SavePlugin(pluginData string) (*Plugin, error) {
 		plugin.CreatedAt = time.Now().Format(time.RFC3339)
 	}
 
+	// New plugins are never confirmed, so suspicious ones are stored inactive
+	applyScan(&plugin)
+	plugin.ScanConfirmed = false
+	if plugin.ScanVerdict == VerdictSuspicious {
+		plugin.IsActive = false
+	}
+
 	result, err := c.db.Exec(`
-		INSERT INTO plugins (name, description, is_active, code, template, version, author, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, plugin.Name, plugin.Description, plugin.IsActive, plugin.Code, plugin.Template, plugin.Version, plugin.Author, plugin.CreatedAt)
+		INSERT INTO plugins (name, description, is_active, code, template, version, author, created_at, scan_verdict, scan_report, scan_confirmed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)
+	`, plugin.Name, plugin.Description, plugin.IsActive, plugin.Code, plugin.Template, plugin.Version, plugin.Author, plugin.CreatedAt,
+		plugin.ScanVerdict, plugin.ScanReport)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert plugin: %v", err)
 	}
@@ -228,7 +258,7 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 	var currentPlugin Plugin
 	var isActive sql.NullInt64 // Use NullInt64 to handle potential NULL values
 	err = tx.QueryRow(`
-		SELECT id, name, description, is_active, code, template, version, author, created_at
+		SELECT id, name, description, is_active, code, template, version, author, created_at, scan_confirmed
 		FROM plugins WHERE id = ?
 	`, plugin.ID).Scan(
 		&currentPlugin.ID,
@@ -240,6 +270,7 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 		&currentPlugin.Version,
 		&currentPlugin.Author,
 		&currentPlugin.CreatedAt,
+		&currentPlugin.ScanConfirmed,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch current plugin state: %v", err)
@@ -251,6 +282,14 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 	fmt.Printf("Requested state change for plugin %d: isActive=%v\n",
 		plugin.ID, plugin.IsActive)
 
+	// Scan the code being stored. A confirmation only covers the code it was
+	// given for, so changed code has to be confirmed again.
+	applyScan(&plugin)
+	plugin.ScanConfirmed = currentPlugin.ScanConfirmed && plugin.Code == currentPlugin.Code
+	if plugin.IsActive && plugin.ScanVerdict == VerdictSuspicious && !plugin.ScanConfirmed {
+		return nil, ErrConfirmationRequired
+	}
+
 	// Convert bool to int for SQLite
 	isActiveInt := 0
 	if plugin.IsActive {
@@ -265,12 +304,12 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 
 		fmt.Printf("Performing toggle operation for plugin %d\n", plugin.ID)
 
-		// Update only the is_active field
+		// Update only the is_active field and the scan of plugins stored before scanning existed
 		result, err := tx.Exec(`
 			UPDATE plugins 
-			SET is_active = ?
+			SET is_active = ?, scan_verdict = ?, scan_report = ?
 			WHERE id = ?
-		`, isActiveInt, plugin.ID)
+		`, isActiveInt, plugin.ScanVerdict, plugin.ScanReport, plugin.ID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update plugin active state: %v", err)
 		}
@@ -291,9 +330,11 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 		// This is a full update - keep original version and author
 		result, err := tx.Exec(`
 			UPDATE plugins 
-			SET name = ?, description = ?, is_active = ?, code = ?, template = ?, version = ?, author = ?
+			SET name = ?, description = ?, is_active = ?, code = ?, template = ?, version = ?, author = ?,
+				scan_verdict = ?, scan_report = ?, scan_confirmed = ?
 			WHERE id = ?
-		`, plugin.Name, plugin.Description, isActiveInt, plugin.Code, plugin.Template, currentPlugin.Version, currentPlugin.Author, plugin.ID)
+		`, plugin.Name, plugin.Description, isActiveInt, plugin.Code, plugin.Template, currentPlugin.Version, currentPlugin.Author,
+			plugin.ScanVerdict, plugin.ScanReport, plugin.ScanConfirmed, plugin.ID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update plugin: %v", err)
 		}
@@ -314,7 +355,8 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 	var updatedPlugin Plugin
 	var finalIsActive sql.NullInt64
 	err = tx.QueryRow(`
-		SELECT id, name, description, is_active, code, template, version, author, created_at
+		SELECT id, name, description, is_active, code, template, version, author, created_at,
+			COALESCE(scan_verdict, ''), COALESCE(scan_report, ''), scan_confirmed
 		FROM plugins WHERE id = ?
 	`, plugin.ID).Scan(
 		&updatedPlugin.ID,
@@ -326,6 +368,9 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 		&updatedPlugin.Version,
 		&updatedPlugin.Author,
 		&updatedPlugin.CreatedAt,
+		&updatedPlugin.ScanVerdict,
+		&updatedPlugin.ScanReport,
+		&updatedPlugin.ScanConfirmed,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify updated plugin state: %v", err)
@@ -344,6 +389,59 @@ func (c *Client) UpdatePlugin(pluginData string) (*Plugin, error) {
 	return &updatedPlugin, nil
 }
 
+// ConfirmPlugin records that the user accepted the scan verdict of a plugin's
+// current code and activates it
+func (c *Client) ConfirmPlugin(pluginID int) (*Plugin, error) {
+	var code string
+	if err := c.db.QueryRow("SELECT COALESCE(code, '') FROM plugins WHERE id = ?", pluginID).Scan(&code); err != nil {
+		return nil, fmt.Errorf("failed to fetch plugin: %v", err)
+	}
+
+	plugin := Plugin{Code: code}
+	applyScan(&plugin)
+	_, err := c.db.Exec(`
+		UPDATE plugins
+		SET is_active = 1, scan_verdict = ?, scan_report = ?, scan_confirmed = 1
+		WHERE id = ?
+	`, plugin.ScanVerdict, plugin.ScanReport, pluginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm plugin: %v", err)
+	}
+
+	return c.GetPlugin(pluginID)
+}
+
+// GetPlugin loads a single plugin from the database
+func (c *Client) GetPlugin(pluginID int) (*Plugin, error) {
+	var p Plugin
+	var createdAt sql.NullString
+	var isActive sql.NullInt64
+	err := c.db.QueryRow(`
+		SELECT id, name, description, is_active, code, template, version, author, created_at,
+			COALESCE(scan_verdict, ''), COALESCE(scan_report, ''), scan_confirmed
+		FROM plugins WHERE id = ?
+	`, pluginID).Scan(&p.ID, &p.Name, &p.Description, &isActive, &p.Code, &p.Template, &p.Version, &p.Author, &createdAt,
+		&p.ScanVerdict, &p.ScanReport, &p.ScanConfirmed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch plugin: %v", err)
+	}
+	p.CreatedAt = createdAt.String
+	p.IsActive = isActive.Valid && isActive.Int64 == 1
+	return &p, nil
+}
+
+// applyScan scans the plugin code and stores the verdict on the plugin
+func applyScan(p *Plugin) {
+	result := ScanCode(p.Code)
+	p.ScanVerdict = result.Verdict
+	report, err := json.Marshal(result)
+	if err != nil {
+		p.ScanReport = ""
+		return
+	}
+	p.ScanReport = string(report)
+}
+
 // DeletePlugin deletes a plugin from the database
 func (c *Client) DeletePlugin(pluginID int) error {
 	_, err := c.db.Exec("DELETE FROM plugins WHERE id = ?", pluginID)
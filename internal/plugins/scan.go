@@ -0,0 +1,125 @@
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Scan verdicts stored with each plugin
+const (
+	VerdictClean      = "clean"
+	VerdictSuspicious = "suspicious"
+)
+
+// ErrConfirmationRequired is returned when a suspicious plugin is activated
+// before the user confirmed its scan verdict
+var ErrConfirmationRequired = errors.New("plugin scan found dangerous patterns, confirmation required before activation")
+
+// ScanIssue is a dangerous pattern found in plugin code
+type ScanIssue struct {
+	Rule    string `json:"rule"`
+	Line    int    `json:"line"`
+	Snippet string `json:"snippet"`
+}
+
+// ScanResult is the verdict of a static scan of plugin code
+type ScanResult struct {
+	Verdict  string      `json:"verdict"`
+	Issues   []ScanIssue `json:"issues"`
+	CodeHash string      `json:"codeHash"`
+}
+
+// scanRule flags a single dangerous construct
+type scanRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var scanRules = []scanRule{
+	{"eval call", regexp.MustCompile(`\beval\s*\(`)},
+	{"Function constructor", regexp.MustCompile(`\bnew\s+Function\s*\(|\bFunction\s*\(\s*["'\x60]`)},
+	{"string passed to timer", regexp.MustCompile(`\bset(?:Timeout|Interval)\s*\(\s*["'\x60]`)},
+	{"script injection", regexp.MustCompile(`(?i)document\.write\s*\(.*<script|createElement\s*\(\s*["']script["']`)},
+	{"worker script import", regexp.MustCompile(`\bimportScripts\s*\(`)},
+	{"dynamic import", regexp.MustCompile(`\bimport\s*\(`)},
+	{"obfuscated payload", regexp.MustCompile(`\batob\s*\(|\\x[0-9a-fA-F]{2}(?:\\x[0-9a-fA-F]{2}){15,}`)},
+}
+
+// networkPattern finds network calls and the URL literal they use, if any
+var networkPattern = regexp.MustCompile(`\b(?:fetch|new\s+WebSocket|new\s+EventSource|\.open|sendBeacon)\s*\(\s*(?:["'\x60]([^"'\x60]*)["'\x60])?`)
+
+// allowedOrigins are hosts plugins may talk to without being flagged
+var allowedOrigins = map[string]bool{
+	"localhost": true,
+	"127.0.0.1": true,
+	"prokzee":   true,
+}
+
+// ScanCode statically scans plugin JavaScript for dangerous APIs: code
+// evaluation, script injection and network calls to unexpected origins.
+// Fetching content and evaluating it is flagged as remote code execution.
+func ScanCode(code string) ScanResult {
+	sum := sha256.Sum256([]byte(code))
+	result := ScanResult{Verdict: VerdictClean, Issues: []ScanIssue{}, CodeHash: hex.EncodeToString(sum[:])}
+
+	evaluates, fetches := false, false
+	for i, line := range strings.Split(code, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "//") {
+			continue
+		}
+
+		for _, rule := range scanRules {
+			if rule.pattern.MatchString(line) {
+				result.Issues = append(result.Issues, ScanIssue{Rule: rule.name, Line: i + 1, Snippet: snippet(trimmed)})
+				if rule.name == "eval call" || rule.name == "Function constructor" {
+					evaluates = true
+				}
+			}
+		}
+
+		for _, match := range networkPattern.FindAllStringSubmatch(line, -1) {
+			fetches = true
+			target := match[1]
+			switch {
+			case target == "":
+				result.Issues = append(result.Issues, ScanIssue{Rule: "network call to computed URL", Line: i + 1, Snippet: snippet(trimmed)})
+			case !allowedTarget(target):
+				result.Issues = append(result.Issues, ScanIssue{Rule: "network call to unexpected origin", Line: i + 1, Snippet: snippet(trimmed)})
+			}
+		}
+	}
+
+	if evaluates && fetches {
+		result.Issues = append(result.Issues, ScanIssue{Rule: "possible evaluation of remote content"})
+	}
+	if len(result.Issues) > 0 {
+		result.Verdict = VerdictSuspicious
+	}
+	return result
+}
+
+// allowedTarget reports whether a URL literal stays on an allowed origin.
+// Relative URLs are allowed.
+func allowedTarget(target string) bool {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	if parsed.Host == "" {
+		return !strings.HasPrefix(target, "//")
+	}
+	return allowedOrigins[parsed.Hostname()]
+}
+
+// snippet shortens a line of code for display
+func snippet(line string) string {
+	if len(line) > 120 {
+		return line[:117] + "..."
+	}
+	return line
+}
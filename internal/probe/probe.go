@@ -0,0 +1,252 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Parameter locations
+const (
+	LocationQuery = "query"
+	LocationBody  = "body" // a form encoded body parameter
+)
+
+// Pair is a name=value pair of a query, form body or Cookie header, kept raw
+// and in order
+type Pair struct {
+	Name, Value string
+}
+
+// Request is a stored request the probes are derived from. Its headers leave
+// out Host, Content-Length and, as the body is stored decoded,
+// Content-Encoding.
+type Request struct {
+	Method         string
+	Target         *url.URL
+	Header         http.Header
+	Host           string // the stored Host header, "" when there was none
+	Body           string
+	Query          []Pair
+	Form           []Pair // nil unless the body is form encoded
+	ResponseHeader http.Header
+}
+
+// Load reads a stored request and splits its query and form body
+func Load(db *sql.DB, requestID int) (*Request, error) {
+	var method, rawURL, rawHeaders, body, encoding, rawResponseHeaders string
+	err := db.QueryRow(`
+		SELECT method, COALESCE(url, ''), COALESCE(request_headers, ''), COALESCE(request_body, ''),
+			COALESCE(request_encoding, ''), COALESCE(response_headers, '')
+		FROM requests WHERE id = ?
+	`, requestID).Scan(&method, &rawURL, &rawHeaders, &body, &encoding, &rawResponseHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load request %d: %v", requestID, err)
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid request URL %q", rawURL)
+	}
+
+	headers := http.Header{}
+	if rawHeaders != "" {
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			return nil, fmt.Errorf("failed to parse request headers: %v", err)
+		}
+	}
+	headers.Del("Content-Length")
+	// The body is stored decoded
+	if encoding != "" {
+		headers.Del("Content-Encoding")
+	}
+	host := headers.Get("Host")
+	headers.Del("Host")
+
+	responseHeaders := http.Header{}
+	if rawResponseHeaders != "" {
+		json.Unmarshal([]byte(rawResponseHeaders), &responseHeaders)
+	}
+
+	stored := &Request{
+		Method:         method,
+		Target:         target,
+		Header:         headers,
+		Host:           host,
+		Body:           body,
+		Query:          SplitPairs(target.RawQuery, "&"),
+		ResponseHeader: responseHeaders,
+	}
+	if strings.HasPrefix(strings.ToLower(headers.Get("Content-Type")), "application/x-www-form-urlencoded") {
+		stored.Form = SplitPairs(body, "&")
+		if stored.Form == nil {
+			stored.Form = []Pair{}
+		}
+	}
+	return stored, nil
+}
+
+// LoadParams reads a stored request that has query or form body parameters
+func LoadParams(db *sql.DB, requestID int) (*Request, error) {
+	stored, err := Load(db, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored.Query) == 0 && len(stored.Form) == 0 {
+		return nil, fmt.Errorf("request %d has no query or form body parameters", requestID)
+	}
+	return stored, nil
+}
+
+// WithParams returns the target and body of the request with the given query
+// and form body. The body is only replaced when it is form encoded.
+func (r *Request) WithParams(query, form []Pair) (*url.URL, string) {
+	target := *r.Target
+	target.RawQuery = JoinPairs(query, "&")
+	body := r.Body
+	if r.Form != nil {
+		body = JoinPairs(form, "&")
+	}
+	return &target, body
+}
+
+// NewHTTPRequest builds a replay of the request with another method, target
+// and body. The response body is asked for as is, so it can be searched and
+// compared.
+func (r *Request) NewHTTPRequest(ctx context.Context, method string, target *url.URL, body string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	if r.Host != "" {
+		req.Host = r.Host
+	}
+	req.Header.Del("Accept-Encoding")
+	return req, nil
+}
+
+// Send replays the request with the given query and form body and reads up
+// to limit bytes of the response body
+func (r *Request) Send(ctx context.Context, client *http.Client, query, form []Pair, limit int64) (int, []byte, error) {
+	target, body := r.WithParams(query, form)
+	req, err := r.NewHTTPRequest(ctx, r.Method, target, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, respBody, err := Do(client, req, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// SendVariant replays the request with the pairs of one location replaced
+func (r *Request) SendVariant(ctx context.Context, client *http.Client, location string, pairs []Pair, limit int64) (int, []byte, error) {
+	if location == LocationBody {
+		return r.Send(ctx, client, r.Query, pairs, limit)
+	}
+	return r.Send(ctx, client, pairs, r.Form, limit)
+}
+
+// Authority returns the host the request is sent to: its stored Host header,
+// or the host of its URL
+func (r *Request) Authority() string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.Target.Host
+}
+
+// HasBody reports whether the request is sent with a Content-Length
+func (r *Request) HasBody() bool {
+	return r.Body != "" || r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch
+}
+
+// HTTP1 serializes the request for HTTP/1.1 with the given Connection header,
+// its headers sorted by name
+func (r *Request) HTTP1(connection string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", r.Method, r.Target.RequestURI(), r.Authority())
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	if r.HasBody() {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(r.Body))
+	}
+	fmt.Fprintf(&b, "Connection: %s\r\n\r\n", connection)
+	b.WriteString(r.Body)
+	return b.Bytes()
+}
+
+// Do sends req and reads up to limit bytes of the response body
+func Do(client *http.Client, req *http.Request, limit int64) (*http.Response, []byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, limit))
+	return resp, body, nil
+}
+
+// SplitPairs splits a raw query, form body or Cookie header into its pairs,
+// undecoded
+func SplitPairs(raw, separator string) []Pair {
+	var pairs []Pair
+	for _, part := range strings.Split(raw, separator) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		pairs = append(pairs, Pair{Name: name, Value: value})
+	}
+	return pairs
+}
+
+// JoinPairs joins pairs back into a raw query, form body or Cookie header
+func JoinPairs(pairs []Pair, separator string) string {
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = p.Name + "=" + p.Value
+	}
+	return strings.Join(parts, separator)
+}
+
+// ReplaceAt returns a copy of pairs with the pair at index replaced by p
+func ReplaceAt(pairs []Pair, index int, p Pair) []Pair {
+	out := append([]Pair{}, pairs...)
+	out[index] = p
+	return out
+}
+
+// Decode undoes the URL encoding of a raw value
+func Decode(value string) string {
+	if decoded, err := url.QueryUnescape(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+// Token returns a short random hex string, for canaries and cache busters
+func Token() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -10,6 +10,9 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"prokzee/internal/settings"
+	"prokzee/internal/setup"
 )
 
 // Client represents the projects client
@@ -22,22 +25,8 @@ type Client struct {
 
 // NewClient creates a new projects client
 func NewClient(ctx context.Context, db *sql.DB, dbMutex *sync.RWMutex) *Client {
-	// Get the appropriate config directory for the current OS
-	configDir, err := os.UserConfigDir()
-	if err != nil {
-		log.Printf("Error getting user config directory: %v, falling back to home directory", err)
-		homeDir, homeDirErr := os.UserHomeDir()
-		if homeDirErr != nil {
-			log.Printf("Error getting user home directory: %v, using current directory", homeDirErr)
-			configDir = "."
-		} else {
-			configDir = homeDir
-		}
-	}
-
-	// Create a dedicated app data directory
-	appDataDir := filepath.Join(configDir, "ProKZee")
-	projectsDir := filepath.Join(appDataDir, "projects")
+	// Use the data directory chosen in the first-run wizard, or the default one
+	projectsDir := setup.ProjectsDir(setup.DataDir())
 
 	// Ensure projects directory exists with proper permissions
 	if err := os.MkdirAll(projectsDir, 0755); err != nil {
@@ -59,6 +48,21 @@ func NewClient(ctx context.Context, db *sql.DB, dbMutex *sync.RWMutex) *Client {
 	}
 }
 
+// ProjectsDir returns the directory the project databases are stored in
+func (c *Client) ProjectsDir() string {
+	return c.projectsDir
+}
+
+// SetProjectsDir moves where projects are listed, created and opened. The
+// databases in the previous directory are left in place.
+func (c *Client) SetProjectsDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create projects directory: %v", err)
+	}
+	c.projectsDir = dir
+	return nil
+}
+
 // ListProjects returns a list of all available projects
 func (c *Client) ListProjects() ([]string, error) {
 	// Ensure the projects directory exists
@@ -122,7 +126,20 @@ func (c *Client) CreateNewProject(projectName string) error {
 			query TEXT DEFAULT '',
 			domain TEXT DEFAULT '',
 			length INTEGER DEFAULT 0,
-			mime_type TEXT DEFAULT ''
+			mime_type TEXT DEFAULT '',
+			transfer_info TEXT DEFAULT '',
+			content_changed INTEGER DEFAULT 0,
+			body_redacted INTEGER DEFAULT 0,
+			sniffed_type TEXT DEFAULT '',
+			media_metadata TEXT DEFAULT '',
+			archive_info TEXT DEFAULT '',
+			request_encoding TEXT DEFAULT '',
+			response_encoding TEXT DEFAULT '',
+			request_blob TEXT DEFAULT '',
+			response_blob TEXT DEFAULT '',
+			body_truncated INTEGER DEFAULT 0,
+			correlation_id TEXT DEFAULT '',
+			tags TEXT DEFAULT ''
 		);
 
 		CREATE TABLE rules (
@@ -145,6 +162,41 @@ func (c *Client) CreateNewProject(projectName string) error {
 			enabled BOOLEAN
 		);
 
+		CREATE TABLE map_local_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT,
+			method TEXT DEFAULT '',
+			url_pattern TEXT NOT NULL,
+			status_code INTEGER DEFAULT 200,
+			headers TEXT DEFAULT '',
+			body TEXT DEFAULT '',
+			file_path TEXT DEFAULT '',
+			enabled BOOLEAN
+		);
+
+		CREATE TABLE chaos_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT,
+			method TEXT DEFAULT '',
+			url_pattern TEXT NOT NULL,
+			action TEXT NOT NULL,
+			delay_ms INTEGER DEFAULT 0,
+			jitter_ms INTEGER DEFAULT 0,
+			truncate_bytes INTEGER DEFAULT 0,
+			status_code INTEGER DEFAULT 503,
+			probability INTEGER DEFAULT 100,
+			enabled BOOLEAN
+		);
+
+		CREATE TABLE tag_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT,
+			tag TEXT NOT NULL,
+			part TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			enabled BOOLEAN
+		);
+
 		CREATE TABLE scope_lists (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			type TEXT,
@@ -156,9 +208,28 @@ func (c *Client) CreateNewProject(projectName string) error {
 			name varchar DEFAULT 'Tab',
 			request_ids_arr varchar,
 			timestamp datetime,
+			group_name TEXT DEFAULT '',
+			follow_redirects BOOLEAN DEFAULT 0,
+			max_redirects INTEGER DEFAULT 10,
+			show_redirect_chain BOOLEAN DEFAULT 0,
 			PRIMARY KEY (id)
 		);
 
+		CREATE TABLE resender_variables (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			value TEXT DEFAULT '',
+			source TEXT DEFAULT ''
+		);
+
+		CREATE TABLE resender_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			tab_ids TEXT DEFAULT '[]',
+			mode TEXT DEFAULT 'sequential',
+			extractions TEXT DEFAULT '[]'
+		);
+
 		CREATE TABLE resender_requests (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			request_id TEXT,
@@ -176,7 +247,11 @@ func (c *Client) CreateNewProject(projectName string) error {
 			query TEXT DEFAULT '',
 			domain TEXT DEFAULT '',
 			length INTEGER DEFAULT 0,
-			mime_type TEXT DEFAULT ''
+			mime_type TEXT DEFAULT '',
+			negotiated_protocol TEXT DEFAULT '',
+			raw_request TEXT DEFAULT '',
+			raw_response TEXT DEFAULT '',
+			timings TEXT DEFAULT ''
 		);
 
 		CREATE TABLE settings (
@@ -189,6 +264,19 @@ func (c *Client) CreateNewProject(projectName string) error {
 			interactsh_host varchar,
 			interactsh_port int,
 			created_at DATETIME,
+			upstream_proxy varchar DEFAULT '',
+			upstream_proxy_bypass varchar DEFAULT '',
+			tls_passthrough varchar DEFAULT '',
+			host_overrides varchar DEFAULT '',
+			dns_server varchar DEFAULT '',
+			max_stored_body_size INTEGER DEFAULT 0,
+			force_connection_close INTEGER DEFAULT 0,
+			project_ca INTEGER DEFAULT 0,
+			tls_profile varchar DEFAULT '',
+			correlation_header varchar DEFAULT '',
+			bind_address varchar DEFAULT '',
+			max_request_body_size INTEGER DEFAULT 0,
+			max_response_body_size INTEGER DEFAULT 0,
 			PRIMARY KEY (id)
 		);
 
@@ -239,23 +327,38 @@ func (c *Client) CreateNewProject(projectName string) error {
 			template TEXT,
 			version TEXT,
 			author TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			scan_verdict TEXT DEFAULT '',
+			scan_report TEXT DEFAULT '',
+			scan_confirmed INTEGER NOT NULL DEFAULT 0
 		);
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to initialize new database: %v", err)
 	}
 
-	// Initialize default settings
+	// Initialize default settings, seeded from the config file or environment
+	defaults := &settings.Settings{
+		ProjectName:    projectName,
+		OpenAIAPIURL:   "https://api.openai.com/v1/chat/completions",
+		OpenAIAPIKey:   "XXXXXXX",
+		ProxyPort:      "8080",
+		InteractshHost: "oast.fun",
+		InteractshPort: 443,
+	}
+	settings.ApplySeed(defaults)
 	_, err = db.Exec(`
 		INSERT INTO settings (
 			id, project_name, openai_api_url, openai_api_key, proxy_port, 
-			theme, interactsh_host, interactsh_port, created_at
+			theme, interactsh_host, interactsh_port, created_at,
+			upstream_proxy, upstream_proxy_bypass
 		) VALUES (
-			1, ?, 'https://api.openai.com/v1/chat/completions', 'XXXXXXX', '8080',
-			'dark', 'oast.fun', 443, CURRENT_TIMESTAMP
+			1, ?, ?, ?, ?,
+			'dark', ?, ?, CURRENT_TIMESTAMP,
+			?, ?
 		)
-	`, projectName)
+	`, defaults.ProjectName, defaults.OpenAIAPIURL, defaults.OpenAIAPIKey, defaults.ProxyPort,
+		defaults.InteractshHost, defaults.InteractshPort, defaults.UpstreamProxy, defaults.UpstreamProxyBypass)
 	if err != nil {
 		return fmt.Errorf("failed to initialize settings: %v", err)
 	}
@@ -403,17 +506,14 @@ func (c *Client) CreateNewProject(projectName string) error {
 	return nil
 }
 
-// SwitchProject switches to the selected database
-func (c *Client) SwitchProject(dbName string) (*sql.DB, error) {
+// OpenProject opens the database of a project without closing the current
+// one, so the caller can hand over to it and close the old connection once its
+// pending writes are done
+func (c *Client) OpenProject(dbName string) (*sql.DB, error) {
 	dbPath := filepath.Join(c.projectsDir, dbName)
 
-	log.Printf("Switching to database: %s", dbPath)
+	log.Printf("Opening database: %s", dbPath)
 
-	// Lock for database switch
-	c.dbMutex.Lock()
-	defer c.dbMutex.Unlock()
-
-	// Open the new database first before closing the old one
 	newDB, err := sql.Open("sqlite3", dbPath+"?_journal=WAL&_timeout=5000&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open new database: %v", err)
@@ -430,34 +530,5 @@ func (c *Client) SwitchProject(dbName string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to connect to new database: %v", err)
 	}
 
-	// Store the old database connection
-	oldDB := c.db
-
-	// Update the client's database reference
-	c.db = newDB
-
-	// Close the existing database connection if it exists
-	// Do this after setting the new connection to avoid any gap
-	if oldDB != nil {
-		// Wait a moment for any in-flight transactions to complete
-		time.Sleep(time.Second)
-
-		// Close with a timeout to avoid hanging
-		closeComplete := make(chan struct{})
-		go func() {
-			if err := oldDB.Close(); err != nil {
-				log.Printf("Warning: error closing old database connection: %v", err)
-			}
-			close(closeComplete)
-		}()
-
-		select {
-		case <-closeComplete:
-			// Close completed normally
-		case <-time.After(5 * time.Second):
-			log.Printf("Warning: database close operation timed out")
-		}
-	}
-
 	return newDB, nil
 }
@@ -15,7 +15,35 @@ CREATE TABLE IF NOT EXISTS requests (
             query TEXT DEFAULT '',
             domain TEXT DEFAULT '',
             length INTEGER DEFAULT 0,
-            mime_type TEXT DEFAULT ''
+            mime_type TEXT DEFAULT '',
+            transfer_info TEXT DEFAULT '',
+            content_changed INTEGER DEFAULT 0,
+            body_redacted INTEGER DEFAULT 0,
+            sniffed_type TEXT DEFAULT '',
+            media_metadata TEXT DEFAULT '',
+            archive_info TEXT DEFAULT '',
+            request_encoding TEXT DEFAULT '',
+            response_encoding TEXT DEFAULT '',
+            request_blob TEXT DEFAULT '',
+            response_blob TEXT DEFAULT '',
+            body_truncated INTEGER DEFAULT 0,
+            correlation_id TEXT DEFAULT '',
+            tags TEXT DEFAULT ''
+        );
+CREATE TABLE IF NOT EXISTS do_not_log (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            host TEXT NOT NULL,
+            path TEXT DEFAULT ''
+        );
+CREATE TABLE IF NOT EXISTS stream_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            request_id INTEGER NOT NULL,
+            seq INTEGER NOT NULL,
+            event TEXT DEFAULT '',
+            event_id TEXT DEFAULT '',
+            data TEXT DEFAULT '',
+            truncated INTEGER DEFAULT 0,
+            timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
         );
 CREATE TABLE IF NOT EXISTS rules (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -26,6 +54,21 @@ CREATE TABLE IF NOT EXISTS rules (
             pattern TEXT,
             enabled INTEGER DEFAULT 1
         );
+CREATE TABLE IF NOT EXISTS auto_forward_rules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            host TEXT NOT NULL,
+            path TEXT DEFAULT '',
+            method TEXT DEFAULT ''
+        );
+CREATE TABLE IF NOT EXISTS intercept_edits (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            intercept_id TEXT NOT NULL,
+            url TEXT DEFAULT '',
+            original_request TEXT NOT NULL,
+            edited_request TEXT NOT NULL,
+            diff TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );
 CREATE TABLE IF NOT EXISTS match_replace_rules (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			rule_name TEXT,
@@ -35,18 +78,71 @@ CREATE TABLE IF NOT EXISTS match_replace_rules (
 			target TEXT,
 			enabled BOOLEAN
 );
+CREATE TABLE IF NOT EXISTS map_local_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT,
+			method TEXT DEFAULT '',
+			url_pattern TEXT NOT NULL,
+			status_code INTEGER DEFAULT 200,
+			headers TEXT DEFAULT '',
+			body TEXT DEFAULT '',
+			file_path TEXT DEFAULT '',
+			enabled BOOLEAN
+);
+CREATE TABLE IF NOT EXISTS chaos_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT,
+			method TEXT DEFAULT '',
+			url_pattern TEXT NOT NULL,
+			action TEXT NOT NULL,
+			delay_ms INTEGER DEFAULT 0,
+			jitter_ms INTEGER DEFAULT 0,
+			truncate_bytes INTEGER DEFAULT 0,
+			status_code INTEGER DEFAULT 503,
+			probability INTEGER DEFAULT 100,
+			enabled BOOLEAN
+);
+CREATE TABLE IF NOT EXISTS tag_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule_name TEXT,
+			tag TEXT NOT NULL,
+			part TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			enabled BOOLEAN
+);
 CREATE TABLE IF NOT EXISTS scope_lists (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			type TEXT,
 			pattern TEXT
 		);
+CREATE TABLE IF NOT EXISTS scope_sampling (
+			pattern TEXT PRIMARY KEY,
+			sample_rate INTEGER NOT NULL DEFAULT 1
+		);
 CREATE TABLE IF NOT EXISTS resender_tabs (
             id integer,
             name varchar DEFAULT 'Tab',
             request_ids_arr varchar,
             timestamp datetime,
+            group_name TEXT DEFAULT '',
+            follow_redirects BOOLEAN DEFAULT 0,
+            max_redirects INTEGER DEFAULT 10,
+            show_redirect_chain BOOLEAN DEFAULT 0,
             PRIMARY KEY (id)
         );
+CREATE TABLE IF NOT EXISTS resender_variables (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL UNIQUE,
+            value TEXT DEFAULT '',
+            source TEXT DEFAULT ''
+        );
+CREATE TABLE IF NOT EXISTS resender_groups (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT NOT NULL,
+            tab_ids TEXT DEFAULT '[]',
+            mode TEXT DEFAULT 'sequential',
+            extractions TEXT DEFAULT '[]'
+        );
 CREATE TABLE IF NOT EXISTS settings (
             id integer,
             project_name varchar,
@@ -57,6 +153,19 @@ CREATE TABLE IF NOT EXISTS settings (
             interactsh_host varchar,
             interactsh_port int,
             created_at DATETIME,
+            upstream_proxy varchar DEFAULT '',
+            upstream_proxy_bypass varchar DEFAULT '',
+            tls_passthrough varchar DEFAULT '',
+            host_overrides varchar DEFAULT '',
+            dns_server varchar DEFAULT '',
+            max_stored_body_size INTEGER DEFAULT 0,
+            force_connection_close INTEGER DEFAULT 0,
+            project_ca INTEGER DEFAULT 0,
+            tls_profile varchar DEFAULT '',
+            correlation_header varchar DEFAULT '',
+            bind_address varchar DEFAULT '',
+            max_request_body_size INTEGER DEFAULT 0,
+            max_response_body_size INTEGER DEFAULT 0,
             PRIMARY KEY (id)
         );
 CREATE TABLE IF NOT EXISTS chat_contexts (
@@ -110,7 +219,11 @@ CREATE TABLE IF NOT EXISTS resender_requests (
             query TEXT DEFAULT '',
             domain TEXT DEFAULT '',
             length INTEGER DEFAULT 0,
-            mime_type TEXT DEFAULT ''
+            mime_type TEXT DEFAULT '',
+            negotiated_protocol TEXT DEFAULT '',
+            raw_request TEXT DEFAULT '',
+            raw_response TEXT DEFAULT '',
+            timings TEXT DEFAULT ''
         );
 CREATE TABLE IF NOT EXISTS plugins (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -121,5 +234,71 @@ CREATE TABLE IF NOT EXISTS plugins (
             template TEXT,
             version TEXT,
             author TEXT,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            scan_verdict TEXT DEFAULT '',
+            scan_report TEXT DEFAULT '',
+            scan_confirmed INTEGER NOT NULL DEFAULT 0
+        );
+CREATE TABLE IF NOT EXISTS favorites (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            request_id INTEGER NOT NULL UNIQUE,
+            label TEXT DEFAULT '',
+            position INTEGER NOT NULL DEFAULT 0,
             created_at DATETIME DEFAULT CURRENT_TIMESTAMP
         );
+CREATE TABLE IF NOT EXISTS findings (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            source TEXT NOT NULL,
+            host TEXT DEFAULT '',
+            url TEXT DEFAULT '',
+            request_id INTEGER DEFAULT 0,
+            title TEXT NOT NULL,
+            severity TEXT NOT NULL DEFAULT 'info',
+            detail TEXT DEFAULT '',
+            finding_key TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(source, finding_key)
+        );
+CREATE TABLE IF NOT EXISTS endpoint_hashes (
+            endpoint TEXT PRIMARY KEY,
+            hash TEXT NOT NULL,
+            status INTEGER DEFAULT 0,
+            stable_count INTEGER NOT NULL DEFAULT 1,
+            last_request_id INTEGER DEFAULT 0,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );
+CREATE TABLE IF NOT EXISTS host_info (
+            host TEXT NOT NULL,
+            category TEXT NOT NULL,
+            name TEXT NOT NULL,
+            version TEXT DEFAULT '',
+            evidence TEXT DEFAULT '',
+            source TEXT DEFAULT 'passive',
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (host, category, name)
+        );
+CREATE TABLE IF NOT EXISTS fuzzer_checkpoints (
+            tab_id INTEGER PRIMARY KEY,
+            config TEXT NOT NULL,
+            status TEXT NOT NULL,
+            next_index INTEGER NOT NULL DEFAULT 0,
+            total INTEGER NOT NULL DEFAULT 0,
+            elapsed_ms INTEGER NOT NULL DEFAULT 0,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );
+CREATE TABLE IF NOT EXISTS fuzzer_checkpoint_results (
+            tab_id INTEGER NOT NULL,
+            payload_index INTEGER NOT NULL,
+            payload TEXT,
+            status_code TEXT,
+            response_length INTEGER DEFAULT 0,
+            error TEXT DEFAULT '',
+            request_method TEXT DEFAULT '',
+            request_url TEXT DEFAULT '',
+            request_headers TEXT DEFAULT '',
+            request_body TEXT DEFAULT '',
+            raw_request TEXT DEFAULT '',
+            response_headers TEXT DEFAULT '',
+            response_body TEXT DEFAULT '',
+            PRIMARY KEY (tab_id, payload_index)
+        );
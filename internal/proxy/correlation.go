@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"prokzee/internal/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/http/httpguts"
+)
+
+// ParseCorrelationHeader checks a correlation header name and returns it in
+// canonical form, "" when it is empty
+func ParseCorrelationHeader(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name != "" && !httpguts.ValidHeaderFieldName(name) {
+		return "", fmt.Errorf("invalid correlation header name %q", name)
+	}
+	return http.CanonicalHeaderKey(name), nil
+}
+
+// SetCorrelationHeader sets the header that carries a fresh ID upstream with
+// every forwarded request, such as X-ProKZee-ID, or turns it off when name is
+// empty. The stored copy of a request never has the header, its ID is kept
+// with the history entry instead.
+func (p *Proxy) SetCorrelationHeader(name string) error {
+	name, err := ParseCorrelationHeader(name)
+	if err != nil {
+		return err
+	}
+	p.correlationMtx.Lock()
+	p.correlationHeader = name
+	p.correlationMtx.Unlock()
+	return nil
+}
+
+// CorrelationHeader returns the correlation header name, "" when off
+func (p *Proxy) CorrelationHeader() string {
+	p.correlationMtx.RLock()
+	defer p.correlationMtx.RUnlock()
+	return p.correlationHeader
+}
+
+// markCorrelation returns a copy of req carrying the correlation header, so
+// the request that is stored stays as the client sent it. The ID is kept in
+// the user data and reused if the request is sent again.
+func (p *Proxy) markCorrelation(req *http.Request, userData *UserData) *http.Request {
+	name := p.CorrelationHeader()
+	if name == "" {
+		return req
+	}
+	if userData.CorrelationID == "" {
+		userData.CorrelationID = uuid.New().String()
+	}
+	marked := req.Clone(req.Context())
+	marked.Header.Set(name, userData.CorrelationID)
+	return marked
+}
+
+// withCorrelationID passes the correlation ID of a forwarded request on to
+// storage through its context
+func withCorrelationID(req *http.Request, userData *UserData) *http.Request {
+	if req == nil || userData.CorrelationID == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), models.CorrelationIDKey, userData.CorrelationID))
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"prokzee/internal/upstream"
+
+	"github.com/elazarl/goproxy"
+	"golang.org/x/net/http2"
+)
+
+// withALPN makes the MITM certificate config offer h2 next to HTTP/1.1, so
+// clients keep the protocol they would use without the proxy. Streams of an
+// h2 connection are intercepted one by one like HTTP/1.1 requests.
+func withALPN(config func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error)) func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error) {
+	return func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error) {
+		tlsConfig, err := config(host, ctx)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		return tlsConfig, nil
+	}
+}
+
+// transportFor picks the upstream transport for the protocol of a request:
+// HTTP/2 requests negotiate h2 with the server and fall back to HTTP/1.1,
+// HTTP/1.x requests never upgrade
+func (p *Proxy) transportFor(req *http.Request) http.RoundTripper {
+	if upstream.IsHTTP2(req.Proto) {
+		return p.http2Transport
+	}
+	return p.http1Transport
+}
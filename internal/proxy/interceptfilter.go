@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// InterceptFilter narrows interception down to the requests worth holding,
+// on top of scope and rules. Empty lists and unset flags do not filter.
+type InterceptFilter struct {
+	Methods      []string `json:"methods"`      // e.g. POST, PUT
+	ParamsOnly   bool     `json:"paramsOnly"`   // only requests with query or body parameters
+	ContentTypes []string `json:"contentTypes"` // e.g. json, application/xml, matched in the media type
+}
+
+// SetInterceptFilter replaces the intercept filter
+func (p *Proxy) SetInterceptFilter(filter InterceptFilter) {
+	clean := InterceptFilter{ParamsOnly: filter.ParamsOnly, Methods: []string{}, ContentTypes: []string{}}
+	for _, method := range filter.Methods {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			clean.Methods = append(clean.Methods, method)
+		}
+	}
+	for _, contentType := range filter.ContentTypes {
+		if contentType = strings.ToLower(strings.TrimSpace(contentType)); contentType != "" {
+			clean.ContentTypes = append(clean.ContentTypes, contentType)
+		}
+	}
+	p.interceptFilterMtx.Lock()
+	p.interceptFilter = clean
+	p.interceptFilterMtx.Unlock()
+}
+
+// GetInterceptFilter returns the intercept filter
+func (p *Proxy) GetInterceptFilter() InterceptFilter {
+	p.interceptFilterMtx.RLock()
+	defer p.interceptFilterMtx.RUnlock()
+	filter := p.interceptFilter
+	filter.Methods = append([]string{}, filter.Methods...)
+	filter.ContentTypes = append([]string{}, filter.ContentTypes...)
+	return filter
+}
+
+// passesInterceptFilter reports whether a request in scope is held, given
+// its body
+func (p *Proxy) passesInterceptFilter(req *http.Request, body []byte) bool {
+	p.interceptFilterMtx.RLock()
+	defer p.interceptFilterMtx.RUnlock()
+	filter := p.interceptFilter
+
+	if len(filter.Methods) > 0 && !containsFold(filter.Methods, req.Method) {
+		return false
+	}
+	if filter.ParamsOnly && req.URL.RawQuery == "" && len(body) == 0 {
+		return false
+	}
+	if len(filter.ContentTypes) > 0 {
+		mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil || mediaType == "" {
+			return false
+		}
+		for _, contentType := range filter.ContentTypes {
+			if strings.Contains(mediaType, contentType) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// containsFold reports whether list holds s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package proxy
+
+// SetForceConnectionClose turns on sending "Connection: close" upstream with
+// every HTTP/1.x request, the behavior before connections were reused. Turning
+// it on also drops the pooled connections.
+func (p *Proxy) SetForceConnectionClose(force bool) {
+	p.forceCloseMtx.Lock()
+	p.forceClose = force
+	p.forceCloseMtx.Unlock()
+	if force {
+		p.CloseIdleConnections()
+	}
+}
+
+// ForceConnectionClose reports whether upstream connections are closed after
+// every HTTP/1.x request
+func (p *Proxy) ForceConnectionClose() bool {
+	p.forceCloseMtx.RLock()
+	defer p.forceCloseMtx.RUnlock()
+	return p.forceClose
+}
+
+// CloseIdleConnections drops the pooled upstream connections, so the next
+// requests dial again through the current upstream chain and with the current
+// client certificates
+func (p *Proxy) CloseIdleConnections() {
+	p.http1Transport.CloseIdleConnections()
+	p.http2Transport.CloseIdleConnections()
+	p.Upstream.CloseIdleConnections()
+}
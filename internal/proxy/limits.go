@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BodyLimits are the largest request and response bodies the proxy lets
+// through, in bytes. 0 means no limit.
+type BodyLimits struct {
+	Request  int64
+	Response int64
+}
+
+// SetBodyLimits sets the body limits applied to requests starting from now on
+func (p *Proxy) SetBodyLimits(limits BodyLimits) {
+	p.bodyLimitsMtx.Lock()
+	p.bodyLimits = limits
+	p.bodyLimitsMtx.Unlock()
+}
+
+// BodyLimits returns the body limits in use
+func (p *Proxy) BodyLimits() BodyLimits {
+	p.bodyLimitsMtx.RLock()
+	defer p.bodyLimitsMtx.RUnlock()
+	return p.bodyLimits
+}
+
+// limitRequest enforces the request body limit, answering with a 413 page
+// when the body is over it. Reading stops as soon as it is known to be over,
+// a body within the limit is buffered like the rest of the pipeline does.
+func (p *Proxy) limitRequest(req *http.Request, limit int64) *http.Response {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	tooLarge := p.CreateErrorResponse(req, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("The request body is larger than the %d bytes allowed by the proxy settings", limit))
+	if req.ContentLength > limit {
+		req.Body.Close()
+		return tooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	req.Body.Close()
+	if err != nil {
+		return p.CreateErrorResponse(req, http.StatusBadRequest, "Error reading request body")
+	}
+	if int64(len(data)) > limit {
+		return tooLarge
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	return nil
+}
+
+// limitResponse enforces the response body limit. A response declaring a
+// body over it is replaced with a 502 page without downloading the body, one
+// of unknown length still streams and is cut off once it goes over.
+func (p *Proxy) limitResponse(req *http.Request, resp *http.Response, limit int64) (*http.Response, bool) {
+	if resp.Body == nil || resp.Body == http.NoBody || req.Method == http.MethodHead {
+		return resp, false
+	}
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return p.CreateErrorResponse(req, http.StatusBadGateway,
+			fmt.Sprintf("The response body is larger than the %d bytes allowed by the proxy settings, the download was aborted", limit)), true
+	}
+	if resp.ContentLength < 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit, limit: limit}
+	}
+	return resp, false
+}
+
+// limitedBody fails reads once more than limit bytes came through, which
+// aborts the transfer to the client and closes the upstream connection
+type limitedBody struct {
+	io.ReadCloser
+	remaining, limit int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, fmt.Errorf("response body is over the %d bytes limit", b.limit)
+	}
+	// One byte past the limit tells a body ending exactly at it from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), fmt.Errorf("response body is over the %d bytes limit", b.limit)
+	}
+	return n, err
+}
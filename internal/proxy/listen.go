@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// ParseBindAddress checks the address the proxy listens on: an IP address of
+// one of the machine's interfaces, such as 127.0.0.1 or ::1, or localhost.
+// Empty means every interface. IPv6 addresses may be bracketed.
+func ParseBindAddress(address string) (string, error) {
+	address = strings.Trim(strings.TrimSpace(address), "[]")
+	if address == "" || strings.EqualFold(address, "localhost") {
+		return strings.ToLower(address), nil
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid bind address %q: use an IP address such as 127.0.0.1, or leave it empty for every interface", address)
+	}
+	return addr.String(), nil
+}
+
+// ListenError turns the error of binding address:port into a message saying
+// what to change
+func ListenError(address, port string, err error) error {
+	where := "every interface"
+	if address != "" {
+		where = address
+	}
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Errorf("port %s is already in use on %s, pick another port or stop the program using it", port, where)
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return fmt.Errorf("%s is not an address of this machine, pick the address of one of its network interfaces", address)
+	case errors.Is(err, syscall.EACCES):
+		return fmt.Errorf("not allowed to listen on port %s, ports below 1024 usually need administrator rights", port)
+	}
+	return fmt.Errorf("failed to listen on %s: %v", net.JoinHostPort(address, port), err)
+}
+
+// LocalHost returns the host clients on this machine reach a proxy listening
+// on address at: the address itself when it is a specific one, 127.0.0.1
+// for every interface or localhost
+func LocalHost(address string) string {
+	addr, err := netip.ParseAddr(strings.Trim(address, "[]"))
+	if err != nil || addr.IsUnspecified() {
+		return "127.0.0.1"
+	}
+	return addr.String()
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// handshakeFailureFormat is how goproxy reports a client refusing the
+// certificate of a MITM'd connection, usually because the app pins its
+// certificates
+const handshakeFailureFormat = "[%03d] WARN: Cannot handshake client %v %v\n"
+
+// handshakeFailurePrefix starts the log message of a failed client handshake,
+// followed by the host and the error
+const handshakeFailurePrefix = "TLS handshake with client failed for "
+
+// ParseTLSPassthrough compiles the passthrough patterns, one regular
+// expression per line. Empty lines and lines starting with # are ignored.
+func ParseTLSPassthrough(patterns string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, line := range strings.Split(patterns, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS passthrough pattern %q: %v", line, err)
+		}
+		compiled = append(compiled, pattern)
+	}
+	return compiled, nil
+}
+
+// SetTLSPassthrough replaces the hosts whose TLS connections are tunnelled
+// untouched instead of intercepted
+func (p *Proxy) SetTLSPassthrough(patterns string) error {
+	compiled, err := ParseTLSPassthrough(patterns)
+	if err != nil {
+		return err
+	}
+	p.passthroughMtx.Lock()
+	p.passthrough = compiled
+	p.passthroughMtx.Unlock()
+	return nil
+}
+
+// isPassthrough reports whether a CONNECT host matches a passthrough pattern.
+// Patterns are matched against the host name without the port.
+func (p *Proxy) isPassthrough(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	p.passthroughMtx.RLock()
+	defer p.passthroughMtx.RUnlock()
+	for _, pattern := range p.passthrough {
+		if pattern.MatchString(hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// PassthroughPattern returns the pattern matching exactly the host name of
+// host, which may carry a port
+func PassthroughPattern(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return "^" + regexp.QuoteMeta(host) + "$"
+}
+
+// HandshakeFailureHost returns the host of a failed client handshake log
+// message, or "" when the message is not one
+func HandshakeFailureHost(message string) string {
+	rest, ok := strings.CutPrefix(message, handshakeFailurePrefix)
+	if !ok {
+		return ""
+	}
+	host, _, _ := strings.Cut(rest, ": ")
+	return host
+}
+
+// handshakeLogger passes goproxy logs on to the standard logger and records
+// failed client handshakes in the project log, from where the host can be
+// added to the passthrough list
+type handshakeLogger struct {
+	proxy *Proxy
+}
+
+func (l handshakeLogger) Printf(format string, v ...any) {
+	log.Printf(format, v...)
+	if format != handshakeFailureFormat || len(v) != 3 {
+		return
+	}
+	if logger := l.proxy.Components().Logger; logger != nil {
+		logger.LogMessage("warning", fmt.Sprintf("%s%v: %v", handshakeFailurePrefix, v[1], v[2]), "TLS")
+	}
+}
@@ -4,23 +4,28 @@ import (
 	"bytes"
 	"context"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"prokzee/internal/certificate"
-
-	"crypto/tls"
+	"prokzee/internal/models"
+	"prokzee/internal/upstream"
 
 	"github.com/elazarl/goproxy"
 	"github.com/google/uuid"
@@ -29,18 +34,59 @@ import (
 
 // Proxy struct holds all proxy-related fields and functionality
 type Proxy struct {
-	ApprovalChs       map[string]chan ApprovalResponse
-	ApprovalChsM      sync.Mutex
-	PendingRequests   map[string]*http.Request
-	PendingRequestsM  sync.Mutex
-	ActiveRequests    map[int]context.CancelFunc
-	CertManager       *certificate.CertificateManager
-	InterceptionOn    bool
-	InterceptionMtx   sync.Mutex
-	ProxyServer       *goproxy.ProxyHttpServer
-	server            *http.Server
-	proxyIsListening  bool
-	proxyListeningMtx sync.Mutex
+	ApprovalChs        map[string]chan ApprovalResponse
+	ApprovalChsM       sync.Mutex
+	PendingRequests    map[string]*http.Request
+	PendingRequestsM   sync.Mutex
+	heldOrder          []string // queue order set by ReorderHeld, guarded by PendingRequestsM
+	ActiveRequests     map[int]context.CancelFunc
+	CertManager        *certificate.CertificateManager
+	InterceptionOn     bool
+	InterceptionMtx    sync.Mutex
+	RecordingOn        bool
+	RecordingMtx       sync.Mutex
+	ProxyServer        *goproxy.ProxyHttpServer
+	Upstream           *upstream.Transport
+	http1Transport     *http.Transport
+	http2Transport     *http.Transport
+	server             *http.Server
+	listener           net.Listener
+	proxyIsListening   bool
+	proxyListeningMtx  sync.Mutex
+	components         Components
+	componentsMtx      sync.RWMutex
+	passthrough        []*regexp.Regexp
+	passthroughMtx     sync.RWMutex
+	forceClose         bool
+	forceCloseMtx      sync.RWMutex
+	interceptFilter    InterceptFilter
+	interceptFilterMtx sync.RWMutex
+	correlationHeader  string
+	correlationMtx     sync.RWMutex
+	bodyLimits         BodyLimits
+	bodyLimitsMtx      sync.RWMutex
+	stats              proxyStats
+}
+
+// ApprovalTimeout is how long an intercepted request is held for approval
+// before the proxy answers it with a 504
+const ApprovalTimeout = 5 * time.Minute
+
+// ShutdownTimeout is how long StopServer waits for in-flight requests
+const ShutdownTimeout = 5 * time.Second
+
+// DrainTimeout is how long a listener replaced by Rebind keeps serving its
+// in-flight requests, long enough for held intercepts to be answered
+const DrainTimeout = ApprovalTimeout + ShutdownTimeout
+
+// HeldRequest is an intercepted request waiting for approval
+type HeldRequest struct {
+	RequestID        string    `json:"requestId"`
+	Method           string    `json:"method"`
+	URL              string    `json:"url"`
+	HeldSince        time.Time `json:"heldSince"`
+	AgeSeconds       int       `json:"ageSeconds"`
+	RemainingSeconds int       `json:"remainingSeconds"`
 }
 
 // ApprovalResponse represents the response from the frontend for request approval
@@ -54,17 +100,279 @@ type ApprovalResponse struct {
 	RequestID       string
 }
 
+// HeldRequests returns the intercepted requests waiting for approval in
+// queue order: the order set by ReorderHeld, then oldest first
+func (p *Proxy) HeldRequests() []HeldRequest {
+	now := time.Now()
+	p.PendingRequestsM.Lock()
+	held := make([]HeldRequest, 0, len(p.PendingRequests))
+	for requestID, req := range p.PendingRequests {
+		since, ok := req.Context().Value(models.CreationTimeKey).(time.Time)
+		if !ok {
+			since = now
+		}
+		age := now.Sub(since)
+		held = append(held, HeldRequest{
+			RequestID:        requestID,
+			Method:           req.Method,
+			URL:              req.URL.String(),
+			HeldSince:        since,
+			AgeSeconds:       int(age.Seconds()),
+			RemainingSeconds: int((ApprovalTimeout - age).Seconds()),
+		})
+	}
+	position := p.queuePositions()
+	p.PendingRequestsM.Unlock()
+
+	sort.Slice(held, func(i, j int) bool {
+		return queueLess(position, held[i].RequestID, held[j].RequestID, held[i].HeldSince, held[j].HeldSince)
+	})
+	return held
+}
+
+// queuePositions returns the position of every reordered held request and
+// forgets the ones no longer held. The caller holds PendingRequestsM.
+func (p *Proxy) queuePositions() map[string]int {
+	position := make(map[string]int, len(p.heldOrder))
+	order := p.heldOrder[:0]
+	for _, requestID := range p.heldOrder {
+		if _, ok := p.PendingRequests[requestID]; ok {
+			position[requestID] = len(order)
+			order = append(order, requestID)
+		}
+	}
+	p.heldOrder = order
+	return position
+}
+
+// queueLess orders reordered requests first, by position, and the others by age
+func queueLess(position map[string]int, a, b string, sinceA, sinceB time.Time) bool {
+	posA, okA := position[a]
+	posB, okB := position[b]
+	switch {
+	case okA && okB:
+		return posA < posB
+	case okA != okB:
+		return okA
+	}
+	return sinceA.Before(sinceB)
+}
+
+// ReorderHeld moves the given held requests to the front of the queue in the
+// given order; the others follow, oldest first
+func (p *Proxy) ReorderHeld(requestIDs []string) {
+	p.PendingRequestsM.Lock()
+	defer p.PendingRequestsM.Unlock()
+	seen := make(map[string]bool, len(requestIDs))
+	order := make([]string, 0, len(requestIDs))
+	for _, requestID := range requestIDs {
+		if _, ok := p.PendingRequests[requestID]; ok && !seen[requestID] {
+			seen[requestID] = true
+			order = append(order, requestID)
+		}
+	}
+	p.heldOrder = order
+}
+
+// heldEntry is a held request taken off the queue
+type heldEntry struct {
+	id  string
+	ch  chan ApprovalResponse
+	req *http.Request
+}
+
+// takeHeld removes the held requests accepted by match from the queue and
+// returns them in queue order
+func (p *Proxy) takeHeld(match func(requestID string, req *http.Request) bool) []heldEntry {
+	var taken []heldEntry
+
+	p.ApprovalChsM.Lock()
+	p.PendingRequestsM.Lock()
+	position := p.queuePositions()
+	for requestID, req := range p.PendingRequests {
+		ch, ok := p.ApprovalChs[requestID]
+		if !ok || !match(requestID, req) {
+			continue
+		}
+		taken = append(taken, heldEntry{requestID, ch, req})
+		delete(p.ApprovalChs, requestID)
+		delete(p.PendingRequests, requestID)
+	}
+	p.PendingRequestsM.Unlock()
+	p.ApprovalChsM.Unlock()
+
+	sort.Slice(taken, func(i, j int) bool {
+		sinceI, _ := taken[i].req.Context().Value(models.CreationTimeKey).(time.Time)
+		sinceJ, _ := taken[j].req.Context().Value(models.CreationTimeKey).(time.Time)
+		return queueLess(position, taken[i].id, taken[j].id, sinceI, sinceJ)
+	})
+	return taken
+}
+
+// forward sends held requests on unchanged and returns the IDs that went
+func forward(entries []heldEntry) []string {
+	released := []string{}
+	for _, h := range entries {
+		body := heldBody(h.req)
+		response := ApprovalResponse{
+			Approved:        true,
+			Headers:         h.req.Header,
+			Body:            string(body),
+			Method:          h.req.Method,
+			ProtocolVersion: h.req.Proto,
+			URL:             h.req.URL.String(),
+			RequestID:       h.id,
+		}
+		select {
+		case h.ch <- response:
+			released = append(released, h.id)
+		case <-time.After(100 * time.Millisecond):
+			log.Printf("Could not release held request %s, channel may be closed", h.id)
+		}
+	}
+	return released
+}
+
+// ReleaseHeld forwards the held requests accepted by match unchanged and
+// returns their IDs
+func (p *Proxy) ReleaseHeld(match func(req *http.Request) bool) []string {
+	return forward(p.takeHeld(func(_ string, req *http.Request) bool {
+		return match(req)
+	}))
+}
+
+// ForwardHeld forwards the given held requests unchanged, every held request
+// when requestIDs is empty, in queue order and returns the IDs that went
+func (p *Proxy) ForwardHeld(requestIDs []string) []string {
+	return forward(p.takeHeld(idMatcher(requestIDs)))
+}
+
+// DropHeldRequests answers the given held requests with a 403, every held
+// request when requestIDs is empty, and returns the IDs that were dropped
+func (p *Proxy) DropHeldRequests(requestIDs []string) []string {
+	dropped := []string{}
+	for _, h := range p.takeHeld(idMatcher(requestIDs)) {
+		select {
+		case h.ch <- ApprovalResponse{Approved: false, RequestID: h.id}:
+			dropped = append(dropped, h.id)
+		case <-time.After(100 * time.Millisecond):
+			log.Printf("Could not drop held request %s, channel may be closed", h.id)
+		}
+	}
+	return dropped
+}
+
+// idMatcher matches the given request IDs, or every request when there are none
+func idMatcher(requestIDs []string) func(string, *http.Request) bool {
+	wanted := make(map[string]bool, len(requestIDs))
+	for _, requestID := range requestIDs {
+		wanted[requestID] = true
+	}
+	return func(requestID string, _ *http.Request) bool {
+		return len(wanted) == 0 || wanted[requestID]
+	}
+}
+
+// ReemitHeld asks the frontend again to approve every held request, after
+// its state was cleared by a project switch
+func (p *Proxy) ReemitHeld(ctx context.Context) {
+	p.PendingRequestsM.Lock()
+	held := make(map[string]*http.Request, len(p.PendingRequests))
+	for requestID, req := range p.PendingRequests {
+		held[requestID] = req
+	}
+	p.PendingRequestsM.Unlock()
+
+	for requestID, req := range held {
+		wailsRuntime.EventsEmit(ctx, "app:requestApproval", map[string]interface{}{
+			"requestID": requestID,
+			"details":   heldDetails(req, heldBody(req)),
+		})
+	}
+}
+
+// heldDetails describes an intercepted request to the frontend
+func heldDetails(req *http.Request, body []byte) map[string]interface{} {
+	return map[string]interface{}{
+		"url":             req.URL.String(),
+		"headers":         req.Header,
+		"method":          req.Method,
+		"protocolVersion": req.Proto,
+		"body":            string(body),
+	}
+}
+
+// heldBody returns the body of a held request, which can be read any number of times
+func heldBody(req *http.Request) []byte {
+	if req.GetBody == nil {
+		return nil
+	}
+	reader, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(reader)
+	return body
+}
+
+// DropHeld answers every held request with a 403 so their handlers return,
+// and returns how many were dropped
+func (p *Proxy) DropHeld() int {
+	p.ApprovalChsM.Lock()
+	p.PendingRequestsM.Lock()
+	channels := make(map[string]chan ApprovalResponse, len(p.ApprovalChs))
+	for requestID, ch := range p.ApprovalChs {
+		channels[requestID] = ch
+		delete(p.ApprovalChs, requestID)
+		delete(p.PendingRequests, requestID)
+	}
+	p.PendingRequestsM.Unlock()
+	p.ApprovalChsM.Unlock()
+
+	dropped := 0
+	for requestID, ch := range channels {
+		select {
+		case ch <- ApprovalResponse{Approved: false, RequestID: requestID}:
+			dropped++
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return dropped
+}
+
 // NewProxy creates a new Proxy instance
 func NewProxy() *Proxy {
-	return &Proxy{
+	p := &Proxy{
 		ApprovalChs:      make(map[string]chan ApprovalResponse),
 		PendingRequests:  make(map[string]*http.Request),
 		ActiveRequests:   make(map[int]context.CancelFunc),
 		InterceptionOn:   true,
+		RecordingOn:      true,
 		proxyIsListening: false,
 		ProxyServer:      goproxy.NewProxyHttpServer(),
+		Upstream:         upstream.NewTransport(nil),
+		http1Transport:   upstream.NewHTTPTransport(false),
+		http2Transport:   upstream.NewHTTPTransport(true),
 		CertManager:      certificate.NewCertificateManager(),
 	}
+
+	// Traffic that is not intercepted, tunnels and WebSockets, also goes
+	// through the upstream proxy chain
+	envDial := p.ProxyServer.ConnectDial
+	p.ProxyServer.ConnectDialWithReq = func(req *http.Request, network, addr string) (net.Conn, error) {
+		if upstream.CurrentChain() == nil && envDial != nil && !upstream.CurrentResolver().Handles(addr) {
+			return envDial(network, addr)
+		}
+		ctx := context.Background()
+		if req != nil {
+			ctx = req.Context()
+		}
+		return upstream.DialContext(ctx, network, addr)
+	}
+	p.ProxyServer.Tr.Proxy = upstream.ProxyOrEnvironment
+	p.ProxyServer.Tr.DialContext = upstream.TransportDialContext
+	p.ProxyServer.Logger = handshakeLogger{proxy: p}
+	return p
 }
 
 // SetupCertificates sets up the certificates using the certificate manager
@@ -80,25 +388,141 @@ func (p *Proxy) SetupCertificates() error {
 	return nil
 }
 
-// StartServer starts the proxy server on the specified port
-func (p *Proxy) StartServer(port string) error {
+// StartServer starts the proxy server on the specified address and port. An
+// empty address listens on every interface.
+func (p *Proxy) StartServer(address, port string) error {
+	return p.Rebind(address, port)
+}
+
+// Rebind moves the proxy to address and port without dropping traffic.
+// Nothing happens when it already listens there. Otherwise the new address is
+// bound first, so one that is unavailable leaves the current listener in
+// place, and the previous listener stops accepting connections but drains its
+// in-flight requests in the background. A move to another address on the
+// same port cannot bind while the previous listener holds the port, so that
+// listener is closed first and bound again when the new address fails. Held
+// intercepts and tunnelled connections carry on.
+func (p *Proxy) Rebind(address, port string) error {
 	p.proxyListeningMtx.Lock()
-	p.proxyIsListening = true
-	p.proxyListeningMtx.Unlock()
+	defer p.proxyListeningMtx.Unlock()
+
+	address, err := ParseBindAddress(address)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(address, port)
+	if p.proxyIsListening && p.server != nil && p.server.Addr == addr {
+		return nil
+	}
+
+	previous := p.server
+	if !p.proxyIsListening {
+		previous = nil
+	}
+	samePort := false
+	if previous != nil {
+		_, previousPort, _ := net.SplitHostPort(previous.Addr)
+		samePort = previousPort == port
+	}
+	if samePort {
+		p.listener.Close()
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		if samePort {
+			p.restoreListener(previous)
+		}
+		return ListenError(address, port, err)
+	}
 
 	p.server = &http.Server{
-		Addr:    ":" + port,
+		Addr:    addr,
 		Handler: p.ProxyServer,
 	}
+	p.proxyIsListening = true
+	log.Printf("Starting HTTPS proxy server on %s", addr)
+	p.serve(p.server, listener)
+
+	if previous != nil {
+		log.Printf("Draining HTTPS proxy server on %s", previous.Addr)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+			defer cancel()
+			if err := previous.Shutdown(ctx); err != nil {
+				log.Printf("Proxy connections on %s still open after %v, closing them", previous.Addr, DrainTimeout)
+				previous.Close()
+			}
+		}()
+	}
+	return nil
+}
 
-	log.Printf("Starting HTTPS proxy server on :%s", port)
-	go func() {
-		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("ListenAndServe(): %v", err)
+// restoreListener binds the address of server again after its listener was
+// closed for a move that failed. The proxy is stopped when the address was
+// taken in the meantime.
+func (p *Proxy) restoreListener(server *http.Server) {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Printf("Failed to listen on %s again, stopping the proxy: %v", server.Addr, err)
+		p.proxyIsListening = false
+		server.Close()
+		return
+	}
+	log.Printf("Restoring HTTPS proxy server on %s", server.Addr)
+	p.serve(server, listener)
+}
+
+// serve accepts the connections of listener on server in the background
+func (p *Proxy) serve(server *http.Server, listener net.Listener) {
+	p.listener = &onceCloseListener{Listener: statsListener{Listener: listener, stats: &p.stats}}
+	go func(listener net.Listener) {
+		// A listener closed to free its port for a move ends Serve as well
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Serve(): %v", err)
 		}
-	}()
+	}(p.listener)
+}
 
-	return nil
+// onceCloseListener closes its listener only once, so draining a server
+// whose listener was already closed for a move does not fail and cut its
+// connections
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() { l.err = l.Listener.Close() })
+	return l.err
+}
+
+// Port returns the port the proxy listens on, or "" when it is stopped
+func (p *Proxy) Port() string {
+	_, port := p.listenAddr()
+	return port
+}
+
+// BindAddress returns the address the proxy listens on, "" for every
+// interface or when it is stopped
+func (p *Proxy) BindAddress() string {
+	address, _ := p.listenAddr()
+	return address
+}
+
+// listenAddr returns the address and port of the running listener
+func (p *Proxy) listenAddr() (string, string) {
+	p.proxyListeningMtx.Lock()
+	defer p.proxyListeningMtx.Unlock()
+	if !p.proxyIsListening || p.server == nil {
+		return "", ""
+	}
+	address, port, err := net.SplitHostPort(p.server.Addr)
+	if err != nil {
+		return "", ""
+	}
+	return address, port
 }
 
 // StopServer stops the proxy server
@@ -110,8 +534,14 @@ func (p *Proxy) StopServer() error {
 		p.proxyIsListening = false
 		log.Println("Stopping HTTPS proxy server")
 		if p.server != nil {
-			if err := p.server.Shutdown(context.Background()); err != nil {
-				return fmt.Errorf("HTTP server Shutdown: %v", err)
+			// Let in-flight requests finish, then cut the remaining connections
+			ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+			if err := p.server.Shutdown(ctx); err != nil {
+				log.Printf("Proxy connections still open after %v, closing them", ShutdownTimeout)
+				if err := p.server.Close(); err != nil {
+					return fmt.Errorf("HTTP server Close: %v", err)
+				}
 			}
 		}
 	}
@@ -131,6 +561,15 @@ func (p *Proxy) SetupHandlers() {
 				// For .pem format, serve as application/x-x509-ca-cert
 				// For .crt and .cer format, serve as application/x-x509-ca-cert (same content)
 				return req, goproxy.NewResponse(req, "application/x-x509-ca-cert", http.StatusOK, string(caCertPEM))
+			} else if req.URL.Path == "/rootCA.der" || req.URL.Path == "/rootCA.p12" {
+				// Binary formats for Android and the Windows certificate store,
+				// the PKCS#12 file has no password
+				format := strings.TrimPrefix(path.Ext(req.URL.Path), ".")
+				data, err := p.CertManager.Export(format, "")
+				if err != nil {
+					return req, p.CreateErrorResponse(req, http.StatusInternalServerError, err.Error())
+				}
+				return req, goproxy.NewResponse(req, certificate.ContentType(format), http.StatusOK, string(data))
 			} else if req.URL.Path == "/appicon.png" {
 				iconData, err := os.ReadFile("frontend/src/assets/images/appicon.png")
 				if err != nil {
@@ -148,11 +587,16 @@ func (p *Proxy) SetupHandlers() {
 			return goproxy.OkConnect, host
 		}
 
+		// Tunnel the hosts that pin their certificates untouched
+		if p.isPassthrough(host) {
+			return goproxy.OkConnect, host
+		}
+
 		// Create a custom MITM action with our CA certificate
 		tlsCert := p.CertManager.GetTLSCertificate()
 		customCaMitm := &goproxy.ConnectAction{
 			Action:    goproxy.ConnectMitm,
-			TLSConfig: goproxy.TLSConfigFromCA(&tlsCert),
+			TLSConfig: withALPN(goproxy.TLSConfigFromCA(&tlsCert)),
 		}
 
 		// Always return the host with the action to ensure proper routing
@@ -167,49 +611,10 @@ func (p *Proxy) ToggleInterception() bool {
 	newState := p.InterceptionOn
 	p.InterceptionMtx.Unlock()
 
-	// If turning off interception, approve all pending requests
+	// If turning off interception, forward all pending requests unchanged
 	if !newState {
-		p.ApprovalChsM.Lock()
-		p.PendingRequestsM.Lock()
-
-		// Create a copy of the maps to iterate over
-		approvalChsCopy := make(map[string]chan ApprovalResponse)
-		pendingRequestsCopy := make(map[string]*http.Request)
-		for k, v := range p.ApprovalChs {
-			approvalChsCopy[k] = v
-		}
-		for k, v := range p.PendingRequests {
-			pendingRequestsCopy[k] = v
-		}
-
-		// Clear the maps
-		p.ApprovalChs = make(map[string]chan ApprovalResponse)
-		p.PendingRequests = make(map[string]*http.Request)
-
-		p.PendingRequestsM.Unlock()
-		p.ApprovalChsM.Unlock()
-
-		// Process all pending requests
-		for requestID, ch := range approvalChsCopy {
-			if req, ok := pendingRequestsCopy[requestID]; ok {
-				// Create approval response from the original request
-				response := ApprovalResponse{
-					Approved:        true,
-					Headers:         req.Header,
-					Method:          req.Method,
-					ProtocolVersion: req.Proto,
-					URL:             req.URL.String(),
-					RequestID:       requestID,
-				}
-
-				// Try to send the response with a short timeout
-				select {
-				case ch <- response:
-					log.Printf("Successfully forwarded request %s when turning off interception", requestID)
-				case <-time.After(100 * time.Millisecond):
-					log.Printf("Could not send approval for request %s, channel may be closed", requestID)
-				}
-			}
+		if forwarded := p.ForwardHeld(nil); len(forwarded) > 0 {
+			log.Printf("Forwarded %d held requests when turning off interception", len(forwarded))
 		}
 	}
 
@@ -224,6 +629,31 @@ func (p *Proxy) GetInterceptionState() bool {
 	return state
 }
 
+// ToggleRecording toggles whether proxied traffic is stored in the project.
+// Recording is independent of interception: when off, requests are still
+// forwarded (and intercepted if interception is on) but nothing is persisted.
+func (p *Proxy) ToggleRecording() bool {
+	p.RecordingMtx.Lock()
+	defer p.RecordingMtx.Unlock()
+	p.RecordingOn = !p.RecordingOn
+	return p.RecordingOn
+}
+
+// ToggleHTTP3 toggles HTTP/3 for upstream hosts that advertise it via Alt-Svc
+func (p *Proxy) ToggleHTTP3() bool {
+	newState := !p.Upstream.Enabled()
+	p.Upstream.SetEnabled(newState)
+	return newState
+}
+
+// GetRecordingState returns the current recording state
+func (p *Proxy) GetRecordingState() bool {
+	p.RecordingMtx.Lock()
+	state := p.RecordingOn
+	p.RecordingMtx.Unlock()
+	return state
+}
+
 // CreateErrorResponse creates an HTML error response
 func (p *Proxy) CreateErrorResponse(req *http.Request, statusCode int, errorMessage string) *http.Response {
 	html := fmt.Sprintf(ErrorResponseTemplate, errorMessage, req.URL.String())
@@ -258,14 +688,20 @@ type ResponseHandler func(*http.Request, *http.Response)
 type UserData struct {
 	RequestID         string
 	BodyBytes         []byte
+	CorrelationID     string // sent upstream in the correlation header
 	requestProcessed  bool
 	responseProcessed bool
 }
 
 // HandleRequest sets up the request interception handler
-func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matchReplaceClient MatchReplaceClient, rulesClient RulesClient, logger Logger, requestHandler RequestHandler) {
+func (p *Proxy) HandleRequest(ctx context.Context, requestHandler RequestHandler) {
 	log.Printf("DEBUG: Setting up request handler")
 	p.ProxyServer.OnRequest().DoFunc(func(req *http.Request, proxyCtx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		// A request keeps the components of the project it started in
+		components := p.Components()
+		scopeClient, matchReplaceClient, rulesClient := components.Scope, components.MatchReplace, components.Rules
+		editRecorder, logger := components.Edits, components.Logger
+
 		// Initialize ctx.UserData if it's nil
 		if proxyCtx.UserData == nil {
 			proxyCtx.UserData = &UserData{}
@@ -276,6 +712,7 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 			return req, nil
 		}
 		userData.requestProcessed = true
+		p.stats.requests.Add(1)
 
 		log.Printf("DEBUG: Proxy request handler called for URL: %s", req.URL.String())
 
@@ -285,6 +722,23 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 			return req, nil
 		}
 
+		// Forward diagnostic self-test requests untouched
+		if req.Header.Get(models.SelfTestHeader) != "" {
+			return req, nil
+		}
+
+		// Route through the HTTP/3 capable upstream transport when enabled,
+		// otherwise keep the protocol the request has once it is released
+		if p.Upstream.Enabled() {
+			proxyCtx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, _ *goproxy.ProxyCtx) (*http.Response, error) {
+				return p.timedRoundTrip(p.markCorrelation(req, userData), p.Upstream.RoundTrip)
+			})
+		} else {
+			proxyCtx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, _ *goproxy.ProxyCtx) (*http.Response, error) {
+				return p.timedRoundTrip(p.markCorrelation(req, userData), p.transportFor(req).RoundTrip)
+			})
+		}
+
 		log.Printf("DEBUG: Handling request for URL: %s", req.URL.String())
 		log.Printf("DEBUG: Request headers before: %+v", req.Header)
 
@@ -294,17 +748,40 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 			req.Header = make(http.Header)
 		}
 
-		// Only set Connection: close for non-websocket requests
-		if !isWebSocketHandshake(req.Header) {
+		// Upstream connections are pooled and reused. Closing them after each
+		// request is only done for non-websocket HTTP/1.x requests when asked
+		// for, an HTTP/2 upstream connection is shared by many streams.
+		if p.ForceConnectionClose() && !isWebSocketHandshake(req.Header) && req.ProtoMajor < 2 {
 			log.Printf("DEBUG: Setting Connection header")
 			req.Header.Set("Connection", "close")
 		}
 
 		log.Printf("DEBUG: Request headers after: %+v", req.Header)
 
+		// Oversized bodies are refused before anything buffers or stores them
+		if limit := p.BodyLimits().Request; limit > 0 {
+			if resp := p.limitRequest(req, limit); resp != nil {
+				logger.LogMessage("info", fmt.Sprintf("Request to %s refused, its body is over the %d bytes limit", req.URL.String(), limit), "ProxyServer")
+				return req, resp
+			}
+		}
+
 		// Call the request handler for ALL requests, regardless of scope or rules
 		requestHandler(req)
 
+		// Benchmark traffic is measured end to end but never waits for a tester
+		if req.Header.Get(models.LoadTestHeader) != "" {
+			return req, nil
+		}
+
+		// Mapped requests are answered locally and never reach the server
+		if components.MapLocal != nil {
+			if resp, ok := components.MapLocal.Respond(req); ok {
+				logger.LogMessage("info", fmt.Sprintf("Request %s answered by a map local rule", req.URL.String()), "MapLocal")
+				return req, resp
+			}
+		}
+
 		p.InterceptionMtx.Lock()
 		interceptionOn := p.InterceptionOn
 		p.InterceptionMtx.Unlock()
@@ -315,21 +792,6 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 			return req, nil
 		}
 
-		// Create a custom transport based on the requested protocol version
-		transport := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		}
-
-		// Disable HTTP/2 if HTTP/1.1 is requested
-		if req.Proto == "HTTP/1.1" {
-			transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
-		}
-
-		// Set the transport on the proxy server
-		p.ProxyServer.Tr = transport
-
 		// Check if the request should be intercepted based on scope and rules
 		host := req.Host
 		log.Printf("Proxy checking scope for host: %s (from URL: %s)", host, req.URL.String())
@@ -364,14 +826,13 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 
 		userData.BodyBytes = bodyContent
 
-		requestDetails := map[string]interface{}{
-			"url":             req.URL.String(),
-			"headers":         req.Header,
-			"method":          req.Method,
-			"protocolVersion": req.Proto,
-			"body":            string(bodyContent),
+		// Quick filters pass on what is not worth holding without a full rule
+		if !p.passesInterceptFilter(req, bodyContent) {
+			return req, nil
 		}
 
+		requestDetails := heldDetails(req, bodyContent)
+
 		log.Printf("Sending request details to frontend: %+v", requestDetails)
 
 		// Create a unique request ID
@@ -379,8 +840,12 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 		approvalCh := make(chan ApprovalResponse)
 
 		// Create a context with creation time for stale detection
-		reqCtx := context.WithValue(req.Context(), creationTimeKey, time.Now())
+		reqCtx := context.WithValue(req.Context(), models.CreationTimeKey, time.Now())
 		reqWithTime := req.Clone(reqCtx)
+		reqWithTime.Body = ioutil.NopCloser(bytes.NewReader(bodyContent))
+		reqWithTime.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(bodyContent)), nil
+		}
 
 		p.ApprovalChsM.Lock()
 		p.PendingRequestsM.Lock()
@@ -410,7 +875,7 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 			delete(p.PendingRequests, requestID)
 			p.PendingRequestsM.Unlock()
 
-		case <-time.After(60 * 5 * time.Second):
+		case <-time.After(ApprovalTimeout):
 			log.Printf("Request approval timed out for %s", requestID)
 
 			// Clean up on timeout
@@ -430,10 +895,23 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 			return req, p.CreateErrorResponse(req, http.StatusForbidden, "Request was dropped")
 		}
 
+		// Keep the original and the edited request when the tester changed it
+		original := renderRequest(requestDetails["method"].(string), requestDetails["url"].(string), requestDetails["protocolVersion"].(string), req.Header, string(bodyContent))
+		edited := renderRequest(approvalResponse.Method, approvalResponse.URL, approvalResponse.ProtocolVersion, approvalResponse.Headers, approvalResponse.Body)
+		if original != edited {
+			if err := editRecorder.RecordEdit(requestID, approvalResponse.URL, original, edited); err != nil {
+				logger.LogMessage("ERROR", fmt.Sprintf("Error recording intercepted request edit: %v", err), "ProxyServer")
+			}
+		}
+
 		// Apply modifications
 		req.Header = approvalResponse.Headers
 		req.Method = approvalResponse.Method
 		req.Proto = approvalResponse.ProtocolVersion
+		req.ProtoMajor, req.ProtoMinor = 1, 1
+		if upstream.IsHTTP2(req.Proto) {
+			req.ProtoMajor, req.ProtoMinor = 2, 0
+		}
 		req.Host = req.Header.Get("Host")
 
 		// Update the URL with the new path
@@ -467,9 +945,12 @@ func (p *Proxy) HandleRequest(ctx context.Context, scopeClient ScopeClient, matc
 }
 
 // HandleResponse sets up the response interception handler
-func (p *Proxy) HandleResponse(ctx context.Context, matchReplaceClient MatchReplaceClient, logger Logger, responseHandler ResponseHandler) {
+func (p *Proxy) HandleResponse(ctx context.Context, responseHandler ResponseHandler) {
 	log.Printf("DEBUG: Setting up response handler")
 	p.ProxyServer.OnResponse().DoFunc(func(resp *http.Response, proxyCtx *goproxy.ProxyCtx) *http.Response {
+		components := p.Components()
+		matchReplaceClient, logger := components.MatchReplace, components.Logger
+
 		if proxyCtx.UserData == nil {
 			proxyCtx.UserData = &UserData{}
 		}
@@ -488,8 +969,25 @@ func (p *Proxy) HandleResponse(ctx context.Context, matchReplaceClient MatchRepl
 			return resp
 		}
 
+		// Oversized responses are aborted before they fill the history
+		if limit := p.BodyLimits().Response; limit > 0 && resp != nil {
+			var refused bool
+			if resp, refused = p.limitResponse(proxyCtx.Req, resp, limit); refused {
+				logger.LogMessage("info", fmt.Sprintf("Response from %s refused, its body is over the %d bytes limit", proxyCtx.Req.URL.String(), limit), "ProxyServer")
+			}
+		}
+
+		// Chaos rules degrade responses regardless of interception state, and
+		// history keeps what the client got
+		if components.Chaos != nil {
+			var applied string
+			if resp, applied = components.Chaos.Apply(proxyCtx.Req, resp); applied != "" {
+				logger.LogMessage("info", fmt.Sprintf("Response to %s %s", proxyCtx.Req.URL.String(), applied), "Chaos")
+			}
+		}
+
 		// Call the response handler regardless of interception state
-		responseHandler(proxyCtx.Req, resp)
+		responseHandler(withCorrelationID(proxyCtx.Req, userData), resp)
 
 		p.InterceptionMtx.Lock()
 		interceptionOn := p.InterceptionOn
@@ -555,6 +1053,32 @@ func handleMultipartForm(req *http.Request) error {
 	return nil
 }
 
+// Components are the project-bound clients used by the proxy handlers. They
+// are swapped as a whole on a project switch, without touching the listener.
+type Components struct {
+	Scope        ScopeClient
+	MatchReplace MatchReplaceClient
+	MapLocal     MapLocalClient
+	Chaos        ChaosClient
+	Rules        RulesClient
+	Edits        EditRecorder
+	Logger       Logger
+}
+
+// SetComponents replaces the clients used for requests starting from now on
+func (p *Proxy) SetComponents(components Components) {
+	p.componentsMtx.Lock()
+	p.components = components
+	p.componentsMtx.Unlock()
+}
+
+// Components returns the clients currently used by the proxy handlers
+func (p *Proxy) Components() Components {
+	p.componentsMtx.RLock()
+	defer p.componentsMtx.RUnlock()
+	return p.components
+}
+
 // Interface for request storage
 type RequestStorage interface {
 	StoreRequest(req *http.Request, resp *http.Response) (string, int, error)
@@ -573,17 +1097,57 @@ type MatchReplaceClient interface {
 	ApplyToResponse(resp *http.Response) (*http.Response, error)
 }
 
+// Interface for map local client
+type MapLocalClient interface {
+	Respond(req *http.Request) (*http.Response, bool)
+}
+
+// Interface for chaos client
+type ChaosClient interface {
+	Apply(req *http.Request, resp *http.Response) (*http.Response, string)
+}
+
 // Interface for rules client
 type RulesClient interface {
 	RuleEvaluation(req *http.Request) bool
 }
 
+// Interface for the history of edited intercepted requests
+type EditRecorder interface {
+	RecordEdit(interceptID, url, original, edited string) error
+}
+
 // Interface for logger
 type Logger interface {
 	LogMessage(level string, message string, source string)
 }
 
-// Key type for context values
-type contextKey int
+// renderRequest formats a request the way it goes on the wire, with headers
+// sorted so that two renderings only differ where the request does
+func renderRequest(method, rawURL, proto string, headers http.Header, body string) string {
+	target, host := rawURL, headers.Get("Host")
+	if u, err := url.Parse(rawURL); err == nil {
+		target = u.RequestURI()
+		if host == "" {
+			host = u.Host
+		}
+	}
 
-const creationTimeKey contextKey = iota
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\nHost: %s\n", method, target, proto, host)
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		if key != "Host" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range headers[key] {
+			fmt.Fprintf(&b, "%s: %s\n", key, value)
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(body)
+	return b.String()
+}
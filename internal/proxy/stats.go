@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"prokzee/internal/hostaddr"
+)
+
+// maxStatsHosts bounds how many hosts latencies are kept for, traffic to
+// further hosts still counts in the totals
+const maxStatsHosts = 1000
+
+// topStatsHosts is how many hosts a snapshot lists, the busiest first
+const topStatsHosts = 20
+
+// minRateWindow is the shortest interval rates are measured over, so that
+// snapshots taken in quick succession do not report spikes
+const minRateWindow = time.Second
+
+// HostLatency is the upstream latency of the requests sent to one host
+type HostLatency struct {
+	Host      string  `json:"host"`
+	Requests  uint64  `json:"requests"`
+	Errors    uint64  `json:"errors"`
+	AverageMs float64 `json:"averageMs"`
+}
+
+// Stats is a snapshot of the proxy activity. Bytes are counted on the client
+// connections: in is what clients sent, out what they received.
+type Stats struct {
+	Requests          uint64        `json:"requests"`
+	RequestsPerSecond float64       `json:"requestsPerSecond"`
+	ActiveConnections int64         `json:"activeConnections"`
+	BytesIn           uint64        `json:"bytesIn"`
+	BytesOut          uint64        `json:"bytesOut"`
+	BytesInPerSecond  float64       `json:"bytesInPerSecond"`
+	BytesOutPerSecond float64       `json:"bytesOutPerSecond"`
+	QueueDepth        int           `json:"queueDepth"` // intercepted requests waiting for approval
+	Hosts             []HostLatency `json:"hosts"`
+	Timestamp         time.Time     `json:"timestamp"`
+}
+
+// hostTotals accumulates the round trips to one host
+type hostTotals struct {
+	requests, errors uint64
+	total            time.Duration
+}
+
+// proxyStats counts the proxy traffic, its zero value is ready to use
+type proxyStats struct {
+	requests    atomic.Uint64
+	connections atomic.Int64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+
+	mu    sync.Mutex
+	hosts map[string]*hostTotals
+	// totals at the start of the current rate window
+	windowStart                         time.Time
+	windowRequests, windowIn, windowOut uint64
+	rates                               [3]float64
+}
+
+// observe records the upstream round trip of a request
+func (s *proxyStats) observe(host string, d time.Duration, failed bool) {
+	host = hostaddr.Canonical(host)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hosts == nil {
+		s.hosts = make(map[string]*hostTotals)
+	}
+	totals, ok := s.hosts[host]
+	if !ok {
+		if len(s.hosts) >= maxStatsHosts {
+			return
+		}
+		totals = &hostTotals{}
+		s.hosts[host] = totals
+	}
+	totals.requests++
+	totals.total += d
+	if failed {
+		totals.errors++
+	}
+}
+
+// Stats returns the proxy activity since it was created, with rates over the
+// last second or more
+func (p *Proxy) Stats() Stats {
+	s := &p.stats
+	now := time.Now()
+	stats := Stats{
+		Requests:          s.requests.Load(),
+		ActiveConnections: s.connections.Load(),
+		BytesIn:           s.bytesIn.Load(),
+		BytesOut:          s.bytesOut.Load(),
+		QueueDepth:        len(p.HeldRequests()),
+		Hosts:             []HostLatency{},
+		Timestamp:         now,
+	}
+
+	s.mu.Lock()
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+	if elapsed := now.Sub(s.windowStart); elapsed >= minRateWindow {
+		seconds := elapsed.Seconds()
+		s.rates = [3]float64{
+			float64(stats.Requests-s.windowRequests) / seconds,
+			float64(stats.BytesIn-s.windowIn) / seconds,
+			float64(stats.BytesOut-s.windowOut) / seconds,
+		}
+		s.windowStart, s.windowRequests, s.windowIn, s.windowOut = now, stats.Requests, stats.BytesIn, stats.BytesOut
+	}
+	stats.RequestsPerSecond, stats.BytesInPerSecond, stats.BytesOutPerSecond = s.rates[0], s.rates[1], s.rates[2]
+
+	for host, totals := range s.hosts {
+		stats.Hosts = append(stats.Hosts, HostLatency{
+			Host:      host,
+			Requests:  totals.requests,
+			Errors:    totals.errors,
+			AverageMs: float64(totals.total.Microseconds()) / float64(totals.requests) / 1000,
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		if stats.Hosts[i].Requests != stats.Hosts[j].Requests {
+			return stats.Hosts[i].Requests > stats.Hosts[j].Requests
+		}
+		return stats.Hosts[i].Host < stats.Hosts[j].Host
+	})
+	if len(stats.Hosts) > topStatsHosts {
+		stats.Hosts = stats.Hosts[:topStatsHosts]
+	}
+	return stats
+}
+
+// timedRoundTrip sends a request upstream with rt and records its latency
+func (p *Proxy) timedRoundTrip(req *http.Request, rt func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt(req)
+	p.stats.observe(req.URL.Hostname(), time.Since(start), err != nil)
+	return resp, err
+}
+
+// statsListener counts the client connections accepted by the proxy and the
+// bytes they carry, tunnels and intercepted TLS included
+type statsListener struct {
+	net.Listener
+	stats *proxyStats
+}
+
+func (l statsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.stats.connections.Add(1)
+	return &statsConn{Conn: conn, stats: l.stats}, nil
+}
+
+// statsConn is a client connection counted by a statsListener
+type statsConn struct {
+	net.Conn
+	stats  *proxyStats
+	closed sync.Once
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+func (c *statsConn) Close() error {
+	c.closed.Do(func() { c.stats.connections.Add(-1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite and CloseRead keep the half-close of tunnelled TCP connections
+func (c *statsConn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return nil
+}
+
+func (c *statsConn) CloseRead() error {
+	if conn, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return conn.CloseRead()
+	}
+	return nil
+}
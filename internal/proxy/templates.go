@@ -121,7 +121,11 @@ const CertificateDownloadPage = `<!DOCTYPE html>
             <a href="/rootCA.pem" class="download-btn">Download Root CA Certificate</a>
             <div style="margin-top: 15px; font-size: 14px;">
                 For Windows users: <a href="/rootCA.crt" style="color: #4CAF50; font-weight: bold;">Download .CRT Format</a> | 
-                <a href="/rootCA.cer" style="color: #4CAF50; font-weight: bold;">Download .CER Format</a>
+                <a href="/rootCA.cer" style="color: #4CAF50; font-weight: bold;">Download .CER Format</a> |
+                <a href="/rootCA.p12" style="color: #4CAF50; font-weight: bold;">Download .P12 Format</a>
+            </div>
+            <div style="margin-top: 8px; font-size: 14px;">
+                For Android and iOS: <a href="/rootCA.der" style="color: #4CAF50; font-weight: bold;">Download .DER Format</a>
             </div>
         </div>
         <div class="warning">
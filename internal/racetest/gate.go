@@ -0,0 +1,61 @@
+package racetest
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// LastByteGate holds back the end of a number of requests until every one of
+// them is written but that end, then releases them together so they reach
+// the server within a few microseconds
+type LastByteGate struct {
+	pending sync.WaitGroup
+	release chan struct{}
+}
+
+// NewLastByteGate creates a gate for a number of requests
+func NewLastByteGate(requests int) *LastByteGate {
+	g := &LastByteGate{release: make(chan struct{})}
+	g.pending.Add(requests)
+	go func() {
+		g.pending.Wait()
+		close(g.release)
+	}()
+	return g
+}
+
+// Ticket is the place of one request at the gate
+type Ticket struct {
+	gate *LastByteGate
+	once sync.Once
+}
+
+// Ticket returns the place of a request at the gate
+func (g *LastByteGate) Ticket() *Ticket {
+	return &Ticket{gate: g}
+}
+
+// Arrive marks the request ready, or failed so the others do not wait for
+// it. Only the first call counts.
+func (t *Ticket) Arrive() {
+	t.once.Do(t.gate.pending.Done)
+}
+
+// Write writes message but its last held bytes, waits until every request
+// of the gate got that far and writes the rest
+func (t *Ticket) Write(ctx context.Context, w io.Writer, message []byte, held int) error {
+	split := max(len(message)-held, 0)
+	_, err := w.Write(message[:split])
+	t.Arrive()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-t.gate.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err = w.Write(message[split:])
+	return err
+}
@@ -0,0 +1,570 @@
+package racetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Ways the copies of a request are released
+const (
+	// ModeAuto uses single-packet over HTTP/2 and last-byte otherwise
+	ModeAuto = "auto"
+	// ModeSinglePacket opens one HTTP/2 stream per copy and completes them
+	// all in a single TCP packet
+	ModeSinglePacket = "single-packet"
+	// ModeLastByte sends each copy on its own connection but the last byte,
+	// then sends the last bytes together
+	ModeLastByte = "last-byte"
+	// ModeParallel opens a connection per copy and sends the copies together
+	ModeParallel = "parallel"
+)
+
+// DefaultCount is how many copies are sent when no count is given
+const DefaultCount = 20
+
+// MaxCount bounds the copies of one run
+const MaxCount = 100
+
+// runTimeout bounds a whole run, connecting included
+const runTimeout = 30 * time.Second
+
+// maxResponseBody bounds how much of a response is read and compared
+const maxResponseBody = 256 * 1024
+
+// sampleSize is how much of the body of a group is shown
+const sampleSize = 300
+
+// errNoHTTP2 is returned when the server does not negotiate HTTP/2
+var errNoHTTP2 = errors.New("the server does not speak HTTP/2 over TLS, use last-byte instead")
+
+// hopHeaders are not replayed, they describe the original connection
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Te", "Content-Length", "Host"}
+
+// Config describes a race run
+type Config struct {
+	RequestID int    `json:"requestId"`
+	Count     int    `json:"count"`
+	Mode      string `json:"mode"`
+}
+
+// Response is the answer to one copy
+type Response struct {
+	Index  int     `json:"index"`
+	Status int     `json:"status"`
+	Length int     `json:"length"`
+	TimeMs float64 `json:"timeMs"` // from the release to the response headers
+	Group  int     `json:"group"`  // index in Result.Groups, -1 on error
+	Error  string  `json:"error,omitempty"`
+
+	body []byte
+}
+
+// Group is a set of identical responses
+type Group struct {
+	Status  int    `json:"status"`
+	Length  int    `json:"length"`
+	Count   int    `json:"count"`
+	Indexes []int  `json:"indexes"`
+	Sample  string `json:"sample"`
+}
+
+// Result is the outcome of a race run
+type Result struct {
+	RequestID int        `json:"requestId"`
+	URL       string     `json:"url"`
+	Method    string     `json:"method"`
+	Mode      string     `json:"mode"` // mode used, auto resolved
+	Count     int        `json:"count"`
+	Responses []Response `json:"responses"`
+	Groups    []Group    `json:"groups"`
+	Errors    int        `json:"errors"`
+	SpreadMs  float64    `json:"spreadMs"` // between the first and last response headers
+	Divergent bool       `json:"divergent"`
+	Note      string     `json:"note"`
+}
+
+// Tester sends synchronized copies of a stored request
+type Tester struct {
+	db *sql.DB
+}
+
+// NewTester creates a new race condition tester
+func NewTester(db *sql.DB) *Tester {
+	return &Tester{db: db}
+}
+
+// Run sends Count copies of a stored request released at the same moment and
+// groups the identical answers. More than one group, such as several
+// successes where the endpoint should allow one, points at a race condition.
+func (t *Tester) Run(ctx context.Context, config Config) (*Result, error) {
+	count := config.Count
+	if count == 0 {
+		count = DefaultCount
+	}
+	if count < 2 || count > MaxCount {
+		return nil, fmt.Errorf("the number of copies must be between 2 and %d", MaxCount)
+	}
+	mode := strings.ToLower(strings.TrimSpace(config.Mode))
+	if mode == "" {
+		mode = ModeAuto
+	}
+	switch mode {
+	case ModeAuto, ModeSinglePacket, ModeLastByte, ModeParallel:
+	default:
+		return nil, fmt.Errorf("unknown mode %q", config.Mode)
+	}
+
+	stored, err := t.load(config.RequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+
+	var responses []Response
+	if mode == ModeAuto || mode == ModeSinglePacket {
+		if stored.Target.Scheme == "https" {
+			responses, err = sendSinglePacket(ctx, stored, count)
+		} else {
+			err = errNoHTTP2
+		}
+		if err == errNoHTTP2 && mode == ModeAuto {
+			mode, err = ModeLastByte, nil
+		} else if err == nil {
+			mode = ModeSinglePacket
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mode == ModeLastByte || mode == ModeParallel {
+		responses = sendHTTP1(ctx, stored, count, mode == ModeLastByte)
+	}
+
+	result := &Result{
+		RequestID: config.RequestID,
+		URL:       stored.Target.String(),
+		Method:    stored.Method,
+		Mode:      mode,
+		Count:     count,
+		Responses: responses,
+	}
+	compare(result)
+	return result, nil
+}
+
+// compare groups the identical responses and sums up the run
+func compare(result *Result) {
+	result.Groups = []Group{}
+	groups := make(map[string]int)
+	first, last := -1.0, 0.0
+	for i := range result.Responses {
+		response := &result.Responses[i]
+		response.Index = i
+		if response.Error != "" {
+			response.Group = -1
+			result.Errors++
+			continue
+		}
+		if first < 0 || response.TimeMs < first {
+			first = response.TimeMs
+		}
+		if response.TimeMs > last {
+			last = response.TimeMs
+		}
+
+		sum := sha256.Sum256(response.body)
+		key := fmt.Sprintf("%d %x", response.Status, sum)
+		index, ok := groups[key]
+		if !ok {
+			index = len(result.Groups)
+			groups[key] = index
+			sample := response.body
+			if len(sample) > sampleSize {
+				sample = sample[:sampleSize]
+			}
+			result.Groups = append(result.Groups, Group{
+				Status: response.Status,
+				Length: response.Length,
+				Sample: strings.ToValidUTF8(string(sample), "\ufffd"),
+			})
+		}
+		response.Group = index
+		result.Groups[index].Count++
+		result.Groups[index].Indexes = append(result.Groups[index].Indexes, i)
+	}
+	if first >= 0 {
+		result.SpreadMs = last - first
+	}
+
+	result.Divergent = len(result.Groups) > 1
+	switch {
+	case len(result.Groups) == 0:
+		result.Note = "No copy got an answer"
+	case !result.Divergent:
+		result.Note = fmt.Sprintf("All %d answers are identical", result.Count-result.Errors)
+	default:
+		successes := 0
+		for _, group := range result.Groups {
+			if group.Status >= 200 && group.Status < 300 {
+				successes += group.Count
+			}
+		}
+		result.Note = fmt.Sprintf("The answers fall into %d groups and %d copies succeeded; check whether more succeeded than the endpoint should allow",
+			len(result.Groups), successes)
+	}
+}
+
+// load reads a stored request, without the headers describing the original
+// connection
+func (t *Tester) load(requestID int) (*probe.Request, error) {
+	stored, err := probe.Load(t.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range hopHeaders {
+		stored.Header.Del(header)
+	}
+	// Ask for bodies as is, so they can be compared
+	stored.Header.Del("Accept-Encoding")
+	return stored, nil
+}
+
+// dial connects to the target of the request through the upstream chain,
+// with TLS for https offering the given protocols
+func dial(ctx context.Context, target *url.URL, protocols ...string) (net.Conn, error) {
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+	conn, err := upstream.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if target.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := upstream.WithClientCertificate(&tls.Config{InsecureSkipVerify: true}, addr)
+	config.NextProtos = protocols
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	return tlsConn, nil
+}
+
+// sendHTTP1 sends every copy on a connection of its own. All connections are
+// opened and, with lastByte, carry their copy but its last byte before any
+// copy is completed.
+func sendHTTP1(ctx context.Context, stored *probe.Request, count int, lastByte bool) []Response {
+	raw := stored.HTTP1("close")
+	held := len(raw)
+	if lastByte {
+		held = 1
+	}
+
+	gate := NewLastByteGate(count)
+	responses := make([]Response, count)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ticket := gate.Ticket()
+			// A copy failing to connect still lets the others go
+			defer ticket.Arrive()
+
+			conn, err := dial(ctx, stored.Target, "http/1.1")
+			if err != nil {
+				responses[i].Error = err.Error()
+				return
+			}
+			defer conn.Close()
+			if err := ticket.Write(ctx, conn, raw, held); err != nil {
+				responses[i].Error = err.Error()
+				return
+			}
+
+			start := time.Now()
+			resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: stored.Method})
+			if err != nil {
+				responses[i].Error = err.Error()
+				return
+			}
+			responses[i].TimeMs = milliseconds(time.Since(start))
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+			resp.Body.Close()
+			responses[i].Status, responses[i].Length, responses[i].body = resp.StatusCode, len(body), body
+		}(i)
+	}
+	wg.Wait()
+	return responses
+}
+
+// h2Stream is the answer to one copy sent over HTTP/2
+type h2Stream struct {
+	response Response
+	done     bool
+}
+
+// sendSinglePacket sends every copy as a stream of one HTTP/2 connection.
+// The headers, and the body but its last byte, of every stream go first; the
+// final frames of all streams then leave in a single write, small enough for
+// one TCP packet, so the server gets every copy complete at the same time.
+func sendSinglePacket(ctx context.Context, stored *probe.Request, count int) ([]Response, error) {
+	conn, err := dial(ctx, stored.Target, "h2", "http/1.1")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if conn.(*tls.Conn).ConnectionState().NegotiatedProtocol != "h2" {
+		return nil, errNoHTTP2
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	writer := bufio.NewWriterSize(conn, 64*1024)
+	framer := http2.NewFramer(writer, conn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	writer.WriteString(http2.ClientPreface)
+	framer.WriteSettings(
+		http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 24},
+	)
+	framer.WriteWindowUpdate(0, 1<<30)
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	// The server settings bound the streams and frames that can be sent
+	maxStreams, maxFrame, window := uint32(MaxCount), uint32(16384), uint32(65535)
+	for settled := false; !settled; {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the server settings: %v", err)
+		}
+		if settings, ok := frame.(*http2.SettingsFrame); ok && !settings.IsAck() {
+			if v, ok := settings.Value(http2.SettingMaxConcurrentStreams); ok {
+				maxStreams = v
+			}
+			if v, ok := settings.Value(http2.SettingMaxFrameSize); ok {
+				maxFrame = v
+			}
+			if v, ok := settings.Value(http2.SettingInitialWindowSize); ok {
+				window = v
+			}
+			framer.WriteSettingsAck()
+			settled = true
+		}
+	}
+	// The connection window the server opens right after its settings is
+	// known once a ping is answered
+	framer.WritePing(false, [8]byte{'s', 'e', 't', 't', 'i', 'n', 'g', 's'})
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	connWindow, err := awaitPing(framer, writer)
+	if err != nil {
+		return nil, err
+	}
+	connWindow += 65535
+
+	if uint32(count) > maxStreams {
+		return nil, fmt.Errorf("the server allows %d concurrent streams, send fewer copies or use last-byte", maxStreams)
+	}
+	body := []byte(stored.Body)
+	if len(body) > int(window) || count*len(body) > int(connWindow) {
+		return nil, fmt.Errorf("the copies carry more body than the server accepts up front, send fewer copies or use last-byte")
+	}
+
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for i := 0; i < count; i++ {
+		block.Reset()
+		encodeHeaders(encoder, stored)
+		if block.Len() > int(maxFrame) {
+			return nil, fmt.Errorf("the headers do not fit a single HTTP/2 frame")
+		}
+		streamID := uint32(2*i + 1)
+		framer.WriteHeaders(http2.HeadersFrameParam{StreamID: streamID, BlockFragment: block.Bytes(), EndHeaders: true})
+		for rest := body[:max(len(body)-1, 0)]; len(rest) > 0; {
+			chunk := rest[:min(len(rest), int(maxFrame))]
+			framer.WriteData(streamID, false, chunk)
+			rest = rest[len(chunk):]
+		}
+	}
+	// A ping answered means the server has read every frame sent before it
+	framer.WritePing(false, [8]byte{'r', 'e', 'l', 'e', 'a', 's', 'e'})
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := awaitPing(framer, writer); err != nil {
+		return nil, err
+	}
+
+	streams := make([]h2Stream, count)
+	for i := 0; i < count; i++ {
+		var last []byte
+		if len(body) > 0 {
+			last = body[len(body)-1:]
+		}
+		framer.WriteData(uint32(2*i+1), true, last)
+	}
+	start := time.Now()
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	for pending := count; pending > 0; {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			for i := range streams {
+				if !streams[i].done {
+					streams[i].response.Error = err.Error()
+				}
+			}
+			break
+		}
+		index := int(frame.Header().StreamID-1) / 2
+		stream := (*h2Stream)(nil)
+		if frame.Header().StreamID%2 == 1 && index < count && !streams[index].done {
+			stream = &streams[index]
+		}
+
+		ended := false
+		switch f := frame.(type) {
+		case *http2.MetaHeadersFrame:
+			// Informational 1xx answers come before the final headers
+			if status, _ := strconv.Atoi(f.PseudoValue("status")); stream != nil && status >= 200 && stream.response.Status == 0 {
+				stream.response.Status = status
+				stream.response.TimeMs = milliseconds(time.Since(start))
+			}
+			ended = f.StreamEnded()
+		case *http2.DataFrame:
+			if stream != nil && len(stream.response.body) < maxResponseBody {
+				data := f.Data()
+				stream.response.body = append(stream.response.body, data[:min(len(data), maxResponseBody-len(stream.response.body))]...)
+				stream.response.Length = len(stream.response.body)
+			}
+			ended = f.StreamEnded()
+		case *http2.RSTStreamFrame:
+			if stream != nil {
+				stream.response.Error = "stream reset by the server: " + f.ErrCode.String()
+			}
+			ended = true
+		case *http2.GoAwayFrame:
+			for i := range streams {
+				if !streams[i].done && uint32(2*i+1) > f.LastStreamID {
+					streams[i].response.Error = "refused by the server: " + f.ErrCode.String()
+					streams[i].done = true
+					pending--
+				}
+			}
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				framer.WritePing(true, f.Data)
+				writer.Flush()
+			}
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				framer.WriteSettingsAck()
+				writer.Flush()
+			}
+		}
+		if stream != nil && ended {
+			stream.done = true
+			pending--
+		}
+	}
+
+	responses := make([]Response, count)
+	for i, stream := range streams {
+		responses[i] = stream.response
+	}
+	return responses, nil
+}
+
+// awaitPing reads frames until the server acknowledges a ping, and returns
+// by how much the server opened the connection window meanwhile
+func awaitPing(framer *http2.Framer, writer *bufio.Writer) (uint32, error) {
+	var opened uint32
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return 0, fmt.Errorf("connection lost before the release: %v", err)
+		}
+		switch f := frame.(type) {
+		case *http2.PingFrame:
+			if f.IsAck() {
+				return opened, nil
+			}
+			framer.WritePing(true, f.Data)
+			writer.Flush()
+		case *http2.WindowUpdateFrame:
+			if f.StreamID == 0 {
+				opened += f.Increment
+			}
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				framer.WriteSettingsAck()
+				writer.Flush()
+			}
+		case *http2.GoAwayFrame:
+			return 0, fmt.Errorf("the server closed the connection before the release: %s", f.ErrCode)
+		case *http2.RSTStreamFrame:
+			return 0, fmt.Errorf("the server reset a stream before the release: %s", f.ErrCode)
+		}
+	}
+}
+
+// encodeHeaders writes the HTTP/2 header block of the request
+func encodeHeaders(encoder *hpack.Encoder, stored *probe.Request) {
+	encoder.WriteField(hpack.HeaderField{Name: ":method", Value: stored.Method})
+	encoder.WriteField(hpack.HeaderField{Name: ":scheme", Value: stored.Target.Scheme})
+	encoder.WriteField(hpack.HeaderField{Name: ":authority", Value: stored.Authority()})
+	encoder.WriteField(hpack.HeaderField{Name: ":path", Value: stored.Target.RequestURI()})
+	for name, values := range stored.Header {
+		for _, value := range values {
+			encoder.WriteField(hpack.HeaderField{Name: strings.ToLower(name), Value: value})
+		}
+	}
+	if stored.HasBody() {
+		encoder.WriteField(hpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(stored.Body))})
+	}
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
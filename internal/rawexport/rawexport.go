@@ -0,0 +1,214 @@
+package rawexport
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/storage"
+)
+
+// Export formats
+const (
+	FormatSplit    = "split"    // the request in a .req file and the response in a .res file
+	FormatCombined = "combined" // the request followed by the response in one .http file
+)
+
+// Extensions of the exported files
+const (
+	RequestExtension  = ".req"
+	ResponseExtension = ".res"
+	CombinedExtension = ".http"
+)
+
+// Entry is a history entry as the bytes of its messages on the wire
+type Entry struct {
+	ID       int
+	Request  []byte
+	Response []byte // nil when no response was recorded
+	// Incomplete is set when a body is only stored in part or was not logged,
+	// so the bytes are not those exchanged
+	Incomplete bool
+}
+
+// Combined returns the request followed by the response, as they would pass
+// on a connection
+func (e *Entry) Combined() []byte {
+	combined := make([]byte, 0, len(e.Request)+len(e.Response))
+	combined = append(combined, e.Request...)
+	return append(combined, e.Response...)
+}
+
+// Load rebuilds the messages of a history entry. Bodies are read from their
+// blob when they have one, which holds them as received; bodies stored
+// decoded get their content encoding applied again and chunked bodies are
+// chunked again, so the headers describe the bytes that follow them.
+// blobPath returns the path of a blob named in the requests table.
+func Load(db *sql.DB, blobPath func(name string) string, id int) (*Entry, error) {
+	var rawURL, method, httpVersion, status string
+	var requestHeaders, requestBody, responseHeaders, responseBody string
+	var requestEncoding, responseEncoding, requestBlob, responseBlob, transferInfo string
+	var truncated, redacted, hasResponse bool
+	err := db.QueryRow(`
+		SELECT COALESCE(url, ''), method, COALESCE(http_version, ''), COALESCE(status, ''),
+			COALESCE(request_headers, ''), COALESCE(request_body, ''),
+			COALESCE(response_headers, ''), COALESCE(response_body, ''),
+			COALESCE(request_encoding, ''), COALESCE(response_encoding, ''),
+			COALESCE(request_blob, ''), COALESCE(response_blob, ''), COALESCE(transfer_info, ''),
+			COALESCE(body_truncated, 0), COALESCE(body_redacted, 0), response_headers IS NOT NULL
+		FROM requests WHERE id = ?
+	`, id).Scan(&rawURL, &method, &httpVersion, &status, &requestHeaders, &requestBody,
+		&responseHeaders, &responseBody, &requestEncoding, &responseEncoding,
+		&requestBlob, &responseBlob, &transferInfo, &truncated, &redacted, &hasResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch request %d: %v", id, err)
+	}
+
+	var framing storage.TransferInfo
+	if transferInfo != "" {
+		json.Unmarshal([]byte(transferInfo), &framing)
+	}
+	if httpVersion == "" {
+		httpVersion = "HTTP/1.1"
+	}
+
+	entry := &Entry{ID: id, Incomplete: redacted}
+	reqURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of request %d: %v", id, err)
+	}
+	header := parseHeader(requestHeaders)
+	if header.Get("Host") == "" {
+		header.Set("Host", reqURL.Host)
+	}
+	body, complete := wireBody(requestBody, requestEncoding, blobPath(requestBlob), truncated)
+	entry.Incomplete = entry.Incomplete || !complete
+	requestLine := fmt.Sprintf("%s %s %s", method, reqURL.RequestURI(), httpVersion)
+	entry.Request = message(requestLine, header, body, framing.RequestTransferEncoding, framing.RequestTrailers, framing.RequestChunks, method != "GET" && method != "HEAD")
+
+	if hasResponse {
+		body, complete := wireBody(responseBody, responseEncoding, blobPath(responseBlob), truncated)
+		entry.Incomplete = entry.Incomplete || !complete
+		statusLine := strings.TrimSpace(httpVersion + " " + status)
+		entry.Response = message(statusLine, parseHeader(responseHeaders), body, framing.ResponseTransferEncoding, framing.ResponseTrailers, framing.ResponseChunks, true)
+	}
+	return entry, nil
+}
+
+// parseHeader reads headers stored as JSON
+func parseHeader(headersJSON string) http.Header {
+	header := http.Header{}
+	if headersJSON != "" {
+		json.Unmarshal([]byte(headersJSON), &header)
+	}
+	return header
+}
+
+// wireBody returns a body as it was sent, and whether it is complete. The
+// blob holds the body as received; otherwise the stored content, decoded when
+// encoding is set, is encoded again.
+func wireBody(content, encoding, blob string, truncated bool) ([]byte, bool) {
+	if blob != "" {
+		if data, err := os.ReadFile(blob); err == nil {
+			return data, true
+		}
+	}
+	body := []byte(content)
+	if encoding != "" {
+		if encoded, err := contentcoding.Encode(body, encoding); err == nil {
+			body = encoded
+		}
+	}
+	// Without its blob, a body is complete unless the entry was cut
+	return body, !truncated
+}
+
+// message writes a start line, headers sorted by name with Host first, and a
+// body. Chunked messages are chunked again with their trailers, along the
+// recorded chunk boundaries when they cover the body, others get a
+// Content-Length matching the body when withLength is set or a body follows.
+// The Content-Length of a message without body, such as the answer to a HEAD
+// request, is kept.
+func message(startLine string, header http.Header, body []byte, transferEncoding []string, trailers http.Header, chunks []storage.Chunk, withLength bool) []byte {
+	chunked := false
+	for _, coding := range transferEncoding {
+		if strings.EqualFold(coding, "chunked") {
+			chunked = true
+		}
+	}
+	header = header.Clone()
+	if chunked {
+		header.Del("Content-Length")
+		header.Set("Transfer-Encoding", strings.Join(transferEncoding, ", "))
+	} else if len(body) > 0 || (withLength && header.Get("Content-Length") == "") {
+		header.Del("Transfer-Encoding")
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	var out bytes.Buffer
+	out.WriteString(startLine + "\r\n")
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "Host" || names[j] == "Host" {
+			return names[i] == "Host"
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(&out, "%s: %s\r\n", name, value)
+		}
+	}
+	out.WriteString("\r\n")
+
+	if !chunked {
+		out.Write(body)
+		return out.Bytes()
+	}
+	if !coversBody(chunks, len(body)) {
+		chunks = nil
+		if len(body) > 0 {
+			chunks = []storage.Chunk{{Size: int64(len(body))}}
+		}
+	}
+	for _, chunk := range chunks {
+		fmt.Fprintf(&out, "%x", chunk.Size)
+		if chunk.Extension != "" {
+			out.WriteString(";" + chunk.Extension)
+		}
+		out.WriteString("\r\n")
+		out.Write(body[chunk.Offset : chunk.Offset+chunk.Size])
+		out.WriteString("\r\n")
+	}
+	out.WriteString("0\r\n")
+	trailers.Write(&out)
+	out.WriteString("\r\n")
+	return out.Bytes()
+}
+
+// coversBody reports whether chunks follow each other from the start to the
+// end of a body of the given length
+func coversBody(chunks []storage.Chunk, length int) bool {
+	if len(chunks) == 0 {
+		return false
+	}
+	var offset int64
+	for _, chunk := range chunks {
+		if chunk.Offset != offset || chunk.Size <= 0 {
+			return false
+		}
+		offset += chunk.Size
+	}
+	return offset == int64(length)
+}
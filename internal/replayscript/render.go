@@ -0,0 +1,311 @@
+package replayscript
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// expression joins segments into a string concatenation of quoted literals
+// and variable references
+func expression(segments []segment, quote func(string) string, reference func(string) string) string {
+	if len(segments) == 0 {
+		return quote("")
+	}
+	parts := make([]string, len(segments))
+	for i, part := range segments {
+		if part.variable != "" {
+			parts[i] = reference(part.variable)
+		} else {
+			parts[i] = quote(part.literal)
+		}
+	}
+	return strings.Join(parts, " + ")
+}
+
+// headerValue joins the values of a header the way they travel on the wire
+func headerValue(name string, values []string) string {
+	if http.CanonicalHeaderKey(name) == "Cookie" {
+		return strings.Join(values, "; ")
+	}
+	return strings.Join(values, ", ")
+}
+
+// quoteJSON quotes a string as a JSON string, which is also a valid Python
+// and JavaScript literal
+func quoteJSON(value string) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+func (s *Script) renderGo() ([]byte, error) {
+	quote := strconv.Quote
+	reference := func(name string) string { return fmt.Sprintf("vars[%s]", strconv.Quote(name)) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `package replay
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestReplay replays %d requests exported from ProKZee in the order they
+// were captured
+func TestReplay(t *testing.T) {
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	vars := map[string]string{}
+	_ = vars
+`, len(s.Steps))
+
+	for i, step := range s.Steps {
+		fmt.Fprintf(&b, "\n\t// %d. %s %s\n\t{\n", i+1, step.Method, step.URL)
+		fmt.Fprintf(&b, "\t\treq, err := http.NewRequest(%s, %s, strings.NewReader(%s))\n",
+			quote(step.Method), expression(s.split(step.URL, i), quote, reference), expression(s.split(step.Body, i), quote, reference))
+		b.WriteString("\t\tif err != nil {\n\t\t\tt.Fatal(err)\n\t\t}\n")
+		for _, name := range headerNames(step) {
+			value := headerValue(name, step.Headers[name])
+			fmt.Fprintf(&b, "\t\treq.Header[%s] = []string{%s}\n", quote(name), expression(s.split(value, i), quote, reference))
+		}
+
+		extractions := s.extractions(i)
+		bodyName := "_"
+		for _, variable := range extractions {
+			if variable.Source == SourceJSON {
+				bodyName = "body"
+			}
+		}
+		if step.Status == 0 && len(extractions) == 0 {
+			b.WriteString("\t\tsend(t, client, req)\n")
+		} else {
+			fmt.Fprintf(&b, "\t\tresp, %s := send(t, client, req)\n", bodyName)
+		}
+		if step.Status != 0 {
+			fmt.Fprintf(&b, "\t\tif resp.StatusCode != %d {\n\t\t\tt.Errorf(\"step %d: status %%d, want %d\", resp.StatusCode)\n\t\t}\n", step.Status, i+1, step.Status)
+		}
+		for _, variable := range extractions {
+			switch variable.Source {
+			case SourceCookie:
+				fmt.Fprintf(&b, "\t\t%s = cookie(resp, %s)\n", reference(variable.Name), quote(variable.Key))
+			case SourceHeader:
+				fmt.Fprintf(&b, "\t\t%s = resp.Header.Get(%s)\n", reference(variable.Name), quote(variable.Key))
+			case SourceJSON:
+				quoted := make([]string, len(variable.Path))
+				for j, key := range variable.Path {
+					quoted[j] = quote(key)
+				}
+				fmt.Fprintf(&b, "\t\t%s = jsonValue(body, %s)\n", reference(variable.Name), strings.Join(quoted, ", "))
+			}
+		}
+		b.WriteString("\t}\n")
+	}
+
+	b.WriteString(`}
+
+// send performs a request and reads the whole response body
+func send(t *testing.T, client *http.Client, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+// cookie returns the value of a cookie set by the response
+func cookie(resp *http.Response, name string) string {
+	for _, c := range resp.Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// jsonValue returns the string at a path of object keys and array indexes
+func jsonValue(body []byte, path ...string) string {
+	var node interface{}
+	if json.Unmarshal(body, &node) != nil {
+		return ""
+	}
+	for _, key := range path {
+		switch value := node.(type) {
+		case map[string]interface{}:
+			node = value[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(value) {
+				return ""
+			}
+			node = value[index]
+		default:
+			return ""
+		}
+	}
+	text, _ := node.(string)
+	return text
+}
+`)
+
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to format Go script: %v", err)
+	}
+	return formatted, nil
+}
+
+func (s *Script) renderPython() []byte {
+	reference := func(name string) string { return fmt.Sprintf("v[%s]", quoteJSON(name)) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `#!/usr/bin/env python3
+"""Replays %d requests exported from ProKZee in the order they were captured."""
+import requests
+import urllib3
+
+urllib3.disable_warnings(urllib3.exceptions.InsecureRequestWarning)
+
+
+def json_value(resp, *path):
+    """Returns the value at a path of object keys and array indexes."""
+    try:
+        value = resp.json()
+        for key in path:
+            value = value[int(key)] if isinstance(value, list) else value[key]
+        return value
+    except (ValueError, KeyError, IndexError, TypeError):
+        return ""
+
+
+def main():
+    v = {}
+`, len(s.Steps))
+
+	for i, step := range s.Steps {
+		fmt.Fprintf(&b, "\n    # %d. %s %s\n", i+1, step.Method, step.URL)
+		if names := headerNames(step); len(names) == 0 {
+			b.WriteString("    headers = {}\n")
+		} else {
+			b.WriteString("    headers = {\n")
+			for _, name := range names {
+				value := headerValue(name, step.Headers[name])
+				fmt.Fprintf(&b, "        %s: %s,\n", quoteJSON(name), expression(s.split(value, i), quoteJSON, reference))
+			}
+			b.WriteString("    }\n")
+		}
+		data := "None"
+		if step.Body != "" {
+			data = "(" + expression(s.split(step.Body, i), quoteJSON, reference) + ").encode()"
+		}
+		fmt.Fprintf(&b, "    resp = requests.request(%s, %s, headers=headers, data=%s, allow_redirects=False, verify=False)\n",
+			quoteJSON(step.Method), expression(s.split(step.URL, i), quoteJSON, reference), data)
+		if step.Status != 0 {
+			fmt.Fprintf(&b, "    assert resp.status_code == %d, f\"step %d: status {resp.status_code}, want %d\"\n", step.Status, i+1, step.Status)
+		}
+		for _, variable := range s.extractions(i) {
+			switch variable.Source {
+			case SourceCookie:
+				fmt.Fprintf(&b, "    %s = resp.cookies.get(%s, \"\")\n", reference(variable.Name), quoteJSON(variable.Key))
+			case SourceHeader:
+				fmt.Fprintf(&b, "    %s = resp.headers.get(%s, \"\")\n", reference(variable.Name), quoteJSON(variable.Key))
+			case SourceJSON:
+				quoted := make([]string, len(variable.Path))
+				for j, key := range variable.Path {
+					quoted[j] = quoteJSON(key)
+				}
+				fmt.Fprintf(&b, "    %s = json_value(resp, %s)\n", reference(variable.Name), strings.Join(quoted, ", "))
+			}
+		}
+	}
+
+	b.WriteString(`
+
+if __name__ == "__main__":
+    main()
+`)
+	return []byte(b.String())
+}
+
+func (s *Script) renderK6() []byte {
+	reference := func(name string) string { return fmt.Sprintf("v[%s]", quoteJSON(name)) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `import http from "k6/http";
+import { check } from "k6";
+
+// Replays %d requests exported from ProKZee in the order they were captured
+export const options = { insecureSkipTLSVerify: true };
+
+export default function () {
+  const v = {};
+  let res;
+`, len(s.Steps))
+
+	for i, step := range s.Steps {
+		fmt.Fprintf(&b, "\n  // %d. %s %s\n", i+1, step.Method, step.URL)
+		body := "null"
+		if step.Body != "" {
+			body = expression(s.split(step.Body, i), quoteJSON, reference)
+		}
+		fmt.Fprintf(&b, "  res = http.request(%s, %s, %s, {\n",
+			quoteJSON(step.Method), expression(s.split(step.URL, i), quoteJSON, reference), body)
+		if names := headerNames(step); len(names) == 0 {
+			b.WriteString("    headers: {},\n")
+		} else {
+			b.WriteString("    headers: {\n")
+			for _, name := range names {
+				value := headerValue(name, step.Headers[name])
+				fmt.Fprintf(&b, "      %s: %s,\n", quoteJSON(name), expression(s.split(value, i), quoteJSON, reference))
+			}
+			b.WriteString("    },\n")
+		}
+		// A fresh jar keeps k6 from adding cookies next to the recorded ones
+		b.WriteString("    redirects: 0,\n    jar: new http.CookieJar(),\n  });\n")
+		if step.Status != 0 {
+			fmt.Fprintf(&b, "  check(res, { %s: (r) => r.status === %d });\n", quoteJSON(fmt.Sprintf("step %d status is %d", i+1, step.Status)), step.Status)
+		}
+		for _, variable := range s.extractions(i) {
+			switch variable.Source {
+			case SourceCookie:
+				fmt.Fprintf(&b, "  %s = res.cookies[%s] ? res.cookies[%s][0].value : \"\";\n",
+					reference(variable.Name), quoteJSON(variable.Key), quoteJSON(variable.Key))
+			case SourceHeader:
+				fmt.Fprintf(&b, "  %s = res.headers[%s] || \"\";\n", reference(variable.Name), quoteJSON(variable.Key))
+			case SourceJSON:
+				fmt.Fprintf(&b, "  %s = res.json(%s) || \"\";\n", reference(variable.Name), quoteJSON(strings.Join(variable.Path, ".")))
+			}
+		}
+	}
+
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// extractions returns the variables the response of step index issues
+func (s *Script) extractions(index int) []Variable {
+	var variables []Variable
+	for _, variable := range s.Variables {
+		if variable.Step == index {
+			variables = append(variables, variable)
+		}
+	}
+	return variables
+}
@@ -0,0 +1,380 @@
+package replayscript
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Script formats
+const (
+	FormatGoTest = "go"
+	FormatPython = "python"
+	FormatK6     = "k6"
+)
+
+// Sources of dynamic values
+const (
+	SourceCookie = "cookie" // a Set-Cookie of the response
+	SourceHeader = "header" // a response header
+	SourceJSON   = "json"   // a string field of a JSON response body
+)
+
+// MaxRequests bounds how many requests a script replays
+const MaxRequests = 500
+
+// minDynamicLength is the shortest value treated as dynamic, shorter ones
+// match by accident too often
+const minDynamicLength = 6
+
+// skippedRequestHeaders are set by the HTTP client of the script
+var skippedRequestHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Proxy-Connection":  true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Accept-Encoding":   true,
+	"Upgrade":           true,
+}
+
+// staticResponseHeaders never carry values worth extracting
+var staticResponseHeaders = map[string]bool{
+	"Date":                      true,
+	"Server":                    true,
+	"Content-Type":              true,
+	"Content-Length":            true,
+	"Content-Encoding":          true,
+	"Transfer-Encoding":         true,
+	"Connection":                true,
+	"Cache-Control":             true,
+	"Expires":                   true,
+	"Last-Modified":             true,
+	"Vary":                      true,
+	"Set-Cookie":                true,
+	"Strict-Transport-Security": true,
+	"Content-Security-Policy":   true,
+}
+
+// Filter selects the history entries to export. IDs take precedence over the
+// search, which matches the URL.
+type Filter struct {
+	IDs    []int  `json:"ids"`
+	Search string `json:"search"`
+	Domain string `json:"domain"`
+}
+
+// Step is one recorded request of the script
+type Step struct {
+	ID      int
+	Method  string
+	URL     string
+	Headers http.Header
+	Body    string
+	Status  int
+
+	responseHeaders http.Header
+	responseBody    string
+}
+
+// Variable is a value issued by a response and sent again by later requests.
+// The script extracts it at runtime instead of replaying the recorded value.
+type Variable struct {
+	Name   string   `json:"name"`
+	Source string   `json:"source"`
+	Key    string   `json:"key"`  // cookie or header name
+	Path   []string `json:"path"` // JSON path for SourceJSON
+	Step   int      `json:"step"` // index of the step whose response issues it
+	Value  string   `json:"value"`
+}
+
+// Script is a replayable slice of history
+type Script struct {
+	Steps     []Step
+	Variables []Variable
+}
+
+// Load reads the selected history entries in the order they were captured
+func Load(db *sql.DB, filter Filter) ([]Step, error) {
+	query := `
+		SELECT id, method, url, COALESCE(request_headers, ''), COALESCE(request_body, ''),
+			COALESCE(status, ''), COALESCE(response_headers, ''), COALESCE(response_body, '')
+		FROM requests WHERE 1=1`
+	params := []interface{}{}
+
+	if len(filter.IDs) > 0 {
+		if len(filter.IDs) > MaxRequests {
+			return nil, fmt.Errorf("cannot export more than %d requests", MaxRequests)
+		}
+		placeholders := make([]string, len(filter.IDs))
+		for i, id := range filter.IDs {
+			placeholders[i] = "?"
+			params = append(params, id)
+		}
+		query += " AND id IN (" + strings.Join(placeholders, ",") + ")"
+	} else {
+		if filter.Search != "" {
+			query += " AND LOWER(url) LIKE ?"
+			params = append(params, "%"+strings.ToLower(filter.Search)+"%")
+		}
+		if filter.Domain != "" {
+			query += " AND LOWER(domain) = ?"
+			params = append(params, strings.ToLower(filter.Domain))
+		}
+	}
+	query += " ORDER BY id ASC LIMIT ?"
+	params = append(params, MaxRequests)
+
+	rows, err := db.Query(query, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load requests: %v", err)
+	}
+	defer rows.Close()
+
+	var steps []Step
+	for rows.Next() {
+		var step Step
+		var rawHeaders, status, rawResponseHeaders string
+		if err := rows.Scan(&step.ID, &step.Method, &step.URL, &rawHeaders, &step.Body,
+			&status, &rawResponseHeaders, &step.responseBody); err != nil {
+			return nil, fmt.Errorf("failed to read request: %v", err)
+		}
+		step.Headers = parseHeaders(rawHeaders)
+		step.responseHeaders = parseHeaders(rawResponseHeaders)
+		fmt.Sscanf(status, "%d", &step.Status)
+		steps = append(steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load requests: %v", err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no requests match the filter")
+	}
+	return steps, nil
+}
+
+func parseHeaders(raw string) http.Header {
+	headers := http.Header{}
+	if raw != "" {
+		json.Unmarshal([]byte(raw), &headers)
+	}
+	return headers
+}
+
+// New builds a script from the steps, finding the dynamic values that later
+// requests depend on
+func New(steps []Step) *Script {
+	script := &Script{Steps: steps}
+	seen := make(map[string]bool)
+	names := make(map[string]int)
+
+	for i, step := range steps {
+		for _, candidate := range candidates(step) {
+			if seen[candidate.Value] || !sentAfter(steps, i, candidate.Value) || sentBefore(steps, i, candidate.Value) {
+				continue
+			}
+			seen[candidate.Value] = true
+			candidate.Step = i
+			candidate.Name = uniqueName(names, candidate.Name)
+			script.Variables = append(script.Variables, candidate)
+		}
+	}
+	return script
+}
+
+// candidates lists the values the response of a step issues
+func candidates(step Step) []Variable {
+	var found []Variable
+
+	response := http.Response{Header: step.responseHeaders}
+	for _, cookie := range response.Cookies() {
+		if len(cookie.Value) >= minDynamicLength {
+			found = append(found, Variable{Name: cookie.Name, Source: SourceCookie, Key: cookie.Name, Value: cookie.Value})
+		}
+	}
+
+	names := make([]string, 0, len(step.responseHeaders))
+	for name := range step.responseHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if staticResponseHeaders[canonical] || strings.HasPrefix(canonical, "Access-Control-") {
+			continue
+		}
+		if value := step.responseHeaders.Get(name); len(value) >= minDynamicLength {
+			found = append(found, Variable{Name: canonical, Source: SourceHeader, Key: canonical, Value: value})
+		}
+	}
+
+	var body interface{}
+	if json.Unmarshal([]byte(step.responseBody), &body) == nil {
+		walkJSON(body, nil, func(path []string, value string) {
+			if len(value) >= minDynamicLength {
+				found = append(found, Variable{Name: path[len(path)-1], Source: SourceJSON, Path: path, Value: value})
+			}
+		})
+	}
+	return found
+}
+
+// walkJSON calls visit for every string in a decoded JSON document, in a
+// stable order
+func walkJSON(node interface{}, path []string, visit func(path []string, value string)) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			walkJSON(value[key], append(append([]string{}, path...), key), visit)
+		}
+	case []interface{}:
+		for i, item := range value {
+			walkJSON(item, append(append([]string{}, path...), strconv.Itoa(i)), visit)
+		}
+	case string:
+		if len(path) > 0 {
+			visit(path, value)
+		}
+	}
+}
+
+// sentAfter reports whether a request after step index sends value
+func sentAfter(steps []Step, index int, value string) bool {
+	for _, step := range steps[index+1:] {
+		if sends(step, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// sentBefore reports whether value was sent before the response of step index
+// issued it, in which case it was not issued by that response
+func sentBefore(steps []Step, index int, value string) bool {
+	for _, step := range steps[:index+1] {
+		if sends(step, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func sends(step Step, value string) bool {
+	if strings.Contains(step.URL, value) || strings.Contains(step.Body, value) {
+		return true
+	}
+	for name, values := range step.Headers {
+		if skippedRequestHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, v := range values {
+			if strings.Contains(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func uniqueName(names map[string]int, name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name = b.String()
+	if name == "" {
+		name = "value"
+	}
+	names[name]++
+	if names[name] > 1 {
+		return fmt.Sprintf("%s_%d", name, names[name])
+	}
+	return name
+}
+
+// segment is a literal, or a variable reference when variable is set
+type segment struct {
+	literal  string
+	variable string
+}
+
+// split cuts text into literals and references to the variables issued
+// before step index, preferring the longest value at each position
+func (s *Script) split(text string, index int) []segment {
+	var segments []segment
+	for text != "" {
+		position, length, name := -1, 0, ""
+		for _, variable := range s.Variables {
+			if variable.Step >= index {
+				continue
+			}
+			at := strings.Index(text, variable.Value)
+			if at < 0 {
+				continue
+			}
+			if position < 0 || at < position || at == position && len(variable.Value) > length {
+				position, length, name = at, len(variable.Value), variable.Name
+			}
+		}
+		if position < 0 {
+			segments = append(segments, segment{literal: text})
+			break
+		}
+		if position > 0 {
+			segments = append(segments, segment{literal: text[:position]})
+		}
+		segments = append(segments, segment{variable: name})
+		text = text[position+length:]
+	}
+	return segments
+}
+
+// headerNames returns the request headers of a step the script sends, sorted
+func headerNames(step Step) []string {
+	names := make([]string, 0, len(step.Headers))
+	for name := range step.Headers {
+		if !skippedRequestHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render writes the script in the given format
+func (s *Script) Render(format string) ([]byte, error) {
+	switch format {
+	case FormatGoTest:
+		return s.renderGo()
+	case FormatPython:
+		return s.renderPython(), nil
+	case FormatK6:
+		return s.renderK6(), nil
+	}
+	return nil, fmt.Errorf("unknown script format: %s", format)
+}
+
+// Extension returns the file extension of a format
+func Extension(format string) string {
+	switch format {
+	case FormatGoTest:
+		return "_test.go"
+	case FormatPython:
+		return ".py"
+	}
+	return ".js"
+}
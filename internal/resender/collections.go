@@ -0,0 +1,374 @@
+package resender
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Collection formats the resender imports and exports
+const (
+	CollectionFormatPostman    = "postman"
+	CollectionFormatInsomnia   = "insomnia"
+	CollectionFormatHoppscotch = "hoppscotch"
+)
+
+// skippedExportHeaders are set by the API clients themselves and left out of
+// exports
+var skippedExportHeaders = map[string]bool{
+	"Host":           true,
+	"Content-Length": true,
+	"Connection":     true,
+}
+
+// CollectionImport summarizes an imported collection
+type CollectionImport struct {
+	Collection string   `json:"collection"`
+	Tabs       int      `json:"tabs"`
+	Variables  int      `json:"variables"`
+	Skipped    []string `json:"skipped"`
+}
+
+// collectionHeader is a request header of an exported tab
+type collectionHeader struct {
+	Name  string
+	Value string
+}
+
+// collectionTab is the latest request of a tab, as exported
+type collectionTab struct {
+	Name    string
+	Group   string
+	Method  string
+	URL     string
+	Headers []collectionHeader
+	Body    string
+}
+
+// folders returns the folder path of the tab group
+func (t collectionTab) folders() []string {
+	var folders []string
+	for _, part := range strings.Split(t.Group, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			folders = append(folders, part)
+		}
+	}
+	return folders
+}
+
+// loadCollectionTabs reads the latest request of the given tabs, or of every
+// tab when none are given, in tab order
+func (r *Resender) loadCollectionTabs(tabIDs []int) ([]collectionTab, error) {
+	selected := make(map[int]bool, len(tabIDs))
+	for _, id := range tabIDs {
+		selected[id] = true
+	}
+
+	rows, err := r.db.Query("SELECT id, name, request_ids_arr, COALESCE(group_name, '') FROM resender_tabs ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch resender tabs: %v", err)
+	}
+	var tabs []collectionTab
+	var requestIDs []int
+	for rows.Next() {
+		var id int
+		var tab collectionTab
+		var requestIDsJSON string
+		if err := rows.Scan(&id, &tab.Name, &requestIDsJSON, &tab.Group); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan resender tab: %v", err)
+		}
+		if len(selected) > 0 && !selected[id] {
+			continue
+		}
+		var ids []int
+		if json.Unmarshal([]byte(requestIDsJSON), &ids) != nil || len(ids) == 0 {
+			continue
+		}
+		tabs = append(tabs, tab)
+		requestIDs = append(requestIDs, ids[len(ids)-1])
+	}
+	rows.Close()
+	if len(tabs) == 0 {
+		return nil, fmt.Errorf("no resender tabs to export")
+	}
+
+	for i := range tabs {
+		var headersJSON string
+		err := r.db.QueryRow(`
+			SELECT url, method, COALESCE(request_headers, '{}'), COALESCE(request_body, '')
+			FROM resender_requests WHERE id = ?
+		`, requestIDs[i]).Scan(&tabs[i].URL, &tabs[i].Method, &headersJSON, &tabs[i].Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch request %d: %v", requestIDs[i], err)
+		}
+
+		var headers map[string]interface{}
+		json.Unmarshal([]byte(headersJSON), &headers)
+		names := make([]string, 0, len(headers))
+		for name := range headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !skippedExportHeaders[http.CanonicalHeaderKey(name)] {
+				tabs[i].Headers = append(tabs[i].Headers, collectionHeader{Name: name, Value: headerString(headers[name])})
+			}
+		}
+	}
+	return tabs, nil
+}
+
+// usedVariables returns the variables the tabs refer to
+func (r *Resender) usedVariables(tabs []collectionTab) ([]Variable, error) {
+	used := make(map[string]bool)
+	for _, tab := range tabs {
+		text := tab.URL + tab.Body
+		for _, header := range tab.Headers {
+			text += header.Name + header.Value
+		}
+		for _, name := range referencedVariables(text) {
+			used[name] = true
+		}
+	}
+
+	variables, err := r.GetVariables()
+	if err != nil {
+		return nil, err
+	}
+	var found []Variable
+	for _, variable := range variables {
+		if used[variable.Name] {
+			found = append(found, variable)
+		}
+	}
+	return found, nil
+}
+
+// encodeCollection writes an indented JSON document without escaping the &
+// of query strings
+func encodeCollection(collection interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(collection); err != nil {
+		return nil, fmt.Errorf("failed to encode collection: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// importedTab is a request of an imported collection
+type importedTab struct {
+	Name    string
+	Group   string
+	Method  string
+	URL     string
+	Headers map[string]interface{}
+	Body    string
+}
+
+// addHeader adds a header, joining repeated ones
+func (t *importedTab) addHeader(name, value string) {
+	if existing, ok := t.Headers[name].(string); ok {
+		t.Headers[name] = existing + ", " + value
+	} else {
+		t.Headers[name] = value
+	}
+}
+
+// setDefaultHeader sets a header unless the request already has it
+func (t *importedTab) setDefaultHeader(name, value string) {
+	for existing := range t.Headers {
+		if strings.EqualFold(existing, name) {
+			return
+		}
+	}
+	t.Headers[name] = value
+}
+
+// addQuery appends a query parameter to the URL
+func (t *importedTab) addQuery(name, value string) {
+	separator := "?"
+	if strings.Contains(t.URL, "?") {
+		separator = "&"
+	}
+	t.URL += separator + name + "=" + value
+}
+
+// formField is a field of an url-encoded or multipart body
+type formField struct {
+	Name  string
+	Value string
+	File  bool
+}
+
+// setForm sets an url-encoded or multipart body. File fields cannot be
+// carried over and are returned as skipped.
+func (t *importedTab) setForm(fields []formField, multipartForm bool) []string {
+	var skipped []string
+	if !multipartForm {
+		var pairs []string
+		for _, field := range fields {
+			pairs = append(pairs, escapeTemplate(field.Name)+"="+escapeTemplate(field.Value))
+		}
+		t.Body = strings.Join(pairs, "&")
+		t.setDefaultHeader("Content-Type", "application/x-www-form-urlencoded")
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	for _, field := range fields {
+		if field.File {
+			skipped = append(skipped, fmt.Sprintf("file field %q is not imported", field.Name))
+			continue
+		}
+		writer.WriteField(field.Name, field.Value)
+	}
+	writer.Close()
+	t.Body = buffer.String()
+	t.setDefaultHeader("Content-Type", writer.FormDataContentType())
+	return skipped
+}
+
+// setGraphQL sets a GraphQL query as a JSON body
+func (t *importedTab) setGraphQL(query, variables string) error {
+	payload := map[string]interface{}{"query": query}
+	if variables = strings.TrimSpace(variables); variables != "" {
+		payload["variables"] = json.RawMessage(variables)
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("invalid GraphQL variables")
+	}
+	t.Body = string(encoded)
+	t.setDefaultHeader("Content-Type", "application/json")
+	return nil
+}
+
+// basicAuthorization encodes basic credentials. They are encoded at import,
+// so variables are expanded with their current values.
+func basicAuthorization(username, password string, values map[string]string) string {
+	credentials := expandVariables(username+":"+password, values)
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+// importTabs stores the tabs of an imported collection in one transaction
+func (r *Resender) importTabs(tabs []importedTab) error {
+	values := r.variableValues()
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, tab := range tabs {
+		if tab.Method == "" {
+			tab.Method = http.MethodGet
+		}
+		if _, _, err := insertTab(tx, tab, values); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// escapeTemplate form-encodes text except for its {{name}} placeholders
+func escapeTemplate(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range variablePattern.FindAllStringIndex(text, -1) {
+		b.WriteString(url.QueryEscape(text[last:match[0]]))
+		b.WriteString(text[match[0]:match[1]])
+		last = match[1]
+	}
+	b.WriteString(url.QueryEscape(text[last:]))
+	return b.String()
+}
+
+// insertTab stores a request and a tab showing it, returning the IDs of both.
+// The URL components are taken from the URL with the variables expanded.
+func insertTab(tx *sql.Tx, tab importedTab, values map[string]string) (int64, int, error) {
+	var domain, port, path, query string
+	if parsedURL, err := url.Parse(expandVariables(tab.URL, values)); err == nil {
+		domain = parsedURL.Hostname()
+		port = parsedURL.Port()
+		if port == "" {
+			if parsedURL.Scheme == "https" {
+				port = "443"
+			} else {
+				port = "80"
+			}
+		}
+		path = parsedURL.Path
+		if path == "" {
+			path = "/"
+		}
+		query = parsedURL.RawQuery
+	}
+
+	headersJSON, err := json.Marshal(tab.Headers)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to marshal headers: %v", err)
+	}
+
+	var requestID int
+	err = tx.QueryRow(`
+		INSERT INTO resender_requests (request_id, domain, port, path, query, url, method, request_headers, request_body, response_headers, response_body, http_version, status, mime_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, uuid.New().String(), domain, port, path, query, tab.URL, tab.Method, string(headersJSON), tab.Body, "{}", "", "HTTP/1.1", "", "").Scan(&requestID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to save request to database: %v", err)
+	}
+
+	requestIDsArr, _ := json.Marshal([]int{requestID})
+	result, err := tx.Exec(`
+		INSERT INTO resender_tabs (name, request_ids_arr, group_name)
+		VALUES (?, ?, ?)
+	`, tab.Name, string(requestIDsArr), tab.Group)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to save resender tab to database: %v", err)
+	}
+	tabID, err := result.LastInsertId()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get last insert ID: %v", err)
+	}
+	return tabID, requestID, nil
+}
+
+// headerString returns a stored header value, a string or a list of strings
+func headerString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, part := range v {
+			parts = append(parts, fmt.Sprint(part))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return fmt.Sprint(value)
+}
+
+// valueString returns a JSON value of any type as a string
+func valueString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,451 @@
+package resender
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// CurlImport is the resender tab created from a curl command
+type CurlImport struct {
+	TabID   int64    `json:"tabId"`
+	Skipped []string `json:"skipped"`
+}
+
+// curlValueOptions take a value and are applied to the request
+var curlValueOptions = map[string]bool{
+	"-X": true, "--request": true, "-H": true, "--header": true, "-d": true, "--data": true,
+	"--data-ascii": true, "--data-raw": true, "--data-binary": true, "--data-urlencode": true,
+	"--json": true, "-F": true, "--form": true, "--form-string": true, "-u": true, "--user": true,
+	"-A": true, "--user-agent": true, "-e": true, "--referer": true, "-b": true, "--cookie": true,
+	"--url": true, "--url-query": true, "--oauth2-bearer": true,
+}
+
+// curlIgnoredValueOptions take a value that only matters to curl itself, such
+// as where output goes or how the connection is made
+var curlIgnoredValueOptions = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"-x": true, "--proxy": true, "-U": true, "--proxy-user": true, "--resolve": true, "--connect-to": true,
+	"--cacert": true, "--capath": true, "-E": true, "--cert": true, "--key": true, "--cert-type": true,
+	"--key-type": true, "-w": true, "--write-out": true, "--retry": true, "--retry-delay": true,
+	"--retry-max-time": true, "-c": true, "--cookie-jar": true, "-D": true, "--dump-header": true,
+	"--max-redirs": true, "--limit-rate": true, "-r": true, "--range": true, "--interface": true,
+	"--ciphers": true, "--tls-max": true, "-K": true, "--config": true, "--trace": true,
+	"--trace-ascii": true, "--stderr": true, "-T": true, "--upload-file": true,
+}
+
+// ImportCurl creates a resender tab from a curl command line, as copied from
+// browser developer tools or a bug report. Options that only change how curl
+// runs are ignored; options that cannot be carried over, such as bodies read
+// from files, are returned as skipped.
+func (r *Resender) ImportCurl(command string) (*CurlImport, error) {
+	tab, skipped, err := parseCurl(command)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	tabID, requestID, err := insertTab(tx, *tab, r.variableValues())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	runtime.EventsEmit(r.ctx, "backend:newTabCreated", map[string]interface{}{
+		"tabId":     tabID,
+		"requestId": requestID,
+	})
+	return &CurlImport{TabID: tabID, Skipped: skipped}, nil
+}
+
+// parseCurl converts a curl command line into the request it sends
+func parseCurl(command string) (*importedTab, []string, error) {
+	args, err := splitShellWords(command)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) == 0 || strings.TrimSuffix(path.Base(strings.ReplaceAll(args[0], `\`, "/")), ".exe") != "curl" {
+		return nil, nil, fmt.Errorf("not a curl command")
+	}
+
+	tab := &importedTab{Headers: map[string]interface{}{}}
+	skipped := []string{}
+	var data, queries []string
+	var fields []formField
+	method, rawURL := "", ""
+	get, head := false, false
+
+	// Headers curl adds for its options, such as Authorization for -u, give
+	// way to the same header given with -H, whatever the order
+	derived := map[string]bool{}
+	deriveHeader := func(name, value string) {
+		if headerKey(tab.Headers, name) == "" {
+			tab.Headers[name] = value
+			derived[strings.ToLower(name)] = true
+		}
+	}
+	dropDerived := func(name string) {
+		if derived[strings.ToLower(name)] {
+			delete(tab.Headers, headerKey(tab.Headers, name))
+			delete(derived, strings.ToLower(name))
+		}
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			rawURL = arg
+			continue
+		}
+		name, value, hasValue := arg, "", false
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			// --name=value is not accepted by curl, only --name value
+		case len(arg) > 2:
+			// Combined short flags, as in -sSL, where only -I and -G change
+			// the request. A flag taking a value ends them, its value being
+			// the rest of the argument or the next one, as in -XPOST,
+			// -sXDELETE or -sSLd 'a=b'.
+			name = ""
+			for j := 1; j < len(arg) && name == ""; j++ {
+				flag := "-" + arg[j:j+1]
+				switch {
+				case curlValueOptions[flag] || curlIgnoredValueOptions[flag]:
+					name, value, hasValue = flag, arg[j+1:], j+1 < len(arg)
+				case flag == "-I":
+					head = true
+				case flag == "-G":
+					get = true
+				}
+			}
+			if name == "" {
+				continue
+			}
+		}
+
+		if curlValueOptions[name] || curlIgnoredValueOptions[name] {
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("option %s is missing its value", name)
+				}
+				i++
+				value = args[i]
+			}
+			if curlIgnoredValueOptions[name] {
+				continue
+			}
+		}
+
+		switch name {
+		case "-X", "--request":
+			method = value
+		case "-H", "--header":
+			headerName, headerValue, ok := strings.Cut(value, ":")
+			switch {
+			case ok && strings.TrimSpace(headerValue) != "":
+				dropDerived(strings.TrimSpace(headerName))
+				tab.addHeader(strings.TrimSpace(headerName), strings.TrimSpace(headerValue))
+			case ok:
+				// "Name:" only removes a header curl would add
+				dropDerived(strings.TrimSpace(headerName))
+			case strings.HasSuffix(value, ";"):
+				// "Name;" sends the header without value
+				headerName = strings.TrimSpace(strings.TrimSuffix(value, ";"))
+				dropDerived(headerName)
+				tab.addHeader(headerName, "")
+			case strings.HasPrefix(value, "@"):
+				skipped = append(skipped, "headers read from "+value[1:]+" are not imported")
+			}
+		case "-d", "--data", "--data-ascii", "--data-binary":
+			if strings.HasPrefix(value, "@") {
+				skipped = append(skipped, "data read from "+value[1:]+" is not imported")
+				continue
+			}
+			data = append(data, value)
+		case "--data-raw":
+			data = append(data, value)
+		case "--data-urlencode":
+			data = append(data, curlURLEncode(value))
+		case "--json":
+			data = append(data, value)
+			deriveHeader("Content-Type", "application/json")
+			deriveHeader("Accept", "application/json")
+		case "-F", "--form", "--form-string":
+			fieldName, fieldValue, _ := strings.Cut(value, "=")
+			file := name != "--form-string" && (strings.HasPrefix(fieldValue, "@") || strings.HasPrefix(fieldValue, "<"))
+			fields = append(fields, formField{Name: fieldName, Value: fieldValue, File: file})
+		case "-u", "--user":
+			deriveHeader("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(value)))
+		case "--oauth2-bearer":
+			deriveHeader("Authorization", "Bearer "+value)
+		case "-A", "--user-agent":
+			deriveHeader("User-Agent", value)
+		case "-e", "--referer":
+			deriveHeader("Referer", strings.TrimSuffix(value, ";auto"))
+		case "-b", "--cookie":
+			if !strings.Contains(value, "=") {
+				skipped = append(skipped, "cookies read from "+value+" are not imported")
+				continue
+			}
+			key := headerKey(tab.Headers, "Cookie")
+			switch {
+			case key == "":
+				deriveHeader("Cookie", value)
+			case derived["cookie"]:
+				tab.Headers[key] = tab.Headers[key].(string) + "; " + value
+			}
+			// A Cookie header given with -H replaces the cookies of -b
+		case "--url":
+			rawURL = value
+		case "--url-query":
+			queries = append(queries, curlURLEncode(value))
+		case "-I", "--head":
+			head = true
+		case "-G", "--get":
+			get = true
+		case "--compressed":
+			deriveHeader("Accept-Encoding", "deflate, gzip")
+		default:
+			// Flags without value, such as -k, -s, -L or --http2, only change
+			// how curl runs
+		}
+	}
+
+	if rawURL == "" {
+		return nil, nil, fmt.Errorf("the curl command has no URL")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	tab.URL = rawURL
+	for _, query := range queries {
+		addQueryPair(tab, query)
+	}
+
+	// With -G the data goes to the query string
+	switch {
+	case get:
+		for _, pair := range data {
+			addQueryPair(tab, pair)
+		}
+		tab.Method = http.MethodGet
+	case len(fields) > 0:
+		skipped = append(skipped, tab.setForm(fields, true)...)
+		tab.Method = http.MethodPost
+	case len(data) > 0:
+		tab.Body = strings.Join(data, "&")
+		tab.setDefaultHeader("Content-Type", "application/x-www-form-urlencoded")
+		tab.Method = http.MethodPost
+	case head:
+		tab.Method = http.MethodHead
+	default:
+		tab.Method = http.MethodGet
+	}
+	if method != "" {
+		tab.Method = method
+	}
+
+	tab.Name = tab.Method + " " + rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		tab.Name = tab.Method + " " + parsed.Host + parsed.EscapedPath()
+	}
+	return tab, skipped, nil
+}
+
+// headerKey returns the name headers hold a header under, whatever its case,
+// or "" when they do not have it
+func headerKey(headers map[string]interface{}, name string) string {
+	for existing := range headers {
+		if strings.EqualFold(existing, name) {
+			return existing
+		}
+	}
+	return ""
+}
+
+// addQueryPair appends an encoded name=value pair, or a lone value, to the
+// query string
+func addQueryPair(tab *importedTab, pair string) {
+	if name, value, ok := strings.Cut(pair, "="); ok {
+		tab.addQuery(name, value)
+		return
+	}
+	separator := "?"
+	if strings.Contains(tab.URL, "?") {
+		separator = "&"
+	}
+	tab.URL += separator + pair
+}
+
+// curlURLEncode encodes a --data-urlencode value: "content", "=content" and
+// "name=content" encode the content, "name@file" cannot be read
+func curlURLEncode(value string) string {
+	name, content, ok := strings.Cut(value, "=")
+	if !ok {
+		return url.QueryEscape(value)
+	}
+	if name == "" {
+		return url.QueryEscape(content)
+	}
+	return name + "=" + url.QueryEscape(content)
+}
+
+// splitShellWords splits a command line the way a POSIX shell does, with
+// single quotes, double quotes, $'...' strings, backslash escapes and line
+// continuations
+func splitShellWords(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\\' && strings.HasPrefix(command[i+1:], "\r\n"):
+			// A line continuation with Windows line endings
+			i += 2
+		case c == '\\' && i+1 < len(command):
+			i++
+			if command[i] != '\n' {
+				word.WriteByte(command[i])
+				inWord = true
+			}
+		case c == '\\':
+			// A trailing backslash continues nowhere
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '$' && i+1 < len(command) && command[i+1] == '\'':
+			text, length, err := ansiCString(command[i+2:])
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(text)
+			i += length + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`\n", command[i+1]) >= 0 {
+					i++
+					if command[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// ansiCString decodes the content of a $'...' string up to its closing quote,
+// returning the text and the length read, closing quote included
+func ansiCString(text string) (string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '\'' {
+			return b.String(), i + 1, nil
+		}
+		if c != '\\' || i+1 >= len(text) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'e', 'E':
+			b.WriteByte(0x1b)
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			end := i + 1
+			for end < len(text) && end < i+3 && strings.IndexByte("0123456789abcdefABCDEF", text[end]) >= 0 {
+				end++
+			}
+			if end == i+1 {
+				b.WriteString(`\x`)
+				continue
+			}
+			value, _ := strconv.ParseUint(text[i+1:end], 16, 8)
+			b.WriteByte(byte(value))
+			i = end - 1
+		case 'u', 'U':
+			size := 4
+			if text[i] == 'U' {
+				size = 8
+			}
+			end := i + 1
+			for end < len(text) && end < i+1+size && strings.IndexByte("0123456789abcdefABCDEF", text[end]) >= 0 {
+				end++
+			}
+			value, err := strconv.ParseUint(text[i+1:end], 16, 32)
+			if end == i+1 || err != nil {
+				b.WriteByte('\\')
+				b.WriteByte(text[i])
+				continue
+			}
+			b.WriteRune(rune(value))
+			i = end - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := i
+			for end < len(text) && end < i+3 && text[end] >= '0' && text[end] <= '7' {
+				end++
+			}
+			value, _ := strconv.ParseUint(text[i:end], 8, 8)
+			b.WriteByte(byte(value))
+			i = end - 1
+		default:
+			// \\, \', \" and \? stand for the character itself
+			b.WriteByte(text[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated $' quote")
+}
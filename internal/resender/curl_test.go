@@ -0,0 +1,72 @@
+package resender
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseCurlCombinedFlags(t *testing.T) {
+	tests := []struct {
+		command, method, body string
+	}{
+		{`curl -sSLd 'a=b' https://example.com/`, "POST", "a=b"},
+		{`curl -sSLda=b https://example.com/`, "POST", "a=b"},
+		{`curl -sX DELETE https://example.com/`, "DELETE", ""},
+		{`curl -sXDELETE https://example.com/`, "DELETE", ""},
+		{`curl -XPOST https://example.com/`, "POST", ""},
+		{`curl -sI https://example.com/`, "HEAD", ""},
+		{`curl -sGd q=1 https://example.com/`, "GET", ""},
+	}
+	for _, test := range tests {
+		tab, _, err := parseCurl(test.command)
+		if err != nil {
+			t.Errorf("%s: %v", test.command, err)
+			continue
+		}
+		if tab.Method != test.method || tab.Body != test.body {
+			t.Errorf("%s: got %s %q, want %s %q", test.command, tab.Method, tab.Body, test.method, test.body)
+		}
+	}
+
+	tab, _, err := parseCurl(`curl -sSLo out.html -H 'X-Test: 1' https://example.com/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tab.URL != "https://example.com/" || tab.Headers["X-Test"] != "1" {
+		t.Errorf("-o in a cluster did not take its value: URL %q, headers %v", tab.URL, tab.Headers)
+	}
+}
+
+func TestParseCurlHeaderReplacesUser(t *testing.T) {
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pw"))
+	tests := []struct {
+		command, authorization string
+	}{
+		{`curl -u user:pw -H 'Authorization: Bearer x' https://example.com/`, "Bearer x"},
+		{`curl -H 'Authorization: Bearer x' -u user:pw https://example.com/`, "Bearer x"},
+		{`curl -u user:pw -H 'authorization: Bearer x' https://example.com/`, "Bearer x"},
+		{`curl -u user:pw https://example.com/`, basic},
+	}
+	for _, test := range tests {
+		tab, _, err := parseCurl(test.command)
+		if err != nil {
+			t.Errorf("%s: %v", test.command, err)
+			continue
+		}
+		key := headerKey(tab.Headers, "Authorization")
+		if got := tab.Headers[key]; got != test.authorization {
+			t.Errorf("%s: Authorization %q, want %q", test.command, got, test.authorization)
+		}
+		if len(tab.Headers) != 1 {
+			t.Errorf("%s: headers %v, want Authorization alone", test.command, tab.Headers)
+		}
+	}
+
+	tab, _, err := parseCurl(`curl -A agent -H 'User-Agent:' https://example.com/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := headerKey(tab.Headers, "User-Agent"); key != "" {
+		t.Errorf("-H 'User-Agent:' kept the header of -A: %v", tab.Headers)
+	}
+}
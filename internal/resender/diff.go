@@ -0,0 +1,243 @@
+package resender
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"prokzee/internal/intercept"
+)
+
+// diffContext is how many unchanged lines surround the changes of a hunk
+const diffContext = 3
+
+// ResponseDiff compares the responses of two resender requests
+type ResponseDiff struct {
+	FromID  int            `json:"fromId"`
+	ToID    int            `json:"toId"`
+	Status  StatusDiff     `json:"status"`
+	Headers []HeaderChange `json:"headers"` // changed headers only, by name
+	Body    BodyDiff       `json:"body"`
+}
+
+// StatusDiff is the status of both responses
+type StatusDiff struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Changed bool   `json:"changed"`
+}
+
+// HeaderChange is a response header added, removed or changed. Repeated
+// headers are compared with their values joined.
+type HeaderChange struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"` // added, removed or changed
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// BodyDiff is a line diff of the response bodies, in hunks
+type BodyDiff struct {
+	Identical bool `json:"identical"`
+	FromBytes int  `json:"fromBytes"`
+	ToBytes   int  `json:"toBytes"`
+	// JSON is set when both bodies were JSON, indented before the diff so
+	// changes show per field
+	JSON  bool       `json:"json"`
+	Hunks []DiffHunk `json:"hunks"`
+}
+
+// DiffHunk is a run of changed lines with the unchanged lines around them.
+// Line numbers start at 1.
+type DiffHunk struct {
+	FromLine  int        `json:"fromLine"`
+	FromCount int        `json:"fromCount"`
+	ToLine    int        `json:"toLine"`
+	ToCount   int        `json:"toCount"`
+	Lines     []DiffLine `json:"lines"`
+}
+
+// DiffLine is a line of a hunk
+type DiffLine struct {
+	Kind string `json:"kind"` // context, removed or added
+	Text string `json:"text"`
+}
+
+// DiffResponses compares the responses of two resender requests, typically
+// consecutive sends of a tab
+func (r *Resender) DiffResponses(fromID, toID int) (*ResponseDiff, error) {
+	from, err := r.storedResponse(fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := r.storedResponse(toID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResponseDiff{
+		FromID:  fromID,
+		ToID:    toID,
+		Status:  StatusDiff{From: from.status, To: to.status, Changed: from.status != to.status},
+		Headers: diffHeaders(from.headers, to.headers),
+		Body:    diffBodies(from.body, to.body),
+	}, nil
+}
+
+// storedResponse is the response of a resender request as stored
+type storedResponse struct {
+	status  string
+	headers map[string]string
+	body    string
+}
+
+// storedResponse reads the response of a resender request
+func (r *Resender) storedResponse(requestID int) (*storedResponse, error) {
+	var status, headersJSON, body string
+	err := r.db.QueryRow(`
+		SELECT COALESCE(status, ''), COALESCE(response_headers, ''), COALESCE(response_body, '')
+		FROM resender_requests WHERE id = ?
+	`, requestID).Scan(&status, &headersJSON, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch request %d: %v", requestID, err)
+	}
+	return &storedResponse{status: status, headers: parseStoredHeaders(headersJSON), body: body}, nil
+}
+
+// parseStoredHeaders reads response headers stored as an http.Header, or as
+// one value per name
+func parseStoredHeaders(headersJSON string) map[string]string {
+	headers := make(map[string]string)
+	if headersJSON == "" {
+		return headers
+	}
+	var multi map[string][]string
+	if err := json.Unmarshal([]byte(headersJSON), &multi); err == nil {
+		for name, values := range multi {
+			headers[name] = strings.Join(values, ", ")
+		}
+		return headers
+	}
+	var single map[string]string
+	if err := json.Unmarshal([]byte(headersJSON), &single); err == nil {
+		return single
+	}
+	return headers
+}
+
+// diffHeaders lists the headers that differ, sorted by name
+func diffHeaders(from, to map[string]string) []HeaderChange {
+	changes := []HeaderChange{}
+	for name, value := range from {
+		other, ok := to[name]
+		switch {
+		case !ok:
+			changes = append(changes, HeaderChange{Name: name, Kind: "removed", From: value})
+		case other != value:
+			changes = append(changes, HeaderChange{Name: name, Kind: "changed", From: value, To: other})
+		}
+	}
+	for name, value := range to {
+		if _, ok := from[name]; !ok {
+			changes = append(changes, HeaderChange{Name: name, Kind: "added", To: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// diffBodies splits the line diff of two bodies into hunks
+func diffBodies(from, to string) BodyDiff {
+	diff := BodyDiff{Identical: from == to, FromBytes: len(from), ToBytes: len(to), Hunks: []DiffHunk{}}
+	if diff.Identical {
+		return diff
+	}
+	if indentedFrom, indentedTo, ok := indentJSON(from, to); ok {
+		from, to, diff.JSON = indentedFrom, indentedTo, true
+	}
+
+	var lines []DiffLine
+	for _, line := range strings.Split(intercept.Diff(from, to), "\n") {
+		kind := "context"
+		switch {
+		case strings.HasPrefix(line, "- "):
+			kind = "removed"
+		case strings.HasPrefix(line, "+ "):
+			kind = "added"
+		}
+		lines = append(lines, DiffLine{Kind: kind, Text: line[2:]})
+	}
+	diff.Hunks = hunks(lines)
+	return diff
+}
+
+// indentJSON indents two bodies when both are JSON
+func indentJSON(from, to string) (string, string, bool) {
+	var indentedFrom, indentedTo bytes.Buffer
+	if json.Indent(&indentedFrom, []byte(from), "", "  ") != nil {
+		return "", "", false
+	}
+	if json.Indent(&indentedTo, []byte(to), "", "  ") != nil {
+		return "", "", false
+	}
+	return indentedFrom.String(), indentedTo.String(), true
+}
+
+// hunks groups the changed lines of a diff with diffContext unchanged lines
+// around them, merging groups whose context touches
+func hunks(lines []DiffLine) []DiffHunk {
+	result := []DiffHunk{}
+	fromLine, toLine := 1, 1 // line numbers of lines[i]
+	var current *DiffHunk
+	lastChange := -1 // index of the last changed line of current
+
+	for i, line := range lines {
+		if line.Kind != "context" {
+			if current == nil || i-lastChange-1 > 2*diffContext {
+				if current != nil {
+					result = append(result, closeHunk(current, lines[lastChange+1:min(lastChange+1+diffContext, i)]))
+				}
+				start := max(i-diffContext, 0)
+				current = &DiffHunk{FromLine: fromLine - (i - start), ToLine: toLine - (i - start)}
+				current.Lines = append(current.Lines, lines[start:i]...)
+				current.FromCount, current.ToCount = i-start, i-start
+			} else {
+				between := lines[lastChange+1 : i]
+				current.Lines = append(current.Lines, between...)
+				current.FromCount += len(between)
+				current.ToCount += len(between)
+			}
+			current.Lines = append(current.Lines, line)
+			if line.Kind == "removed" {
+				current.FromCount++
+			} else {
+				current.ToCount++
+			}
+			lastChange = i
+		}
+
+		switch line.Kind {
+		case "context":
+			fromLine++
+			toLine++
+		case "removed":
+			fromLine++
+		case "added":
+			toLine++
+		}
+	}
+	if current != nil {
+		result = append(result, closeHunk(current, lines[lastChange+1:min(lastChange+1+diffContext, len(lines))]))
+	}
+	return result
+}
+
+// closeHunk adds the unchanged lines following the last change of a hunk
+func closeHunk(hunk *DiffHunk, trailing []DiffLine) DiffHunk {
+	hunk.Lines = append(hunk.Lines, trailing...)
+	hunk.FromCount += len(trailing)
+	hunk.ToCount += len(trailing)
+	return *hunk
+}
@@ -0,0 +1,539 @@
+package resender
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"prokzee/internal/racetest"
+	"prokzee/internal/snippet"
+)
+
+// Ways the tabs of a group are sent
+const (
+	// GroupModeSequential sends the tabs one after the other, values
+	// extracted from a response are set as variables for the tabs after it
+	GroupModeSequential = "sequential"
+	// GroupModeParallel sends the tabs together for race conditions: each
+	// request is written but its last byte on its own connection, then the
+	// last bytes are released at once
+	GroupModeParallel = "parallel"
+)
+
+// MaxGroupTabs bounds the tabs of a group
+const MaxGroupTabs = 50
+
+// Sources values are extracted from
+const (
+	ExtractHeader = "header" // a response header, by name
+	ExtractCookie = "cookie" // a cookie set by the response, by name
+	ExtractRegex  = "regex"  // the first capture group of a pattern over headers and body
+	ExtractJSON   = "json"   // a dotted path into a JSON body, such as data.items.0.id
+)
+
+// Extraction sets a variable from the response of a tab of a sequential group
+type Extraction struct {
+	TabID    int    `json:"tabId"`
+	Variable string `json:"variable"`
+	Source   string `json:"source"`
+	Name     string `json:"name"`
+}
+
+// RequestGroup is an ordered set of resender tabs sent as a batch
+type RequestGroup struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	TabIDs      []int        `json:"tabIds"`
+	Mode        string       `json:"mode"`
+	Extractions []Extraction `json:"extractions"`
+}
+
+// GroupStep is the outcome of sending one tab of a group
+type GroupStep struct {
+	TabID     int               `json:"tabId"`
+	RequestID int               `json:"requestId,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Extracted map[string]string `json:"extracted,omitempty"`
+}
+
+// GroupRun is the outcome of sending a group, steps in tab order
+type GroupRun struct {
+	GroupID   int         `json:"groupId"`
+	Mode      string      `json:"mode"`
+	Steps     []GroupStep `json:"steps"`
+	Cancelled bool        `json:"cancelled"`
+}
+
+// ensureGroupsTable creates the groups table of projects created before it
+// existed
+func (r *Resender) ensureGroupsTable() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS resender_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			tab_ids TEXT DEFAULT '[]',
+			mode TEXT DEFAULT 'sequential',
+			extractions TEXT DEFAULT '[]'
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create resender groups table: %v", err)
+	}
+	return nil
+}
+
+// GetGroups returns all groups in creation order
+func (r *Resender) GetGroups() ([]RequestGroup, error) {
+	rows, err := r.db.Query("SELECT id, name, COALESCE(tab_ids, '[]'), COALESCE(mode, ''), COALESCE(extractions, '[]') FROM resender_groups ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch resender groups: %v", err)
+	}
+	defer rows.Close()
+
+	groups := []RequestGroup{}
+	for rows.Next() {
+		group, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, *group)
+	}
+	return groups, rows.Err()
+}
+
+// getGroup reads one group
+func (r *Resender) getGroup(groupID int) (*RequestGroup, error) {
+	row := r.db.QueryRow("SELECT id, name, COALESCE(tab_ids, '[]'), COALESCE(mode, ''), COALESCE(extractions, '[]') FROM resender_groups WHERE id = ?", groupID)
+	group, err := scanGroup(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch resender group %d: %v", groupID, err)
+	}
+	return group, nil
+}
+
+// scanGroup reads a group from a row
+func scanGroup(row interface{ Scan(...interface{}) error }) (*RequestGroup, error) {
+	var group RequestGroup
+	var tabIDsJSON, extractionsJSON string
+	if err := row.Scan(&group.ID, &group.Name, &tabIDsJSON, &group.Mode, &extractionsJSON); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(tabIDsJSON), &group.TabIDs)
+	json.Unmarshal([]byte(extractionsJSON), &group.Extractions)
+	if group.TabIDs == nil {
+		group.TabIDs = []int{}
+	}
+	if group.Extractions == nil {
+		group.Extractions = []Extraction{}
+	}
+	if group.Mode == "" {
+		group.Mode = GroupModeSequential
+	}
+	return &group, nil
+}
+
+// SaveGroup creates a group, or replaces the one with the same ID
+func (r *Resender) SaveGroup(group RequestGroup) (*RequestGroup, error) {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return nil, fmt.Errorf("group name is required")
+	}
+	if len(group.TabIDs) == 0 || len(group.TabIDs) > MaxGroupTabs {
+		return nil, fmt.Errorf("a group holds between 1 and %d tabs", MaxGroupTabs)
+	}
+	if group.Mode == "" {
+		group.Mode = GroupModeSequential
+	}
+	if group.Mode != GroupModeSequential && group.Mode != GroupModeParallel {
+		return nil, fmt.Errorf("unknown group mode: %s", group.Mode)
+	}
+	if group.Extractions == nil {
+		group.Extractions = []Extraction{}
+	}
+	for _, extraction := range group.Extractions {
+		if err := extraction.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	tabIDsJSON, _ := json.Marshal(group.TabIDs)
+	extractionsJSON, _ := json.Marshal(group.Extractions)
+	if group.ID == 0 {
+		err := r.db.QueryRow(`
+			INSERT INTO resender_groups (name, tab_ids, mode, extractions) VALUES (?, ?, ?, ?)
+			RETURNING id
+		`, group.Name, string(tabIDsJSON), group.Mode, string(extractionsJSON)).Scan(&group.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save resender group: %v", err)
+		}
+		return &group, nil
+	}
+	result, err := r.db.Exec(`
+		UPDATE resender_groups SET name = ?, tab_ids = ?, mode = ?, extractions = ? WHERE id = ?
+	`, group.Name, string(tabIDsJSON), group.Mode, string(extractionsJSON), group.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save resender group: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, fmt.Errorf("resender group %d not found", group.ID)
+	}
+	return &group, nil
+}
+
+// DeleteGroup removes a group, its tabs are kept
+func (r *Resender) DeleteGroup(groupID int) error {
+	if _, err := r.db.Exec("DELETE FROM resender_groups WHERE id = ?", groupID); err != nil {
+		return fmt.Errorf("failed to delete resender group: %v", err)
+	}
+	return nil
+}
+
+// CancelGroup stops a group being sent. Tabs already sent keep their response.
+func (r *Resender) CancelGroup(groupID int) {
+	r.activeReqMutex.Lock()
+	defer r.activeReqMutex.Unlock()
+	if cancel, exists := r.activeGroups[groupID]; exists {
+		cancel()
+	}
+}
+
+// SendGroup sends the tabs of a group, sequentially or in parallel as its
+// mode says, calling progress as each tab gets its response. Every tab keeps
+// its response as if sent on its own.
+func (r *Resender) SendGroup(groupID int, progress func(GroupStep)) (*GroupRun, error) {
+	group, err := r.getGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+	r.activeReqMutex.Lock()
+	if _, running := r.activeGroups[groupID]; running {
+		r.activeReqMutex.Unlock()
+		return nil, fmt.Errorf("group %q is already being sent", group.Name)
+	}
+	r.activeGroups[groupID] = cancel
+	r.activeReqMutex.Unlock()
+	defer func() {
+		r.activeReqMutex.Lock()
+		delete(r.activeGroups, groupID)
+		r.activeReqMutex.Unlock()
+	}()
+
+	run := &GroupRun{GroupID: group.ID, Mode: group.Mode}
+	if group.Mode == GroupModeParallel {
+		run.Steps = r.sendParallel(ctx, group, progress)
+	} else {
+		run.Steps = r.sendSequential(ctx, group, progress)
+	}
+	run.Cancelled = ctx.Err() != nil
+	return run, nil
+}
+
+// sendSequential sends the tabs in order, setting the variables extracted
+// from each response before the next tab is sent
+func (r *Resender) sendSequential(ctx context.Context, group *RequestGroup, progress func(GroupStep)) []GroupStep {
+	steps := make([]GroupStep, 0, len(group.TabIDs))
+	for _, tabID := range group.TabIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		step := GroupStep{TabID: tabID}
+		details, before, err := r.tabRequestDetails(tabID)
+		if err == nil {
+			err = r.SendRequest(float64(tabID), details)
+		}
+		if err == nil {
+			r.recordStep(&step, before)
+		} else {
+			step.Error = err.Error()
+		}
+
+		if step.RequestID != 0 {
+			r.extractValues(group, &step)
+		}
+		steps = append(steps, step)
+		if progress != nil {
+			progress(step)
+		}
+	}
+	return steps
+}
+
+// sendParallel sends the tabs together as raw HTTP/1.1 messages, holding
+// back the last byte of each until all are written. Variables are expanded
+// with their values when the group starts.
+func (r *Resender) sendParallel(ctx context.Context, group *RequestGroup, progress func(GroupStep)) []GroupStep {
+	steps := make([]GroupStep, len(group.TabIDs))
+	gate := racetest.NewLastByteGate(len(group.TabIDs))
+	values := r.variableValues()
+
+	var wg sync.WaitGroup
+	for i, tabID := range group.TabIDs {
+		wg.Add(1)
+		go func(i, tabID int) {
+			defer wg.Done()
+			ticket := gate.Ticket()
+			// A tab failing before its last byte still lets the others go
+			defer ticket.Arrive()
+
+			step := GroupStep{TabID: tabID}
+			details, before, err := r.tabRequestDetails(tabID)
+			if err == nil {
+				details, err = rawDetails(details, values)
+			}
+			if err == nil {
+				err = r.sendRaw(withGateTicket(ctx, ticket), float64(tabID), details)
+			}
+			if err == nil {
+				r.recordStep(&step, before)
+			} else {
+				step.Error = err.Error()
+			}
+			steps[i] = step
+			if progress != nil {
+				progress(step)
+			}
+		}(i, tabID)
+	}
+	wg.Wait()
+	return steps
+}
+
+// recordStep fills a step with the response the tab got, if a new one was
+// stored after the request before
+func (r *Resender) recordStep(step *GroupStep, before int) {
+	after, err := r.latestTabRequest(step.TabID)
+	if err != nil || after == before {
+		// Raw exchanges report their errors to the tab rather than returning them
+		step.Error = "no response was received"
+		return
+	}
+	step.RequestID = after
+	r.db.QueryRow("SELECT COALESCE(status, '') FROM resender_requests WHERE id = ?", after).Scan(&step.Status)
+}
+
+// latestTabRequest returns the ID of the last request of a tab, 0 when it has
+// none
+func (r *Resender) latestTabRequest(tabID int) (int, error) {
+	var requestIDsJSON string
+	err := r.db.QueryRow("SELECT COALESCE(request_ids_arr, '[]') FROM resender_tabs WHERE id = ?", tabID).Scan(&requestIDsJSON)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch resender tab %d: %v", tabID, err)
+	}
+	var requestIDs []int
+	json.Unmarshal([]byte(requestIDsJSON), &requestIDs)
+	if len(requestIDs) == 0 {
+		return 0, nil
+	}
+	return requestIDs[len(requestIDs)-1], nil
+}
+
+// tabRequestDetails returns the last request of a tab as SendRequest takes
+// it, templates included, with the ID of that request
+func (r *Resender) tabRequestDetails(tabID int) (map[string]interface{}, int, error) {
+	requestID, err := r.latestTabRequest(tabID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if requestID == 0 {
+		return nil, 0, fmt.Errorf("tab %d has no request", tabID)
+	}
+
+	var rawURL, method, headersJSON, body, protocolVersion, raw string
+	err = r.db.QueryRow(`
+		SELECT COALESCE(url, ''), method, COALESCE(request_headers, ''), COALESCE(request_body, ''),
+			COALESCE(http_version, ''), COALESCE(raw_request, '')
+		FROM resender_requests WHERE id = ?
+	`, requestID).Scan(&rawURL, &method, &headersJSON, &body, &protocolVersion, &raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch request %d: %v", requestID, err)
+	}
+
+	headers := map[string]interface{}{}
+	var stored map[string]interface{}
+	if json.Unmarshal([]byte(headersJSON), &stored) == nil {
+		for name, value := range stored {
+			headers[name] = headerString(value)
+		}
+	}
+	details := map[string]interface{}{
+		"url":             rawURL,
+		"method":          method,
+		"headers":         headers,
+		"body":            body,
+		"protocolVersion": protocolVersion,
+	}
+	if raw != "" {
+		details["raw"] = raw
+	}
+	return details, requestID, nil
+}
+
+// rawDetails turns the details of a tab into a raw message with the
+// variables expanded, for tabs not written raw already
+func rawDetails(details map[string]interface{}, values map[string]string) (map[string]interface{}, error) {
+	if _, ok := details["raw"].(string); ok {
+		return details, nil
+	}
+	rawURL := expandVariables(details["url"].(string), values)
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+	req := &snippet.Request{
+		Method: details["method"].(string),
+		URL:    rawURL,
+		Proto:  "HTTP/1.1",
+		Header: http.Header{},
+		Body:   []byte(expandVariables(details["body"].(string), values)),
+	}
+	for name, value := range details["headers"].(map[string]interface{}) {
+		req.Header[expandVariables(name, values)] = []string{expandVariables(value.(string), values)}
+	}
+	message, err := snippet.Render(req, snippet.FormatRaw)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"raw": message,
+		"url": target.Scheme + "://" + target.Host,
+	}, nil
+}
+
+// extractValues sets the variables a group extracts from the response of a
+// step
+func (r *Resender) extractValues(group *RequestGroup, step *GroupStep) {
+	var headersJSON, body string
+	err := r.db.QueryRow(`
+		SELECT COALESCE(response_headers, ''), COALESCE(response_body, '') FROM resender_requests WHERE id = ?
+	`, step.RequestID).Scan(&headersJSON, &body)
+	if err != nil {
+		return
+	}
+	header := http.Header{}
+	json.Unmarshal([]byte(headersJSON), &header)
+
+	for _, extraction := range group.Extractions {
+		if extraction.TabID != step.TabID {
+			continue
+		}
+		value, ok := extraction.extract(header, body)
+		if !ok {
+			continue
+		}
+		if err := r.SetVariable(extraction.Variable, value, "group "+group.Name); err != nil {
+			continue
+		}
+		if step.Extracted == nil {
+			step.Extracted = make(map[string]string)
+		}
+		step.Extracted[extraction.Variable] = value
+	}
+}
+
+// validate checks an extraction before it is saved
+func (e Extraction) validate() error {
+	if !variablePattern.MatchString("{{" + e.Variable + "}}") {
+		return fmt.Errorf("invalid variable name %q", e.Variable)
+	}
+	if e.Name == "" {
+		return fmt.Errorf("extraction of %s needs a name, pattern or path", e.Variable)
+	}
+	switch e.Source {
+	case ExtractHeader, ExtractCookie, ExtractJSON:
+	case ExtractRegex:
+		pattern, err := regexp.Compile(e.Name)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for %s: %v", e.Variable, err)
+		}
+		if pattern.NumSubexp() < 1 {
+			return fmt.Errorf("pattern for %s needs a capture group", e.Variable)
+		}
+	default:
+		return fmt.Errorf("unknown extraction source: %s", e.Source)
+	}
+	return nil
+}
+
+// extract pulls the value of an extraction out of a response
+func (e Extraction) extract(header http.Header, body string) (string, bool) {
+	switch e.Source {
+	case ExtractHeader:
+		value := header.Get(e.Name)
+		return value, value != ""
+	case ExtractCookie:
+		for _, cookie := range (&http.Response{Header: header}).Cookies() {
+			if cookie.Name == e.Name {
+				return cookie.Value, true
+			}
+		}
+	case ExtractRegex:
+		pattern, err := regexp.Compile(e.Name)
+		if err != nil {
+			return "", false
+		}
+		var raw bytes.Buffer
+		header.Write(&raw)
+		raw.WriteString(body)
+		if match := pattern.FindSubmatch(raw.Bytes()); len(match) > 1 {
+			return string(match[1]), true
+		}
+	case ExtractJSON:
+		return jsonPathValue(body, e.Name)
+	}
+	return "", false
+}
+
+// jsonPathValue follows a dotted path of keys and array indexes into a JSON
+// document. Strings are returned as they are, other values as JSON.
+func jsonPathValue(document, path string) (string, bool) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return "", false
+	}
+	for _, key := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return "", false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", false
+			}
+			value = node[index]
+		default:
+			return "", false
+		}
+	}
+	if text, ok := value.(string); ok {
+		return text, true
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// gateKey carries the gate ticket of a raw exchange in its context
+type gateKey struct{}
+
+// withGateTicket makes the raw exchange of ctx wait at the gate before its
+// last byte
+func withGateTicket(ctx context.Context, ticket *racetest.Ticket) context.Context {
+	return context.WithValue(ctx, gateKey{}, ticket)
+}
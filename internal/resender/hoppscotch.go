@@ -0,0 +1,381 @@
+package resender
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// hoppscotchVariablePattern matches the <<name>> placeholders of Hoppscotch
+var hoppscotchVariablePattern = regexp.MustCompile(`<<([A-Za-z0-9_.\-]+)>>`)
+
+// hoppscotchCollection is a collection or, nested in one, a folder
+type hoppscotchCollection struct {
+	Version  int                    `json:"v"`
+	Name     string                 `json:"name"`
+	Folders  []hoppscotchCollection `json:"folders"`
+	Requests []hoppscotchRequest    `json:"requests"`
+	Auth     *hoppscotchAuth        `json:"auth,omitempty"`
+	Headers  []hoppscotchKeyValue   `json:"headers"`
+}
+
+type hoppscotchRequest struct {
+	Version          string               `json:"v"`
+	Name             string               `json:"name"`
+	Method           string               `json:"method"`
+	Endpoint         string               `json:"endpoint"`
+	Params           []hoppscotchKeyValue `json:"params"`
+	Headers          []hoppscotchKeyValue `json:"headers"`
+	PreRequestScript string               `json:"preRequestScript"`
+	TestScript       string               `json:"testScript"`
+	Auth             *hoppscotchAuth      `json:"auth"`
+	Body             hoppscotchBody       `json:"body"`
+}
+
+type hoppscotchKeyValue struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Active bool   `json:"active"`
+}
+
+type hoppscotchAuth struct {
+	AuthType   string `json:"authType"`
+	AuthActive bool   `json:"authActive"`
+	Token      string `json:"token,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	Key        string `json:"key,omitempty"`
+	Value      string `json:"value,omitempty"`
+	AddTo      string `json:"addTo,omitempty"`
+}
+
+// hoppscotchBody holds a text body or, for multipart forms, a list of fields
+type hoppscotchBody struct {
+	ContentType *string         `json:"contentType"`
+	Body        json.RawMessage `json:"body"`
+}
+
+type hoppscotchFormField struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Active bool   `json:"active"`
+	IsFile bool   `json:"isFile"`
+}
+
+type hoppscotchEnvironment struct {
+	Name      string               `json:"name"`
+	Variables []hoppscotchVariable `json:"variables"`
+}
+
+type hoppscotchVariable struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ImportHoppscotch creates a resender tab for every request of a Hoppscotch
+// collection export, which holds one collection or a list of them, grouped
+// by collection and folder. The variables of the environment export, when
+// given, become resender variables.
+func (r *Resender) ImportHoppscotch(collectionData, environmentData []byte) (*CollectionImport, error) {
+	var collections []hoppscotchCollection
+	if err := unmarshalOneOrMany(collectionData, &collections); err != nil {
+		return nil, fmt.Errorf("failed to parse Hoppscotch collection: %v", err)
+	}
+	var names []string
+	for _, collection := range collections {
+		names = append(names, collection.Name)
+	}
+	result := &CollectionImport{Collection: strings.Join(names, ", "), Skipped: []string{}}
+	if result.Collection == "" {
+		result.Collection = "Hoppscotch"
+	}
+
+	if len(environmentData) > 0 {
+		var environments []hoppscotchEnvironment
+		if err := unmarshalOneOrMany(environmentData, &environments); err != nil {
+			return nil, fmt.Errorf("failed to parse Hoppscotch environment: %v", err)
+		}
+		for _, environment := range environments {
+			for _, variable := range environment.Variables {
+				if variable.Key == "" {
+					continue
+				}
+				if err := r.SetVariable(variable.Key, fromHoppscotchTemplate(variable.Value), "hoppscotch environment: "+environment.Name); err != nil {
+					result.Skipped = append(result.Skipped, err.Error())
+					continue
+				}
+				result.Variables++
+			}
+		}
+	}
+
+	values := r.variableValues()
+	var tabs []importedTab
+	var walk func(collection hoppscotchCollection, group string, auth *hoppscotchAuth, headers []hoppscotchKeyValue)
+	walk = func(collection hoppscotchCollection, group string, auth *hoppscotchAuth, headers []hoppscotchKeyValue) {
+		if collection.Auth != nil && collection.Auth.AuthType != "inherit" {
+			auth = collection.Auth
+		}
+		headers = append(append([]hoppscotchKeyValue{}, headers...), collection.Headers...)
+
+		for _, request := range collection.Requests {
+			requestAuth := auth
+			if request.Auth != nil && request.Auth.AuthType != "inherit" {
+				requestAuth = request.Auth
+			}
+			tab, skipped := request.toTab(requestAuth, headers, values)
+			tab.Name, tab.Group = request.Name, group
+			if len(skipped) > 0 {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", request.Name, strings.Join(skipped, "; ")))
+			}
+			tabs = append(tabs, tab)
+		}
+		for _, folder := range collection.Folders {
+			walk(folder, group+"/"+folder.Name, auth, headers)
+		}
+	}
+	for _, collection := range collections {
+		walk(collection, collection.Name, nil, nil)
+	}
+
+	if err := r.importTabs(tabs); err != nil {
+		return nil, err
+	}
+	result.Tabs = len(tabs)
+	return result, nil
+}
+
+// unmarshalOneOrMany decodes a JSON document holding a single object or a
+// list of them
+func unmarshalOneOrMany[T any](data []byte, list *[]T) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		return json.Unmarshal(data, list)
+	}
+	var single T
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	*list = []T{single}
+	return nil
+}
+
+// fromHoppscotchTemplate rewrites <<name>> placeholders as {{name}}
+func fromHoppscotchTemplate(text string) string {
+	return hoppscotchVariablePattern.ReplaceAllString(text, "{{$1}}")
+}
+
+// toHoppscotchTemplate rewrites {{name}} placeholders as <<name>>, leaving
+// the dynamic $ variables, which Hoppscotch does not know, as they are
+func toHoppscotchTemplate(text string) string {
+	return variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(name, "$") {
+			return match
+		}
+		return "<<" + name + ">>"
+	})
+}
+
+// toTab converts a Hoppscotch request into a resender tab. The headers of the
+// enclosing collections apply unless the request sets them. Parts that cannot
+// be carried over are returned as skipped.
+func (p hoppscotchRequest) toTab(auth *hoppscotchAuth, inherited []hoppscotchKeyValue, values map[string]string) (importedTab, []string) {
+	tab := importedTab{Method: strings.ToUpper(p.Method), URL: fromHoppscotchTemplate(p.Endpoint), Headers: make(map[string]interface{})}
+	var skipped []string
+
+	for _, param := range p.Params {
+		if param.Active && param.Key != "" {
+			tab.addQuery(fromHoppscotchTemplate(param.Key), fromHoppscotchTemplate(param.Value))
+		}
+	}
+	for _, header := range p.Headers {
+		if header.Active && header.Key != "" {
+			tab.addHeader(fromHoppscotchTemplate(header.Key), fromHoppscotchTemplate(header.Value))
+		}
+	}
+	for _, header := range inherited {
+		if header.Active && header.Key != "" {
+			tab.setDefaultHeader(fromHoppscotchTemplate(header.Key), fromHoppscotchTemplate(header.Value))
+		}
+	}
+
+	if auth != nil && auth.AuthActive {
+		switch auth.AuthType {
+		case "bearer":
+			tab.setDefaultHeader("Authorization", "Bearer "+fromHoppscotchTemplate(auth.Token))
+		case "basic":
+			tab.setDefaultHeader("Authorization", basicAuthorization(fromHoppscotchTemplate(auth.Username), fromHoppscotchTemplate(auth.Password), values))
+		case "api-key":
+			key, value := fromHoppscotchTemplate(auth.Key), fromHoppscotchTemplate(auth.Value)
+			// Older exports spell the targets "Headers" and "Query params"
+			if strings.EqualFold(strings.ReplaceAll(auth.AddTo, " ", "_"), "query_params") {
+				tab.addQuery(key, value)
+			} else if key != "" {
+				tab.setDefaultHeader(key, value)
+			}
+		case "none", "":
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s auth is not supported", auth.AuthType))
+		}
+	}
+
+	if p.Body.ContentType != nil {
+		contentType := *p.Body.ContentType
+		switch contentType {
+		case "multipart/form-data":
+			var fields []hoppscotchFormField
+			json.Unmarshal(p.Body.Body, &fields)
+			var form []formField
+			for _, field := range fields {
+				if field.Active {
+					form = append(form, formField{Name: fromHoppscotchTemplate(field.Key), Value: fromHoppscotchTemplate(field.Value), File: field.IsFile})
+				}
+			}
+			skipped = append(skipped, tab.setForm(form, true)...)
+		case "application/x-www-form-urlencoded":
+			// The body lists the fields as "key: value" lines
+			var text string
+			json.Unmarshal(p.Body.Body, &text)
+			var form []formField
+			for _, line := range strings.Split(text, "\n") {
+				key, value, _ := strings.Cut(line, ":")
+				if key = strings.TrimSpace(key); key != "" && !strings.HasPrefix(key, "#") {
+					form = append(form, formField{Name: fromHoppscotchTemplate(key), Value: fromHoppscotchTemplate(strings.TrimSpace(value))})
+				}
+			}
+			tab.setForm(form, false)
+		default:
+			var text string
+			json.Unmarshal(p.Body.Body, &text)
+			tab.Body = fromHoppscotchTemplate(text)
+			tab.setDefaultHeader("Content-Type", contentType)
+		}
+	}
+
+	return tab, skipped
+}
+
+// ExportHoppscotch writes the latest request of the given tabs, or of every
+// tab when none are given, as a Hoppscotch collection, with groups as
+// folders. Hoppscotch keeps variables in environments, so the variables the
+// requests use are returned as a separate environment export, nil when there
+// are none.
+func (r *Resender) ExportHoppscotch(tabIDs []int, name string) ([]byte, []byte, error) {
+	tabs, err := r.loadCollectionTabs(tabIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == "" {
+		name = "ProKZee"
+	}
+
+	collection := newHoppscotchCollection(name)
+	for _, tab := range tabs {
+		request := hoppscotchRequest{
+			Version:  "1",
+			Name:     tab.Name,
+			Method:   tab.Method,
+			Endpoint: toHoppscotchTemplate(tab.URL),
+			Params:   []hoppscotchKeyValue{},
+			Headers:  []hoppscotchKeyValue{},
+			Auth:     &hoppscotchAuth{AuthType: "none", AuthActive: true},
+			Body:     hoppscotchBody{Body: json.RawMessage("null")},
+		}
+		contentType := "text/plain"
+		for _, header := range tab.Headers {
+			request.Headers = append(request.Headers, hoppscotchKeyValue{Key: toHoppscotchTemplate(header.Name), Value: toHoppscotchTemplate(header.Value), Active: true})
+			if strings.EqualFold(header.Name, "Content-Type") {
+				contentType = header.Value
+			}
+		}
+		if tab.Body != "" {
+			// Multipart bodies are kept as raw text, whose boundary matches
+			// the Content-Type header
+			if strings.HasPrefix(contentType, "multipart/") {
+				contentType = "text/plain"
+			}
+			text := toHoppscotchTemplate(tab.Body)
+			if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+				contentType, text = "application/x-www-form-urlencoded", hoppscotchFormText(text)
+			}
+			request.Body = hoppscotchBody{ContentType: &contentType, Body: rawString(text)}
+		}
+
+		folder := &collection
+		for _, part := range tab.folders() {
+			folder = hoppscotchFolder(folder, part)
+		}
+		folder.Requests = append(folder.Requests, request)
+	}
+
+	content, err := encodeCollection(collection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	variables, err := r.usedVariables(tabs)
+	if err != nil || len(variables) == 0 {
+		return content, nil, err
+	}
+	environment := hoppscotchEnvironment{Name: name}
+	for _, variable := range variables {
+		environment.Variables = append(environment.Variables, hoppscotchVariable{Key: variable.Name, Value: variable.Value})
+	}
+	environmentContent, err := encodeCollection(environment)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, environmentContent, nil
+}
+
+// hoppscotchFormText writes an url-encoded body as the "key: value" lines
+// Hoppscotch keeps forms in
+func hoppscotchFormText(body string) string {
+	var lines []string
+	for _, pair := range strings.Split(body, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		lines = append(lines, key+": "+value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rawString encodes text as a JSON string without escaping HTML characters
+func rawString(text string) json.RawMessage {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(text)
+	return bytes.TrimSpace(buffer.Bytes())
+}
+
+func newHoppscotchCollection(name string) hoppscotchCollection {
+	return hoppscotchCollection{
+		Version:  2,
+		Name:     name,
+		Folders:  []hoppscotchCollection{},
+		Requests: []hoppscotchRequest{},
+		Auth:     &hoppscotchAuth{AuthType: "inherit", AuthActive: true},
+		Headers:  []hoppscotchKeyValue{},
+	}
+}
+
+// hoppscotchFolder returns the named folder of a collection, creating it if
+// needed
+func hoppscotchFolder(collection *hoppscotchCollection, name string) *hoppscotchCollection {
+	for i := range collection.Folders {
+		if collection.Folders[i].Name == name {
+			return &collection.Folders[i]
+		}
+	}
+	collection.Folders = append(collection.Folders, newHoppscotchCollection(name))
+	return &collection.Folders[len(collection.Folders)-1]
+}
@@ -0,0 +1,375 @@
+package resender
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// insomniaVariablePattern matches the {{ _.name }} placeholders of Insomnia.
+// The older {{ name }} form is already a resender placeholder.
+var insomniaVariablePattern = regexp.MustCompile(`\{\{\s*_\.([A-Za-z0-9_.$\-]+)\s*\}\}`)
+
+// insomniaExport is an Insomnia v4 export, a flat list of resources linked
+// by their parent IDs
+type insomniaExport struct {
+	Type         string             `json:"_type"`
+	ExportFormat int                `json:"__export_format"`
+	ExportDate   string             `json:"__export_date,omitempty"`
+	ExportSource string             `json:"__export_source,omitempty"`
+	Resources    []insomniaResource `json:"resources"`
+}
+
+type insomniaResource struct {
+	ID       string `json:"_id"`
+	Type     string `json:"_type"`
+	ParentID string `json:"parentId"`
+	Name     string `json:"name"`
+
+	// Requests
+	Method         string                 `json:"method,omitempty"`
+	URL            string                 `json:"url,omitempty"`
+	Body           *insomniaBody          `json:"body,omitempty"`
+	Headers        []insomniaParameter    `json:"headers,omitempty"`
+	Parameters     []insomniaParameter    `json:"parameters,omitempty"`
+	Authentication map[string]interface{} `json:"authentication,omitempty"`
+
+	// Environments
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	// Workspaces
+	Scope string `json:"scope,omitempty"`
+}
+
+type insomniaBody struct {
+	MimeType string              `json:"mimeType,omitempty"`
+	Text     string              `json:"text,omitempty"`
+	Params   []insomniaParameter `json:"params,omitempty"`
+}
+
+type insomniaParameter struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Type     string `json:"type,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// ImportInsomnia creates a resender tab for every request of an Insomnia v4
+// export, in JSON or YAML, grouped by workspace and folder. The base
+// environment and then the named sub-environment, or the first one when no
+// name is given, become resender variables.
+func (r *Resender) ImportInsomnia(data []byte, environment string) (*CollectionImport, error) {
+	var export insomniaExport
+	if err := decodeInsomnia(data, &export); err != nil {
+		return nil, fmt.Errorf("failed to parse Insomnia export: %v", err)
+	}
+	if export.Type != "export" || export.ExportFormat != 4 {
+		return nil, fmt.Errorf("not an Insomnia v4 export")
+	}
+
+	resources := make(map[string]insomniaResource, len(export.Resources))
+	for _, resource := range export.Resources {
+		resources[resource.ID] = resource
+	}
+	result := &CollectionImport{Skipped: []string{}}
+	var workspaces []string
+	for _, resource := range export.Resources {
+		if resource.Type == "workspace" {
+			workspaces = append(workspaces, resource.Name)
+		}
+	}
+	result.Collection = strings.Join(workspaces, ", ")
+	if result.Collection == "" {
+		result.Collection = "Insomnia"
+	}
+
+	// Base environments hang off a workspace, sub-environments off a base one
+	var base, sub []insomniaResource
+	for _, resource := range export.Resources {
+		if resource.Type != "environment" {
+			continue
+		}
+		if resources[resource.ParentID].Type == "environment" {
+			sub = append(sub, resource)
+		} else {
+			base = append(base, resource)
+		}
+	}
+	selected := base
+	for _, resource := range sub {
+		if environment == "" || resource.Name == environment {
+			selected = append(selected, resource)
+			break
+		}
+	}
+	for _, resource := range selected {
+		values := make(map[string]string)
+		flattenEnvironment(resource.Data, "", values)
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := r.SetVariable(name, fromInsomniaTemplate(values[name]), "insomnia environment: "+resource.Name); err != nil {
+				result.Skipped = append(result.Skipped, err.Error())
+				continue
+			}
+			result.Variables++
+		}
+	}
+
+	values := r.variableValues()
+	var tabs []importedTab
+	for _, resource := range export.Resources {
+		switch resource.Type {
+		case "request":
+		case "grpc_request", "websocket_request":
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s is not supported", resource.Name, strings.TrimSuffix(resource.Type, "_request")))
+			continue
+		default:
+			continue
+		}
+
+		var groups []string
+		for parent, ok := resources[resource.ParentID]; ok; parent, ok = resources[parent.ParentID] {
+			groups = append([]string{parent.Name}, groups...)
+		}
+
+		tab, skipped := resource.toTab(values)
+		tab.Name, tab.Group = resource.Name, strings.Join(groups, "/")
+		if len(skipped) > 0 {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", resource.Name, strings.Join(skipped, "; ")))
+		}
+		tabs = append(tabs, tab)
+	}
+
+	if err := r.importTabs(tabs); err != nil {
+		return nil, err
+	}
+	result.Tabs = len(tabs)
+	return result, nil
+}
+
+// decodeInsomnia decodes an export written as JSON or, by the CLI, as YAML
+func decodeInsomnia(data []byte, export *insomniaExport) error {
+	if json.Valid(data) {
+		return json.Unmarshal(data, export)
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	converted, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(converted, export)
+}
+
+// flattenEnvironment turns nested environment data into dotted variable
+// names, which Insomnia templates refer to as {{ _.parent.child }}
+func flattenEnvironment(data map[string]interface{}, prefix string, values map[string]string) {
+	for key, value := range data {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenEnvironment(nested, prefix+key+".", values)
+			continue
+		}
+		values[prefix+key] = valueString(value)
+	}
+}
+
+// nestEnvironment stores a dotted variable name as nested environment data,
+// the reverse of flattenEnvironment
+func nestEnvironment(data map[string]interface{}, path []string, value string) {
+	for _, key := range path[:len(path)-1] {
+		nested, ok := data[key].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			data[key] = nested
+		}
+		data = nested
+	}
+	data[path[len(path)-1]] = value
+}
+
+// fromInsomniaTemplate rewrites {{ _.name }} placeholders as {{name}}
+func fromInsomniaTemplate(text string) string {
+	return insomniaVariablePattern.ReplaceAllString(text, "{{$1}}")
+}
+
+// toInsomniaTemplate rewrites {{name}} placeholders as {{ _.name }}, leaving
+// the dynamic $ variables, which Insomnia does not know, as they are
+func toInsomniaTemplate(text string) string {
+	return variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		if strings.HasPrefix(name, "$") {
+			return match
+		}
+		return "{{ _." + name + " }}"
+	})
+}
+
+// toTab converts an Insomnia request into a resender tab. Parts that cannot
+// be carried over are returned as skipped.
+func (p insomniaResource) toTab(values map[string]string) (importedTab, []string) {
+	tab := importedTab{Method: strings.ToUpper(p.Method), URL: fromInsomniaTemplate(p.URL), Headers: make(map[string]interface{})}
+	var skipped []string
+	if strings.Contains(p.URL, "{%") {
+		skipped = append(skipped, "template tags are not expanded")
+	}
+
+	for _, parameter := range p.Parameters {
+		if !parameter.Disabled && parameter.Name != "" {
+			tab.addQuery(fromInsomniaTemplate(parameter.Name), fromInsomniaTemplate(parameter.Value))
+		}
+	}
+	for _, header := range p.Headers {
+		if !header.Disabled && header.Name != "" {
+			tab.addHeader(fromInsomniaTemplate(header.Name), fromInsomniaTemplate(header.Value))
+		}
+	}
+
+	auth := func(key string) string { return fromInsomniaTemplate(valueString(p.Authentication[key])) }
+	if disabled, _ := p.Authentication["disabled"].(bool); !disabled {
+		switch authType := auth("type"); authType {
+		case "bearer":
+			prefix := auth("prefix")
+			if prefix == "" {
+				prefix = "Bearer"
+			}
+			tab.setDefaultHeader("Authorization", prefix+" "+auth("token"))
+		case "basic":
+			tab.setDefaultHeader("Authorization", basicAuthorization(auth("username"), auth("password"), values))
+		case "apikey":
+			switch auth("addTo") {
+			case "queryParams":
+				tab.addQuery(auth("key"), auth("value"))
+			case "cookie":
+				tab.setDefaultHeader("Cookie", auth("key")+"="+auth("value"))
+			default:
+				if auth("key") != "" {
+					tab.setDefaultHeader(auth("key"), auth("value"))
+				}
+			}
+		case "", "none":
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s auth is not supported", authType))
+		}
+	}
+
+	if p.Body != nil {
+		// Forms come as params, unless they were written out as text
+		mimeType := p.Body.MimeType
+		isForm := len(p.Body.Params) > 0 && (mimeType == "application/x-www-form-urlencoded" || mimeType == "multipart/form-data")
+		switch {
+		case isForm:
+			var form []formField
+			for _, param := range p.Body.Params {
+				if !param.Disabled {
+					form = append(form, formField{Name: fromInsomniaTemplate(param.Name), Value: fromInsomniaTemplate(param.Value), File: param.Type == "file"})
+				}
+			}
+			skipped = append(skipped, tab.setForm(form, mimeType == "multipart/form-data")...)
+		case mimeType == "application/graphql":
+			// The text is already the JSON payload of the query
+			tab.Body = fromInsomniaTemplate(p.Body.Text)
+			tab.setDefaultHeader("Content-Type", "application/json")
+		case mimeType == "application/octet-stream":
+			skipped = append(skipped, "file bodies are not imported")
+		default:
+			tab.Body = fromInsomniaTemplate(p.Body.Text)
+			if mimeType != "" {
+				tab.setDefaultHeader("Content-Type", mimeType)
+			}
+		}
+	}
+
+	return tab, skipped
+}
+
+// ExportInsomnia writes the latest request of the given tabs, or of every tab
+// when none are given, as an Insomnia v4 export with a single workspace.
+// Groups become folders and the variables the requests use become the base
+// environment.
+func (r *Resender) ExportInsomnia(tabIDs []int, name string) ([]byte, error) {
+	tabs, err := r.loadCollectionTabs(tabIDs)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "ProKZee"
+	}
+
+	workspace := insomniaResource{ID: insomniaID("wrk"), Type: "workspace", Name: name, Scope: "collection"}
+	export := insomniaExport{
+		Type:         "export",
+		ExportFormat: 4,
+		ExportDate:   time.Now().UTC().Format(time.RFC3339),
+		ExportSource: "prokzee",
+		Resources:    []insomniaResource{workspace},
+	}
+
+	folders := make(map[string]string)
+	for _, tab := range tabs {
+		parentID, path := workspace.ID, ""
+		for _, part := range tab.folders() {
+			path += "/" + part
+			if id, ok := folders[path]; ok {
+				parentID = id
+				continue
+			}
+			folder := insomniaResource{ID: insomniaID("fld"), Type: "request_group", ParentID: parentID, Name: part}
+			export.Resources = append(export.Resources, folder)
+			folders[path] = folder.ID
+			parentID = folder.ID
+		}
+
+		request := insomniaResource{
+			ID:         insomniaID("req"),
+			Type:       "request",
+			ParentID:   parentID,
+			Name:       tab.Name,
+			Method:     tab.Method,
+			URL:        toInsomniaTemplate(tab.URL),
+			Headers:    []insomniaParameter{},
+			Parameters: []insomniaParameter{},
+			Body:       &insomniaBody{},
+		}
+		for _, header := range tab.Headers {
+			request.Headers = append(request.Headers, insomniaParameter{Name: toInsomniaTemplate(header.Name), Value: toInsomniaTemplate(header.Value)})
+			if strings.EqualFold(header.Name, "Content-Type") {
+				request.Body.MimeType = header.Value
+			}
+		}
+		if tab.Body != "" {
+			request.Body.Text = toInsomniaTemplate(tab.Body)
+		} else {
+			request.Body.MimeType = ""
+		}
+		export.Resources = append(export.Resources, request)
+	}
+
+	variables, err := r.usedVariables(tabs)
+	if err != nil {
+		return nil, err
+	}
+	environment := insomniaResource{ID: insomniaID("env"), Type: "environment", ParentID: workspace.ID, Name: "Base Environment", Data: map[string]interface{}{}}
+	for _, variable := range variables {
+		nestEnvironment(environment.Data, strings.Split(variable.Name, "."), toInsomniaTemplate(variable.Value))
+	}
+	export.Resources = append(export.Resources, environment)
+
+	return encodeCollection(export)
+}
+
+// insomniaID returns a resource ID with the prefix Insomnia uses for its type
+func insomniaID(prefix string) string {
+	return prefix + "_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
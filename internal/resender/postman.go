@@ -0,0 +1,378 @@
+package resender
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PostmanSchema is the collection format written by ExportPostman. Imports
+// accept any v2 collection.
+const PostmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Item     []postmanItem     `json:"item"`
+	Auth     *postmanAuth      `json:"auth,omitempty"`
+	Variable []postmanVariable `json:"variable,omitempty"`
+}
+
+type postmanInfo struct {
+	PostmanID string `json:"_postman_id,omitempty"`
+	Name      string `json:"name"`
+	Schema    string `json:"schema"`
+}
+
+// postmanItem is a request or, when it has items, a folder
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Auth    *postmanAuth    `json:"auth,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string            `json:"method"`
+	Header []postmanKeyValue `json:"header"`
+	Body   *postmanBody      `json:"body,omitempty"`
+	URL    postmanURL        `json:"url"`
+	Auth   *postmanAuth      `json:"auth,omitempty"`
+}
+
+// UnmarshalJSON accepts the short form of a request, a plain URL string
+func (r *postmanRequest) UnmarshalJSON(data []byte) error {
+	var rawURL string
+	if json.Unmarshal(data, &rawURL) == nil {
+		*r = postmanRequest{Method: http.MethodGet, URL: postmanURL{Raw: rawURL}}
+		return nil
+	}
+	type plain postmanRequest
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+type postmanKeyValue struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Type     string `json:"type,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type postmanBody struct {
+	Mode       string            `json:"mode"`
+	Raw        string            `json:"raw,omitempty"`
+	URLEncoded []postmanKeyValue `json:"urlencoded,omitempty"`
+	FormData   []postmanKeyValue `json:"formdata,omitempty"`
+	GraphQL    *struct {
+		Query     string `json:"query"`
+		Variables string `json:"variables"`
+	} `json:"graphql,omitempty"`
+	Options *postmanBodyOptions `json:"options,omitempty"`
+}
+
+type postmanBodyOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+// postmanURL is a URL written either as a string or as an object
+type postmanURL struct {
+	Raw string `json:"raw"`
+}
+
+// UnmarshalJSON accepts both URL forms, rebuilding the object form from its
+// parts when it has no raw URL
+func (u *postmanURL) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &u.Raw) == nil {
+		return nil
+	}
+	var object struct {
+		Raw      string            `json:"raw"`
+		Protocol string            `json:"protocol"`
+		Host     json.RawMessage   `json:"host"`
+		Port     string            `json:"port"`
+		Path     json.RawMessage   `json:"path"`
+		Query    []postmanKeyValue `json:"query"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	if object.Raw != "" {
+		u.Raw = object.Raw
+		return nil
+	}
+
+	raw := joinParts(object.Host, ".")
+	if object.Protocol != "" {
+		raw = object.Protocol + "://" + raw
+	}
+	if object.Port != "" {
+		raw += ":" + object.Port
+	}
+	if path := joinParts(object.Path, "/"); path != "" {
+		raw += "/" + path
+	}
+	var query []string
+	for _, param := range object.Query {
+		if !param.Disabled {
+			query = append(query, param.Key+"="+param.Value)
+		}
+	}
+	if len(query) > 0 {
+		raw += "?" + strings.Join(query, "&")
+	}
+	u.Raw = raw
+	return nil
+}
+
+// joinParts joins a host or path given as a string or a list of segments
+func joinParts(data json.RawMessage, separator string) string {
+	var parts []string
+	if json.Unmarshal(data, &parts) == nil {
+		return strings.Join(parts, separator)
+	}
+	var whole string
+	json.Unmarshal(data, &whole)
+	return strings.TrimPrefix(whole, "/")
+}
+
+type postmanAuth struct {
+	Type   string             `json:"type"`
+	Bearer []postmanAttribute `json:"bearer,omitempty"`
+	Basic  []postmanAttribute `json:"basic,omitempty"`
+	APIKey []postmanAttribute `json:"apikey,omitempty"`
+}
+
+type postmanAttribute struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// attribute returns an auth attribute as a string
+func attribute(attributes []postmanAttribute, key string) string {
+	for _, attribute := range attributes {
+		if attribute.Key == key {
+			return valueString(attribute.Value)
+		}
+	}
+	return ""
+}
+
+type postmanVariable struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	Disabled bool        `json:"disabled,omitempty"`
+}
+
+type postmanEnvironment struct {
+	Name   string `json:"name"`
+	Values []struct {
+		Key     string      `json:"key"`
+		Value   interface{} `json:"value"`
+		Enabled *bool       `json:"enabled"`
+	} `json:"values"`
+}
+
+// ImportPostman creates a resender tab for every request of a Postman v2
+// collection, grouped by collection and folder. Collection variables and
+// then the environment, when given, become resender variables so the {{name}}
+// placeholders keep working.
+func (r *Resender) ImportPostman(collectionData, environmentData []byte) (*CollectionImport, error) {
+	var collection postmanCollection
+	if err := json.Unmarshal(collectionData, &collection); err != nil {
+		return nil, fmt.Errorf("failed to parse Postman collection: %v", err)
+	}
+	if !strings.Contains(collection.Info.Schema, "/collection/v2") {
+		return nil, fmt.Errorf("not a Postman v2 collection")
+	}
+	name := collection.Info.Name
+	if name == "" {
+		name = "Postman"
+	}
+	result := &CollectionImport{Collection: name, Skipped: []string{}}
+
+	for _, variable := range collection.Variable {
+		if variable.Disabled || variable.Key == "" {
+			continue
+		}
+		if err := r.SetVariable(variable.Key, valueString(variable.Value), "postman: "+name); err != nil {
+			result.Skipped = append(result.Skipped, err.Error())
+			continue
+		}
+		result.Variables++
+	}
+
+	if len(environmentData) > 0 {
+		var environment postmanEnvironment
+		if err := json.Unmarshal(environmentData, &environment); err != nil {
+			return nil, fmt.Errorf("failed to parse Postman environment: %v", err)
+		}
+		for _, value := range environment.Values {
+			if value.Enabled != nil && !*value.Enabled || value.Key == "" {
+				continue
+			}
+			if err := r.SetVariable(value.Key, valueString(value.Value), "postman environment: "+environment.Name); err != nil {
+				result.Skipped = append(result.Skipped, err.Error())
+				continue
+			}
+			result.Variables++
+		}
+	}
+
+	values := r.variableValues()
+	var tabs []importedTab
+	var walk func(items []postmanItem, group string, auth *postmanAuth)
+	walk = func(items []postmanItem, group string, auth *postmanAuth) {
+		for _, item := range items {
+			itemAuth := auth
+			if item.Auth != nil {
+				itemAuth = item.Auth
+			}
+			if item.Request == nil {
+				walk(item.Item, group+"/"+item.Name, itemAuth)
+				continue
+			}
+			if item.Request.Auth != nil {
+				itemAuth = item.Request.Auth
+			}
+
+			tab, skipped := item.Request.toTab(itemAuth, values)
+			tab.Name, tab.Group = item.Name, group
+			if len(skipped) > 0 {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", item.Name, strings.Join(skipped, "; ")))
+			}
+			tabs = append(tabs, tab)
+		}
+	}
+	walk(collection.Item, name, collection.Auth)
+
+	if err := r.importTabs(tabs); err != nil {
+		return nil, err
+	}
+	result.Tabs = len(tabs)
+	return result, nil
+}
+
+// toTab converts a Postman request into a resender tab. Parts that cannot be
+// carried over are returned as skipped.
+func (p *postmanRequest) toTab(auth *postmanAuth, values map[string]string) (importedTab, []string) {
+	tab := importedTab{Method: strings.ToUpper(p.Method), URL: p.URL.Raw, Headers: make(map[string]interface{})}
+	var skipped []string
+
+	for _, header := range p.Header {
+		if !header.Disabled && header.Key != "" {
+			tab.addHeader(header.Key, header.Value)
+		}
+	}
+
+	if auth != nil {
+		switch auth.Type {
+		case "bearer":
+			tab.setDefaultHeader("Authorization", "Bearer "+attribute(auth.Bearer, "token"))
+		case "basic":
+			tab.setDefaultHeader("Authorization", basicAuthorization(attribute(auth.Basic, "username"), attribute(auth.Basic, "password"), values))
+		case "apikey":
+			key, value := attribute(auth.APIKey, "key"), attribute(auth.APIKey, "value")
+			if attribute(auth.APIKey, "in") == "query" {
+				tab.addQuery(key, value)
+			} else if key != "" {
+				tab.setDefaultHeader(key, value)
+			}
+		case "noauth", "":
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s auth is not supported", auth.Type))
+		}
+	}
+
+	if p.Body != nil {
+		switch p.Body.Mode {
+		case "raw":
+			tab.Body = p.Body.Raw
+			if p.Body.Options != nil && p.Body.Options.Raw.Language == "json" {
+				tab.setDefaultHeader("Content-Type", "application/json")
+			}
+		case "urlencoded", "formdata":
+			fields := p.Body.URLEncoded
+			if p.Body.Mode == "formdata" {
+				fields = p.Body.FormData
+			}
+			var form []formField
+			for _, field := range fields {
+				if !field.Disabled {
+					form = append(form, formField{Name: field.Key, Value: field.Value, File: field.Type == "file"})
+				}
+			}
+			skipped = append(skipped, tab.setForm(form, p.Body.Mode == "formdata")...)
+		case "graphql":
+			if p.Body.GraphQL != nil {
+				if err := tab.setGraphQL(p.Body.GraphQL.Query, p.Body.GraphQL.Variables); err != nil {
+					skipped = append(skipped, err.Error())
+				}
+			}
+		case "file":
+			skipped = append(skipped, "file bodies are not imported")
+		}
+	}
+
+	return tab, skipped
+}
+
+// ExportPostman writes the latest request of the given tabs, or of every tab
+// when none are given, as a Postman v2.1 collection. Groups become folders
+// and the variables the requests use become collection variables.
+func (r *Resender) ExportPostman(tabIDs []int, name string) ([]byte, error) {
+	tabs, err := r.loadCollectionTabs(tabIDs)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = "ProKZee"
+	}
+
+	collection := postmanCollection{
+		Info: postmanInfo{PostmanID: uuid.New().String(), Name: name, Schema: PostmanSchema},
+		Item: []postmanItem{},
+	}
+	for _, tab := range tabs {
+		request := &postmanRequest{Method: tab.Method, Header: []postmanKeyValue{}, URL: postmanURL{Raw: tab.URL}}
+		for _, header := range tab.Headers {
+			request.Header = append(request.Header, postmanKeyValue{Key: header.Name, Value: header.Value, Type: "text"})
+		}
+		if tab.Body != "" {
+			request.Body = &postmanBody{Mode: "raw", Raw: tab.Body}
+			if json.Valid([]byte(tab.Body)) {
+				request.Body.Options = &postmanBodyOptions{}
+				request.Body.Options.Raw.Language = "json"
+			}
+		}
+
+		folder := &collection.Item
+		for _, part := range tab.folders() {
+			folder = postmanFolder(folder, part)
+		}
+		*folder = append(*folder, postmanItem{Name: tab.Name, Request: request})
+	}
+
+	variables, err := r.usedVariables(tabs)
+	if err != nil {
+		return nil, err
+	}
+	for _, variable := range variables {
+		collection.Variable = append(collection.Variable, postmanVariable{Key: variable.Name, Value: variable.Value})
+	}
+	return encodeCollection(collection)
+}
+
+// postmanFolder returns the items of the named folder, creating it if needed
+func postmanFolder(items *[]postmanItem, name string) *[]postmanItem {
+	for i := range *items {
+		if (*items)[i].Request == nil && (*items)[i].Name == name {
+			return &(*items)[i].Item
+		}
+	}
+	*items = append(*items, postmanItem{Name: name, Item: []postmanItem{}})
+	return &(*items)[len(*items)-1].Item
+}
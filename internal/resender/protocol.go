@@ -0,0 +1,177 @@
+package resender
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"prokzee/internal/upstream"
+
+	"golang.org/x/net/http2"
+)
+
+// Protocol modes that force a specific wire protocol regardless of what the
+// server advertises
+const (
+	ProtocolModeAuto   = ""
+	ProtocolModeHTTP10 = "http/1.0"
+	ProtocolModeHTTP11 = "http/1.1"
+	ProtocolModeH2C    = "h2c" // HTTP/2 with prior knowledge over cleartext
+	ProtocolModeH2TLS  = "h2"  // HTTP/2 negotiated through ALPN
+)
+
+// protocolDialTimeout bounds how long the HTTP/1.0 transport waits to connect
+const protocolDialTimeout = 30 * time.Second
+
+// newForcedTransport returns a round tripper that only speaks the protocol of
+// the given mode
+func newForcedTransport(mode string) (http.RoundTripper, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+	switch strings.ToLower(mode) {
+	case ProtocolModeHTTP10:
+		return &http10Transport{tlsConfig: tlsConfig}, nil
+	case ProtocolModeHTTP11:
+		return upstream.NewHTTPTransport(false), nil
+	case ProtocolModeH2C:
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return upstream.DialContext(ctx, network, addr)
+			},
+		}, nil
+	case ProtocolModeH2TLS:
+		return &http2.Transport{
+			TLSClientConfig: tlsConfig,
+			DialTLSContext: func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+				conn, err := upstream.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn := tls.Client(conn, upstream.WithClientCertificate(config, addr))
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown protocol mode: %s", mode)
+}
+
+// negotiatedProtocol describes the protocol that was actually used for a response
+func negotiatedProtocol(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	if resp.TLS != nil && resp.TLS.NegotiatedProtocol != "" {
+		return fmt.Sprintf("%s (ALPN %s)", resp.Proto, resp.TLS.NegotiatedProtocol)
+	}
+	return resp.Proto
+}
+
+// http10Transport writes requests with an HTTP/1.0 request line, which
+// net/http never does on its own
+type http10Transport struct {
+	tlsConfig *tls.Config
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *http10Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" {
+			host = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), protocolDialTimeout)
+	conn, err := upstream.DialContext(ctx, "tcp", host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsState *tls.ConnectionState
+	if req.URL.Scheme == "https" {
+		config := upstream.WithClientCertificate(t.tlsConfig, host)
+		config.ServerName = req.URL.Hostname()
+		config.NextProtos = []string{"http/1.0"}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(req.Context()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+		conn = tlsConn
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s %s HTTP/1.0\r\n", req.Method, req.URL.RequestURI())
+	hostHeader := req.Host
+	if hostHeader == "" {
+		hostHeader = req.Header.Get("Host")
+	}
+	if hostHeader == "" {
+		hostHeader = req.URL.Host
+	}
+	fmt.Fprintf(w, "Host: %s\r\n", hostHeader)
+	headers := req.Header.Clone()
+	headers.Del("Host")
+	headers.Del("Transfer-Encoding")
+	if len(body) > 0 || req.Method == http.MethodPost || req.Method == http.MethodPut {
+		headers.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	}
+	if err := headers.Write(w); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	w.WriteString("\r\n")
+	w.Write(body)
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.TLS = tlsState
+	resp.Body = &connClosingBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// connClosingBody closes the underlying connection together with the body
+type connClosingBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *connClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.conn.Close()
+	return err
+}
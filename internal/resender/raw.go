@@ -0,0 +1,415 @@
+package resender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/hostaddr"
+	"prokzee/internal/racetest"
+	"prokzee/internal/storage"
+	"prokzee/internal/upstream"
+
+	"github.com/google/uuid"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// rawTimeout bounds a raw exchange, from connecting to the first response
+const rawTimeout = 30 * time.Second
+
+// rawTrailingWait is how long bytes following the first response are waited
+// for, such as the answer to a smuggled request
+const rawTrailingWait = time.Second
+
+// maxRawResponse bounds how many response bytes a raw exchange keeps
+const maxRawResponse = 10 << 20
+
+// rawRequest is what could be made out of a raw message for the history, the
+// message itself is sent as it is
+type rawRequest struct {
+	method  string
+	target  string
+	proto   string
+	headers map[string]interface{}
+	host    string
+	body    string
+}
+
+// parseRawRequest splits a raw message into request line, headers and body.
+// It never fails: a line that is not a header is skipped, a missing blank
+// line leaves the body empty.
+func parseRawRequest(raw string) rawRequest {
+	parsed := rawRequest{headers: make(map[string]interface{})}
+
+	head, body, found := strings.Cut(raw, "\r\n\r\n")
+	if lfHead, lfBody, lfFound := strings.Cut(raw, "\n\n"); lfFound && (!found || len(lfHead) < len(head)) {
+		head, body, found = lfHead, lfBody, true
+	}
+	if found {
+		parsed.body = body
+	}
+
+	lines := strings.Split(head, "\n")
+	fields := strings.Fields(lines[0])
+	if len(fields) > 0 {
+		parsed.method = fields[0]
+	}
+	if len(fields) > 1 {
+		parsed.target = fields[1]
+	}
+	if len(fields) > 2 {
+		parsed.proto = fields[2]
+	}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			continue
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		// Repeated headers, such as a second Content-Length, are kept together
+		if previous, ok := parsed.headers[name].(string); ok {
+			value = previous + ", " + value
+		}
+		parsed.headers[name] = value
+		if strings.EqualFold(name, "Host") && parsed.host == "" {
+			parsed.host = value
+		}
+	}
+	return parsed
+}
+
+// normalizeLineEndings turns the bare LF line endings of the request line and
+// headers into CRLF, the body is left alone
+func normalizeLineEndings(raw string) string {
+	end := len(raw)
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		end = i + 4
+	}
+	if i := strings.Index(raw, "\n\n"); i >= 0 && i+2 < end {
+		end = i + 2
+	}
+	head := strings.ReplaceAll(raw[:end], "\r\n", "\n")
+	return strings.ReplaceAll(head, "\n", "\r\n") + raw[end:]
+}
+
+// rawTarget returns the URL the raw message goes to: the given one, or the
+// Host header over http when none is given
+func rawTarget(target string, parsed rawRequest) (*url.URL, error) {
+	if target == "" {
+		if parsed.host == "" {
+			return nil, fmt.Errorf("missing target URL and Host header")
+		}
+		target = "http://" + parsed.host
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported target scheme: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("target URL has no host")
+	}
+	return u, nil
+}
+
+// exchangeRaw writes a raw message to the target and reads back the first
+// response, followed by whatever else the server sends shortly after. raw
+// holds every byte received, resp is nil when they do not start with a
+// response. The phases of the exchange are recorded in timer.
+func exchangeRaw(ctx context.Context, target *url.URL, message []byte, method string, timer *sendTimer) (resp *http.Response, body []byte, raw []byte, err error) {
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+
+	ctx, cancel := context.WithTimeout(ctx, rawTimeout)
+	defer cancel()
+	dialStart := time.Now()
+	conn, err := upstream.DialContext(httptrace.WithClientTrace(ctx, timer.trace()), "tcp", addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// Connections through an upstream proxy report no connect events
+	timer.mu.Lock()
+	if timer.connectDone.IsZero() {
+		timer.connectStart, timer.connectDone = dialStart, time.Now()
+	}
+	timer.mu.Unlock()
+	defer conn.Close()
+	// Closing the connection is what interrupts reads on cancel
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var tlsState *tls.ConnectionState
+	if target.Scheme == "https" {
+		config := upstream.WithClientCertificate(&tls.Config{InsecureSkipVerify: true}, addr)
+		config.ServerName = target.Hostname()
+		config.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, config)
+		timer.mark(&timer.tlsStart)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, nil, nil, fmt.Errorf("TLS handshake failed: %v", err)
+		}
+		timer.mark(&timer.tlsDone)
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+		conn = tlsConn
+	}
+
+	// Requests of a parallel group hold back their last byte until all of
+	// them are written
+	if ticket, ok := ctx.Value(gateKey{}).(*racetest.Ticket); ok {
+		err = ticket.Write(ctx, conn, message, 1)
+	} else {
+		_, err = conn.Write(message)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	timer.mark(&timer.wroteRequest)
+
+	var received bytes.Buffer
+	reader := bufio.NewReader(io.TeeReader(io.LimitReader(conn, maxRawResponse), &received))
+	if _, err := reader.Peek(1); err == nil {
+		timer.mark(&timer.firstByte)
+	}
+	resp, err = http.ReadResponse(reader, &http.Request{Method: method})
+	if err == nil {
+		resp.TLS = tlsState
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		timer.finish()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, nil, received.Bytes(), err
+	}
+
+	// Pick up anything sent after the first response
+	conn.SetReadDeadline(time.Now().Add(rawTrailingWait))
+	io.Copy(io.Discard, reader)
+	return resp, body, received.Bytes(), nil
+}
+
+// sendRaw sends the raw message of a resender tab byte for byte, so malformed
+// and ambiguous requests reach the server as they were written. The exchange
+// stops when parent is cancelled.
+func (r *Resender) sendRaw(parent context.Context, tabId float64, requestDetails map[string]interface{}) error {
+	templateRaw, _ := requestDetails["raw"].(string)
+	targetURL, _ := requestDetails["url"].(string)
+
+	// Substitute {{name}} variables, the tab keeps the template
+	values := r.variableValues()
+	message := expandVariables(templateRaw, values)
+	targetURL = expandVariables(targetURL, values)
+	if normalize, _ := requestDetails["normalizeLineEndings"].(bool); normalize {
+		message = normalizeLineEndings(message)
+	}
+
+	parsed := parseRawRequest(message)
+	target, err := rawTarget(targetURL, parsed)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	r.activeReqMutex.Lock()
+	r.activeRequests[int(tabId)] = cancel
+	r.activeReqMutex.Unlock()
+	defer func() {
+		r.activeReqMutex.Lock()
+		delete(r.activeRequests, int(tabId))
+		r.activeReqMutex.Unlock()
+		cancel()
+	}()
+
+	timer := newSendTimer()
+	resp, respBody, rawResponse, err := exchangeRaw(ctx, target, []byte(message), parsed.method, timer)
+	if err != nil {
+		log.Printf("Error sending raw request: %v", err)
+		// Reported here rather than returned, so the bytes received before
+		// the error are not lost
+		runtime.EventsEmit(r.ctx, "backend:resenderResponse", map[string]interface{}{
+			"error":       err.Error(),
+			"tabId":       tabId,
+			"raw":         true,
+			"rawResponse": string(rawResponse),
+		})
+		return nil
+	}
+
+	timings := timer.timings(target.Scheme == "https")
+	timings.RequestBytes = int64(len(parsed.body))
+	timings.HeaderBytes = headerSize(resp)
+	timings.ResponseBytes = int64(len(respBody))
+
+	// Undo the content encoding so the response reads as plaintext
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	responseEncoding := ""
+	if !contentcoding.IsIdentity(contentEncoding) && contentcoding.Supported(contentEncoding) {
+		if decoded, err := contentcoding.Decode(respBody, contentEncoding, maxRawResponse); err == nil {
+			respBody = decoded
+			responseEncoding = strings.Join(contentcoding.Codings(contentEncoding), ", ")
+		} else {
+			log.Printf("Error decoding %s response: %v", contentEncoding, err)
+		}
+	}
+
+	timings.DecodedBytes = int64(len(respBody))
+
+	domain := hostaddr.Canonical(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		if target.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	path, query, _ := strings.Cut(parsed.target, "?")
+	if u, err := url.Parse(parsed.target); err == nil && u.IsAbs() {
+		path, query = u.Path, u.RawQuery
+	}
+	if path == "" {
+		path = "/"
+	}
+	fullURL := target.Scheme + "://" + target.Host + path
+	if query != "" {
+		fullURL += "?" + query
+	}
+	method := parsed.method
+	if method == "" {
+		method = "GET"
+	}
+
+	headersJSON, err := json.Marshal(parsed.headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %v", err)
+	}
+	respHeadersJSON, err := json.Marshal(resp.Header)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response headers: %v", err)
+	}
+
+	requestID := uuid.New().String()
+	// The raw bytes show the chunk boundaries net/http decodes away
+	framing := storage.NewTransferInfo(nil, resp)
+	framing.RequestChunks = storage.MessageChunks([]byte(message))
+	framing.ResponseChunks = storage.MessageChunks(rawResponse)
+	transferInfo := framing.JSON()
+	negotiated := negotiatedProtocol(resp)
+
+	// Keep only the headers of requests marked as do-not-log
+	storedRequestBody, storedResponseBody := parsed.body, string(respBody)
+	storedRawRequest, storedRawResponse := templateRaw, string(rawResponse)
+	bodyRedacted := r.requestStorage.IsDoNotLog(domain, path)
+	if bodyRedacted {
+		storedRequestBody, storedResponseBody = "", ""
+		storedRawRequest, storedRawResponse = "", ""
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var newRequestId int
+	err = tx.QueryRow(`
+		INSERT INTO resender_requests (
+			request_id, domain, port, path, query, url, method,
+			request_headers, request_body, response_headers, response_body,
+			http_version, status, mime_type, length, negotiated_protocol,
+			raw_request, raw_response, timings
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, requestID, domain, port, path, query, targetURL, method,
+		string(headersJSON), storedRequestBody, string(respHeadersJSON), storedResponseBody,
+		parsed.proto, resp.Status, resp.Header.Get("Content-Type"), len(respBody), negotiated,
+		storedRawRequest, storedRawResponse, timingsJSON(timings)).Scan(&newRequestId)
+	if err != nil {
+		return fmt.Errorf("failed to save to resender_requests: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO requests (
+			request_id, domain, port, path, query, url, method,
+			request_headers, request_body, response_headers, response_body,
+			http_version, status, mime_type, length, transfer_info, body_redacted, response_encoding
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, requestID, domain, port, path, query, fullURL, method,
+		string(headersJSON), storedRequestBody, string(respHeadersJSON), storedResponseBody,
+		parsed.proto, resp.Status, resp.Header.Get("Content-Type"), len(respBody), transferInfo, bodyRedacted, responseEncoding)
+	if err != nil {
+		return fmt.Errorf("failed to copy to requests: %v", err)
+	}
+
+	if err := appendTabRequest(tx, int(tabId), newRequestId); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	runtime.EventsEmit(r.ctx, "backend:resenderResponse", map[string]interface{}{
+		"httpVersion":     resp.Proto,
+		"tabId":           tabId,
+		"requestId":       newRequestId,
+		"responseHeaders": resp.Header,
+		"responseBody":    string(respBody),
+		"status":          resp.Status,
+		"isRedirect":      resp.StatusCode >= 300 && resp.StatusCode < 400,
+		"redirectURL":     resp.Header.Get("Location"),
+		"transferInfo":    transferInfo,
+		"negotiated":      negotiated,
+		"raw":             true,
+		"rawResponse":     string(rawResponse),
+		"timings":         timings,
+	})
+	return nil
+}
+
+// appendTabRequest adds a sent request to the history of a tab
+func appendTabRequest(tx *sql.Tx, tabID int, requestID int) error {
+	var requestIDsJSON string
+	err := tx.QueryRow("SELECT request_ids_arr FROM resender_tabs WHERE id = ?", tabID).Scan(&requestIDsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tab request IDs: %v", err)
+	}
+
+	var requestIDs []int
+	if err := json.Unmarshal([]byte(requestIDsJSON), &requestIDs); err == nil {
+		requestIDs = append(requestIDs, requestID)
+		if newRequestIDsJSON, err := json.Marshal(requestIDs); err == nil {
+			_, err = tx.Exec("UPDATE resender_tabs SET request_ids_arr = ? WHERE id = ?", string(newRequestIDsJSON), tabID)
+			if err != nil {
+				return fmt.Errorf("failed to update tab request IDs: %v", err)
+			}
+		}
+	}
+	return nil
+}
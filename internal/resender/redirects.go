@@ -0,0 +1,199 @@
+package resender
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/hostaddr"
+
+	"github.com/google/uuid"
+)
+
+// DefaultMaxRedirects is how many redirects a tab follows unless told otherwise
+const DefaultMaxRedirects = 10
+
+// maxRedirectsLimit bounds the hops a tab can be set to follow
+const maxRedirectsLimit = 50
+
+// RedirectOptions is how a tab handles redirect responses
+type RedirectOptions struct {
+	Follow  bool `json:"follow"`  // otherwise the 3xx response is returned
+	MaxHops int  `json:"maxHops"` // redirects followed before the last 3xx is returned
+	// ShowChain stores every hop as its own request of the tab, not only the
+	// final response
+	ShowChain bool `json:"showChain"`
+}
+
+// normalize bounds the hops of the options
+func (o *RedirectOptions) normalize() {
+	if o.MaxHops <= 0 {
+		o.MaxHops = DefaultMaxRedirects
+	}
+	if o.MaxHops > maxRedirectsLimit {
+		o.MaxHops = maxRedirectsLimit
+	}
+}
+
+// SetTabRedirects sets how a resender tab handles redirect responses
+func (r *Resender) SetTabRedirects(tabID int, options RedirectOptions) error {
+	options.normalize()
+	_, err := r.db.Exec(`
+		UPDATE resender_tabs SET follow_redirects = ?, max_redirects = ?, show_redirect_chain = ? WHERE id = ?
+	`, options.Follow, options.MaxHops, options.ShowChain, tabID)
+	if err != nil {
+		return fmt.Errorf("failed to update tab redirect options: %v", err)
+	}
+	return nil
+}
+
+// tabRedirects returns the redirect options of a tab, the defaults for a tab
+// that is not stored
+func (r *Resender) tabRedirects(tabID int) (RedirectOptions, error) {
+	var options RedirectOptions
+	err := r.db.QueryRow(`
+		SELECT COALESCE(follow_redirects, 0), COALESCE(max_redirects, 0), COALESCE(show_redirect_chain, 0)
+		FROM resender_tabs WHERE id = ?
+	`, tabID).Scan(&options.Follow, &options.MaxHops, &options.ShowChain)
+	if err != nil && err != sql.ErrNoRows {
+		return options, fmt.Errorf("failed to fetch tab redirect options: %v", err)
+	}
+	options.normalize()
+	return options, nil
+}
+
+// redirectHop is one redirect response met while following redirects
+type redirectHop struct {
+	request  *http.Request
+	response *http.Response
+	body     []byte
+	encoding string // content codings undone on the body
+}
+
+// summary describes a hop for the frontend
+func (h redirectHop) summary() map[string]interface{} {
+	return map[string]interface{}{
+		"method":   h.request.Method,
+		"url":      h.request.URL.String(),
+		"status":   h.response.Status,
+		"location": h.response.Header.Get("Location"),
+	}
+}
+
+// redirectChain describes the hops of a redirect chain for the frontend
+func redirectChain(hops []redirectHop) []map[string]interface{} {
+	chain := make([]map[string]interface{}, 0, len(hops))
+	for _, hop := range hops {
+		chain = append(chain, hop.summary())
+	}
+	return chain
+}
+
+// readDecodedBody reads a response body, undoing its content encoding so it
+// reads as plaintext. It returns the codings undone.
+func readDecodedBody(resp *http.Response) ([]byte, string, error) {
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	if contentcoding.IsIdentity(contentEncoding) || !contentcoding.Supported(contentEncoding) {
+		body, err := io.ReadAll(resp.Body)
+		return body, "", err
+	}
+	decoder, err := contentcoding.NewReader(resp.Body, contentEncoding)
+	if err != nil {
+		return nil, "", err
+	}
+	defer decoder.Close()
+	body, err := io.ReadAll(decoder)
+	return body, strings.Join(contentcoding.Codings(contentEncoding), ", "), err
+}
+
+// location returns the domain, port, path and query a request URL is stored
+// under
+func location(u *url.URL) (domain, port, path, query string) {
+	domain = hostaddr.Canonical(u.Hostname())
+	port = u.Port()
+	if port == "" {
+		if u.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	path = u.Path
+	if path == "" {
+		path = "/"
+	}
+	return domain, port, path, u.RawQuery
+}
+
+// flattenHeader turns request headers into the name to value form the
+// resender stores them in
+func flattenHeader(header http.Header) map[string]interface{} {
+	flat := make(map[string]interface{}, len(header))
+	for name, values := range header {
+		flat[name] = strings.Join(values, ", ")
+	}
+	return flat
+}
+
+// storeHop records a redirect hop in resender_requests and the history,
+// returning its resender request ID. requestBody is what the hop sent when its
+// request carried a body.
+func (r *Resender) storeHop(tx *sql.Tx, hop redirectHop, requestBody []byte, protocolVersion string) (int, error) {
+	domain, port, path, query := location(hop.request.URL)
+	headersJSON, err := json.Marshal(flattenHeader(hop.request.Header))
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal headers: %v", err)
+	}
+	respHeadersJSON, err := json.Marshal(hop.response.Header)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal response headers: %v", err)
+	}
+
+	// Requests turned into a GET by the redirect carry no body
+	storedRequestBody, storedResponseBody := "", string(hop.body)
+	if hop.request.Body != nil && hop.request.Body != http.NoBody {
+		storedRequestBody = string(requestBody)
+	}
+	bodyRedacted := r.requestStorage.IsDoNotLog(domain, path)
+	if bodyRedacted {
+		storedRequestBody, storedResponseBody = "", ""
+	}
+
+	requestID := uuid.New().String()
+	negotiated := negotiatedProtocol(hop.response)
+	var hopID int
+	err = tx.QueryRow(`
+		INSERT INTO resender_requests (
+			request_id, domain, port, path, query, url, method,
+			request_headers, request_body, response_headers, response_body,
+			http_version, status, mime_type, length, negotiated_protocol
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, requestID, domain, port, path, query, hop.request.URL.String(), hop.request.Method,
+		string(headersJSON), storedRequestBody, string(respHeadersJSON), storedResponseBody,
+		protocolVersion, hop.response.Status,
+		hop.response.Header.Get("Content-Type"), len(hop.body), negotiated).Scan(&hopID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save redirect hop to resender_requests: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO requests (
+			request_id, domain, port, path, query, url, method,
+			request_headers, request_body, response_headers, response_body,
+			http_version, status, mime_type, length, body_redacted, response_encoding
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, requestID, domain, port, path, query, hop.request.URL.String(), hop.request.Method,
+		string(headersJSON), storedRequestBody, string(respHeadersJSON), storedResponseBody,
+		protocolVersion, hop.response.Status,
+		hop.response.Header.Get("Content-Type"), len(hop.body), bodyRedacted, hop.encoding)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy redirect hop to requests: %v", err)
+	}
+	return hopID, nil
+}
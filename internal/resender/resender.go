@@ -7,15 +7,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"sync"
 
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/hostaddr"
 	"prokzee/internal/storage"
+	"prokzee/internal/upstream"
 
 	"bytes"
-	"compress/gzip"
-	"crypto/tls"
 	"io"
 
 	"github.com/google/uuid"
@@ -37,6 +39,7 @@ type Resender struct {
 	activeRequests map[int]context.CancelFunc
 	activeReqMutex sync.Mutex
 	requestStorage *storage.RequestStorage
+	activeGroups   map[int]context.CancelFunc // groups being sent, guarded by activeReqMutex
 }
 
 // NewResender creates a new Resender instance
@@ -47,9 +50,40 @@ func NewResender(ctx context.Context, db *sql.DB, requestStorage *storage.Reques
 		activeRequests: make(map[int]context.CancelFunc),
 		activeReqMutex: sync.Mutex{},
 		requestStorage: requestStorage,
+		activeGroups:   make(map[int]context.CancelFunc),
 	}
 }
 
+// EnsureSchema adds resender columns and tables introduced after the project
+// was created
+func (r *Resender) EnsureSchema() error {
+	if err := storage.EnsureColumn(r.db, "resender_requests", "negotiated_protocol", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := storage.EnsureColumn(r.db, "resender_tabs", "group_name", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	redirectColumns := map[string]string{
+		"follow_redirects":    "BOOLEAN DEFAULT 0",
+		"max_redirects":       fmt.Sprintf("INTEGER DEFAULT %d", DefaultMaxRedirects),
+		"show_redirect_chain": "BOOLEAN DEFAULT 0",
+	}
+	for column, definition := range redirectColumns {
+		if err := storage.EnsureColumn(r.db, "resender_tabs", column, definition); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"raw_request", "raw_response", "timings"} {
+		if err := storage.EnsureColumn(r.db, "resender_requests", column, "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	if err := r.ensureVariablesTable(); err != nil {
+		return err
+	}
+	return r.ensureGroupsTable()
+}
+
 // CreateNewTab creates a new resender tab
 func (r *Resender) CreateNewTab(newTabData map[string]interface{}) error {
 	defaultRequest, ok := newTabData["defaultRequest"].(map[string]interface{})
@@ -105,7 +139,7 @@ func (r *Resender) CreateNewTab(newTabData map[string]interface{}) error {
 
 	// Extract URL components for storage
 	parsedURL := httpReq.URL
-	domain := parsedURL.Hostname()
+	domain := hostaddr.Canonical(parsedURL.Hostname())
 	port := parsedURL.Port()
 	if port == "" {
 		if parsedURL.Scheme == "https" {
@@ -221,7 +255,7 @@ func (r *Resender) SendToResender(requestData map[string]interface{}) error {
 	}
 
 	// Extract domain and port
-	domain := parsedURL.Hostname()
+	domain := hostaddr.Canonical(parsedURL.Hostname())
 	port := parsedURL.Port()
 	if port == "" {
 		if parsedURL.Scheme == "https" {
@@ -288,7 +322,10 @@ func (r *Resender) SendToResender(requestData map[string]interface{}) error {
 
 // GetTabs retrieves all resender tabs
 func (r *Resender) GetTabs() ([]map[string]interface{}, error) {
-	rows, err := r.db.Query("SELECT id, name, request_ids_arr FROM resender_tabs ORDER BY id ASC")
+	rows, err := r.db.Query(`
+		SELECT id, name, request_ids_arr, COALESCE(group_name, ''),
+			COALESCE(follow_redirects, 0), COALESCE(max_redirects, 0), COALESCE(show_redirect_chain, 0)
+		FROM resender_tabs ORDER BY id ASC`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch resender tabs: %v", err)
 	}
@@ -297,10 +334,12 @@ func (r *Resender) GetTabs() ([]map[string]interface{}, error) {
 	var tabs []map[string]interface{}
 	for rows.Next() {
 		var id int
-		var name, requestIDsArrJSON string
-		if err := rows.Scan(&id, &name, &requestIDsArrJSON); err != nil {
+		var name, requestIDsArrJSON, group string
+		var redirects RedirectOptions
+		if err := rows.Scan(&id, &name, &requestIDsArrJSON, &group, &redirects.Follow, &redirects.MaxHops, &redirects.ShowChain); err != nil {
 			return nil, fmt.Errorf("failed to scan resender tab: %v", err)
 		}
+		redirects.normalize()
 
 		// Parse the request IDs array
 		var requestIDs []int
@@ -333,6 +372,8 @@ func (r *Resender) GetTabs() ([]map[string]interface{}, error) {
 			"name":         name,
 			"requestIds":   requestIDs,
 			"currentIndex": len(requestIDs) - 1,
+			"group":        group,
+			"redirects":    redirects,
 		})
 	}
 
@@ -389,6 +430,8 @@ func (r *Resender) GetTabs() ([]map[string]interface{}, error) {
 			"name":         defaultTabName,
 			"requestIds":   []int{firstRequestId},
 			"currentIndex": 0,
+			"group":        "",
+			"redirects":    RedirectOptions{MaxHops: DefaultMaxRedirects},
 		}
 		tabs = append(tabs, defaultTab)
 	}
@@ -412,6 +455,11 @@ func (r *Resender) GetTabs() ([]map[string]interface{}, error) {
 
 // SendRequest sends a request from a resender tab
 func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interface{}) error {
+	// Raw mode sends the message as written instead of building a request
+	if raw, ok := requestDetails["raw"].(string); ok && raw != "" {
+		return r.sendRaw(r.ctx, tabId, requestDetails)
+	}
+
 	url, ok := requestDetails["url"].(string)
 	if !ok {
 		log.Println("Invalid or missing URL")
@@ -438,6 +486,24 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		body = ""
 	}
 
+	// Substitute {{name}} variables. The tab keeps the templates, the history
+	// gets what went on the wire.
+	templateURL, templateBody := url, body
+	templateHeadersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %v", err)
+	}
+	values := r.variableValues()
+	url = expandVariables(url, values)
+	body = expandVariables(body, values)
+	expandedHeaders := make(map[string]interface{}, len(headers))
+	for key, value := range headers {
+		if strValue, ok := value.(string); ok {
+			expandedHeaders[expandVariables(key, values)] = expandVariables(strValue, values)
+		}
+	}
+	headers = expandedHeaders
+
 	// Create the request with a copy of the body that can be read multiple times
 	bodyReader := strings.NewReader(body)
 	bodyBytes := []byte(body) // Keep a copy for storage
@@ -459,6 +525,11 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		req.ProtoMajor = 2
 		req.ProtoMinor = 0
 	}
+	useHTTP3 := protocolVersion == "HTTP/3" || protocolVersion == "HTTP/3.0"
+	if useHTTP3 {
+		req.ProtoMajor = 3
+		req.ProtoMinor = 0
+	}
 
 	// Set headers
 	for key, value := range headers {
@@ -467,20 +538,84 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		}
 	}
 
-	// Create a custom transport based on the requested protocol version
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	// Replay with chunked encoding when asked to, or when the original request was chunked
+	chunked, _ := requestDetails["chunked"].(bool)
+	if strings.EqualFold(req.Header.Get("Transfer-Encoding"), "chunked") {
+		chunked = true
 	}
+	var chunks *chunkReader
+	if chunked {
+		chunkSize, _ := requestDetails["chunkSize"].(float64)
+		chunks = applyChunkedEncoding(req, bodyBytes, int(chunkSize))
+		if trailers, ok := requestDetails["trailers"].(map[string]interface{}); ok {
+			req.Trailer = make(http.Header)
+			for key, value := range trailers {
+				if strValue, ok := value.(string); ok {
+					req.Trailer.Set(key, strValue)
+				}
+			}
+		}
+	}
+
+	// Time the phases of the send
+	timer := newSendTimer()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timer.trace()))
 
-	// Disable HTTP/2 if HTTP/1.1 is requested
-	if protocolVersion == "HTTP/1.1" {
-		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+	// Negotiate h2 with the server only when HTTP/2 is requested
+	transport := upstream.NewHTTPTransport(upstream.IsHTTP2(protocolVersion))
+
+	// Follow redirects only when the tab asks for it, keeping every hop
+	redirects, err := r.tabRedirects(int(tabId))
+	if err != nil {
+		log.Printf("Warning: %v", err)
 	}
+	var hops []redirectHop
+	finalReq := req
+	redirectLimitReached := false
 
 	client := &http.Client{
 		Transport: transport,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if !redirects.Follow {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > redirects.MaxHops {
+				redirectLimitReached = true
+				return http.ErrUseLastResponse
+			}
+			// The client closes the redirect response once this returns
+			hop := redirectHop{request: via[len(via)-1], response: next.Response}
+			var readErr error
+			hop.body, hop.encoding, readErr = readDecodedBody(next.Response)
+			if readErr != nil {
+				log.Printf("Error reading redirect response body: %v", readErr)
+			}
+			hops = append(hops, hop)
+			finalReq = next
+			return nil
+		},
+	}
+
+	// Force a specific wire protocol when requested
+	protocolMode, _ := requestDetails["protocolMode"].(string)
+	if protocolMode != ProtocolModeAuto {
+		forced, err := newForcedTransport(protocolMode)
+		if err != nil {
+			runtime.EventsEmit(r.ctx, "backend:resenderResponse", map[string]interface{}{
+				"error": err.Error(),
+				"tabId": tabId,
+			})
+			return err
+		}
+		client.Transport = forced
+	}
+
+	// Try HTTP/3 first when requested, falling back to the regular transport
+	if useHTTP3 && protocolMode == ProtocolModeAuto {
+		h3Transport := upstream.NewTransport(transport)
+		h3Transport.SetEnabled(true)
+		h3Transport.SetForceHTTP3(true)
+		client.Transport = h3Transport
 	}
 
 	// Send the request
@@ -494,23 +629,28 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		return err
 	}
 	defer resp.Body.Close()
+	wire := &countingReader{ReadCloser: resp.Body}
+	resp.Body = wire
 
 	// Read response body while keeping a copy
 	var respBody []byte
 
-	// Check if response is gzip encoded
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzipReader, gzipErr := gzip.NewReader(resp.Body)
-		if gzipErr != nil {
-			log.Printf("Error creating gzip reader: %v", gzipErr)
+	// Undo the content encoding so the response reads as plaintext
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	responseEncoding := ""
+	if !contentcoding.IsIdentity(contentEncoding) && contentcoding.Supported(contentEncoding) {
+		decoder, decodeErr := contentcoding.NewReader(resp.Body, contentEncoding)
+		if decodeErr != nil {
+			log.Printf("Error creating %s decoder: %v", contentEncoding, decodeErr)
 			runtime.EventsEmit(r.ctx, "backend:resenderResponse", map[string]interface{}{
-				"error": gzipErr.Error(),
+				"error": decodeErr.Error(),
 				"tabId": tabId,
 			})
-			return gzipErr
+			return decodeErr
 		}
-		defer gzipReader.Close()
-		respBody, err = io.ReadAll(gzipReader)
+		defer decoder.Close()
+		respBody, err = io.ReadAll(decoder)
+		responseEncoding = strings.Join(contentcoding.Codings(contentEncoding), ", ")
 	} else {
 		respBody, err = io.ReadAll(resp.Body)
 	}
@@ -523,6 +663,12 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		})
 		return err
 	}
+	timer.finish()
+	timings := timer.timings(finalReq.URL.Scheme == "https")
+	timings.RequestBytes = int64(len(bodyBytes))
+	timings.HeaderBytes = headerSize(resp)
+	timings.ResponseBytes = wire.n
+	timings.DecodedBytes = int64(len(respBody))
 
 	// Create a new response with the copied body for storage
 	respForStorage := *resp
@@ -534,7 +680,7 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 
 	// Extract URL components for storage
 	parsedURL := reqForStorage.URL
-	domain := parsedURL.Hostname()
+	domain := hostaddr.Canonical(parsedURL.Hostname())
 
 	// Skip storing prokzee requests
 	if strings.Contains(strings.ToLower(domain), "prokzee") {
@@ -570,6 +716,48 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 	// Generate a UUID for the request_id
 	requestID := uuid.New().String()
 
+	// Record the framing used on the wire, including response trailers
+	framing := storage.NewTransferInfo(req, resp)
+	if chunks != nil {
+		framing.RequestChunks = chunks.chunks()
+	}
+	transferInfo := framing.JSON()
+	negotiated := negotiatedProtocol(resp)
+
+	// Keep only the headers of requests marked as do-not-log
+	storedRequestBody, storedResponseBody := string(bodyBytes), string(respBody)
+	bodyRedacted := r.requestStorage.IsDoNotLog(domain, path)
+	if bodyRedacted {
+		storedRequestBody, storedResponseBody = "", ""
+	}
+
+	// Once redirects are followed, the history records the request that got
+	// the final response, the tab keeps the one it sent
+	historyURL, historyMethod, historyHeadersJSON := req.URL.String(), method, string(headersJSON)
+	historyDomain, historyPort, historyPath, historyQuery := domain, port, path, query
+	historyRequestBody, historyResponseBody, historyRedacted := storedRequestBody, storedResponseBody, bodyRedacted
+	if finalReq != req {
+		historyDomain, historyPort, historyPath, historyQuery = location(finalReq.URL)
+		historyURL, historyMethod = finalReq.URL.String(), finalReq.Method
+		finalHeadersJSON, err := json.Marshal(flattenHeader(finalReq.Header))
+		if err != nil {
+			return fmt.Errorf("failed to marshal headers: %v", err)
+		}
+		historyHeadersJSON = string(finalHeadersJSON)
+		historyRequestBody, historyResponseBody = "", string(respBody)
+		if finalReq.Body != nil && finalReq.Body != http.NoBody {
+			historyRequestBody = string(bodyBytes)
+		}
+		historyRedacted = r.requestStorage.IsDoNotLog(historyDomain, historyPath)
+		if historyRedacted {
+			historyRequestBody, historyResponseBody = "", ""
+		}
+	}
+	tabRequestBody := templateBody
+	if bodyRedacted {
+		tabRequestBody = ""
+	}
+
 	// Start a transaction
 	tx, err := r.db.Begin()
 	if err != nil {
@@ -577,19 +765,31 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 	}
 	defer tx.Rollback()
 
+	// Hops of the redirect chain come before the final response in the tab
+	var hopIDs []int
+	if redirects.ShowChain {
+		for _, hop := range hops {
+			hopID, err := r.storeHop(tx, hop, bodyBytes, protocolVersion)
+			if err != nil {
+				return err
+			}
+			hopIDs = append(hopIDs, hopID)
+		}
+	}
+
 	// Insert into resender_requests first
 	var newRequestId int
 	err = tx.QueryRow(`
 		INSERT INTO resender_requests (
 			request_id, domain, port, path, query, url, method, 
 			request_headers, request_body, response_headers, response_body, 
-			http_version, status, mime_type, length
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			http_version, status, mime_type, length, negotiated_protocol, timings
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		RETURNING id
-	`, requestID, domain, port, path, query, req.URL.String(), method,
-		string(headersJSON), string(bodyBytes), string(respHeadersJSON), string(respBody),
+	`, requestID, domain, port, path, query, templateURL, method,
+		string(templateHeadersJSON), tabRequestBody, string(respHeadersJSON), storedResponseBody,
 		protocolVersion, resp.Status,
-		resp.Header.Get("Content-Type"), len(respBody)).Scan(&newRequestId)
+		resp.Header.Get("Content-Type"), len(respBody), negotiated, timingsJSON(timings)).Scan(&newRequestId)
 	if err != nil {
 		return fmt.Errorf("failed to save to resender_requests: %v", err)
 	}
@@ -599,31 +799,20 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		INSERT INTO requests (
 			request_id, domain, port, path, query, url, method, 
 			request_headers, request_body, response_headers, response_body, 
-			http_version, status, mime_type, length
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, requestID, domain, port, path, query, req.URL.String(), method,
-		string(headersJSON), string(bodyBytes), string(respHeadersJSON), string(respBody),
+			http_version, status, mime_type, length, transfer_info, body_redacted, response_encoding
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, requestID, historyDomain, historyPort, historyPath, historyQuery, historyURL, historyMethod,
+		historyHeadersJSON, historyRequestBody, string(respHeadersJSON), historyResponseBody,
 		protocolVersion, resp.Status,
-		resp.Header.Get("Content-Type"), len(respBody))
+		resp.Header.Get("Content-Type"), len(respBody), transferInfo, historyRedacted, responseEncoding)
 	if err != nil {
 		return fmt.Errorf("failed to copy to requests: %v", err)
 	}
 
 	// Update the tab's request IDs array
-	var requestIDsJSON string
-	err = tx.QueryRow("SELECT request_ids_arr FROM resender_tabs WHERE id = ?", int(tabId)).Scan(&requestIDsJSON)
-	if err != nil {
-		return fmt.Errorf("failed to fetch tab request IDs: %v", err)
-	}
-
-	var requestIDs []int
-	if err := json.Unmarshal([]byte(requestIDsJSON), &requestIDs); err == nil {
-		requestIDs = append(requestIDs, newRequestId)
-		if newRequestIDsJSON, err := json.Marshal(requestIDs); err == nil {
-			_, err = tx.Exec("UPDATE resender_tabs SET request_ids_arr = ? WHERE id = ?", string(newRequestIDsJSON), int(tabId))
-			if err != nil {
-				return fmt.Errorf("failed to update tab request IDs: %v", err)
-			}
+	for _, id := range append(hopIDs, newRequestId) {
+		if err := appendTabRequest(tx, int(tabId), id); err != nil {
+			return err
 		}
 	}
 
@@ -642,11 +831,78 @@ func (r *Resender) SendRequest(tabId float64, requestDetails map[string]interfac
 		"status":          resp.Status,
 		"isRedirect":      resp.StatusCode >= 300 && resp.StatusCode < 400,
 		"redirectURL":     resp.Header.Get("Location"),
+		"transferInfo":    transferInfo,
+		"protocolMode":    protocolMode,
+		"negotiated":      negotiated,
+		"timings":         timings,
+
+		// Redirects followed on the way to the response
+		"redirectChain":        redirectChain(hops),
+		"redirectIds":          hopIDs,
+		"redirectLimitReached": redirectLimitReached,
 	})
 
 	return nil
 }
 
+// chunkReader hands out the body in fixed-size reads. It deliberately does not
+// implement io.WriterTo so the chunked writer emits one chunk per read.
+type chunkReader struct {
+	data      []byte
+	chunkSize int
+
+	mu     sync.Mutex
+	offset int64
+	sent   []storage.Chunk // chunks handed out, as written on the wire
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.chunkSize
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	copy(p, c.data[:n])
+	c.data = c.data[n:]
+	c.sent = append(c.sent, storage.Chunk{Offset: c.offset, Size: int64(n)})
+	c.offset += int64(n)
+	return n, nil
+}
+
+// chunks returns the chunks handed out so far
+func (c *chunkReader) chunks() []storage.Chunk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]storage.Chunk(nil), c.sent...)
+}
+
+// applyChunkedEncoding switches a request to Transfer-Encoding: chunked, using
+// chunkSize to control the chunk boundaries (0 sends the body as one chunk).
+// The returned reader records the chunks sent.
+func applyChunkedEncoding(req *http.Request, body []byte, chunkSize int) *chunkReader {
+	if chunkSize <= 0 {
+		chunkSize = len(body)
+	}
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+	reader := &chunkReader{data: body, chunkSize: chunkSize}
+	req.Header.Del("Content-Length")
+	req.Header.Del("Transfer-Encoding")
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	req.Body = io.NopCloser(reader)
+	req.GetBody = nil
+	return reader
+}
+
 // CancelRequest cancels an active request
 func (r *Resender) CancelRequest(tabID int) {
 	r.activeReqMutex.Lock()
@@ -672,6 +928,15 @@ func (r *Resender) UpdateTabName(tabID int, newName string) error {
 	return nil
 }
 
+// SetTabGroup moves a resender tab into a group, an empty group ungroups it
+func (r *Resender) SetTabGroup(tabID int, group string) error {
+	_, err := r.db.Exec("UPDATE resender_tabs SET group_name = ? WHERE id = ?", strings.TrimSpace(group), tabID)
+	if err != nil {
+		return fmt.Errorf("failed to update tab group: %v", err)
+	}
+	return nil
+}
+
 // DeleteTab deletes a resender tab
 func (r *Resender) DeleteTab(tabID int) error {
 	_, err := r.db.Exec("DELETE FROM resender_tabs WHERE id = ?", tabID)
@@ -692,13 +957,17 @@ func (r *Resender) GetRequest(requestID int) error {
 	log.Printf("Getting request with ID: %d", requestID)
 
 	var url, method string
-	var requestHeaders, requestBody, responseHeaders, responseBody, httpVersion, status string
+	var requestHeaders, requestBody, responseHeaders, responseBody, httpVersion, status, negotiated string
+	var rawRequest, rawResponse, storedTimings string
 	var portNull sql.NullString
 
 	err := r.db.QueryRow(`
-		SELECT url, method, request_headers, request_body, response_headers, response_body, http_version, status, port
+		SELECT url, method, request_headers, request_body, response_headers, response_body, http_version, status, port,
+			COALESCE(negotiated_protocol, ''), COALESCE(raw_request, ''), COALESCE(raw_response, ''),
+			COALESCE(timings, '')
 		FROM resender_requests WHERE id = ?
-	`, requestID).Scan(&url, &method, &requestHeaders, &requestBody, &responseHeaders, &responseBody, &httpVersion, &status, &portNull)
+	`, requestID).Scan(&url, &method, &requestHeaders, &requestBody, &responseHeaders, &responseBody, &httpVersion, &status, &portNull,
+		&negotiated, &rawRequest, &rawResponse, &storedTimings)
 	if err != nil {
 		return fmt.Errorf("failed to fetch request details: %v", err)
 	}
@@ -714,6 +983,16 @@ func (r *Resender) GetRequest(requestID int) error {
 		responseHeaders = "{}"
 	}
 
+	// Requests sent before timings were recorded have none
+	var timings *Timings
+	if storedTimings != "" {
+		timings = &Timings{}
+		if err := json.Unmarshal([]byte(storedTimings), timings); err != nil {
+			log.Printf("Warning: invalid timings for request %d: %v", requestID, err)
+			timings = nil
+		}
+	}
+
 	// Emit the request details
 	runtime.EventsEmit(r.ctx, "backend:resenderRequest", map[string]interface{}{
 		"id":              requestID,
@@ -726,6 +1005,10 @@ func (r *Resender) GetRequest(requestID int) error {
 		"httpVersion":     httpVersion,
 		"status":          status,
 		"port":            portNull.String,
+		"negotiated":      negotiated,
+		"rawRequest":      rawRequest, // set for requests sent in raw mode
+		"rawResponse":     rawResponse,
+		"timings":         timings,
 	})
 
 	return nil
@@ -0,0 +1,222 @@
+package resender
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings break down a send, in milliseconds. Phases that did not happen,
+// such as TLS on a reused connection, are 0. With redirects followed the
+// phases are those of the last request and Total covers them all.
+type Timings struct {
+	DNSMs     float64 `json:"dnsMs"`
+	ConnectMs float64 `json:"connectMs"`
+	TLSMs     float64 `json:"tlsMs"`
+	// WaitMs runs from the request written to the first response byte, the
+	// time the server took
+	WaitMs  float64 `json:"waitMs"`
+	TTFBMs  float64 `json:"ttfbMs"` // from the start of the send
+	TotalMs float64 `json:"totalMs"`
+	Reused  bool    `json:"reused"` // the connection was kept alive from an earlier send
+
+	RequestBytes  int64 `json:"requestBytes"`  // body sent
+	HeaderBytes   int64 `json:"headerBytes"`   // status line and headers received
+	ResponseBytes int64 `json:"responseBytes"` // body received, as sent on the wire
+	DecodedBytes  int64 `json:"decodedBytes"`  // body once its content encoding is undone
+}
+
+// milliseconds converts a duration, keeping a microsecond precision
+func milliseconds(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(d.Microseconds()) / 1000
+}
+
+// sendTimer collects the phases of a send through an httptrace.ClientTrace
+type sendTimer struct {
+	mu    sync.Mutex
+	start time.Time
+	end   time.Time // the response read, the time of timings when zero
+
+	getConn, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, gotConn, wroteRequest, firstByte   time.Time
+	reused                                                bool
+}
+
+func newSendTimer() *sendTimer {
+	return &sendTimer{start: time.Now()}
+}
+
+// finish marks the response as read, before anything else the send waits for
+func (t *sendTimer) finish() {
+	t.mark(&t.end)
+}
+
+// mark records the time of a phase
+func (t *sendTimer) mark(field *time.Time) {
+	t.mu.Lock()
+	*field = time.Now()
+	t.mu.Unlock()
+}
+
+// trace returns the hooks recording the phases. Each request of a redirect
+// chain starts the phases over.
+func (t *sendTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			t.getConn = time.Now()
+			t.dnsStart, t.dnsDone, t.connectStart, t.connectDone = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone, t.gotConn, t.wroteRequest, t.firstByte = time.Time{}, time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			t.reused = false
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.mark(&t.dnsDone) },
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			// Several addresses may be tried, the first attempt starts the phase
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone:       func(string, string, error) { t.mark(&t.connectDone) },
+		TLSHandshakeStart: func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.mark(&t.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.gotConn = time.Now()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.mark(&t.wroteRequest) },
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	}
+}
+
+// timings sums up the phases once the response is read
+func (t *sendTimer) timings(https bool) Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	end := t.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	timings := Timings{TotalMs: milliseconds(end.Sub(t.start)), Reused: t.reused}
+	if !t.dnsDone.IsZero() {
+		timings.DNSMs = milliseconds(t.dnsDone.Sub(t.dnsStart))
+	}
+	if !t.connectDone.IsZero() {
+		timings.ConnectMs = milliseconds(t.connectDone.Sub(t.connectStart))
+	}
+	switch {
+	case !t.tlsDone.IsZero():
+		timings.TLSMs = milliseconds(t.tlsDone.Sub(t.tlsStart))
+	case https && !t.reused && !t.connectDone.IsZero() && !t.gotConn.IsZero():
+		// Handshakes done by the upstream dialers report no trace events,
+		// they run from the connection up to the connection handed over
+		timings.TLSMs = milliseconds(t.gotConn.Sub(t.connectDone))
+	}
+	if !t.firstByte.IsZero() {
+		timings.TTFBMs = milliseconds(t.firstByte.Sub(t.start))
+		if !t.wroteRequest.IsZero() {
+			timings.WaitMs = milliseconds(t.firstByte.Sub(t.wroteRequest))
+		}
+	}
+	return timings
+}
+
+// headerSize returns how many bytes the status line and headers of a
+// response take on the wire, as HTTP/1.1
+func headerSize(resp *http.Response) int64 {
+	counter := &countingWriter{}
+	fmt.Fprintf(counter, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(counter)
+	counter.n += 2
+	return counter.n
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// timingsJSON serializes timings for storage
+func timingsJSON(timings Timings) string {
+	data, err := json.Marshal(timings)
+	if err != nil {
+		log.Printf("Failed to marshal timings: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+// TimingEntry is the timings of one send of a tab
+type TimingEntry struct {
+	RequestID int      `json:"requestId"`
+	Timestamp string   `json:"timestamp"`
+	Status    string   `json:"status"`
+	Timings   *Timings `json:"timings"`
+}
+
+// GetTabTimings returns the timings of the sends of a tab, oldest first, to
+// compare the latency of repeated sends
+func (r *Resender) GetTabTimings(tabID int) ([]TimingEntry, error) {
+	var requestIDsJSON string
+	if err := r.db.QueryRow("SELECT request_ids_arr FROM resender_tabs WHERE id = ?", tabID).Scan(&requestIDsJSON); err != nil {
+		return nil, fmt.Errorf("failed to fetch tab request IDs: %v", err)
+	}
+	var requestIDs []int
+	if err := json.Unmarshal([]byte(requestIDsJSON), &requestIDs); err != nil {
+		return nil, fmt.Errorf("failed to parse tab request IDs: %v", err)
+	}
+
+	entries := []TimingEntry{}
+	for _, id := range requestIDs {
+		var timestamp, status, stored string
+		err := r.db.QueryRow(`
+			SELECT COALESCE(timestamp, ''), COALESCE(status, ''), COALESCE(timings, '')
+			FROM resender_requests WHERE id = ?
+		`, id).Scan(&timestamp, &status, &stored)
+		if err != nil {
+			continue
+		}
+		// Requests sent before timings were recorded have none
+		if stored == "" {
+			continue
+		}
+		var timings Timings
+		if err := json.Unmarshal([]byte(stored), &timings); err != nil {
+			continue
+		}
+		entries = append(entries, TimingEntry{RequestID: id, Timestamp: timestamp, Status: status, Timings: &timings})
+	}
+	return entries, nil
+}
@@ -0,0 +1,136 @@
+package resender
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// variablePattern matches {{name}} placeholders, the syntax Postman uses
+var variablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.$\-]+)\s*\}\}`)
+
+// Variable is a value substituted for {{name}} in resender requests
+type Variable struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// ensureVariablesTable creates the variables table of projects created before
+// it existed
+func (r *Resender) ensureVariablesTable() error {
+	_, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS resender_variables (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			value TEXT DEFAULT '',
+			source TEXT DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create resender variables table: %v", err)
+	}
+	return nil
+}
+
+// GetVariables returns all variables sorted by name
+func (r *Resender) GetVariables() ([]Variable, error) {
+	rows, err := r.db.Query("SELECT name, COALESCE(value, ''), COALESCE(source, '') FROM resender_variables ORDER BY name ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch variables: %v", err)
+	}
+	defer rows.Close()
+
+	variables := []Variable{}
+	for rows.Next() {
+		var variable Variable
+		if err := rows.Scan(&variable.Name, &variable.Value, &variable.Source); err != nil {
+			return nil, fmt.Errorf("failed to scan variable: %v", err)
+		}
+		variables = append(variables, variable)
+	}
+	return variables, rows.Err()
+}
+
+// SetVariable creates or replaces a variable
+func (r *Resender) SetVariable(name, value, source string) error {
+	name = strings.TrimSpace(name)
+	if !variablePattern.MatchString("{{" + name + "}}") {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO resender_variables (name, value, source) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET value = excluded.value, source = excluded.source
+	`, name, value, source)
+	if err != nil {
+		return fmt.Errorf("failed to save variable: %v", err)
+	}
+	return nil
+}
+
+// DeleteVariable removes a variable
+func (r *Resender) DeleteVariable(name string) error {
+	if _, err := r.db.Exec("DELETE FROM resender_variables WHERE name = ?", name); err != nil {
+		return fmt.Errorf("failed to delete variable: %v", err)
+	}
+	return nil
+}
+
+// variableValues returns the variables as a map for expansion
+func (r *Resender) variableValues() map[string]string {
+	values := make(map[string]string)
+	variables, err := r.GetVariables()
+	if err != nil {
+		return values
+	}
+	for _, variable := range variables {
+		values[variable.Name] = variable.Value
+	}
+	return values
+}
+
+// Expand replaces the {{name}} placeholders of text with the current
+// variables, for tools sending resender requests themselves
+func (r *Resender) Expand(text string) string {
+	return expandVariables(text, r.variableValues())
+}
+
+// expandVariables replaces {{name}} placeholders with their values. The
+// dynamic $guid, $timestamp and $randomInt variables of Postman are
+// generated, unknown names are left untouched.
+func expandVariables(text string, values map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	return variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		switch name {
+		case "$guid", "$randomUUID":
+			return uuid.New().String()
+		case "$timestamp":
+			return strconv.FormatInt(time.Now().Unix(), 10)
+		case "$randomInt":
+			return strconv.Itoa(rand.Intn(1001))
+		}
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// referencedVariables returns the names of the variables used in text
+func referencedVariables(text string) []string {
+	var names []string
+	for _, match := range variablePattern.FindAllStringSubmatch(text, -1) {
+		if !strings.HasPrefix(match[1], "$") {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AutoForwardRule forwards matching requests without holding them for
+// approval. Session rules live in memory only and have negative IDs.
+type AutoForwardRule struct {
+	ID         int    `json:"id"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`   // exact path, a trailing * matches a prefix, empty matches any path
+	Method     string `json:"method"` // empty matches any method
+	Persistent bool   `json:"persistent"`
+}
+
+// Matches reports whether a request is covered by the rule
+func (r AutoForwardRule) Matches(req *http.Request) bool {
+	host := req.URL.Hostname()
+	if host == "" {
+		host = strings.Split(req.Host, ":")[0]
+	}
+	if !strings.EqualFold(host, r.Host) {
+		return false
+	}
+	if r.Method != "" && !strings.EqualFold(req.Method, r.Method) {
+		return false
+	}
+	switch {
+	case r.Path == "":
+		return true
+	case strings.HasSuffix(r.Path, "*"):
+		return strings.HasPrefix(req.URL.Path, strings.TrimSuffix(r.Path, "*"))
+	default:
+		return req.URL.Path == r.Path
+	}
+}
+
+// initializeAutoForwardTable creates the table of persistent auto-forward rules
+func (c *Client) initializeAutoForwardTable() error {
+	_, err := c.db.Exec(`
+		CREATE TABLE IF NOT EXISTS auto_forward_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			host TEXT NOT NULL,
+			path TEXT DEFAULT '',
+			method TEXT DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create auto_forward_rules table: %v", err)
+	}
+	return nil
+}
+
+// loadAutoForwardRules loads the persistent auto-forward rules
+func (c *Client) loadAutoForwardRules() error {
+	rows, err := c.db.Query("SELECT id, host, COALESCE(path, ''), COALESCE(method, '') FROM auto_forward_rules")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []AutoForwardRule
+	for rows.Next() {
+		rule := AutoForwardRule{Persistent: true}
+		if err := rows.Scan(&rule.ID, &rule.Host, &rule.Path, &rule.Method); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+
+	c.autoForwardMu.Lock()
+	c.autoForward = rules
+	c.autoForwardMu.Unlock()
+	return rows.Err()
+}
+
+// AddAutoForwardRule adds an auto-forward rule, stored in the project when
+// persistent and kept until the application exits otherwise
+func (c *Client) AddAutoForwardRule(rule AutoForwardRule) (AutoForwardRule, error) {
+	rule.Host = strings.TrimSpace(rule.Host)
+	if rule.Host == "" {
+		return rule, &RuleValidationError{Field: "host", Message: "cannot be empty"}
+	}
+	rule.Method = strings.ToUpper(strings.TrimSpace(rule.Method))
+
+	c.autoForwardMu.Lock()
+	defer c.autoForwardMu.Unlock()
+
+	for _, existing := range c.autoForward {
+		if strings.EqualFold(existing.Host, rule.Host) && existing.Path == rule.Path && existing.Method == rule.Method && existing.Persistent == rule.Persistent {
+			return existing, nil
+		}
+	}
+
+	if rule.Persistent {
+		result, err := c.db.Exec("INSERT INTO auto_forward_rules (host, path, method) VALUES (?, ?, ?)", rule.Host, rule.Path, rule.Method)
+		if err != nil {
+			return rule, fmt.Errorf("failed to store auto-forward rule: %v", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return rule, err
+		}
+		rule.ID = int(id)
+	} else {
+		c.nextSessionID--
+		rule.ID = c.nextSessionID
+	}
+
+	c.autoForward = append(c.autoForward, rule)
+	return rule, nil
+}
+
+// DeleteAutoForwardRule removes an auto-forward rule
+func (c *Client) DeleteAutoForwardRule(id int) error {
+	if id > 0 {
+		if _, err := c.db.Exec("DELETE FROM auto_forward_rules WHERE id = ?", id); err != nil {
+			return fmt.Errorf("failed to delete auto-forward rule: %v", err)
+		}
+	}
+
+	c.autoForwardMu.Lock()
+	defer c.autoForwardMu.Unlock()
+	for i, rule := range c.autoForward {
+		if rule.ID == id {
+			c.autoForward = append(c.autoForward[:i], c.autoForward[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetAutoForwardRules returns the persistent and session auto-forward rules
+func (c *Client) GetAutoForwardRules() []AutoForwardRule {
+	c.autoForwardMu.RLock()
+	defer c.autoForwardMu.RUnlock()
+	return append([]AutoForwardRule{}, c.autoForward...)
+}
+
+// MatchAutoForward returns the first auto-forward rule covering a request
+func (c *Client) MatchAutoForward(req *http.Request) (AutoForwardRule, bool) {
+	c.autoForwardMu.RLock()
+	defer c.autoForwardMu.RUnlock()
+	for _, rule := range c.autoForward {
+		if rule.Matches(req) {
+			return rule, true
+		}
+	}
+	return AutoForwardRule{}, false
+}
@@ -27,6 +27,10 @@ type Client struct {
 	db         *sql.DB
 	rules      []Rule
 	regexCache *regexCache
+
+	autoForward   []AutoForwardRule
+	autoForwardMu sync.RWMutex
+	nextSessionID int
 }
 
 // RuleValidationError represents a validation error
@@ -138,6 +142,14 @@ func NewClient(db *sql.DB) (*Client, error) {
 		return nil, fmt.Errorf("failed to load rules: %v", err)
 	}
 
+	if err := client.initializeAutoForwardTable(); err != nil {
+		return nil, fmt.Errorf("failed to initialize auto-forward rules: %v", err)
+	}
+
+	if err := client.loadAutoForwardRules(); err != nil {
+		return nil, fmt.Errorf("failed to load auto-forward rules: %v", err)
+	}
+
 	return client, nil
 }
 
@@ -166,6 +178,12 @@ func (c *Client) initializeRulesTable() error {
 func (c *Client) RuleEvaluation(req *http.Request) bool {
 	//log.Printf("Evaluating request: %s %s", req.Method, req.URL.String())
 
+	// Auto-forward rules take precedence over the interception rules
+	if rule, ok := c.MatchAutoForward(req); ok {
+		log.Printf("Request URL %s auto-forwarded by rule %d (%s %s %s)", req.URL.String(), rule.ID, rule.Method, rule.Host, rule.Path)
+		return false
+	}
+
 	// Group rules by operator
 	andRules := []Rule{}
 	orRules := []Rule{}
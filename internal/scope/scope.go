@@ -5,20 +5,35 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
+	"sync"
+
+	hostaddr "prokzee/internal/hostaddr"
 )
 
+// SamplingPolicy stores 1-in-N requests for hosts matching an in-scope pattern
+type SamplingPolicy struct {
+	Pattern    string `json:"pattern"`
+	SampleRate int    `json:"sample_rate"`
+}
+
 // Client handles the scope-related functionality
 type Client struct {
-	db           *sql.DB
-	inScopeList  []string
-	outScopeList []string
+	db               *sql.DB
+	inScopeList      []string
+	outScopeList     []string
+	samplingPolicies map[string]int
+	sampleCounters   map[string]int
+	samplingMtx      sync.Mutex
 }
 
 // NewClient creates a new scope client
 func NewClient(db *sql.DB) (*Client, error) {
 	log.Printf("Creating new scope client")
 	client := &Client{
-		db: db,
+		db:               db,
+		samplingPolicies: make(map[string]int),
+		sampleCounters:   make(map[string]int),
 	}
 
 	// Ensure the scope_lists table exists
@@ -35,6 +50,12 @@ func NewClient(db *sql.DB) (*Client, error) {
 	}
 	log.Printf("Successfully loaded scope lists - in-scope: %v, out-of-scope: %v", client.inScopeList, client.outScopeList)
 
+	// Load sampling policies from database
+	if err := client.loadSamplingPoliciesFromDB(); err != nil {
+		log.Printf("Error loading sampling policies: %v", err)
+		return nil, fmt.Errorf("failed to load sampling policies: %v", err)
+	}
+
 	// Add validation check
 	if len(client.inScopeList) == 0 && len(client.outScopeList) == 0 {
 		log.Printf("WARNING: Both scope lists are empty after initialization")
@@ -61,6 +82,29 @@ func (c *Client) GetOutScopeList() []string {
 	return c.outScopeList
 }
 
+// hostToken matches the domain names written in a scope pattern
+var hostToken = regexp.MustCompile(`(?i)(?:[a-z0-9-]+\.)+[a-z]{2,}`)
+
+// InScopeHosts returns the domain names written in the in-scope patterns,
+// with their escaping removed, for comparing other hosts against
+func (c *Client) InScopeHosts() []string {
+	if c == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, pattern := range c.inScopeList {
+		for _, host := range hostToken.FindAllString(strings.ReplaceAll(pattern, `\`, ""), -1) {
+			host = strings.ToLower(host)
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
 // UpdateInScopeList updates the in-scope list and saves it to the database
 func (c *Client) UpdateInScopeList(newList []string) error {
 	log.Printf("Updating in-scope list with %d items: %v", len(newList), newList)
@@ -111,12 +155,13 @@ func (c *Client) IsInScope(host string) bool {
 	}
 
 	log.Printf("IsInScope checking host: %s", host)
+	forms := hostaddr.Forms(host)
 	log.Printf("Current scope state - in-scope list: %v (length: %d), out-of-scope list: %v (length: %d)",
 		c.inScopeList, len(c.inScopeList), c.outScopeList, len(c.outScopeList))
 
 	// First check if URL matches any out-of-scope pattern (these take precedence)
 	for _, pattern := range c.outScopeList {
-		matched, err := regexp.MatchString(pattern, host)
+		matched, err := matchesAny(pattern, forms)
 		if err != nil {
 			log.Printf("Error matching out-of-scope pattern '%s': %v", pattern, err)
 			continue
@@ -131,7 +176,7 @@ func (c *Client) IsInScope(host string) bool {
 	if len(c.inScopeList) > 0 {
 		for _, pattern := range c.inScopeList {
 			log.Printf("Trying to match host '%s' against in-scope pattern '%s'", host, pattern)
-			matched, err := regexp.MatchString(pattern, host)
+			matched, err := matchesAny(pattern, forms)
 			if err != nil {
 				log.Printf("Error matching in-scope pattern '%s': %v", pattern, err)
 				continue
@@ -151,6 +196,126 @@ func (c *Client) IsInScope(host string) bool {
 	return true
 }
 
+// matchesAny reports whether a pattern matches any of the forms of a host, so
+// IPv6 literals match with or without brackets and port
+func matchesAny(pattern string, forms []string) (bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, form := range forms {
+		if re.MatchString(form) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetSamplingPolicies returns the sampling policies configured for in-scope patterns
+func (c *Client) GetSamplingPolicies() []SamplingPolicy {
+	c.samplingMtx.Lock()
+	defer c.samplingMtx.Unlock()
+
+	policies := []SamplingPolicy{}
+	for _, pattern := range c.inScopeList {
+		if rate, ok := c.samplingPolicies[pattern]; ok {
+			policies = append(policies, SamplingPolicy{Pattern: pattern, SampleRate: rate})
+		}
+	}
+	return policies
+}
+
+// SetSamplingPolicy sets the sample rate for an in-scope pattern. A rate of 1 or
+// less removes the policy so every request is stored again.
+func (c *Client) SetSamplingPolicy(pattern string, rate int) error {
+	c.samplingMtx.Lock()
+	defer c.samplingMtx.Unlock()
+
+	if rate <= 1 {
+		if _, err := c.db.Exec("DELETE FROM scope_sampling WHERE pattern = ?", pattern); err != nil {
+			return fmt.Errorf("failed to delete sampling policy: %v", err)
+		}
+		delete(c.samplingPolicies, pattern)
+		delete(c.sampleCounters, pattern)
+		return nil
+	}
+
+	_, err := c.db.Exec(`
+		INSERT INTO scope_sampling (pattern, sample_rate) VALUES (?, ?)
+		ON CONFLICT(pattern) DO UPDATE SET sample_rate = excluded.sample_rate
+	`, pattern, rate)
+	if err != nil {
+		return fmt.Errorf("failed to save sampling policy: %v", err)
+	}
+	c.samplingPolicies[pattern] = rate
+	c.sampleCounters[pattern] = 0
+	return nil
+}
+
+// ShouldStore decides whether a request for the given host should be stored
+// according to the sampling policies. Error responses (4xx/5xx, or no status at
+// all) are always stored so failures are never sampled away.
+func (c *Client) ShouldStore(host string, statusCode int) bool {
+	if c == nil {
+		return true
+	}
+	if statusCode == 0 || statusCode >= 400 {
+		return true
+	}
+
+	c.samplingMtx.Lock()
+	defer c.samplingMtx.Unlock()
+
+	if len(c.samplingPolicies) == 0 {
+		return true
+	}
+
+	for _, pattern := range c.inScopeList {
+		rate, ok := c.samplingPolicies[pattern]
+		if !ok {
+			continue
+		}
+		matched, err := matchesAny(pattern, hostaddr.Forms(host))
+		if err != nil || !matched {
+			continue
+		}
+		// Store the first request of every window of N
+		store := c.sampleCounters[pattern]%rate == 0
+		c.sampleCounters[pattern]++
+		return store
+	}
+
+	return true
+}
+
+// loadSamplingPoliciesFromDB loads the sampling policies from the database
+func (c *Client) loadSamplingPoliciesFromDB() error {
+	rows, err := c.db.Query("SELECT pattern, sample_rate FROM scope_sampling")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	policies := make(map[string]int)
+	for rows.Next() {
+		var pattern string
+		var rate int
+		if err := rows.Scan(&pattern, &rate); err != nil {
+			return err
+		}
+		policies[pattern] = rate
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.samplingMtx.Lock()
+	c.samplingPolicies = policies
+	c.sampleCounters = make(map[string]int)
+	c.samplingMtx.Unlock()
+	return nil
+}
+
 // loadScopeListsFromDB loads the scope lists from the database
 func (c *Client) loadScopeListsFromDB() error {
 	rows, err := c.db.Query("SELECT type, pattern FROM scope_lists")
@@ -228,6 +393,16 @@ func (c *Client) ensureTableExists() error {
 		log.Printf("Error creating scope_lists table: %v", err)
 		return fmt.Errorf("failed to create scope_lists table: %v", err)
 	}
+
+	_, err = c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS scope_sampling (
+		pattern TEXT PRIMARY KEY,
+		sample_rate INTEGER NOT NULL DEFAULT 1
+	)`)
+	if err != nil {
+		log.Printf("Error creating scope_sampling table: %v", err)
+		return fmt.Errorf("failed to create scope_sampling table: %v", err)
+	}
 	log.Printf("Successfully created/verified scope_lists table")
 	return nil
 }
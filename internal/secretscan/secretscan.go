@@ -0,0 +1,258 @@
+package secretscan
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+
+	findings "prokzee/internal/findings"
+)
+
+// Source identifies findings created by the secret scanner
+const Source = "secret-scan"
+
+// MinEntropy is the Shannon entropy in bits per character above which a
+// generic token is reported
+const MinEntropy = 4.5
+
+// contextSize is how many characters around a secret are kept as context
+const contextSize = 40
+
+// maxBodyScan bounds how much of a body is scanned
+const maxBodyScan = 2 << 20
+
+// Pattern recognizes a well-known credential format
+type Pattern struct {
+	Name     string
+	Regex    *regexp.Regexp
+	Severity string
+}
+
+// Patterns are credential formats reported regardless of their entropy
+var Patterns = []Pattern{
+	{Name: "AWS access key ID", Regex: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), Severity: findings.SeverityHigh},
+	{Name: "GitHub token", Regex: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), Severity: findings.SeverityHigh},
+	{Name: "Slack token", Regex: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`), Severity: findings.SeverityHigh},
+	{Name: "Google API key", Regex: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`), Severity: findings.SeverityMedium},
+	{Name: "Stripe secret key", Regex: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{20,}\b`), Severity: findings.SeverityHigh},
+	{Name: "Private key", Regex: regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP )?PRIVATE KEY-----`), Severity: findings.SeverityHigh},
+	{Name: "JSON Web Token", Regex: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{10,}\.eyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,}\b`), Severity: findings.SeverityLow},
+}
+
+// candidatePattern finds tokens worth measuring
+var candidatePattern = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{20,}`)
+
+// hexPattern matches tokens made of hex digits only, which are mostly hashes and IDs
+var hexPattern = regexp.MustCompile(`^[0-9a-fA-F\-]+$`)
+
+// Secret is a suspicious string found in a response
+type Secret struct {
+	Kind      string  `json:"kind"`
+	Value     string  `json:"value"`
+	Entropy   float64 `json:"entropy"`
+	Context   string  `json:"context"`
+	Host      string  `json:"host"`
+	URL       string  `json:"url"`
+	RequestID int     `json:"requestId"`
+	Severity  string  `json:"severity"`
+}
+
+// Scanner looks for secrets in stored text responses
+type Scanner struct {
+	db       *sql.DB
+	findings *findings.Client
+}
+
+// NewScanner creates a new secret scanner
+func NewScanner(db *sql.DB, findingsClient *findings.Client) *Scanner {
+	return &Scanner{db: db, findings: findingsClient}
+}
+
+// Run scans the text responses of the hosts that pass the filter. Every secret
+// is reported once per project, at the first response it appeared in.
+func (s *Scanner) Run(filter func(host string) bool) ([]Secret, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(domain, ''), COALESCE(url, ''), COALESCE(mime_type, ''), COALESCE(response_body, '')
+		FROM requests
+		WHERE response_body IS NOT NULL AND response_body != ''
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query responses: %v", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	allowed := make(map[string]bool)
+	secrets := []Secret{}
+	for rows.Next() {
+		var id int
+		var host, rawURL, mimeType, body string
+		if err := rows.Scan(&id, &host, &rawURL, &mimeType, &body); err != nil {
+			return nil, fmt.Errorf("failed to scan response: %v", err)
+		}
+
+		if _, ok := allowed[host]; !ok {
+			allowed[host] = filter == nil || filter(host)
+		}
+		if !allowed[host] || !isText(mimeType) {
+			continue
+		}
+
+		for _, secret := range Scan(body) {
+			if seen[secret.Value] {
+				continue
+			}
+			seen[secret.Value] = true
+
+			secret.Host = host
+			secret.URL = rawURL
+			secret.RequestID = id
+			secrets = append(secrets, secret)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read responses: %v", err)
+	}
+
+	for _, secret := range secrets {
+		sum := sha256.Sum256([]byte(secret.Value))
+		err := s.findings.AddFinding(findings.Finding{
+			Source:    Source,
+			Host:      secret.Host,
+			URL:       secret.URL,
+			RequestID: secret.RequestID,
+			Title:     fmt.Sprintf("Possible secret: %s", secret.Kind),
+			Severity:  secret.Severity,
+			Detail: fmt.Sprintf("%s (entropy %.2f bits/char)\n%s", mask(secret.Value), secret.Entropy,
+				strings.ReplaceAll(secret.Context, secret.Value, mask(secret.Value))),
+			Key: hex.EncodeToString(sum[:]),
+		})
+		if err != nil {
+			log.Printf("Failed to record secret finding: %v", err)
+		}
+	}
+
+	return secrets, nil
+}
+
+// Scan returns the secrets in a body, known credential formats first
+func Scan(body string) []Secret {
+	if len(body) > maxBodyScan {
+		body = body[:maxBodyScan]
+	}
+
+	secrets := []Secret{}
+	matched := make(map[string]bool)
+
+	for _, pattern := range Patterns {
+		for _, loc := range pattern.Regex.FindAllStringIndex(body, -1) {
+			value := body[loc[0]:loc[1]]
+			if matched[value] {
+				continue
+			}
+			matched[value] = true
+			secrets = append(secrets, Secret{
+				Kind:     pattern.Name,
+				Value:    value,
+				Entropy:  Entropy(value),
+				Context:  context(body, loc[0], loc[1]),
+				Severity: pattern.Severity,
+			})
+		}
+	}
+
+	for _, loc := range candidatePattern.FindAllStringIndex(body, -1) {
+		value := body[loc[0]:loc[1]]
+		if matched[value] || covered(matched, value) || hexPattern.MatchString(value) {
+			continue
+		}
+		// Inline images and fonts are long, random-looking and harmless
+		if loc[0] >= 7 && body[loc[0]-7:loc[0]] == "base64," {
+			continue
+		}
+		entropy := Entropy(value)
+		if entropy < MinEntropy {
+			continue
+		}
+		matched[value] = true
+		secrets = append(secrets, Secret{
+			Kind:     "High-entropy string",
+			Value:    value,
+			Entropy:  entropy,
+			Context:  context(body, loc[0], loc[1]),
+			Severity: findings.SeverityLow,
+		})
+	}
+
+	return secrets
+}
+
+// Entropy returns the Shannon entropy of s in bits per character
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// covered reports whether the candidate is part of an already matched secret
+func covered(matched map[string]bool, candidate string) bool {
+	for value := range matched {
+		if strings.Contains(value, candidate) || strings.Contains(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// context returns the text around a match on a single line
+func context(body string, start, end int) string {
+	from := start - contextSize
+	if from < 0 {
+		from = 0
+	}
+	to := end + contextSize
+	if to > len(body) {
+		to = len(body)
+	}
+	return strings.Join(strings.Fields(body[from:to]), " ")
+}
+
+// mask hides the middle of a secret so findings don't spread it further
+func mask(value string) string {
+	if len(value) <= 12 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:6] + strings.Repeat("*", len(value)-10) + value[len(value)-4:]
+}
+
+// isText reports whether a MIME type carries text worth scanning
+func isText(mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	if mimeType == "" {
+		return true
+	}
+	for _, text := range []string{"text/", "json", "javascript", "xml", "x-www-form-urlencoded"} {
+		if strings.Contains(mimeType, text) {
+			return true
+		}
+	}
+	return false
+}
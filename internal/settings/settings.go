@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sync"
 	"time"
+
+	"prokzee/internal/storage"
 )
 
 // Settings represents the application settings
@@ -17,6 +20,104 @@ type Settings struct {
 	InteractshHost string `json:"interactsh_host"`
 	InteractshPort int    `json:"interactsh_port"`
 	CreatedAt      string `json:"created_at"`
+
+	// UpstreamProxy is the HTTP or SOCKS5 proxy all outbound traffic goes
+	// through, empty to connect directly
+	UpstreamProxy string `json:"upstream_proxy"`
+	// UpstreamProxyBypass lists the hosts, wildcards and CIDR ranges that
+	// skip the upstream proxy, separated by commas or new lines
+	UpstreamProxyBypass string `json:"upstream_proxy_bypass"`
+
+	// HostOverrides maps host names to IP addresses, one "host ip" pair per
+	// line like a hosts file, for the proxy, Resender and Fuzzer
+	HostOverrides string `json:"host_overrides"`
+	// DNSServer is the DNS server resolving the other hosts, empty for the
+	// system resolver
+	DNSServer string `json:"dns_server"`
+
+	// TLSPassthrough lists regular expressions, one per line, matching the
+	// hosts whose TLS connections are tunnelled without interception
+	TLSPassthrough string `json:"tls_passthrough"`
+
+	// MaxStoredBodySize is how many bytes of a body are kept in the history,
+	// larger bodies are written whole to a blob. 0 uses the default.
+	MaxStoredBodySize int64 `json:"max_stored_body_size"`
+
+	// ForceConnectionClose sends "Connection: close" upstream on every
+	// HTTP/1.x request instead of reusing connections, for debugging
+	ForceConnectionClose bool `json:"force_connection_close"`
+
+	// ProjectCA signs the intercepted hosts of the project with a CA of its
+	// own, stored next to the project, instead of the shared one
+	ProjectCA bool `json:"project_ca"`
+
+	// TLSProfile shapes the ClientHello of upstream connections, a preset
+	// name or a profile as JSON, empty for the Go defaults
+	TLSProfile string `json:"tls_profile"`
+
+	// CorrelationHeader names the header the proxy adds upstream with a fresh
+	// ID per request, such as X-ProKZee-ID, empty for none
+	CorrelationHeader string `json:"correlation_header"`
+
+	// BindAddress is the address the proxy listens on, such as 127.0.0.1,
+	// empty for every interface
+	BindAddress string `json:"bind_address"`
+
+	// MaxRequestBodySize and MaxResponseBodySize are the largest bodies the
+	// proxy lets through in bytes, larger ones are cut off with an error
+	// page. 0 for no limit.
+	MaxRequestBodySize  int64 `json:"max_request_body_size"`
+	MaxResponseBodySize int64 `json:"max_response_body_size"`
+}
+
+// Seed holds the values new projects start with instead of the built-in
+// defaults, taken from the config file or the environment. Empty fields keep
+// the built-in value.
+type Seed struct {
+	ProxyPort      string
+	OpenAIAPIURL   string
+	OpenAIAPIKey   string
+	InteractshHost string
+	InteractshPort int
+	UpstreamProxy  string
+	UpstreamBypass string
+}
+
+var (
+	seed   Seed
+	seedMu sync.RWMutex
+)
+
+// SetSeed sets the values new projects start with
+func SetSeed(s Seed) {
+	seedMu.Lock()
+	seed = s
+	seedMu.Unlock()
+}
+
+// ApplySeed overrides the built-in defaults of a new project with the seed
+func ApplySeed(settings *Settings) {
+	seedMu.RLock()
+	defer seedMu.RUnlock()
+	if seed.ProxyPort != "" {
+		settings.ProxyPort = seed.ProxyPort
+	}
+	if seed.OpenAIAPIURL != "" {
+		settings.OpenAIAPIURL = seed.OpenAIAPIURL
+	}
+	if seed.OpenAIAPIKey != "" {
+		settings.OpenAIAPIKey = seed.OpenAIAPIKey
+	}
+	if seed.InteractshHost != "" {
+		settings.InteractshHost = seed.InteractshHost
+	}
+	if seed.InteractshPort != 0 {
+		settings.InteractshPort = seed.InteractshPort
+	}
+	if seed.UpstreamProxy != "" {
+		settings.UpstreamProxy = seed.UpstreamProxy
+		settings.UpstreamProxyBypass = seed.UpstreamBypass
+	}
 }
 
 // Client represents the settings client
@@ -51,7 +152,20 @@ func (c *Client) ensureTableExists() error {
 		theme varchar,
 		interactsh_host varchar,
 		interactsh_port int,
-		created_at DATETIME
+		created_at DATETIME,
+		upstream_proxy varchar DEFAULT '',
+		upstream_proxy_bypass varchar DEFAULT '',
+		tls_passthrough varchar DEFAULT '',
+		host_overrides varchar DEFAULT '',
+		dns_server varchar DEFAULT '',
+		max_stored_body_size INTEGER DEFAULT 0,
+		force_connection_close INTEGER DEFAULT 0,
+		project_ca INTEGER DEFAULT 0,
+		tls_profile varchar DEFAULT '',
+		correlation_header varchar DEFAULT '',
+		bind_address varchar DEFAULT '',
+		max_request_body_size INTEGER DEFAULT 0,
+		max_response_body_size INTEGER DEFAULT 0
 	)`
 
 	_, err := c.db.Exec(query)
@@ -60,6 +174,17 @@ func (c *Client) ensureTableExists() error {
 		return fmt.Errorf("failed to create settings table: %v", err)
 	}
 
+	for _, column := range []string{"upstream_proxy", "upstream_proxy_bypass", "tls_passthrough", "host_overrides", "dns_server", "tls_profile", "correlation_header", "bind_address"} {
+		if err := storage.EnsureColumn(c.db, "settings", column, "varchar DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"max_stored_body_size", "force_connection_close", "project_ca", "max_request_body_size", "max_response_body_size"} {
+		if err := storage.EnsureColumn(c.db, "settings", column, "INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+
 	// Check if we need to add default settings
 	var count int
 	err = c.db.QueryRow("SELECT COUNT(*) FROM settings").Scan(&count)
@@ -72,18 +197,28 @@ func (c *Client) ensureTableExists() error {
 	if count == 0 {
 		log.Printf("No settings found, adding default settings...")
 
-		// Default port that doesn't require admin rights (>1024)
-		defaultPort := "8080"
+		defaults := &Settings{
+			ProjectName:    "Default Project",
+			OpenAIAPIURL:   "https://api.openai.com/v1/chat/completions",
+			ProxyPort:      "8080", // Default port that doesn't require admin rights (>1024)
+			InteractshHost: "oast.pro",
+			InteractshPort: 1337,
+		}
+		ApplySeed(defaults)
 
 		_, err = c.db.Exec(`
 			INSERT INTO settings (
 				id, project_name, openai_api_url, openai_api_key, proxy_port, 
-				theme, interactsh_host, interactsh_port, created_at
+				theme, interactsh_host, interactsh_port, created_at,
+				upstream_proxy, upstream_proxy_bypass
 			) VALUES (
-				1, 'Default Project', 'https://api.openai.com/v1/chat/completions', '', ?,
-				'dark', 'oast.pro', 1337, ?
+				1, ?, ?, ?, ?,
+				'dark', ?, ?, ?,
+				?, ?
 			)
-		`, defaultPort, time.Now().Format(time.RFC3339))
+		`, defaults.ProjectName, defaults.OpenAIAPIURL, defaults.OpenAIAPIKey, defaults.ProxyPort,
+			defaults.InteractshHost, defaults.InteractshPort, time.Now().Format(time.RFC3339),
+			defaults.UpstreamProxy, defaults.UpstreamProxyBypass)
 
 		if err != nil {
 			log.Printf("Error inserting default settings: %v", err)
@@ -98,7 +233,7 @@ func (c *Client) ensureTableExists() error {
 
 // LoadSettings loads settings from the database
 func (c *Client) LoadSettings() (*Settings, error) {
-	row := c.db.QueryRow("SELECT id, project_name, openai_api_url, openai_api_key, proxy_port, interactsh_host, interactsh_port, created_at FROM settings LIMIT 1")
+	row := c.db.QueryRow("SELECT id, project_name, openai_api_url, openai_api_key, proxy_port, interactsh_host, interactsh_port, created_at, COALESCE(upstream_proxy, ''), COALESCE(upstream_proxy_bypass, ''), COALESCE(tls_passthrough, ''), COALESCE(host_overrides, ''), COALESCE(dns_server, ''), COALESCE(max_stored_body_size, 0), COALESCE(force_connection_close, 0), COALESCE(project_ca, 0), COALESCE(tls_profile, ''), COALESCE(correlation_header, ''), COALESCE(bind_address, ''), COALESCE(max_request_body_size, 0), COALESCE(max_response_body_size, 0) FROM settings LIMIT 1")
 	var settings Settings
 	err := row.Scan(
 		&settings.ID,
@@ -109,6 +244,19 @@ func (c *Client) LoadSettings() (*Settings, error) {
 		&settings.InteractshHost,
 		&settings.InteractshPort,
 		&settings.CreatedAt,
+		&settings.UpstreamProxy,
+		&settings.UpstreamProxyBypass,
+		&settings.TLSPassthrough,
+		&settings.HostOverrides,
+		&settings.DNSServer,
+		&settings.MaxStoredBodySize,
+		&settings.ForceConnectionClose,
+		&settings.ProjectCA,
+		&settings.TLSProfile,
+		&settings.CorrelationHeader,
+		&settings.BindAddress,
+		&settings.MaxRequestBodySize,
+		&settings.MaxResponseBodySize,
 	)
 	if err != nil {
 		return nil, err
@@ -120,9 +268,9 @@ func (c *Client) LoadSettings() (*Settings, error) {
 func (c *Client) UpdateSettings(settings *Settings) error {
 	_, err := c.db.Exec(`
 		UPDATE settings
-		SET project_name = ?, openai_api_url = ?, openai_api_key = ?, proxy_port = ?, interactsh_host = ?, interactsh_port = ?, created_at = ?
+		SET project_name = ?, openai_api_url = ?, openai_api_key = ?, proxy_port = ?, interactsh_host = ?, interactsh_port = ?, created_at = ?, upstream_proxy = ?, upstream_proxy_bypass = ?, tls_passthrough = ?, host_overrides = ?, dns_server = ?, max_stored_body_size = ?, force_connection_close = ?, project_ca = ?, tls_profile = ?, correlation_header = ?, bind_address = ?, max_request_body_size = ?, max_response_body_size = ?
 		WHERE id = ?
-	`, settings.ProjectName, settings.OpenAIAPIURL, settings.OpenAIAPIKey, settings.ProxyPort, settings.InteractshHost, settings.InteractshPort, settings.CreatedAt, settings.ID)
+	`, settings.ProjectName, settings.OpenAIAPIURL, settings.OpenAIAPIKey, settings.ProxyPort, settings.InteractshHost, settings.InteractshPort, settings.CreatedAt, settings.UpstreamProxy, settings.UpstreamProxyBypass, settings.TLSPassthrough, settings.HostOverrides, settings.DNSServer, settings.MaxStoredBodySize, settings.ForceConnectionClose, settings.ProjectCA, settings.TLSProfile, settings.CorrelationHeader, settings.BindAddress, settings.MaxRequestBodySize, settings.MaxResponseBodySize, settings.ID)
 
 	if err != nil {
 		log.Printf("Failed to update settings: %v", err)
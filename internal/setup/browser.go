@@ -0,0 +1,168 @@
+package setup
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Browser kinds, which decide how the proxy is configured
+const (
+	KindChromium = "chromium"
+	KindFirefox  = "firefox"
+)
+
+// startURL is opened in a configured browser, the proxy serves the CA
+// download page on it
+const startURL = "http://prokzee/"
+
+// Browser is an installed browser that can be launched through the proxy
+type Browser struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// candidate is a browser and where it is usually installed on one OS
+type candidate struct {
+	name, kind string
+	paths      []string
+}
+
+func candidates() []candidate {
+	switch runtime.GOOS {
+	case "darwin":
+		return []candidate{
+			{"Google Chrome", KindChromium, []string{"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome"}},
+			{"Chromium", KindChromium, []string{"/Applications/Chromium.app/Contents/MacOS/Chromium"}},
+			{"Microsoft Edge", KindChromium, []string{"/Applications/Microsoft Edge.app/Contents/MacOS/Microsoft Edge"}},
+			{"Brave", KindChromium, []string{"/Applications/Brave Browser.app/Contents/MacOS/Brave Browser"}},
+			{"Firefox", KindFirefox, []string{"/Applications/Firefox.app/Contents/MacOS/firefox"}},
+		}
+	case "windows":
+		var roots []string
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "LocalAppData"} {
+			if root := os.Getenv(env); root != "" {
+				roots = append(roots, root)
+			}
+		}
+		under := func(rel string) []string {
+			paths := make([]string, 0, len(roots))
+			for _, root := range roots {
+				paths = append(paths, filepath.Join(root, rel))
+			}
+			return paths
+		}
+		return []candidate{
+			{"Google Chrome", KindChromium, under(`Google\Chrome\Application\chrome.exe`)},
+			{"Microsoft Edge", KindChromium, under(`Microsoft\Edge\Application\msedge.exe`)},
+			{"Brave", KindChromium, under(`BraveSoftware\Brave-Browser\Application\brave.exe`)},
+			{"Firefox", KindFirefox, under(`Mozilla Firefox\firefox.exe`)},
+		}
+	default:
+		return []candidate{
+			{"Google Chrome", KindChromium, []string{"google-chrome", "google-chrome-stable"}},
+			{"Chromium", KindChromium, []string{"chromium", "chromium-browser"}},
+			{"Microsoft Edge", KindChromium, []string{"microsoft-edge"}},
+			{"Brave", KindChromium, []string{"brave-browser"}},
+			{"Firefox", KindFirefox, []string{"firefox"}},
+		}
+	}
+}
+
+// DetectBrowsers returns the supported browsers installed on this system
+func DetectBrowsers() []Browser {
+	browsers := []Browser{}
+	for _, c := range candidates() {
+		for _, path := range c.paths {
+			if found := findExecutable(path); found != "" {
+				browsers = append(browsers, Browser{Name: c.name, Kind: c.kind, Path: found})
+				break
+			}
+		}
+	}
+	return browsers
+}
+
+func findExecutable(path string) string {
+	if filepath.IsAbs(path) {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		return ""
+	}
+	found, err := exec.LookPath(path)
+	if err != nil {
+		return ""
+	}
+	return found
+}
+
+// LaunchBrowser starts a browser with its own profile in profileDir that
+// sends all traffic through the proxy at proxyHost and proxyPort. Chromium browsers also
+// trust the CA with the given SPKI hash; Firefox keeps its own certificate
+// store, so the CA still has to be imported from the start page.
+func LaunchBrowser(browser Browser, profileDir, proxyHost, proxyPort, caSPKIHash string) error {
+	if err := os.MkdirAll(profileDir, 0755); err != nil {
+		return fmt.Errorf("failed to create browser profile: %v", err)
+	}
+	proxyAddr := net.JoinHostPort(proxyHost, proxyPort)
+
+	var args []string
+	switch browser.Kind {
+	case KindChromium:
+		args = []string{
+			"--user-data-dir=" + profileDir,
+			"--proxy-server=http://" + proxyAddr,
+			// Chromium bypasses the proxy for loopback addresses by default
+			"--proxy-bypass-list=<-loopback>",
+			"--no-first-run",
+			"--no-default-browser-check",
+		}
+		if caSPKIHash != "" {
+			args = append(args, "--ignore-certificate-errors-spki-list="+caSPKIHash)
+		}
+	case KindFirefox:
+		if err := writeFirefoxPrefs(profileDir, proxyHost, proxyPort); err != nil {
+			return err
+		}
+		args = []string{"-profile", profileDir, "-no-remote"}
+	default:
+		return fmt.Errorf("unsupported browser kind: %s", browser.Kind)
+	}
+	args = append(args, startURL)
+
+	cmd := exec.Command(browser.Path, args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %v", browser.Name, err)
+	}
+	// The browser outlives the call, reap it in the background
+	go cmd.Wait()
+	return nil
+}
+
+// writeFirefoxPrefs writes a user.js that routes every protocol through the
+// proxy, including localhost
+func writeFirefoxPrefs(profileDir, proxyHost, proxyPort string) error {
+	prefs := []string{
+		`user_pref("network.proxy.type", 1);`,
+		`user_pref("network.proxy.http", "` + proxyHost + `");`,
+		`user_pref("network.proxy.http_port", ` + proxyPort + `);`,
+		`user_pref("network.proxy.ssl", "` + proxyHost + `");`,
+		`user_pref("network.proxy.ssl_port", ` + proxyPort + `);`,
+		`user_pref("network.proxy.share_proxy_settings", true);`,
+		`user_pref("network.proxy.no_proxies_on", "");`,
+		`user_pref("network.proxy.allow_hijacking_localhost", true);`,
+		`user_pref("browser.shell.checkDefaultBrowser", false);`,
+		`user_pref("browser.aboutwelcome.enabled", false);`,
+	}
+	path := filepath.Join(profileDir, "user.js")
+	if err := os.WriteFile(path, []byte(strings.Join(prefs, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write Firefox preferences: %v", err)
+	}
+	return nil
+}
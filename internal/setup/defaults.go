@@ -0,0 +1,169 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"prokzee/internal/settings"
+	"prokzee/internal/upstream"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables that override the config file
+const (
+	EnvConfig         = "PROKZEE_CONFIG" // path of the config file
+	EnvDataDir        = "PROKZEE_DATA_DIR"
+	EnvProxyPort      = "PROKZEE_PROXY_PORT"
+	EnvLLMAPIURL      = "PROKZEE_LLM_API_URL"
+	EnvLLMAPIKey      = "PROKZEE_LLM_API_KEY"
+	EnvInteractshHost = "PROKZEE_INTERACTSH_HOST"
+	EnvInteractshPort = "PROKZEE_INTERACTSH_PORT"
+	EnvUpstreamProxy  = "PROKZEE_UPSTREAM_PROXY"
+	EnvUpstreamBypass = "PROKZEE_UPSTREAM_PROXY_BYPASS"
+)
+
+// configFiles are looked up in the default data directory, in order, when
+// PROKZEE_CONFIG is not set
+var configFiles = []string{"config.yaml", "config.yml", "config.json"}
+
+// Defaults are the global defaults of a config file and the environment. They
+// seed the settings of new projects, existing projects keep their own.
+type Defaults struct {
+	DataDir   string `yaml:"data_dir" json:"data_dir"`
+	ProxyPort int    `yaml:"proxy_port" json:"proxy_port"`
+	LLM       struct {
+		APIURL string `yaml:"api_url" json:"api_url"`
+		APIKey string `yaml:"api_key" json:"api_key"`
+	} `yaml:"llm" json:"llm"`
+	Interactsh struct {
+		Host string `yaml:"host" json:"host"`
+		Port int    `yaml:"port" json:"port"`
+	} `yaml:"interactsh" json:"interactsh"`
+	UpstreamProxy struct {
+		URL    string `yaml:"url" json:"url"`
+		Bypass string `yaml:"bypass" json:"bypass"`
+	} `yaml:"upstream_proxy" json:"upstream_proxy"`
+
+	// Source is the config file the defaults were read from, if any
+	Source string `yaml:"-" json:"-"`
+}
+
+// LoadDefaults reads the config file, YAML or JSON by extension, and applies
+// the environment variables on top. Having neither is not an error.
+func LoadDefaults() (*Defaults, error) {
+	defaults := &Defaults{}
+
+	path := os.Getenv(EnvConfig)
+	if path == "" {
+		for _, name := range configFiles {
+			candidate := filepath.Join(DefaultDataDir(), name)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path != "" {
+		if err := defaults.readFile(path); err != nil {
+			return defaults, err
+		}
+	}
+
+	if err := defaults.applyEnv(); err != nil {
+		return defaults, err
+	}
+	return defaults, defaults.validate()
+}
+
+func (d *Defaults) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, d)
+	} else {
+		err = yaml.Unmarshal(data, d)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	d.Source = path
+	return nil
+}
+
+func (d *Defaults) applyEnv() error {
+	if value := os.Getenv(EnvDataDir); value != "" {
+		d.DataDir = value
+	}
+	if value := os.Getenv(EnvLLMAPIURL); value != "" {
+		d.LLM.APIURL = value
+	}
+	if value := os.Getenv(EnvLLMAPIKey); value != "" {
+		d.LLM.APIKey = value
+	}
+	if value := os.Getenv(EnvInteractshHost); value != "" {
+		d.Interactsh.Host = value
+	}
+	if value := os.Getenv(EnvUpstreamProxy); value != "" {
+		d.UpstreamProxy.URL = value
+	}
+	if value := os.Getenv(EnvUpstreamBypass); value != "" {
+		d.UpstreamProxy.Bypass = value
+	}
+
+	ports := []struct {
+		name  string
+		value *int
+	}{
+		{EnvProxyPort, &d.ProxyPort},
+		{EnvInteractshPort, &d.Interactsh.Port},
+	}
+	for _, port := range ports {
+		value := os.Getenv(port.name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", port.name, err)
+		}
+		*port.value = number
+	}
+	return nil
+}
+
+func (d *Defaults) validate() error {
+	if d.ProxyPort < 0 || d.ProxyPort > 65535 {
+		return fmt.Errorf("invalid proxy port %d", d.ProxyPort)
+	}
+	if d.Interactsh.Port < 0 || d.Interactsh.Port > 65535 {
+		return fmt.Errorf("invalid interactsh port %d", d.Interactsh.Port)
+	}
+	if _, err := upstream.ParseChain(d.UpstreamProxy.URL, d.UpstreamProxy.Bypass); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Seed returns the settings new projects start with
+func (d *Defaults) Seed() settings.Seed {
+	seed := settings.Seed{
+		OpenAIAPIURL:   d.LLM.APIURL,
+		OpenAIAPIKey:   d.LLM.APIKey,
+		InteractshHost: d.Interactsh.Host,
+		InteractshPort: d.Interactsh.Port,
+		UpstreamProxy:  d.UpstreamProxy.URL,
+		UpstreamBypass: d.UpstreamProxy.Bypass,
+	}
+	if d.ProxyPort != 0 {
+		seed.ProxyPort = strconv.Itoa(d.ProxyPort)
+	}
+	return seed
+}
@@ -0,0 +1,180 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"prokzee/internal/certificate"
+)
+
+// configName is the file, always in the default data directory, that records
+// the first-run choices. It stays there so a moved data directory is found.
+const configName = "setup.json"
+
+// DefaultProject is the project opened at startup and after the data
+// directory changes
+const DefaultProject = "default_project.db"
+
+// Config is what the first-run wizard chose
+type Config struct {
+	DataDir     string `json:"dataDir"`
+	Completed   bool   `json:"completed"`
+	CompletedAt string `json:"completedAt,omitempty"`
+}
+
+// DefaultDataDir returns the ProKZee directory in the user config directory,
+// falling back to the home and then the current directory
+func DefaultDataDir() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		log.Printf("Error getting user config directory: %v, falling back to home directory", err)
+		homeDir, homeDirErr := os.UserHomeDir()
+		if homeDirErr != nil {
+			log.Printf("Error getting user home directory: %v, using current directory", homeDirErr)
+			configDir = "."
+		} else {
+			configDir = homeDir
+		}
+	}
+	return filepath.Join(configDir, "ProKZee")
+}
+
+// Load reads the first-run configuration. A missing file is not an error, it
+// means the wizard never ran.
+func Load() (*Config, error) {
+	config := &Config{}
+	data, err := os.ReadFile(filepath.Join(DefaultDataDir(), configName))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read setup configuration: %v", err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return config, fmt.Errorf("failed to parse setup configuration: %v", err)
+	}
+	return config, nil
+}
+
+// Save writes the first-run configuration
+func Save(config *Config) error {
+	dir := DefaultDataDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode setup configuration: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, configName), data, 0644); err != nil {
+		return fmt.Errorf("failed to save setup configuration: %v", err)
+	}
+	return nil
+}
+
+// Complete marks the wizard as done
+func (c *Config) Complete() {
+	c.Completed = true
+	c.CompletedAt = time.Now().UTC().Format(time.RFC3339)
+}
+
+// DataDir returns the data directory. PROKZEE_DATA_DIR takes precedence over
+// the directory chosen in the wizard, which takes precedence over the config
+// file and then the default directory.
+func DataDir() string {
+	if dir := os.Getenv(EnvDataDir); dir != "" {
+		return dir
+	}
+	config, err := Load()
+	if err != nil {
+		log.Printf("Ignoring the setup configuration: %v", err)
+	}
+	if config.DataDir != "" {
+		return config.DataDir
+	}
+	if defaults, err := LoadDefaults(); err == nil && defaults.DataDir != "" {
+		return defaults.DataDir
+	}
+	return DefaultDataDir()
+}
+
+// ProjectsDir returns the directory the project databases are stored in
+func ProjectsDir(dataDir string) string {
+	return filepath.Join(dataDir, "projects")
+}
+
+// CertsDir returns the directory the CA is stored in
+func CertsDir(dataDir string) string {
+	return filepath.Join(dataDir, "certs")
+}
+
+// ValidateDataDir checks that a directory can hold the ProKZee data, creating
+// it when it does not exist
+func ValidateDataDir(dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("data directory cannot be empty")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid data directory: %v", err)
+	}
+	if err := os.MkdirAll(ProjectsDir(dir), 0755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return "", fmt.Errorf("data directory is not writable: %v", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return dir, nil
+}
+
+// PortCheck is the result of checking whether the proxy can listen on a port
+type PortCheck struct {
+	Port      string `json:"port"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CheckPort reports whether a port is free to listen on at address, every
+// interface when it is empty. currentPort is the port the proxy already uses,
+// which counts as available.
+func CheckPort(address, port, currentPort string) PortCheck {
+	check := PortCheck{Port: port}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		check.Error = "port must be a number between 1 and 65535"
+		return check
+	}
+	if port == currentPort {
+		check.Available = true
+		return check
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, port))
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	listener.Close()
+	check.Available = true
+	return check
+}
+
+// State is what the first-run wizard shows at each step
+type State struct {
+	Completed      bool                `json:"completed"`
+	DataDir        string              `json:"dataDir"`
+	DefaultDataDir string              `json:"defaultDataDir"`
+	ProxyPort      string              `json:"proxyPort"`
+	CA             *certificate.CAInfo `json:"ca"`
+	Browsers       []Browser           `json:"browsers"`
+}
@@ -4,12 +4,19 @@ import (
 	"database/sql"
 	"strings"
 	"time"
+
+	"prokzee/internal/asof"
+	"prokzee/internal/hostaddr"
+	"prokzee/internal/pathtemplate"
 )
 
 // Node represents a node in the sitemap tree
 type Node struct {
 	URL      string  `json:"url"`
 	Children []*Node `json:"children"`
+	// Stats sums up the responses of the paths ending at the node, nil for
+	// nodes no request ended at
+	Stats *EndpointStats `json:"stats,omitempty"`
 }
 
 // Client handles sitemap operations
@@ -24,10 +31,12 @@ func NewClient(db *sql.DB) (*Client, error) {
 	}, nil
 }
 
-// GetDomains retrieves all unique domains from the requests table
-func (c *Client) GetDomains() ([]string, error) {
+// GetDomains retrieves all unique domains from the requests table, those
+// seen by asOf when it is set
+func (c *Client) GetDomains(asOf time.Time) ([]string, error) {
 	// Query distinct domains, excluding wails.localhost
-	rows, err := c.db.Query("SELECT DISTINCT domain FROM requests where 1=1 ORDER BY domain")
+	asOfCond, asOfArgs := asof.Condition("timestamp", asOf)
+	rows, err := c.db.Query("SELECT DISTINCT domain FROM requests where 1=1"+asOfCond+" ORDER BY domain", asOfArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,41 +49,75 @@ func (c *Client) GetDomains() ([]string, error) {
 			return nil, err
 		}
 		if domain != "" {
-			domains = append(domains, domain)
+			domains = append(domains, hostaddr.Literal(domain))
 		}
 	}
 
 	return domains, nil
 }
 
-// GetSiteMap retrieves the sitemap for a given domain
-func (c *Client) GetSiteMap(domain string) (*Node, error) {
+// GetSiteMap retrieves the sitemap for a given domain, with the response
+// statistics of every endpoint. A non-zero asOf rebuilds the sitemap from
+// the requests recorded by then.
+func (c *Client) GetSiteMap(domain string, asOf time.Time) (*Node, error) {
 	// Create root node for the domain
-	root := &Node{URL: domain, Children: []*Node{}}
+	root := &Node{URL: hostaddr.Literal(domain), Children: []*Node{}}
 
-	// Query the database for paths
-	rows, err := c.db.Query("SELECT DISTINCT path FROM requests WHERE domain = ? ORDER BY path", domain)
+	responses, err := c.loadResponses(domain, "", asOf)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	first, last := timeRange(responses)
 
-	// Process each path
-	for rows.Next() {
-		var path string
-		if err := rows.Scan(&path); err != nil {
-			return nil, err
+	// Paths collapsed into one {param} node share its statistics
+	builders := make(map[*Node]*statsBuilder)
+	var node *Node
+	for i, response := range responses {
+		if response.path == "" {
+			continue
+		}
+		if i == 0 || response.path != responses[i-1].path {
+			node = c.addPathToSiteMap(root, response.path)
 		}
-		if path != "" {
-			c.addPathToSiteMap(root, path)
+		builder, ok := builders[node]
+		if !ok {
+			builder = newStatsBuilder(first, last)
+			builders[node] = builder
 		}
+		builder.add(response)
+	}
+	for node, builder := range builders {
+		node.Stats = builder.finish()
 	}
 
 	return root, nil
 }
 
-// addPathToSiteMap adds a path to the sitemap tree
-func (c *Client) addPathToSiteMap(root *Node, path string) {
+// GetEndpointStats sums up the responses of one path of a domain, those
+// recorded by asOf when it is set
+func (c *Client) GetEndpointStats(domain, path string, asOf time.Time) (*EndpointStats, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	responses, err := c.loadResponses(domain, path, asOf)
+	if err != nil {
+		return nil, err
+	}
+	builder := newStatsBuilder(timeRange(responses))
+	for _, response := range responses {
+		builder.add(response)
+	}
+	return builder.finish(), nil
+}
+
+// domainForms returns a domain as the list shows it, without the brackets of
+// IPv6 literals, and in the canonical form requests are stored with
+func domainForms(domain string) (string, string) {
+	return strings.Trim(domain, "[]"), hostaddr.Canonical(domain)
+}
+
+// addPathToSiteMap adds a path to the sitemap tree and returns its node
+func (c *Client) addPathToSiteMap(root *Node, path string) *Node {
 	// Ensure path starts with /
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
@@ -85,18 +128,14 @@ func (c *Client) addPathToSiteMap(root *Node, path string) {
 
 	// Only add root path if it's explicitly in the data
 	if path == "/" {
-		found := false
 		for _, child := range current.Children {
 			if child.URL == "/" {
-				found = true
-				break
+				return child
 			}
 		}
-		if !found {
-			newNode := &Node{URL: "/", Children: []*Node{}}
-			current.Children = append(current.Children, newNode)
-		}
-		return
+		newNode := &Node{URL: "/", Children: []*Node{}}
+		current.Children = append(current.Children, newNode)
+		return newNode
 	}
 
 	// Handle other paths
@@ -104,11 +143,9 @@ func (c *Client) addPathToSiteMap(root *Node, path string) {
 		if part == "" {
 			continue
 		}
-		// Check if the part contains parameters (e.g., :id, {id})
-		if strings.Contains(part, ":") || (strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")) {
-			// Replace parameter with a placeholder
-			part = "{param}"
-		}
+		// Parameters (e.g., :id, {id}) and numbers, UUIDs or hashes share
+		// one placeholder node, such as {id}
+		part = pathtemplate.Segment(part)
 		found := false
 		for _, child := range current.Children {
 			if child.URL == part {
@@ -123,6 +160,7 @@ func (c *Client) addPathToSiteMap(root *Node, path string) {
 			current = newNode
 		}
 	}
+	return current
 }
 
 // RequestInfo represents the information about a request
@@ -137,8 +175,10 @@ type RequestInfo struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-// GetRequestsByEndpoint retrieves all requests for a specific domain and path
-func (c *Client) GetRequestsByEndpoint(domain, path string) ([]RequestInfo, error) {
+// GetRequestsByEndpoint retrieves all requests for a specific domain and
+// path, those recorded by asOf when it is set. A path with placeholders, as
+// the sitemap shows it, retrieves the requests of every path it stands for.
+func (c *Client) GetRequestsByEndpoint(domain, path string, asOf time.Time) ([]RequestInfo, error) {
 	// Ensure path starts with a forward slash
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
@@ -148,9 +188,17 @@ func (c *Client) GetRequestsByEndpoint(domain, path string) ([]RequestInfo, erro
 	query := `
 		SELECT id, method, url, domain, path, query, status, timestamp 
 		FROM requests 
-		WHERE domain = ? AND path = ?
+		WHERE domain IN (?, ?)
 	`
-	rows, err := c.db.Query(query, domain, path)
+	stored, canonical := domainForms(domain)
+	args := []interface{}{stored, canonical}
+	templated := pathtemplate.IsTemplate(path)
+	if !templated {
+		query += " AND path = ?"
+		args = append(args, path)
+	}
+	asOfCond, asOfArgs := asof.Condition("timestamp", asOf)
+	rows, err := c.db.Query(query+asOfCond, append(args, asOfArgs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -171,6 +219,9 @@ func (c *Client) GetRequestsByEndpoint(domain, path string) ([]RequestInfo, erro
 		); err != nil {
 			return nil, err
 		}
+		if templated && !pathtemplate.Matches(path, req.Path) {
+			continue
+		}
 		requests = append(requests, req)
 	}
 
@@ -182,9 +233,10 @@ func (c *Client) GetRequestsByDomain(domain string) ([]RequestInfo, error) {
 	query := `
 		SELECT id, method, url, domain, path, query, status, timestamp 
 		FROM requests 
-		WHERE domain = ?
+		WHERE domain IN (?, ?)
 	`
-	rows, err := c.db.Query(query, domain)
+	stored, canonical := domainForms(domain)
+	rows, err := c.db.Query(query, stored, canonical)
 	if err != nil {
 		return nil, err
 	}
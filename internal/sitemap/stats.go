@@ -0,0 +1,197 @@
+package sitemap
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"prokzee/internal/asof"
+	"prokzee/internal/pathtemplate"
+)
+
+// SparklineBuckets is how many time slices the history of an endpoint is
+// split into
+const SparklineBuckets = 20
+
+// Bucket is one time slice of the history of an endpoint
+type Bucket struct {
+	Start     time.Time      `json:"start"`
+	Requests  int            `json:"requests"`
+	AvgLength float64        `json:"avgLength"`
+	Classes   map[string]int `json:"classes"` // answers by status class, such as 2xx
+}
+
+// EndpointStats sums up the responses recorded for an endpoint. The
+// sparkline slices the time from the first to the last request of the
+// domain, so the sparklines of a sitemap line up.
+type EndpointStats struct {
+	Requests  int            `json:"requests"`
+	MinLength int64          `json:"minLength"`
+	MaxLength int64          `json:"maxLength"`
+	AvgLength float64        `json:"avgLength"`
+	Statuses  map[string]int `json:"statuses"` // answers by status code, "0" without an answer
+	Sparkline []Bucket       `json:"sparkline"`
+	// Drift is set when the last slice with requests differs from the ones
+	// before in status class or, by more than half, in average length
+	Drift bool `json:"drift"`
+}
+
+// response is what the statistics are computed from
+type response struct {
+	path      string
+	status    int
+	length    int64
+	timestamp time.Time
+}
+
+// loadResponses reads the responses of a domain, ordered by path, or of one
+// of its paths or the paths of a template, leaving out those after asOf when
+// it is set
+func (c *Client) loadResponses(domain, path string, asOf time.Time) ([]response, error) {
+	query := `
+		SELECT COALESCE(path, ''), COALESCE(status, ''), COALESCE(length, LENGTH(CAST(response_body AS BLOB)), 0), timestamp
+		FROM requests
+		WHERE domain IN (?, ?)`
+	stored, canonical := domainForms(domain)
+	args := []interface{}{stored, canonical}
+	templated := pathtemplate.IsTemplate(path)
+	if path != "" && !templated {
+		query += " AND path = ?"
+		args = append(args, path)
+	}
+	asOfCond, asOfArgs := asof.Condition("timestamp", asOf)
+	query += asOfCond
+	args = append(args, asOfArgs...)
+	rows, err := c.db.Query(query+" ORDER BY path, timestamp", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var responses []response
+	for rows.Next() {
+		var r response
+		var status string
+		if err := rows.Scan(&r.path, &status, &r.length, &r.timestamp); err != nil {
+			return nil, err
+		}
+		// Stored like "200 OK"
+		if fields := strings.Fields(status); len(fields) > 0 {
+			r.status, _ = strconv.Atoi(fields[0])
+		}
+		if templated && !pathtemplate.Matches(path, r.path) {
+			continue
+		}
+		responses = append(responses, r)
+	}
+	return responses, rows.Err()
+}
+
+// timeRange returns the times of the first and last response
+func timeRange(responses []response) (time.Time, time.Time) {
+	var first, last time.Time
+	for _, r := range responses {
+		if first.IsZero() || r.timestamp.Before(first) {
+			first = r.timestamp
+		}
+		if r.timestamp.After(last) {
+			last = r.timestamp
+		}
+	}
+	return first, last
+}
+
+// statusClass names the class of a status code, such as 4xx
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "none"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// statsBuilder accumulates the responses of an endpoint
+type statsBuilder struct {
+	first time.Time
+	width time.Duration // of a bucket
+	stats EndpointStats
+	total int64
+	sums  [SparklineBuckets]int64
+}
+
+func newStatsBuilder(first, last time.Time) *statsBuilder {
+	b := &statsBuilder{
+		first: first,
+		width: last.Sub(first)/SparklineBuckets + 1,
+		stats: EndpointStats{Statuses: map[string]int{}, Sparkline: make([]Bucket, SparklineBuckets)},
+	}
+	for i := range b.stats.Sparkline {
+		b.stats.Sparkline[i] = Bucket{Start: first.Add(time.Duration(i) * b.width), Classes: map[string]int{}}
+	}
+	return b
+}
+
+func (b *statsBuilder) add(r response) {
+	s := &b.stats
+	if s.Requests == 0 || r.length < s.MinLength {
+		s.MinLength = r.length
+	}
+	if r.length > s.MaxLength {
+		s.MaxLength = r.length
+	}
+	s.Requests++
+	b.total += r.length
+	s.Statuses[strconv.Itoa(r.status)]++
+
+	index := int(r.timestamp.Sub(b.first) / b.width)
+	if index < 0 {
+		index = 0
+	} else if index >= SparklineBuckets {
+		index = SparklineBuckets - 1
+	}
+	bucket := &s.Sparkline[index]
+	bucket.Requests++
+	bucket.Classes[statusClass(r.status)]++
+	b.sums[index] += r.length
+}
+
+// finish computes the averages and looks for drift
+func (b *statsBuilder) finish() *EndpointStats {
+	s := b.stats
+	if s.Requests > 0 {
+		s.AvgLength = float64(b.total) / float64(s.Requests)
+	}
+	latest := -1
+	for i := range s.Sparkline {
+		if s.Sparkline[i].Requests > 0 {
+			s.Sparkline[i].AvgLength = float64(b.sums[i]) / float64(s.Sparkline[i].Requests)
+			latest = i
+		}
+	}
+	if latest < 0 {
+		return &s
+	}
+
+	// The last slice is compared with all the earlier ones together
+	var earlierRequests int
+	var earlierLength int64
+	earlierClasses := map[string]bool{}
+	for i := 0; i < latest; i++ {
+		earlierRequests += s.Sparkline[i].Requests
+		earlierLength += b.sums[i]
+		for class := range s.Sparkline[i].Classes {
+			earlierClasses[class] = true
+		}
+	}
+	if earlierRequests > 0 {
+		for class := range s.Sparkline[latest].Classes {
+			if !earlierClasses[class] {
+				s.Drift = true
+			}
+		}
+		earlierAvg := float64(earlierLength) / float64(earlierRequests)
+		if diff := s.Sparkline[latest].AvgLength - earlierAvg; diff > earlierAvg/2 || -diff > earlierAvg/2 {
+			s.Drift = true
+		}
+	}
+	return &s
+}
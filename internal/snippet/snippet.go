@@ -0,0 +1,387 @@
+package snippet
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"prokzee/internal/contentcoding"
+)
+
+// Snippet formats
+const (
+	FormatCurl   = "curl"
+	FormatPython = "python"
+	FormatGo     = "go"
+	FormatRaw    = "raw" // the HTTP/1 message, to paste into raw HTTP tools
+)
+
+// Sources of stored requests
+const (
+	SourceHistory  = "history"
+	SourceResender = "resender"
+)
+
+// skippedHeaders are set by the client running the snippet
+var skippedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Connection":        true,
+	"Proxy-Connection":  true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+}
+
+// Request is a stored request as a snippet sends it
+type Request struct {
+	Method string
+	URL    string
+	Proto  string
+	Header http.Header
+	Body   []byte // as sent, content encoding included
+}
+
+// LoadHistory reads a request of the history. Bodies stored decoded get their
+// content encoding applied again, and a body kept whole in a blob is read
+// from it. blobPath returns the path of a blob named in the requests table.
+func LoadHistory(db *sql.DB, blobPath func(name string) string, id int) (*Request, error) {
+	var req Request
+	var headersJSON, body, encoding, blob string
+	err := db.QueryRow(`
+		SELECT method, COALESCE(url, ''), COALESCE(http_version, ''), COALESCE(request_headers, ''),
+			COALESCE(request_body, ''), COALESCE(request_encoding, ''), COALESCE(request_blob, '')
+		FROM requests WHERE id = ?
+	`, id).Scan(&req.Method, &req.URL, &req.Proto, &headersJSON, &body, &encoding, &blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch request %d: %v", id, err)
+	}
+	req.Header = parseHeader(headersJSON)
+
+	if path := blobPath(blob); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			req.Body = data
+			return &req, nil
+		}
+	}
+	req.Body = []byte(body)
+	if encoding != "" {
+		if encoded, err := contentcoding.Encode(req.Body, encoding); err == nil {
+			req.Body = encoded
+		}
+	}
+	return &req, nil
+}
+
+// LoadResender reads a request sent from the resender
+func LoadResender(db *sql.DB, id int) (*Request, error) {
+	var req Request
+	var headersJSON, body string
+	err := db.QueryRow(`
+		SELECT method, COALESCE(url, ''), COALESCE(http_version, ''), COALESCE(request_headers, ''), COALESCE(request_body, '')
+		FROM resender_requests WHERE id = ?
+	`, id).Scan(&req.Method, &req.URL, &req.Proto, &headersJSON, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch resender request %d: %v", id, err)
+	}
+	req.Header = parseHeader(headersJSON)
+	req.Body = []byte(body)
+	return &req, nil
+}
+
+// parseHeader reads headers stored as JSON, with a list of values or a
+// single value per name
+func parseHeader(headersJSON string) http.Header {
+	header := http.Header{}
+	var stored map[string]interface{}
+	if headersJSON == "" || json.Unmarshal([]byte(headersJSON), &stored) != nil {
+		return header
+	}
+	for name, value := range stored {
+		switch value := value.(type) {
+		case string:
+			header[name] = append(header[name], value)
+		case []interface{}:
+			for _, item := range value {
+				if text, ok := item.(string); ok {
+					header[name] = append(header[name], text)
+				}
+			}
+		}
+	}
+	return header
+}
+
+// Render writes a request as a snippet in the given format
+func Render(req *Request, snippetFormat string) (string, error) {
+	if _, err := url.Parse(req.URL); err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+	switch snippetFormat {
+	case FormatCurl:
+		return renderCurl(req), nil
+	case FormatPython:
+		return renderPython(req), nil
+	case FormatGo:
+		return renderGo(req)
+	case FormatRaw:
+		return renderRaw(req), nil
+	}
+	return "", fmt.Errorf("unknown snippet format: %s", snippetFormat)
+}
+
+// headerNames returns the headers a snippet sets, sorted. Host is only set
+// when it differs from the host of the URL.
+func headerNames(req *Request) []string {
+	host := ""
+	if u, err := url.Parse(req.URL); err == nil {
+		host = u.Host
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		canonical := http.CanonicalHeaderKey(name)
+		if skippedHeaders[canonical] || (canonical == "Host" && strings.EqualFold(req.Header.Get(name), host)) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hostOverride returns the Host header of a request when it differs from the
+// host of its URL
+func hostOverride(req *Request) string {
+	for _, name := range headerNames(req) {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			return req.Header[name][0]
+		}
+	}
+	return ""
+}
+
+// headerValue joins the values of a header the way they travel on the wire
+func headerValue(name string, values []string) string {
+	if http.CanonicalHeaderKey(name) == "Cookie" {
+		return strings.Join(values, "; ")
+	}
+	return strings.Join(values, ", ")
+}
+
+// isText reports whether a body can be written as a text literal: valid
+// UTF-8 without control characters other than tabs and line breaks
+func isText(body []byte) bool {
+	if !utf8.Valid(body) {
+		return false
+	}
+	for _, r := range string(body) {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteShell quotes a string for a POSIX shell
+func quoteShell(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func renderCurl(req *Request) string {
+	options := []string{"curl -sS -k --path-as-is"}
+	if strings.HasPrefix(req.Proto, "HTTP/2") {
+		options = append(options, "--http2")
+	}
+	if req.Method != http.MethodGet || len(req.Body) > 0 {
+		options = append(options, "-X "+quoteShell(req.Method))
+	}
+	compressed := false
+	for _, name := range headerNames(req) {
+		if http.CanonicalHeaderKey(name) == "Accept-Encoding" {
+			compressed = true
+		}
+		// Cookies travel in one header, as browsers send them
+		values := req.Header[name]
+		if http.CanonicalHeaderKey(name) == "Cookie" {
+			values = []string{headerValue(name, values)}
+		}
+		for _, value := range values {
+			options = append(options, "-H "+quoteShell(name+": "+value))
+		}
+	}
+	if compressed {
+		options = append(options, "--compressed")
+	}
+
+	// Binary bodies go through base64, shell strings cannot hold every byte
+	prefix := ""
+	switch {
+	case len(req.Body) == 0:
+	case isText(req.Body):
+		options = append(options, "--data-raw "+quoteShell(string(req.Body)))
+	default:
+		prefix = "printf '%s' " + quoteShell(base64.StdEncoding.EncodeToString(req.Body)) + " | base64 -d | "
+		options = append(options, "--data-binary @-")
+	}
+	options = append(options, quoteShell(req.URL))
+	return prefix + strings.Join(options, " \\\n  ") + "\n"
+}
+
+// quoteJSON quotes a string as a JSON string, which is also a valid Python
+// literal
+func quoteJSON(value string) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// pythonBytes writes a Python bytes literal holding data
+func pythonBytes(data []byte) string {
+	var b strings.Builder
+	b.WriteString("b'")
+	for _, c := range data {
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\'':
+			b.WriteString(`\'`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+func renderPython(req *Request) string {
+	var b strings.Builder
+	b.WriteString(`import requests
+import urllib3
+
+urllib3.disable_warnings(urllib3.exceptions.InsecureRequestWarning)
+
+`)
+	if names := headerNames(req); len(names) == 0 {
+		b.WriteString("headers = {}\n")
+	} else {
+		b.WriteString("headers = {\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "    %s: %s,\n", quoteJSON(name), quoteJSON(headerValue(name, req.Header[name])))
+		}
+		b.WriteString("}\n")
+	}
+	data := "None"
+	if len(req.Body) > 0 {
+		data = "data"
+		fmt.Fprintf(&b, "data = %s\n", pythonBytes(req.Body))
+	}
+	fmt.Fprintf(&b, "\nresp = requests.request(%s, %s, headers=headers, data=%s, allow_redirects=False, verify=False)\n",
+		quoteJSON(req.Method), quoteJSON(req.URL), data)
+	b.WriteString("print(resp.status_code, resp.reason)\nprint(resp.text)\n")
+	return b.String()
+}
+
+func renderGo(req *Request) (string, error) {
+	var b strings.Builder
+	imports := []string{"crypto/tls", "fmt", "io", "net/http"}
+	body := "nil"
+	if len(req.Body) > 0 {
+		imports = append(imports, "strings")
+		body = fmt.Sprintf("strings.NewReader(%s)", strconv.Quote(string(req.Body)))
+	}
+	b.WriteString("package main\n\nimport (\n")
+	for _, name := range imports {
+		fmt.Fprintf(&b, "\t%s\n", strconv.Quote(name))
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+	fmt.Fprintf(&b, "\treq, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(req.Method), strconv.Quote(req.URL), body)
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	for _, name := range headerNames(req) {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			continue
+		}
+		values := req.Header[name]
+		if http.CanonicalHeaderKey(name) == "Cookie" {
+			values = []string{headerValue(name, values)}
+		}
+		for _, value := range values {
+			fmt.Fprintf(&b, "\treq.Header.Add(%s, %s)\n", strconv.Quote(name), strconv.Quote(value))
+		}
+	}
+	if host := hostOverride(req); host != "" {
+		fmt.Fprintf(&b, "\treq.Host = %s\n", strconv.Quote(host))
+	}
+	b.WriteString(`
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(resp.Status)
+	fmt.Println(string(data))
+}
+`)
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to format Go snippet: %v", err)
+	}
+	return string(formatted), nil
+}
+
+// renderRaw writes a request as an HTTP/1 message, Host first and a
+// Content-Length matching the body
+func renderRaw(req *Request) string {
+	target, host := req.URL, ""
+	if u, err := url.Parse(req.URL); err == nil {
+		target, host = u.RequestURI(), u.Host
+	}
+	if override := hostOverride(req); override != "" {
+		host = override
+	}
+	proto := req.Proto
+	if !strings.HasPrefix(proto, "HTTP/1") {
+		proto = "HTTP/1.1"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s\r\n", req.Method, target, proto)
+	fmt.Fprintf(&b, "Host: %s\r\n", host)
+	for _, name := range headerNames(req) {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			continue
+		}
+		for _, value := range req.Header[name] {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	if len(req.Body) > 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(req.Body))
+	}
+	b.WriteString("\r\n")
+	b.Write(req.Body)
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package ssti
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Placeholders of the probes, filled with random numbers and markers so an
+// evaluated expression is told apart from a reflected one
+const (
+	number1 = "NUM1"
+	number2 = "NUM2"
+	product = "PRODUCT"
+	marker1 = "MARK1"
+	marker2 = "MARK2"
+)
+
+// Syntax is an expression delimiter family and the polyglot probe telling
+// whether a parameter is evaluated in it. Expect is never a substring of the
+// probe, so a plain reflection does not match.
+type Syntax struct {
+	Name   string `json:"name"`
+	Probe  string `json:"probe"`
+	Expect string `json:"expect"`
+}
+
+// Syntaxes are the detection probes, sent in this order
+var Syntaxes = []Syntax{
+	{Name: "{{ }}", Probe: "{{NUM1*NUM2}}", Expect: product},
+	{Name: "${ }", Probe: "${NUM1*NUM2}", Expect: product},
+	{Name: "<%= %>", Probe: "<%= NUM1*NUM2 %>", Expect: product},
+	{Name: "#{ }", Probe: "#{NUM1*NUM2}", Expect: product},
+	{Name: "{ }", Probe: "{NUM1*NUM2}", Expect: product},
+	{Name: "@( )", Probe: "@(NUM1*NUM2)", Expect: product},
+	{Name: "[[${ }]]", Probe: "[[${NUM1*NUM2}]]", Expect: product},
+	{Name: "#set", Probe: "#set($p=NUM1*NUM2)${p}", Expect: product},
+	{Name: "{{printf}}", Probe: `{{printf "%d%d" NUM1 NUM2}}`, Expect: number1 + number2},
+	{Name: "{{#if}}", Probe: "{{#if 1}}MARK1{{/if}}MARK2", Expect: marker1 + marker2},
+}
+
+// Engine is a template engine and the verification payload only it renders
+// as expected. The engines of a syntax are tried in order and the first one
+// that matches identifies it.
+type Engine struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Syntax   string `json:"syntax"`
+	Payload  string `json:"payload"`
+	Expect   string `json:"expect"`
+}
+
+// Engines are the verification payloads
+var Engines = []Engine{
+	// String repetition and filters together only exist in Jinja2
+	{Name: "Jinja2", Language: "Python", Syntax: "{{ }}", Payload: "{{('MARK1'*2)|lower}}", Expect: marker1 + marker1},
+	{Name: "Tornado", Language: "Python", Syntax: "{{ }}", Payload: "{% raw 'MARK1'*2 %}", Expect: marker1 + marker1},
+	// Twig ranges include their end
+	{Name: "Twig", Language: "PHP", Syntax: "{{ }}", Payload: "{{range(1,3)|join('MARK1')}}", Expect: "1" + marker1 + "2" + marker1 + "3"},
+	{Name: "Nunjucks", Language: "JavaScript", Syntax: "{{ }}", Payload: "{{'MARK1'.concat('MARK2')}}", Expect: marker1 + marker2},
+	{Name: "FreeMarker", Language: "Java", Syntax: "${ }", Payload: `${"MARK1"?trim}MARK2`, Expect: marker1 + marker2},
+	{Name: "Mako", Language: "Python", Syntax: "${ }", Payload: "${'MARK1'*2}", Expect: marker1 + marker1},
+	{Name: "Java EL / SpEL", Language: "Java", Syntax: "${ }", Payload: "${'MARK1'.concat('MARK2')}", Expect: marker1 + marker2},
+	{Name: "ERB", Language: "Ruby", Syntax: "<%= %>", Payload: "<%= 'MARK1'*2 %>", Expect: marker1 + marker1},
+	{Name: "EJS", Language: "JavaScript", Syntax: "<%= %>", Payload: "<%= 'MARK1'.concat('MARK2') %>", Expect: marker1 + marker2},
+	{Name: "Ruby interpolation (Slim, Haml)", Language: "Ruby", Syntax: "#{ }", Payload: "#{'MARK1'*2}", Expect: marker1 + marker1},
+	{Name: "SpEL", Language: "Java", Syntax: "#{ }", Payload: "#{T(java.lang.String).valueOf('MARK1').concat('MARK2')}", Expect: marker1 + marker2},
+	{Name: "Pug", Language: "JavaScript", Syntax: "#{ }", Payload: "#{'MARK1'.concat('MARK2')}", Expect: marker1 + marker2},
+	{Name: "Smarty", Language: "PHP", Syntax: "{ }", Payload: "{'MARK1'|cat:'MARK2'}", Expect: marker1 + marker2},
+	{Name: "Razor", Language: "C#", Syntax: "@( )", Payload: `@("MARK1" + "MARK2")`, Expect: marker1 + marker2},
+	{Name: "Thymeleaf", Language: "Java", Syntax: "[[${ }]]", Payload: "[[${'MARK1'.concat('MARK2')}]]", Expect: marker1 + marker2},
+	{Name: "Velocity", Language: "Java", Syntax: "#set", Payload: `#set($p="MARK1")${p.concat("MARK2")}`, Expect: marker1 + marker2},
+	{Name: "Go text/template", Language: "Go", Syntax: "{{printf}}", Payload: `{{printf "%s%s" "MARK1" "MARK2"}}`, Expect: marker1 + marker2},
+	{Name: "Handlebars", Language: "JavaScript", Syntax: "{{#if}}", Payload: "{{#with 'MARK1'}}{{this}}{{/with}}MARK2", Expect: marker1 + marker2},
+}
+
+// values are the random numbers and markers of one run
+type values struct {
+	n1, n2 int
+	m1, m2 string
+}
+
+// fill writes the values in a probe or an expected output
+func (v values) fill(template string) string {
+	return strings.NewReplacer(
+		product, strconv.Itoa(v.n1*v.n2),
+		number1, strconv.Itoa(v.n1),
+		number2, strconv.Itoa(v.n2),
+		marker1, v.m1,
+		marker2, v.m2,
+	).Replace(template)
+}
+
+// enginesFor returns the verification payloads of a syntax
+func enginesFor(syntax string) []Engine {
+	var out []Engine
+	for _, engine := range Engines {
+		if engine.Syntax == syntax {
+			out = append(out, engine)
+		}
+	}
+	return out
+}
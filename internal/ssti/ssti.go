@@ -0,0 +1,233 @@
+package ssti
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the template injection checker
+const Source = "ssti"
+
+// maxResponseBody bounds how much of a response is searched for output
+const maxResponseBody = 512 * 1024
+
+// Hit is a parameter whose value is evaluated as a template expression
+type Hit struct {
+	Parameter string `json:"parameter"`
+	Location  string `json:"location"` // query or body
+	Syntax    string `json:"syntax"`
+	Probe     string `json:"probe"`
+	Engine    string `json:"engine"` // "" when no verification payload matched
+	Language  string `json:"language"`
+	Payload   string `json:"payload"` // the verification payload that matched
+	Status    int    `json:"status"`
+	Evidence  string `json:"evidence"`
+}
+
+// Result is the outcome of checking a request
+type Result struct {
+	RequestID  int      `json:"requestId"`
+	URL        string   `json:"url"`
+	Parameters []string `json:"parameters"` // parameters that were tried
+	Hits       []Hit    `json:"hits"`
+}
+
+// Checker replays a request with template expressions in its parameters
+type Checker struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewChecker creates a new template injection checker
+func NewChecker(db *sql.DB, findingsClient *findings.Client) *Checker {
+	return &Checker{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run replaces the selected query and form body parameters of a stored
+// request, or all of them when names is empty, with the polyglot probe of
+// each syntax. When the evaluated output of a probe comes back, the
+// verification payloads of its syntax are sent to identify the engine. An
+// output already present in the original response never counts.
+func (c *Checker) Run(ctx context.Context, requestID int, names []string) (*Result, error) {
+	stored, err := probe.LoadParams(c.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, baseline, err := stored.Send(ctx, c.client, stored.Query, stored.Form, maxResponseBody)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	result := &Result{
+		RequestID:  requestID,
+		URL:        stored.Target.String(),
+		Parameters: []string{},
+		Hits:       []Hit{},
+	}
+
+	for _, location := range []string{probe.LocationQuery, probe.LocationBody} {
+		pairs := stored.Query
+		if location == probe.LocationBody {
+			pairs = stored.Form
+		}
+		for i, p := range pairs {
+			if len(selected) > 0 && !selected[p.Name] && !selected[probe.Decode(p.Name)] {
+				continue
+			}
+			result.Parameters = append(result.Parameters, probe.Decode(p.Name))
+			if hit := c.tryParam(ctx, stored, location, i, string(baseline)); hit != nil {
+				result.Hits = append(result.Hits, *hit)
+			}
+			if ctx.Err() != nil {
+				return result, nil
+			}
+		}
+	}
+	if len(result.Parameters) == 0 {
+		return nil, fmt.Errorf("none of the parameters %s were found in request %d", strings.Join(names, ", "), requestID)
+	}
+
+	for _, hit := range result.Hits {
+		c.record(stored, requestID, hit)
+	}
+	return result, nil
+}
+
+// tryParam sends the probe of every syntax for one parameter and, on the
+// first evaluated one, chains to the verification payloads of its engines
+func (c *Checker) tryParam(ctx context.Context, stored *probe.Request, location string, index int, baseline string) *Hit {
+	pairs := stored.Query
+	if location == probe.LocationBody {
+		pairs = stored.Form
+	}
+	original := pairs[index]
+
+	for _, syntax := range Syntaxes {
+		v := newValues()
+		polyglot, expect := v.fill(syntax.Probe), v.fill(syntax.Expect)
+		if ctx.Err() != nil {
+			return nil
+		}
+		status, body, err := stored.SendVariant(ctx, c.client, location, probe.ReplaceAt(pairs, index, probe.Pair{Name: original.Name, Value: url.QueryEscape(polyglot)}), maxResponseBody)
+		if err != nil || !evaluated(body, expect, baseline) {
+			continue
+		}
+
+		hit := &Hit{
+			Parameter: probe.Decode(original.Name),
+			Location:  location,
+			Syntax:    syntax.Name,
+			Probe:     polyglot,
+			Status:    status,
+			Evidence:  evidence(body, expect),
+		}
+		for _, engine := range enginesFor(syntax.Name) {
+			if ctx.Err() != nil {
+				return hit
+			}
+			v := newValues()
+			payload, expect := v.fill(engine.Payload), v.fill(engine.Expect)
+			status, body, err := stored.SendVariant(ctx, c.client, location, probe.ReplaceAt(pairs, index, probe.Pair{Name: original.Name, Value: url.QueryEscape(payload)}), maxResponseBody)
+			if err != nil || !evaluated(body, expect, baseline) {
+				continue
+			}
+			hit.Engine, hit.Language, hit.Payload = engine.Name, engine.Language, payload
+			hit.Status, hit.Evidence = status, evidence(body, expect)
+			break
+		}
+		return hit
+	}
+	return nil
+}
+
+// record stores an evaluated parameter as a finding, High once the engine is
+// identified
+func (c *Checker) record(stored *probe.Request, requestID int, hit Hit) {
+	severity := findings.SeverityMedium
+	detail := fmt.Sprintf("The %s probe %s was evaluated (status %d) but no engine verification payload matched\nEvidence: %s",
+		hit.Syntax, hit.Probe, hit.Status, hit.Evidence)
+	if hit.Engine != "" {
+		severity = findings.SeverityHigh
+		detail = fmt.Sprintf("The %s probe %s was evaluated and the %s payload %s identified %s (%s), status %d\nEvidence: %s",
+			hit.Syntax, hit.Probe, hit.Engine, hit.Payload, hit.Engine, hit.Language, hit.Status, hit.Evidence)
+	}
+	err := c.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      stored.Target.Hostname(),
+		URL:       stored.Target.String(),
+		RequestID: requestID,
+		Title:     fmt.Sprintf("Server-side template injection in %s parameter %s", hit.Location, hit.Parameter),
+		Severity:  severity,
+		Detail:    detail,
+		Key:       stored.Method + "|" + stored.Target.Host + stored.Target.Path + "|" + hit.Location + "|" + hit.Parameter,
+	})
+	if err != nil {
+		log.Printf("Failed to record template injection finding: %v", err)
+	}
+}
+
+// newValues draws the numbers and markers of one probe. The numbers have four
+// digits so their product is unlikely to show up by chance.
+func newValues() values {
+	return values{
+		n1: 1000 + rand.Intn(9000),
+		n2: 1000 + rand.Intn(9000),
+		m1: marker(),
+		m2: marker(),
+	}
+}
+
+// marker returns a random lowercase token
+func marker() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := []byte("pkz")
+	for i := 0; i < 7; i++ {
+		b = append(b, letters[rand.Intn(len(letters))])
+	}
+	return string(b)
+}
+
+// evaluated reports whether the expected output of an expression shows up in
+// a response and was not in the original one
+func evaluated(body []byte, expect, baseline string) bool {
+	return strings.Contains(string(body), expect) && !strings.Contains(baseline, expect)
+}
+
+// evidence returns the expected output with a little of its context
+func evidence(body []byte, expect string) string {
+	text := string(body)
+	i := strings.Index(text, expect)
+	if i < 0 {
+		return ""
+	}
+	start, end := max(0, i-40), min(len(text), i+len(expect)+40)
+	return strings.Join(strings.Fields(text[start:end]), " ")
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/docinspect"
+)
+
+// DefaultMaxBodySize is how much of a body is stored in the requests table
+// when the project does not set its own limit. Larger bodies go to blobs.
+const DefaultMaxBodySize = 10 * 1024 * 1024
+
+// BlobDirFor returns the directory holding the body blobs of the project
+// database at dbPath
+func BlobDirFor(dbPath string) string {
+	return strings.TrimSuffix(dbPath, filepath.Ext(dbPath)) + ".blobs"
+}
+
+// SetBlobDir sets where bodies over the size limit are written. Without a
+// directory they are truncated to the limit.
+func (s *RequestStorage) SetBlobDir(dir string) {
+	s.blobMu.Lock()
+	s.blobDir = dir
+	s.blobMu.Unlock()
+}
+
+// SetMaxBodySize sets how many bytes of a body are stored in the requests
+// table, 0 or less for DefaultMaxBodySize
+func (s *RequestStorage) SetMaxBodySize(size int64) {
+	if size <= 0 {
+		size = DefaultMaxBodySize
+	}
+	s.blobMu.Lock()
+	s.maxBodySize = size
+	s.blobMu.Unlock()
+}
+
+// MaxBodySize returns how many bytes of a body are stored in the requests table
+func (s *RequestStorage) MaxBodySize() int64 {
+	s.blobMu.RLock()
+	defer s.blobMu.RUnlock()
+	if s.maxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return s.maxBodySize
+}
+
+// BlobPath returns the path of a blob named in the requests table
+func (s *RequestStorage) BlobPath(name string) string {
+	s.blobMu.RLock()
+	defer s.blobMu.RUnlock()
+	if name == "" || s.blobDir == "" {
+		return ""
+	}
+	return filepath.Join(s.blobDir, filepath.Base(name))
+}
+
+// createBlob creates a new blob file, or returns nil when blobs are disabled
+func (s *RequestStorage) createBlob() (*os.File, error) {
+	s.blobMu.RLock()
+	dir := s.blobDir
+	s.blobMu.RUnlock()
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %v", err)
+	}
+	return os.CreateTemp(dir, "body-*.bin")
+}
+
+// removeBlob deletes a blob that is not going to be referenced
+func (s *RequestStorage) removeBlob(name string) {
+	if path := s.BlobPath(name); path != "" {
+		os.Remove(path)
+	}
+}
+
+// spillBody writes an in-memory body to a blob when it is larger than
+// MaxBodySize. It returns the blob name and whether the body is too large for
+// the requests table.
+func (s *RequestStorage) spillBody(body []byte) (string, bool) {
+	if int64(len(body)) <= s.MaxBodySize() {
+		return "", false
+	}
+
+	name := ""
+	file, err := s.createBlob()
+	if err != nil {
+		log.Printf("Failed to create body blob, truncating the body: %v", err)
+	} else if file != nil {
+		if _, err := file.Write(body); err != nil {
+			log.Printf("Failed to write body blob, truncating the body: %v", err)
+			file.Close()
+			os.Remove(file.Name())
+		} else {
+			file.Close()
+			name = filepath.Base(file.Name())
+		}
+	}
+	return name, true
+}
+
+// InspectArchive inspects a zip archive or Office document body, given with
+// its content encoding undone. Bodies without a content encoding are read from
+// their blob, as the stored part of a large archive misses its file list. It
+// returns nil for other bodies.
+func (s *RequestStorage) InspectArchive(contentEncoding string, body []byte, blob string) *docinspect.Inspection {
+	if !bytes.HasPrefix(body, []byte("PK\x03\x04")) {
+		return nil
+	}
+	if path := s.BlobPath(blob); path != "" && contentcoding.IsIdentity(contentEncoding) {
+		if file, err := os.Open(path); err == nil {
+			defer file.Close()
+			if info, err := file.Stat(); err == nil {
+				return docinspect.Inspect(file, info.Size())
+			}
+		}
+	}
+	return docinspect.InspectBytes(body)
+}
+
+// RemoveOrphanBlobs deletes the blobs no stored request refers to anymore,
+// left behind when requests were deleted
+func (s *RequestStorage) RemoveOrphanBlobs() (int, error) {
+	s.blobMu.RLock()
+	dir := s.blobDir
+	s.blobMu.RUnlock()
+	if dir == "" {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to list blobs: %v", err)
+	}
+
+	rows, err := s.db.Query("SELECT request_blob FROM requests WHERE request_blob != '' UNION SELECT response_blob FROM requests WHERE response_blob != ''")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list referenced blobs: %v", err)
+	}
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			referenced[name] = true
+		}
+	}
+	rows.Close()
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || referenced[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// BodyCapture copies a body streamed to the client. It keeps the first
+// MaxBodySize bytes in memory and writes the whole body to a blob once it
+// grows past that, so large downloads never sit in memory. Bodies of
+// do-not-log requests are never written to a blob.
+type BodyCapture struct {
+	body     io.ReadCloser
+	storage  *RequestStorage
+	limit    int64
+	head     bytes.Buffer
+	blob     *os.File
+	blobName string
+	noBlob   bool // blobs are disabled, the request is do-not-log or the blob could not be created
+	size     int64
+	complete bool
+	done     func(*BodyCapture)
+	once     sync.Once
+
+	// The head with its content encoding undone, set by Content
+	content     []byte
+	encoding    string
+	contentCut  bool
+	contentOnce sync.Once
+}
+
+// CaptureBody wraps the body of a response to req so it is recorded while the
+// client reads it. done runs once, when the body was read to the end or
+// closed.
+func (s *RequestStorage) CaptureBody(req *http.Request, body io.ReadCloser, done func(*BodyCapture)) *BodyCapture {
+	return &BodyCapture{body: body, storage: s, limit: s.MaxBodySize(), noBlob: s.doNotLogRequest(req), done: done}
+}
+
+// Read implements io.Reader
+func (c *BodyCapture) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if n > 0 {
+		c.record(p[:n])
+	}
+	if err == io.EOF {
+		c.complete = true
+		c.finish()
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (c *BodyCapture) Close() error {
+	err := c.body.Close()
+	c.finish()
+	return err
+}
+
+// Head returns the part of the body kept for the requests table
+func (c *BodyCapture) Head() []byte {
+	return c.head.Bytes()
+}
+
+// Content returns the part of the body stored in the requests table, the head
+// with its content encoding undone when possible
+func (c *BodyCapture) Content(contentEncoding string) []byte {
+	c.contentOnce.Do(func() {
+		c.content, c.encoding, c.contentCut = c.storage.storedContent(c.Head(), contentEncoding)
+	})
+	return c.content
+}
+
+// Decoded reports whether Content undid a content encoding
+func (c *BodyCapture) Decoded() bool {
+	return c.encoding != ""
+}
+
+// Size returns how many bytes of the body went through
+func (c *BodyCapture) Size() int64 {
+	return c.size
+}
+
+// Truncated reports whether Head holds only the start of the body
+func (c *BodyCapture) Truncated() bool {
+	return c.size > int64(c.head.Len())
+}
+
+// Complete reports whether the body was read to the end, rather than closed
+// early by the client
+func (c *BodyCapture) Complete() bool {
+	return c.complete
+}
+
+// BlobName returns the blob holding the whole body, "" when there is none
+func (c *BodyCapture) BlobName() string {
+	return c.blobName
+}
+
+// record appends read bytes to the head and, past the limit, to the blob
+func (c *BodyCapture) record(p []byte) {
+	c.size += int64(len(p))
+	if room := c.limit - int64(c.head.Len()); room > 0 && c.blob == nil {
+		if int64(len(p)) <= room {
+			c.head.Write(p)
+			return
+		}
+		c.head.Write(p[:room])
+	}
+
+	if c.blob == nil {
+		if c.noBlob {
+			return
+		}
+		file, err := c.storage.createBlob()
+		if err != nil || file == nil {
+			if err != nil {
+				log.Printf("Failed to create body blob, truncating the body: %v", err)
+			}
+			c.noBlob = true
+			return
+		}
+		c.blob = file
+		c.blobName = filepath.Base(file.Name())
+		// The blob holds the whole body, starting with the head
+		c.blob.Write(c.head.Bytes())
+		c.blob.Write(p[c.head.Len()-int(c.size-int64(len(p))):])
+		return
+	}
+	c.blob.Write(p)
+}
+
+// finish closes the blob and hands the capture over, once
+func (c *BodyCapture) finish() {
+	c.once.Do(func() {
+		if c.blob != nil {
+			c.blob.Close()
+		}
+		if c.done != nil {
+			c.done(c)
+		}
+	})
+}
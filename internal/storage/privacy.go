@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"prokzee/internal/hostaddr"
+)
+
+// DoNotLogRule marks a host, or a path of a host, whose request and response
+// bodies must never be written to the project. Requests it covers are stored
+// with their headers only.
+type DoNotLogRule struct {
+	ID   int    `json:"id"`
+	Host string `json:"host"` // exact host, or *.example.com for every subdomain
+	Path string `json:"path"` // path prefix, empty for the whole host
+}
+
+// Matches reports whether a rule covers a request to host and path
+func (r DoNotLogRule) Matches(host, path string) bool {
+	host = strings.ToLower(host)
+	pattern := strings.ToLower(r.Host)
+	if strings.HasPrefix(pattern, "*.") {
+		if host != pattern[2:] && !strings.HasSuffix(host, pattern[1:]) {
+			return false
+		}
+	} else if host != pattern {
+		return false
+	}
+	return strings.HasPrefix(path, r.Path)
+}
+
+// ensureDoNotLogTable creates the do_not_log table and loads its rules
+func (s *RequestStorage) ensureDoNotLogTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS do_not_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			host TEXT NOT NULL,
+			path TEXT DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create do_not_log table: %v", err)
+	}
+	return s.loadDoNotLog()
+}
+
+// loadDoNotLog reads the do-not-log rules into memory
+func (s *RequestStorage) loadDoNotLog() error {
+	rows, err := s.db.Query("SELECT id, host, COALESCE(path, '') FROM do_not_log")
+	if err != nil {
+		return fmt.Errorf("failed to load do-not-log rules: %v", err)
+	}
+	defer rows.Close()
+
+	rules := []DoNotLogRule{}
+	for rows.Next() {
+		var rule DoNotLogRule
+		if err := rows.Scan(&rule.ID, &rule.Host, &rule.Path); err != nil {
+			return fmt.Errorf("failed to scan do-not-log rule: %v", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	s.privacyMu.Lock()
+	s.doNotLog = rules
+	s.privacyMu.Unlock()
+	return rows.Err()
+}
+
+// AddDoNotLog marks a host, optionally restricted to a path prefix, as never
+// having its bodies persisted
+func (s *RequestStorage) AddDoNotLog(host, path string) (DoNotLogRule, error) {
+	rule := DoNotLogRule{Host: strings.TrimSpace(host), Path: strings.TrimSpace(path)}
+	if rule.Host == "" {
+		return rule, fmt.Errorf("host cannot be empty")
+	}
+
+	result, err := s.db.Exec("INSERT INTO do_not_log (host, path) VALUES (?, ?)", rule.Host, rule.Path)
+	if err != nil {
+		return rule, fmt.Errorf("failed to add do-not-log rule: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return rule, fmt.Errorf("failed to get do-not-log rule id: %v", err)
+	}
+	rule.ID = int(id)
+
+	s.privacyMu.Lock()
+	s.doNotLog = append(s.doNotLog, rule)
+	s.privacyMu.Unlock()
+	return rule, nil
+}
+
+// DeleteDoNotLog removes a do-not-log rule. Bodies that were never stored
+// are not recovered.
+func (s *RequestStorage) DeleteDoNotLog(id int) error {
+	if _, err := s.db.Exec("DELETE FROM do_not_log WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete do-not-log rule: %v", err)
+	}
+
+	s.privacyMu.Lock()
+	defer s.privacyMu.Unlock()
+	for i, rule := range s.doNotLog {
+		if rule.ID == id {
+			s.doNotLog = append(s.doNotLog[:i], s.doNotLog[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetDoNotLog returns the do-not-log rules
+func (s *RequestStorage) GetDoNotLog() []DoNotLogRule {
+	s.privacyMu.RLock()
+	defer s.privacyMu.RUnlock()
+	return append([]DoNotLogRule{}, s.doNotLog...)
+}
+
+// IsDoNotLog reports whether the bodies of requests to host and path must
+// not be persisted
+func (s *RequestStorage) IsDoNotLog(host, path string) bool {
+	s.privacyMu.RLock()
+	defer s.privacyMu.RUnlock()
+	for _, rule := range s.doNotLog {
+		if rule.Matches(host, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// doNotLogRequest reports whether the bodies of req must not be persisted
+func (s *RequestStorage) doNotLogRequest(req *http.Request) bool {
+	return s.IsDoNotLog(hostaddr.Canonical(req.URL.Hostname()), req.URL.Path)
+}
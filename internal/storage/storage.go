@@ -1,21 +1,63 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"prokzee/internal/contentcoding"
+	"prokzee/internal/docinspect"
+	"prokzee/internal/hostaddr"
+	"prokzee/internal/mediameta"
+	"prokzee/internal/mimesniff"
+	"prokzee/internal/models"
+
+	"github.com/elazarl/goproxy"
 )
 
 // RequestStorage handles storing HTTP requests and responses
 type RequestStorage struct {
 	db      *sql.DB
 	dbMutex *sync.RWMutex
+
+	doNotLog  []DoNotLogRule
+	privacyMu sync.RWMutex
+
+	// Bodies over maxBodySize are cut in the table and written to blobDir
+	blobDir     string
+	maxBodySize int64
+	blobMu      sync.RWMutex
+
+	// Labels the exchanges as they are stored, nil for none
+	tagger   Tagger
+	taggerMu sync.RWMutex
+
+	// Writes running in the background, drained before the database closes
+	pending   sync.WaitGroup
+	pendingN  int
+	draining  bool
+	pendingMu sync.Mutex
+}
+
+// Tagger computes the tags of an exchange when it is stored, from its
+// decoded bodies. resp is nil for requests without an answer.
+type Tagger interface {
+	Tags(req *http.Request, requestBody []byte, resp *http.Response, responseBody []byte) []string
+}
+
+// SetTagger sets what tags the exchanges stored from now on
+func (s *RequestStorage) SetTagger(tagger Tagger) {
+	s.taggerMu.Lock()
+	s.tagger = tagger
+	s.taggerMu.Unlock()
 }
 
 // NewRequestStorage creates a new RequestStorage instance
@@ -26,8 +68,338 @@ func NewRequestStorage(db *sql.DB, dbMutex *sync.RWMutex) *RequestStorage {
 	}
 }
 
-// StoreRequest stores a request and its response in the database
+// EnsureTableExists creates the requests table if it doesn't exist and adds
+// any columns introduced after the table was first created
+func (s *RequestStorage) EnsureTableExists() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS requests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			request_id TEXT,
+			url TEXT,
+			port TEXT,
+			request_headers TEXT,
+			request_body TEXT,
+			http_version TEXT,
+			response_headers TEXT,
+			response_body TEXT,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			method varchar NOT NULL DEFAULT 'GET',
+			status varchar NOT NULL DEFAULT '',
+			path TEXT DEFAULT '',
+			query TEXT DEFAULT '',
+			domain TEXT DEFAULT '',
+			length INTEGER DEFAULT 0,
+			mime_type TEXT DEFAULT '',
+			transfer_info TEXT DEFAULT '',
+			content_changed INTEGER DEFAULT 0,
+			body_redacted INTEGER DEFAULT 0,
+			sniffed_type TEXT DEFAULT '',
+			media_metadata TEXT DEFAULT '',
+			archive_info TEXT DEFAULT '',
+			request_encoding TEXT DEFAULT '',
+			response_encoding TEXT DEFAULT '',
+			request_blob TEXT DEFAULT '',
+			response_blob TEXT DEFAULT '',
+			body_truncated INTEGER DEFAULT 0,
+			correlation_id TEXT DEFAULT '',
+			tags TEXT DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create requests table: %v", err)
+	}
+
+	if err := EnsureColumn(s.db, "requests", "transfer_info", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "content_changed", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "body_redacted", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "sniffed_type", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "media_metadata", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "archive_info", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	for _, column := range []string{"request_encoding", "response_encoding"} {
+		if err := EnsureColumn(s.db, "requests", column, "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"request_blob", "response_blob"} {
+		if err := EnsureColumn(s.db, "requests", column, "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	if err := EnsureColumn(s.db, "requests", "body_truncated", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "correlation_id", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := EnsureColumn(s.db, "requests", "tags", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.ensureStreamEventsTable(); err != nil {
+		return err
+	}
+	return s.ensureDoNotLogTable()
+}
+
+// EnsureColumn adds a column to an existing table if it is missing
+func EnsureColumn(db *sql.DB, table, column, definition string) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check column %s.%s: %v", table, column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+	}
+	return nil
+}
+
+// TransferInfo describes how a message was framed on the wire before the
+// proxy normalized it, which matters when researching request smuggling.
+// Chunk boundaries are only known for messages whose bytes prokzee handled
+// itself, such as raw and chunked resender sends; net/http removes them from
+// proxied traffic before it is seen.
+type TransferInfo struct {
+	RequestTransferEncoding  []string    `json:"requestTransferEncoding,omitempty"`
+	RequestTrailers          http.Header `json:"requestTrailers,omitempty"`
+	RequestContentLength     int64       `json:"requestContentLength"`
+	RequestChunks            []Chunk     `json:"requestChunks,omitempty"`
+	ResponseTransferEncoding []string    `json:"responseTransferEncoding,omitempty"`
+	ResponseTrailers         http.Header `json:"responseTrailers,omitempty"`
+	ResponseContentLength    int64       `json:"responseContentLength"`
+	ResponseChunks           []Chunk     `json:"responseChunks,omitempty"`
+	HTTP2                    *StreamInfo `json:"http2,omitempty"`
+}
+
+// Chunk is one chunk of a chunked body. Offset is where its data starts in
+// the decoded body.
+type Chunk struct {
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Extension string `json:"extension,omitempty"` // after the ";" of the size line
+}
+
+// StreamInfo is the HTTP/2 framing of a request the client sent over h2,
+// which the stored URL and headers no longer show
+type StreamInfo struct {
+	Connection    int64             `json:"connection"`
+	Stream        int64             `json:"stream"` // order of the stream on its connection
+	PseudoHeaders map[string]string `json:"pseudoHeaders"`
+	// UpstreamProtocol is the protocol the server answered with when it
+	// differs from the client's, e.g. HTTP/1.1 for servers without h2
+	UpstreamProtocol string `json:"upstreamProtocol,omitempty"`
+}
+
+// TransferInfoJSON captures the transfer encoding and trailers of a request and
+// its response as JSON, along with the stream of requests received over HTTP/2.
+// It returns an empty string for HTTP/1.x messages sent with a plain
+// Content-Length and no trailers.
+func TransferInfoJSON(req *http.Request, resp *http.Response) string {
+	return NewTransferInfo(req, resp).JSON()
+}
+
+// NewTransferInfo captures the transfer encoding and trailers of a request and
+// its response, along with the stream of requests received over HTTP/2
+func NewTransferInfo(req *http.Request, resp *http.Response) TransferInfo {
+	info := TransferInfo{RequestContentLength: -1, ResponseContentLength: -1}
+	if req != nil {
+		info.HTTP2 = streamInfo(req, resp)
+		info.RequestTransferEncoding = req.TransferEncoding
+		info.RequestContentLength = req.ContentLength
+		if len(req.Trailer) > 0 {
+			info.RequestTrailers = req.Trailer.Clone()
+		}
+	}
+	if resp != nil {
+		info.ResponseTransferEncoding = resp.TransferEncoding
+		info.ResponseContentLength = resp.ContentLength
+		if len(resp.Trailer) > 0 {
+			info.ResponseTrailers = resp.Trailer.Clone()
+		}
+	}
+	return info
+}
+
+// JSON returns the framing as JSON, or an empty string when there is nothing
+// beyond a plain Content-Length to record
+func (info TransferInfo) JSON() string {
+	if len(info.RequestTransferEncoding) == 0 && len(info.ResponseTransferEncoding) == 0 &&
+		len(info.RequestTrailers) == 0 && len(info.ResponseTrailers) == 0 &&
+		len(info.RequestChunks) == 0 && len(info.ResponseChunks) == 0 && info.HTTP2 == nil {
+		return ""
+	}
+
+	jsonBytes, err := json.Marshal(info)
+	if err != nil {
+		return ""
+	}
+	return string(jsonBytes)
+}
+
+// MessageChunks returns the chunks of a raw HTTP/1.x message, or nil when it
+// is not chunked. A truncated or malformed body yields the chunks read so far.
+func MessageChunks(raw []byte) []Chunk {
+	head, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		return nil
+	}
+	chunked := false
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "Transfer-Encoding") {
+			continue
+		}
+		codings := strings.Split(value, ",")
+		chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+	}
+	if !chunked {
+		return nil
+	}
+
+	var chunks []Chunk
+	var offset int64
+	for {
+		line, rest, found := bytes.Cut(body, []byte("\n"))
+		if !found {
+			return chunks
+		}
+		sizeField, extension, _ := strings.Cut(strings.TrimSpace(string(line)), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size <= 0 {
+			return chunks
+		}
+		chunks = append(chunks, Chunk{Offset: offset, Size: size, Extension: strings.TrimSpace(extension)})
+		offset += size
+		if int64(len(rest)) < size {
+			return chunks
+		}
+		body = bytes.TrimPrefix(bytes.TrimPrefix(rest[size:], []byte("\r")), []byte("\n"))
+	}
+}
+
+// streamInfo returns the HTTP/2 stream a request arrived on, or nil for
+// requests received over HTTP/1.x
+func streamInfo(req *http.Request, resp *http.Response) *StreamInfo {
+	stream := goproxy.H2StreamFromContext(req.Context())
+	if stream == nil {
+		return nil
+	}
+	info := &StreamInfo{
+		Connection: stream.Connection,
+		Stream:     stream.Sequence,
+		PseudoHeaders: map[string]string{
+			":method":    stream.Method,
+			":scheme":    stream.Scheme,
+			":authority": stream.Authority,
+			":path":      stream.Path,
+		},
+	}
+	if resp != nil {
+		info.PseudoHeaders[":status"] = strconv.Itoa(resp.StatusCode)
+		if resp.Proto != "" && resp.Proto != req.Proto {
+			info.UpstreamProtocol = resp.Proto
+		}
+	}
+	return info
+}
+
+// StoreRequest stores a request and its response in the database. Bodies
+// larger than MaxBodySize are cut in the requests table and kept whole in a
+// blob.
 func (s *RequestStorage) StoreRequest(req *http.Request, resp *http.Response) (string, int, error) {
+	var responseBytes []byte
+	if resp != nil && resp.Body != nil {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read response body: %v", err)
+		}
+		resp.Body.Close()
+		fmt.Printf("Debug: Response body length before storage: %d bytes\n", len(bodyBytes))
+
+		// Restore the body for future use
+		resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		responseBytes = bodyBytes
+	}
+
+	// The bodies of do-not-log requests never reach the disk, not even
+	// for a moment
+	blob, truncated := "", false
+	if !s.doNotLogRequest(req) {
+		blob, truncated = s.spillBody(responseBytes)
+	}
+	contentEncoding := ""
+	if resp != nil {
+		contentEncoding = resp.Header.Get("Content-Encoding")
+	}
+	content, encoding, cut := s.storedContent(responseBytes, contentEncoding)
+	return s.storeRequest(req, resp, storedBody{
+		content:   content,
+		encoding:  encoding,
+		blob:      blob,
+		truncated: truncated || cut,
+		size:      int64(len(responseBytes)),
+	})
+}
+
+// StoreCapturedRequest stores a request whose response body was streamed to
+// the client through a BodyCapture. The body of resp is ignored.
+func (s *RequestStorage) StoreCapturedRequest(req *http.Request, resp *http.Response, capture *BodyCapture) (string, int, error) {
+	content := capture.Content(resp.Header.Get("Content-Encoding"))
+	return s.storeRequest(req, resp, storedBody{
+		content:   content,
+		encoding:  capture.encoding,
+		blob:      capture.BlobName(),
+		truncated: capture.Truncated() || capture.contentCut,
+		size:      capture.Size(),
+	})
+}
+
+// storedBody is a response body as it goes into the requests table
+type storedBody struct {
+	content   []byte // the part stored in the table, content encoding undone
+	encoding  string // content encoding undone, if any
+	blob      string // blob holding the whole body as received, if any
+	truncated bool   // content holds only the start of the response
+	size      int64
+}
+
+// storedContent returns the part of a body kept in the requests table: at
+// most MaxBodySize bytes with the content encoding undone, so history search
+// and the viewers work on plaintext. It also returns the encoding undone, ""
+// when the body is kept as received, and whether the content was cut. A body
+// in an unknown or corrupt encoding is kept as received.
+func (s *RequestStorage) storedContent(body []byte, contentEncoding string) ([]byte, string, bool) {
+	limit := s.MaxBodySize()
+	encoding := ""
+	if !contentcoding.IsIdentity(contentEncoding) && contentcoding.Supported(contentEncoding) {
+		// The start of a cut body still decodes up to where it was cut
+		if decoded, err := contentcoding.Decode(body, contentEncoding, limit+1); err == nil || len(decoded) > 0 {
+			body = decoded
+			encoding = strings.Join(contentcoding.Codings(contentEncoding), ", ")
+		}
+	}
+	if int64(len(body)) > limit {
+		return body[:limit], encoding, true
+	}
+	return body, encoding, false
+}
+
+// storeRequest inserts a request and its response with the given body
+func (s *RequestStorage) storeRequest(req *http.Request, resp *http.Response, response storedBody) (string, int, error) {
 	// Lock for database operations
 	s.dbMutex.Lock()
 	defer s.dbMutex.Unlock()
@@ -47,21 +419,30 @@ func (s *RequestStorage) StoreRequest(req *http.Request, resp *http.Response) (s
 	// Extract request details
 	requestHeaders := headerToString(req.Header)
 
+	// Requests marked as do-not-log keep only their headers, nothing read
+	// from their bodies
+	bodyRedacted := s.doNotLogRequest(req)
+
 	// Read and restore request body
-	var requestBody string
+	var requestBody, requestBlob, requestEncoding string
+	requestTruncated := false
 	if req.Body != nil {
 		bodyBytes, err := io.ReadAll(req.Body)
 		if err != nil {
 			return "", 0, fmt.Errorf("failed to read request body: %v", err)
 		}
-		requestBody = string(bodyBytes)
 		// Restore the body for future use
-		req.Body = io.NopCloser(strings.NewReader(requestBody))
+		req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 		fmt.Printf("Debug: Request body length before storage: %d bytes\n", len(bodyBytes))
+		if !bodyRedacted {
+			requestBlob, requestTruncated = s.spillBody(bodyBytes)
+		}
+		content, encoding, cut := s.storedContent(bodyBytes, req.Header.Get("Content-Encoding"))
+		requestBody, requestEncoding, requestTruncated = string(content), encoding, requestTruncated || cut
 	}
 
 	// Extract URL components
-	domain := req.URL.Hostname()
+	domain := hostaddr.Canonical(req.URL.Hostname())
 	port := req.URL.Port()
 	if port == "" {
 		if req.URL.Scheme == "http" {
@@ -80,22 +461,28 @@ func (s *RequestStorage) StoreRequest(req *http.Request, resp *http.Response) (s
 	var status sql.NullString
 	var length sql.NullInt64
 	var mimeType sql.NullString
+	var sniffedType string
+	var mediaMetadata string
+	var archiveInfo string
 
 	// Extract response details if available
 	if resp != nil {
 		responseHeaders = sql.NullString{String: headerToString(resp.Header), Valid: true}
-		if resp.Body != nil {
-			bodyBytes, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return "", 0, fmt.Errorf("failed to read response body: %v", err)
+		if resp.Body != nil || response.size > 0 {
+			responseBody = sql.NullString{String: string(response.content), Valid: true}
+			if !bodyRedacted {
+				sniffEncoding := resp.Header.Get("Content-Encoding")
+				if response.encoding != "" {
+					sniffEncoding = ""
+				}
+				sniffedType = mimesniff.SniffBody(response.content, sniffEncoding)
+				if strings.HasPrefix(sniffedType, "image/") {
+					mediaMetadata = mediameta.JSON(response.content)
+				}
+				if sniffedType == "application/zip" {
+					archiveInfo = docinspect.JSON(s.InspectArchive(resp.Header.Get("Content-Encoding"), response.content, response.blob))
+				}
 			}
-			resp.Body.Close()
-
-			responseBody = sql.NullString{String: string(bodyBytes), Valid: true}
-			fmt.Printf("Debug: Response body length before storage: %d bytes\n", len(bodyBytes))
-
-			// Restore the body for future use
-			resp.Body = io.NopCloser(strings.NewReader(responseBody.String))
 		}
 
 		if resp.Status != "" {
@@ -103,28 +490,64 @@ func (s *RequestStorage) StoreRequest(req *http.Request, resp *http.Response) (s
 		}
 		if resp.ContentLength != -1 {
 			length = sql.NullInt64{Int64: resp.ContentLength, Valid: true}
+		} else if response.truncated {
+			length = sql.NullInt64{Int64: response.size, Valid: true}
 		}
 		if ct := resp.Header.Get("Content-Type"); ct != "" {
 			mimeType = sql.NullString{String: ct, Valid: true}
 		}
 	}
+	bodyTruncated := requestTruncated || response.truncated
+
+	// Capture framing details once the bodies (and any trailers) have been read
+	transferInfo := TransferInfoJSON(req, resp)
+
+	// Keep only the headers of requests marked as do-not-log
+	if bodyRedacted {
+		requestBody = ""
+		if responseBody.Valid {
+			responseBody.String = ""
+		}
+		// A capture started before the host was marked may have spilled
+		s.removeBlob(response.blob)
+		response.blob = ""
+	}
+
+	// The ID the request carried upstream in the correlation header, if any
+	correlationID, _ := req.Context().Value(models.CorrelationIDKey).(string)
+
+	// Tags are computed from what is stored, without the bodies of
+	// do-not-log requests
+	s.taggerMu.RLock()
+	tagger := s.tagger
+	s.taggerMu.RUnlock()
+	var tags string
+	if tagger != nil {
+		responseContent := response.content
+		if bodyRedacted {
+			responseContent = nil
+		}
+		tags = strings.Join(tagger.Tags(req, []byte(requestBody), resp, responseContent), ",")
+	}
 
 	// Insert a new request
 	result, err := tx.ExecContext(ctx, `
-		INSERT INTO requests (url, method, domain, port, path, query, request_headers, request_body, http_version, response_headers, response_body, status, length, mime_type)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		INSERT INTO requests (url, method, domain, port, path, query, request_headers, request_body, http_version, response_headers, response_body, status, length, mime_type, transfer_info, body_redacted, sniffed_type, media_metadata, archive_info, request_encoding, response_encoding, request_blob, response_blob, body_truncated, correlation_id, tags)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		req.URL.String(), req.Method, domain, port, path, query, requestHeaders, requestBody, httpVersion,
-		responseHeaders, responseBody, status, length, mimeType,
+		responseHeaders, responseBody, status, length, mimeType, transferInfo, bodyRedacted, sniffedType, mediaMetadata, archiveInfo, requestEncoding, response.encoding,
+		requestBlob, response.blob, bodyTruncated, correlationID, tags,
 	)
 	if err != nil {
 		if strings.Contains(err.Error(), "database is locked") {
 			// If database is locked, wait briefly and retry once
 			time.Sleep(100 * time.Millisecond)
 			result, err = tx.ExecContext(ctx, `
-				INSERT INTO requests (url, method, domain, port, path, query, request_headers, request_body, http_version, response_headers, response_body, status, length, mime_type)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				INSERT INTO requests (url, method, domain, port, path, query, request_headers, request_body, http_version, response_headers, response_body, status, length, mime_type, transfer_info, body_redacted, sniffed_type, media_metadata, archive_info, request_encoding, response_encoding, request_blob, response_blob, body_truncated, correlation_id, tags)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 				req.URL.String(), req.Method, domain, port, path, query, requestHeaders, requestBody, httpVersion,
-				responseHeaders, responseBody, status, length, mimeType,
+				responseHeaders, responseBody, status, length, mimeType, transferInfo, bodyRedacted, sniffedType, mediaMetadata, archiveInfo, requestEncoding, response.encoding,
+				requestBlob, response.blob, bodyTruncated, correlationID, tags,
 			)
 			if err != nil {
 				return "", 0, fmt.Errorf("failed to insert request after retry: %v", err)
@@ -156,6 +579,74 @@ func (s *RequestStorage) StoreRequest(req *http.Request, resp *http.Response) (s
 	return fmt.Sprintf("Inserted request with id: %d", id), id, nil
 }
 
+// Async runs a storage task in the background, tracked so that Drain can wait
+// for it. It returns false without running the task once draining started.
+func (s *RequestStorage) Async(task func()) bool {
+	s.pendingMu.Lock()
+	if s.draining {
+		s.pendingMu.Unlock()
+		return false
+	}
+	s.pending.Add(1)
+	s.pendingN++
+	s.pendingMu.Unlock()
+
+	go func() {
+		defer func() {
+			s.pendingMu.Lock()
+			s.pendingN--
+			s.pendingMu.Unlock()
+			s.pending.Done()
+		}()
+		task()
+	}()
+	return true
+}
+
+// Drain stops accepting background writes and waits for the running ones. It
+// returns the number of writes still running when the timeout expired.
+func (s *RequestStorage) Drain(timeout time.Duration) int {
+	s.pendingMu.Lock()
+	s.draining = true
+	s.pendingMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+		s.pendingMu.Lock()
+		defer s.pendingMu.Unlock()
+		return s.pendingN
+	}
+}
+
+// DeleteByURLPrefix removes the stored requests whose URL starts with prefix
+// and returns how many were removed
+func (s *RequestStorage) DeleteByURLPrefix(prefix string) (int, error) {
+	s.dbMutex.Lock()
+	defer s.dbMutex.Unlock()
+
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+	result, err := s.db.Exec(`DELETE FROM requests WHERE url LIKE ? ESCAPE '\'`, escaped+"%")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete requests: %v", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted requests: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM stream_events WHERE request_id NOT IN (SELECT id FROM requests)`); err != nil {
+		return 0, fmt.Errorf("failed to delete stream events: %v", err)
+	}
+	return int(deleted), nil
+}
+
 // Helper function to read body as string
 func readBody(body io.ReadCloser) (string, error) {
 	defer body.Close()
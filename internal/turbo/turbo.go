@@ -0,0 +1,424 @@
+package turbo
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+const (
+	// DefaultConnections is how many connections are opened when no number is given
+	DefaultConnections = 8
+	// MaxConnections bounds the connections of one run
+	MaxConnections = 256
+	// DefaultRequests is how many requests are sent when no number is given
+	DefaultRequests = 10000
+	// MaxRequests bounds the requests of one run
+	MaxRequests = 10000000
+	// MaxPipeline bounds how many requests wait for an answer on one connection
+	MaxPipeline = 100
+)
+
+// requestTimeout bounds the wait for one response
+const requestTimeout = 10 * time.Second
+
+// progressInterval is how often a snapshot is reported while running
+const progressInterval = time.Second
+
+// maxStatus bounds the status codes counted one by one, others count as 0
+const maxStatus = 600
+
+// hopHeaders are not replayed, they describe the original connection
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Te", "Content-Length", "Host"}
+
+// Config describes a run
+type Config struct {
+	RequestID   int `json:"requestId"`
+	Connections int `json:"connections"`
+	Requests    int `json:"requests"`
+	// Pipeline is how many requests are written ahead of their answers on a
+	// connection, 1 to wait for each answer
+	Pipeline int `json:"pipeline"`
+}
+
+// StatusCount is how many answers had one status code
+type StatusCount struct {
+	Status int    `json:"status"`
+	Count  uint64 `json:"count"`
+}
+
+// Snapshot is the progress of a run
+type Snapshot struct {
+	URL         string        `json:"url"`
+	Method      string        `json:"method"`
+	Requests    int           `json:"requests"` // to send
+	Completed   uint64        `json:"completed"`
+	Errors      uint64        `json:"errors"`
+	ErrorRate   float64       `json:"errorRate"` // errors per request over the last interval
+	RPS         float64       `json:"rps"`       // answers per second over the last interval
+	AverageRPS  float64       `json:"averageRps"`
+	AverageMs   float64       `json:"averageMs"` // latency over the last interval
+	MaxMs       float64       `json:"maxMs"`     // over the last interval
+	BytesIn     uint64        `json:"bytesIn"`
+	Connections int64         `json:"connections"` // open right now
+	Reconnects  uint64        `json:"reconnects"`
+	Statuses    []StatusCount `json:"statuses"`
+	LastError   string        `json:"lastError,omitempty"`
+	ElapsedMs   float64       `json:"elapsedMs"`
+	Done        bool          `json:"done"`
+
+	latency int64 // microseconds summed over the answers so far
+}
+
+// Engine sends one stored request at a high rate
+type Engine struct {
+	db *sql.DB
+}
+
+// NewEngine creates a new high rate request engine
+func NewEngine(db *sql.DB) *Engine {
+	return &Engine{db: db}
+}
+
+// run is the state of one run, shared by its connections
+type run struct {
+	target   *url.URL
+	method   string
+	raw      []byte // the request, serialized once
+	total    int64
+	pipeline int
+
+	claimed     atomic.Int64
+	completed   atomic.Uint64
+	errors      atomic.Uint64
+	bytesIn     atomic.Uint64
+	latency     atomic.Int64 // microseconds, summed
+	maxLatency  atomic.Int64 // microseconds, since the last snapshot
+	connections atomic.Int64
+	reconnects  atomic.Uint64
+	statuses    [maxStatus]atomic.Uint64
+
+	mu        sync.Mutex
+	lastError string
+}
+
+// Run sends Requests copies of a stored request over Connections kept alive
+// connections, writing Pipeline requests ahead on each. The request is
+// serialized once and answers are read into reused buffers and discarded, so
+// the rate is bound by the server and the network rather than the client.
+// progress is called every second with the counts so far.
+func (e *Engine) Run(ctx context.Context, config Config, progress func(Snapshot)) (*Snapshot, error) {
+	if config.Connections == 0 {
+		config.Connections = DefaultConnections
+	}
+	if config.Connections < 1 || config.Connections > MaxConnections {
+		return nil, fmt.Errorf("the number of connections must be between 1 and %d", MaxConnections)
+	}
+	if config.Requests == 0 {
+		config.Requests = DefaultRequests
+	}
+	if config.Requests < 1 || config.Requests > MaxRequests {
+		return nil, fmt.Errorf("the number of requests must be between 1 and %d", MaxRequests)
+	}
+	if config.Pipeline == 0 {
+		config.Pipeline = 1
+	}
+	if config.Pipeline < 1 || config.Pipeline > MaxPipeline {
+		return nil, fmt.Errorf("the pipeline depth must be between 1 and %d", MaxPipeline)
+	}
+
+	r, err := e.load(config.RequestID)
+	if err != nil {
+		return nil, err
+	}
+	r.total, r.pipeline = int64(config.Requests), config.Pipeline
+
+	// Fail fast on an unreachable target rather than with every request
+	conn, err := dial(ctx, r.target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", r.target.Host, err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < config.Connections; i++ {
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			r.worker(ctx, conn)
+		}(conn)
+		conn = nil
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	last, lastAt := &Snapshot{}, start
+	for {
+		select {
+		case <-done:
+			snapshot := r.snapshot(last, lastAt, start)
+			snapshot.Done = true
+			return snapshot, nil
+		case now := <-ticker.C:
+			snapshot := r.snapshot(last, lastAt, start)
+			last, lastAt = snapshot, now
+			if progress != nil {
+				progress(*snapshot)
+			}
+		}
+	}
+}
+
+// snapshot sums up the run, with rates since the previous snapshot
+func (r *run) snapshot(previous *Snapshot, previousAt, start time.Time) *Snapshot {
+	now := time.Now()
+	s := &Snapshot{
+		URL:         r.target.String(),
+		Method:      r.method,
+		Requests:    int(r.total),
+		Completed:   r.completed.Load(),
+		Errors:      r.errors.Load(),
+		BytesIn:     r.bytesIn.Load(),
+		Connections: r.connections.Load(),
+		Reconnects:  r.reconnects.Load(),
+		Statuses:    []StatusCount{},
+		ElapsedMs:   milliseconds(now.Sub(start)),
+		MaxMs:       float64(r.maxLatency.Swap(0)) / 1000,
+	}
+	latency := r.latency.Load()
+	if seconds := now.Sub(start).Seconds(); seconds > 0 {
+		s.AverageRPS = float64(s.Completed) / seconds
+	}
+	if seconds := now.Sub(previousAt).Seconds(); seconds > 0 {
+		s.RPS = float64(s.Completed-previous.Completed) / seconds
+	}
+	if answered := s.Completed - previous.Completed; answered > 0 {
+		s.AverageMs = float64(latency-previous.latency) / float64(answered) / 1000
+	}
+	if sent := s.Completed + s.Errors - previous.Completed - previous.Errors; sent > 0 {
+		s.ErrorRate = float64(s.Errors-previous.Errors) / float64(sent)
+	}
+	s.latency = latency
+
+	for status := range r.statuses {
+		if count := r.statuses[status].Load(); count > 0 {
+			s.Statuses = append(s.Statuses, StatusCount{Status: status, Count: count})
+		}
+	}
+	sort.Slice(s.Statuses, func(i, j int) bool { return s.Statuses[i].Count > s.Statuses[j].Count })
+
+	r.mu.Lock()
+	s.LastError = r.lastError
+	r.mu.Unlock()
+	return s
+}
+
+// claim reserves the next request to send, false once all were sent
+func (r *run) claim() bool {
+	if r.claimed.Add(1) > r.total {
+		r.claimed.Add(-1)
+		return false
+	}
+	return true
+}
+
+// fail counts a request that got no answer
+func (r *run) fail(err error) {
+	r.errors.Add(1)
+	r.mu.Lock()
+	r.lastError = err.Error()
+	r.mu.Unlock()
+}
+
+// worker sends requests over one connection at a time until every request
+// was claimed, reconnecting whenever the server closes the connection
+func (r *run) worker(ctx context.Context, conn net.Conn) {
+	for ctx.Err() == nil && r.claimed.Load() < r.total {
+		if conn == nil {
+			var err error
+			if conn, err = dial(ctx, r.target); err != nil {
+				if ctx.Err() == nil && r.claim() {
+					r.fail(err)
+				}
+				continue
+			}
+			r.reconnects.Add(1)
+		}
+		r.serve(ctx, conn)
+		conn = nil
+	}
+}
+
+// serve writes requests on conn, up to pipeline ahead of the answers, until
+// the connection fails or closes. Requests written but not answered when the
+// server closes the connection cleanly are sent again on the next one.
+func (r *run) serve(ctx context.Context, conn net.Conn) {
+	r.connections.Add(1)
+	defer r.connections.Add(-1)
+	stop := make(chan struct{})
+	var closeOnce sync.Once
+	closeConn := func() { closeOnce.Do(func() { close(stop); conn.Close() }) }
+	defer closeConn()
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeConn()
+		case <-stop:
+		}
+	}()
+
+	// Each request written is announced with the time it left, the capacity
+	// bounds how many are waiting for an answer
+	inflight := make(chan time.Time, r.pipeline)
+	go func() {
+		defer close(inflight)
+		for r.claim() {
+			select {
+			case inflight <- time.Now():
+			case <-stop:
+				r.claimed.Add(-1)
+				return
+			}
+			if _, err := conn.Write(r.raw); err != nil {
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReaderSize(conn, 32*1024)
+	buffer := make([]byte, 32*1024)
+	request := &http.Request{Method: r.method}
+	answered := 0
+	for sent := range inflight {
+		conn.SetReadDeadline(time.Now().Add(requestTimeout))
+		resp, err := http.ReadResponse(reader, request)
+		if err != nil {
+			closeConn()
+			// A connection closed cleanly after answering, such as on a
+			// keep-alive limit, only loses requests that are sent again
+			if ctx.Err() != nil || (err == io.EOF && answered > 0) {
+				r.claimed.Add(-1)
+			} else {
+				r.fail(err)
+			}
+			r.requeue(inflight)
+			return
+		}
+		n, err := io.CopyBuffer(io.Discard, resp.Body, buffer)
+		resp.Body.Close()
+		r.bytesIn.Add(uint64(n))
+		if err != nil {
+			closeConn()
+			if ctx.Err() != nil {
+				r.claimed.Add(-1)
+			} else {
+				r.fail(err)
+			}
+			r.requeue(inflight)
+			return
+		}
+		answered++
+
+		elapsed := time.Since(sent).Microseconds()
+		r.latency.Add(elapsed)
+		r.observeMax(elapsed)
+		status := resp.StatusCode
+		if status < 0 || status >= maxStatus {
+			status = 0
+		}
+		r.statuses[status].Add(1)
+		r.completed.Add(1)
+
+		if resp.Close {
+			closeConn()
+			r.requeue(inflight)
+			return
+		}
+	}
+}
+
+// observeMax raises the highest latency since the last snapshot
+func (r *run) observeMax(elapsed int64) {
+	for {
+		max := r.maxLatency.Load()
+		if elapsed <= max || r.maxLatency.CompareAndSwap(max, elapsed) {
+			return
+		}
+	}
+}
+
+// requeue gives back the requests written to a closed connection that will
+// never be answered, they are sent again on another connection
+func (r *run) requeue(inflight chan time.Time) {
+	for range inflight {
+		r.claimed.Add(-1)
+	}
+}
+
+// load reads and serializes the stored request
+func (e *Engine) load(requestID int) (*run, error) {
+	stored, err := probe.Load(e.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Target.Scheme != "http" && stored.Target.Scheme != "https" {
+		return nil, fmt.Errorf("invalid request URL %q", stored.Target.String())
+	}
+	for _, header := range hopHeaders {
+		stored.Header.Del(header)
+	}
+	return &run{target: stored.Target, method: stored.Method, raw: stored.HTTP1("keep-alive")}, nil
+}
+
+// dial connects to the target through the upstream chain, with TLS for https
+// limited to HTTP/1.1 so requests can be pipelined
+func dial(ctx context.Context, target *url.URL) (net.Conn, error) {
+	port := target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+	dialCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	conn, err := upstream.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := upstream.WithClientCertificate(&tls.Config{InsecureSkipVerify: true}, addr)
+	config.NextProtos = []string{"http/1.1"}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	return tlsConn, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
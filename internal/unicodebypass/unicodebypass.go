@@ -0,0 +1,199 @@
+package unicodebypass
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Techniques of the variants
+const (
+	// TechniqueCase changes the case of the ASCII letters
+	TechniqueCase = "case"
+	// TechniqueCaseMapping uses the letters whose upper or lower case is an
+	// ASCII letter, such as ſ for S, ı for I and the Kelvin sign for k
+	TechniqueCaseMapping = "case-mapping"
+	// TechniqueCompatibility uses characters that NFKC or NFKD normalize to
+	// the ASCII ones: fullwidth, mathematical, circled, small, superscript,
+	// ligature and dot leader forms
+	TechniqueCompatibility = "compatibility"
+	// TechniqueDecomposed uses precomposed characters whose NFD form is the
+	// ASCII character followed by a combining mark, for filters that strip
+	// the marks after decomposing
+	TechniqueDecomposed = "decomposed"
+	// TechniqueHomoglyph uses look-alike characters of other scripts, which no
+	// normalization maps back
+	TechniqueHomoglyph = "homoglyph"
+)
+
+// Techniques lists every technique, in the order the variants are produced
+var Techniques = []string{TechniqueCase, TechniqueCaseMapping, TechniqueCompatibility, TechniqueDecomposed, TechniqueHomoglyph}
+
+// Variant is a transformed value
+type Variant struct {
+	Technique string `json:"technique"`
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+}
+
+// Variants transforms value with the given techniques, all of them when none
+// is given. Variants equal to the value or to an earlier variant are left out.
+func Variants(value string, techniques []string) []Variant {
+	if len(techniques) == 0 {
+		techniques = Techniques
+	}
+	selected := make(map[string]bool)
+	for _, technique := range techniques {
+		selected[strings.ToLower(strings.TrimSpace(technique))] = true
+	}
+
+	var variants []Variant
+	seen := map[string]bool{value: true}
+	add := func(technique, name, transformed string) {
+		if !seen[transformed] {
+			seen[transformed] = true
+			variants = append(variants, Variant{Technique: technique, Name: name, Value: transformed})
+		}
+	}
+
+	for _, technique := range Techniques {
+		if !selected[technique] {
+			continue
+		}
+		switch technique {
+		case TechniqueCase:
+			add(technique, "upper", strings.ToUpper(value))
+			add(technique, "lower", strings.ToLower(value))
+			add(technique, "alternating", alternateCase(value))
+		case TechniqueCaseMapping:
+			add(technique, "upper case mapping", mapRunes(value, upperMappings))
+			add(technique, "lower case mapping", mapRunes(value, lowerMappings))
+		case TechniqueCompatibility:
+			add(technique, "fullwidth", mapRunes(value, fullwidth))
+			add(technique, "mathematical bold", mapRunes(value, mathBold))
+			add(technique, "circled", mapRunes(value, circled))
+			add(technique, "small forms", mapRunes(value, smallForms))
+			add(technique, "superscript", mapRunes(value, superscripts))
+			add(technique, "ligatures", ligatures.Replace(value))
+			add(technique, "dot leaders", dotLeaders.Replace(value))
+		case TechniqueDecomposed:
+			add(technique, "precomposed", mapRunes(value, precomposed))
+		case TechniqueHomoglyph:
+			add(technique, "cyrillic", mapRunes(value, cyrillic))
+			add(technique, "punctuation", mapRunes(value, punctuation))
+		}
+	}
+	return variants
+}
+
+// Values returns the values of the variants of value, for the fuzzer
+func Values(value string, techniques []string) []string {
+	var values []string
+	for _, variant := range Variants(value, techniques) {
+		values = append(values, variant.Value)
+	}
+	return values
+}
+
+// mapRunes replaces the characters that have a mapping and keeps the others
+func mapRunes(value string, mapping func(rune) (rune, bool)) string {
+	return strings.Map(func(r rune) rune {
+		if mapped, ok := mapping(r); ok {
+			return mapped
+		}
+		return r
+	}, value)
+}
+
+// alternateCase writes the letters in alternating case, upper first
+func alternateCase(value string) string {
+	upper := true
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) {
+			return r
+		}
+		defer func() { upper = !upper }()
+		if upper {
+			return unicode.ToUpper(r)
+		}
+		return unicode.ToLower(r)
+	}, value)
+}
+
+// fromTable maps the characters of a table
+func fromTable(table map[rune]rune) func(rune) (rune, bool) {
+	return func(r rune) (rune, bool) {
+		mapped, ok := table[r]
+		return mapped, ok
+	}
+}
+
+// fromRange maps the ASCII characters of a range to a block starting at base
+func fromRange(first, last, base rune) func(rune) (rune, bool) {
+	return func(r rune) (rune, bool) {
+		if r >= first && r <= last {
+			return base + r - first, true
+		}
+		return 0, false
+	}
+}
+
+// anyOf tries the mappings in order
+func anyOf(mappings ...func(rune) (rune, bool)) func(rune) (rune, bool) {
+	return func(r rune) (rune, bool) {
+		for _, mapping := range mappings {
+			if mapped, ok := mapping(r); ok {
+				return mapped, true
+			}
+		}
+		return 0, false
+	}
+}
+
+var (
+	// ſ upper cases to S and ı to I
+	upperMappings = fromTable(map[rune]rune{'s': '\u017f', 'i': '\u0131'})
+
+	// The Kelvin sign lower cases to k
+	lowerMappings = fromTable(map[rune]rune{'k': '\u212a', 'K': '\u212a'})
+
+	// Fullwidth forms cover every printable ASCII character but the space
+	fullwidth = fromRange('!', '~', '\uff01')
+
+	mathBold = anyOf(fromRange('A', 'Z', '𝐀'), fromRange('a', 'z', '𝐚'), fromRange('0', '9', '𝟎'))
+
+	circled = anyOf(fromRange('A', 'Z', 'Ⓐ'), fromRange('a', 'z', 'ⓐ'), fromRange('1', '9', '①'), fromTable(map[rune]rune{'0': '⓪'}))
+
+	smallForms = fromTable(map[rune]rune{
+		',': '\ufe50', '.': '\ufe52', ';': '\ufe54', ':': '\ufe55', '?': '\ufe56', '!': '\ufe57', '(': '\ufe59', ')': '\ufe5a',
+		'{': '\ufe5b', '}': '\ufe5c', '#': '\ufe5f', '&': '\ufe60', '*': '\ufe61', '+': '\ufe62', '-': '\ufe63', '<': '\ufe64',
+		'>': '\ufe65', '=': '\ufe66', '\\': '\ufe68', '$': '\ufe69', '%': '\ufe6a', '@': '\ufe6b',
+	})
+
+	superscripts = fromTable(map[rune]rune{
+		'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+		'i': 'ⁱ', 'n': 'ⁿ', '+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾',
+	})
+
+	// Longest sequences first, the replacer takes the first match
+	ligatures = strings.NewReplacer("ffi", "ﬃ", "ffl", "ﬄ", "ff", "ﬀ", "fi", "ﬁ", "fl", "ﬂ", "st", "ﬆ")
+
+	// ../ becomes ‥/, which NFKC turns back into ../
+	dotLeaders = strings.NewReplacer("...", "\u2026", "..", "\u2025", ".", "\u2024")
+
+	// The NFD form of each is the ASCII character and a combining mark
+	precomposed = fromTable(map[rune]rune{
+		'a': 'á', 'c': 'ç', 'e': 'é', 'i': 'í', 'n': 'ñ', 'o': 'ó', 's': 'ś', 'u': 'ú', 'y': 'ý', 'z': 'ź',
+		'A': 'Á', 'C': 'Ç', 'E': 'É', 'I': 'Í', 'N': 'Ñ', 'O': 'Ó', 'S': 'Ś', 'U': 'Ú', 'Y': 'Ý', 'Z': 'Ź',
+		'<': '≮', '>': '≯', '=': '≠',
+	})
+
+	cyrillic = fromTable(map[rune]rune{
+		'a': '\u0430', 'c': '\u0441', 'e': '\u0435', 'i': '\u0456', 'j': '\u0458', 'o': '\u043e', 'p': '\u0440', 's': '\u0455', 'x': '\u0445', 'y': '\u0443',
+		'A': '\u0410', 'B': '\u0412', 'C': '\u0421', 'E': '\u0415', 'H': '\u041d', 'I': '\u0406', 'J': '\u0408', 'K': '\u041a', 'M': '\u041c', 'O': '\u041e',
+		'P': '\u0420', 'S': '\u0405', 'T': '\u0422', 'X': '\u0425', 'Y': '\u04ae',
+	})
+
+	punctuation = fromTable(map[rune]rune{
+		'/': '\u2215', '\\': '\u2216', '-': '\u2010', ':': '\u2236', '\'': '\u02bc', '"': '\u2033', '<': '\u2039', '>': '\u203a', '*': '\u2217', '|': '\u2223',
+	})
+)
@@ -0,0 +1,127 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"prokzee/internal/hostaddr"
+)
+
+// ClientCertificate is a client TLS certificate presented to the hosts
+// matching Host, a host name or a *.example.com wildcard
+type ClientCertificate struct {
+	Host        string
+	Certificate tls.Certificate
+}
+
+var (
+	clientCertificates []ClientCertificate
+	clientCertsMu      sync.RWMutex
+)
+
+// SetClientCertificates sets the client certificates used by every transport
+// created by this package. The first certificate whose host matches wins.
+func SetClientCertificates(certificates []ClientCertificate) {
+	clientCertsMu.Lock()
+	clientCertificates = certificates
+	clientCertsMu.Unlock()
+}
+
+// ClientCertificateFor returns the client certificate for host, with or
+// without a port, or nil when none is configured
+func ClientCertificateFor(host string) *tls.Certificate {
+	clientCertsMu.RLock()
+	defer clientCertsMu.RUnlock()
+
+	for i := range clientCertificates {
+		if hostMatches(strings.ToLower(strings.TrimSpace(clientCertificates[i].Host)), host) {
+			return &clientCertificates[i].Certificate
+		}
+	}
+	return nil
+}
+
+// WithClientCertificate returns a copy of config that connects to addr,
+// presenting the client certificate of its host if there is one and shaped
+// after the TLS profile in use
+func WithClientCertificate(config *tls.Config, addr string) *tls.Config {
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			config.ServerName = host
+		}
+	}
+	if certificate := ClientCertificateFor(addr); certificate != nil {
+		config.Certificates = []tls.Certificate{*certificate}
+	}
+	if profile := CurrentTLSProfile(); profile != nil {
+		profile.apply(config)
+	}
+	return config
+}
+
+// hostMatches reports whether host, with or without a port, matches a rule:
+// a host name, a *.example.com wildcard that includes example.com, or a
+// .example.com suffix
+func hostMatches(rule, host string) bool {
+	host = hostaddr.Canonical(host)
+
+	switch {
+	case rule == "":
+		return false
+	case strings.HasPrefix(rule, "*."):
+		return host == rule[2:] || strings.HasSuffix(host, rule[1:])
+	case strings.HasPrefix(rule, "."):
+		return strings.HasSuffix(host, rule)
+	default:
+		// IP literals match however they are written
+		return host == hostaddr.Canonical(rule)
+	}
+}
+
+// directForClientCertificates wraps a transport Proxy function so https
+// requests to hosts with a client certificate, or all of them under a TLS
+// profile, are not proxied by the transport. dialClientTLS tunnels them
+// through the chain itself, as the transport would neither present the
+// certificate nor apply the profile on a proxied connection.
+func directForClientCertificates(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if req.URL.Scheme == "https" && (ClientCertificateFor(req.URL.Host) != nil || CurrentTLSProfile() != nil) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
+
+// dialClientTLS returns a DialTLSContext function for transport that presents
+// the client certificate of the host it connects to
+func dialClientTLS(transport *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		profiled := CurrentTLSProfile() != nil && !CurrentChain().isProxyAddr(addr)
+		if ClientCertificateFor(addr) != nil || profiled || resolvesLocally(addr) {
+			conn, err = DialContext(ctx, network, addr)
+		} else {
+			// addr is the server itself or an https upstream proxy, which the
+			// transport chose through its Proxy function
+			conn, err = (&net.Dialer{Timeout: chainDialTimeout}).DialContext(ctx, network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, WithClientCertificate(transport.TLSClientConfig, addr))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,50 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// IsHTTP2 reports whether a protocol version, as stored with a request,
+// names HTTP/2
+func IsHTTP2(protocolVersion string) bool {
+	return protocolVersion == "HTTP/2" || protocolVersion == "HTTP/2.0"
+}
+
+// Connection pool of the transports created by NewHTTPTransport. Idle
+// connections are kept and reused by the next requests to the same host.
+const (
+	maxIdleConns        = 100
+	maxIdleConnsPerHost = 16
+	idleConnTimeout     = 90 * time.Second
+)
+
+// NewHTTPTransport returns a transport that skips certificate verification,
+// like the proxy, and goes through the upstream proxy chain. With allowHTTP2 it offers h2 through ALPN and falls back to
+// HTTP/1.1 for servers that do not accept it, otherwise it only speaks
+// HTTP/1.1. Hosts with a client certificate are sent it during the handshake,
+// and the handshake follows the TLS profile in use.
+func NewHTTPTransport(allowHTTP2 bool) *http.Transport {
+	transport := &http.Transport{
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		Proxy:               directForClientCertificates(Proxy),
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+	transport.DialContext = TransportDialContext
+	transport.DialTLSContext = dialClientTLS(transport)
+	if !allowHTTP2 {
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+		return transport
+	}
+	if err := http2.ConfigureTransport(transport); err != nil {
+		log.Printf("Failed to enable HTTP/2 upstream, using HTTP/1.1: %v", err)
+	}
+	return transport
+}
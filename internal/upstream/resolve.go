@@ -0,0 +1,196 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// resolveTimeout bounds a lookup against the custom DNS server
+const resolveTimeout = 5 * time.Second
+
+// HostOverride maps a host name, or a *.example.com wildcard, to an IP
+// address, like an entry of the system hosts file
+type HostOverride struct {
+	Host string
+	IP   string
+}
+
+// Resolver resolves the hosts the transports of this package connect to with
+// the host overrides first and the custom DNS server next, falling back to
+// the system resolver
+type Resolver struct {
+	overrides []HostOverride
+	dnsServer string
+	resolver  *net.Resolver
+}
+
+var (
+	currentResolver *Resolver
+	resolverMu      sync.RWMutex
+)
+
+// SetResolver sets the resolver used by every transport created by this
+// package, nil for the system resolver
+func SetResolver(resolver *Resolver) {
+	resolverMu.Lock()
+	currentResolver = resolver
+	resolverMu.Unlock()
+}
+
+// CurrentResolver returns the resolver in use, or nil
+func CurrentResolver() *Resolver {
+	resolverMu.RLock()
+	defer resolverMu.RUnlock()
+	return currentResolver
+}
+
+// ParseResolver parses host overrides, one "host ip" or "ip host" pair per
+// line in the hosts file format with # comments, and the address of a DNS
+// server, port 53 when none is given. Empty overrides and server return a nil
+// resolver.
+func ParseResolver(hosts, dnsServer string) (*Resolver, error) {
+	resolver := &Resolver{}
+	for number, line := range strings.Split(hosts, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("host override on line %d needs a host and an IP address", number+1)
+		}
+		// Both "host ip" and the hosts file "ip host [alias...]" orders are accepted
+		ip, names := fields[1], fields[:1]
+		if net.ParseIP(fields[0]) != nil {
+			ip, names = fields[0], fields[1:]
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("host override on line %d has no valid IP address", number+1)
+		}
+		for _, name := range names {
+			resolver.overrides = append(resolver.overrides, HostOverride{Host: strings.ToLower(name), IP: ip})
+		}
+	}
+
+	dnsServer = strings.TrimSpace(dnsServer)
+	if dnsServer != "" {
+		if _, _, err := net.SplitHostPort(dnsServer); err != nil {
+			dnsServer = net.JoinHostPort(strings.Trim(dnsServer, "[]"), "53")
+		}
+		if host, _, _ := net.SplitHostPort(dnsServer); net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("custom DNS server %q must be an IP address", dnsServer)
+		}
+		resolver.dnsServer = dnsServer
+		resolver.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: resolveTimeout}).DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+
+	if len(resolver.overrides) == 0 && resolver.dnsServer == "" {
+		return nil, nil
+	}
+	return resolver, nil
+}
+
+// Overrides returns the host overrides of the resolver
+func (r *Resolver) Overrides() []HostOverride {
+	if r == nil {
+		return nil
+	}
+	return append([]HostOverride{}, r.overrides...)
+}
+
+// Handles reports whether the resolver, rather than the system or an upstream
+// proxy, resolves host, with or without a port
+func (r *Resolver) Handles(host string) bool {
+	if r == nil {
+		return false
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return false
+	}
+	return r.dnsServer != "" || r.override(host) != ""
+}
+
+// override returns the IP address a host is mapped to, or ""
+func (r *Resolver) override(host string) string {
+	for _, override := range r.overrides {
+		if hostMatches(override.Host, host) {
+			return override.IP
+		}
+	}
+	return ""
+}
+
+// ResolveAddr replaces the host of a host:port address with the IP address
+// the resolver gives it. Addresses the resolver does not handle are returned
+// unchanged.
+func (r *Resolver) ResolveAddr(ctx context.Context, addr string) string {
+	if !r.Handles(addr) {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if ip := r.override(host); ip != "" {
+		return net.JoinHostPort(ip, port)
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	ips, err := r.resolver.LookupIPAddr(lookupCtx, host)
+	if err != nil || len(ips) == 0 {
+		// Public hosts may be missing from a private zone
+		log.Printf("Custom DNS server %s failed to resolve %s, using the system resolver: %v", r.dnsServer, host, err)
+		return addr
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port)
+}
+
+// resolvesLocally reports whether the current resolver handles host, so
+// connections to it are dialed by this package instead of through the Proxy
+// function of a transport, which would leave the lookup to the upstream proxy
+func resolvesLocally(host string) bool {
+	return CurrentResolver().Handles(host)
+}
+
+// dialDirect connects to addr without the upstream proxy chain, resolving its
+// host with the current resolver
+func dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	return (&net.Dialer{Timeout: chainDialTimeout}).DialContext(ctx, network, CurrentResolver().ResolveAddr(ctx, addr))
+}
+
+// TransportDialContext is a DialContext function for transports whose Proxy
+// function is Proxy or ProxyOrEnvironment. Hosts the resolver handles are not
+// proxied by the transport and are dialed through the chain here; everything
+// else, the server or the upstream proxy the transport chose, goes direct.
+func TransportDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if resolvesLocally(addr) {
+		return DialContext(ctx, network, addr)
+	}
+	return dialDirect(ctx, network, addr)
+}
+
+// dialQUIC opens the QUIC connection of an HTTP/3 request, resolving its host
+// with the current resolver. The TLS configuration already names the host.
+func dialQUIC(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	return quic.DialAddrEarly(ctx, CurrentResolver().ResolveAddr(ctx, addr), tlsConfig, config)
+}
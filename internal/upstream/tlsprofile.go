@@ -0,0 +1,538 @@
+package upstream
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSProfile shapes the ClientHello of upstream connections, changing their
+// TLS fingerprint. crypto/tls decides the order of the cipher suites and of
+// the extensions itself, so a profile only controls which TLS 1.2 suites,
+// groups, versions and ALPN protocols are offered, and the order of the
+// groups and the ALPN protocols. It cannot reproduce the fingerprint of a
+// browser or of another client.
+type TLSProfile struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description,omitempty"`
+	MinVersion     string   `json:"minVersion,omitempty"`   // "1.0" to "1.3"
+	MaxVersion     string   `json:"maxVersion,omitempty"`   // "1.0" to "1.3"
+	CipherSuites   []string `json:"cipherSuites,omitempty"` // TLS 1.2 suites by their IANA name
+	Curves         []string `json:"curves,omitempty"`       // X25519, P-256, P-384, P-521 or a numeric group ID
+	ALPN           []string `json:"alpn,omitempty"`         // offered on connections that may use HTTP/2
+	SessionTickets bool     `json:"sessionTickets"`
+}
+
+// compatibleSuites are ECDHE and RSA suites, AES-GCM first, with CBC fallbacks
+var compatibleSuites = []string{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	"TLS_RSA_WITH_AES_128_GCM_SHA256",
+	"TLS_RSA_WITH_AES_256_GCM_SHA384",
+	"TLS_RSA_WITH_AES_128_CBC_SHA",
+	"TLS_RSA_WITH_AES_256_CBC_SHA",
+}
+
+// TLSPresets are the built-in profiles, named after what they offer. "go"
+// leaves the ClientHello of crypto/tls untouched.
+var TLSPresets = []TLSProfile{
+	{Name: "go", Description: "Go defaults", SessionTickets: true},
+	{
+		Name: "compatible", Description: "TLS 1.2 and 1.3, ECDHE and RSA suites with AES-GCM first and CBC fallbacks",
+		MinVersion: "1.2", MaxVersion: "1.3",
+		CipherSuites:   compatibleSuites,
+		Curves:         []string{"X25519", "P-256", "P-384"},
+		ALPN:           []string{"h2", "http/1.1"},
+		SessionTickets: true,
+	},
+	{
+		Name: "wide", Description: "TLS 1.2 and 1.3, ECDHE and RSA suites with ChaCha20 early, CBC fallbacks and P-521",
+		MinVersion: "1.2", MaxVersion: "1.3",
+		CipherSuites: []string{
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+			"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+			"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+			"TLS_RSA_WITH_AES_128_GCM_SHA256",
+			"TLS_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_RSA_WITH_AES_128_CBC_SHA",
+			"TLS_RSA_WITH_AES_256_CBC_SHA",
+		},
+		Curves:         []string{"X25519", "P-256", "P-384", "P-521"},
+		ALPN:           []string{"h2", "http/1.1"},
+		SessionTickets: true,
+	},
+	{
+		Name: "legacy", Description: "TLS 1.2 and 1.3, AES-256 first, 3DES fallbacks and no session tickets",
+		MinVersion: "1.2", MaxVersion: "1.3",
+		CipherSuites: []string{
+			"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+			"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+			"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+			"TLS_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_RSA_WITH_AES_128_GCM_SHA256",
+			"TLS_RSA_WITH_AES_256_CBC_SHA",
+			"TLS_RSA_WITH_AES_128_CBC_SHA",
+			"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+			"TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+		},
+		Curves:         []string{"X25519", "P-256", "P-384", "P-521"},
+		ALPN:           []string{"h2", "http/1.1"},
+		SessionTickets: false,
+	},
+	{
+		Name: "aead-only", Description: "TLS 1.2 and 1.3, only ECDHE suites with AES-GCM or ChaCha20",
+		MinVersion: "1.2", MaxVersion: "1.3",
+		CipherSuites: []string{
+			"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		},
+		Curves:         []string{"X25519", "P-256", "P-521", "P-384"},
+		ALPN:           []string{"h2", "http/1.1"},
+		SessionTickets: true,
+	},
+}
+
+var (
+	currentTLSProfile *TLSProfile
+	tlsProfileMu      sync.RWMutex
+)
+
+// SetTLSProfile sets the profile of the TLS connections made by every
+// transport of this package, nil for the Go defaults
+func SetTLSProfile(profile *TLSProfile) {
+	tlsProfileMu.Lock()
+	currentTLSProfile = profile
+	tlsProfileMu.Unlock()
+}
+
+// CurrentTLSProfile returns the profile in use, or nil
+func CurrentTLSProfile() *TLSProfile {
+	tlsProfileMu.RLock()
+	defer tlsProfileMu.RUnlock()
+	return currentTLSProfile
+}
+
+// ParseTLSProfile parses the TLS profile setting: empty or "go" for the Go
+// defaults, the name of a preset, or a profile as JSON. A nil profile is
+// returned for the Go defaults.
+func ParseTLSProfile(raw string) (*TLSProfile, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.EqualFold(raw, "go") {
+		return nil, nil
+	}
+
+	var profile TLSProfile
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &profile); err != nil {
+			return nil, fmt.Errorf("invalid TLS profile: %v", err)
+		}
+		if profile.Name == "" {
+			profile.Name = "custom"
+		}
+	} else {
+		found := false
+		for _, preset := range TLSPresets {
+			if strings.EqualFold(preset.Name, raw) {
+				profile, found = preset, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown TLS profile %q", raw)
+		}
+	}
+
+	// Reject what apply would otherwise silently skip
+	if _, err := parseTLSVersion(profile.MinVersion); err != nil {
+		return nil, err
+	}
+	if _, err := parseTLSVersion(profile.MaxVersion); err != nil {
+		return nil, err
+	}
+	if _, err := cipherSuiteIDs(profile.CipherSuites); err != nil {
+		return nil, err
+	}
+	if _, err := curveIDs(profile.Curves); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// apply shapes config after the profile. The ALPN protocols of the profile
+// are only offered by transports that already offer h2, so the HTTP/1.1
+// only transports keep speaking HTTP/1.1.
+func (p *TLSProfile) apply(config *tls.Config) {
+	if version, _ := parseTLSVersion(p.MinVersion); version != 0 {
+		config.MinVersion = version
+	}
+	if version, _ := parseTLSVersion(p.MaxVersion); version != 0 {
+		config.MaxVersion = version
+	}
+	if suites, _ := cipherSuiteIDs(p.CipherSuites); len(suites) > 0 {
+		config.CipherSuites = suites
+	}
+	if curves, _ := curveIDs(p.Curves); len(curves) > 0 {
+		config.CurvePreferences = curves
+	}
+	if len(p.ALPN) > 0 && containsString(config.NextProtos, "h2") {
+		config.NextProtos = append([]string{}, p.ALPN...)
+	}
+	config.SessionTicketsDisabled = !p.SessionTickets
+}
+
+// parseTLSVersion parses "1.0" to "1.3", 0 for an empty version
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "tls") {
+	case "":
+		return 0, nil
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", version)
+}
+
+// cipherSuiteIDs returns the IDs of cipher suites named as by IANA
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// curveIDs returns the IDs of groups given by name or number
+func curveIDs(names []string) ([]tls.CurveID, error) {
+	var ids []tls.CurveID
+	for _, name := range names {
+		switch strings.ToUpper(strings.TrimSpace(name)) {
+		case "X25519":
+			ids = append(ids, tls.X25519)
+		case "P-256", "P256", "SECP256R1":
+			ids = append(ids, tls.CurveP256)
+		case "P-384", "P384", "SECP384R1":
+			ids = append(ids, tls.CurveP384)
+		case "P-521", "P521", "SECP521R1":
+			ids = append(ids, tls.CurveP521)
+		default:
+			id, err := strconv.ParseUint(strings.TrimSpace(name), 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("unknown curve %q", name)
+			}
+			ids = append(ids, tls.CurveID(id))
+		}
+	}
+	return ids, nil
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSFingerprint is the fingerprint of the ClientHello a profile produces
+type TLSFingerprint struct {
+	JA3     string `json:"ja3"`
+	JA3Hash string `json:"ja3Hash"`
+	JA4     string `json:"ja4"`
+}
+
+// Fingerprint returns the JA3 and JA4 fingerprints of the ClientHello sent
+// to an HTTP/2 capable server with profile, nil for the Go defaults. Go
+// orders the cipher suites after the hardware, so the fingerprint is the one
+// of this machine.
+func Fingerprint(profile *TLSProfile) (*TLSFingerprint, error) {
+	config := &tls.Config{InsecureSkipVerify: true, ServerName: "example.com", NextProtos: []string{"h2", "http/1.1"}}
+	if profile != nil {
+		profile.apply(config)
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		tls.Client(client, config).Handshake()
+	}()
+
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		return nil, fmt.Errorf("failed to read the ClientHello: %v", err)
+	}
+	if header[0] != 22 {
+		return nil, fmt.Errorf("unexpected TLS record type %d", header[0])
+	}
+	record := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+	if _, err := io.ReadFull(server, record); err != nil {
+		return nil, fmt.Errorf("failed to read the ClientHello: %v", err)
+	}
+
+	hello, err := parseClientHello(record)
+	if err != nil {
+		return nil, err
+	}
+	return hello.fingerprint(), nil
+}
+
+// clientHello holds the ClientHello fields the fingerprints are made of
+type clientHello struct {
+	version           uint16
+	ciphers           []uint16
+	extensions        []uint16
+	curves            []uint16
+	pointFormats      []uint8
+	signatureAlgs     []uint16
+	alpn              []string
+	sni               bool
+	supportedVersions []uint16
+}
+
+// parseClientHello parses the ClientHello handshake message of a record
+func parseClientHello(data []byte) (*clientHello, error) {
+	r := &byteReader{data: data}
+	if r.u8() != 1 {
+		return nil, fmt.Errorf("not a ClientHello")
+	}
+	r.skip(3) // message length
+	hello := &clientHello{version: r.u16()}
+	r.skip(32)          // random
+	r.skip(int(r.u8())) // session ID
+	ciphers := r.sub(int(r.u16()))
+	for !ciphers.done() {
+		hello.ciphers = append(hello.ciphers, ciphers.u16())
+	}
+	r.skip(int(r.u8())) // compression methods
+
+	extensions := r.sub(int(r.u16()))
+	for !extensions.done() {
+		kind := extensions.u16()
+		body := extensions.sub(int(extensions.u16()))
+		hello.extensions = append(hello.extensions, kind)
+		switch kind {
+		case 0:
+			hello.sni = true
+		case 10:
+			list := body.sub(int(body.u16()))
+			for !list.done() {
+				hello.curves = append(hello.curves, list.u16())
+			}
+		case 11:
+			list := body.sub(int(body.u8()))
+			for !list.done() {
+				hello.pointFormats = append(hello.pointFormats, list.u8())
+			}
+		case 13:
+			list := body.sub(int(body.u16()))
+			for !list.done() {
+				hello.signatureAlgs = append(hello.signatureAlgs, list.u16())
+			}
+		case 16:
+			list := body.sub(int(body.u16()))
+			for !list.done() {
+				hello.alpn = append(hello.alpn, string(list.bytes(int(list.u8()))))
+			}
+		case 43:
+			list := body.sub(int(body.u8()))
+			for !list.done() {
+				hello.supportedVersions = append(hello.supportedVersions, list.u16())
+			}
+		}
+	}
+	if r.err || extensions.err {
+		return nil, fmt.Errorf("malformed ClientHello")
+	}
+	return hello, nil
+}
+
+// fingerprint computes the JA3 and JA4 fingerprints, GREASE values left out
+func (h *clientHello) fingerprint() *TLSFingerprint {
+	ciphers := withoutGrease(h.ciphers)
+	extensions := withoutGrease(h.extensions)
+	curves := withoutGrease(h.curves)
+	points := make([]uint16, len(h.pointFormats))
+	for i, format := range h.pointFormats {
+		points[i] = uint16(format)
+	}
+
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		joinDecimal(ciphers), joinDecimal(extensions), joinDecimal(curves), joinDecimal(points),
+	}, ",")
+	ja3Sum := md5.Sum([]byte(ja3))
+
+	version := h.version
+	for _, v := range withoutGrease(h.supportedVersions) {
+		if v > version {
+			version = v
+		}
+	}
+	versions := map[uint16]string{tls.VersionTLS10: "10", tls.VersionTLS11: "11", tls.VersionTLS12: "12", tls.VersionTLS13: "13"}
+	ja4Version, ok := versions[version]
+	if !ok {
+		ja4Version = "00"
+	}
+	destination := "i"
+	if h.sni {
+		destination = "d"
+	}
+	alpn := "00"
+	if len(h.alpn) > 0 && h.alpn[0] != "" {
+		first := h.alpn[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+
+	sortedCiphers := append([]uint16{}, ciphers...)
+	sort.Slice(sortedCiphers, func(i, j int) bool { return sortedCiphers[i] < sortedCiphers[j] })
+	var sortedExtensions []uint16
+	for _, extension := range extensions {
+		if extension != 0 && extension != 16 {
+			sortedExtensions = append(sortedExtensions, extension)
+		}
+	}
+	sort.Slice(sortedExtensions, func(i, j int) bool { return sortedExtensions[i] < sortedExtensions[j] })
+	extensionPart := joinHex(sortedExtensions)
+	if len(h.signatureAlgs) > 0 {
+		extensionPart += "_" + joinHex(h.signatureAlgs)
+	}
+
+	ja4 := fmt.Sprintf("t%s%s%02d%02d%s_%s_%s", ja4Version, destination, min(len(ciphers), 99), min(len(extensions), 99), alpn,
+		truncatedSHA256(joinHex(sortedCiphers)), truncatedSHA256(extensionPart))
+
+	return &TLSFingerprint{JA3: ja3, JA3Hash: hex.EncodeToString(ja3Sum[:]), JA4: ja4}
+}
+
+// withoutGrease drops the GREASE values of RFC 8701
+func withoutGrease(values []uint16) []uint16 {
+	var out []uint16
+	for _, value := range values {
+		if value&0x0f0f != 0x0a0a || value>>8 != value&0xff {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+// joinDecimal joins values as JA3 does, with dashes
+func joinDecimal(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(int(value))
+	}
+	return strings.Join(parts, "-")
+}
+
+// joinHex joins values as JA4 does, four hex digits separated by commas
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%04x", value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// truncatedSHA256 returns the first 12 hex digits of the SHA-256 of value,
+// all zeros for an empty value
+func truncatedSHA256(value string) string {
+	if value == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// byteReader reads the big endian fields of a handshake message, recording
+// rather than panicking on a short message
+type byteReader struct {
+	data []byte
+	err  bool
+}
+
+func (r *byteReader) done() bool {
+	return r.err || len(r.data) == 0
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.err || n > len(r.data) {
+		r.err = true
+		return nil
+	}
+	out := r.data[:n]
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *byteReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *byteReader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *byteReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *byteReader) sub(n int) *byteReader {
+	b := r.bytes(n)
+	return &byteReader{data: b, err: r.err}
+}
